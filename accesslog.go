@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/server"
+)
+
+// accessLog logs a line per request once it's served, with the tasks of
+// PHP requests summed up, while enabled returns true.
+func accessLog(logger *slog.Logger, enabled func() bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		var tasks server.TaskSummary
+		next.ServeHTTP(rec, r.WithContext(server.WithTaskSummary(r.Context(), &tasks)))
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"bytes", rec.bytes,
+		}
+		if tasks.Tasks > 0 {
+			attrs = append(attrs, slog.Group("tasks",
+				"count", tasks.Tasks,
+				"failed", tasks.Failed,
+				"slowest", tasks.Slowest,
+				"slowest_duration", tasks.SlowestDuration,
+			))
+		}
+		logger.Info("Request", attrs...)
+	})
+}
+
+// accessRecorder records the status and size of a response.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+	wrote  bool
+}
+
+func (w *accessRecorder) WriteHeader(code int) {
+	if !w.wrote {
+		w.status = code
+		w.wrote = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessRecorder) Write(b []byte) (int, error) {
+	w.wrote = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *accessRecorder) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *accessRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,96 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// WithAuthAll requires authorization on every route, including the ones
+// that only read state, rather than on those changing it alone. Handler
+// does so anyway once a token or client certificates are configured.
+func WithAuthAll() Option {
+	return func(c *config) {
+		c.authAll = true
+	}
+}
+
+// WithClientCerts accepts requests over TLS with a verified client
+// certificate in place of the bearer token. The listener decides which
+// certificates it verifies, see tls.Config.ClientCAs.
+func WithClientCerts() Option {
+	return func(c *config) {
+		c.clientCerts = true
+	}
+}
+
+// WithCORS lets pages of origins, or of any origin given "*", call the
+// admin API from the browser.
+func WithCORS(origins ...string) Option {
+	return func(c *config) {
+		c.corsOrigins = origins
+	}
+}
+
+// wrap adds authorization of every route with WithAuthAll, and CORS with
+// WithCORS, to a handler.
+func (c *config) wrap(h http.Handler) http.Handler {
+	if c.authAll {
+		h = c.authorize(h.ServeHTTP)
+	}
+	if len(c.corsOrigins) > 0 {
+		h = c.cors(h)
+	}
+	return h
+}
+
+// authorize requires "Authorization: Bearer <token>", or a verified client
+// certificate with WithClientCerts. Without either configured it refuses
+// every request.
+func (c *config) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			next(w, r)
+			return
+		}
+		if c.token == "" {
+			writeError(w, http.StatusForbidden, "admin token not configured")
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(c.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// cors adds the CORS headers for allowed origins to responses, and answers
+// preflight requests before they reach authorization.
+func (c *config) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(slices.Contains(c.corsOrigins, origin) || slices.Contains(c.corsOrigins, "*")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+		header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		header.Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,106 @@
+package admin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test requiring the token on every route with WithAuthAll, or once a token
+// is configured
+func TestHandler_AuthAll(t *testing.T) {
+	reg := NewRegistry()
+
+	var stats Stats
+	assertEqual(t, get(t, Handler(reg), Prefix+"/stats", &stats), http.StatusOK)
+
+	var body map[string]string
+	assertEqual(t, get(t, Handler(reg, WithAuthAll()), Prefix+"/stats", &body), http.StatusForbidden)
+
+	for _, h := range []http.Handler{
+		Handler(reg, WithToken("secret")),
+		Handler(reg, WithToken("secret"), WithAuthAll()),
+	} {
+		for _, path := range []string{"/stats", "/tasks", "/tasks/d0q4kg7n9ccvr4mpkmvg", "/unknown"} {
+			assertEqual(t, get(t, h, Prefix+path, &body), http.StatusUnauthorized)
+		}
+		assertEqual(t, do(t, h, http.MethodGet, Prefix+"/stats", "wrong", &body), http.StatusUnauthorized)
+		assertEqual(t, do(t, h, http.MethodGet, Prefix+"/stats", "secret", &stats), http.StatusOK)
+
+		// The dashboard page itself holds no state
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Prefix+"/ui", nil))
+		assertEqual(t, rec.Code, http.StatusOK)
+	}
+}
+
+// Test verified client certificates standing in for the token
+func TestHandler_ClientCerts(t *testing.T) {
+	reg := NewRegistry()
+
+	request := func(verified bool) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, Prefix+"/stats", nil)
+		req.TLS = &tls.ConnectionState{}
+		if verified {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{{}}}
+		}
+		return req
+	}
+
+	h := Handler(reg, WithClientCerts())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, request(true))
+	assertEqual(t, rec.Code, http.StatusOK)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, request(false))
+	assertEqual(t, rec.Code, http.StatusForbidden)
+
+	// Without WithClientCerts certificates don't count
+	rec = httptest.NewRecorder()
+	Handler(reg, WithToken("secret"), WithAuthAll()).ServeHTTP(rec, request(true))
+	assertEqual(t, rec.Code, http.StatusUnauthorized)
+}
+
+// Test CORS headers for allowed origins and preflight requests
+func TestHandler_CORS(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg, WithToken("secret"), WithAuthAll(), WithCORS("https://ops.example.com"))
+
+	// Preflights are answered without the token
+	req := httptest.NewRequest(http.MethodOptions, Prefix+"/stats", nil)
+	req.Header.Set("Origin", "https://ops.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assertEqual(t, rec.Code, http.StatusNoContent)
+	assertEqual(t, rec.Header().Get("Access-Control-Allow-Origin"), "https://ops.example.com")
+	assertEqual(t, rec.Header().Get("Access-Control-Allow-Headers"), "Authorization, Content-Type")
+
+	req = httptest.NewRequest(http.MethodGet, Prefix+"/stats", nil)
+	req.Header.Set("Origin", "https://ops.example.com")
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assertEqual(t, rec.Code, http.StatusOK)
+	assertEqual(t, rec.Header().Get("Access-Control-Allow-Origin"), "https://ops.example.com")
+
+	// Other origins get no CORS headers, and preflights fall through
+	req = httptest.NewRequest(http.MethodOptions, Prefix+"/stats", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assertEqual(t, rec.Code, http.StatusUnauthorized)
+	assertEqual(t, rec.Header().Get("Access-Control-Allow-Origin"), "")
+
+	// "*" allows any origin
+	req = httptest.NewRequest(http.MethodGet, Prefix+"/stats", nil)
+	req.Header.Set("Origin", "https://any.example.com")
+	rec = httptest.NewRecorder()
+	Handler(reg, WithCORS("*")).ServeHTTP(rec, req)
+	assertEqual(t, rec.Header().Get("Access-Control-Allow-Origin"), "https://any.example.com")
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client calls the admin API of a running server.
+type Client struct {
+	BaseURL string // e.g. http://127.0.0.1:8082
+	Token   string // bearer token for routes that change task state
+	HTTP    *http.Client
+}
+
+// NewClient returns a Client for the admin API at baseURL.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   token,
+		HTTP:    http.DefaultClient,
+	}
+}
+
+// Tasks lists the tasks of in-flight requests, optionally filtered by
+// status. A zero limit uses the server default.
+func (c *Client) Tasks(ctx context.Context, statuses []string, limit, offset int) (TaskList, error) {
+	query := url.Values{}
+	if len(statuses) > 0 {
+		query.Set("status", strings.Join(statuses, ","))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+
+	var list TaskList
+	err := c.do(ctx, http.MethodGet, "/tasks?"+query.Encode(), &list)
+	return list, err
+}
+
+// Task returns a single task.
+func (c *Client) Task(ctx context.Context, id string) (Task, error) {
+	var task Task
+	err := c.do(ctx, http.MethodGet, "/tasks/"+url.PathEscape(id), &task)
+	return task, err
+}
+
+// Cancel cancels a task and returns its new state.
+func (c *Client) Cancel(ctx context.Context, id string) (Task, error) {
+	var task Task
+	err := c.do(ctx, http.MethodDelete, "/tasks/"+url.PathEscape(id), &task)
+	return task, err
+}
+
+// do sends a request to path below Prefix and decodes the JSON response
+// into v, or returns the error message of a failed request.
+func (c *Client) do(ctx context.Context, method, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+Prefix+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+			return fmt.Errorf("admin API: %s", resp.Status)
+		}
+		return fmt.Errorf("admin API: %s (%d)", body.Error, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Test the client against the admin API
+func TestClient(t *testing.T) {
+	reg := NewRegistry()
+	srv := httptest.NewServer(Handler(reg, WithToken("secret")))
+	t.Cleanup(srv.Close)
+
+	ctx := context.Background()
+	tm := newManager(t, reg, "/index.php")
+	started := make(chan struct{})
+	id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, nil
+	}))
+	<-started
+
+	client := NewClient(srv.URL+"/", "secret")
+
+	list, err := client.Tasks(ctx, []string{"running"}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, list.Total, 1)
+	assertEqual(t, list.Limit, 10)
+	assertEqual(t, list.Tasks[0].ID, id.String())
+
+	task, err := client.Cancel(ctx, id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, task.Status, "canceled")
+
+	task, err = client.Task(ctx, id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, task.Status, "canceled")
+
+	// API errors carry the server's message
+	_, err = NewClient(srv.URL, "wrong").Cancel(ctx, id.String())
+	if err == nil || !strings.Contains(err.Error(), "invalid token (401)") {
+		t.Fatalf("expected invalid token error, got %v", err)
+	}
+	_, err = client.Task(ctx, "nope")
+	if err == nil || !strings.Contains(err.Error(), "invalid task ID (400)") {
+		t.Fatalf("expected invalid task ID error, got %v", err)
+	}
+}
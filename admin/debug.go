@@ -0,0 +1,171 @@
+package admin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+type (
+	// Goroutine is a group of goroutines sharing a stack.
+	Goroutine struct {
+		Count int      `json:"count"`
+		Stack []string `json:"stack"`
+	}
+
+	// TaskGoroutines is a task with the goroutines running for it.
+	TaskGoroutines struct {
+		Task
+		Goroutines []Goroutine `json:"goroutines"`
+	}
+
+	// GoroutineDump correlates the goroutines of the process with tasks.
+	GoroutineDump struct {
+		Total     int              `json:"total"`
+		Tasks     []TaskGoroutines `json:"tasks"`
+		Unrelated int              `json:"unrelated"` // goroutines not running for a task
+	}
+)
+
+// WithDebug mounts net/http/pprof under /_frankenasync/debug/pprof/ and a
+// goroutine dump grouped by task under /_frankenasync/debug/tasks.
+func WithDebug() Option {
+	return func(c *config) {
+		c.debug = true
+	}
+}
+
+// mountDebug mounts the debug routes, which expose the command line, memory
+// and stacks of the process, behind the bearer token whatever the other
+// routes need.
+func mountDebug(mux *http.ServeMux, reg *Registry, cfg *config) {
+	// The pprof index resolves profile names relative to /debug/pprof/
+	index := http.StripPrefix(Prefix, http.HandlerFunc(pprof.Index))
+	mux.HandleFunc("GET "+Prefix+"/debug/pprof/", cfg.authorize(index.ServeHTTP))
+	mux.HandleFunc("GET "+Prefix+"/debug/pprof/cmdline", cfg.authorize(pprof.Cmdline))
+	mux.HandleFunc("GET "+Prefix+"/debug/pprof/profile", cfg.authorize(pprof.Profile))
+	mux.HandleFunc("GET "+Prefix+"/debug/pprof/symbol", cfg.authorize(pprof.Symbol))
+	mux.HandleFunc("POST "+Prefix+"/debug/pprof/symbol", cfg.authorize(pprof.Symbol))
+	mux.HandleFunc("GET "+Prefix+"/debug/pprof/trace", cfg.authorize(pprof.Trace))
+
+	mux.HandleFunc("GET "+Prefix+"/debug/tasks", cfg.authorize(func(w http.ResponseWriter, r *http.Request) {
+		dump, err := dumpGoroutines(reg)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, dump)
+	}))
+}
+
+// dumpGoroutines reads the goroutine profile and attributes each goroutine
+// to the task in its asynctask.ProfileLabel, listing unfinished tasks
+// without goroutines too, since those are typically the stuck ones.
+func dumpGoroutines(reg *Registry) (GoroutineDump, error) {
+	var buf bytes.Buffer
+	if err := runtimepprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return GoroutineDump{}, err
+	}
+
+	byTask := make(map[string][]Goroutine)
+	var dump GoroutineDump
+
+	for _, g := range parseGoroutines(buf.Bytes()) {
+		dump.Total += g.count
+		if id := g.labels[asynctask.ProfileLabel]; id != "" {
+			byTask[id] = append(byTask[id], Goroutine{Count: g.count, Stack: g.stack})
+		} else {
+			dump.Unrelated += g.count
+		}
+	}
+
+	dump.Tasks = []TaskGoroutines{}
+	for manager, req := range reg.snapshot() {
+		for _, future := range manager.List() {
+			task := newTask(future, req)
+			goroutines := byTask[task.ID]
+			if goroutines == nil && finishedStatus(future.Status) {
+				continue
+			}
+			dump.Tasks = append(dump.Tasks, TaskGoroutines{Task: task, Goroutines: goroutines})
+		}
+	}
+
+	return dump, nil
+}
+
+type goroutineGroup struct {
+	count  int
+	labels map[string]string
+	stack  []string
+}
+
+// parseGoroutines parses a debug=1 goroutine profile, which lists groups as
+//
+//	2 @ 0x43b8d6 0x40a8e5
+//	# labels: {"task_id":"cq7..."}
+//	#	0x4711a3	main.work+0x43	/src/main.go:12
+func parseGoroutines(profile []byte) []goroutineGroup {
+	var groups []goroutineGroup
+	var current *goroutineGroup
+
+	scanner := bufio.NewScanner(bytes.NewReader(profile))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			current = nil
+
+		case strings.HasPrefix(line, "# labels: "):
+			if current != nil {
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "# labels: ")), &current.labels)
+			}
+
+		case strings.HasPrefix(line, "#\t"):
+			if current != nil {
+				// "#\t<pc>\t<func>+<off>\t<file>:<line>", the columns
+				// aligned with as many tabs as the longest function takes
+				fields := slices.DeleteFunc(strings.Split(strings.TrimPrefix(line, "#\t"), "\t"), func(field string) bool {
+					return field == ""
+				})
+				if len(fields) == 3 {
+					fn, _, _ := strings.Cut(fields[1], "+")
+					current.stack = append(current.stack, fn+" "+strings.TrimSpace(fields[2]))
+				}
+			}
+
+		default:
+			countStr, _, ok := strings.Cut(line, " @ ")
+			if !ok {
+				continue
+			}
+			count, err := strconv.Atoi(countStr)
+			if err != nil {
+				continue
+			}
+			groups = append(groups, goroutineGroup{count: count})
+			current = &groups[len(groups)-1]
+		}
+	}
+
+	return groups
+}
+
+func finishedStatus(status string) bool {
+	switch status {
+	case asynctask.StatusCompleted.String(), asynctask.StatusFailed.String(), asynctask.StatusCanceled.String():
+		return true
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Test goroutines are attributed to the task they run for
+func TestHandler_DebugTasks(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg, WithToken("secret"), WithDebug())
+
+	tm := newManager(t, reg, "/index.php")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	id := tm.Async(context.Background(), asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}))
+	<-started
+
+	var dump GoroutineDump
+	assertEqual(t, do(t, h, http.MethodGet, Prefix+"/debug/tasks", "secret", &dump), http.StatusOK)
+	assertEqual(t, len(dump.Tasks), 1)
+	assertEqual(t, dump.Tasks[0].ID, id.String())
+	assertEqual(t, len(dump.Tasks[0].Goroutines), 1)
+
+	stack := strings.Join(dump.Tasks[0].Goroutines[0].Stack, "\n")
+	if !strings.Contains(stack, "TestHandler_DebugTasks") {
+		t.Fatalf("expected the task's stack, got:\n%s", stack)
+	}
+	if dump.Unrelated == 0 || dump.Total <= dump.Unrelated {
+		t.Fatalf("unexpected totals %d/%d", dump.Total, dump.Unrelated)
+	}
+}
+
+// Test pprof is only mounted with WithDebug, and always behind the token
+func TestHandler_Pprof(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, Prefix+"/debug/pprof/goroutine?debug=1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	Handler(NewRegistry(), WithToken("secret"), WithDebug()).ServeHTTP(rec, req)
+	assertEqual(t, rec.Code, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), "goroutine profile") {
+		t.Fatalf("unexpected profile: %.100s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	Handler(NewRegistry()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Prefix+"/debug/pprof/", nil))
+	assertEqual(t, rec.Code, http.StatusNotFound)
+
+	// Without a token the debug routes are refused, even on the metrics
+	// listener, whose other routes are open
+	for _, h := range []http.Handler{Handler(NewRegistry(), WithDebug()), MetricsHandler(NewRegistry(), WithDebug())} {
+		for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/tasks"} {
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Prefix+path, nil))
+			assertEqual(t, rec.Code, http.StatusForbidden)
+		}
+	}
+}
+
+// Test parsing stack frames whose columns are aligned with several tabs
+func TestParseGoroutines(t *testing.T) {
+	profile := "goroutine profile: total 3\n" +
+		"2 @ 0x43b8d6 0x40a8e5\n" +
+		"# labels: {\"task_id\":\"d0q4kg7n9ccvr4mpkmvg\"}\n" +
+		"#\t0x4711a3\tmain.work+0x43\t\t\t\t/src/main.go:12\n" +
+		"#\t0x4712b4\tmain.(*server).serveRequests+0x1f4\t/src/server.go:80\n" +
+		"\n" +
+		"1 @ 0x43b8d6\n" +
+		"#\t0x4713c5\truntime.gopark+0xce\t\t/usr/local/go/src/runtime/proc.go:435\n"
+
+	groups := parseGoroutines([]byte(profile))
+	assertEqual(t, len(groups), 2)
+	assertEqual(t, groups[0].count, 2)
+	assertEqual(t, groups[0].labels["task_id"], "d0q4kg7n9ccvr4mpkmvg")
+	assertEqual(t, len(groups[0].stack), 2)
+	assertEqual(t, groups[0].stack[0], "main.work /src/main.go:12")
+	assertEqual(t, groups[0].stack[1], "main.(*server).serveRequests /src/server.go:80")
+	assertEqual(t, groups[1].count, 1)
+	assertEqual(t, len(groups[1].stack), 1)
+	assertEqual(t, groups[1].stack[0], "runtime.gopark /usr/local/go/src/runtime/proc.go:435")
+}
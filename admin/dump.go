@@ -0,0 +1,93 @@
+package admin
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"runtime/pprof"
+	"slices"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// LogState logs a snapshot of every tracked request: its tasks by status,
+// worker pool occupancy, the oldest running task and the deferred tasks
+// still waiting to be awaited. Meant for diagnosing hung requests.
+func (reg *Registry) LogState(ctx context.Context, logger *slog.Logger) {
+	managers := reg.snapshot()
+	now := time.Now()
+
+	type entry struct {
+		manager *asynctask.Manager
+		req     request
+	}
+
+	// Oldest requests first, those are the likely hung ones
+	entries := make([]entry, 0, len(managers))
+	for manager, req := range managers {
+		entries = append(entries, entry{manager, req})
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		return a.req.started.Compare(b.req.started)
+	})
+
+	logger.InfoContext(ctx, "Task manager state", slog.Int("requests", len(entries)))
+
+	for _, e := range entries {
+		stats := e.manager.Stats()
+
+		attrs := []slog.Attr{
+			slog.String("request", e.req.method+" "+e.req.path),
+			slog.Duration("age", now.Sub(e.req.started)),
+			slog.Group("tasks",
+				slog.Int("deferred", stats.Deferred),
+				slog.Int("pending", stats.Pending),
+				slog.Int("running", stats.Running),
+				slog.Int("completed", stats.Completed),
+				slog.Int("failed", stats.Failed),
+				slog.Int("canceled", stats.Canceled),
+			),
+			slog.Group("workers",
+				slog.Int("busy", stats.Workers),
+				slog.Int("limit", stats.WorkerLimit),
+				slog.Int("peak", stats.PeakWorkers),
+				slog.Int("waiting", stats.Waiting),
+			),
+		}
+
+		var oldest *asynctask.Future
+		var deferred []string
+
+		// List is ordered oldest first
+		for _, future := range e.manager.List() {
+			switch future.Status {
+			case asynctask.StatusRunning.String():
+				if oldest == nil {
+					oldest = &future
+				}
+			case asynctask.StatusDeferred.String():
+				deferred = append(deferred, future.ID.String())
+			}
+		}
+
+		if oldest != nil {
+			attrs = append(attrs, slog.Group("oldest_running",
+				slog.String("id", oldest.ID.String()),
+				slog.Duration("age", now.Sub(oldest.Submitted)),
+				slog.Any("labels", oldest.Labels),
+			))
+		}
+		if len(deferred) > 0 {
+			attrs = append(attrs, slog.Any("deferred", deferred))
+		}
+
+		logger.LogAttrs(ctx, slog.LevelInfo, "Request tasks", attrs...)
+	}
+}
+
+// WriteGoroutines writes the stacks of all goroutines to w, including the
+// task ID labels of goroutines running tasks.
+func WriteGoroutines(w io.Writer) error {
+	return pprof.Lookup("goroutine").WriteTo(w, 1)
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Test the state dump logs one record per request
+func TestRegistry_LogState(t *testing.T) {
+	reg := NewRegistry()
+	ctx := context.Background()
+
+	tm := newManager(t, reg, "/hung.php")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	running := tm.Async(asynctask.WithLabels(ctx, map[string]string{"script": "slow.php"}), asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}))
+	<-started
+	deferred := tm.Defer(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	}))
+
+	var buf bytes.Buffer
+	reg.LogState(ctx, slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assertEqual(t, len(lines), 2)
+
+	var record struct {
+		Request string `json:"request"`
+		Tasks   struct {
+			Running  int `json:"running"`
+			Deferred int `json:"deferred"`
+		} `json:"tasks"`
+		Workers struct {
+			Busy int `json:"busy"`
+		} `json:"workers"`
+		Oldest struct {
+			ID     string            `json:"id"`
+			Labels map[string]string `json:"labels"`
+		} `json:"oldest_running"`
+		Deferred []string `json:"deferred"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatalf("invalid record %q: %v", lines[1], err)
+	}
+
+	assertEqual(t, record.Request, "GET /hung.php")
+	assertEqual(t, record.Tasks.Running, 1)
+	assertEqual(t, record.Tasks.Deferred, 1)
+	assertEqual(t, record.Workers.Busy, 1)
+	assertEqual(t, record.Oldest.ID, running.String())
+	assertEqual(t, record.Oldest.Labels["script"], "slow.php")
+	assertEqual(t, len(record.Deferred), 1)
+	assertEqual(t, record.Deferred[0], deferred.String())
+}
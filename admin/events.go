@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/pubsub"
+)
+
+// EventsTopic is the broker topic task events are published on.
+const EventsTopic = "_frankenasync.tasks"
+
+// eventBuffer is the number of events a slow stream client may fall behind
+// before events are dropped.
+const eventBuffer = 256
+
+// Event is the JSON representation of a task lifecycle event.
+type Event struct {
+	Type     string            `json:"type"`
+	ID       string            `json:"id"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Time     time.Time         `json:"time"`
+	Duration float64           `json:"duration_ms,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Publisher returns an asynctask event handler publishing events to b, for
+// use with asynctask.WithEventHandler. Events are only encoded while a
+// stream is connected.
+func Publisher(b *pubsub.Broker) func(asynctask.Event) {
+	return func(ev asynctask.Event) {
+		if b.Subscribers(EventsTopic) == 0 {
+			return
+		}
+
+		event := Event{
+			Type:     string(ev.Type),
+			ID:       ev.ID.String(),
+			Labels:   ev.Labels,
+			Time:     ev.Time,
+			Duration: float64(ev.Duration) / float64(time.Millisecond),
+		}
+		if ev.Error != nil {
+			event.Error = ev.Error.Error()
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		b.Publish(EventsTopic, data)
+	}
+}
+
+// WithEvents enables the event stream route, fed by tasks whose manager
+// publishes to b through Publisher.
+func WithEvents(b *pubsub.Broker) Option {
+	return func(c *config) {
+		c.events = b
+	}
+}
+
+// eventFilter matches events against the query of an event stream:
+// type=failed,completed, label=key:value (repeatable) and request=ID, a
+// shorthand for label=request:ID.
+type eventFilter struct {
+	types  []string
+	labels map[string]string
+}
+
+func newEventFilter(r *http.Request) (eventFilter, error) {
+	query := r.URL.Query()
+	filter := eventFilter{labels: make(map[string]string)}
+
+	if v := query.Get("type"); v != "" {
+		filter.types = strings.Split(v, ",")
+	}
+
+	for _, label := range query["label"] {
+		key, value, ok := strings.Cut(label, ":")
+		if !ok || key == "" {
+			return filter, fmt.Errorf("invalid label filter %q, want key:value", label)
+		}
+		filter.labels[key] = value
+	}
+
+	if v := query.Get("request"); v != "" {
+		filter.labels[RequestLabel] = v
+	}
+
+	return filter, nil
+}
+
+func (f eventFilter) match(ev Event) bool {
+	if f.types != nil && !slices.Contains(f.types, ev.Type) {
+		return false
+	}
+	for key, value := range f.labels {
+		if ev.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// streamEvents serves task events as Server-Sent Events until the client
+// disconnects.
+func streamEvents(b *pubsub.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := newEventFilter(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, "streaming unsupported")
+			return
+		}
+
+		// Streams outlive the server's write timeout
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		sub := b.Subscribe(EventsTopic, eventBuffer)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			msg, err := sub.Receive(r.Context())
+			if err != nil {
+				return
+			}
+
+			var ev Event
+			if err := json.Unmarshal(msg, &ev); err != nil || !filter.match(ev) {
+				continue
+			}
+
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, msg)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/pubsub"
+)
+
+// Test streaming filtered task events
+func TestHandler_Events(t *testing.T) {
+	b := pubsub.NewBroker()
+	server := httptest.NewServer(Handler(NewRegistry(), WithEvents(b)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + Prefix + "/events?type=completed,failed&request=r1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	assertEqual(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	// Wait for the handler to subscribe before publishing
+	deadline := time.Now().Add(time.Second)
+	for b.Subscribers(EventsTopic) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	tm := asynctask.NewManager(asynctask.WithEventHandler(Publisher(b)))
+	defer tm.Shutdown(context.Background())
+
+	run := func(request string, err error) asynctask.ID {
+		ctx := asynctask.WithLabels(context.Background(), map[string]string{RequestLabel: request})
+		id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			return nil, err
+		}))
+		_, _ = tm.Await(ctx, id)
+		return id
+	}
+
+	run("r2", nil) // other request, filtered out
+	failed := run("r1", errors.New("boom"))
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		lines = append(lines, strings.TrimRight(line, "\n"))
+	}
+	assertEqual(t, lines[0], "event: failed")
+
+	var ev Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &ev); err != nil {
+		t.Fatalf("invalid event %q: %v", lines[1], err)
+	}
+	assertEqual(t, ev.ID, failed.String())
+	assertEqual(t, ev.Error, "boom")
+	assertEqual(t, ev.Labels[RequestLabel], "r1")
+}
+
+// Test invalid filters and the disabled stream
+func TestHandler_EventsFilters(t *testing.T) {
+	var body map[string]string
+	h := Handler(NewRegistry(), WithEvents(pubsub.NewBroker()))
+	assertEqual(t, get(t, h, Prefix+"/events?label=nocolon", &body), http.StatusBadRequest)
+
+	rec := httptest.NewRecorder()
+	Handler(NewRegistry()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Prefix+"/events", nil))
+	assertEqual(t, rec.Code, http.StatusNotFound)
+}
@@ -0,0 +1,336 @@
+package admin
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/pubsub"
+)
+
+const (
+	// Prefix is the path prefix of all admin routes.
+	Prefix = "/_frankenasync"
+
+	// RequestLabel is the task label holding the ID of the request that
+	// started the task.
+	RequestLabel = "request"
+
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+type (
+	// Task is the JSON representation of a task in admin responses.
+	Task struct {
+		ID        string            `json:"id"`
+		Status    string            `json:"status"`
+		Labels    map[string]string `json:"labels,omitempty"`
+		Submitted *time.Time        `json:"submitted,omitempty"`
+		Started   *time.Time        `json:"started,omitempty"`
+		Finished  *time.Time        `json:"finished,omitempty"`
+		Duration  float64           `json:"duration_ms"`
+		Wait      float64           `json:"wait_ms"` // spent waiting for a worker slot
+		Error     string            `json:"error,omitempty"`
+		Request   string            `json:"request"`
+	}
+
+	// TaskList is a page of tasks.
+	TaskList struct {
+		Tasks  []Task `json:"tasks"`
+		Total  int    `json:"total"`
+		Limit  int    `json:"limit"`
+		Offset int    `json:"offset"`
+	}
+
+	Option func(*config)
+
+	config struct {
+		token       string
+		clientCerts bool
+		authAll     bool
+		corsOrigins []string
+		threads     func() Threads
+		events      *pubsub.Broker
+		debug       bool
+		reload      func() error
+		history     *asynctask.History
+	}
+)
+
+// WithToken sets the bearer token required by the routes that change task
+// state. Without a token those routes are refused.
+func WithToken(token string) Option {
+	return func(c *config) {
+		c.token = token
+	}
+}
+
+// Handler returns the admin API:
+//
+//	GET    /_frankenasync/ui
+//	GET    /_frankenasync/stats
+//	GET    /_frankenasync/events?type=failed&label=key:value&request=ID
+//	GET    /_frankenasync/debug/pprof/  (WithDebug)
+//	GET    /_frankenasync/debug/tasks   (WithDebug)
+//	GET    /_frankenasync/tasks?status=running,failed&limit=100&offset=0
+//	GET    /_frankenasync/tasks/{id}
+//	GET    /_frankenasync/history?status=failed&label=key:value&request=ID&limit=100 (WithHistory)
+//	DELETE /_frankenasync/tasks/{id}        (bearer token)
+//	POST   /_frankenasync/tasks/{id}/retry  (bearer token)
+//	POST   /_frankenasync/tasks/{id}/replay (bearer token)
+//	POST   /_frankenasync/reload            (bearer token, WithReload)
+//	PUT    /_frankenasync/workers?limit=8&request=ID (bearer token)
+//
+// Once a token or client certificates are configured, or with WithAuthAll,
+// every route but the dashboard page needs the bearer token, and
+// WithClientCerts accepts verified client certificates in its place. The
+// debug routes always need it.
+func Handler(reg *Registry, opts ...Option) http.Handler {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// Task state, labels and errors are as sensitive as the routes changing
+	// them once there is a way to authorize
+	if cfg.token != "" || cfg.clientCerts {
+		cfg.authAll = true
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET "+Prefix+"/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, collectStats(reg, cfg.threads))
+	})
+
+	if cfg.debug {
+		mountDebug(mux, reg, &cfg)
+	}
+
+	if cfg.events != nil {
+		mux.HandleFunc("GET "+Prefix+"/events", streamEvents(cfg.events))
+	}
+
+	if cfg.reload != nil {
+		mux.HandleFunc("POST "+Prefix+"/reload", cfg.authorize(reload(cfg.reload)))
+	}
+
+	if cfg.history != nil {
+		mux.HandleFunc("GET "+Prefix+"/history", serveHistory(cfg.history))
+	}
+
+	mux.HandleFunc("PUT "+Prefix+"/workers", cfg.authorize(resize(reg)))
+
+	mux.HandleFunc("GET "+Prefix+"/tasks", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		limit, err := intParam(query.Get("limit"), defaultLimit)
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(limit, maxLimit)
+
+		offset, err := intParam(query.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+
+		var statuses []string
+		if v := query.Get("status"); v != "" {
+			statuses = strings.Split(v, ",")
+		}
+
+		var tasks []Task
+		for manager, req := range reg.snapshot() {
+			for _, future := range manager.List() {
+				if statuses != nil && !slices.Contains(statuses, future.Status) {
+					continue
+				}
+				tasks = append(tasks, newTask(future, req))
+			}
+		}
+
+		// Oldest first
+		slices.SortFunc(tasks, func(a, b Task) int {
+			return cmp.Or(submitted(a).Compare(submitted(b)), strings.Compare(a.ID, b.ID))
+		})
+
+		list := TaskList{Tasks: []Task{}, Total: len(tasks), Limit: limit, Offset: offset}
+		if offset < len(tasks) {
+			list.Tasks = tasks[offset:min(offset+limit, len(tasks))]
+		}
+
+		writeJSON(w, http.StatusOK, list)
+	})
+
+	mux.HandleFunc("GET "+Prefix+"/tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, manager, req, ok := lookup(w, r, reg)
+		if !ok {
+			return
+		}
+
+		future, err := manager.Future(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newTask(future, req))
+	})
+
+	// Trace of every task of the request the task belongs to
+	mux.HandleFunc("GET "+Prefix+"/tasks/{id}/trace", func(w http.ResponseWriter, r *http.Request) {
+		_, manager, _, ok := lookup(w, r, reg)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = manager.ExportTrace(w)
+	})
+
+	mux.HandleFunc("DELETE "+Prefix+"/tasks/{id}", cfg.authorize(func(w http.ResponseWriter, r *http.Request) {
+		id, manager, req, ok := lookup(w, r, reg)
+		if !ok {
+			return
+		}
+
+		result, err := manager.Cancel(id)
+		switch {
+		case err != nil:
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		case result == asynctask.CancelAlreadyFinished:
+			writeError(w, http.StatusConflict, "task already finished")
+			return
+		}
+
+		future, _ := manager.Future(id)
+		writeJSON(w, http.StatusOK, newTask(future, req))
+	}))
+
+	mux.HandleFunc("POST "+Prefix+"/tasks/{id}/retry", cfg.authorize(func(w http.ResponseWriter, r *http.Request) {
+		id, manager, req, ok := lookup(w, r, reg)
+		if !ok {
+			return
+		}
+
+		newID, err := manager.Retry(id)
+		switch {
+		case errors.Is(err, asynctask.ErrTaskNotRetryable):
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		case err != nil:
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		future, _ := manager.Future(newID)
+		writeJSON(w, http.StatusAccepted, newTask(future, req))
+	}))
+
+	mux.HandleFunc("POST "+Prefix+"/tasks/{id}/replay", cfg.authorize(func(w http.ResponseWriter, r *http.Request) {
+		id, manager, req, ok := lookup(w, r, reg)
+		if !ok {
+			return
+		}
+
+		newID, err := manager.Replay(id)
+		switch {
+		case errors.Is(err, asynctask.ErrTaskNotFound):
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		case err != nil:
+			// Not finished, no spec, or a spec its factory now rejects
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		future, _ := manager.Future(newID)
+		writeJSON(w, http.StatusAccepted, newTask(future, req))
+	}))
+
+	// The dashboard page holds no state, and passes the token in its URL
+	// fragment on to the routes it polls
+	root := http.NewServeMux()
+	root.HandleFunc("GET "+Prefix+"/ui", serveDashboard)
+	root.Handle("/", cfg.wrap(mux))
+	return root
+}
+
+// lookup resolves the {id} path value to the manager tracking that task,
+// writing an error response when it can't.
+func lookup(w http.ResponseWriter, r *http.Request, reg *Registry) (asynctask.ID, *asynctask.Manager, request, bool) {
+	id, err := asynctask.ParseID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid task ID")
+		return asynctask.ID{}, nil, request{}, false
+	}
+
+	for manager, req := range reg.snapshot() {
+		if _, err := manager.Status(id); err == nil {
+			return id, manager, req, true
+		}
+	}
+
+	writeError(w, http.StatusNotFound, asynctask.ErrTaskNotFound.Error())
+	return asynctask.ID{}, nil, request{}, false
+}
+
+func newTask(future asynctask.Future, req request) Task {
+	task := Task{
+		ID:       future.ID.String(),
+		Status:   future.Status,
+		Labels:   future.Labels,
+		Duration: float64(future.Duration) / float64(time.Millisecond),
+		Wait:     float64(future.Wait) / float64(time.Millisecond),
+		Request:  req.method + " " + req.path,
+	}
+	if !future.Submitted.IsZero() {
+		task.Submitted = &future.Submitted
+	}
+	if !future.Time.IsZero() {
+		task.Started = &future.Time
+	}
+	if !future.Finished.IsZero() {
+		task.Finished = &future.Finished
+	}
+	if future.Error != nil {
+		task.Error = future.Error.Error()
+	}
+	return task
+}
+
+func intParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// submitted returns when task was submitted, the zero time if unknown.
+func submitted(task Task) time.Time {
+	if task.Submitted == nil {
+		return time.Time{}
+	}
+	return *task.Submitted
+}
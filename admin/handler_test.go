@@ -0,0 +1,285 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+func init() {
+	asynctask.RegisterRunnable("admin.echo", func(params map[string]any) (asynctask.Runnable, error) {
+		return asynctask.RunnableFunc(func(context.Context) (any, error) {
+			return params["value"], nil
+		}), nil
+	})
+}
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func get(t *testing.T, h http.Handler, target string, v any) int {
+	t.Helper()
+	return do(t, h, http.MethodGet, target, "", v)
+}
+
+func do(t *testing.T, h http.Handler, method, target, token string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	h.ServeHTTP(rec, req)
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code
+}
+
+func newManager(t *testing.T, reg *Registry, path string) *asynctask.Manager {
+	t.Helper()
+	tm := asynctask.NewManager()
+	t.Cleanup(reg.Track(tm, http.MethodGet, path))
+	t.Cleanup(func() { tm.Shutdown(context.Background()) })
+	return tm
+}
+
+// Test listing tasks across requests with filters and pagination
+func TestHandler_List(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg)
+	ctx := context.Background()
+
+	a := newManager(t, reg, "/a.php")
+	b := newManager(t, reg, "/b.php")
+
+	ok := a.Async(asynctask.WithLabels(ctx, map[string]string{"name": "ok"}), asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return "ok", nil
+	}))
+	_, _ = a.Await(ctx, ok)
+
+	failed := b.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, errors.New("boom")
+	}))
+	_, _ = b.Await(ctx, failed)
+
+	b.Defer(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	}))
+
+	var list TaskList
+	assertEqual(t, get(t, h, Prefix+"/tasks", &list), http.StatusOK)
+	assertEqual(t, list.Total, 3)
+	assertEqual(t, list.Tasks[0].ID, ok.String())
+	assertEqual(t, list.Tasks[0].Labels["name"], "ok")
+	assertEqual(t, list.Tasks[0].Request, "GET /a.php")
+	assertEqual(t, list.Tasks[1].Status, "failed")
+	assertEqual(t, list.Tasks[1].Error, "boom")
+	assertEqual(t, list.Tasks[2].Status, "deferred")
+
+	list = TaskList{}
+	assertEqual(t, get(t, h, Prefix+"/tasks?status=failed,deferred", &list), http.StatusOK)
+	assertEqual(t, list.Total, 2)
+
+	list = TaskList{}
+	assertEqual(t, get(t, h, Prefix+"/tasks?limit=1&offset=1", &list), http.StatusOK)
+	assertEqual(t, list.Total, 3)
+	assertEqual(t, len(list.Tasks), 1)
+	assertEqual(t, list.Tasks[0].ID, failed.String())
+
+	list = TaskList{}
+	assertEqual(t, get(t, h, Prefix+"/tasks?offset=10", &list), http.StatusOK)
+	assertEqual(t, len(list.Tasks), 0)
+
+	var body map[string]string
+	assertEqual(t, get(t, h, Prefix+"/tasks?limit=x", &body), http.StatusBadRequest)
+}
+
+// Test inspecting a single task
+func TestHandler_Get(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg)
+	ctx := context.Background()
+
+	tm := newManager(t, reg, "/index.php")
+	id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return "done", nil
+	}))
+	_, _ = tm.Await(ctx, id)
+
+	var task Task
+	assertEqual(t, get(t, h, Prefix+"/tasks/"+id.String(), &task), http.StatusOK)
+	assertEqual(t, task.ID, id.String())
+	assertEqual(t, task.Status, "completed")
+	if task.Submitted == nil || task.Started == nil || task.Finished == nil {
+		t.Fatal("expected submit, start and finish times")
+	}
+	if task.Started.Before(*task.Submitted) || task.Finished.Before(*task.Started) {
+		t.Fatalf("transition times out of order: %v, %v, %v", task.Submitted, task.Started, task.Finished)
+	}
+
+	var body map[string]string
+	assertEqual(t, get(t, h, Prefix+"/tasks/not-an-id", &body), http.StatusBadRequest)
+	assertEqual(t, get(t, h, Prefix+"/tasks/"+"d0q4kg7n9ccvr4mpkmvg", &body), http.StatusNotFound)
+}
+
+// Test exporting the trace of a task's request
+func TestHandler_Trace(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg)
+	ctx := context.Background()
+
+	tm := newManager(t, reg, "/index.php")
+	id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return "done", nil
+	}))
+	_, _ = tm.Await(ctx, id)
+
+	var trace struct {
+		TraceEvents []struct {
+			Name  string `json:"name"`
+			Phase string `json:"ph"`
+		} `json:"traceEvents"`
+	}
+	assertEqual(t, get(t, h, Prefix+"/tasks/"+id.String()+"/trace", &trace), http.StatusOK)
+
+	spans := 0
+	for _, ev := range trace.TraceEvents {
+		if ev.Phase == "X" {
+			assertEqual(t, ev.Name, id.String())
+			spans++
+		}
+	}
+	assertEqual(t, spans, 1)
+
+	var body map[string]string
+	assertEqual(t, get(t, h, Prefix+"/tasks/d0q4kg7n9ccvr4mpkmvg/trace", &body), http.StatusNotFound)
+}
+
+// Test canceling a task requires the token
+func TestHandler_Cancel(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg, WithToken("secret"))
+
+	tm := newManager(t, reg, "/index.php")
+	started := make(chan struct{})
+	id := tm.Async(context.Background(), asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, nil
+	}))
+	<-started
+
+	target := Prefix + "/tasks/" + id.String()
+
+	var body map[string]string
+	assertEqual(t, do(t, h, http.MethodDelete, target, "", &body), http.StatusUnauthorized)
+	assertEqual(t, do(t, h, http.MethodDelete, target, "wrong", &body), http.StatusUnauthorized)
+
+	var task Task
+	assertEqual(t, do(t, h, http.MethodDelete, target, "secret", &task), http.StatusOK)
+	assertEqual(t, task.Status, "canceled")
+
+	status, _ := tm.Status(id)
+	assertEqual(t, status, asynctask.StatusCanceled)
+
+	// Finished tasks keep their outcome
+	assertEqual(t, do(t, h, http.MethodDelete, target, "secret", &body), http.StatusConflict)
+
+	// Without a configured token, state changes are refused
+	assertEqual(t, do(t, Handler(reg), http.MethodDelete, target, "secret", &body), http.StatusForbidden)
+}
+
+// Test retrying a failed task
+func TestHandler_Retry(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg, WithToken("secret"))
+	ctx := context.Background()
+
+	tm := newManager(t, reg, "/index.php")
+	failed := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, errors.New("boom")
+	}))
+	_, _ = tm.Await(ctx, failed)
+
+	var task Task
+	assertEqual(t, do(t, h, http.MethodPost, Prefix+"/tasks/"+failed.String()+"/retry", "secret", &task), http.StatusAccepted)
+	if task.ID == failed.String() {
+		t.Fatal("expected a new task ID")
+	}
+
+	list := TaskList{}
+	do(t, h, http.MethodGet, Prefix+"/tasks", "secret", &list)
+	assertEqual(t, list.Total, 2)
+
+	ok := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return "ok", nil
+	}))
+	_, _ = tm.Await(ctx, ok)
+
+	var body map[string]string
+	assertEqual(t, do(t, h, http.MethodPost, Prefix+"/tasks/"+ok.String()+"/retry", "secret", &body), http.StatusConflict)
+	assertEqual(t, do(t, h, http.MethodPost, Prefix+"/tasks/"+"d0q4kg7n9ccvr4mpkmvg"+"/retry", "secret", &body), http.StatusNotFound)
+}
+
+// Test replaying a task submitted from a spec
+func TestHandler_Replay(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg, WithToken("secret"))
+	ctx := context.Background()
+
+	tm := newManager(t, reg, "/index.php")
+	completed, err := tm.Submit(ctx, asynctask.Spec{Name: "admin.echo", Params: map[string]any{"value": "ok"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _ = tm.Await(ctx, completed)
+
+	var task Task
+	assertEqual(t, do(t, h, http.MethodPost, Prefix+"/tasks/"+completed.String()+"/replay", "secret", &task), http.StatusAccepted)
+	if task.ID == completed.String() {
+		t.Fatal("expected a new task ID")
+	}
+	assertEqual(t, task.Labels[asynctask.RunnableLabel], "admin.echo")
+
+	// Tasks started from a closure can't be replayed
+	closure := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return "ok", nil
+	}))
+	_, _ = tm.Await(ctx, closure)
+
+	var body map[string]string
+	assertEqual(t, do(t, h, http.MethodPost, Prefix+"/tasks/"+closure.String()+"/replay", "secret", &body), http.StatusConflict)
+	assertEqual(t, do(t, h, http.MethodPost, Prefix+"/tasks/"+"d0q4kg7n9ccvr4mpkmvg"+"/replay", "secret", &body), http.StatusNotFound)
+}
+
+// Test untracking a request removes its tasks
+func TestRegistry_Untrack(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg)
+
+	tm := asynctask.NewManager()
+	untrack := reg.Track(tm, http.MethodGet, "/")
+	tm.Defer(context.Background(), asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	}))
+
+	var list TaskList
+	get(t, h, Prefix+"/tasks", &list)
+	assertEqual(t, list.Total, 1)
+
+	untrack()
+
+	list = TaskList{}
+	get(t, h, Prefix+"/tasks", &list)
+	assertEqual(t, list.Total, 0)
+}
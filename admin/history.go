@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+type (
+	// HistoryEntry is the JSON representation of a finished task of the
+	// history.
+	HistoryEntry struct {
+		ID        string            `json:"id"`
+		Name      string            `json:"name,omitempty"`
+		Request   string            `json:"request,omitempty"` // ID of the request that started the task
+		Labels    map[string]string `json:"labels,omitempty"`
+		Status    string            `json:"status"`
+		Submitted *time.Time        `json:"submitted,omitempty"`
+		Started   *time.Time        `json:"started,omitempty"`
+		Finished  time.Time         `json:"finished"`
+		Duration  float64           `json:"duration_ms"`
+		Wait      float64           `json:"wait_ms"`
+		Attempts  int               `json:"attempts"`
+		Error     string            `json:"error,omitempty"`
+	}
+
+	// History is the most recently finished tasks matching a history query.
+	History struct {
+		Tasks    []HistoryEntry `json:"tasks"`
+		Capacity int            `json:"capacity"` // tasks the history keeps
+	}
+)
+
+// WithHistory mounts GET /_frankenasync/history, serving the tasks managers
+// recorded in h with asynctask.WithHistory, most recently finished first.
+// It's filtered like the event stream: status=failed,canceled,
+// label=key:value (repeatable) and request=ID, and capped by limit.
+func WithHistory(h *asynctask.History) Option {
+	return func(c *config) {
+		c.history = h
+	}
+}
+
+// serveHistory serves the tasks of h matching the query.
+func serveHistory(h *asynctask.History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := newEventFilter(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		filter.types = nil
+		if v := r.URL.Query().Get("status"); v != "" {
+			filter.types = strings.Split(v, ",")
+		}
+
+		limit, err := intParam(r.URL.Query().Get("limit"), defaultLimit)
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = min(limit, maxLimit)
+
+		history := History{Tasks: []HistoryEntry{}, Capacity: h.Capacity()}
+		for _, entry := range h.Entries() {
+			if len(history.Tasks) == limit {
+				break
+			}
+			if !filter.match(Event{Type: entry.Status.String(), Labels: entry.Labels}) {
+				continue
+			}
+			history.Tasks = append(history.Tasks, newHistoryEntry(entry))
+		}
+
+		writeJSON(w, http.StatusOK, history)
+	}
+}
+
+func newHistoryEntry(entry asynctask.HistoryEntry) HistoryEntry {
+	out := HistoryEntry{
+		ID:       entry.ID.String(),
+		Name:     entry.Name,
+		Request:  entry.RequestID,
+		Labels:   entry.Labels,
+		Status:   entry.Status.String(),
+		Finished: entry.Finished,
+		Duration: float64(entry.Duration) / float64(time.Millisecond),
+		Wait:     float64(entry.Wait) / float64(time.Millisecond),
+		Attempts: entry.Attempts,
+		Error:    entry.Error,
+	}
+	if !entry.Submitted.IsZero() {
+		out.Submitted = &entry.Submitted
+	}
+	if !entry.Started.IsZero() {
+		out.Started = &entry.Started
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Test serving the history of finished tasks, after their request ended
+func TestHandler_History(t *testing.T) {
+	reg := NewRegistry()
+	history := asynctask.NewHistory(10)
+	h := Handler(reg, WithHistory(history))
+	ctx := context.Background()
+
+	for _, requestID := range []string{"req-a", "req-b"} {
+		tm := asynctask.NewManager(asynctask.WithHistory(history), asynctask.WithRequestID(requestID))
+		untrack := reg.Track(tm, http.MethodGet, "/"+requestID+".php")
+		reqCtx := asynctask.WithLabels(ctx, map[string]string{RequestLabel: requestID})
+		_, _ = tm.Await(ctx, tm.Async(reqCtx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			return "ok", nil
+		})))
+		_, _ = tm.Await(ctx, tm.Async(reqCtx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			return nil, errors.New("boom")
+		})))
+		tm.Shutdown(ctx)
+		untrack()
+	}
+
+	var list History
+	assertEqual(t, get(t, h, Prefix+"/history", &list), http.StatusOK)
+	assertEqual(t, list.Capacity, 10)
+	assertEqual(t, len(list.Tasks), 4)
+	assertEqual(t, list.Tasks[0].Request, "req-b")
+	assertEqual(t, list.Tasks[0].Status, "failed")
+	assertEqual(t, list.Tasks[0].Error, "boom")
+	assertEqual(t, list.Tasks[0].Attempts, 1)
+
+	assertEqual(t, get(t, h, Prefix+"/history?status=completed&request=req-a", &list), http.StatusOK)
+	assertEqual(t, len(list.Tasks), 1)
+	assertEqual(t, list.Tasks[0].Request, "req-a")
+	assertEqual(t, list.Tasks[0].Status, "completed")
+
+	assertEqual(t, get(t, h, Prefix+"/history?limit=3", &list), http.StatusOK)
+	assertEqual(t, len(list.Tasks), 3)
+
+	var body map[string]string
+	assertEqual(t, get(t, h, Prefix+"/history?label=nokey", &body), http.StatusBadRequest)
+	assertEqual(t, get(t, h, Prefix+"/history?limit=0", &body), http.StatusBadRequest)
+
+	// Not mounted without WithHistory
+	rec := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Prefix+"/history", nil))
+	assertEqual(t, rec.Code, http.StatusNotFound)
+}
@@ -0,0 +1,153 @@
+package admin
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// metric is a Prometheus metric with its samples.
+type metric struct {
+	name    string
+	kind    string // gauge or counter
+	help    string
+	samples []sample
+}
+
+// sample is the value of a metric for a label, or without one.
+type sample struct {
+	label string // name="value", or empty
+	value float64
+}
+
+// MetricsHandler serves the stats of the stats route, and those of the
+// operations recorded by asynctask.WithMetrics, in the Prometheus text
+// format under /metrics, and /healthz, for a listener scrapers reach but
+// operators don't. WithDebug mounts the profiling routes as it does for
+// Handler, behind the bearer token, and WithAuthAll requires the token on
+// every route.
+func MetricsHandler(reg *Registry, opts ...Option) http.Handler {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", HealthHandler())
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, metrics(collectStats(reg, cfg.threads)))
+		writeOperations(w, asynctask.Operations())
+	})
+
+	if cfg.debug {
+		mountDebug(mux, reg, &cfg)
+	}
+
+	return cfg.wrap(mux)
+}
+
+// metrics returns the stats as metrics. Totals since startup are counters.
+func metrics(stats Stats) []metric {
+	tasks := []sample{
+		{`status="deferred"`, float64(stats.Tasks.Deferred)},
+		{`status="pending"`, float64(stats.Tasks.Pending)},
+		{`status="running"`, float64(stats.Tasks.Running)},
+		{`status="completed"`, float64(stats.Tasks.Completed)},
+		{`status="failed"`, float64(stats.Tasks.Failed)},
+		{`status="canceled"`, float64(stats.Tasks.Canceled)},
+	}
+
+	var workers, limits, waiting []sample
+	for _, name := range slices.Sorted(maps.Keys(stats.Tasks.Pools)) {
+		pool := stats.Tasks.Pools[name]
+		label := "pool=" + strconv.Quote(name)
+		workers = append(workers, sample{label, float64(pool.Workers)})
+		limits = append(limits, sample{label, float64(pool.WorkerLimit)})
+		waiting = append(waiting, sample{label, float64(pool.Waiting)})
+	}
+
+	list := []metric{
+		{"frankenasync_requests", "gauge", "In-flight requests with tasks.", []sample{{"", float64(stats.Requests)}}},
+		{"frankenasync_tasks", "gauge", "Tasks of in-flight requests by status.", tasks},
+		{"frankenasync_tasks_processed_total", "counter", "Tasks finished since startup.", []sample{{"", float64(stats.Processed)}}},
+		{"frankenasync_tasks_slow_total", "counter", "Tasks over the slow task threshold since startup.", []sample{{"", float64(stats.Slow)}}},
+		{"frankenasync_tasks_stalled_total", "counter", "Times tasks were found stalled since startup.", []sample{{"", float64(stats.Stalled)}}},
+		{"frankenasync_awaits_over_budget_total", "counter", "Awaits over the await budget since startup.", []sample{{"", float64(stats.OverBudget)}}},
+		{"frankenasync_tasks_over_memory_total", "counter", "Tasks over the task memory limit since startup.", []sample{{"", float64(stats.OverMemory)}}},
+		{"frankenasync_task_cpu_seconds_total", "counter", "Thread CPU time of profiled tasks since startup.", []sample{{"", stats.CPU}}},
+		{"frankenasync_task_memory_bytes", "gauge", "Approximate bytes held by the results of in-flight requests' tasks.", []sample{{"", float64(stats.Tasks.Memory)}}},
+		{"frankenasync_workers", "gauge", "Worker slots in use.", []sample{{"", float64(stats.Tasks.Workers)}}},
+		{"frankenasync_worker_limit", "gauge", "Worker slots of in-flight requests.", []sample{{"", float64(stats.Tasks.WorkerLimit)}}},
+		{"frankenasync_pool_workers", "gauge", "Worker slots in use by named pool.", workers},
+		{"frankenasync_pool_worker_limit", "gauge", "Worker slots by named pool.", limits},
+		{"frankenasync_pool_waiting", "gauge", "Submissions waiting for a worker slot by named pool.", waiting},
+		{"frankenasync_waiting", "gauge", "Submissions waiting for a worker slot.", []sample{{"", float64(stats.Pool.Waiting)}}},
+		{"frankenasync_acquired_total", "counter", "Worker slots handed out since startup.", []sample{{"", float64(stats.Pool.Acquired)}}},
+		{"frankenasync_wait_max_seconds", "gauge", "Longest wait for a worker slot since startup.", []sample{{"", stats.Pool.WaitMax / 1000}}},
+		{"frankenasync_goroutines", "gauge", "Goroutines of the process.", []sample{{"", float64(stats.Memory.Goroutines)}}},
+		{"frankenasync_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", []sample{{"", float64(stats.Memory.HeapAlloc)}}},
+		{"frankenasync_sys_bytes", "gauge", "Bytes of memory obtained from the OS.", []sample{{"", float64(stats.Memory.Sys)}}},
+	}
+	if t := stats.Threads; t != nil {
+		list = append(list, metric{"frankenasync_php_threads", "gauge", "PHP threads by state.", []sample{
+			{`state="busy"`, float64(t.Busy)},
+			{`state="idle"`, float64(t.Total - t.Busy)},
+			{`state="reserved"`, float64(t.Reserved)},
+		}})
+	}
+	return list
+}
+
+// writeMetrics writes metrics in the Prometheus text format, leaving out
+// those without samples.
+func writeMetrics(w io.Writer, list []metric) {
+	for _, m := range list {
+		if len(m.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range m.samples {
+			value := strconv.FormatFloat(s.value, 'g', -1, 64)
+			if s.label == "" {
+				fmt.Fprintf(w, "%s %s\n", m.name, value)
+			} else {
+				fmt.Fprintf(w, "%s{%s} %s\n", m.name, s.label, value)
+			}
+		}
+	}
+}
+
+// writeOperations writes the operations as a histogram of their durations
+// and a counter of their outcomes.
+func writeOperations(w io.Writer, ops []asynctask.OperationStats) {
+	if len(ops) == 0 {
+		return
+	}
+
+	const name = "frankenasync_operation_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Durations of operations since startup.\n# TYPE %s histogram\n", name, name)
+	var outcomes []sample
+	for _, op := range ops {
+		label := "operation=" + strconv.Quote(op.Name)
+		for i, le := range asynctask.OperationBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, label, strconv.FormatFloat(le, 'g', -1, 64), op.Buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, label, op.Count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, label, strconv.FormatFloat(op.Sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, label, op.Count)
+
+		for _, o := range slices.Sorted(maps.Keys(op.Outcomes)) {
+			outcomes = append(outcomes, sample{label + ",outcome=" + strconv.Quote(o), float64(op.Outcomes[o])})
+		}
+	}
+
+	writeMetrics(w, []metric{
+		{"frankenasync_operations_total", "counter", "Operations since startup by outcome.", outcomes},
+	})
+}
@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Test the stats in the Prometheus text format
+func TestMetricsHandler(t *testing.T) {
+	reg := NewRegistry()
+	h := MetricsHandler(reg, WithThreads(func() Threads {
+		return Threads{Total: 8, Busy: 3}
+	}))
+	ctx := context.Background()
+
+	tm := asynctask.NewManager(asynctask.WithPool("io", 4))
+	t.Cleanup(reg.Track(tm, http.MethodGet, "/a.php"))
+	t.Cleanup(func() { tm.Shutdown(ctx) })
+	id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	}))
+	_, _ = tm.Await(ctx, id)
+
+	op := tm.Async(ctx, asynctask.WithMetrics(asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, errors.New("failed")
+	}), "admin.test"))
+	_, _ = tm.Await(ctx, op)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assertEqual(t, rec.Code, http.StatusOK)
+	assertEqual(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"), true)
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE frankenasync_requests gauge",
+		"frankenasync_requests 1",
+		`frankenasync_tasks{status="completed"} 1`,
+		`frankenasync_tasks{status="failed"} 1`,
+		`frankenasync_pool_worker_limit{pool="io"} 4`,
+		`frankenasync_php_threads{state="busy"} 3`,
+		`frankenasync_php_threads{state="idle"} 5`,
+		"# TYPE frankenasync_tasks_processed_total counter",
+		"# TYPE frankenasync_operation_duration_seconds histogram",
+		`frankenasync_operation_duration_seconds_bucket{operation="admin.test",le="+Inf"} 1`,
+		`frankenasync_operation_duration_seconds_count{operation="admin.test"} 1`,
+		`frankenasync_operations_total{operation="admin.test",outcome="error"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, body)
+		}
+	}
+
+	// Nothing of the admin API
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Prefix+"/tasks", nil))
+	assertEqual(t, rec.Code, http.StatusNotFound)
+
+	// Every route needs the token with WithAuthAll
+	h = MetricsHandler(reg, WithToken("secret"), WithAuthAll())
+	var status map[string]string
+	assertEqual(t, get(t, h, "/healthz", &status), http.StatusUnauthorized)
+	assertEqual(t, do(t, h, http.MethodGet, "/healthz", "secret", &status), http.StatusOK)
+}
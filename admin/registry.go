@@ -0,0 +1,121 @@
+// Package admin serves an operator-facing HTTP API for inspecting the async
+// tasks of in-flight requests. It is meant to be mounted on a separate,
+// non-public listener.
+package admin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+type (
+	// Registry tracks the task managers of in-flight requests.
+	Registry struct {
+		mu        sync.RWMutex
+		managers  map[*asynctask.Manager]request
+		processed int             // tasks finished by untracked requests
+		pool      asynctask.Stats // worker pool usage of untracked requests
+	}
+
+	request struct {
+		method  string
+		path    string
+		started time.Time
+	}
+)
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{managers: make(map[*asynctask.Manager]request)}
+}
+
+// Track registers the task manager of a request and returns a function that
+// removes it again. Call it once the request is done but before the manager
+// is shut down, so its finished tasks are still counted.
+func (reg *Registry) Track(manager *asynctask.Manager, method, path string) func() {
+	reg.mu.Lock()
+	reg.managers[manager] = request{method: method, path: path, started: time.Now()}
+	reg.mu.Unlock()
+
+	return func() {
+		reg.mu.Lock()
+		if _, ok := reg.managers[manager]; ok {
+			delete(reg.managers, manager)
+			stats := manager.Stats()
+			reg.processed += finished(stats)
+			reg.pool = mergePool(reg.pool, stats)
+		}
+		reg.mu.Unlock()
+	}
+}
+
+// Future looks up a task in any tracked request.
+func (reg *Registry) Future(id asynctask.ID) (asynctask.Future, error) {
+	for manager := range reg.snapshot() {
+		if future, err := manager.Future(id); err == nil {
+			return future, nil
+		}
+	}
+	return asynctask.Future{}, asynctask.ErrTaskNotFound
+}
+
+// Prune removes tasks that finished more than ttl ago from every tracked
+// request, so long-running requests don't hold on to them. Pruned tasks
+// still count as processed. Returns the number of tasks pruned.
+func (reg *Registry) Prune(ttl time.Duration) int {
+	// Hold the lock so a request being untracked can't count the same
+	// tasks again
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	pruned := 0
+	for manager := range reg.managers {
+		pruned += manager.Prune(ttl)
+	}
+	reg.processed += pruned
+
+	return pruned
+}
+
+// snapshot copies the tracked managers so callers can query them without
+// holding the lock.
+func (reg *Registry) snapshot() map[*asynctask.Manager]request {
+	managers, _, _ := reg.snapshotProcessed()
+	return managers
+}
+
+// snapshotProcessed is snapshot that also returns the number of tasks
+// finished and the worker pool usage of requests that are no longer tracked.
+func (reg *Registry) snapshotProcessed() (map[*asynctask.Manager]request, int, asynctask.Stats) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	managers := make(map[*asynctask.Manager]request, len(reg.managers))
+	for manager, req := range reg.managers {
+		managers[manager] = req
+	}
+	return managers, reg.processed, reg.pool
+}
+
+func finished(stats asynctask.Stats) int {
+	return stats.Completed + stats.Failed + stats.Canceled
+}
+
+// mergePool adds the worker pool usage, slow and stalled tasks, awaits over
+// budget, tasks over the memory limit and CPU time of src to dst. Peaks are those of the busiest manager.
+func mergePool(dst, src asynctask.Stats) asynctask.Stats {
+	dst.Waiting += src.Waiting
+	dst.Acquired += src.Acquired
+	dst.WaitTotal += src.WaitTotal
+	dst.WaitMax = max(dst.WaitMax, src.WaitMax)
+	dst.PeakWorkers = max(dst.PeakWorkers, src.PeakWorkers)
+	dst.Slow += src.Slow
+	dst.Stalled += src.Stalled
+	dst.AwaitTotal += src.AwaitTotal
+	dst.OverBudget += src.OverBudget
+	dst.OverMemory += src.OverMemory
+	dst.CPUTotal += src.CPUTotal
+	return dst
+}
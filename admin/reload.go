@@ -0,0 +1,23 @@
+package admin
+
+import "net/http"
+
+// WithReload mounts POST /_frankenasync/reload, which calls fn to reload the
+// server configuration. It requires the bearer token.
+func WithReload(fn func() error) Option {
+	return func(c *config) {
+		c.reload = fn
+	}
+}
+
+// reload responds with the error returned by fn, if any. A rejected
+// configuration leaves the running one in place.
+func reload(fn func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+	}
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Test reloading the configuration through the admin API
+func TestHandler_Reload(t *testing.T) {
+	reg := NewRegistry()
+
+	var reloadErr error
+	reloads := 0
+	h := Handler(reg, WithToken("secret"), WithReload(func() error {
+		reloads++
+		return reloadErr
+	}))
+
+	var body map[string]string
+	assertEqual(t, do(t, h, http.MethodPost, Prefix+"/reload", "", &body), http.StatusUnauthorized)
+	assertEqual(t, reloads, 0)
+
+	assertEqual(t, do(t, h, http.MethodPost, Prefix+"/reload", "secret", &body), http.StatusOK)
+	assertEqual(t, body["status"], "reloaded")
+	assertEqual(t, reloads, 1)
+
+	reloadErr = errors.New("workers: must not be negative")
+	assertEqual(t, do(t, h, http.MethodPost, Prefix+"/reload", "secret", &body), http.StatusUnprocessableEntity)
+	assertEqual(t, body["error"], "workers: must not be negative")
+
+	// Not mounted without WithReload
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, Prefix+"/reload", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	Handler(reg, WithToken("secret")).ServeHTTP(rec, req)
+	assertEqual(t, rec.Code, http.StatusNotFound)
+}
+
+// Test pruning finished tasks across requests
+func TestRegistry_Prune(t *testing.T) {
+	reg := NewRegistry()
+	ctx := context.Background()
+
+	tm := newManager(t, reg, "/index.php")
+	done := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return "ok", nil
+	}))
+	_, _ = tm.Await(ctx, done)
+
+	started := make(chan struct{})
+	tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, nil
+	}))
+	<-started
+
+	assertEqual(t, reg.Prune(time.Hour), 0)
+	assertEqual(t, reg.Prune(0), 1)
+
+	_, err := tm.Status(done)
+	assertEqual(t, errors.Is(err, asynctask.ErrTaskNotFound), true)
+
+	var stats Stats
+	get(t, Handler(reg), Prefix+"/stats", &stats)
+	assertEqual(t, stats.Processed, 1)
+}
@@ -0,0 +1,30 @@
+package admin
+
+import "net/http"
+
+// ClientRequestLabel is the task label holding the X-Request-ID header the
+// client sent, if it's valid. The request's own ID, under RequestLabel, is
+// always generated by the server, so clients can't pick the ID of another
+// request to collide with it in the registry, logs or events.
+const ClientRequestLabel = "client_request"
+
+// maxClientRequestID bounds the length of accepted X-Request-ID headers.
+const maxClientRequestID = 128
+
+// ClientRequestID returns the X-Request-ID header of r, or "" unless it's
+// at most 128 letters, digits and "-", "_", ".", ":" or "/".
+func ClientRequestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if len(id) > maxClientRequestID {
+		return ""
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/':
+		default:
+			return ""
+		}
+	}
+	return id
+}
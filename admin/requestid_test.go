@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test that only well-formed client request IDs are accepted
+func TestClientRequestID(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                                     "",
+		"d0q4kg7n9ccvr4mpkmvg":                 "d0q4kg7n9ccvr4mpkmvg",
+		"5f0c6e1a-9b7d-4c1e-8f2a-3b4c5d6e7f80": "5f0c6e1a-9b7d-4c1e-8f2a-3b4c5d6e7f80",
+		"1-67a1b2c3/svc:web.2_a":               "1-67a1b2c3/svc:web.2_a",
+		strings.Repeat("a", 128):               strings.Repeat("a", 128),
+		strings.Repeat("a", 129):               "",
+		"a b":                                  "",
+		"a\x00b":                               "",
+		"a\nb":                                 "",
+		"<script>":                             "",
+		"é":                                    "",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-ID", header)
+		assertEqual(t, ClientRequestID(req), want)
+	}
+}
@@ -0,0 +1,149 @@
+package admin
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+type (
+	// Threads describes the PHP thread pool.
+	Threads struct {
+		Total    int `json:"total"`
+		Busy     int `json:"busy"`
+		Reserved int `json:"reserved"` // threads that can still be started
+	}
+
+	// Memory holds Go runtime memory figures in bytes.
+	Memory struct {
+		HeapAlloc  uint64 `json:"heap_alloc"`
+		HeapInuse  uint64 `json:"heap_inuse"`
+		Sys        uint64 `json:"sys"`
+		NumGC      uint32 `json:"num_gc"`
+		Goroutines int    `json:"goroutines"`
+	}
+
+	// Pool summarizes worker slot usage since startup, to tell whether
+	// requests wait for workers. PeakWorkers and WaitMax are those of the
+	// busiest request, to compare with the per-request worker limit.
+	Pool struct {
+		Waiting     int     `json:"waiting"`  // submissions blocked on a full pool now
+		Acquired    int     `json:"acquired"` // worker slots handed out
+		WaitAvg     float64 `json:"wait_avg_ms"`
+		WaitMax     float64 `json:"wait_max_ms"`
+		PeakWorkers int     `json:"peak_workers"`
+	}
+
+	// Stats is the response of the stats route. Task and worker counts are
+	// summed over all in-flight requests.
+	Stats struct {
+		Requests   int             `json:"requests"`
+		Processed  int             `json:"processed"`   // tasks finished since startup
+		Slow       int             `json:"slow"`        // tasks over the slow task threshold since startup
+		Stalled    int             `json:"stalled"`     // times tasks were found stalled since startup
+		OverBudget int             `json:"over_budget"` // awaits over the await budget since startup
+		OverMemory int             `json:"over_memory"` // tasks over the task memory limit since startup
+		CPU        float64         `json:"cpu_seconds"` // thread CPU time of profiled tasks since startup
+		Tasks      asynctask.Stats `json:"tasks"`
+		Pool       Pool            `json:"pool"`
+		Threads    *Threads        `json:"threads,omitempty"`
+		Memory     Memory          `json:"memory"`
+	}
+)
+
+// WithThreads sets the function reporting the PHP thread pool in stats.
+func WithThreads(threads func() Threads) Option {
+	return func(c *config) {
+		c.threads = threads
+	}
+}
+
+// collectStats aggregates the stats of every tracked manager.
+func collectStats(reg *Registry, threads func() Threads) Stats {
+	managers, processed, pool := reg.snapshotProcessed()
+	stats := Stats{Processed: processed}
+
+	for manager := range managers {
+		s := manager.Stats()
+
+		stats.Requests++
+		stats.Processed += finished(s)
+		stats.Tasks.Deferred += s.Deferred
+		stats.Tasks.Pending += s.Pending
+		stats.Tasks.Running += s.Running
+		stats.Tasks.Completed += s.Completed
+		stats.Tasks.Failed += s.Failed
+		stats.Tasks.Canceled += s.Canceled
+		stats.Tasks.Total += s.Total
+		stats.Tasks.Workers += s.Workers
+		stats.Tasks.WorkerLimit += s.WorkerLimit
+		stats.Tasks.Memory += s.Memory
+		stats.Tasks.MemoryMax = max(stats.Tasks.MemoryMax, s.MemoryMax)
+		stats.Tasks = mergePool(stats.Tasks, s)
+		pool = mergePool(pool, s)
+
+		for name, p := range s.Pools {
+			if stats.Tasks.Pools == nil {
+				stats.Tasks.Pools = make(map[string]asynctask.PoolStats)
+			}
+			sum := stats.Tasks.Pools[name]
+			sum.Workers += p.Workers
+			sum.WorkerLimit += p.WorkerLimit
+			sum.Waiting += p.Waiting
+			stats.Tasks.Pools[name] = sum
+		}
+	}
+
+	stats.Slow = pool.Slow
+	stats.Stalled = pool.Stalled
+	stats.OverBudget = pool.OverBudget
+	stats.OverMemory = pool.OverMemory
+	stats.CPU = pool.CPUTotal.Seconds()
+	stats.Pool = Pool{
+		Waiting:     pool.Waiting,
+		Acquired:    pool.Acquired,
+		WaitMax:     float64(pool.WaitMax) / float64(time.Millisecond),
+		PeakWorkers: pool.PeakWorkers,
+	}
+	if pool.Acquired > 0 {
+		stats.Pool.WaitAvg = float64(pool.WaitTotal) / float64(pool.Acquired) / float64(time.Millisecond)
+	}
+
+	if threads != nil {
+		t := threads()
+		stats.Threads = &t
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats.Memory = Memory{
+		HeapAlloc:  mem.HeapAlloc,
+		HeapInuse:  mem.HeapInuse,
+		Sys:        mem.Sys,
+		NumGC:      mem.NumGC,
+		Goroutines: runtime.NumGoroutine(),
+	}
+
+	return stats
+}
+
+// HealthHandler reports that the process is up.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// ReadyHandler reports whether the server can take traffic. It responds
+// 503 with the error returned by check until check succeeds.
+func ReadyHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
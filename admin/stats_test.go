@@ -0,0 +1,157 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Test stats are summed across requests
+func TestHandler_Stats(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg, WithThreads(func() Threads {
+		return Threads{Total: 8, Busy: 3}
+	}))
+	ctx := context.Background()
+
+	for _, path := range []string{"/a.php", "/b.php"} {
+		tm := asynctask.NewManager(asynctask.WithPool("io", 4))
+		t.Cleanup(reg.Track(tm, http.MethodGet, path))
+		t.Cleanup(func() { tm.Shutdown(ctx) })
+		id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			return nil, nil
+		}))
+		_, _ = tm.Await(ctx, id)
+		tm.Defer(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			return nil, nil
+		}))
+	}
+
+	var stats Stats
+	assertEqual(t, get(t, h, Prefix+"/stats", &stats), http.StatusOK)
+	assertEqual(t, stats.Requests, 2)
+	assertEqual(t, stats.Tasks.Completed, 2)
+	assertEqual(t, stats.Tasks.Deferred, 2)
+	assertEqual(t, stats.Tasks.Total, 4)
+	assertEqual(t, stats.Tasks.Pools["io"].WorkerLimit, 8)
+	assertEqual(t, stats.Threads.Total, 8)
+	assertEqual(t, stats.Threads.Busy, 3)
+	if stats.Memory.HeapAlloc == 0 || stats.Memory.Goroutines == 0 {
+		t.Fatalf("expected memory figures, got %+v", stats.Memory)
+	}
+}
+
+// Test health and readiness probes
+func TestHealthHandlers(t *testing.T) {
+	var body map[string]string
+	assertEqual(t, get(t, HealthHandler(), "/healthz", &body), http.StatusOK)
+
+	var ready error
+	h := ReadyHandler(func() error { return ready })
+	assertEqual(t, get(t, h, "/readyz", &body), http.StatusOK)
+
+	ready = errors.New("thread pool saturated")
+	assertEqual(t, get(t, h, "/readyz", &body), http.StatusServiceUnavailable)
+	assertEqual(t, body["error"], "thread pool saturated")
+}
+
+// Test finished tasks of untracked requests stay counted
+func TestHandler_Processed(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg)
+	ctx := context.Background()
+
+	tm := asynctask.NewManager(asynctask.WithSlowTaskThreshold(5 * time.Millisecond))
+	untrack := reg.Track(tm, http.MethodGet, "/")
+	for i := range 3 {
+		id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			if i == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+			return nil, nil
+		}))
+		_, _ = tm.Await(ctx, id)
+	}
+
+	var stats Stats
+	get(t, h, Prefix+"/stats", &stats)
+	assertEqual(t, stats.Processed, 3)
+	assertEqual(t, stats.Slow, 1)
+
+	untrack()
+	untrack()
+	tm.Shutdown(ctx)
+
+	stats = Stats{}
+	get(t, h, Prefix+"/stats", &stats)
+	assertEqual(t, stats.Requests, 0)
+	assertEqual(t, stats.Processed, 3)
+
+	// So does their worker pool usage
+	assertEqual(t, stats.Pool.Acquired, 3)
+	assertEqual(t, stats.Pool.PeakWorkers, 1)
+	assertEqual(t, stats.Slow, 1)
+
+	// And their awaits over budget
+	tm = asynctask.NewManager(asynctask.WithAwaitBudget(time.Millisecond))
+	untrack = reg.Track(tm, http.MethodGet, "/")
+	_, err := tm.Await(ctx, tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})))
+	if !errors.Is(err, asynctask.ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got %v", err)
+	}
+	untrack()
+	tm.Shutdown(ctx)
+
+	stats = Stats{}
+	get(t, h, Prefix+"/stats", &stats)
+	assertEqual(t, stats.OverBudget, 1)
+
+	// And their tasks over the memory limit, while results count as held
+	// until the request ends
+	tm = asynctask.NewManager(asynctask.WithMaxTaskMemory(4), asynctask.WithLogger(slog.DiscardHandler))
+	untrack = reg.Track(tm, http.MethodGet, "/")
+	_, err = tm.Await(ctx, tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return "abc", nil
+	})))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = tm.Await(ctx, tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return "too large", nil
+	})))
+	if !errors.Is(err, asynctask.ErrMemoryExceeded) {
+		t.Fatalf("expected ErrMemoryExceeded, got %v", err)
+	}
+
+	stats = Stats{}
+	get(t, h, Prefix+"/stats", &stats)
+	assertEqual(t, stats.Tasks.Memory, int64(3))
+	untrack()
+	tm.Shutdown(ctx)
+
+	stats = Stats{}
+	get(t, h, Prefix+"/stats", &stats)
+	assertEqual(t, stats.OverMemory, 1)
+	assertEqual(t, stats.Tasks.Memory, int64(0))
+}
+
+// Test the dashboard is served
+func TestHandler_Dashboard(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(NewRegistry()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Prefix+"/ui", nil))
+
+	assertEqual(t, rec.Code, http.StatusOK)
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), "FrankenAsync") {
+		t.Fatalf("unexpected dashboard response: %q", rec.Header().Get("Content-Type"))
+	}
+}
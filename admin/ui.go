@@ -0,0 +1,17 @@
+package admin
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboard is a single-page task monitor polling the stats and task routes.
+//
+//go:embed ui/index.html
+var dashboard []byte
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(dashboard)
+}
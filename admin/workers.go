@@ -0,0 +1,34 @@
+package admin
+
+import "net/http"
+
+// resize sets the worker limit of the in-flight request given by the request
+// query parameter, or of every in-flight request. Requests started later
+// use the configured limit.
+func resize(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		limit, err := intParam(query.Get("limit"), 0)
+		if err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+
+		requestID := query.Get("request")
+		resized := 0
+		for manager := range reg.snapshot() {
+			if requestID != "" && manager.RequestID() != requestID {
+				continue
+			}
+			manager.Resize(limit)
+			resized++
+		}
+
+		if requestID != "" && resized == 0 {
+			writeError(w, http.StatusNotFound, "request not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"resized": resized, "limit": limit})
+	}
+}
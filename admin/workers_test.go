@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Test resizing the worker pools of in-flight requests
+func TestHandler_Resize(t *testing.T) {
+	reg := NewRegistry()
+	h := Handler(reg, WithToken("secret"))
+
+	var managers []*asynctask.Manager
+	for _, id := range []string{"req-a", "req-b"} {
+		tm := asynctask.NewManager(asynctask.WithWorkerLimit(2), asynctask.WithRequestID(id))
+		t.Cleanup(reg.Track(tm, http.MethodGet, "/"))
+		t.Cleanup(func() { tm.Shutdown(context.Background()) })
+		managers = append(managers, tm)
+	}
+
+	var body map[string]any
+	assertEqual(t, do(t, h, http.MethodPut, Prefix+"/workers?limit=4", "", &body), http.StatusUnauthorized)
+	assertEqual(t, do(t, h, http.MethodPut, Prefix+"/workers?limit=0", "secret", &body), http.StatusBadRequest)
+
+	assertEqual(t, do(t, h, http.MethodPut, Prefix+"/workers?limit=4&request=req-b", "secret", &body), http.StatusOK)
+	assertEqual(t, body["resized"], 1.0)
+	assertEqual(t, managers[0].Stats().WorkerLimit, 2)
+	assertEqual(t, managers[1].Stats().WorkerLimit, 4)
+
+	assertEqual(t, do(t, h, http.MethodPut, Prefix+"/workers?limit=6", "secret", &body), http.StatusOK)
+	assertEqual(t, body["resized"], 2.0)
+	assertEqual(t, managers[0].Stats().WorkerLimit, 6)
+
+	assertEqual(t, do(t, h, http.MethodPut, Prefix+"/workers?limit=4&request=missing", "secret", &body), http.StatusNotFound)
+}
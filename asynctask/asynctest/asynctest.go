@@ -0,0 +1,108 @@
+// Package asynctest helps test code that embeds an asynctask.Manager
+// without sleeping: managers running tasks inline, managers recording what
+// was submitted, and waiting for a task to reach a status.
+package asynctest
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// pollInterval is how often WaitForStatus checks a task's status.
+const pollInterval = time.Millisecond
+
+type (
+	// Recorder is a manager recording every task submitted to it. Tasks
+	// still run as usual.
+	Recorder struct {
+		*asynctask.Manager
+
+		mu          sync.Mutex
+		submissions []Submission
+	}
+
+	// Submission is a task submitted to a Recorder.
+	Submission struct {
+		ID       asynctask.ID
+		Runnable asynctask.Runnable
+		Labels   map[string]string
+	}
+)
+
+// NewSyncManager returns a manager running tasks on the goroutine
+// submitting them, so Async returns once the task has finished and its
+// result can be checked right away. Deferred tasks still run when awaited.
+func NewSyncManager(opts ...asynctask.Option) *asynctask.Manager {
+	return asynctask.NewManager(append(opts, asynctask.WithInlineExecution())...)
+}
+
+// NewRecorder returns a Recorder configured with opts. Combined with
+// asynctask.WithInlineExecution, tasks run inline as with NewSyncManager.
+func NewRecorder(opts ...asynctask.Option) *Recorder {
+	r := &Recorder{}
+	r.Manager = asynctask.NewManager(append(opts, asynctask.WithSubmitHandler(r.record))...)
+	return r
+}
+
+func (r *Recorder) record(ctx context.Context, id asynctask.ID, runnable asynctask.Runnable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.submissions = append(r.submissions, Submission{
+		ID:       id,
+		Runnable: runnable,
+		Labels:   maps.Clone(asynctask.LabelsFromContext(ctx)),
+	})
+}
+
+// Submissions returns the tasks submitted so far, in order.
+func (r *Recorder) Submissions() []Submission {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.submissions)
+}
+
+// Reset forgets the tasks submitted so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.submissions = nil
+}
+
+// WaitForStatus waits until the task id of tm has status, and returns its
+// future. It fails t if the task doesn't get there within timeout, or
+// finishes with another status.
+func WaitForStatus(t testing.TB, tm *asynctask.Manager, id asynctask.ID, status asynctask.Status, timeout time.Duration) asynctask.Future {
+	t.Helper()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+
+	var current asynctask.Status
+	for {
+		var err error
+		current, err = tm.Status(id)
+		if err != nil {
+			t.Fatalf("task %s: %v", id, err)
+		}
+		switch {
+		case current == status:
+			future, _ := tm.Future(id)
+			return future
+		case current == asynctask.StatusCompleted, current == asynctask.StatusFailed, current == asynctask.StatusCanceled:
+			t.Fatalf("task %s finished %s, want %s", id, current, status)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			t.Fatalf("task %s still %s after %v, want %s", id, current, timeout, status)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package asynctest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// Test a sync manager has finished the task when Async returns
+func TestSyncManager(t *testing.T) {
+	tm := NewSyncManager()
+	ctx := context.Background()
+
+	ran := false
+	id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		ran = true
+		return "done", nil
+	}))
+	assertEqual(t, ran, true)
+
+	status, err := tm.Status(id)
+	assertEqual(t, err, nil)
+	assertEqual(t, status, asynctask.StatusCompleted)
+
+	// Tasks may start tasks of their own
+	id = tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		child := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			return 2, nil
+		}))
+		result, err := tm.Await(ctx, child)
+		return result.Result.(int) * 21, err
+	}))
+	result, err := tm.Await(ctx, id)
+	assertEqual(t, err, nil)
+	assertEqual(t, result.Result, 42)
+	assertEqual(t, tm.Config().Inline, true)
+}
+
+// Test a recorder captures submitted runnables and their labels
+func TestRecorder(t *testing.T) {
+	rec := NewRecorder(asynctask.WithInlineExecution())
+	ctx := asynctask.WithLabels(context.Background(), map[string]string{"job": "email"})
+
+	runnable := asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, errors.New("boom")
+	})
+	id := rec.Async(ctx, runnable)
+	deferred := rec.Defer(ctx, runnable)
+
+	submissions := rec.Submissions()
+	assertEqual(t, len(submissions), 2)
+	assertEqual(t, submissions[0].ID, id)
+	assertEqual(t, submissions[0].Labels["job"], "email")
+	assertEqual(t, submissions[1].ID, deferred)
+	if submissions[0].Runnable == nil {
+		t.Fatal("expected the submitted runnable")
+	}
+
+	status, _ := rec.Status(id)
+	assertEqual(t, status, asynctask.StatusFailed)
+
+	rec.Reset()
+	assertEqual(t, len(rec.Submissions()), 0)
+}
+
+// Test waiting for a task to reach a status
+func TestWaitForStatus(t *testing.T) {
+	tm := asynctask.NewManager()
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return "ok", nil
+	}))
+
+	WaitForStatus(t, tm, id, asynctask.StatusRunning, time.Second)
+	close(release)
+	future := WaitForStatus(t, tm, id, asynctask.StatusCompleted, time.Second)
+	assertEqual(t, future.Result, "ok")
+}
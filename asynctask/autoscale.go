@@ -0,0 +1,89 @@
+package asynctask
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// autoscaleInterval is how often an autoscaled pool is reconsidered when
+// no submission queues.
+const autoscaleInterval = 100 * time.Millisecond
+
+// DefaultAutoscalePolicy grows the pool once submissions wait 10ms for a
+// worker slot.
+var DefaultAutoscalePolicy = QueuePolicy(10 * time.Millisecond)
+
+type (
+	// PoolState is the state of the worker pool an AutoscalePolicy decides
+	// on.
+	PoolState struct {
+		Limit   int           // current worker limit
+		Workers int           // worker slots in use
+		Waiting int           // submissions queued for a slot
+		Wait    time.Duration // how long the oldest queued submission has waited
+	}
+
+	// AutoscalePolicy returns the worker limit for the pool state. The
+	// result is clamped to the bounds given to WithAutoscale.
+	AutoscalePolicy func(PoolState) int
+
+	autoscaler struct {
+		min, max int
+		policy   AutoscalePolicy
+		mu       sync.Mutex // serializes decisions
+		done     chan struct{}
+		once     sync.Once
+	}
+)
+
+// QueuePolicy grows the pool by the number of queued submissions once the
+// oldest has waited longer than threshold, and shrinks it by one slot at a
+// time while nothing queues and fewer than half the slots are in use.
+func QueuePolicy(threshold time.Duration) AutoscalePolicy {
+	return func(p PoolState) int {
+		switch {
+		case p.Waiting > 0 && p.Wait >= threshold:
+			return p.Limit + p.Waiting
+		case p.Waiting == 0 && p.Workers < p.Limit/2:
+			return p.Limit - 1
+		}
+		return p.Limit
+	}
+}
+
+func (a *autoscaler) run(tm *Manager) {
+	for {
+		timer := tm.clock.NewTimer(autoscaleInterval)
+		select {
+		case <-a.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+			a.scale(tm)
+		}
+	}
+}
+
+// scale applies the policy to the current pool state.
+func (a *autoscaler) scale(tm *Manager) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	size, used, waiting, oldest := tm.workers.state()
+	limit := a.clamp(a.policy(PoolState{Limit: size, Workers: used, Waiting: waiting, Wait: oldest}))
+	if limit == size {
+		return
+	}
+
+	tm.workers.resize(limit)
+	tm.logger.Debug("Workers Resized", slog.Int("from", size), slog.Int("to", limit), slog.Int("waiting", waiting))
+}
+
+func (a *autoscaler) clamp(limit int) int {
+	return min(max(limit, a.min), a.max)
+}
+
+func (a *autoscaler) stop() {
+	a.once.Do(func() { close(a.done) })
+}
@@ -0,0 +1,64 @@
+package asynctask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBudgetExceeded is returned by awaits once the manager's awaits took
+// the time of its await budget, see WithAwaitBudget.
+var ErrBudgetExceeded = errors.New("await budget exceeded")
+
+// WithAwaitBudget limits the total time callers may spend awaiting the
+// manager's tasks, such as the 2s a page may wait for its fragments. The
+// time of every Await, WaitFor, AwaitAll and AwaitAny adds up; one that
+// runs into the limit stops with ErrBudgetExceeded, as Await does on a
+// timeout, and later ones fail right away. Defaults to 0, no limit.
+func WithAwaitBudget(d time.Duration) Option {
+	return func(m *Manager) {
+		if d < 0 {
+			m.invalidOption("await budget %v, must not be negative", d)
+			return
+		}
+		m.awaitBudget = d
+	}
+}
+
+// AwaitTime returns the time spent awaiting the manager's tasks, and
+// whether it ran over the await budget.
+func (tm *Manager) AwaitTime() (time.Duration, bool) {
+	return time.Duration(tm.awaited.Load()), tm.overBudget.Load() > 0
+}
+
+// budgeted runs await with ctx limited to what is left of the await
+// budget, adding the time it took to the time awaited.
+func (tm *Manager) budgeted(ctx context.Context, await func(context.Context) error) error {
+	if tm.awaitBudget <= 0 {
+		start := tm.clock.Now()
+		err := await(ctx)
+		tm.awaited.Add(int64(tm.clock.Now().Sub(start)))
+		return err
+	}
+
+	left := tm.awaitBudget - time.Duration(tm.awaited.Load())
+	if left <= 0 {
+		tm.overBudget.Add(1)
+		return fmt.Errorf("%w: awaits took %v", ErrBudgetExceeded, tm.awaitBudget)
+	}
+
+	budgetCtx, cancel := withTimeout(ctx, tm.clock, left)
+	defer cancel()
+
+	start := tm.clock.Now()
+	err := await(budgetCtx)
+	tm.awaited.Add(int64(tm.clock.Now().Sub(start)))
+
+	// Only the budget ran out when ctx itself is still live
+	if err != nil && budgetCtx.Err() != nil && ctx.Err() == nil {
+		tm.overBudget.Add(1)
+		return fmt.Errorf("%w: awaits took %v", ErrBudgetExceeded, tm.awaitBudget)
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package asynctask
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// Clock tells the time and runs timers for a manager: task timestamps,
+	// durations and prune TTLs, slot waits, autoscaling, and the backoff
+	// and timeouts of WithRetry and WithTimeout. Tests can replace it with
+	// a FakeClock instead of sleeping.
+	Clock interface {
+		Now() time.Time
+		NewTimer(d time.Duration) Timer
+		AfterFunc(d time.Duration, f func()) Timer
+	}
+
+	// Timer is a timer of a Clock, like time.Timer.
+	Timer interface {
+		// C returns the channel the time is sent on once the timer fires,
+		// nil for timers created with AfterFunc.
+		C() <-chan time.Time
+		Stop() bool
+	}
+
+	// FakeClock is a Clock for tests. Its time only moves when told to,
+	// firing the timers that come due.
+	FakeClock struct {
+		mu     sync.Mutex
+		now    time.Time
+		timers []*fakeTimer
+	}
+
+	fakeTimer struct {
+		clock *FakeClock
+		at    time.Time
+		c     chan time.Time
+		f     func()
+	}
+
+	systemClock struct{}
+
+	systemTimer struct {
+		t *time.Timer
+	}
+)
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{t: time.NewTimer(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{t: time.AfterFunc(d, f)}
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer firing once the clock has advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, make(chan time.Time, 1), nil)
+}
+
+// AfterFunc returns a timer calling f once the clock has advanced by d.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(d, nil, f)
+}
+
+// Advance moves the clock forward by d and fires the timers that come due,
+// in order. Functions of AfterFunc timers run before Advance returns.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	c.timers = slices.DeleteFunc(c.timers, func(t *fakeTimer) bool {
+		if t.at.After(c.now) {
+			return false
+		}
+		due = append(due, t)
+		return true
+	})
+	now := c.now
+	c.mu.Unlock()
+
+	slices.SortStableFunc(due, func(a, b *fakeTimer) int {
+		return a.at.Compare(b.at)
+	})
+	for _, t := range due {
+		if t.f != nil {
+			t.f()
+		} else {
+			t.c <- now
+		}
+	}
+}
+
+// Timers returns the number of timers that haven't fired or been stopped,
+// so tests can wait for a task to start its timer before advancing.
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (c *FakeClock) add(d time.Duration, ch chan time.Time, f func()) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: ch, f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	n := len(t.clock.timers)
+	t.clock.timers = slices.DeleteFunc(t.clock.timers, func(other *fakeTimer) bool {
+		return other == t
+	})
+	return len(t.clock.timers) < n
+}
+
+// withTimeout is context.WithTimeout on clock. Contexts timed by any other
+// clock than the system's have no deadline, and are canceled with
+// context.DeadlineExceeded as their cause.
+func withTimeout(ctx context.Context, clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(systemClock); ok {
+		return context.WithTimeout(ctx, timeout)
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := clock.AfterFunc(timeout, func() { cancel(context.DeadlineExceeded) })
+	return ctx, func() {
+		timer.Stop()
+		cancel(nil)
+	}
+}
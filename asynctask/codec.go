@@ -0,0 +1,192 @@
+package asynctask
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// ErrUnknownCodec is returned by LookupCodec for names no codec has.
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Names of the built-in codecs.
+const (
+	CodecJSON    = "json"
+	CodecMsgpack = "msgpack"
+	CodecPHP     = "php"
+	CodecGob     = "gob"
+)
+
+type (
+	// Codec serializes task results where they leave the manager: the
+	// PHP bridge, futures marshaled to JSON for persistence, and the
+	// gRPC API. Codecs must be safe for concurrent use.
+	Codec interface {
+		Name() string
+		Marshal(v any) ([]byte, error)
+		Unmarshal(data []byte, v any) error
+	}
+
+	// JSONCodec encodes values as JSON. Numbers decoded into an any
+	// become float64 and objects maps.
+	JSONCodec struct{}
+
+	// MsgpackCodec encodes values as MessagePack, keeping integers apart
+	// from floats and binary data apart from text. Structs use their json
+	// field names, so both codecs produce the same keys.
+	MsgpackCodec struct{}
+
+	// GobCodec encodes values with encoding/gob, for Go on both ends.
+	// Values held in an any must have their type registered with
+	// gob.Register; maps of strings to any and slices of any are.
+	GobCodec struct{}
+
+	// PHPCodec encodes values in PHP's serialize() format, which PHP
+	// reads back with unserialize() as native arrays, integers and
+	// objects. Structs use their json field names. Decoded PHP objects
+	// become maps of their properties.
+	PHPCodec struct{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		CodecJSON:    JSONCodec{},
+		CodecMsgpack: MsgpackCodec{},
+		CodecPHP:     PHPCodec{},
+		CodecGob:     GobCodec{},
+	}
+)
+
+func init() {
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+}
+
+// RegisterCodec adds c to the codecs LookupCodec finds by name, replacing
+// any codec of the same name. Futures marshaled to JSON name their codec,
+// so custom codecs must be registered to decode them.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// LookupCodec returns the codec named name. Returns ErrUnknownCodec if
+// there is none.
+func LookupCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	if c, ok := codecs[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, name)
+}
+
+// Name implements Codec.
+func (JSONCodec) Name() string { return CodecJSON }
+
+// Marshal implements Codec.
+func (JSONCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal implements Codec.
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// Name implements Codec.
+func (MsgpackCodec) Name() string { return CodecMsgpack }
+
+// Marshal implements Codec.
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Codec.
+func (MsgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// Name implements Codec.
+func (GobCodec) Name() string { return CodecGob }
+
+// Marshal implements Codec. v is encoded as an interface value, so it
+// decodes into an any as well as into its own type.
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Codec.
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	var decoded any
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded); err != nil {
+		return err
+	}
+	return assign(v, decoded)
+}
+
+// Name implements Codec.
+func (PHPCodec) Name() string { return CodecPHP }
+
+// Marshal implements Codec.
+func (PHPCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := phpSerialize(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Codec. Values other than an any are filled in
+// through their JSON form.
+func (PHPCodec) Unmarshal(data []byte, v any) error {
+	decoded, err := phpUnserialize(data)
+	if err != nil {
+		return err
+	}
+	if p, ok := v.(*any); ok {
+		*p = decoded
+		return nil
+	}
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, v)
+}
+
+// assign stores value in the variable v points to.
+func assign(v any, value any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("decode into non-pointer %T", v)
+	}
+	target := rv.Elem()
+	if value == nil {
+		target.SetZero()
+		return nil
+	}
+	rvalue := reflect.ValueOf(value)
+	if !rvalue.Type().AssignableTo(target.Type()) {
+		return fmt.Errorf("cannot decode %T into %s", value, target.Type())
+	}
+	target.Set(rvalue)
+	return nil
+}
@@ -0,0 +1,196 @@
+package asynctask
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultMaxOutput is how much of each output stream a command keeps when
+// its MaxOutput isn't set.
+const DefaultMaxOutput = 1 << 20
+
+// defaultWaitDelay is how long a canceled command gets to exit after it
+// was signaled, before it's killed.
+const defaultWaitDelay = 5 * time.Second
+
+// ErrCommandFailed is matched by the errors of commands exiting with a
+// status other than 0 that isn't mapped.
+var ErrCommandFailed = errors.New("command failed")
+
+type (
+	// CommandRunnable runs an external command as a task. When the task's
+	// context is done the command, and on Unix the processes it started,
+	// get SIGTERM, and the command is killed when it doesn't exit within
+	// WaitDelay. Its output is captured up to MaxOutput bytes per stream,
+	// the rest discarded.
+	CommandRunnable struct {
+		Name  string
+		Args  []string
+		Dir   string   // working directory, that of the process when empty
+		Env   []string // KEY=value, the environment of the process when nil
+		Stdin []byte
+
+		MaxOutput int           // bytes kept of stdout and stderr each, DefaultMaxOutput when 0
+		WaitDelay time.Duration // after the signal, 5s when 0
+		Limits    CommandLimits
+
+		// ExitErrors maps exit statuses to the errors the task fails with.
+		// A nil error makes the task succeed, for commands exiting with 1
+		// on a negative answer.
+		ExitErrors map[int]error
+	}
+
+	// CommandLimits are the resource limits of a command and the processes
+	// it starts, each unlimited when 0. They're only set on Linux, right
+	// after the command has started, so it's briefly unlimited; elsewhere
+	// commands with limits fail. A command going over CPU or FileSize gets
+	// a signal ending it, one going over the others gets errors from the
+	// calls that would.
+	CommandLimits struct {
+		CPU       time.Duration // CPU time, rounded up to seconds
+		Memory    int64         // bytes of address space
+		OpenFiles int           // open file descriptors
+		FileSize  int64         // bytes of the files it writes
+	}
+
+	// CommandResult is the result of a command task.
+	CommandResult struct {
+		ExitCode  int     `json:"exit_code"`
+		Stdout    string  `json:"stdout"`
+		Stderr    string  `json:"stderr"`
+		Truncated bool    `json:"truncated,omitempty"` // output went over MaxOutput
+		Duration  float64 `json:"duration"`            // milliseconds
+	}
+
+	// ExitError is the error of a command exiting with a status other than
+	// 0. It wraps the error the status is mapped to, or ErrCommandFailed.
+	ExitError struct {
+		Code   int
+		Stderr string // the end of it
+		Err    error
+	}
+
+	// capped keeps the first max bytes written to it.
+	capped struct {
+		buf       bytes.Buffer
+		max       int
+		truncated bool
+	}
+)
+
+// Command returns a runnable running name with args, found in the PATH
+// when it has no slashes.
+func Command(name string, args ...string) *CommandRunnable {
+	return &CommandRunnable{Name: name, Args: args}
+}
+
+// MapExit maps the exit status code to err, nil to succeed, and returns c.
+func (c *CommandRunnable) MapExit(code int, err error) *CommandRunnable {
+	if c.ExitErrors == nil {
+		c.ExitErrors = make(map[int]error)
+	}
+	c.ExitErrors[code] = err
+	return c
+}
+
+// Run runs the command, returning its *CommandResult, also when it fails
+// with an *ExitError.
+func (c *CommandRunnable) Run(ctx context.Context) (any, error) {
+	maxOutput := c.MaxOutput
+	if maxOutput <= 0 {
+		maxOutput = DefaultMaxOutput
+	}
+	stdout, stderr := &capped{max: maxOutput}, &capped{max: maxOutput}
+
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if c.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(c.Stdin)
+	}
+	cmd.WaitDelay = c.WaitDelay
+	if cmd.WaitDelay <= 0 {
+		cmd.WaitDelay = defaultWaitDelay
+	}
+	isolate(cmd)
+	if !limitsSupported && c.Limits != (CommandLimits{}) {
+		return nil, fmt.Errorf("%w: %s: resource limits: %w", ErrCommandFailed, c.Name, errors.ErrUnsupported)
+	}
+
+	clock := ClockFromContext(ctx)
+	start := clock.Now()
+	err := cmd.Start()
+	if err == nil {
+		if err = setLimits(cmd.Process.Pid, c.Limits); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			err = fmt.Errorf("resource limits: %w", err)
+		} else {
+			err = cmd.Wait()
+		}
+	}
+
+	result := &CommandResult{
+		ExitCode:  cmd.ProcessState.ExitCode(),
+		Stdout:    stdout.buf.String(),
+		Stderr:    stderr.buf.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+		Duration:  float64(clock.Now().Sub(start).Microseconds()) / 1000.0,
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return result, nil
+	case ctx.Err() != nil:
+		return result, ctx.Err()
+	case !errors.As(err, &exitErr) || result.ExitCode < 0:
+		return result, fmt.Errorf("%w: %s: %w", ErrCommandFailed, c.Name, err)
+	}
+
+	mapped, ok := c.ExitErrors[result.ExitCode]
+	if ok && mapped == nil {
+		return result, nil
+	}
+	if !ok {
+		mapped = ErrCommandFailed
+	}
+	return result, &ExitError{Code: result.ExitCode, Stderr: tail(result.Stderr, 512), Err: mapped}
+}
+
+// Error returns the mapped error, the exit status and the end of stderr.
+func (e *ExitError) Error() string {
+	msg := fmt.Sprintf("%v: exit status %d", e.Err, e.Code)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+// Unwrap returns the mapped error, or ErrCommandFailed.
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+func (w *capped) Write(p []byte) (int, error) {
+	if room := w.max - w.buf.Len(); room < len(p) {
+		w.truncated = true
+		w.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return w.buf.Write(p)
+}
+
+// tail returns the last n bytes of s.
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "..." + s[len(s)-n:]
+}
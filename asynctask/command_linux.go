@@ -0,0 +1,48 @@
+//go:build linux
+
+package asynctask
+
+import (
+	"math"
+
+	"golang.org/x/sys/unix"
+)
+
+const limitsSupported = true
+
+// setLimits sets the limits of the process pid, keeping those it inherited
+// where they're lower. The hard CPU limit is a second over the soft one,
+// so the command gets SIGXCPU before it's killed.
+func setLimits(pid int, l CommandLimits) error {
+	set := func(resource int, soft, hard uint64) error {
+		var inherited unix.Rlimit
+		if err := unix.Prlimit(pid, resource, nil, &inherited); err != nil {
+			return err
+		}
+		limit := unix.Rlimit{Cur: min(soft, inherited.Max), Max: min(hard, inherited.Max)}
+		return unix.Prlimit(pid, resource, &limit, nil)
+	}
+
+	if l.CPU > 0 {
+		seconds := uint64(math.Ceil(l.CPU.Seconds()))
+		if err := set(unix.RLIMIT_CPU, seconds, seconds+1); err != nil {
+			return err
+		}
+	}
+	if l.Memory > 0 {
+		if err := set(unix.RLIMIT_AS, uint64(l.Memory), uint64(l.Memory)); err != nil {
+			return err
+		}
+	}
+	if l.OpenFiles > 0 {
+		if err := set(unix.RLIMIT_NOFILE, uint64(l.OpenFiles), uint64(l.OpenFiles)); err != nil {
+			return err
+		}
+	}
+	if l.FileSize > 0 {
+		if err := set(unix.RLIMIT_FSIZE, uint64(l.FileSize), uint64(l.FileSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
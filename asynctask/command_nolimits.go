@@ -0,0 +1,10 @@
+//go:build !linux
+
+package asynctask
+
+const limitsSupported = false
+
+// setLimits does nothing, commands with limits fail before they start.
+func setLimits(pid int, l CommandLimits) error {
+	return nil
+}
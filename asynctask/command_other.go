@@ -0,0 +1,9 @@
+//go:build !unix
+
+package asynctask
+
+import "os/exec"
+
+// isolate leaves canceling to exec.CommandContext, which kills the
+// command alone.
+func isolate(cmd *exec.Cmd) {}
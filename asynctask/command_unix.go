@@ -0,0 +1,17 @@
+//go:build unix
+
+package asynctask
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// isolate starts the command in a process group of its own, so canceling
+// it signals the processes it started too.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+}
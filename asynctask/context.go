@@ -1,8 +1,19 @@
 package asynctask
 
-import "context"
+import (
+	"context"
+	"log/slog"
+	"maps"
+)
 
-type ctxKey struct{}
+type (
+	ctxKey     struct{}
+	labelsKey  struct{}
+	loggerKey  struct{}
+	clockKey   struct{}
+	taskIDKey  struct{}
+	requestKey struct{}
+)
 
 // WithContext stores an async task Manager in the context and returns
 // a new derived context containing it.
@@ -19,3 +30,88 @@ func FromContext(ctx context.Context) *Manager {
 	}
 	return NewManager()
 }
+
+// WithLabels returns a derived context carrying labels that are attached to
+// every task started with it. Labels already present in ctx are kept unless
+// overridden by the same key.
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	merged := maps.Clone(LabelsFromContext(ctx))
+	if merged == nil {
+		merged = make(map[string]string, len(labels))
+	}
+	maps.Copy(merged, labels)
+	return context.WithValue(ctx, labelsKey{}, merged)
+}
+
+// LabelsFromContext returns the task labels stored in ctx, or nil. The
+// returned map must not be modified.
+func LabelsFromContext(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(labelsKey{}).(map[string]string)
+	return labels
+}
+
+// withLogger returns a derived context carrying logger. Tasks receive their
+// own logger this way, tagged with their ID and captured for Manager.Logs.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger stored in ctx. Inside a task this is
+// the task's logger. If no logger is found, it returns slog.Default().
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// withClock returns a derived context carrying the clock of a task's
+// manager.
+func withClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+// ClockFromContext returns the clock stored in ctx. Inside a task this is
+// its manager's clock. If no clock is found, it returns the system clock.
+func ClockFromContext(ctx context.Context) Clock {
+	if clock, ok := ctx.Value(clockKey{}).(Clock); ok {
+		return clock
+	}
+	return systemClock{}
+}
+
+// hasLabels reports whether labels holds every key and value of want.
+func hasLabels(labels, want map[string]string) bool {
+	for key, value := range want {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// withTaskID returns a derived context carrying the ID of the task it's
+// the context of.
+func withTaskID(ctx context.Context, id ID) context.Context {
+	return context.WithValue(ctx, taskIDKey{}, id)
+}
+
+// TaskIDFromContext returns the ID of the task whose context ctx is, or
+// derives from, and false outside of tasks.
+func TaskIDFromContext(ctx context.Context) (ID, bool) {
+	id, ok := ctx.Value(taskIDKey{}).(ID)
+	return id, ok
+}
+
+// withRequest returns a derived context remembering the context of the
+// request it was bound to, whose cancellation and deadline it drops.
+func withRequest(ctx, request context.Context) context.Context {
+	return context.WithValue(ctx, requestKey{}, request)
+}
+
+// requestFromContext returns the context of the request ctx was bound to
+// with Manager.Bind.
+func requestFromContext(ctx context.Context) (context.Context, bool) {
+	request, ok := ctx.Value(requestKey{}).(context.Context)
+	return request, ok
+}
@@ -0,0 +1,71 @@
+package asynctask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInsufficientDeadline fails tasks submitted with less time left before
+// the deadline of their context than they need, see WithMinDuration.
+var ErrInsufficientDeadline = errors.New("insufficient deadline")
+
+type minDurationKey struct{}
+
+// WithMinDuration returns a derived context for tasks that need at least d
+// to be of any use, such as a report taking seconds to build. Tasks started
+// with it fail right away with ErrInsufficientDeadline when less than d is
+// left before the deadline of ctx, rather than starting work that can't
+// finish in time: a request with a 30s limit submitting one at second 29
+// learns so at once. Deferred tasks are checked as they're promoted,
+// against the deadline of the await. A d of
+// 0 declares no minimum, overriding the manager's default.
+func WithMinDuration(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, minDurationKey{}, d)
+}
+
+// WithMinTaskDuration sets the minimum duration of tasks that don't declare
+// one with WithMinDuration. Defaults to the timeout of the default policy,
+// see WithDefaultPolicy, as tasks are meant to be given that much time.
+func WithMinTaskDuration(d time.Duration) Option {
+	return func(m *Manager) {
+		if d < 0 {
+			m.invalidOption("minimum task duration %v, must not be negative", d)
+			return
+		}
+		m.minDuration = d
+	}
+}
+
+// minDurationOf returns the time a task submitted with ctx needs at least.
+func (tm *Manager) minDurationOf(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(minDurationKey{}).(time.Duration); ok {
+		return d
+	}
+	if tm.minDuration > 0 {
+		return tm.minDuration
+	}
+	if tm.policy != nil {
+		return tm.policy.timeout
+	}
+	return 0
+}
+
+// checkDeadline returns ErrInsufficientDeadline when the deadline of ctx
+// leaves a task submitted with it less than its minimum duration.
+func (tm *Manager) checkDeadline(ctx context.Context) error {
+	need := tm.minDurationOf(ctx)
+	if need <= 0 {
+		return nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	if left := deadline.Sub(tm.clock.Now()); left < need {
+		tm.refused.Add(1)
+		return fmt.Errorf("%w: %v left, task needs %v", ErrInsufficientDeadline, max(left, 0), need)
+	}
+	return nil
+}
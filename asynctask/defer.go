@@ -0,0 +1,163 @@
+package asynctask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// promotedContext is the context a deferred task runs in once awaited. It
+// carries the values of the context the task was deferred with, such as
+// its labels, logger and request, but is canceled with, and has the
+// deadline of, the context of the await promoting it: the request that
+// deferred the task may have moved on by the time it's needed, while the
+// await knows how long it will wait.
+type promotedContext struct {
+	context.Context                 // of the await
+	values          context.Context // of Defer
+}
+
+// Value returns the value of key in the context the task was deferred with.
+func (c promotedContext) Value(key any) any {
+	return c.values.Value(key)
+}
+
+// AfterFunc lets contexts derived from c be canceled with it without a
+// goroutine each, see context.AfterFunc.
+func (c promotedContext) AfterFunc(f func()) func() bool {
+	return context.AfterFunc(c.Context, f)
+}
+
+// DeferAll defers runnables as one group, returning their IDs in order:
+// awaiting any of them, or promoting it with PromoteAll, promotes them all,
+// so a page declaring its tasks up front starts all of them at their first
+// use rather than one per await. Tasks promoted along with the awaited one
+// run as WaitFor would promote them, so the await giving up doesn't cancel
+// them. See Defer.
+func (tm *Manager) DeferAll(ctx context.Context, runnables ...Runnable) []ID {
+	return tm.deferAll(ctx, runnables)
+}
+
+// PromoteAll starts the deferred tasks of taskIDs, and those deferred
+// along with them by DeferAll, without awaiting them: they run until they
+// finish, are canceled or the manager shuts down. Tasks already promoted,
+// or never deferred, are left as they are. Returns ErrTaskNotFound for the
+// tasks that don't exist or were canceled.
+func (tm *Manager) PromoteAll(taskIDs []ID) error {
+	var errs []error
+	for _, taskID := range taskIDs {
+		if _, err := tm.resolve(context.Background(), "", taskID, false); err != nil {
+			errs = append(errs, fmt.Errorf("task %s: %w", taskID.String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deferAll defers runnables with ctx, grouping them when there are several.
+// They're stored once all are created, so promoting any of them promotes
+// the whole group.
+func (tm *Manager) deferAll(ctx context.Context, runnables []Runnable) []ID {
+	ctx = tm.propagated(ctx)
+	tm.mu.Lock()
+	shuttingDown := tm.shuttingDown
+	tm.mu.Unlock()
+
+	ids := make([]ID, len(runnables))
+	group := make([]*taskRecord, 0, len(runnables))
+	for i, runnable := range runnables {
+		if shuttingDown {
+			// Return canceled task immediately if shutting down
+			rec := tm.newRecord(ctx, nil, StatusCanceled)
+			rec.result = Future{ID: rec.id, Error: ErrTaskCanceled}
+			close(rec.done)
+			tm.tasks.store(rec)
+			ids[i] = rec.id
+			continue
+		}
+		if err := tm.admit(true); err != nil {
+			rec := tm.newRecord(ctx, runnable, StatusPending)
+			tm.tasks.store(rec)
+			tm.emit(rec, Event{Type: EventSubmitted})
+			ids[i] = tm.reject(rec, err)
+			continue
+		}
+
+		rec := tm.newRecord(ctx, runnable, StatusDeferred)
+		rec.deferred = true
+		group = append(group, rec)
+		ids[i] = rec.id
+	}
+
+	for _, rec := range group {
+		if len(group) > 1 {
+			rec.group = group
+		}
+		tm.tasks.store(rec)
+		tm.emit(rec, Event{Type: EventSubmitted})
+	}
+	return ids
+}
+
+// promote starts the deferred task of rec the first time it's awaited,
+// with ctx, the context of that await, along with the others of its group.
+// Awaits that cancel the task when they give up, such as Await, have it
+// canceled with ctx; the others, such as WaitFor, leave it running, as
+// they leave the rest of the group. The promoted task is stored before rec
+// is unlocked, so Cancel either finds it or keeps it from starting, and it
+// waits for its worker slot on a goroutine of its own, so the await isn't
+// held up by the slot: it waits for the task, and gives up on it as it
+// would on a running one.
+func (tm *Manager) promote(ctx context.Context, rec *taskRecord, cancel bool) {
+	rec.once.Do(func() {
+		tm.promoteOne(ctx, rec, cancel)
+	})
+	for _, member := range rec.group {
+		if member != rec {
+			member.once.Do(func() {
+				tm.promoteOne(ctx, member, false)
+			})
+		}
+	}
+}
+
+// promoteOne starts the deferred task of rec with ctx, see promote.
+func (tm *Manager) promoteOne(ctx context.Context, rec *taskRecord, cancel bool) {
+	if !cancel {
+		ctx = context.WithoutCancel(ctx)
+	}
+
+	rec.mu.Lock()
+	if rec.detached {
+		rec.mu.Unlock()
+		return
+	}
+	promoted, taskCtx := tm.submit(promotedContext{Context: ctx, values: rec.ctx}, rec.runnable)
+	rec.promoted = promoted.id
+	rec.mu.Unlock()
+
+	tm.schedule(taskCtx, promoted, true)
+}
+
+// cancelDeferred cancels the deferred task of rec. Not promoted yet, it's
+// canceled for good and never runs; promoted, the task it was promoted to
+// is canceled instead.
+func (tm *Manager) cancelDeferred(rec *taskRecord) (CancelResult, error) {
+	rec.mu.Lock()
+	if promoted := rec.promoted; !promoted.IsZero() {
+		rec.mu.Unlock()
+		return tm.Cancel(promoted)
+	}
+	if _, ok := rec.transition(StatusCanceled); !ok {
+		rec.mu.Unlock()
+		return CancelAlreadyFinished, nil
+	}
+	rec.detached = true
+	rec.result = Future{ID: rec.id, Error: fmt.Errorf("%w", ErrTaskCanceled)}
+	rec.mu.Unlock()
+	rec.close()
+
+	tm.emit(rec, Event{Type: EventCanceled, Error: ErrTaskCanceled})
+	tm.logger.Debug("Future Canceled", slog.String("id", rec.id.String()))
+	return CancelBeforeStart, nil
+}
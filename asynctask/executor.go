@@ -0,0 +1,191 @@
+package asynctask
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Executor decides how a manager runs its tasks, see WithExecutor.
+	// The implementations are GoroutineExecutor and PoolExecutor.
+	Executor interface {
+		start(m *Manager)
+		name() string
+	}
+
+	// GoroutineExecutor is the default executor. Async waits for a worker
+	// slot, blocking the caller while the pool is full, then runs the task
+	// on a goroutine of its own.
+	GoroutineExecutor struct{}
+
+	// PoolExecutor runs tasks on a fixed number of long-lived workers,
+	// pulling them in submission order from a queue, or with
+	// WithNamespaceSharding from a queue per group of namespaces, taken in
+	// turns. Async queues the task and returns right away, even while the
+	// pool is full, so fanning out thousands of tasks takes thousands of
+	// queued records rather than goroutines blocked on the semaphore.
+	// Workers still take a slot of the task's pool before running it, so
+	// worker limits, named pools and fair scheduling apply. A task
+	// awaiting tasks it started holds its worker meanwhile: with every
+	// worker doing so, the tasks awaited never run.
+	PoolExecutor struct {
+		Workers int // the default pool's worker limit when 0
+	}
+
+	// taskQueue is the unbounded queue of a PoolExecutor. It's FIFO
+	// within a shard, with a single shard unless the manager is sharded
+	// by namespace, and workers take turns between the shards.
+	taskQueue struct {
+		shards []queueShard
+		queued atomic.Int64
+		wake   chan struct{} // tells idle workers to look for work
+		done   chan struct{} // closed once every shard is
+	}
+
+	queueShard struct {
+		mu     sync.Mutex
+		items  []func()
+		closed bool
+	}
+)
+
+// WithExecutor sets how the manager runs its tasks. Defaults to
+// GoroutineExecutor.
+func WithExecutor(e Executor) Option {
+	return func(m *Manager) {
+		if e == nil {
+			m.invalidOption("nil executor")
+			return
+		}
+		if p, ok := e.(PoolExecutor); ok && p.Workers < 0 {
+			m.invalidOption("pool executor workers %d, must not be negative", p.Workers)
+			return
+		}
+		m.executor = e
+	}
+}
+
+func (GoroutineExecutor) start(*Manager) {}
+
+func (GoroutineExecutor) name() string {
+	return "goroutine"
+}
+
+// start starts the workers of m, as many as the default pool's slots when
+// Workers isn't set.
+func (e PoolExecutor) start(m *Manager) {
+	workers := e.Workers
+	if workers == 0 {
+		workers, _, _, _ = m.workers.state()
+	}
+	shards := 1
+	if m.tasks.byNamespace {
+		shards = namespaceGroups
+	}
+	m.queue = newTaskQueue(shards, workers)
+	for i := range workers {
+		go m.queue.run(i % shards)
+	}
+}
+
+func (PoolExecutor) name() string {
+	return "pool"
+}
+
+func newTaskQueue(shards, workers int) *taskQueue {
+	return &taskQueue{
+		shards: make([]queueShard, shards),
+		wake:   make(chan struct{}, workers),
+		done:   make(chan struct{}),
+	}
+}
+
+// push queues fn in the shard of namespace, reporting false once the
+// queue is closed.
+func (q *taskQueue) push(namespace string, fn func()) bool {
+	s := &q.shards[0]
+	if len(q.shards) > 1 {
+		s = &q.shards[namespaceGroup(namespace)]
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	s.items = append(s.items, fn)
+	q.queued.Add(1)
+	s.mu.Unlock()
+
+	// A worker taking the token looks for work after fn was queued. With
+	// no room left, as many workers are about to look already.
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop takes the next function of the first shard holding any, starting at
+// *next and moving *next past it, or returns nil when all are empty.
+func (q *taskQueue) pop(next *int) func() {
+	for range len(q.shards) {
+		i := *next
+		*next = (i + 1) % len(q.shards)
+
+		s := &q.shards[i]
+		s.mu.Lock()
+		if len(s.items) > 0 {
+			fn := s.items[0]
+			s.items[0] = nil
+			s.items = s.items[1:]
+			q.queued.Add(-1)
+			s.mu.Unlock()
+			return fn
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// run is a worker, running what's queued, starting at shard next, until
+// the queue is closed and empty.
+func (q *taskQueue) run(next int) {
+	for {
+		if fn := q.pop(&next); fn != nil {
+			fn()
+			continue
+		}
+		select {
+		case <-q.wake:
+		case <-q.done:
+			// Nothing is queued once every shard is closed, but what
+			// was queued before
+			if fn := q.pop(&next); fn != nil {
+				fn()
+				continue
+			}
+			return
+		}
+	}
+}
+
+// len returns the number of queued functions.
+func (q *taskQueue) len() int {
+	return int(q.queued.Load())
+}
+
+// close refuses new functions, letting the workers exit once they've run
+// those queued.
+func (q *taskQueue) close() {
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		closed := s.closed
+		s.closed = true
+		s.mu.Unlock()
+		if closed {
+			return
+		}
+	}
+	close(q.done)
+}
@@ -0,0 +1,49 @@
+package asynctask
+
+import (
+	"log/slog"
+)
+
+// Forwarder runs tasks a saturated manager can't start right away on other
+// nodes, see WithForwarding. The cluster package implements it.
+type Forwarder interface {
+	// Route returns a runnable running the task spec describes on another
+	// node with room for it, or nil to leave the task waiting here. The
+	// runnable's result and error become the task's.
+	Route(spec Spec, labels map[string]string) Runnable
+}
+
+// WithForwarding hands tasks to f when their pool is full rather than
+// waiting for a slot. Only tasks built from a spec (see Submit) can run
+// elsewhere, so others always wait. A forwarded task runs without a slot
+// here, wrapped in the default policy, and is awaited, canceled and
+// reported like any other; Stats().Forwarded counts them.
+func WithForwarding(f Forwarder) Option {
+	return func(m *Manager) {
+		if f == nil {
+			m.invalidOption("nil forwarder")
+			return
+		}
+		m.forwarder = f
+	}
+}
+
+// route returns the runnable running rec on another node, or nil when it
+// isn't built from a spec or no node has room for it.
+func (tm *Manager) route(rec *taskRecord) Runnable {
+	if tm.forwarder == nil {
+		return nil
+	}
+	sr, ok := rec.runnable.(*specRunnable)
+	if !ok {
+		return nil
+	}
+	remote := tm.forwarder.Route(sr.spec, rec.labels)
+	if remote != nil {
+		tm.forwarded.Add(1)
+		if tm.logger.Enabled(rec.ctx, slog.LevelDebug) {
+			tm.logger.Debug("Task Forwarded", slog.String("id", rec.id.String()), slog.String("runnable", sr.spec.Name))
+		}
+	}
+	return remote
+}
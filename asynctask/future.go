@@ -0,0 +1,179 @@
+package asynctask
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+type (
+	// futureJSON is the JSON form of a Future.
+	futureJSON struct {
+		ID        ID                `json:"id"`
+		Status    string            `json:"status"`
+		Codec     string            `json:"codec,omitempty"` // of the result, json when unset
+		Result    json.RawMessage   `json:"result,omitempty"`
+		Error     *string           `json:"error"`
+		Submitted string            `json:"submitted,omitempty"`
+		Time      string            `json:"started,omitempty"`
+		Finished  string            `json:"finished,omitempty"`
+		Duration  time.Duration     `json:"duration"`
+		Wait      time.Duration     `json:"wait"`
+		Memory    int64             `json:"memory,omitempty"`
+		Offloaded bool              `json:"offloaded,omitempty"`
+		CPU       time.Duration     `json:"cpu,omitempty"`
+		Stalled   bool              `json:"stalled,omitempty"`
+		Labels    map[string]string `json:"labels,omitempty"`
+	}
+
+	// futureError is a task error read back from JSON. It still matches
+	// the sentinel its message starts with, so errors.Is(err,
+	// ErrTaskCanceled) holds for a canceled task's decoded future.
+	futureError struct {
+		msg      string
+		sentinel error
+	}
+)
+
+// futureSentinels are the errors decoded task errors can match.
+var futureSentinels = []error{ErrTaskTimeout, ErrTaskCanceled, ErrTaskPanicked, ErrTaskFailed}
+
+// resultCodec returns the codec of the future's result: that of the
+// manager it came from, JSON for futures made elsewhere.
+func (f Future) resultCodec() Codec {
+	if f.codec == nil {
+		return JSONCodec{}
+	}
+	return f.codec
+}
+
+// MarshalJSON implements json.Marshaler. The ID is a string, times are
+// RFC 3339 and omitted when unset, durations are nanoseconds and the
+// error is its message. The result is encoded by the codec of the manager
+// the future came from (see WithCodec): inline for JSON, else as base64
+// next to the name of the codec.
+func (f Future) MarshalJSON() ([]byte, error) {
+	out := futureJSON{
+		ID:        f.ID,
+		Status:    f.Status,
+		Submitted: formatTime(f.Submitted),
+		Time:      formatTime(f.Time),
+		Finished:  formatTime(f.Finished),
+		Duration:  f.Duration,
+		Wait:      f.Wait,
+		Memory:    f.Memory,
+		Offloaded: f.Offloaded,
+		CPU:       f.CPU,
+		Stalled:   f.Stalled,
+		Labels:    f.Labels,
+	}
+	if f.Result != nil {
+		codec := f.resultCodec()
+		result, err := codec.Marshal(f.Result)
+		if err != nil {
+			return nil, err
+		}
+		if codec.Name() != CodecJSON {
+			out.Codec = codec.Name()
+			if result, err = json.Marshal(result); err != nil {
+				return nil, err
+			}
+		}
+		out.Result = result
+	}
+	if f.Error != nil {
+		msg := f.Error.Error()
+		out.Error = &msg
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, reading what MarshalJSON
+// writes. The result is decoded into an any by the codec it names, which
+// must be registered (see RegisterCodec); the error keeps its message
+// only.
+func (f *Future) UnmarshalJSON(data []byte) error {
+	var in futureJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	decoded := Future{
+		ID:        in.ID,
+		Status:    in.Status,
+		Duration:  in.Duration,
+		Wait:      in.Wait,
+		Memory:    in.Memory,
+		Offloaded: in.Offloaded,
+		CPU:       in.CPU,
+		Stalled:   in.Stalled,
+		Labels:    in.Labels,
+	}
+	var err error
+	if decoded.Submitted, err = parseTime(in.Submitted); err != nil {
+		return err
+	}
+	if decoded.Time, err = parseTime(in.Time); err != nil {
+		return err
+	}
+	if decoded.Finished, err = parseTime(in.Finished); err != nil {
+		return err
+	}
+	if in.Codec != "" {
+		if decoded.codec, err = LookupCodec(in.Codec); err != nil {
+			return err
+		}
+	}
+	if len(in.Result) > 0 && string(in.Result) != "null" {
+		data := []byte(in.Result)
+		if in.Codec != "" && in.Codec != CodecJSON {
+			if err := json.Unmarshal(in.Result, &data); err != nil {
+				return err
+			}
+		}
+		if err := decoded.resultCodec().Unmarshal(data, &decoded.Result); err != nil {
+			return err
+		}
+	}
+	if in.Error != nil {
+		decoded.Error = newFutureError(*in.Error)
+	}
+
+	*f = decoded
+	return nil
+}
+
+func newFutureError(msg string) error {
+	for _, sentinel := range futureSentinels {
+		if strings.HasPrefix(msg, sentinel.Error()) {
+			return &futureError{msg: msg, sentinel: sentinel}
+		}
+	}
+	return errors.New(msg)
+}
+
+func (e *futureError) Error() string {
+	return e.msg
+}
+
+func (e *futureError) Unwrap() error {
+	return e.sentinel
+}
+
+// formatTime returns t in RFC 3339 with nanoseconds, empty for the zero
+// time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseTime parses a time written by formatTime.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,156 @@
+package asynctask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTaskStalled fails tasks that stopped calling Heartbeat, once the
+// restarts of WithStallDetection are used up.
+var ErrTaskStalled = errors.New("task stalled")
+
+type (
+	heartbeatKey struct{}
+
+	// heartbeat tracks the liveness of a running task with stall detection.
+	heartbeat struct {
+		beat    atomic.Int64 // last heartbeat, or start of the run, in Unix nanoseconds
+		stalled atomic.Bool
+
+		mu     sync.Mutex
+		cancel context.CancelCauseFunc // of the current run, with restarts
+	}
+
+	// stallWatch periodically looks for stalled tasks.
+	stallWatch struct {
+		timeout  time.Duration
+		restarts int
+		done     chan struct{}
+		once     sync.Once
+	}
+)
+
+// Heartbeat tells the manager the task running with ctx is still making
+// progress, for WithStallDetection. Long-running runnables call it between
+// steps, such as per processed batch or received chunk. It's a no-op
+// outside of tasks, and for managers without stall detection.
+func Heartbeat(ctx context.Context) {
+	if hb, ok := ctx.Value(heartbeatKey{}).(*heartbeat); ok {
+		hb.beat.Store(ClockFromContext(ctx).Now().UnixNano())
+		hb.stalled.Store(false)
+	}
+}
+
+// WithStallDetection marks running tasks that haven't called Heartbeat for
+// timeout, counting from their start, as stalled: they're logged at warn
+// level, reported with an EventStalled event and Future.Stalled, and
+// counted in Stats().Stalled, once until they beat again. Tasks running
+// longer than timeout must call Heartbeat not to be taken for stalled.
+//
+// With restarts, the run of a stalled task is canceled and started over,
+// up to restarts times, after which the task fails with ErrTaskStalled.
+// Runs that don't return once canceled are left behind, so runnables
+// wedged on I/O don't hold up their task. With 0, stalled tasks are only
+// reported.
+func WithStallDetection(timeout time.Duration, restarts int) Option {
+	return func(m *Manager) {
+		if timeout <= 0 {
+			m.invalidOption("stall timeout %v, must be positive", timeout)
+			return
+		}
+		if restarts < 0 {
+			m.invalidOption("stall restarts %d, must not be negative", restarts)
+			return
+		}
+		m.stall = &stallWatch{timeout: timeout, restarts: restarts, done: make(chan struct{})}
+	}
+}
+
+// watched wraps the runnable of a task with stall detection, tracking its
+// liveness in hb and restarting it when it stalls.
+func (tm *Manager) watched(hb *heartbeat, runnable Runnable) Runnable {
+	return RunnableFunc(func(ctx context.Context) (any, error) {
+		ctx = context.WithValue(ctx, heartbeatKey{}, hb)
+		for restarts := 0; ; restarts++ {
+			hb.beat.Store(tm.clock.Now().UnixNano())
+			hb.stalled.Store(false)
+			if tm.stall.restarts == 0 {
+				return runnable.Run(ctx)
+			}
+
+			runCtx, cancel := context.WithCancelCause(ctx)
+			hb.mu.Lock()
+			hb.cancel = cancel
+			hb.mu.Unlock()
+
+			result, err := runBound(runCtx, runnable, nil)
+			stalled := errors.Is(context.Cause(runCtx), ErrTaskStalled) && ctx.Err() == nil
+			cancel(nil)
+			if !stalled {
+				return result, err
+			}
+			if restarts == tm.stall.restarts {
+				return nil, fmt.Errorf("%w: no heartbeat for %v, restarted %d times", ErrTaskStalled, tm.stall.timeout, restarts)
+			}
+
+			countAttempt(ctx)
+			LoggerFromContext(ctx).Warn("Task Restarted", slog.Int("restart", restarts+1), slog.Duration("timeout", tm.stall.timeout))
+		}
+	})
+}
+
+// run checks for stalled tasks until stopped, a few times per timeout.
+func (w *stallWatch) run(tm *Manager) {
+	for {
+		timer := tm.clock.NewTimer(w.timeout / 4)
+		select {
+		case <-w.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+			w.check(tm)
+		}
+	}
+}
+
+// check reports the running tasks that just stalled, canceling their run
+// when they're restarted.
+func (w *stallWatch) check(tm *Manager) {
+	now := tm.clock.Now()
+	for _, rec := range tm.tasks.snapshot() {
+		hb := rec.heartbeat.Load()
+		if hb == nil || rec.loadStatus() != StatusRunning {
+			continue
+		}
+		idle := now.Sub(time.Unix(0, hb.beat.Load()))
+		if idle < w.timeout || !hb.stalled.CompareAndSwap(false, true) {
+			continue
+		}
+
+		tm.stalled.Add(1)
+		tm.logger.Warn("Task Stalled", slog.String("id", rec.id.String()), slog.Duration("idle", idle))
+		if tm.events != nil {
+			ev := Event{Type: EventStalled, ID: rec.id, Time: now}
+			if len(rec.labels) > 0 {
+				ev.Labels = rec.labels
+			}
+			tm.events(ev)
+		}
+
+		hb.mu.Lock()
+		cancel := hb.cancel
+		hb.mu.Unlock()
+		if cancel != nil {
+			cancel(fmt.Errorf("%w: no heartbeat for %v", ErrTaskStalled, idle))
+		}
+	}
+}
+
+func (w *stallWatch) stop() {
+	w.once.Do(func() { close(w.done) })
+}
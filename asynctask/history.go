@@ -0,0 +1,141 @@
+package asynctask
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+type (
+	// History keeps the most recent finished tasks of the managers given it
+	// with WithHistory, beyond Prune and the managers themselves, so what
+	// happened a while ago can be looked up without a task store. It's a
+	// ring of a fixed capacity, dropping the oldest task once full.
+	History struct {
+		mu       sync.Mutex
+		entries  []HistoryEntry
+		capacity int
+		next     int // oldest entry once the ring is full
+	}
+
+	// HistoryEntry is a finished task as the history keeps it: what ran,
+	// for whom, when and how it ended. Its result isn't kept.
+	HistoryEntry struct {
+		ID        ID
+		Name      string // of the spec the task was built from, see Submit
+		RequestID string // of the manager, see WithRequestID
+		Labels    map[string]string
+		Status    Status
+		Submitted time.Time
+		Started   time.Time // zero for tasks canceled before they started
+		Finished  time.Time
+		Duration  time.Duration
+		Wait      time.Duration
+		Attempts  int
+		Error     string
+	}
+)
+
+// NewHistory returns a history keeping the last capacity finished tasks.
+// A capacity below 1 keeps one.
+func NewHistory(capacity int) *History {
+	return &History{capacity: max(capacity, 1)}
+}
+
+// WithHistory records every task of the manager in h as it finishes. A
+// history may be shared by many managers, such as those of every request
+// of a server.
+func WithHistory(h *History) Option {
+	return func(m *Manager) {
+		if h == nil {
+			m.invalidOption("nil history")
+			return
+		}
+		m.history = h
+	}
+}
+
+// History returns the tasks of the manager's history, most recently
+// finished first, including those of other managers sharing it. Returns
+// nil without WithHistory.
+func (tm *Manager) History() []HistoryEntry {
+	if tm.history == nil {
+		return nil
+	}
+	return tm.history.Entries()
+}
+
+// Entries returns the tasks of the history, most recently finished first.
+func (h *History) Entries() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]HistoryEntry, 0, len(h.entries))
+	for i := h.next - 1; i >= 0; i-- {
+		entries = append(entries, h.entries[i])
+	}
+	for i := len(h.entries) - 1; i >= h.next; i-- {
+		entries = append(entries, h.entries[i])
+	}
+	return entries
+}
+
+// Capacity returns how many tasks the history keeps.
+func (h *History) Capacity() int {
+	return h.capacity
+}
+
+func (h *History) add(entry HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) < h.capacity {
+		h.entries = append(h.entries, entry)
+		return
+	}
+
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % h.capacity
+}
+
+// record adds rec to the history, if any, once it finished. Records are
+// reused, so the entry copies what it keeps.
+func (tm *Manager) record(rec *taskRecord) {
+	if tm.history == nil {
+		return
+	}
+	status := rec.loadStatus()
+	if !status.finished() {
+		return
+	}
+
+	future := rec.future()
+	entry := HistoryEntry{
+		ID:        rec.id,
+		RequestID: tm.requestID,
+		Labels:    maps.Clone(rec.labels),
+		Status:    status,
+		Submitted: future.Submitted,
+		Started:   future.Time,
+		Finished:  future.Finished,
+		Duration:  future.Duration,
+		Wait:      future.Wait,
+		Attempts:  int(rec.attempts.Load()),
+	}
+	if sr, ok := rec.runnable.(*specRunnable); ok {
+		entry.Name = sr.spec.Name
+	}
+	if future.Error != nil {
+		entry.Error = future.Error.Error()
+	}
+	tm.history.add(entry)
+}
+
+// historyCapacity returns the capacity of the manager's history, 0
+// without one.
+func (tm *Manager) historyCapacity() int {
+	if tm.history == nil {
+		return 0
+	}
+	return tm.history.Capacity()
+}
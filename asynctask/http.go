@@ -0,0 +1,252 @@
+package asynctask
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultHTTPHostLimit is how many requests of HTTPRequest runnables run
+// at a time per host, until SetHTTPHostLimit changes it.
+const DefaultHTTPHostLimit = 16
+
+// maxRetryAfter caps how long HTTPRequest waits for a Retry-After before
+// giving up, when its spec doesn't set MaxRetryAfter.
+const maxRetryAfter = 30 * time.Second
+
+// maxErrorBody caps the response body quoted in a StatusError.
+const maxErrorBody = 512
+
+// ErrUnexpectedStatus is matched by the errors of responses whose status
+// the spec doesn't expect.
+var ErrUnexpectedStatus = errors.New("unexpected status")
+
+type (
+	// HTTPSpec describes an outbound HTTP request. Only Decode doesn't
+	// marshal, so specs can be stored and sent as they are.
+	HTTPSpec struct {
+		Method string      `json:"method,omitempty"` // GET when empty
+		URL    string      `json:"url"`
+		Header http.Header `json:"header,omitempty"`
+		Body   []byte      `json:"body,omitempty"`
+
+		// Expect lists the statuses that succeed, 2xx when empty
+		Expect []int `json:"expect,omitempty"`
+
+		// Retries is how often 429 and 503 responses are retried, after
+		// their Retry-After or a second per attempt without one. A
+		// Retry-After over MaxRetryAfter (30s when 0) isn't waited for.
+		Retries       int           `json:"retries,omitempty"`
+		MaxRetryAfter time.Duration `json:"max_retry_after,omitempty"`
+
+		// Decode turns an expected response into the task result, with the
+		// body still to be read. Without it the result is an *HTTPResponse.
+		Decode func(*http.Response) (any, error) `json:"-"`
+	}
+
+	// HTTPResponse is the default result of an HTTPRequest runnable.
+	HTTPResponse struct {
+		Status int         `json:"status"`
+		Header http.Header `json:"header"`
+		Body   []byte      `json:"body"`
+	}
+
+	// StatusError is the error of a response with a status the spec
+	// doesn't expect. It wraps ErrUnexpectedStatus.
+	StatusError struct {
+		Method string
+		URL    string
+		Status int
+		Body   string // the start of it
+	}
+
+	// hostLimits holds a semaphore per host, limiting concurrent requests.
+	hostLimits struct {
+		mu    sync.Mutex
+		limit int
+		hosts map[string]*semaphore
+	}
+)
+
+// httpClient is shared by every HTTPRequest runnable, so connections are
+// pooled across tasks and requests.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        256,
+		MaxIdleConnsPerHost: DefaultHTTPHostLimit,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+var httpHosts = &hostLimits{limit: DefaultHTTPHostLimit, hosts: make(map[string]*semaphore)}
+
+// HTTPRequest returns a runnable making the request of spec with a client
+// shared by all of them, at most SetHTTPHostLimit requests per host at a
+// time. It fails with a *StatusError for responses with an unexpected
+// status. Retries are timed by the clock from ClockFromContext.
+func HTTPRequest(spec HTTPSpec) Runnable {
+	return RunnableFunc(func(ctx context.Context) (any, error) {
+		clock := ClockFromContext(ctx)
+		for attempt := 0; ; attempt++ {
+			result, retryAfter, err := spec.do(ctx, attempt)
+			if retryAfter < 0 || attempt >= spec.Retries {
+				return result, err
+			}
+
+			limit := spec.MaxRetryAfter
+			if limit <= 0 {
+				limit = maxRetryAfter
+			}
+			if retryAfter > limit {
+				return result, err
+			}
+
+			timer := clock.NewTimer(retryAfter)
+			select {
+			case <-timer.C():
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+	})
+}
+
+// SetHTTPHostLimit sets how many requests of HTTPRequest runnables run at a
+// time per host. Lowering it takes effect as running requests finish.
+func SetHTTPHostLimit(limit int) {
+	httpHosts.mu.Lock()
+	defer httpHosts.mu.Unlock()
+	httpHosts.limit = max(limit, 1)
+	for _, sem := range httpHosts.hosts {
+		sem.resize(httpHosts.limit)
+	}
+}
+
+// DecodeJSON is an HTTPSpec.Decode decoding the response body as JSON.
+func DecodeJSON(resp *http.Response) (any, error) {
+	var v any
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return nil, fmt.Errorf("decode %s response: %w", resp.Request.URL.Redacted(), err)
+	}
+	return v, nil
+}
+
+// do makes the attempt numbered from 0. retryAfter is -1 for responses
+// not to retry, and otherwise how long to wait before the next attempt.
+func (spec HTTPSpec) do(ctx context.Context, attempt int) (result any, retryAfter time.Duration, err error) {
+	method := spec.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if spec.Body != nil {
+		body = bytes.NewReader(spec.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, spec.URL, body)
+	if err != nil {
+		return nil, -1, err
+	}
+	for name, values := range spec.Header {
+		req.Header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+	}
+
+	release, err := httpHosts.acquire(ctx, req.URL.Host)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer release()
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer resp.Body.Close()
+
+	if !spec.expects(resp.StatusCode) {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		err := &StatusError{Method: method, URL: req.URL.Redacted(), Status: resp.StatusCode, Body: string(data)}
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			return nil, -1, err
+		}
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return nil, retryAfter, err
+		}
+		return nil, time.Duration(attempt+1) * time.Second, err
+	}
+
+	if spec.Decode != nil {
+		result, err := spec.Decode(resp)
+		return result, -1, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, -1, err
+	}
+	return &HTTPResponse{Status: resp.StatusCode, Header: resp.Header, Body: data}, -1, nil
+}
+
+// expects reports whether status succeeds.
+func (spec HTTPSpec) expects(status int) bool {
+	if len(spec.Expect) == 0 {
+		return status >= 200 && status < 300
+	}
+	return slices.Contains(spec.Expect, status)
+}
+
+// parseRetryAfter returns the wait a Retry-After header asks for, in
+// seconds or as an HTTP date. Reports false when it's missing or invalid.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// Error returns the request, the status and the start of the body.
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%v: %s %s: %d %s", ErrUnexpectedStatus, e.Method, e.URL, e.Status, http.StatusText(e.Status))
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Unwrap returns ErrUnexpectedStatus.
+func (e *StatusError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+// acquire takes a request slot of host, returning the function giving it
+// back. Fails once ctx is done first.
+func (l *hostLimits) acquire(ctx context.Context, host string) (func(), error) {
+	l.mu.Lock()
+	sem, ok := l.hosts[host]
+	if !ok {
+		sem = newSemaphore(l.limit)
+		l.hosts[host] = sem
+	}
+	l.mu.Unlock()
+
+	if !sem.tryAcquire() {
+		if err := sem.wait(ctx, sem.enqueue("")); err != nil {
+			return nil, err
+		}
+	}
+	slot := sem.take()
+	return func() { sem.release(slot) }, nil
+}
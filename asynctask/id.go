@@ -0,0 +1,145 @@
+package asynctask
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// ErrInvalidID is returned by ParseID for strings that aren't task IDs.
+var ErrInvalidID = errors.New("invalid task ID")
+
+// crockford is the base32 alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type (
+	// ID represents a unique identifier for an async task. It holds an
+	// xid, a ULID or a UUID in its canonical string form; the zero ID
+	// is no task.
+	ID struct {
+		s string
+	}
+
+	// IDGenerator returns a new task ID, in one of the forms ParseID
+	// accepts. It must be safe for concurrent use.
+	IDGenerator func() string
+)
+
+// ParseID parses a task ID: an xid, a ULID or a UUID. Returns
+// ErrInvalidID for anything else.
+func ParseID(s string) (ID, error) {
+	switch len(s) {
+	case 20:
+		if _, err := xid.FromString(s); err == nil {
+			return ID{s: s}, nil
+		}
+	case 26:
+		s = strings.ToUpper(s)
+		if s[0] <= '7' && strings.Trim(s, crockford) == "" {
+			return ID{s: s}, nil
+		}
+	case 36:
+		s = strings.ToLower(s)
+		if s[8] == '-' && s[13] == '-' && s[18] == '-' && s[23] == '-' &&
+			strings.Trim(strings.ReplaceAll(s, "-", ""), "0123456789abcdef") == "" {
+			return ID{s: s}, nil
+		}
+	}
+	return ID{}, fmt.Errorf("%w: %q", ErrInvalidID, s)
+}
+
+// String returns a string representation of a task ID
+func (id ID) String() string {
+	return id.s
+}
+
+// IsZero reports whether id is the zero ID.
+func (id ID) IsZero() bool {
+	return id.s == ""
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.s), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Empty text is the
+// zero ID.
+func (id *ID) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*id = ID{}
+		return nil
+	}
+	parsed, err := ParseID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// NewXID returns an xid, the default task ID.
+func NewXID() string {
+	return xid.New().String()
+}
+
+// NewULID returns a ULID: 48 bits of milliseconds and 80 random bits.
+func NewULID() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	_, _ = rand.Read(b[6:])
+
+	// 26 characters of 5 bits cover 130 bits, the first 2 of them zero
+	var out [26]byte
+	for i := range out {
+		bit := i*5 - 2
+		var v uint16
+		for j := range 5 {
+			if n := bit + j; n >= 0 {
+				v = v<<1 | uint16(b[n/8]>>(7-n%8)&1)
+			} else {
+				v <<= 1
+			}
+		}
+		out[i] = crockford[v]
+	}
+	return string(out[:])
+}
+
+// NewUUIDv7 returns a version 7 UUID: 48 bits of milliseconds and 74
+// random bits.
+func NewUUIDv7() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+	_, _ = rand.Read(b[6:])
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var out [36]byte
+	hex.Encode(out[0:8], b[0:4])
+	hex.Encode(out[9:13], b[4:6])
+	hex.Encode(out[14:18], b[6:8])
+	hex.Encode(out[19:23], b[8:10])
+	hex.Encode(out[24:36], b[10:16])
+	out[8], out[13], out[18], out[23] = '-', '-', '-', '-'
+	return string(out[:])
+}
+
+// SequenceIDs returns a generator of deterministic IDs for tests: the
+// xids holding 1, 2, 3 and so on. IDs of managers using their own
+// sequence collide.
+func SequenceIDs() IDGenerator {
+	var n atomic.Uint64
+	return func() string {
+		var id xid.ID
+		binary.BigEndian.PutUint64(id[4:], n.Add(1))
+		return id.String()
+	}
+}
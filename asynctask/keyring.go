@@ -0,0 +1,143 @@
+package asynctask
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedMagic starts data sealed by a Keyring, followed by the length and
+// ID of the key, the nonce and the ciphertext.
+const sealedMagic = "FAS1"
+
+var (
+	ErrInvalidKey = errors.New("invalid encryption key")
+	ErrUnsealable = errors.New("sealed data can't be opened")
+)
+
+type (
+	// Keyring encrypts the task payloads and results stores persist with
+	// AES-GCM. The first key seals, and every key opens data sealed with
+	// it, so keys are rotated by adding a new one in front and dropping the
+	// old one once no data sealed with it is left.
+	Keyring struct {
+		keys []keyringKey
+	}
+
+	keyringKey struct {
+		id   string
+		aead cipher.AEAD
+	}
+)
+
+// NewKeyring returns a keyring of AES keys of 16, 24 or 32 bytes by ID.
+// The first key seals. IDs are at most 255 bytes and must be unique.
+func NewKeyring(ids []string, keys [][]byte) (*Keyring, error) {
+	if len(ids) == 0 || len(ids) != len(keys) {
+		return nil, fmt.Errorf("%w: %d IDs for %d keys", ErrInvalidKey, len(ids), len(keys))
+	}
+	k := &Keyring{}
+	for i, id := range ids {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("%w: ID %q must be 1 to 255 bytes", ErrInvalidKey, id)
+		}
+		if k.key(id) != nil {
+			return nil, fmt.Errorf("%w: ID %q used twice", ErrInvalidKey, id)
+		}
+		block, err := aes.NewCipher(keys[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, id, err)
+		}
+		k.keys = append(k.keys, keyringKey{id: id, aead: aead})
+	}
+	return k, nil
+}
+
+// ParseKeyring returns the keyring of keys given as "id:base64", as in
+// the tasks.encryption_keys setting, the first sealing.
+func ParseKeyring(specs []string) (*Keyring, error) {
+	ids := make([]string, len(specs))
+	keys := make([][]byte, len(specs))
+	for i, spec := range specs {
+		id, encoded, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q isn't id:base64", ErrInvalidKey, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s isn't base64", ErrInvalidKey, id)
+		}
+		ids[i], keys[i] = id, key
+	}
+	return NewKeyring(ids, keys)
+}
+
+// Seal encrypts data with the first key.
+func (k *Keyring) Seal(data []byte) ([]byte, error) {
+	key := k.keys[0]
+	header := make([]byte, 0, len(sealedMagic)+1+len(key.id))
+	header = append(header, sealedMagic...)
+	header = append(header, byte(len(key.id)))
+	header = append(header, key.id...)
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(header, nonce...)
+	return key.aead.Seal(sealed, nonce, data, header), nil
+}
+
+// Open decrypts data sealed by a key of the keyring. Data that isn't
+// sealed, such as that persisted before encryption was turned on, is
+// returned as is. Returns ErrUnsealable for data sealed with an unknown
+// key or tampered with.
+func (k *Keyring) Open(data []byte) ([]byte, error) {
+	if !Sealed(data) {
+		return data, nil
+	}
+	rest := data[len(sealedMagic):]
+	if len(rest) == 0 || len(rest) < 1+int(rest[0]) {
+		return nil, fmt.Errorf("%w: truncated", ErrUnsealable)
+	}
+	id := string(rest[1 : 1+rest[0]])
+	key := k.key(id)
+	if key == nil {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrUnsealable, id)
+	}
+
+	header := data[:len(sealedMagic)+1+len(id)]
+	rest = data[len(header):]
+	if len(rest) < key.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: truncated", ErrUnsealable)
+	}
+	nonce, ciphertext := rest[:key.aead.NonceSize()], rest[key.aead.NonceSize():]
+	plain, err := key.aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsealable, err)
+	}
+	return plain, nil
+}
+
+// Sealed reports whether data was sealed by a Keyring.
+func Sealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedMagic))
+}
+
+func (k *Keyring) key(id string) *keyringKey {
+	for i := range k.keys {
+		if k.keys[i].id == id {
+			return &k.keys[i]
+		}
+	}
+	return nil
+}
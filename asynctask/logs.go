@@ -0,0 +1,216 @@
+package asynctask
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLogCapacity is the number of log records kept per task.
+	DefaultLogCapacity = 50
+
+	// failureLogTail is the number of records attached to a failed task's error.
+	failureLogTail = 10
+)
+
+type (
+	// LogRecord is a log record captured from a task's logger.
+	LogRecord struct {
+		Time    time.Time      `json:"time"`
+		Level   string         `json:"level"`
+		Message string         `json:"message"`
+		Attrs   map[string]any `json:"attrs,omitempty"`
+	}
+
+	// LoggedError is the error of a failed task that logged, carrying the
+	// last records it logged.
+	LoggedError struct {
+		Err  error
+		Logs []LogRecord
+	}
+
+	// logBuffer is a ring of the most recent records of one task, grown
+	// on demand so tasks that don't log cost next to nothing
+	logBuffer struct {
+		mu       sync.Mutex
+		records  []LogRecord
+		capacity int
+		next     int // oldest record once the ring is full
+	}
+
+	// captureHandler records every log record into a buffer before passing
+	// it on to the manager's handler
+	captureHandler struct {
+		next   slog.Handler
+		buf    *logBuffer
+		attrs  []slog.Attr
+		prefix string
+	}
+)
+
+// Error returns the message of the underlying error.
+func (e *LoggedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *LoggedError) Unwrap() error {
+	return e.Err
+}
+
+func newLogBuffer(capacity int) *logBuffer {
+	return &logBuffer{capacity: capacity}
+}
+
+func (b *logBuffer) add(record LogRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.records) < b.capacity {
+		b.records = append(b.records, record)
+		return
+	}
+
+	b.records[b.next] = record
+	b.next = (b.next + 1) % b.capacity
+}
+
+// tail returns up to n of the most recent records, oldest first. A
+// negative n returns all of them.
+func (b *logBuffer) tail(n int) []LogRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	records := make([]LogRecord, 0, len(b.records))
+	records = append(records, b.records[b.next:]...)
+	records = append(records, b.records[:b.next]...)
+
+	if n >= 0 && len(records) > n {
+		records = records[len(records)-n:]
+	}
+	return records
+}
+
+// Enabled captures every level, whatever the next handler keeps.
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *captureHandler) Handle(ctx context.Context, r slog.Record) error {
+	record := LogRecord{
+		Time:    r.Time,
+		Level:   r.Level.String(),
+		Message: r.Message,
+	}
+
+	if len(h.attrs) > 0 || r.NumAttrs() > 0 {
+		record.Attrs = make(map[string]any, len(h.attrs)+r.NumAttrs())
+		for _, attr := range h.attrs {
+			addAttr(record.Attrs, "", attr)
+		}
+		r.Attrs(func(attr slog.Attr) bool {
+			addAttr(record.Attrs, h.prefix, attr)
+			return true
+		})
+	}
+
+	h.buf.add(record)
+
+	if h.next.Enabled(ctx, r.Level) {
+		return h.next.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefixed := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	prefixed = append(prefixed, h.attrs...)
+	for _, attr := range attrs {
+		attr.Key = h.prefix + attr.Key
+		prefixed = append(prefixed, attr)
+	}
+	return &captureHandler{next: h.next.WithAttrs(attrs), buf: h.buf, attrs: prefixed, prefix: h.prefix}
+}
+
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &captureHandler{next: h.next.WithGroup(name), buf: h.buf, attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+// addAttr flattens attr into attrs, joining group keys with dots.
+func addAttr(attrs map[string]any, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, a := range value.Group() {
+			addAttr(attrs, prefix, a)
+		}
+		return
+	}
+	if attr.Key == "" {
+		return
+	}
+	attrs[prefix+attr.Key] = value.Any()
+}
+
+// Logs returns the records captured from the logger of taskID, oldest
+// first. Deferred tasks report the logs of their execution once awaited.
+func (tm *Manager) Logs(taskID ID) ([]LogRecord, error) {
+	rec, ok := tm.tasks.load(taskID)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+
+	// Deferred tasks log under the ID they were promoted to
+	if rec.deferred {
+		promotedID := rec.promotedID()
+		if promotedID.IsZero() {
+			return nil, nil
+		}
+		return tm.Logs(promotedID)
+	}
+
+	rec.mu.Lock()
+	buf := rec.logs
+	rec.mu.Unlock()
+	if buf == nil {
+		return nil, nil
+	}
+	return buf.tail(-1), nil
+}
+
+// taskLogger returns the logger handed to the task of rec through its
+// context, capturing its records when log capture is enabled.
+func (tm *Manager) taskLogger(rec *taskRecord) *slog.Logger {
+	logger := tm.logger.With(slog.String("task_id", rec.id.String()))
+	if tm.logCapacity <= 0 {
+		return logger
+	}
+
+	buf := newLogBuffer(tm.logCapacity)
+	rec.mu.Lock()
+	rec.logs = buf
+	rec.mu.Unlock()
+
+	return slog.New(&captureHandler{next: logger.Handler(), buf: buf})
+}
+
+// withLogTail attaches the last records the task of rec logged to err.
+func withLogTail(rec *taskRecord, err error) error {
+	rec.mu.Lock()
+	buf := rec.logs
+	rec.mu.Unlock()
+	if buf == nil {
+		return err
+	}
+	if logs := buf.tail(failureLogTail); len(logs) > 0 {
+		return &LoggedError{Err: err, Logs: logs}
+	}
+	return err
+}
@@ -1,24 +1,54 @@
 package asynctask
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/rs/xid"
 )
 
+// ProfileLabel is the pprof label holding the ID of the task a goroutine
+// runs for.
+const ProfileLabel = "task_id"
+
 var (
 	ErrTaskTimeout  = errors.New("task timed out")
 	ErrTaskFailed   = errors.New("task failed")
 	ErrTaskNotFound = errors.New("task not found")
 	ErrTaskCanceled = errors.New("task canceled")
 	ErrTaskPanicked = errors.New("task panicked")
+
+	ErrTaskNotRetryable  = errors.New("task not retryable")
+	ErrTaskNotReplayable = errors.New("task not replayable")
+	ErrPoolNotFound      = errors.New("pool not found")
+	ErrQuotaExceeded     = errors.New("task quota exceeded")
+	ErrInvalidOption     = errors.New("invalid option")
+	ErrCircuitOpen       = errors.New("circuit open")
+)
+
+const (
+	EventSubmitted EventType = "submitted"
+	EventStarted   EventType = "started"
+	EventCompleted EventType = "completed"
+	EventFailed    EventType = "failed"
+	EventCanceled  EventType = "canceled"
+	EventStalled   EventType = "stalled" // no heartbeat, see WithStallDetection
+)
+
+const (
+	CancelNotFound        CancelResult = iota // the task doesn't exist
+	CancelBeforeStart                         // deferred or pending, it never ran
+	CancelWhileRunning                        // told to stop, it reports its own outcome
+	CancelAlreadyFinished                     // finished first, its result is kept
 )
 
 const (
@@ -32,20 +62,76 @@ const (
 )
 
 type (
-	// ID represents a unique identifier for an async task.
-	ID xid.ID
-
 	// Status represents the current state of a task
 	Status int
 
-	// Future holds the result of an async task
+	// Future holds the result of an async task. It marshals to JSON with
+	// all of its fields; see MarshalJSON.
 	Future struct {
-		ID       ID            `json:"-"`
-		Result   any           `json:"-"`
-		Time     time.Time     `json:"-"`
-		Error    error         `json:"error"`
-		Duration time.Duration `json:"duration"`
-		Status   string        `json:"status"`
+		ID        ID
+		Result    any
+		Submitted time.Time
+		Time      time.Time // started running
+		Finished  time.Time
+		Error     error
+		Duration  time.Duration
+		Wait      time.Duration // spent waiting for a worker slot
+		Memory    int64         // approximate bytes held by the result
+		Offloaded bool          // result kept in the result store, see WithResultOffload
+		CPU       time.Duration // thread CPU time, with WithProfiling
+		Stalled   bool          // no heartbeat for the stall timeout, see WithStallDetection
+		Status    string
+		Labels    map[string]string
+
+		codec Codec // of the manager it came from, nil for JSON
+	}
+
+	// CancelResult tells what Cancel did to a task
+	CancelResult int
+
+	// PanicError is the error of a task that panicked, carrying the
+	// recovered value and the stack of the panicking goroutine.
+	PanicError struct {
+		Value any
+		Stack []byte
+	}
+
+	// RecoveredError is the error WithRecover turns a panic into: the
+	// error its mapping returned for the recovered value, with the stack
+	// of the panicking goroutine. WithRetry only retries it when the
+	// mapping marked it with Retryable.
+	RecoveredError struct {
+		Err       error
+		Value     any
+		Stack     []byte
+		Retryable bool
+	}
+
+	// retryableError marks an error WithRetry retries even though it
+	// came from a panic.
+	retryableError struct {
+		error
+	}
+
+	// permanentError marks an error WithRetry doesn't retry.
+	permanentError struct {
+		error
+	}
+
+	// EventType names a task lifecycle transition
+	EventType string
+
+	// Event describes a task lifecycle transition. Duration and Error are
+	// only set when the task finishes, and Result when it completes with a
+	// result that wasn't offloaded.
+	Event struct {
+		Type     EventType
+		ID       ID
+		Labels   map[string]string
+		Time     time.Time
+		Duration time.Duration
+		Error    error
+		Result   any
 	}
 
 	// Runnable allows any struct to define its own async logic
@@ -59,58 +145,254 @@ type (
 	// Manager orchestrates concurrent task execution with worker pool management,
 	// task lifecycle tracking, and graceful shutdown. All operations are thread-safe.
 	Manager struct {
-		tasks        sync.Map // taskID -> *asyncTask or *deferredTask
-		tasksResult  sync.Map // taskID -> Future
-		tasksCancel  sync.Map // taskID -> context.CancelFunc
-		taskStatuses sync.Map // taskID -> Status
-
-		workerLimit     int
-		workerSemaphore chan struct{}
-
-		logger *slog.Logger
+		tasks taskTable
+
+		workers   *semaphore
+		pools     map[string]*semaphore // named pools, selected by PoolLabel
+		autoscale *autoscaler
+		fairLabel string // label whose values share queued slots, if any
+		shares    map[string]int
+
+		shardLabel string // label whose values shard tasks, if any
+
+		// Quotas, unlimited when zero
+		maxTasks  int
+		budget    time.Duration
+		submitted atomic.Int64
+		spent     atomic.Int64 // nanoseconds run by finished tasks
+
+		// Time spent awaiting tasks, limited by awaitBudget when set
+		awaitBudget time.Duration
+		awaited     atomic.Int64 // nanoseconds
+		overBudget  atomic.Int64 // awaits failed with ErrBudgetExceeded
+
+		// Bytes a task's result may hold, unlimited when zero
+		maxMemory  int64
+		overMemory atomic.Int64 // tasks failed with ErrMemoryExceeded
+
+		// Store of results over offloadThreshold bytes, if any
+		results          ResultStore
+		offloadThreshold int64
+		offloadTTL       time.Duration
+
+		// Worker pool utilization, reported by Stats
+		acquired    atomic.Int64
+		peakWorkers atomic.Int64
+		waitTotal   atomic.Int64 // nanoseconds
+		waitMax     atomic.Int64 // nanoseconds
+
+		// Rates and durations of finished tasks, reported by Stats
+		throughput throughput
+
+		slowThreshold time.Duration // 0 disables slow task detection
+		slow          atomic.Int64  // tasks that ran longer than slowThreshold
+
+		profiling bool
+		cpu       atomic.Int64 // nanoseconds of thread CPU time, with profiling
+
+		// Readied by WithPrewarm
+		prewarm   int
+		spare     []taskRecord // records for the first tasks
+		spareNext atomic.Int64
+		warm      *warmPool
+
+		executor Executor
+		queue    *taskQueue // tasks waiting for a worker of a PoolExecutor
+
+		newID       IDGenerator
+		clock       Clock
+		codec       Codec
+		invalid     []error         // options rejected by NewManagerE
+		parent      context.Context // shuts the manager down once done, if set
+		stopParent  func() bool
+		logger      *slog.Logger
+		logCapacity int
+		events      func(Event)
+		onPanic     func(ID, any, []byte)
+		onSubmit    func(context.Context, ID, Runnable)
+		inline      bool
+		requestID   string
+		policy      *RunnablePolicy // wraps every task's runnable, if set
+		store       TaskStore       // persists tasks, if set
+		history     *History        // records finished tasks, if set
+		forwarder   Forwarder       // runs tasks elsewhere while their pool is full, if set
+		forwarded   atomic.Int64    // tasks handed to the forwarder
+		propagate   []any           // context keys tasks carry, all when nil
+		minDuration time.Duration   // time tasks need before their deadline, see WithMinTaskDuration
+		refused     atomic.Int64    // tasks failed with ErrInsufficientDeadline
+		stall       *stallWatch     // looks for stalled tasks, if set
+		stalled     atomic.Int64    // tasks found stalled
+
+		// What Close does with the tasks left, see WithShutdownPolicy
+		shutdownPolicy  ShutdownPolicy
+		shutdownTimeout time.Duration
 
 		mu           sync.Mutex
 		wg           sync.WaitGroup
 		shuttingDown bool
 	}
 
-	// Stats holds the current stats of the task manager
-	Stats struct {
-		Deferred  int
-		Pending   int
-		Running   int
-		Completed int
-		Failed    int
-		Canceled  int
-		Total     int
+	// DrainReport tells how the tasks pending or running when the manager
+	// was drained or shut down ended. Completed tasks finished on their
+	// own, whether they succeeded or failed. Abandoned tasks were still
+	// running when the context expired.
+	DrainReport struct {
+		Completed []ID
+		Canceled  []ID
+		Abandoned []ID
 	}
 
-	asyncTask struct {
-		result Future
-		done   chan struct{} // closed when task finishes
-		once   sync.Once
+	// TaskSummary is the state of a task as Each reports it: what tooling
+	// listing tasks needs, without the result.
+	TaskSummary struct {
+		ID        ID
+		Status    Status
+		Labels    map[string]string
+		Submitted time.Time
+		Started   time.Time // zero until it runs
+		Finished  time.Time // zero until it finishes
+		Attempts  int       // runs of its runnable, with retries and restarts
+		Stalled   bool      // see WithStallDetection
 	}
 
-	deferredTask struct {
-		runnable   Runnable
-		ctx        context.Context
-		done       chan struct{}
-		once       sync.Once
-		promotedID ID         // Store the promoted async task ID
-		promotedMu sync.Mutex // Protect promotedID access
+	// Stats holds the current stats of the task manager
+	Stats struct {
+		Deferred  int `json:"deferred"`
+		Pending   int `json:"pending"`
+		Running   int `json:"running"`
+		Completed int `json:"completed"`
+		Failed    int `json:"failed"`
+		Canceled  int `json:"canceled"`
+		Total     int `json:"total"`
+
+		// Worker slots across the default and named pools. Pools breaks
+		// them down by named pool.
+		Workers     int                  `json:"workers"`      // worker slots in use
+		WorkerLimit int                  `json:"worker_limit"` // worker pool size
+		PeakWorkers int                  `json:"peak_workers"` // most worker slots in use at once
+		Waiting     int                  `json:"waiting"`      // submissions blocked on a full pool
+		Queued      int                  `json:"queued"`       // tasks waiting for a PoolExecutor worker
+		Pools       map[string]PoolStats `json:"pools,omitempty"`
+
+		// Time submissions waited for a worker slot. Acquired counts the
+		// slots handed out, WaitAvg is WaitTotal / Acquired.
+		Acquired  int           `json:"acquired"`
+		WaitTotal time.Duration `json:"wait_total"`
+		WaitAvg   time.Duration `json:"wait_avg"`
+		WaitMax   time.Duration `json:"wait_max"`
+
+		// Finished tasks that ran longer than the slow task threshold
+		Slow int `json:"slow"`
+
+		// Tasks run on other nodes while their pool was full, see
+		// WithForwarding
+		Forwarded int `json:"forwarded,omitempty"`
+
+		// Tasks failed for too little time left before their deadline, see
+		// WithMinDuration
+		Refused int `json:"refused,omitempty"`
+
+		// Times running tasks were found stalled, see WithStallDetection
+		Stalled int `json:"stalled,omitempty"`
+
+		// Tasks completed per second as moving averages over 1 and 5
+		// minutes, and the share of the tasks finished over 5 minutes that
+		// failed. Canceled tasks count for neither.
+		Rate1m      float64 `json:"rate_1m"`
+		Rate5m      float64 `json:"rate_5m"`
+		FailureRate float64 `json:"failure_rate"`
+
+		// Percentiles of the durations of the tasks completed or failed in
+		// the last minute or two, estimated from a histogram with buckets
+		// about 20% apart
+		P50 time.Duration `json:"p50"`
+		P95 time.Duration `json:"p95"`
+		P99 time.Duration `json:"p99"`
+
+		// Time finished tasks ran together, and the CPU time their threads
+		// used with WithProfiling
+		RunTotal time.Duration `json:"run_total"`
+		CPUTotal time.Duration `json:"cpu_total"`
+
+		// Time spent awaiting tasks, and awaits failed for running over
+		// the await budget
+		AwaitTotal time.Duration `json:"await_total"`
+		OverBudget int           `json:"over_budget"`
+
+		// Approximate bytes held by the results of the tasks kept, the
+		// largest of them, and tasks failed for a result over the task
+		// memory limit, whose results aren't kept
+		Memory     int64 `json:"memory"`
+		MemoryMax  int64 `json:"memory_max"`
+		OverMemory int   `json:"over_memory"`
 	}
 )
 
-// String returns a string representation of a task ID
-func (id ID) String() string {
-	return xid.ID(id).String()
-}
-
 // Run the wrapped function
 func (f RunnableFunc) Run(ctx context.Context) (any, error) {
 	return f(ctx)
 }
 
+// String returns the string representation of the CancelResult
+func (r CancelResult) String() string {
+	switch r {
+	case CancelBeforeStart:
+		return "canceled before start"
+	case CancelWhileRunning:
+		return "canceled while running"
+	case CancelAlreadyFinished:
+		return "already finished"
+	default:
+		return "not found"
+	}
+}
+
+// Error returns the recovered value followed by the stack.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v: %v\n\n%s", ErrTaskPanicked, e.Value, e.Stack)
+}
+
+// Unwrap returns ErrTaskPanicked.
+func (e *PanicError) Unwrap() error {
+	return ErrTaskPanicked
+}
+
+// Error returns the message of the mapped error.
+func (e *RecoveredError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the mapped error.
+func (e *RecoveredError) Unwrap() error {
+	return e.Err
+}
+
+func (e retryableError) Unwrap() error {
+	return e.error
+}
+
+// Retryable marks err, returned by the mapping of WithRecover, as worth
+// retrying: WithRetry retries the panic it came from.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return retryableError{err}
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// Permanent marks err as not worth retrying, such as a rejected request:
+// WithRetry returns it without another attempt.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
 // String returns the string representation of the Status
 func (s Status) String() string {
 	switch s {
@@ -134,22 +416,40 @@ func (s Status) String() string {
 }
 
 // WithRetry wraps a runnable with exponential backoff retry logic.
-// Retries on any error, backoff multiplies by attempt number.
+// Retries on any error but those marked Permanent and a RecoveredError not
+// marked Retryable, backoff multiplies by attempt number. Backoff is timed by the clock from
+// ClockFromContext.
 func WithRetry(runnable Runnable, retries int, backoff time.Duration) Runnable {
 	return RunnableFunc(func(ctx context.Context) (any, error) {
+		clock := ClockFromContext(ctx)
 		var lastErr error
 		for i := 0; i <= retries; i++ {
+			if i > 0 {
+				countAttempt(ctx)
+			}
 			result, err := runnable.Run(ctx)
 			if err == nil {
 				return result, nil
 			}
 			lastErr = err
 
+			// A panic is only worth another attempt when its mapping says so
+			var recovered *RecoveredError
+			if errors.As(err, &recovered) && !recovered.Retryable {
+				return nil, err
+			}
+			var permanent permanentError
+			if errors.As(err, &permanent) {
+				return nil, err
+			}
+
 			// Skip backoff on last attempt
 			if i < retries {
+				timer := clock.NewTimer(backoff * time.Duration(i+1))
 				select {
-				case <-time.After(backoff * time.Duration(i+1)):
+				case <-timer.C():
 				case <-ctx.Done():
+					timer.Stop()
 					return nil, ctx.Err()
 				}
 			}
@@ -159,41 +459,210 @@ func WithRetry(runnable Runnable, retries int, backoff time.Duration) Runnable {
 }
 
 // WithTimeout wraps a runnable with deadline enforcement.
-// Returns ErrTaskTimeout if runnable exceeds timeout duration.
+// Returns ErrTaskTimeout if runnable exceeds timeout duration, timed by
+// the clock from ClockFromContext.
 func WithTimeout(runnable Runnable, timeout time.Duration) Runnable {
 	return RunnableFunc(func(ctx context.Context) (any, error) {
-		timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+		timeoutCtx, cancel := withTimeout(ctx, ClockFromContext(ctx), timeout)
+		defer cancel()
+
+		return runBound(timeoutCtx, runnable, fmt.Errorf("%w: task exceeded %v timeout", ErrTaskTimeout, timeout))
+	})
+}
+
+// WithDeadline wraps a runnable with enforcement of an absolute deadline.
+// Returns ErrTaskTimeout if runnable is still running at t, timed by the
+// clock from ClockFromContext, without running it once t has passed.
+func WithDeadline(runnable Runnable, t time.Time) Runnable {
+	return RunnableFunc(func(ctx context.Context) (any, error) {
+		exceeded := fmt.Errorf("%w: task exceeded deadline %s", ErrTaskTimeout, t.Format(time.RFC3339Nano))
+
+		clock := ClockFromContext(ctx)
+		timeout := t.Sub(clock.Now())
+		if timeout <= 0 {
+			return nil, exceeded
+		}
+		deadlineCtx, cancel := withTimeout(ctx, clock, timeout)
 		defer cancel()
 
-		type result struct {
-			value any
-			err   error
+		return runBound(deadlineCtx, runnable, exceeded)
+	})
+}
+
+// WithRequestDeadline wraps a runnable so it never outlives the response
+// it's meant for: it's canceled with ErrTaskCanceled once the request its
+// manager was bound to with Bind is done, and fails with ErrTaskTimeout
+// slack before the deadline of the request's context, if it has one.
+// Outside of bound managers the task's own context stands in for the
+// request's.
+func WithRequestDeadline(runnable Runnable, slack time.Duration) Runnable {
+	return RunnableFunc(func(ctx context.Context) (any, error) {
+		request, ok := requestFromContext(ctx)
+		if !ok {
+			request = ctx
 		}
 
-		resultChan := make(chan result, 1)
+		boundCtx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		stop := context.AfterFunc(request, func() { cancel(errRequestDone) })
+		defer stop()
 
-		go func() {
-			value, err := runnable.Run(timeoutCtx)
-			resultChan <- result{value, err}
+		if deadline, ok := request.Deadline(); ok {
+			runnable = WithDeadline(runnable, deadline.Add(-slack))
+		}
+		return runBound(boundCtx, runnable, nil)
+	})
+}
+
+// errRequestDone cancels the tasks of WithRequestDeadline once their
+// request is done.
+var errRequestDone = fmt.Errorf("%w: request finished", ErrTaskCanceled)
+
+// runBound runs runnable with ctx, returning once ctx is done even when
+// runnable doesn't: with exceeded when its deadline passed, the error it
+// was canceled with when that's a task error, or ctx.Err(). A panic of
+// runnable is raised again on the calling goroutine, for the manager to
+// recover.
+func runBound(ctx context.Context, runnable Runnable, exceeded error) (any, error) {
+	type result struct {
+		value     any
+		err       error
+		recovered any
+	}
+
+	resultChan := make(chan result, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				resultChan <- result{recovered: r}
+			}
 		}()
+		value, err := runnable.Run(ctx)
+		resultChan <- result{value: value, err: err}
+	}()
 
-		select {
-		case res := <-resultChan:
-			return res.value, res.err
-		case <-timeoutCtx.Done():
-			if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
-				return nil, fmt.Errorf("%w: task exceeded %v timeout", ErrTaskTimeout, timeout)
+	select {
+	case res := <-resultChan:
+		if res.recovered != nil {
+			panic(res.recovered)
+		}
+		// A runnable returning ctx's error as it's done fails as if it
+		// hadn't returned yet
+		if res.err != nil && ctx.Err() != nil && errors.Is(res.err, ctx.Err()) {
+			return nil, boundError(ctx, exceeded)
+		}
+		return res.value, res.err
+	case <-ctx.Done():
+		return nil, boundError(ctx, exceeded)
+	}
+}
+
+// boundError returns the error of a runnable bound to ctx once ctx is
+// done: exceeded past its deadline, the cause it was canceled with when
+// that's ErrTaskCanceled, else ctx's error.
+func boundError(ctx context.Context, exceeded error) error {
+	cause := context.Cause(ctx)
+	if exceeded != nil && errors.Is(cause, context.DeadlineExceeded) {
+		return exceeded
+	}
+	if errors.Is(cause, ErrTaskCanceled) {
+		return cause
+	}
+	return ctx.Err()
+}
+
+// WithRecover wraps a runnable to turn its panics into the error mapping
+// returns for the recovered value, as a RecoveredError, instead of failing
+// the task with a PanicError. The task's panic handler isn't called. When
+// mapping returns nil the panic is rethrown, for the manager to handle as
+// usual.
+func WithRecover(runnable Runnable, mapping func(recovered any) error) Runnable {
+	return RunnableFunc(func(ctx context.Context) (result any, err error) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			mapped := mapping(r)
+			if mapped == nil {
+				panic(r)
 			}
-			return nil, timeoutCtx.Err()
+			var marked retryableError
+			err = &RecoveredError{
+				Err:       mapped,
+				Value:     r,
+				Stack:     debug.Stack(),
+				Retryable: errors.As(mapped, &marked),
+			}
+			result = nil
+		}()
+		return runnable.Run(ctx)
+	})
+}
+
+// WithLogging wraps a runnable with structured records of its start at
+// debug level, and of its finish at info or its failure at error level,
+// both with the duration timed by the clock from ClockFromContext. Records
+// carry attrs and the task ID from TaskIDFromContext. With a nil logger
+// the task's logger from LoggerFromContext is used.
+func WithLogging(runnable Runnable, logger *slog.Logger, attrs ...any) Runnable {
+	return RunnableFunc(func(ctx context.Context) (any, error) {
+		l := logger
+		if l == nil {
+			// Already tagged with the task ID
+			l = LoggerFromContext(ctx)
+		} else if id, ok := TaskIDFromContext(ctx); ok {
+			l = l.With(slog.String("task_id", id.String()))
 		}
+		l = l.With(attrs...)
+
+		clock := ClockFromContext(ctx)
+		start := clock.Now()
+		l.DebugContext(ctx, "Task Started")
+
+		result, err := runnable.Run(ctx)
+		duration := clock.Now().Sub(start)
+		if err != nil {
+			l.ErrorContext(ctx, "Task Failed", slog.Duration("duration", duration), slog.Any("error", err))
+			return result, err
+		}
+		l.InfoContext(ctx, "Task Finished", slog.Duration("duration", duration))
+		return result, nil
 	})
 }
 
-// NewManager creates a new task manager
+// NewManager creates a new task manager. Invalid options are logged and
+// left out, falling back to the defaults; NewManagerE rejects them.
 func NewManager(opts ...Option) *Manager {
+	m := newManager(opts)
+	for _, err := range m.invalid {
+		m.logger.Warn("Option Ignored", slog.Any("error", err))
+	}
+	m.start()
+	return m
+}
+
+// NewManagerE creates a new task manager, or returns an error wrapping
+// ErrInvalidOption for every invalid option or combination of options.
+func NewManagerE(opts ...Option) (*Manager, error) {
+	m := newManager(opts)
+	if err := errors.Join(m.invalid...); err != nil {
+		return nil, err
+	}
+	m.start()
+	return m, nil
+}
+
+// newManager creates a manager configured by opts, without starting it.
+func newManager(opts []Option) *Manager {
 	m := &Manager{
-		workerLimit:     runtime.GOMAXPROCS(0) * 24,
-		workerSemaphore: make(chan struct{}, runtime.GOMAXPROCS(0)*24),
+		workers:     newSemaphore(runtime.GOMAXPROCS(0) * 24),
+		logCapacity: DefaultLogCapacity,
+		newID:       NewXID,
+		clock:       systemClock{},
+		codec:       JSONCodec{},
+		executor:    GoroutineExecutor{},
 	}
 
 	// Apply options to customize the manager
@@ -206,454 +675,1055 @@ func NewManager(opts ...Option) *Manager {
 		m.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
 	}
 
+	// Tag every record with the request, so logs from a request and its
+	// tasks can be joined
+	if m.requestID != "" {
+		m.logger = m.logger.With(slog.String("request_id", m.requestID))
+	}
+
+	m.workers.clock = m.clock
+	for _, pool := range m.pools {
+		pool.clock = m.clock
+	}
+
+	if m.fairLabel != "" {
+		m.workers.setShares(m.shares)
+		for _, pool := range m.pools {
+			pool.setShares(m.shares)
+		}
+	}
+
+	if m.autoscale != nil {
+		m.workers.resize(m.autoscale.min)
+	}
+
+	if m.prewarm > 0 {
+		m.prewarmTable(m.prewarm)
+	}
+
+	if _, ok := m.executor.(PoolExecutor); ok && m.inline {
+		m.invalidOption("inline execution with a pool executor")
+		m.executor = GoroutineExecutor{}
+	}
+
 	return m
 }
 
-// Async executes runnable in worker pool, returns task ID immediately.
-// Blocks if worker pool is full until slot available or ctx canceled.
+// start starts the goroutines of a configured manager.
+func (m *Manager) start() {
+	if m.autoscale != nil {
+		go m.autoscale.run(m)
+	}
+	if m.stall != nil {
+		go m.stall.run(m)
+	}
+	m.executor.start(m)
+	if m.prewarm > 0 && !m.inline && m.queue == nil {
+		m.warm = newWarmPool(m.prewarm)
+	}
+
+	if m.parent != nil {
+		m.mu.Lock()
+		m.stopParent = context.AfterFunc(m.parent, func() {
+			m.Shutdown(context.Background())
+		})
+		m.mu.Unlock()
+	}
+}
+
+// Async executes runnable in worker pool, returns task ID immediately, or
+// once the task finishes with WithInlineExecution. Blocks if worker pool
+// is full until slot available or ctx canceled.
+// Tasks over the quotas fail right away with ErrQuotaExceeded, and tasks
+// with too little time left before their deadline with
+// ErrInsufficientDeadline.
 func (tm *Manager) Async(ctx context.Context, runnable Runnable) ID {
-	taskID := ID(xid.New())
-	t := &asyncTask{done: make(chan struct{})}
+	rec, taskCtx := tm.submit(ctx, runnable)
+	tm.schedule(taskCtx, rec, false)
+	return rec.id
+}
 
-	tm.tasks.Store(taskID, t)
-	tm.taskStatuses.Store(taskID, StatusPending)
+// submit stores a pending task running runnable with ctx, to be started
+// with schedule, and returns its record and the context it runs in.
+func (tm *Manager) submit(ctx context.Context, runnable Runnable) (*taskRecord, context.Context) {
+	ctx = tm.propagated(ctx)
+	taskCtx, cancel := tm.taskContext(ctx)
+	rec := tm.newRecord(ctx, runnable, StatusPending)
+	rec.cancel = cancel
 
+	tm.tasks.store(rec)
+	return rec, taskCtx
+}
+
+// taskContext returns the context a task submitted with ctx runs in, and
+// the function canceling it. With a parent context it's also canceled with
+// the parent, has its deadline when that's earlier, and carries its values
+// where ctx has none.
+func (tm *Manager) taskContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if tm.parent == nil {
+		return context.WithCancel(ctx)
+	}
+
+	var taskCtx context.Context
+	var cancel context.CancelFunc
+	if deadline, ok := tm.parent.Deadline(); ok {
+		taskCtx, cancel = context.WithDeadline(parentedContext{Context: ctx, parent: tm.parent}, deadline)
+	} else {
+		taskCtx, cancel = context.WithCancel(parentedContext{Context: ctx, parent: tm.parent})
+	}
+	stop := context.AfterFunc(tm.parent, cancel)
+	return taskCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// schedule reports the submitted task of rec and starts it. Promoted
+// deferred tasks, counted when deferred, wait for their worker slot on a
+// goroutine of their own rather than the awaiting one, see promote.
+func (tm *Manager) schedule(taskCtx context.Context, rec *taskRecord, promoted bool) {
+	ctx, runnable, taskID := rec.ctx, rec.runnable, rec.id
+	tm.emit(rec, Event{Type: EventSubmitted})
+
+	// A parent done already shuts the manager down, even when its
+	// shutdown hasn't started yet
 	tm.mu.Lock()
-	if tm.shuttingDown {
+	if tm.shuttingDown || (tm.parent != nil && tm.parent.Err() != nil) {
 		tm.mu.Unlock()
-		tm.taskStatuses.Store(taskID, StatusCanceled)
-		close(t.done)
-		return taskID
+		tm.finish(rec, Future{ID: taskID, Error: ErrTaskCanceled}, StatusCanceled)
+		return
 	}
 	tm.mu.Unlock()
 
-	select {
-	case tm.workerSemaphore <- struct{}{}:
-	case <-ctx.Done():
-		t.result = Future{ID: taskID, Error: fmt.Errorf("%w", ErrTaskCanceled)}
-		close(t.done)
-		tm.taskStatuses.Store(taskID, StatusCanceled)
-		return taskID
+	if err := tm.admit(!promoted); err != nil {
+		tm.reject(rec, err)
+		return
+	}
+	if err := tm.checkDeadline(taskCtx); err != nil {
+		tm.reject(rec, err)
+		return
+	}
+	workers, err := tm.pool(ctx)
+	if err != nil {
+		tm.reject(rec, err)
+		return
+	}
+
+	// With a pool executor, workers wait for the slot and run the task,
+	// so submitting never blocks. Tasks canceled while queued are
+	// finished without taking a slot. Unbounded tasks skip the queue.
+	waitStart := tm.clock.Now()
+	if tm.queue != nil && !rec.unbounded {
+		queued := tm.queue.push(rec.namespace, func() {
+			if taskCtx.Err() != nil {
+				tm.finish(rec, Future{ID: taskID, Error: fmt.Errorf("%w", ErrTaskCanceled)}, StatusCanceled)
+				return
+			}
+			tm.dispatch(taskCtx, rec, runnable, workers, waitStart)
+		})
+		if !queued {
+			tm.finish(rec, Future{ID: taskID, Error: fmt.Errorf("%w", ErrTaskCanceled)}, StatusCanceled)
+		}
+		return
+	}
+	if promoted && !tm.inline {
+		go tm.dispatch(taskCtx, rec, runnable, workers, waitStart)
+		return
+	}
+	tm.dispatch(taskCtx, rec, runnable, workers, waitStart)
+}
+
+// dispatch waits for a slot of workers for rec, submitted at waitStart,
+// and runs it: on a goroutine of its own, or on the calling one with
+// WithInlineExecution or a pool executor. Forwarded tasks run elsewhere
+// without a slot, and unbounded ones run without waiting for one.
+func (tm *Manager) dispatch(taskCtx context.Context, rec *taskRecord, runnable Runnable, workers *semaphore, waitStart time.Time) {
+	taskID := rec.id
+
+	// Wait for a worker slot, measuring how long submissions queue, unless
+	// another node takes the task. A queue forming is a reason to grow an
+	// autoscaled pool right away. Canceling the task gives up its place in
+	// the queue.
+	var err error
+	var remote Runnable
+	if !rec.unbounded && !workers.tryAcquire() {
+		if remote = tm.route(rec); remote == nil {
+			var key string
+			if tm.fairLabel != "" {
+				key = rec.labels[tm.fairLabel]
+			}
+			w := workers.enqueue(key)
+			if tm.autoscale != nil && workers == tm.workers {
+				tm.autoscale.scale(tm)
+			}
+			err = workers.wait(taskCtx, w)
+		}
+	}
+	if err != nil {
+		tm.finish(rec, Future{ID: taskID, Error: fmt.Errorf("%w", ErrTaskCanceled)}, StatusCanceled)
+		return
+	}
+
+	wait := tm.clock.Now().Sub(waitStart)
+	if remote == nil && !rec.unbounded {
+		tm.recordSlot(wait)
 	}
 
-	taskCtx, cancel := context.WithCancel(ctx)
-	tm.tasksCancel.Store(taskID, cancel)
+	taskCtx = withClock(withLogger(withTaskID(taskCtx, taskID), tm.taskLogger(rec)), tm.clock)
+	taskCtx = withAttempts(taskCtx, &rec.attempts)
 
 	tm.wg.Add(1)
 
-	go func() {
-		defer func() { <-tm.workerSemaphore }() // release slot
+	// The tasks an unbounded task starts take a slot again
+	release := func() {}
+	switch {
+	case remote != nil:
+		runnable = remote
+	case rec.unbounded:
+		taskCtx = context.WithValue(taskCtx, unboundedKey{}, false)
+	default:
+		worker := workers.take()
+		rec.worker.Store(int32(worker) + 1)
+		release = func() { workers.release(worker) }
+	}
+
+	if tm.policy != nil {
+		runnable = tm.policy.Apply(runnable)
+	}
+	if tm.stall != nil && remote == nil {
+		hb := new(heartbeat)
+		rec.heartbeat.Store(hb)
+		runnable = tm.watched(hb, runnable)
+	}
+
+	run := func() {
+		defer release()
 		defer tm.wg.Done()
-		start := time.Now()
+		start := tm.clock.Now()
 
 		defer func() {
 			if r := recover(); r != nil {
-				t.result = Future{
+				stack := debug.Stack()
+				if tm.onPanic != nil {
+					tm.onPanic(taskID, r, stack)
+				}
+				tm.finish(rec, Future{
 					ID:       taskID,
-					Error:    fmt.Errorf("%w: %v", ErrTaskPanicked, r),
+					Error:    withLogTail(rec, &PanicError{Value: r, Stack: stack}),
 					Time:     start,
-					Duration: time.Since(start),
-				}
-				tm.tasksResult.Store(taskID, t.result)
-				tm.taskStatuses.Store(taskID, StatusFailed)
-				close(t.done)
+					Duration: tm.clock.Now().Sub(start),
+					Wait:     wait,
+				}, StatusFailed)
 			}
 		}()
 
-		tm.taskStatuses.Store(taskID, StatusRunning)
-		result, err := runnable.Run(taskCtx)
+		// Canceled while it waited for the slot
+		if _, ok := rec.transition(StatusRunning); !ok {
+			tm.finish(rec, Future{ID: taskID, Error: fmt.Errorf("%w", ErrTaskCanceled), Wait: wait}, StatusCanceled)
+			return
+		}
+		rec.attempts.Store(1)
+		tm.emit(rec, Event{Type: EventStarted, Time: start})
+
+		// Label the goroutine (and any it starts) so profiles and goroutine
+		// dumps can be attributed to the task
+		var result any
+		var err error
+		var cpu time.Duration
+		pprof.Do(taskCtx, tm.profileLabels(rec), func(ctx context.Context) {
+			if !tm.profiling {
+				result, err = runnable.Run(ctx)
+				return
+			}
+			cpu = measureCPU(func() {
+				result, err = runnable.Run(ctx)
+			})
+		})
 
 		status := StatusCompleted
 		if err != nil {
 			status = StatusFailed
+			err = withLogTail(rec, err)
 		} else if taskCtx.Err() != nil {
 			status = StatusCanceled
 			err = fmt.Errorf("%w: %v", ErrTaskCanceled, taskCtx.Err())
 		}
 
-		t.result = Future{
+		tm.finish(rec, Future{
 			ID:       taskID,
 			Result:   result,
 			Error:    err,
 			Time:     start,
-			Duration: time.Since(start),
-		}
-		tm.taskStatuses.Store(taskID, status)
-		tm.tasksResult.Store(taskID, t.result)
-		close(t.done)
-	}()
-
-	return taskID
+			Duration: tm.clock.Now().Sub(start),
+			Wait:     wait,
+			CPU:      cpu,
+		}, status)
+	}
+	switch {
+	case tm.inline, tm.queue != nil && remote == nil && !rec.unbounded:
+		run()
+	case tm.warm != nil && tm.warm.submit(run):
+	default:
+		go run()
+	}
 }
 
 // Defer creates a task but doesn't execute it until Await is called.
-// Task will not consume a worker pool slot until awaited.
+// Task will not consume a worker pool slot until awaited. It keeps the
+// values of ctx, but runs with the cancellation and deadline of the await
+// promoting it, see Await. Canceled before then, it never runs.
 func (tm *Manager) Defer(ctx context.Context, runnable Runnable) ID {
-	taskID := ID(xid.New())
-
-	tm.mu.Lock()
-	if tm.shuttingDown {
-		tm.mu.Unlock()
-		// Return canceled task immediately if shutting down
-		t := &asyncTask{done: make(chan struct{})}
-		t.result = Future{ID: taskID, Error: ErrTaskCanceled}
-		close(t.done)
-		tm.tasks.Store(taskID, t)
-		tm.taskStatuses.Store(taskID, StatusCanceled)
-		return taskID
-	}
-	tm.mu.Unlock()
-
-	dt := &deferredTask{
-		runnable:   runnable,
-		ctx:        ctx,
-		done:       make(chan struct{}),
-		promotedID: ID{}, // Initialize to zero value
-	}
-
-	tm.tasks.Store(taskID, dt)
-	tm.taskStatuses.Store(taskID, StatusDeferred)
-
-	return taskID
+	return tm.deferAll(ctx, []Runnable{runnable})[0]
 }
 
 // Await blocks until task completes or ctx canceled. Returns cached result
 // for completed tasks. Idempotent - multiple calls return identical results.
-// Deferred tasks are promoted to async execution on first await.
+// Deferred tasks are promoted to async execution on first await, running
+// with ctx's cancellation and deadline; Await waits for their worker slot
+// as for the task.
 func (tm *Manager) Await(ctx context.Context, taskID ID) (Future, error) {
-	value, ok := tm.tasks.Load(taskID)
-	if !ok {
-		return Future{}, ErrTaskNotFound
-	}
-
-	// Check if it's a deferred task and promote it to async
-	if dt, ok := value.(*deferredTask); ok {
-		// Promote deferred to async - only once
-		dt.once.Do(func() {
-			dt.promotedMu.Lock()
-			dt.promotedID = tm.Async(dt.ctx, dt.runnable)
-			dt.promotedMu.Unlock()
-		})
+	return tm.await(ctx, taskID, true)
+}
 
-		// Get the promoted ID and await it
-		dt.promotedMu.Lock()
-		promotedID := dt.promotedID
-		dt.promotedMu.Unlock()
+// WaitFor is Await for callers that only observe a task: when ctx is done
+// it returns ctx's error and leaves the task running.
+func (tm *Manager) WaitFor(ctx context.Context, taskID ID) (Future, error) {
+	return tm.await(ctx, taskID, false)
+}
 
-		// Recursively await the promoted async task
-		return tm.Await(ctx, promotedID)
+// Wait blocks until every task known when it's called has finished, or
+// returns ctx's error once ctx is done, leaving the tasks running. Given
+// labels, it only waits for tasks carrying all of them. Deferred tasks
+// never awaited won't run, so they aren't waited for.
+func (tm *Manager) Wait(ctx context.Context, labels map[string]string) error {
+	for _, rec := range tm.tasks.snapshot() {
+		if rec.deferred || !hasLabels(rec.labels, labels) {
+			continue
+		}
+		select {
+		case <-rec.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+	return nil
+}
 
-	t := value.(*asyncTask)
+func (tm *Manager) await(ctx context.Context, taskID ID, cancel bool) (future Future, err error) {
+	err = tm.budgeted(ctx, func(ctx context.Context) error {
+		future, err = tm.awaitOne(ctx, taskID, cancel)
+		return err
+	})
+	return future, err
+}
+
+func (tm *Manager) awaitOne(ctx context.Context, taskID ID, cancel bool) (Future, error) {
+	rec, err := tm.resolve(ctx, tm.namespace(ctx), taskID, cancel)
+	if err != nil {
+		return Future{}, err
+	}
 
 	select {
-	case <-t.done:
-		if t.result.Error != nil {
-			return t.result, fmt.Errorf("task %s: %w: %w", taskID.String(), ErrTaskFailed, t.result.Error)
-		}
-		return t.result, nil
+	case <-rec.done:
+		return rec.outcome(ctx)
 	case <-ctx.Done():
-		tm.Cancel(taskID)
+		if !cancel {
+			return Future{}, fmt.Errorf("task %s: %w", rec.id.String(), ctx.Err())
+		}
+		tm.Cancel(rec.id)
 		// Check if it was a deadline exceeded (timeout) vs cancellation
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return Future{}, fmt.Errorf("task %s: %w", taskID.String(), ErrTaskTimeout)
+			return Future{}, fmt.Errorf("task %s: %w", rec.id.String(), ErrTaskTimeout)
 		}
-		return Future{}, fmt.Errorf("task %s: %w: %v", taskID.String(), ErrTaskCanceled, ctx.Err())
+		return Future{}, fmt.Errorf("task %s: %w: %v", rec.id.String(), ErrTaskCanceled, ctx.Err())
 	}
 }
 
-// AwaitAll blocks until all tasks complete or ctx canceled. Returns results
-// in same order as taskIDs. Cancels all tasks if ctx canceled. Idempotent.
-func (tm *Manager) AwaitAll(ctx context.Context, taskIDs []ID) ([]Future, error) {
-	if len(taskIDs) == 0 {
-		return nil, nil
+// resolve returns the record to await with ctx for taskID, looked up in
+// namespace first. Deferred tasks are promoted to async - only once, see
+// promote - and the record they were promoted to is returned.
+func (tm *Manager) resolve(ctx context.Context, namespace string, taskID ID, cancel bool) (*taskRecord, error) {
+	rec, ok := tm.tasks.loadIn(namespace, taskID)
+	if !ok {
+		return nil, ErrTaskNotFound
 	}
 
-	var (
-		tasks = make([]Future, len(taskIDs))
-		errs  = make(chan error, len(taskIDs))
-		wg    sync.WaitGroup
-	)
-
-	cancelCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	rec.mu.Lock()
+	detached := rec.detached
+	rec.mu.Unlock()
+	if detached {
+		return nil, ErrTaskNotFound
+	}
 
-	wg.Add(len(taskIDs))
+	if rec.deferred {
+		tm.promote(ctx, rec, cancel)
+		return tm.resolve(ctx, rec.namespace, rec.promotedID(), cancel)
+	}
 
-	// Await each task concurrently
-	for i, taskID := range taskIDs {
-		go func(index int, id ID) {
-			defer wg.Done()
+	return rec, nil
+}
 
-			result, err := tm.Await(cancelCtx, id)
-			if err != nil {
-				errs <- fmt.Errorf("task %s: %w", id.String(), err)
-				return
-			}
+// AwaitAll blocks until all tasks complete or ctx canceled. Returns results
+// in same order as taskIDs. Cancels all tasks if ctx canceled. Idempotent.
+func (tm *Manager) AwaitAll(ctx context.Context, taskIDs []ID) ([]Future, error) {
+	tasks, errs, err := tm.AwaitAllSettled(ctx, taskIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
 
-			tasks[index] = result
-		}(i, taskID)
+// AwaitAllSettled is AwaitAll that doesn't stop at failed tasks: it returns
+// the result and error of each task in the order of taskIDs, one of them
+// set. The error returned on its own is that of ctx, after canceling all
+// tasks.
+func (tm *Manager) AwaitAllSettled(ctx context.Context, taskIDs []ID) (tasks []Future, errs []error, err error) {
+	if len(taskIDs) == 0 {
+		return nil, nil, nil
 	}
+	err = tm.budgeted(ctx, func(ctx context.Context) error {
+		tasks, errs, err = tm.awaitAll(ctx, taskIDs, true)
+		return err
+	})
+	return tasks, errs, err
+}
 
-	// Wait for all goroutines to complete or context cancellation
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+// awaitAll waits for the tasks of taskIDs. When ctx is done first, it
+// cancels them if cancel is set, and returns ctx's error otherwise.
+func (tm *Manager) awaitAll(ctx context.Context, taskIDs []ID, cancel bool) ([]Future, []error, error) {
+	// Resolve all tasks first, so deferred ones start together
+	namespace := tm.namespace(ctx)
+	records := make([]*taskRecord, len(taskIDs))
+	errs := make([]error, len(taskIDs))
+	for i, taskID := range taskIDs {
+		rec, err := tm.resolve(ctx, namespace, taskID, cancel)
+		if err != nil {
+			errs[i] = fmt.Errorf("task %s: %w", taskID.String(), err)
+			continue
+		}
+		records[i] = rec
+	}
 
-	select {
-	case <-done:
-		// All tasks completed; check if we have errors
-		close(errs)
-		if len(errs) > 0 {
-			return nil, <-errs
+	// Wait for each task in turn; the slowest bounds the total either way
+	tasks := make([]Future, len(taskIDs))
+	for i, rec := range records {
+		if rec == nil {
+			continue
 		}
-		return tasks, nil
 
-	case <-ctx.Done():
-		cancel()
+		select {
+		case <-rec.done:
+			result, err := rec.outcome(ctx)
+			if err != nil {
+				errs[i] = fmt.Errorf("task %s: %w", taskIDs[i].String(), err)
+				continue
+			}
+			tasks[i] = result
 
-		// Context canceled, so we cancel all tasks
-		for _, taskID := range taskIDs {
-			tm.Cancel(taskID)
-		}
-		// Check if it was a deadline exceeded (timeout) vs cancellation
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return nil, fmt.Errorf("%w", ErrTaskTimeout)
+		case <-ctx.Done():
+			if !cancel {
+				return nil, nil, ctx.Err()
+			}
+			// Context canceled, so we cancel all tasks
+			for _, taskID := range taskIDs {
+				tm.Cancel(taskID)
+			}
+			// Check if it was a deadline exceeded (timeout) vs cancellation
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, nil, fmt.Errorf("%w", ErrTaskTimeout)
+			}
+			return nil, nil, fmt.Errorf("%w: %v", ErrTaskCanceled, ctx.Err())
 		}
-		return nil, fmt.Errorf("%w: %v", ErrTaskCanceled, ctx.Err())
 	}
+
+	return tasks, errs, nil
 }
 
 // AwaitAny returns first task to complete among taskIDs. Cancels remaining
 // tasks once first completes. Returns immediately on first completion.
 func (tm *Manager) AwaitAny(ctx context.Context, taskIDs []ID) (Future, error) {
+	_, task, err := tm.AwaitAnyIndex(ctx, taskIDs)
+	return task, err
+}
+
+// AwaitAnyIndex is AwaitAny that also returns the position in taskIDs of the
+// task that completed first, so callers can correlate the winner with their
+// own data. The index is -1 when no task completed.
+func (tm *Manager) AwaitAnyIndex(ctx context.Context, taskIDs []ID) (index int, future Future, err error) {
 	if len(taskIDs) == 0 {
-		return Future{}, nil
+		return -1, Future{}, nil
 	}
+	err = tm.budgeted(ctx, func(ctx context.Context) error {
+		index, future, err = tm.awaitAny(ctx, taskIDs)
+		return err
+	})
+	if err != nil {
+		return -1, Future{}, err
+	}
+	return index, future, nil
+}
 
-	taskChan := make(chan Future, len(taskIDs))
-	errChan := make(chan error, len(taskIDs))
-	cancelCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+func (tm *Manager) awaitAny(ctx context.Context, taskIDs []ID) (int, Future, error) {
+	// Every task reports its index on one channel once it finishes, with
+	// room for all of them so finishing never blocks
+	finished := make(chan int, len(taskIDs))
+	records := make([]*taskRecord, 0, len(taskIDs))
+	defer func() {
+		for _, rec := range records {
+			rec.unwatch(finished)
+		}
+	}()
 
-	// Fire off async waits for each task
-	for _, taskID := range taskIDs {
-		go func(id ID) {
-			task, err := tm.Await(cancelCtx, id)
-			if err != nil {
-				errChan <- fmt.Errorf("task %s: %w", id.String(), err)
-				return
-			}
-			taskChan <- task
-		}(taskID)
+	namespace := tm.namespace(ctx)
+	for i, taskID := range taskIDs {
+		rec, err := tm.resolve(ctx, namespace, taskID, true)
+		if err != nil {
+			tm.cancelAll(taskIDs, -1)
+			return -1, Future{}, fmt.Errorf("task %s: %w", taskID.String(), err)
+		}
+		records = append(records, rec)
+		rec.watch(finished, i)
 	}
 
 	// Wait for the first response, error, or context cancellation
 	select {
-	case task := <-taskChan:
-		cancel()
-
-		// Cancel all tasks except the completed one
-		for _, taskID := range taskIDs {
-			if task.ID != taskID {
-				tm.Cancel(taskID)
-			}
-		}
-
-		return task, nil
-
-	case err := <-errChan:
-		cancel()
-
-		// Cancel all tasks
-		for _, taskID := range taskIDs {
-			tm.Cancel(taskID)
+	case index := <-finished:
+		result, err := records[index].outcome(ctx)
+		if err != nil {
+			tm.cancelAll(taskIDs, -1)
+			return -1, Future{}, fmt.Errorf("task %s: %w", taskIDs[index].String(), err)
 		}
 
-		return Future{}, err
+		// Cancel all tasks except the completed one
+		tm.cancelAll(taskIDs, index)
+		return index, result, nil
 
 	case <-ctx.Done():
-		cancel()
-
 		// Context canceled, so we cancel all tasks
-		for _, taskID := range taskIDs {
-			tm.Cancel(taskID)
-		}
+		tm.cancelAll(taskIDs, -1)
 		// Check if it was a deadline exceeded (timeout) vs cancellation
 		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return Future{}, fmt.Errorf("%w", ErrTaskTimeout)
+			return -1, Future{}, fmt.Errorf("%w", ErrTaskTimeout)
 		}
-		return Future{}, fmt.Errorf("%w: %v", ErrTaskCanceled, ctx.Err())
+		return -1, Future{}, fmt.Errorf("%w: %v", ErrTaskCanceled, ctx.Err())
 	}
 }
 
-// Cancel terminates task by taskID, cleaning up all associated state.
-// Returns true if task existed and was canceled, false otherwise.
-func (tm *Manager) Cancel(taskID ID) bool {
-	// Check if task exists
-	_, exists := tm.taskStatuses.Load(taskID)
-	if !exists {
-		return false
+// cancelAll cancels taskIDs, except the one at index keep.
+func (tm *Manager) cancelAll(taskIDs []ID, keep int) {
+	for i, taskID := range taskIDs {
+		if i != keep {
+			tm.Cancel(taskID)
+		}
 	}
+}
 
-	// Execute the cancel function if present
-	if cancelFunc, ok := tm.tasksCancel.Load(taskID); ok {
-		cancelFunc.(context.CancelFunc)()
+// Cancel terminates task by taskID. A canceled task is no longer
+// awaitable, but its status stays available. Tasks that already finished
+// keep their outcome and result, and report CancelAlreadyFinished.
+// Deferred tasks canceled before they're awaited never run; once awaited,
+// the task they were promoted to is canceled. Returns ErrTaskNotFound if
+// the task doesn't exist.
+func (tm *Manager) Cancel(taskID ID) (CancelResult, error) {
+	rec, ok := tm.tasks.load(taskID)
+	if !ok {
+		return CancelNotFound, ErrTaskNotFound
+	}
+	if rec.deferred {
+		return tm.cancelDeferred(rec)
 	}
 
-	// Update status and clean up state
-	tm.taskStatuses.Store(taskID, StatusCanceled)
-	tm.tasksCancel.Delete(taskID)
-	tm.tasksResult.Delete(taskID)
-	tm.tasks.Delete(taskID)
+	from, ok := rec.transition(StatusCanceled)
+	if !ok {
+		return CancelAlreadyFinished, nil
+	}
+
+	rec.mu.Lock()
+	rec.detached = true
+	rec.mu.Unlock()
+
+	// Pending and running tasks report their own outcome once they return
+	if rec.cancel != nil {
+		rec.cancel()
+	}
 
 	tm.logger.Debug("Future Canceled", slog.String("id", taskID.String()))
 
-	return true
+	if from == StatusRunning {
+		return CancelWhileRunning, nil
+	}
+	return CancelBeforeStart, nil
+}
+
+// Retry starts a new task running the runnable of the failed task taskID
+// with its original context and labels, and returns the new task's ID.
+// Returns ErrTaskNotRetryable if the task hasn't failed.
+func (tm *Manager) Retry(taskID ID) (ID, error) {
+	status, err := tm.Status(taskID)
+	if err != nil {
+		return ID{}, err
+	}
+	if status != StatusFailed {
+		return ID{}, fmt.Errorf("%w: task is %s", ErrTaskNotRetryable, status)
+	}
+
+	rec, ok := tm.tasks.load(taskID)
+	if !ok {
+		return ID{}, ErrTaskNotFound
+	}
+	if rec.runnable == nil {
+		return ID{}, ErrTaskNotRetryable
+	}
+
+	newID := tm.Async(rec.ctx, rec.runnable)
+	tm.logger.Debug("Future Retried", slog.String("id", taskID.String()), slog.String("retry", newID.String()))
+
+	return newID, nil
 }
 
 // Status returns current future status. Returns StatusUnknown and
 // ErrTaskNotFound if future doesn't exist.
 func (tm *Manager) Status(taskID ID) (Status, error) {
-	value, ok := tm.taskStatuses.Load(taskID)
+	rec, ok := tm.tasks.load(taskID)
 	if !ok {
 		return StatusUnknown, ErrTaskNotFound
 	}
 
-	status := value.(Status)
-
-	// If it's deferred, check if it's been promoted
+	// Deferred tasks report the status of the task they were promoted to
+	status := rec.loadStatus()
 	if status == StatusDeferred {
-		if taskValue, exists := tm.tasks.Load(taskID); exists {
-			if dt, ok := taskValue.(*deferredTask); ok {
-				dt.promotedMu.Lock()
-				promotedID := dt.promotedID
-				dt.promotedMu.Unlock()
-
-				// If promoted, return the promoted task's status
-				if promotedID != (ID{}) {
-					return tm.Status(promotedID)
-				}
-			}
+		if promotedID := rec.promotedID(); !promotedID.IsZero() {
+			return tm.Status(promotedID)
 		}
 	}
 
 	return status, nil
 }
 
+// RequestID returns the ID of the request the manager belongs to, or an
+// empty string when none was set.
+func (tm *Manager) RequestID() string {
+	return tm.requestID
+}
+
 // Future retrieves future metadata by ID. Returns partial Future with status
 // if future exists but hasn't completed.
 func (tm *Manager) Future(taskID ID) (Future, error) {
-	// First check if the task exists
-	status, ok := tm.taskStatuses.Load(taskID)
+	rec, ok := tm.tasks.load(taskID)
 	if !ok {
 		return Future{Status: StatusUnknown.String()}, ErrTaskNotFound
 	}
+	return rec.future(), nil
+}
+
+// List returns a snapshot of all tracked futures, oldest first. Futures that
+// haven't completed carry only their ID, status and labels.
+func (tm *Manager) List() []Future {
+	records := tm.tasks.snapshot()
+	futures := make([]Future, 0, len(records))
+	for _, rec := range records {
+		futures = append(futures, rec.future())
+	}
+
+	slices.SortFunc(futures, func(a, b Future) int {
+		return cmp.Or(a.Submitted.Compare(b.Submitted), cmp.Compare(a.ID.s, b.ID.s))
+	})
+
+	return futures
+}
+
+// Each calls yield with a summary of every tracked task, in no particular
+// order, until it returns false. Unlike List, it copies neither results nor
+// the whole table, so tooling can go through large managers cheaply. yield
+// may call the manager, such as to cancel the task; tasks submitted or
+// pruned meanwhile may or may not be seen. Each can be ranged over:
+//
+//	for task := range tm.Each {
+//		...
+//	}
+func (tm *Manager) Each(yield func(TaskSummary) bool) {
+	tm.tasks.each(func(rec *taskRecord) bool {
+		return yield(rec.summary())
+	})
+}
+
+// newRecord returns a record for a new task with the labels carried by
+// ctx. It isn't stored yet.
+func (tm *Manager) newRecord(ctx context.Context, runnable Runnable, status Status) *taskRecord {
+	rec := tm.allocRecord()
+	*rec = taskRecord{
+		id:       ID{s: tm.newID()},
+		labels:   LabelsFromContext(ctx),
+		done:     make(chan struct{}),
+		runnable: runnable,
+		ctx:      ctx,
+		clock:    tm.clock,
+		codec:    tm.codec,
+	}
+	rec.unbounded = unbounded(ctx)
+	if tm.shardLabel != "" {
+		rec.namespace = rec.labels[tm.shardLabel]
+	}
+	rec.status.Store(int32(status))
+	rec.submitted.Store(tm.clock.Now().UnixNano())
+	if status.finished() {
+		rec.finished.Store(rec.submitted.Load())
+	}
+	return rec
+}
 
-	// Check if there's a result in the results map
-	if result, ok := tm.tasksResult.Load(taskID); ok {
-		future := result.(Future)
-		future.Status = status.(Status).String()
-		return future, nil
+// finish records the outcome of rec and wakes its waiters. A task canceled
+// meanwhile stays canceled, whatever it returned.
+func (tm *Manager) finish(rec *taskRecord, result Future, status Status) {
+	tm.account(rec, &result, &status)
+	if _, ok := rec.transition(status); !ok {
+		status = rec.loadStatus()
+		if status == StatusCanceled && !errors.Is(result.Error, ErrTaskCanceled) {
+			result.Error = fmt.Errorf("%w: %v", ErrTaskCanceled, context.Canceled)
+		}
 	}
 
-	// Return a future with current status
-	return Future{Status: status.(Status).String()}, nil
+	result.Submitted, _, result.Finished = rec.times()
+
+	tm.spent.Add(int64(result.Duration))
+	tm.cpu.Add(int64(result.CPU))
+	tm.throughput.record(tm.clock.Now(), result.Duration, status)
+	if tm.slowThreshold > 0 && result.Duration > tm.slowThreshold {
+		tm.reportSlow(rec, result, status)
+	}
+	rec.mu.Lock()
+	rec.result = result
+	rec.mu.Unlock()
+	if rec.cancel != nil {
+		rec.cancel()
+	}
+
+	switch status {
+	case StatusCompleted:
+		tm.emitResult(rec, EventCompleted)
+	case StatusFailed:
+		tm.emitResult(rec, EventFailed)
+	default:
+		tm.emitResult(rec, EventCanceled)
+	}
+	if tm.logger.Enabled(context.Background(), slog.LevelDebug) {
+		tm.logger.Debug("Future Finished", slog.String("id", rec.id.String()), slog.String("status", status.String()), slog.Duration("duration", result.Duration))
+	}
+
+	rec.close()
+}
+
+// reportSlow counts and logs a task that ran longer than the slow task
+// threshold.
+func (tm *Manager) reportSlow(rec *taskRecord, result Future, status Status) {
+	tm.slow.Add(1)
+
+	attrs := []any{
+		slog.String("id", rec.id.String()),
+		slog.String("status", status.String()),
+		slog.Duration("duration", result.Duration),
+		slog.Duration("threshold", tm.slowThreshold),
+	}
+	if script := rec.labels["script"]; script != "" {
+		attrs = append(attrs, slog.String("script", script))
+	}
+	if len(rec.labels) > 0 {
+		attrs = append(attrs, slog.Any("labels", rec.labels))
+	}
+	tm.logger.Warn("Task Slow", attrs...)
+}
+
+// emit sends ev for rec to the event handler, filling in its ID, labels
+// and time, and persists rec to the task store.
+func (tm *Manager) emit(rec *taskRecord, ev Event) {
+	tm.persist(rec)
+	tm.record(rec)
+	if ev.Type == EventSubmitted {
+		if tm.onSubmit != nil {
+			tm.onSubmit(rec.ctx, rec.id, rec.runnable)
+		}
+		if tm.logger.Enabled(context.Background(), slog.LevelDebug) {
+			tm.logger.Debug("Future Submitted", slog.String("id", rec.id.String()))
+		}
+	}
+	if tm.events == nil {
+		return
+	}
+	ev.ID = rec.id
+	if len(rec.labels) > 0 {
+		ev.Labels = rec.labels
+	}
+	if ev.Time.IsZero() {
+		ev.Time = tm.clock.Now()
+	}
+	tm.events(ev)
+}
+
+// emitResult emits a finish event for the result of rec.
+func (tm *Manager) emitResult(rec *taskRecord, typ EventType) {
+	rec.mu.Lock()
+	result := rec.result
+	rec.mu.Unlock()
+
+	tm.emit(rec, Event{
+		Type:     typ,
+		Time:     result.Time.Add(result.Duration),
+		Duration: result.Duration,
+		Error:    result.Error,
+		Result:   result.Result,
+	})
 }
 
 // Prune removes completed/failed/canceled tasks from memory. If ttl > 0,
 // only removes tasks finished longer than ttl ago. Returns count pruned.
 func (tm *Manager) Prune(ttl time.Duration) int {
-	now := time.Now()
+	now := tm.clock.Now()
 	pruned := 0
+	var offloaded []*offloadedResult
 
-	tm.taskStatuses.Range(func(key, value any) bool {
-		status := value.(Status)
-		if status == StatusPending || status == StatusRunning || status == StatusDeferred {
-			return true // skip active/deferred tasks
+	for _, rec := range tm.tasks.snapshot() {
+		if !rec.loadStatus().finished() {
+			continue // skip active/deferred tasks
 		}
 
-		id := key.(ID)
-
 		// Optionally enforce TTL
 		if ttl > 0 {
-			if resultVal, ok := tm.tasksResult.Load(id); ok {
-				task := resultVal.(Future)
-				if !task.Time.IsZero() && now.Sub(task.Time) < ttl {
-					return true // skip task, TTL not expired
-				}
+			if _, _, finished := rec.times(); !finished.IsZero() && now.Sub(finished) < ttl {
+				continue // skip task, TTL not expired
 			}
 		}
 
-		// Delete all state
-		tm.tasks.Delete(id)
-		tm.tasksCancel.Delete(id)
-		tm.tasksResult.Delete(id)
-		tm.taskStatuses.Delete(id)
-
+		tm.tasks.delete(rec)
 		pruned++
-		return true
-	})
+		if ref := rec.offloadedResult(); ref != nil {
+			offloaded = append(offloaded, ref)
+		}
+	}
+	tm.deleteOffloaded(offloaded)
 
 	return pruned
 }
 
+// Drain stops new submissions, which fail as canceled, and waits for the
+// pending and running tasks to finish until ctx is done. Tasks still
+// running then are left running and reported as abandoned, with ctx's
+// error. Deferred tasks are left alone.
+func (tm *Manager) Drain(ctx context.Context) (DrainReport, error) {
+	return tm.drain(ctx, false)
+}
+
 // Shutdown cancels all tasks and waits for workers to finish. Returns early
-// if ctx canceled during shutdown. Cleans up all internal state.
-func (tm *Manager) Shutdown(ctx context.Context) {
+// if ctx canceled during shutdown, with the tasks still running reported
+// as abandoned. Cleans up all internal state, deleting offloaded results.
+func (tm *Manager) Shutdown(ctx context.Context) (DrainReport, error) {
 	tm.mu.Lock()
-	tm.shuttingDown = true
+	stopParent := tm.stopParent
 	tm.mu.Unlock()
+	if stopParent != nil {
+		stopParent()
+	}
+	if tm.autoscale != nil {
+		tm.autoscale.stop()
+	}
+	if tm.stall != nil {
+		tm.stall.stop()
+	}
+	if tm.warm != nil {
+		tm.warm.close()
+	}
+	if tm.queue != nil {
+		tm.queue.close()
+	}
 
-	// Cancel all tasks concurrently
-	tm.taskStatuses.Range(func(key, _ any) bool {
-		if cancelFunc, ok := tm.tasksCancel.Load(key); ok {
-			cancelFunc.(context.CancelFunc)()
+	report, err := tm.drain(ctx, true)
+	if err == nil {
+		tm.wg.Wait() // finished tasks are releasing their slots
+	}
+
+	// Offloaded results can't be awaited any more either
+	if tm.results != nil {
+		var offloaded []*offloadedResult
+		for _, rec := range tm.tasks.snapshot() {
+			if ref := rec.offloadedResult(); ref != nil {
+				offloaded = append(offloaded, ref)
+			}
 		}
-		return true
+		tm.deleteOffloaded(offloaded)
+	}
+	tm.tasks.clear()
+	return report, err
+}
+
+// drain stops new submissions and waits for the pending and running tasks
+// until ctx is done, canceling them and the deferred tasks first if cancel
+// is set.
+func (tm *Manager) drain(ctx context.Context, cancel bool) (DrainReport, error) {
+	tm.mu.Lock()
+	tm.shuttingDown = true
+	tm.mu.Unlock()
+
+	records := tm.tasks.snapshot()
+	slices.SortFunc(records, func(a, b *taskRecord) int {
+		return cmp.Or(cmp.Compare(a.submitted.Load(), b.submitted.Load()), cmp.Compare(a.id.s, b.id.s))
 	})
 
-	done := make(chan struct{})
-	go func() {
-		tm.wg.Wait() // wait for all tasks to finish
-		close(done)
-	}()
+	var report DrainReport
+	var outstanding []*taskRecord
+	for _, rec := range records {
+		switch rec.loadStatus() {
+		case StatusPending, StatusRunning:
+			outstanding = append(outstanding, rec)
+			if cancel {
+				tm.Cancel(rec.id)
+			}
+		case StatusDeferred:
+			// Promoted ones are drained as the task they were promoted to
+			if !cancel || !rec.promotedID().IsZero() {
+				continue
+			}
+			if result, _ := tm.Cancel(rec.id); result == CancelBeforeStart {
+				report.Canceled = append(report.Canceled, rec.id)
+			}
+		}
+	}
 
-	select {
-	case <-ctx.Done():
-		// context canceled, exit early
-	case <-done:
-		// all tasks finished, now clean up
+wait:
+	for _, rec := range outstanding {
+		select {
+		case <-rec.done:
+		case <-ctx.Done():
+			break wait
+		}
 	}
 
-	// Remove all tasks from internal maps
-	tm.tasks.Range(func(key, _ any) bool {
-		tm.tasks.Delete(key)
-		return true
-	})
-	tm.tasksCancel.Range(func(key, _ any) bool {
-		tm.tasksCancel.Delete(key)
-		return true
-	})
-	tm.tasksResult.Range(func(key, _ any) bool {
-		tm.tasksResult.Delete(key)
-		return true
-	})
-	tm.taskStatuses.Range(func(key, _ any) bool {
-		tm.taskStatuses.Delete(key)
-		return true
-	})
+	for _, rec := range outstanding {
+		select {
+		case <-rec.done:
+			if rec.loadStatus() == StatusCanceled {
+				report.Canceled = append(report.Canceled, rec.id)
+			} else {
+				report.Completed = append(report.Completed, rec.id)
+			}
+		default:
+			report.Abandoned = append(report.Abandoned, rec.id)
+		}
+	}
+
+	if len(report.Abandoned) > 0 {
+		return report, fmt.Errorf("%d tasks still running: %w", len(report.Abandoned), ctx.Err())
+	}
+	return report, nil
+}
+
+// admit checks a submission against the quotas, counting it when count is
+// set. Returns ErrQuotaExceeded once the task limit or the duration budget
+// is used up.
+func (tm *Manager) admit(count bool) error {
+	if tm.budget > 0 && time.Duration(tm.spent.Load()) >= tm.budget {
+		return fmt.Errorf("%w: tasks ran for the budget of %v", ErrQuotaExceeded, tm.budget)
+	}
+	if count && tm.maxTasks > 0 && tm.submitted.Add(1) > int64(tm.maxTasks) {
+		return fmt.Errorf("%w: more than %d tasks", ErrQuotaExceeded, tm.maxTasks)
+	}
+	return nil
+}
+
+// reject finishes rec as failed with err before it ran.
+func (tm *Manager) reject(rec *taskRecord, err error) ID {
+	tm.finish(rec, Future{ID: rec.id, Error: err}, StatusFailed)
+	return rec.id
+}
+
+// recordSlot accounts a worker slot handed out after waiting for it.
+func (tm *Manager) recordSlot(wait time.Duration) {
+	tm.acquired.Add(1)
+	tm.waitTotal.Add(int64(wait))
+	storeMax(&tm.waitMax, int64(wait))
+	storeMax(&tm.peakWorkers, int64(tm.busy()))
+}
+
+// storeMax raises v to n if n is larger.
+func storeMax(v *atomic.Int64, n int64) {
+	for {
+		current := v.Load()
+		if n <= current || v.CompareAndSwap(current, n) {
+			return
+		}
+	}
+}
+
+// Resize sets the limit of the default worker pool, for submissions already
+// queued as well.
+// Tasks holding a slot keep running when it drops below the slots in use.
+// With WithAutoscale, n is clamped to its bounds and the policy carries on
+// from there. n is at least 1.
+func (tm *Manager) Resize(n int) {
+	n = max(n, 1)
+	if tm.autoscale != nil {
+		tm.autoscale.mu.Lock()
+		defer tm.autoscale.mu.Unlock()
+		n = tm.autoscale.clamp(n)
+	}
+	tm.workers.resize(n)
 }
 
-// Stats returns current task distribution across all statuses.
+// Stats returns current task distribution across all statuses and the
+// worker pool utilization.
 func (tm *Manager) Stats() Stats {
-	var stats Stats
+	limit, used, waiting, _ := tm.workers.state()
+	var pools map[string]PoolStats
+	for name, pool := range tm.pools {
+		size, n, queued, _ := pool.state()
+		if pools == nil {
+			pools = make(map[string]PoolStats, len(tm.pools))
+		}
+		pools[name] = PoolStats{Workers: n, WorkerLimit: size, Waiting: queued}
+		limit += size
+		used += n
+		waiting += queued
+	}
+	stats := Stats{
+		Pools:       pools,
+		Workers:     used,
+		WorkerLimit: limit,
+		PeakWorkers: int(tm.peakWorkers.Load()),
+		Waiting:     waiting,
+		Acquired:    int(tm.acquired.Load()),
+		WaitTotal:   time.Duration(tm.waitTotal.Load()),
+		WaitMax:     time.Duration(tm.waitMax.Load()),
+		Slow:        int(tm.slow.Load()),
+		Forwarded:   int(tm.forwarded.Load()),
+		Refused:     int(tm.refused.Load()),
+		Stalled:     int(tm.stalled.Load()),
+		RunTotal:    time.Duration(tm.spent.Load()),
+		CPUTotal:    time.Duration(tm.cpu.Load()),
+		AwaitTotal:  time.Duration(tm.awaited.Load()),
+		OverBudget:  int(tm.overBudget.Load()),
+		OverMemory:  int(tm.overMemory.Load()),
+	}
+	if tm.queue != nil {
+		stats.Queued = tm.queue.len()
+	}
+	if stats.Acquired > 0 {
+		stats.WaitAvg = stats.WaitTotal / time.Duration(stats.Acquired)
+	}
+	tm.throughput.stats(tm.clock.Now(), &stats)
+
+	for _, rec := range tm.tasks.snapshot() {
+		rec.mu.Lock()
+		if rec.result.Result != nil {
+			stats.Memory += rec.result.Memory
+			stats.MemoryMax = max(stats.MemoryMax, rec.result.Memory)
+		}
+		rec.mu.Unlock()
 
-	tm.taskStatuses.Range(func(_, value any) bool {
 		stats.Total++
-		switch value.(Status) {
+		switch rec.loadStatus() {
 		case StatusDeferred:
 			stats.Deferred++
 		case StatusPending:
@@ -667,8 +1737,7 @@ func (tm *Manager) Stats() Stats {
 		case StatusCanceled:
 			stats.Canceled++
 		}
-		return true
-	})
+	}
 
 	return stats
 }
@@ -1,17 +1,139 @@
 package asynctask
 
-import "log/slog"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+)
 
 type (
 	Option func(*Manager)
+
+	// Config is the effective configuration of a manager, once defaults
+	// are applied and invalid options left out.
+	Config struct {
+		WorkerLimit  int            `json:"worker_limit"` // current limit of the default pool
+		Pools        map[string]int `json:"pools,omitempty"`
+		AutoscaleMin int            `json:"autoscale_min,omitempty"` // zero without autoscaling
+		AutoscaleMax int            `json:"autoscale_max,omitempty"`
+		FairLabel    string         `json:"fair_label,omitempty"`
+		Shares       map[string]int `json:"shares,omitempty"`
+		ShardLabel   string         `json:"shard_label,omitempty"`
+		MaxTasks     int            `json:"max_tasks"`
+		Budget       time.Duration  `json:"budget"`
+		AwaitBudget  time.Duration  `json:"await_budget,omitempty"`
+		MinDuration  time.Duration  `json:"min_duration,omitempty"`
+		MaxMemory    int64          `json:"max_memory,omitempty"` // bytes of a task's result
+		Offload      int64          `json:"offload,omitempty"`    // bytes of a result before it's offloaded, zero without a result store
+		OffloadTTL   time.Duration  `json:"offload_ttl,omitempty"`
+		Persisted    bool           `json:"persisted,omitempty"`  // tasks saved to a task store
+		History      int            `json:"history,omitempty"`    // capacity of the history finished tasks are recorded in
+		Forwarding   bool           `json:"forwarding,omitempty"` // tasks handed to other nodes while their pool is full
+		Propagated   int            `json:"propagated,omitempty"` // context keys task contexts are limited to, see WithContextPropagation
+		LogCapacity  int            `json:"log_capacity"`
+		RequestID    string         `json:"request_id,omitempty"`
+		Inline       bool           `json:"inline,omitempty"`
+		SlowTask     time.Duration  `json:"slow_task,omitempty"` // zero without slow task detection
+		Profiling    bool           `json:"profiling,omitempty"`
+		Prewarm      int            `json:"prewarm,omitempty"`
+		Executor     string         `json:"executor"`
+		Workers      int            `json:"workers,omitempty"` // of a PoolExecutor
+		Codec        string         `json:"codec"`
+
+		ShutdownPolicy  string        `json:"shutdown_policy"`
+		ShutdownTimeout time.Duration `json:"shutdown_timeout,omitempty"`
+
+		StallTimeout  time.Duration `json:"stall_timeout,omitempty"` // zero without stall detection
+		StallRestarts int           `json:"stall_restarts,omitempty"`
+	}
 )
 
+// invalidOption records an invalid option, reported by NewManagerE.
+func (m *Manager) invalidOption(format string, args ...any) {
+	m.invalid = append(m.invalid, fmt.Errorf("%w: "+format, append([]any{ErrInvalidOption}, args...)...))
+}
+
 // WithWorkerLimit sets the maximum number of concurrent workers in the pool.
 func WithWorkerLimit(limit int) Option {
 	return func(m *Manager) {
-		if limit > 0 {
-			m.workerLimit = limit
-			m.workerSemaphore = make(chan struct{}, limit)
+		if limit < 1 {
+			m.invalidOption("worker limit %d, must be at least 1", limit)
+			return
+		}
+		m.workers = newSemaphore(limit)
+	}
+}
+
+// WithPool adds a worker pool of limit slots for the tasks labeled with
+// PoolLabel name, so slow tasks in one pool can't take the slots of
+// another. Tasks naming a pool that wasn't added fail with ErrPoolNotFound.
+// Adding a pool twice with different limits is invalid.
+func WithPool(name string, limit int) Option {
+	return func(m *Manager) {
+		switch {
+		case name == "":
+			m.invalidOption("pool without a name")
+			return
+		case limit < 1:
+			m.invalidOption("pool %q limit %d, must be at least 1", name, limit)
+			return
+		}
+		if pool, ok := m.pools[name]; ok {
+			if pool.size != limit {
+				m.invalidOption("pool %q added with limits %d and %d", name, pool.size, limit)
+			}
+			return
+		}
+		if m.pools == nil {
+			m.pools = make(map[string]*semaphore)
+		}
+		m.pools[name] = newSemaphore(limit)
+	}
+}
+
+// WithFairScheduling shares the slots of a full worker pool between the
+// values of label, such as the requests of a manager serving many, instead
+// of first come, first served. Free slots go to each value with queued
+// tasks in turn, shares[value] slots at a time or 1 when unset, so one
+// value fanning out many tasks can't starve the others.
+func WithFairScheduling(label string, shares map[string]int) Option {
+	return func(m *Manager) {
+		if label == "" {
+			m.invalidOption("fair scheduling without a label")
+			return
+		}
+		for value, share := range shares {
+			if share < 1 {
+				m.invalidOption("fair scheduling share %d of %q, must be at least 1", share, value)
+				return
+			}
+		}
+		m.fairLabel = label
+		m.shares = shares
+	}
+}
+
+// WithAutoscale lets the default pool's worker limit move between minWorkers and
+// maxWorkers as policy decides, starting at minWorkers and overriding
+// WithWorkerLimit. A nil policy uses DefaultAutoscalePolicy. The policy runs
+// periodically and whenever a submission has to queue, until Shutdown.
+// minWorkers must be at least 1 and maxWorkers at least minWorkers.
+func WithAutoscale(minWorkers, maxWorkers int, policy AutoscalePolicy) Option {
+	return func(m *Manager) {
+		if minWorkers < 1 || maxWorkers < minWorkers {
+			m.invalidOption("autoscale bounds %d to %d, must be 1 <= min <= max", minWorkers, maxWorkers)
+		}
+		minWorkers = max(minWorkers, 1)
+		if policy == nil {
+			policy = DefaultAutoscalePolicy
+		}
+		m.autoscale = &autoscaler{
+			min:    minWorkers,
+			max:    max(maxWorkers, minWorkers),
+			policy: policy,
+			done:   make(chan struct{}),
 		}
 	}
 }
@@ -19,6 +141,221 @@ func WithWorkerLimit(limit int) Option {
 // WithLogger sets a custom logger for the Manager.
 func WithLogger(handler slog.Handler) Option {
 	return func(m *Manager) {
+		if handler == nil {
+			m.invalidOption("nil logger")
+			return
+		}
 		m.logger = slog.New(handler)
 	}
 }
+
+// WithLogCapacity sets the number of log records captured per task for
+// Logs and failed task errors. Zero disables capture.
+func WithLogCapacity(capacity int) Option {
+	return func(m *Manager) {
+		if capacity < 0 {
+			m.invalidOption("log capacity %d, must not be negative", capacity)
+			return
+		}
+		m.logCapacity = capacity
+	}
+}
+
+// WithRequestID sets the ID of the request the manager belongs to. It is
+// added to every record the manager and its tasks log.
+func WithRequestID(id string) Option {
+	return func(m *Manager) {
+		m.requestID = id
+	}
+}
+
+// WithEventHandler sets a function receiving task lifecycle events. It is
+// called synchronously from task goroutines and must not block.
+func WithEventHandler(handler func(Event)) Option {
+	return func(m *Manager) {
+		m.events = handler
+	}
+}
+
+// WithPanicHandler sets a function told about every task that panics,
+// with the recovered value and the stack of the task goroutine, so panics
+// can be reported to an error tracker. It is called from the task
+// goroutine before the task finishes as failed, and must not block.
+func WithPanicHandler(handler func(taskID ID, recovered any, stack []byte)) Option {
+	return func(m *Manager) {
+		m.onPanic = handler
+	}
+}
+
+// WithSubmitHandler sets a function receiving every task submitted, with
+// the context and runnable it was submitted with, before the task starts.
+// A deferred task is submitted again under a new ID once promoted. It is
+// called synchronously from the submitting goroutine and must not block.
+func WithSubmitHandler(handler func(ctx context.Context, taskID ID, runnable Runnable)) Option {
+	return func(m *Manager) {
+		m.onSubmit = handler
+	}
+}
+
+// WithInlineExecution runs tasks on the goroutine submitting them, so
+// Async only returns once the task has finished. Meant for tests; worker
+// limits still apply, so tasks starting tasks need a slot each.
+func WithInlineExecution() Option {
+	return func(m *Manager) {
+		m.inline = true
+	}
+}
+
+// WithSlowTaskThreshold logs tasks that ran longer than threshold at warn
+// level, with their labels, duration and script, and counts them in
+// Stats.Slow. Zero disables it.
+func WithSlowTaskThreshold(threshold time.Duration) Option {
+	return func(m *Manager) {
+		if threshold < 0 {
+			m.invalidOption("slow task threshold %v, must not be negative", threshold)
+			return
+		}
+		m.slowThreshold = threshold
+	}
+}
+
+// WithMaxTasksPerRequest limits the number of tasks started through the
+// manager, deferred, retried and replayed ones included. Tasks beyond the
+// limit fail with ErrQuotaExceeded. Zero means no limit.
+func WithMaxTasksPerRequest(n int) Option {
+	return func(m *Manager) {
+		if n < 0 {
+			m.invalidOption("max tasks %d, must not be negative", n)
+			return
+		}
+		m.maxTasks = n
+	}
+}
+
+// WithRequestBudget limits the total time the manager's tasks may run.
+// Once finished tasks have run for d together, new tasks fail with
+// ErrQuotaExceeded; running tasks are left alone. Zero means no limit.
+func WithRequestBudget(d time.Duration) Option {
+	return func(m *Manager) {
+		if d < 0 {
+			m.invalidOption("budget %v, must not be negative", d)
+			return
+		}
+		m.budget = d
+	}
+}
+
+// WithIDGenerator sets the generator of task IDs, such as NewULID,
+// NewUUIDv7 or SequenceIDs in tests, instead of NewXID.
+func WithIDGenerator(generator IDGenerator) Option {
+	return func(m *Manager) {
+		if generator == nil {
+			m.invalidOption("nil ID generator")
+			return
+		}
+		m.newID = generator
+	}
+}
+
+// WithClock sets the clock the manager tells time by, such as a
+// FakeClock in tests, instead of the system clock. Tasks get it from
+// ClockFromContext.
+func WithClock(clock Clock) Option {
+	return func(m *Manager) {
+		if clock == nil {
+			m.invalidOption("nil clock")
+			return
+		}
+		m.clock = clock
+	}
+}
+
+// WithCodec sets the codec serializing task results where they leave the
+// manager, such as futures marshaled to JSON. JSONCodec by default.
+func WithCodec(codec Codec) Option {
+	return func(m *Manager) {
+		if codec == nil {
+			m.invalidOption("nil codec")
+			return
+		}
+		m.codec = codec
+	}
+}
+
+// WithDefaultPolicy wraps the runnable of every task in the wrappers of
+// policy when it starts, within any a task brings itself. Retried and
+// replayed tasks are wrapped anew.
+func WithDefaultPolicy(policy RunnablePolicy) Option {
+	return func(m *Manager) {
+		m.policy = &policy
+	}
+}
+
+// WithParentContext ties the manager to ctx: once ctx is done, the manager
+// shuts down, canceling its tasks and refusing new ones, as if Shutdown
+// was called. Task contexts derive from ctx as well as the context they
+// were submitted with: they're canceled with either, have the earliest of
+// their deadlines, and carry the values of ctx the submitting context has
+// none for.
+func WithParentContext(ctx context.Context) Option {
+	return func(m *Manager) {
+		if ctx == nil {
+			m.invalidOption("nil parent context")
+			return
+		}
+		m.parent = ctx
+	}
+}
+
+// Config returns the effective configuration of the manager.
+func (tm *Manager) Config() Config {
+	limit, _, _, _ := tm.workers.state()
+	cfg := Config{
+		WorkerLimit: limit,
+		FairLabel:   tm.fairLabel,
+		Shares:      maps.Clone(tm.shares),
+		ShardLabel:  tm.shardLabel,
+		MaxTasks:    tm.maxTasks,
+		Budget:      tm.budget,
+		AwaitBudget: tm.awaitBudget,
+		MaxMemory:   tm.maxMemory,
+		LogCapacity: tm.logCapacity,
+		RequestID:   tm.requestID,
+		Inline:      tm.inline,
+		SlowTask:    tm.slowThreshold,
+		Profiling:   tm.profiling,
+		Prewarm:     tm.prewarm,
+		Persisted:   tm.store != nil,
+		History:     tm.historyCapacity(),
+		Forwarding:  tm.forwarder != nil,
+		Propagated:  len(tm.propagate),
+		MinDuration: tm.minDuration,
+		Executor:    tm.executor.name(),
+		Codec:       tm.codec.Name(),
+	}
+	policy, timeout := tm.ShutdownPolicy()
+	cfg.ShutdownPolicy, cfg.ShutdownTimeout = policy.String(), timeout
+	for name, pool := range tm.pools {
+		if cfg.Pools == nil {
+			cfg.Pools = make(map[string]int, len(tm.pools))
+		}
+		size, _, _, _ := pool.state()
+		cfg.Pools[name] = size
+	}
+	if tm.results != nil {
+		cfg.Offload, cfg.OffloadTTL = tm.offloadThreshold, tm.offloadTTL
+	}
+	if p, ok := tm.executor.(PoolExecutor); ok {
+		cfg.Workers = p.Workers
+		if cfg.Workers == 0 {
+			cfg.Workers = limit
+		}
+	}
+	if tm.autoscale != nil {
+		cfg.AutoscaleMin, cfg.AutoscaleMax = tm.autoscale.min, tm.autoscale.max
+	}
+	if tm.stall != nil {
+		cfg.StallTimeout, cfg.StallRestarts = tm.stall.timeout, tm.stall.restarts
+	}
+	return cfg
+}
@@ -1,10 +1,22 @@
 package asynctask
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"math"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -120,6 +132,19 @@ func TestWithRetry(t *testing.T) {
 		assertNoError(t, err)
 		assertEqual(t, result.Result, "deferred success")
 	})
+
+	t.Run("permanent", func(t *testing.T) {
+		errRejected := errors.New("rejected")
+		attempts := int32(0)
+		wrapped := WithRetry(RunnableFunc(func(ctx context.Context) (any, error) {
+			atomic.AddInt32(&attempts, 1)
+			return nil, Permanent(errRejected)
+		}), 3, 10*time.Millisecond)
+
+		_, err := tm.Await(ctx, tm.Async(ctx, wrapped))
+		assertError(t, err, errRejected)
+		assertEqual(t, atomic.LoadInt32(&attempts), int32(1))
+	})
 }
 
 // Test WithTimeout wrapper with both Async and Defer
@@ -174,6 +199,327 @@ func TestWithTimeout(t *testing.T) {
 	})
 }
 
+// Test WithDeadline and WithRequestDeadline bound tasks by absolute times
+func TestWithDeadline(t *testing.T) {
+	start := time.Now()
+	clock := NewFakeClock(start)
+	tm := NewManager(WithClock(clock))
+	ctx := context.Background()
+
+	awaitTimers := func(n int) {
+		for clock.Timers() < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	var runs atomic.Int32
+	stuck := RunnableFunc(func(ctx context.Context) (any, error) {
+		runs.Add(1)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	// A deadline already passed doesn't run the task
+	_, err := tm.Await(ctx, tm.Async(ctx, WithDeadline(stuck, start.Add(-time.Second))))
+	assertError(t, err, ErrTaskTimeout)
+	assertEqual(t, runs.Load(), int32(0))
+
+	taskID := tm.Async(ctx, WithDeadline(stuck, start.Add(time.Minute)))
+	awaitTimers(1)
+	clock.Advance(time.Minute)
+	_, err = tm.Await(ctx, taskID)
+	assertError(t, err, ErrTaskTimeout)
+
+	// Slack before the deadline of the request
+	reqCtx, cancelReq := context.WithDeadline(ctx, start.Add(time.Hour))
+	taskCtx, closeManager := tm.Bind(reqCtx, 0, nil)
+	taskID = tm.Async(taskCtx, WithRequestDeadline(stuck, 10*time.Minute))
+	awaitTimers(1)
+	clock.Advance(49 * time.Minute)
+	_, err = tm.Await(ctx, taskID)
+	assertError(t, err, ErrTaskTimeout)
+
+	// Canceled once the request is done. With a grace of 0 the disconnect
+	// cancels the task context right away too, and bound runnables fail
+	// with ErrTaskCanceled rather than its bare context error
+	taskID = tm.Async(taskCtx, WithRequestDeadline(stuck, 0))
+	timed := tm.Async(taskCtx, WithTimeout(stuck, time.Hour))
+	awaitTimers(2)
+	cancelReq()
+	_, err = tm.Await(ctx, taskID)
+	assertError(t, err, ErrTaskCanceled)
+	_, err = tm.Await(ctx, timed)
+	assertError(t, err, ErrTaskCanceled)
+	closeManager()
+}
+
+// Test a circuit breaker fails fast while open and probes after cooldown
+func TestCircuitBreaker(t *testing.T) {
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	tm := NewManager(WithClock(clock))
+	ctx := context.Background()
+
+	cb := NewCircuitBreaker(2, time.Minute)
+	var runs atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	wrapped := WithCircuitBreaker(RunnableFunc(func(ctx context.Context) (any, error) {
+		runs.Add(1)
+		if failing.Load() {
+			return nil, errors.New("unavailable")
+		}
+		return "ok", nil
+	}), cb)
+	run := func() error {
+		_, err := tm.Await(ctx, tm.Async(ctx, wrapped))
+		return err
+	}
+
+	assertError(t, run(), ErrTaskFailed)
+	assertEqual(t, cb.Open(), false)
+	assertError(t, run(), ErrTaskFailed)
+	assertEqual(t, cb.Open(), true)
+
+	// Open, so attempts fail without running
+	assertError(t, run(), ErrCircuitOpen)
+	assertEqual(t, runs.Load(), int32(2))
+
+	// A failed probe opens it for another cooldown
+	clock.Advance(time.Minute)
+	assertError(t, run(), ErrTaskFailed)
+	assertError(t, run(), ErrCircuitOpen)
+	assertEqual(t, runs.Load(), int32(3))
+
+	// A successful probe closes it
+	clock.Advance(time.Minute)
+	failing.Store(false)
+	assertNoError(t, run())
+	assertEqual(t, cb.Open(), false)
+	assertNoError(t, run())
+	assertEqual(t, runs.Load(), int32(5))
+}
+
+// Test a policy stacks its wrappers in a fixed order
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	// Retries within the timeout, each attempt guarded by the breaker
+	var attempts atomic.Int32
+	cb := NewCircuitBreaker(5, time.Minute)
+	flaky := RunnableFunc(func(ctx context.Context) (any, error) {
+		if attempts.Add(1) < 3 {
+			return nil, errors.New("flaky")
+		}
+		return "done", nil
+	})
+	policy := Policy().CircuitBreak(cb).Retry(3, time.Millisecond).Timeout(time.Second)
+
+	tm := NewManager()
+	result, err := tm.Await(ctx, tm.Async(ctx, policy.Apply(flaky)))
+	assertNoError(t, err)
+	assertEqual(t, result.Result, "done")
+	assertEqual(t, attempts.Load(), int32(3))
+
+	// Policies are copied, not shared
+	extended := policy.Recover(func(recovered any) error { return fmt.Errorf("recovered: %v", recovered) })
+	panicking := RunnableFunc(func(ctx context.Context) (any, error) {
+		panic("boom")
+	})
+	_, err = tm.Await(ctx, tm.Async(ctx, extended.Apply(panicking)))
+	assertError(t, err, ErrTaskFailed)
+	_, err = tm.Await(ctx, tm.Async(ctx, policy.Apply(panicking)))
+	assertError(t, err, ErrTaskPanicked)
+
+	// The default policy wraps every task
+	var waits atomic.Int32
+	tm = NewManager(WithDefaultPolicy(Policy().RateLimit(limiterFunc(func(ctx context.Context) error {
+		waits.Add(1)
+		return nil
+	}))))
+	_, err = tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	})))
+	assertNoError(t, err)
+	_, err = tm.Await(ctx, tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	})))
+	assertNoError(t, err)
+	assertEqual(t, waits.Load(), int32(2))
+
+	// Attempts the limiter turns away fail
+	errLimited := errors.New("rate limited")
+	tm = NewManager(WithDefaultPolicy(Policy().RateLimit(limiterFunc(func(ctx context.Context) error {
+		return errLimited
+	}))))
+	_, err = tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	})))
+	assertError(t, err, errLimited)
+}
+
+// limiterFunc adapts a function to a RateLimiter.
+type limiterFunc func(ctx context.Context) error
+
+func (f limiterFunc) Wait(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Test running external commands as tasks
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	tm := NewManager()
+	ctx := context.Background()
+
+	greet := Command("sh", "-c", "read name; echo hello $name")
+	greet.Stdin = []byte("world\n")
+	result, err := tm.Await(ctx, tm.Async(ctx, greet))
+	assertNoError(t, err)
+	out := result.Result.(*CommandResult)
+	assertEqual(t, out.ExitCode, 0)
+	assertEqual(t, out.Stdout, "hello world\n")
+
+	// Exit statuses fail the task unless mapped
+	result, err = tm.Await(ctx, tm.Async(ctx, Command("sh", "-c", "echo broken >&2; exit 3")))
+	assertError(t, err, ErrCommandFailed)
+	var exitErr *ExitError
+	if !errors.As(result.Error, &exitErr) {
+		t.Fatalf("expected ExitError, got %v", result.Error)
+	}
+	assertEqual(t, exitErr.Code, 3)
+	assertEqual(t, exitErr.Error(), "command failed: exit status 3: broken")
+	assertEqual(t, result.Result.(*CommandResult).ExitCode, 3)
+
+	errNoMatch := errors.New("no match")
+	grep := Command("sh", "-c", "exit $0").MapExit(1, nil).MapExit(2, errNoMatch)
+	grep.Args = append(grep.Args, "1")
+	_, err = tm.Await(ctx, tm.Async(ctx, grep))
+	assertNoError(t, err)
+	grep.Args[2] = "2"
+	_, err = tm.Await(ctx, tm.Async(ctx, grep))
+	assertError(t, err, errNoMatch)
+
+	// Output over the cap is dropped
+	capped := Command("sh", "-c", "printf 0123456789")
+	capped.MaxOutput = 4
+	result, err = tm.Await(ctx, tm.Async(ctx, capped))
+	assertNoError(t, err)
+	assertEqual(t, result.Result.(*CommandResult).Stdout, "0123")
+	assertEqual(t, result.Result.(*CommandResult).Truncated, true)
+
+	// Canceling stops the command and what it started
+	start := time.Now()
+	_, err = tm.Await(ctx, tm.Async(ctx, WithTimeout(Command("sh", "-c", "sleep 10 & wait"), 50*time.Millisecond)))
+	assertError(t, err, ErrTaskTimeout)
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("command not stopped in time, took %v", time.Since(start))
+	}
+
+	_, err = tm.Await(ctx, tm.Async(ctx, Command("frankenasync-no-such-command")))
+	assertError(t, err, ErrCommandFailed)
+}
+
+// Test the resource limits of commands
+func TestCommand_Limits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("limits are set on Linux only")
+	}
+	tm := NewManager()
+	ctx := context.Background()
+
+	// Limits are set once the command has started
+	limited := Command("sh", "-c", "sleep 0.2; ulimit -t; ulimit -n")
+	limited.Limits = CommandLimits{CPU: 1500 * time.Millisecond, OpenFiles: 32}
+	result, err := tm.Await(ctx, tm.Async(ctx, limited))
+	assertNoError(t, err)
+	assertEqual(t, result.Result.(*CommandResult).Stdout, "2\n32\n")
+
+	// Going over the CPU time ends the command
+	spin := Command("sh", "-c", "while :; do :; done")
+	spin.Limits.CPU = time.Second
+	result, err = tm.Await(ctx, tm.Async(ctx, WithTimeout(spin, 10*time.Second)))
+	assertError(t, err, ErrCommandFailed)
+	assertEqual(t, result.Result.(*CommandResult).ExitCode, -1)
+}
+
+// Test declarative HTTP request runnables
+func TestHTTPRequest(t *testing.T) {
+	var calls, active, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, `{"method":%q,"token":%q}`, r.Method, r.Header.Get("X-Token"))
+		case "/missing":
+			http.Error(w, "no such thing", http.StatusNotFound)
+		case "/slow":
+			n := active.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			active.Add(-1)
+		}
+	}))
+	defer srv.Close()
+
+	tm := NewManager()
+	ctx := context.Background()
+
+	// 503 with a Retry-After is retried, the body decoded by the hook
+	result, err := tm.Await(ctx, tm.Async(ctx, HTTPRequest(HTTPSpec{
+		Method:  http.MethodPost,
+		URL:     srv.URL + "/flaky",
+		Header:  http.Header{"X-Token": {"secret"}},
+		Retries: 1,
+		Decode:  DecodeJSON,
+	})))
+	assertNoError(t, err)
+	assertEqual(t, calls.Load(), int32(2))
+	assertEqual(t, result.Result.(map[string]any)["method"], "POST")
+	assertEqual(t, result.Result.(map[string]any)["token"], "secret")
+
+	// Unexpected statuses fail, expected ones don't
+	result, err = tm.Await(ctx, tm.Async(ctx, HTTPRequest(HTTPSpec{URL: srv.URL + "/missing"})))
+	assertError(t, err, ErrUnexpectedStatus)
+	var statusErr *StatusError
+	if !errors.As(result.Error, &statusErr) {
+		t.Fatalf("expected StatusError, got %v", result.Error)
+	}
+	assertEqual(t, statusErr.Status, http.StatusNotFound)
+	assertEqual(t, statusErr.Body, "no such thing\n")
+
+	result, err = tm.Await(ctx, tm.Async(ctx, HTTPRequest(HTTPSpec{URL: srv.URL + "/missing", Expect: []int{404}})))
+	assertNoError(t, err)
+	assertEqual(t, result.Result.(*HTTPResponse).Status, http.StatusNotFound)
+
+	// Requests to a host are limited
+	SetHTTPHostLimit(1)
+	defer SetHTTPHostLimit(DefaultHTTPHostLimit)
+	var ids []ID
+	for range 3 {
+		ids = append(ids, tm.Async(ctx, HTTPRequest(HTTPSpec{URL: srv.URL + "/slow"})))
+	}
+	for _, id := range ids {
+		_, err := tm.Await(ctx, id)
+		assertNoError(t, err)
+	}
+	assertEqual(t, peak.Load(), int32(1))
+
+	parsed, ok := parseRetryAfter("Wed, 21 Oct 2015 07:28:30 GMT", time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC))
+	assertEqual(t, ok, true)
+	assertEqual(t, parsed, 30*time.Second)
+	_, ok = parseRetryAfter("", time.Now())
+	assertEqual(t, ok, false)
+}
+
 // Test composition of wrappers
 func TestComposition_TimeoutAndRetry(t *testing.T) {
 	tm := NewManager()
@@ -197,6 +543,114 @@ func TestComposition_TimeoutAndRetry(t *testing.T) {
 	assertEqual(t, result.Result, "composed result")
 }
 
+// Test WithLogging records the start, finish and failure of tasks
+func TestWithLogging(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	tm := NewManager(WithIDGenerator(SequenceIDs()))
+	ctx := context.Background()
+
+	ok := tm.Async(ctx, WithLogging(RunnableFunc(func(ctx context.Context) (any, error) {
+		id, found := TaskIDFromContext(ctx)
+		if !found {
+			return nil, errors.New("no task ID")
+		}
+		return id.String(), nil
+	}), logger, "job", "report"))
+	result, err := tm.Await(ctx, ok)
+	assertNoError(t, err)
+	assertEqual(t, result.Result, ok.String())
+
+	failed := tm.Async(ctx, WithLogging(RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, errors.New("upstream unavailable")
+	}), logger))
+	_, err = tm.Await(ctx, failed)
+	assertError(t, err, ErrTaskFailed)
+
+	var records []map[string]any
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var rec map[string]any
+		assertNoError(t, dec.Decode(&rec))
+		records = append(records, rec)
+	}
+	assertEqual(t, len(records), 4)
+	assertEqual(t, records[0]["msg"], "Task Started")
+	assertEqual(t, records[0]["task_id"], ok.String())
+	assertEqual(t, records[0]["job"], "report")
+	assertEqual(t, records[1]["msg"], "Task Finished")
+	assertEqual(t, records[1]["level"], "INFO")
+	if _, found := records[1]["duration"]; !found {
+		t.Error("expected a duration on the finish record")
+	}
+	assertEqual(t, records[3]["msg"], "Task Failed")
+	assertEqual(t, records[3]["level"], "ERROR")
+	assertEqual(t, records[3]["task_id"], failed.String())
+	assertEqual(t, records[3]["error"], "upstream unavailable")
+
+	// Outside of tasks there is no task ID
+	_, found := TaskIDFromContext(ctx)
+	assertEqual(t, found, false)
+}
+
+// Test WithMetrics records durations and outcomes by operation name
+func TestWithMetrics(t *testing.T) {
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	tm := NewManager(WithClock(clock))
+	ctx := context.Background()
+
+	slow := tm.Async(ctx, WithMetrics(RunnableFunc(func(ctx context.Context) (any, error) {
+		clock.Advance(300 * time.Millisecond)
+		return "done", nil
+	}), "test.metrics.slow"))
+	result, err := tm.Await(ctx, slow)
+	assertNoError(t, err)
+	assertEqual(t, result.Result, "done")
+
+	timedOut := tm.Async(ctx, WithMetrics(RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, ErrTaskTimeout
+	}), "test.metrics.slow"))
+	_, err = tm.Await(ctx, timedOut)
+	assertError(t, err, ErrTaskTimeout)
+
+	var op OperationStats
+	for _, o := range Operations() {
+		if o.Name == "test.metrics.slow" {
+			op = o
+		}
+	}
+	assertEqual(t, op.Count, uint64(2))
+	assertEqual(t, op.Sum, 0.3)
+	assertEqual(t, op.Buckets[slices.Index(OperationBuckets, .25)], uint64(1))
+	assertEqual(t, op.Buckets[slices.Index(OperationBuckets, .5)], uint64(2))
+	assertEqual(t, op.Outcomes[OutcomeSuccess], uint64(1))
+	assertEqual(t, op.Outcomes[OutcomeTimeout], uint64(1))
+
+	// Around WithRetry all attempts are one operation, inside it each is one
+	tm = NewManager()
+	attempts := 0
+	flaky := RunnableFunc(func(ctx context.Context) (any, error) {
+		attempts++
+		if attempts%2 == 1 {
+			return nil, errors.New("flaky")
+		}
+		return nil, nil
+	})
+	_, err = tm.Await(ctx, tm.Async(ctx, WithMetrics(WithRetry(flaky, 1, time.Millisecond), "test.metrics.outer")))
+	assertNoError(t, err)
+	_, err = tm.Await(ctx, tm.Async(ctx, WithRetry(WithMetrics(flaky, "test.metrics.inner"), 1, time.Millisecond)))
+	assertNoError(t, err)
+
+	counts := map[string]map[string]uint64{}
+	for _, o := range Operations() {
+		counts[o.Name] = o.Outcomes
+	}
+	assertEqual(t, counts["test.metrics.outer"][OutcomeSuccess], uint64(1))
+	assertEqual(t, counts["test.metrics.outer"][OutcomeError], uint64(0))
+	assertEqual(t, counts["test.metrics.inner"][OutcomeSuccess], uint64(1))
+	assertEqual(t, counts["test.metrics.inner"][OutcomeError], uint64(1))
+}
+
 // Test basic async execution
 func TestAsync(t *testing.T) {
 	tm := NewManager()
@@ -254,18 +708,143 @@ func TestTask_Cancellation(t *testing.T) {
 	}))
 
 	// Cancel the task immediately
-	canceled := tm.Cancel(taskID)
-	if !canceled {
-		t.Fatal("expected task to be canceled")
+	result, err := tm.Cancel(taskID)
+	assertNoError(t, err)
+	if result != CancelBeforeStart && result != CancelWhileRunning {
+		t.Fatalf("expected task to be canceled, got %s", result)
 	}
 
 	// Try to await the canceled task
-	_, err := tm.Await(ctx, taskID)
+	_, err = tm.Await(ctx, taskID)
 	if err == nil || !errors.Is(err, ErrTaskNotFound) {
 		t.Fatalf("expected ErrTaskNotFound after cancel, got %v", err)
 	}
 }
 
+// Test canceling a finished task keeps its outcome
+func TestTask_CancelFinished(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return "done", nil
+	}))
+	_, err := tm.Await(ctx, taskID)
+	assertNoError(t, err)
+
+	result, err := tm.Cancel(taskID)
+	assertNoError(t, err)
+	assertEqual(t, result, CancelAlreadyFinished)
+	status, _ := tm.Status(taskID)
+	assertEqual(t, status, StatusCompleted)
+
+	future, err := tm.Await(ctx, taskID)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "done")
+
+	// A task canceled while it waits for a slot never runs
+	tm = NewManager(WithWorkerLimit(1))
+	block := make(chan struct{})
+	tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-block
+		return nil, nil
+	}))
+
+	var ran atomic.Bool
+	queued := make(chan ID)
+	go func() {
+		queued <- tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			ran.Store(true)
+			return nil, nil
+		}))
+	}()
+	for tm.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for _, future := range tm.List() {
+		if future.Status == StatusPending.String() {
+			result, err := tm.Cancel(future.ID)
+			assertNoError(t, err)
+			assertEqual(t, result, CancelBeforeStart)
+		}
+	}
+	taskID = <-queued
+	status, _ = tm.Status(taskID)
+	assertEqual(t, status, StatusCanceled)
+
+	close(block)
+	tm.Shutdown(ctx)
+
+	assertEqual(t, ran.Load(), false)
+
+	// Running and unknown tasks
+	tm = NewManager()
+	started := make(chan struct{})
+	taskID = tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	<-started
+	result, err = tm.Cancel(taskID)
+	assertNoError(t, err)
+	assertEqual(t, result, CancelWhileRunning)
+
+	result, err = tm.Cancel(ID{s: SequenceIDs()()})
+	assertError(t, err, ErrTaskNotFound)
+	assertEqual(t, result, CancelNotFound)
+}
+
+// Test the task state machine rejects impossible transitions
+func TestStatus_Transitions(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		valid    bool
+	}{
+		{StatusDeferred, StatusCanceled, true},
+		{StatusDeferred, StatusCompleted, false},
+		{StatusPending, StatusRunning, true},
+		{StatusPending, StatusFailed, true},
+		{StatusPending, StatusCompleted, false},
+		{StatusRunning, StatusCompleted, true},
+		{StatusRunning, StatusCanceled, true},
+		{StatusRunning, StatusPending, false},
+		{StatusCompleted, StatusCanceled, false},
+		{StatusCanceled, StatusCompleted, false},
+		{StatusFailed, StatusRunning, false},
+		{StatusUnknown, StatusRunning, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.canTransition(tt.to); got != tt.valid {
+			t.Errorf("%s -> %s: got %v, want %v", tt.from, tt.to, got, tt.valid)
+		}
+	}
+
+	// Transitions stamp the record
+	tm := NewManager()
+	ctx := context.Background()
+	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, nil
+	}))
+	result, err := tm.Await(ctx, taskID)
+	assertNoError(t, err)
+
+	future, err := tm.Future(taskID)
+	assertNoError(t, err)
+	if future.Submitted.IsZero() || future.Time.Before(future.Submitted) || future.Finished.Sub(future.Time) < 5*time.Millisecond {
+		t.Fatalf("transition times out of order: %v, %v, %v", future.Submitted, future.Time, future.Finished)
+	}
+	assertEqual(t, result.Finished, future.Finished)
+
+	// A completed task can't be canceled
+	rec, _ := tm.tasks.load(taskID)
+	if _, ok := rec.transition(StatusCanceled); ok {
+		t.Fatal("expected completed -> canceled to be rejected")
+	}
+	assertEqual(t, future.Finished, rec.future().Finished)
+}
+
 // TestAwait_Cancellation verifies that awaiting a task respects the context cancellation.
 func TestAwait_Cancellation(t *testing.T) {
 	tm := NewManager()
@@ -295,6 +874,72 @@ func TestAwait_Cancellation(t *testing.T) {
 	}
 }
 
+// TestWaitFor verifies that a wait timing out leaves the task running.
+func TestWaitFor(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return "result", nil
+	}))
+
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err := tm.WaitFor(waitCtx, taskID)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	status, _ := tm.Status(taskID)
+	assertEqual(t, status, StatusRunning)
+
+	close(release)
+	future, err := tm.WaitFor(ctx, taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, future.Result, "result")
+}
+
+// TestWait verifies waiting for all outstanding tasks, or those with labels
+func TestWait(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	fast, slow := make(chan struct{}), make(chan struct{})
+	var finished atomic.Int32
+	run := func(release chan struct{}) Runnable {
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			<-release
+			finished.Add(1)
+			return nil, nil
+		})
+	}
+	tm.Async(WithLabels(ctx, map[string]string{"group": "fast"}), run(fast))
+	tm.Async(WithLabels(ctx, map[string]string{"group": "slow"}), run(slow))
+	tm.Defer(ctx, run(slow))
+
+	// Nothing finished yet: the wait times out and leaves the tasks running
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := tm.Wait(waitCtx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	assertEqual(t, tm.Stats().Running, 2)
+
+	close(fast)
+	assertNoError(t, tm.Wait(ctx, map[string]string{"group": "fast"}))
+	assertEqual(t, finished.Load(), int32(1))
+
+	// The deferred task is never awaited, so it isn't waited for
+	close(slow)
+	assertNoError(t, tm.Wait(ctx, nil))
+	assertEqual(t, finished.Load(), int32(2))
+	assertEqual(t, tm.Stats().Deferred, 1)
+}
+
 // TestAwait_Concurrent verifies that multiple goroutines can concurrently
 // await the same task without causing race conditions or inconsistent results.
 func TestAwait_Concurrent(t *testing.T) {
@@ -456,6 +1101,43 @@ func TestAwaitAll_MixedAsyncDefer(t *testing.T) {
 	assertEqual(t, results[1].Result, "defer1")
 }
 
+// Test AwaitAllSettled returns the result or error of every task
+func TestAwaitAllSettled(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	taskIDs := []ID{
+		tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			return nil, errors.New("task failed")
+		})),
+		tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			time.Sleep(10 * time.Millisecond)
+			return "success", nil
+		})),
+		ID{s: NewXID()},
+	}
+
+	results, errs, err := tm.AwaitAllSettled(ctx, taskIDs)
+	assertNoError(t, err)
+	assertEqual(t, len(results), 3)
+	assertError(t, errs[0], ErrTaskFailed)
+	assertNoError(t, errs[1])
+	assertEqual(t, results[1].Result, "success")
+	assertError(t, errs[2], ErrTaskNotFound)
+
+	// A timeout cancels the tasks and returns no results
+	slow := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	results, errs, err = tm.AwaitAllSettled(timeoutCtx, []ID{slow})
+	assertError(t, err, ErrTaskTimeout)
+	assertEqual(t, len(results)+len(errs), 0)
+}
+
 // TestAwaitAny verifies that AwaitAny returns the result of the first task to complete.
 func TestAwaitAny(t *testing.T) {
 	tm := NewManager()
@@ -481,47 +1163,435 @@ func TestAwaitAny(t *testing.T) {
 	assertEqual(t, result.Result, "fast")
 }
 
-// Test Status
-func TestTaskStatus(t *testing.T) {
+// Test AwaitAnyIndex reports the winner's position
+func TestAwaitAnyIndex(t *testing.T) {
 	tm := NewManager()
 	ctx := context.Background()
 
-	// Check status transitions
-	taskStarted := make(chan struct{})
-	taskContinue := make(chan struct{})
+	taskIDs := []ID{
+		tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			time.Sleep(100 * time.Millisecond)
+			return "slow", nil
+		})),
+		tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			time.Sleep(200 * time.Millisecond)
+			return "deferred", nil
+		})),
+		tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			time.Sleep(10 * time.Millisecond)
+			return "fast", nil
+		})),
+	}
 
-	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
-		close(taskStarted)
-		<-taskContinue
-		return "done", nil
+	index, result, err := tm.AwaitAnyIndex(ctx, taskIDs)
+	assertNoError(t, err)
+	assertEqual(t, index, 2)
+	assertEqual(t, result.Result, "fast")
+
+	index, _, err = tm.AwaitAnyIndex(ctx, nil)
+	assertNoError(t, err)
+	assertEqual(t, index, -1)
+}
+
+// Test awaiting many tasks doesn't start a goroutine per task
+func TestAwait_FanIn(t *testing.T) {
+	tm := NewManager(WithWorkerLimit(200))
+	defer tm.Shutdown(context.Background())
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	var taskIDs []ID
+	for range 100 {
+		taskIDs = append(taskIDs, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			<-release
+			return "done", nil
+		})))
+	}
+	winner := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		time.Sleep(20 * time.Millisecond)
+		return "first", nil
 	}))
+	taskIDs = append(taskIDs, winner)
 
-	// Wait for task to start
-	<-taskStarted
+	before := runtime.NumGoroutine()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := tm.AwaitAll(ctx, taskIDs[:100])
+		assertNoError(t, err)
+	}()
+	for range 10 {
+		go func() { _, _ = tm.WaitFor(ctx, taskIDs[0]) }()
+	}
 
-	status, err := tm.Status(taskID)
+	index, result, err := tm.AwaitAnyIndex(ctx, taskIDs[100:])
 	assertNoError(t, err)
-	assertEqual(t, status, StatusRunning)
+	assertEqual(t, index, 0)
+	assertEqual(t, result.Result, "first")
 
-	// Let task complete
-	close(taskContinue)
+	// Only the awaiters started above, none per task
+	if n := runtime.NumGoroutine() - before; n > 11 {
+		t.Fatalf("expected at most 11 more goroutines, got %d", n)
+	}
 
-	_, err = tm.Await(ctx, taskID)
-	assertNoError(t, err)
+	close(release)
+	<-done
 
-	status, err = tm.Status(taskID)
-	assertNoError(t, err)
-	assertEqual(t, status, StatusCompleted)
+	// Watchers are dropped once AwaitAny returns
+	rec, _ := tm.tasks.load(winner)
+	assertEqual(t, len(rec.watchers), 0)
 }
 
-// Test non-existent task
-func TestNonExistentTask(t *testing.T) {
+// Test listing futures with labels
+func TestList(t *testing.T) {
 	tm := NewManager()
-	ctx := context.Background()
+	ctx := WithLabels(context.Background(), map[string]string{"page": "home"})
 
-	fakeID := ID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	first := tm.Async(WithLabels(ctx, map[string]string{"fragment": "header"}), RunnableFunc(func(ctx context.Context) (any, error) {
+		return "header", nil
+	}))
+	_, err := tm.Await(ctx, first)
+	assertNoError(t, err)
 
-	_, err := tm.Await(ctx, fakeID)
+	second := tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return "footer", nil
+	}))
+
+	futures := tm.List()
+	assertEqual(t, len(futures), 2)
+
+	assertEqual(t, futures[0].ID, first)
+	assertEqual(t, futures[0].Status, "completed")
+	assertEqual(t, futures[0].Labels["page"], "home")
+	assertEqual(t, futures[0].Labels["fragment"], "header")
+
+	assertEqual(t, futures[1].ID, second)
+	assertEqual(t, futures[1].Status, "deferred")
+	assertEqual(t, futures[1].Labels["page"], "home")
+	assertEqual(t, futures[1].Labels["fragment"], "")
+
+	tm.Prune(0)
+	assertEqual(t, len(tm.List()), 1)
+}
+
+// Test retrying a failed task
+func TestRetry(t *testing.T) {
+	tm := NewManager()
+	ctx := WithLabels(context.Background(), map[string]string{"name": "flaky"})
+
+	var attempts atomic.Int32
+	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		if attempts.Add(1) == 1 {
+			return nil, errors.New("first attempt fails")
+		}
+		return "ok", nil
+	}))
+
+	_, err := tm.Await(ctx, taskID)
+	assertError(t, err, ErrTaskFailed)
+
+	retryID, err := tm.Retry(taskID)
+	assertNoError(t, err)
+
+	result, err := tm.Await(ctx, retryID)
+	assertNoError(t, err)
+	assertEqual(t, result.Result, "ok")
+
+	future, err := tm.Future(retryID)
+	assertNoError(t, err)
+	assertEqual(t, future.Labels["name"], "flaky")
+
+	// Only failed tasks can be retried
+	_, err = tm.Retry(retryID)
+	assertError(t, err, ErrTaskNotRetryable)
+
+	_, err = tm.Retry(ID{s: SequenceIDs()()})
+	assertError(t, err, ErrTaskNotFound)
+}
+
+func init() {
+	RegisterRunnable("test.greet", func(params map[string]any) (Runnable, error) {
+		name, ok := params["name"].(string)
+		if !ok {
+			return nil, errors.New("name required")
+		}
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			return "hello " + name, nil
+		}), nil
+	})
+}
+
+// Test submitting tasks by the name of a registered runnable
+func TestSubmit(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	spec := Spec{Name: "test.greet", Params: map[string]any{"name": "world"}}
+	taskID, err := tm.Submit(ctx, spec)
+	assertNoError(t, err)
+
+	result, err := tm.Await(ctx, taskID)
+	assertNoError(t, err)
+	assertEqual(t, result.Result, "hello world")
+
+	future, err := tm.Future(taskID)
+	assertNoError(t, err)
+	assertEqual(t, future.Labels[RunnableLabel], "test.greet")
+
+	got, err := tm.Spec(taskID)
+	assertNoError(t, err)
+	assertEqual(t, got.Params["name"], "world")
+
+	// Specs survive a round trip through JSON
+	data, err := json.Marshal(spec)
+	assertNoError(t, err)
+	var decoded Spec
+	assertNoError(t, json.Unmarshal(data, &decoded))
+	_, err = tm.Submit(ctx, decoded)
+	assertNoError(t, err)
+
+	_, err = tm.Submit(ctx, Spec{Name: "test.greet"})
+	if err == nil || !strings.Contains(err.Error(), "name required") {
+		t.Fatalf("expected params error, got %v", err)
+	}
+
+	_, err = tm.Submit(ctx, Spec{Name: "test.missing"})
+	assertError(t, err, ErrRunnableNotRegistered)
+
+	// Tasks started from a closure have no spec
+	_, err = tm.Spec(tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) { return nil, nil })))
+	assertError(t, err, ErrTaskNotFound)
+
+	if !slices.Contains(Runnables(), "test.greet") {
+		t.Fatalf("expected test.greet in %v", Runnables())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic registering a name twice")
+		}
+	}()
+	RegisterRunnable("test.greet", func(map[string]any) (Runnable, error) { return nil, nil })
+}
+
+// Test replaying tasks submitted from a spec
+func TestReplay(t *testing.T) {
+	tm := NewManager()
+	ctx := WithLabels(context.Background(), map[string]string{"name": "greeting"})
+
+	taskID, err := tm.Submit(ctx, Spec{Name: "test.greet", Params: map[string]any{"name": "again"}})
+	assertNoError(t, err)
+
+	// Completed tasks can be replayed, unlike retried
+	_, err = tm.Await(ctx, taskID)
+	assertNoError(t, err)
+
+	replayID, err := tm.Replay(taskID)
+	assertNoError(t, err)
+	if replayID == taskID {
+		t.Fatal("expected a new task ID")
+	}
+
+	result, err := tm.Await(ctx, replayID)
+	assertNoError(t, err)
+	assertEqual(t, result.Result, "hello again")
+
+	future, err := tm.Future(replayID)
+	assertNoError(t, err)
+	assertEqual(t, future.Labels["name"], "greeting")
+	assertEqual(t, future.Labels[RunnableLabel], "test.greet")
+
+	release := make(chan struct{})
+	closureID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}))
+	// Running tasks and tasks without a spec can't be replayed
+	_, err = tm.Replay(closureID)
+	assertError(t, err, ErrTaskNotReplayable)
+	close(release)
+
+	_, err = tm.Await(ctx, closureID)
+	assertNoError(t, err)
+	_, err = tm.Replay(closureID)
+	assertError(t, err, ErrTaskNotReplayable)
+
+	_, err = tm.Replay(ID{s: SequenceIDs()()})
+	assertError(t, err, ErrTaskNotFound)
+}
+
+// Test lifecycle events
+func TestEvents(t *testing.T) {
+	var mu sync.Mutex
+	var events []Event
+
+	tm := NewManager(WithEventHandler(func(ev Event) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}))
+	ctx := WithLabels(context.Background(), map[string]string{"name": "job"})
+
+	ok := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return "ok", nil
+	}))
+	_, err := tm.Await(ctx, ok)
+	assertNoError(t, err)
+
+	failed := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, errors.New("boom")
+	}))
+	_, _ = tm.Await(ctx, failed)
+
+	deferred := tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	}))
+	tm.Cancel(deferred)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []struct {
+		typ EventType
+		id  ID
+	}{
+		{EventSubmitted, ok}, {EventStarted, ok}, {EventCompleted, ok},
+		{EventSubmitted, failed}, {EventStarted, failed}, {EventFailed, failed},
+		{EventSubmitted, deferred}, {EventCanceled, deferred},
+	}
+	assertEqual(t, len(events), len(want))
+	for i, w := range want {
+		assertEqual(t, events[i].Type, w.typ)
+		assertEqual(t, events[i].ID, w.id)
+		assertEqual(t, events[i].Labels["name"], "job")
+	}
+	if events[5].Error == nil {
+		t.Fatal("expected error on failed event")
+	}
+	assertEqual(t, events[2].Result, "ok")
+}
+
+// Test capturing task logs
+func TestLogs(t *testing.T) {
+	tm := NewManager(WithLogCapacity(2))
+	ctx := context.Background()
+
+	taskID := tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		logger := LoggerFromContext(ctx).WithGroup("job")
+		logger.Info("starting", "step", 1)
+		logger.Debug("working", "step", 2)
+		logger.Warn("retrying", slog.Group("http", "status", 503))
+		return nil, errors.New("upstream unavailable")
+	}))
+
+	logs, err := tm.Logs(taskID)
+	assertNoError(t, err)
+	assertEqual(t, len(logs), 0)
+
+	_, awaitErr := tm.Await(ctx, taskID)
+	assertError(t, awaitErr, ErrTaskFailed)
+
+	// Only the last two records are kept
+	logs, err = tm.Logs(taskID)
+	assertNoError(t, err)
+	assertEqual(t, len(logs), 2)
+	assertEqual(t, logs[0].Message, "working")
+	assertEqual(t, logs[0].Level, "DEBUG")
+	assertEqual(t, logs[1].Message, "retrying")
+	assertEqual(t, logs[1].Attrs["job.http.status"], int64(503))
+
+	// Failed tasks carry their log tail
+	var logged *LoggedError
+	if !errors.As(awaitErr, &logged) {
+		t.Fatalf("expected LoggedError, got %v", awaitErr)
+	}
+	assertEqual(t, len(logged.Logs), 2)
+	assertEqual(t, logged.Error(), "upstream unavailable")
+
+	_, err = tm.Logs(ID{s: SequenceIDs()()})
+	assertError(t, err, ErrTaskNotFound)
+
+	// Capture can be disabled
+	tm = NewManager(WithLogCapacity(0))
+	taskID = tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		LoggerFromContext(ctx).Info("not captured")
+		return nil, errors.New("failed")
+	}))
+	_, err = tm.Await(ctx, taskID)
+	if errors.As(err, &logged) {
+		t.Fatal("expected no LoggedError")
+	}
+	logs, _ = tm.Logs(taskID)
+	assertEqual(t, len(logs), 0)
+}
+
+// Test request ID tagging
+func TestRequestID(t *testing.T) {
+	var out bytes.Buffer
+	tm := NewManager(
+		WithLogger(slog.NewJSONHandler(&out, nil)),
+		WithRequestID("req-1"),
+	)
+	ctx := context.Background()
+	assertEqual(t, tm.RequestID(), "req-1")
+
+	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		LoggerFromContext(ctx).Info("fetching")
+		return nil, nil
+	}))
+	_, err := tm.Await(ctx, taskID)
+	assertNoError(t, err)
+
+	var record map[string]any
+	assertNoError(t, json.NewDecoder(&out).Decode(&record))
+	assertEqual(t, record["msg"], "fetching")
+	assertEqual(t, record["request_id"], "req-1")
+	assertEqual(t, record["task_id"], taskID.String())
+
+	assertEqual(t, NewManager().RequestID(), "")
+}
+
+// Test Status
+func TestTaskStatus(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	// Check status transitions
+	taskStarted := make(chan struct{})
+	taskContinue := make(chan struct{})
+
+	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(taskStarted)
+		<-taskContinue
+		return "done", nil
+	}))
+
+	// Wait for task to start
+	<-taskStarted
+
+	status, err := tm.Status(taskID)
+	assertNoError(t, err)
+	assertEqual(t, status, StatusRunning)
+
+	// Let task complete
+	close(taskContinue)
+
+	_, err = tm.Await(ctx, taskID)
+	assertNoError(t, err)
+
+	status, err = tm.Status(taskID)
+	assertNoError(t, err)
+	assertEqual(t, status, StatusCompleted)
+}
+
+// Test non-existent task
+func TestNonExistentTask(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	fakeID := ID{s: SequenceIDs()()}
+
+	_, err := tm.Await(ctx, fakeID)
 	assertError(t, err, ErrTaskNotFound)
 
 	status, err := tm.Status(fakeID)
@@ -529,176 +1599,2491 @@ func TestNonExistentTask(t *testing.T) {
 	assertEqual(t, status, StatusUnknown)
 }
 
-// Test panic recovery
-func TestPanicRecovery(t *testing.T) {
-	tm := NewManager()
-	ctx := context.Background()
+// Test panic recovery
+func TestPanicRecovery(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		panic("test panic")
+	}))
+
+	result, err := tm.Await(ctx, taskID)
+	if err == nil {
+		t.Fatal("expected error from panicked task, got nil")
+	}
+
+	if !errors.Is(err, ErrTaskFailed) {
+		t.Fatalf("expected ErrTaskFailed, got %v", err)
+	}
+
+	if !errors.Is(result.Error, ErrTaskPanicked) {
+		t.Fatalf("expected ErrTaskPanicked in result, got %v", result.Error)
+	}
+}
+
+// Test panics reach the panic handler with their stack
+func TestPanicHandler(t *testing.T) {
+	type report struct {
+		id        ID
+		recovered any
+		stack     []byte
+	}
+	reports := make(chan report, 1)
+	tm := NewManager(WithPanicHandler(func(taskID ID, recovered any, stack []byte) {
+		reports <- report{taskID, recovered, stack}
+	}))
+	ctx := context.Background()
+
+	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		panic("test panic")
+	}))
+	result, _ := tm.Await(ctx, taskID)
+
+	got := <-reports
+	assertEqual(t, got.id, taskID)
+	assertEqual(t, got.recovered, any("test panic"))
+	if !bytes.Contains(got.stack, []byte("TestPanicHandler")) {
+		t.Fatalf("expected stack of the panicking task, got %s", got.stack)
+	}
+
+	var panicErr *PanicError
+	if !errors.As(result.Error, &panicErr) {
+		t.Fatalf("expected PanicError in result, got %v", result.Error)
+	}
+	assertEqual(t, panicErr.Value, any("test panic"))
+	if !strings.Contains(result.Error.Error(), "TestPanicHandler") {
+		t.Fatalf("expected stack in error message, got %q", result.Error.Error())
+	}
+}
+
+// Test WithRecover maps panics to domain errors, retried when marked
+func TestWithRecover(t *testing.T) {
+	panics := make(chan any, 1)
+	tm := NewManager(WithPanicHandler(func(taskID ID, recovered any, stack []byte) {
+		panics <- recovered
+	}))
+	ctx := context.Background()
+
+	errQuota := errors.New("quota exhausted")
+	mapping := func(recovered any) error {
+		switch recovered {
+		case "quota":
+			return errQuota
+		case "flaky":
+			return Retryable(errors.New("connection reset"))
+		}
+		return nil
+	}
+
+	// Mapped panics fail the task with the domain error, not retried
+	var attempts atomic.Int32
+	taskID := tm.Async(ctx, WithRetry(WithRecover(RunnableFunc(func(ctx context.Context) (any, error) {
+		attempts.Add(1)
+		panic("quota")
+	}), mapping), 3, time.Millisecond))
+	result, err := tm.Await(ctx, taskID)
+	assertError(t, err, ErrTaskFailed)
+	assertError(t, result.Error, errQuota)
+	assertEqual(t, errors.Is(result.Error, ErrTaskPanicked), false)
+	assertEqual(t, attempts.Load(), int32(1))
+
+	var recovered *RecoveredError
+	if !errors.As(result.Error, &recovered) {
+		t.Fatalf("expected RecoveredError in result, got %v", result.Error)
+	}
+	assertEqual(t, recovered.Value, any("quota"))
+	if !bytes.Contains(recovered.Stack, []byte("TestWithRecover")) {
+		t.Fatalf("expected stack of the panicking task, got %s", recovered.Stack)
+	}
+
+	// Retryable ones are retried
+	attempts.Store(0)
+	taskID = tm.Async(ctx, WithRetry(WithRecover(RunnableFunc(func(ctx context.Context) (any, error) {
+		if attempts.Add(1) == 1 {
+			panic("flaky")
+		}
+		return "recovered", nil
+	}), mapping), 3, time.Millisecond))
+	result, err = tm.Await(ctx, taskID)
+	assertNoError(t, err)
+	assertEqual(t, result.Result, "recovered")
+	assertEqual(t, attempts.Load(), int32(2))
+
+	// Unmapped panics fail the task as usual
+	taskID = tm.Async(ctx, WithRecover(RunnableFunc(func(ctx context.Context) (any, error) {
+		panic("unexpected")
+	}), mapping))
+	result, _ = tm.Await(ctx, taskID)
+	assertError(t, result.Error, ErrTaskPanicked)
+	assertEqual(t, <-panics, any("unexpected"))
+}
+
+// Test idempotent await
+func TestIdempotentAwait(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	expected := "test result"
+	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return expected, nil
+	}))
+
+	// Await multiple times
+	result1, err1 := tm.Await(ctx, taskID)
+	assertNoError(t, err1)
+
+	result2, err2 := tm.Await(ctx, taskID)
+	assertNoError(t, err2)
+
+	// Results should be identical
+	assertEqual(t, result1.Result, result2.Result)
+	assertEqual(t, result1.ID, result2.ID)
+}
+
+// Test concurrent task execution
+func TestConcurrentTasks(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	numTasks := 100
+	var taskIDs []ID
+	results := make([]int, numTasks)
+
+	for i := 0; i < numTasks; i++ {
+		idx := i // capture loop variable
+		taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			return idx, nil
+		}))
+		taskIDs = append(taskIDs, taskID)
+	}
+
+	// Await all tasks concurrently
+	var wg sync.WaitGroup
+	for i, taskID := range taskIDs {
+		wg.Add(1)
+		go func(index int, id ID) {
+			defer wg.Done()
+			result, err := tm.Await(ctx, id)
+			if err != nil {
+				t.Errorf("task %d failed: %v", index, err)
+				return
+			}
+			results[index] = result.Result.(int)
+		}(i, taskID)
+	}
+
+	wg.Wait()
+
+	// Verify all results
+	for i := 0; i < numTasks; i++ {
+		if results[i] != i {
+			t.Errorf("expected result[%d] = %d, got %d", i, i, results[i])
+		}
+	}
+}
+
+// Test Shutdown
+func TestShutdown(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	// Start several long-running tasks
+	numTasks := 10
+	var taskIDs []ID
+	for i := 0; i < numTasks; i++ {
+		taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			select {
+			case <-time.After(1 * time.Second):
+				return "should not complete", nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}))
+		taskIDs = append(taskIDs, taskID)
+	}
+
+	// Give tasks a moment to start
+	time.Sleep(10 * time.Millisecond)
+
+	// Shutdown with timeout
+	shutdownCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	report, err := tm.Shutdown(shutdownCtx)
+	assertNoError(t, err)
+	assertEqual(t, len(report.Canceled), numTasks)
+	assertEqual(t, len(report.Completed)+len(report.Abandoned), 0)
+
+	// Verify tasks are cleaned up
+	if count := tm.tasks.len(); count > 0 {
+		t.Errorf("expected all tasks to be cleaned up, but found %d remaining", count)
+	}
+
+	// Verify we can't await the canceled tasks
+	for _, taskID := range taskIDs {
+		_, err := tm.Await(context.Background(), taskID)
+		if err == nil || !errors.Is(err, ErrTaskNotFound) {
+			t.Errorf("expected ErrTaskNotFound for shutdown task, got %v", err)
+		}
+	}
+}
+
+// TestStress_WorkerLimit verifies that the Manager respects a worker concurrency limit.
+func TestStress_WorkerLimit(t *testing.T) {
+	t.Log("Stress test: verify manager respects worker concurrency limit")
+	tm := NewManager(WithWorkerLimit(2))
+	ctx := context.Background()
+
+	running := int32(0)
+	maxConcurrent := int32(0)
+
+	var taskIDs []ID
+	for i := 0; i < 10; i++ {
+		taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			current := atomic.AddInt32(&running, 1)
+
+			for {
+				max := atomic.LoadInt32(&maxConcurrent)
+				if current <= max || atomic.CompareAndSwapInt32(&maxConcurrent, max, current) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil, nil
+		}))
+		taskIDs = append(taskIDs, taskID)
+	}
+
+	_, err := tm.AwaitAll(ctx, taskIDs)
+	assertNoError(t, err)
+
+	if maxConcurrent > 2 {
+		t.Errorf("expected max concurrent tasks <= 2, got %d", maxConcurrent)
+	}
+}
+
+// Test that time spent waiting for a worker slot is tracked
+func TestStats_WorkerWait(t *testing.T) {
+	tm := NewManager(WithWorkerLimit(1))
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	first := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}))
+
+	second := make(chan ID)
+	go func() {
+		second <- tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			return nil, nil
+		}))
+	}()
+
+	// Wait for the second submission to block on the full pool
+	deadline := time.Now().Add(time.Second)
+	for tm.Stats().Waiting == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assertEqual(t, tm.Stats().Waiting, 1)
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	id := <-second
+
+	_, err := tm.AwaitAll(ctx, []ID{first, id})
+	assertNoError(t, err)
+
+	stats := tm.Stats()
+	assertEqual(t, stats.Waiting, 0)
+	assertEqual(t, stats.Acquired, 2)
+	assertEqual(t, stats.PeakWorkers, 1)
+	if stats.WaitMax < 10*time.Millisecond || stats.WaitTotal < stats.WaitMax {
+		t.Errorf("expected a wait of at least 10ms, got max %v total %v", stats.WaitMax, stats.WaitTotal)
+	}
+	if stats.RunTotal < 10*time.Millisecond || stats.AwaitTotal <= 0 {
+		t.Errorf("expected the first task's 10ms run and an await, got run %v await %v", stats.RunTotal, stats.AwaitTotal)
+	}
+
+	future, err := tm.Future(id)
+	assertNoError(t, err)
+	assertEqual(t, future.Wait, stats.WaitMax)
+}
+
+// Test that resizing the pool hands slots to queued submissions
+func TestResize(t *testing.T) {
+	tm := NewManager(WithWorkerLimit(1))
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	first := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}))
+
+	second := make(chan ID)
+	go func() {
+		second <- tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			return "second", nil
+		}))
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for tm.Stats().Waiting == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	tm.Resize(2)
+	id := <-second
+	future, err := tm.Await(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "second")
+	assertEqual(t, tm.Stats().WorkerLimit, 2)
+
+	// Shrinking below the slots in use leaves running tasks alone
+	tm.Resize(0)
+	assertEqual(t, tm.Stats().WorkerLimit, 1)
+	close(release)
+	_, err = tm.Await(ctx, first)
+	assertNoError(t, err)
+}
+
+// Test that an autoscaled pool grows while submissions queue and shrinks
+// back when idle
+func TestAutoscale(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	tm := NewManager(WithClock(clock), WithAutoscale(1, 3, QueuePolicy(0)))
+	defer tm.Shutdown(context.Background())
+	ctx := context.Background()
+	assertEqual(t, tm.Stats().WorkerLimit, 1)
+
+	release := make(chan struct{})
+	var ids []ID
+	for range 3 {
+		ids = append(ids, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			<-release
+			return nil, nil
+		})))
+	}
+
+	// Submitting would block on a fixed pool of 1
+	stats := tm.Stats()
+	assertEqual(t, stats.WorkerLimit, 3)
+	assertEqual(t, stats.Workers, 3)
+
+	// Resizing stays within the bounds
+	tm.Resize(10)
+	assertEqual(t, tm.Stats().WorkerLimit, 3)
+
+	close(release)
+	_, err := tm.AwaitAll(ctx, ids)
+	assertNoError(t, err)
+
+	// Idle slots are given back one check at a time
+	for tm.Stats().WorkerLimit > 1 {
+		if clock.Timers() > 0 {
+			clock.Advance(autoscaleInterval)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assertEqual(t, tm.Stats().WorkerLimit, 1)
+}
+
+// Test that tasks in a named pool don't wait for the default pool
+func TestPools(t *testing.T) {
+	tm := NewManager(WithWorkerLimit(1), WithPool("io", 2))
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	blocked := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}))
+
+	io := WithLabels(ctx, map[string]string{PoolLabel: "io"})
+	var ids []ID
+	for range 2 {
+		ids = append(ids, tm.Async(io, RunnableFunc(func(ctx context.Context) (any, error) {
+			return "io", nil
+		})))
+	}
+	_, err := tm.AwaitAll(ctx, ids)
+	assertNoError(t, err)
+
+	stats := tm.Stats()
+	assertEqual(t, stats.WorkerLimit, 3)
+	assertEqual(t, stats.Pools["io"].WorkerLimit, 2)
+	assertEqual(t, stats.Workers, 1)
+
+	missing := tm.Async(WithLabels(ctx, map[string]string{PoolLabel: "gpu"}), RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	}))
+	_, err = tm.Await(ctx, missing)
+	if !errors.Is(err, ErrPoolNotFound) {
+		t.Fatalf("expected ErrPoolNotFound, got %v", err)
+	}
+	status, _ := tm.Status(missing)
+	assertEqual(t, status, StatusFailed)
+
+	close(release)
+	_, err = tm.Await(ctx, blocked)
+	assertNoError(t, err)
+}
+
+// Test that queued slots are shared between requests by their shares
+func TestFairScheduling(t *testing.T) {
+	tm := NewManager(WithWorkerLimit(1), WithFairScheduling("request", map[string]int{"b": 2}))
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	blocked := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}))
+
+	var mu sync.Mutex
+	var order []string
+	ids := make(chan ID, 8)
+	for i, request := range []string{"a", "a", "a", "a", "b", "b", "b", "b"} {
+		labeled := WithLabels(ctx, map[string]string{"request": request})
+		go func() {
+			ids <- tm.Async(labeled, RunnableFunc(func(ctx context.Context) (any, error) {
+				mu.Lock()
+				order = append(order, request)
+				mu.Unlock()
+				return nil, nil
+			}))
+		}()
+
+		// Queue the submissions one by one
+		deadline := time.Now().Add(time.Second)
+		for tm.Stats().Waiting <= i && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	close(release)
+	_, err := tm.Await(ctx, blocked)
+	assertNoError(t, err)
+	var all []ID
+	for range 8 {
+		all = append(all, <-ids)
+	}
+	_, err = tm.AwaitAll(ctx, all)
+	assertNoError(t, err)
+
+	// a was queued first, b gets two slots per turn, a the rest
+	assertEqual(t, strings.Join(order, ""), "abbabbaa")
+}
+
+// Test that tasks beyond the quotas fail without running
+func TestQuotas(t *testing.T) {
+	ctx := context.Background()
+	noop := RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	})
+
+	tm := NewManager(WithMaxTasksPerRequest(2))
+	deferred := tm.Defer(ctx, noop)
+	_, err := tm.Await(ctx, tm.Async(ctx, noop))
+	assertNoError(t, err)
+
+	// Promoting the deferred task doesn't count again
+	_, err = tm.Await(ctx, deferred)
+	assertNoError(t, err)
+
+	for _, id := range []ID{tm.Async(ctx, noop), tm.Defer(ctx, noop)} {
+		_, err = tm.Await(ctx, id)
+		if !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+		}
+		status, _ := tm.Status(id)
+		assertEqual(t, status, StatusFailed)
+	}
+
+	tm = NewManager(WithRequestBudget(10 * time.Millisecond))
+	_, err = tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		time.Sleep(15 * time.Millisecond)
+		return nil, nil
+	})))
+	assertNoError(t, err)
+
+	_, err = tm.Await(ctx, tm.Async(ctx, noop))
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+// Test awaits share the await budget and fail fast once it's spent
+func TestAwaitBudget(t *testing.T) {
+	ctx := context.Background()
+	noop := RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	})
+	stuck := RunnableFunc(func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	tm := NewManager(WithAwaitBudget(20 * time.Millisecond))
+	_, err := tm.Await(ctx, tm.Async(ctx, noop))
+	assertNoError(t, err)
+	_, exceeded := tm.AwaitTime()
+	assertEqual(t, exceeded, false)
+
+	// The await running into the budget stops, canceling its task
+	stuckID := tm.Async(ctx, stuck)
+	_, err = tm.Await(ctx, stuckID)
+	assertError(t, err, ErrBudgetExceeded)
+	status, _ := tm.Status(stuckID)
+	assertEqual(t, status, StatusCanceled)
+
+	// Later awaits fail right away, even for finished tasks
+	done := tm.Async(ctx, noop)
+	_, err = tm.WaitFor(ctx, done)
+	assertError(t, err, ErrBudgetExceeded)
+	_, err = tm.AwaitAll(ctx, []ID{done})
+	assertError(t, err, ErrBudgetExceeded)
+	_, err = tm.AwaitAny(ctx, []ID{done})
+	assertError(t, err, ErrBudgetExceeded)
+
+	awaited, exceeded := tm.AwaitTime()
+	assertEqual(t, exceeded, true)
+	if awaited < 20*time.Millisecond {
+		t.Fatalf("expected at least 20ms awaited, got %v", awaited)
+	}
+	stats := tm.Stats()
+	assertEqual(t, stats.OverBudget, 4)
+	assertEqual(t, stats.AwaitTotal, awaited)
+
+	// A canceled context stays a cancellation
+	tm = NewManager(WithAwaitBudget(time.Second))
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = tm.Await(canceled, tm.Async(ctx, stuck))
+	assertError(t, err, ErrTaskCanceled)
+}
+
+// Test result sizes are accounted and results over the limit dropped
+func TestMaxTaskMemory(t *testing.T) {
+	ctx := context.Background()
+	result := func(v any) Runnable {
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			return v, nil
+		})
+	}
+
+	tm := NewManager(WithMaxTaskMemory(1024), WithLogger(slog.DiscardHandler))
+	small, err := tm.Await(ctx, tm.Async(ctx, result(strings.Repeat("x", 100))))
+	assertNoError(t, err)
+	assertEqual(t, small.Memory, int64(100))
+
+	nested, err := tm.Await(ctx, tm.Async(ctx, result(map[string]any{"body": []byte("hello"), "rows": []int32{1, 2}})))
+	assertNoError(t, err)
+	assertEqual(t, nested.Memory, int64(4+5+4+8))
+
+	bigID := tm.Async(ctx, result(&CommandResult{Stdout: strings.Repeat("x", 2048)}))
+	big, err := tm.Await(ctx, bigID)
+	assertError(t, err, ErrMemoryExceeded)
+	assertEqual(t, big.Result, nil)
+	assertEqual(t, big.Memory, int64(8+2048+1+8))
+	status, _ := tm.Status(bigID)
+	assertEqual(t, status, StatusFailed)
+
+	stats := tm.Stats()
+	assertEqual(t, stats.OverMemory, 1)
+	assertEqual(t, stats.MemoryMax, int64(100))
+	assertEqual(t, stats.Memory, int64(100+21))
+	assertEqual(t, tm.Config().MaxMemory, int64(1024))
+}
+
+// mapStore is a ResultStore in memory
+type mapStore struct {
+	mu      sync.Mutex
+	data    map[string][]byte
+	ttls    map[string]time.Duration
+	failPut bool
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{data: map[string][]byte{}, ttls: map[string]time.Duration{}}
+}
+
+func (s *mapStore) Put(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failPut {
+		return errors.New("store unavailable")
+	}
+	s.data[key], s.ttls[key] = data, ttl
+	return nil
+}
+
+func (s *mapStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.data[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrResultNotFound, key)
+	}
+	return data, nil
+}
+
+func (s *mapStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s *mapStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data)
+}
+
+// Test results over the threshold are offloaded and fetched when awaited
+func TestResultOffload(t *testing.T) {
+	ctx := context.Background()
+	result := func(v any) Runnable {
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			return v, nil
+		})
+	}
+
+	store := newMapStore()
+	tm := NewManager(WithResultOffload(store, 64, time.Hour), WithMaxTaskMemory(1024), WithLogger(slog.DiscardHandler))
+	assertEqual(t, tm.Config().Offload, int64(64))
+	assertEqual(t, tm.Config().OffloadTTL, time.Hour)
+
+	// Small results stay in memory
+	small, err := tm.Await(ctx, tm.Async(ctx, result("small")))
+	assertNoError(t, err)
+	assertEqual(t, small.Offloaded, false)
+	assertEqual(t, store.len(), 0)
+
+	// Strings and bytes are stored as they are, over the memory limit too
+	body := strings.Repeat("x", 2048)
+	textID := tm.Async(ctx, result(body))
+	text, err := tm.Await(ctx, textID)
+	assertNoError(t, err)
+	assertEqual(t, text.Result, body)
+	assertEqual(t, text.Offloaded, true)
+	assertEqual(t, text.Memory, int64(2048))
+	assertEqual(t, string(store.data[textID.String()]), body)
+	assertEqual(t, store.ttls[textID.String()], time.Hour)
+
+	binary, err := tm.Await(ctx, tm.Async(ctx, result([]byte(body))))
+	assertNoError(t, err)
+	assertEqual(t, string(binary.Result.([]byte)), body)
+
+	// Other results come back as the codec decodes them
+	report, err := tm.Await(ctx, tm.Async(ctx, result(map[string]any{"rows": []string{body}})))
+	assertNoError(t, err)
+	assertEqual(t, report.Result.(map[string]any)["rows"].([]any)[0], body)
+
+	// Only a reference is kept
+	future, err := tm.Future(textID)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, nil)
+	assertEqual(t, future.Offloaded, true)
+	assertEqual(t, tm.Stats().Memory, int64(5))
+	assertEqual(t, tm.Stats().OverMemory, 0)
+
+	// Gone from the store, the result can't be awaited
+	store.Delete(ctx, textID.String())
+	_, err = tm.Await(ctx, textID)
+	assertError(t, err, ErrResultNotFound)
+
+	// A result failing to store stays in memory, subject to the limit
+	store.failPut = true
+	kept, err := tm.Await(ctx, tm.Async(ctx, result(strings.Repeat("y", 100))))
+	assertNoError(t, err)
+	assertEqual(t, kept.Offloaded, false)
+	_, err = tm.Await(ctx, tm.Async(ctx, result(body)))
+	assertError(t, err, ErrMemoryExceeded)
+	store.failPut = false
+
+	// Pruning deletes offloaded results
+	assertEqual(t, tm.Prune(0), 6)
+	deadline := time.Now().Add(time.Second)
+	for store.len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d offloaded results left after pruning", store.len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// memTaskStore is a TaskStore in memory, keeping every status saved
+type memTaskStore struct {
+	mu       sync.Mutex
+	tasks    map[ID]StoredTask
+	statuses map[ID][]Status
+	failSave bool
+}
+
+func newMemTaskStore() *memTaskStore {
+	return &memTaskStore{tasks: map[ID]StoredTask{}, statuses: map[ID][]Status{}}
+}
+
+func (s *memTaskStore) Save(ctx context.Context, task StoredTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failSave {
+		return errors.New("store unavailable")
+	}
+	s.tasks[task.ID] = task
+	s.statuses[task.ID] = append(s.statuses[task.ID], task.Status)
+	return nil
+}
+
+func (s *memTaskStore) Load(ctx context.Context, id ID) (StoredTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task, ok := s.tasks[id]
+	if !ok {
+		return StoredTask{}, fmt.Errorf("%w: %s", ErrTaskNotFound, id)
+	}
+	return task, nil
+}
+
+func (s *memTaskStore) List(ctx context.Context, query TaskQuery) ([]StoredTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Collect(maps.Values(s.tasks)), nil
+}
+
+// Test tasks are saved to the task store as they move through their statuses
+func TestTaskStore(t *testing.T) {
+	ctx := context.Background()
+	RegisterRunnable("test.store", func(params map[string]any) (Runnable, error) {
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			return map[string]any{"rows": params["rows"]}, nil
+		}), nil
+	})
+
+	store := newMemTaskStore()
+	tm := NewManager(WithTaskStore(store), WithLogger(slog.DiscardHandler))
+	assertEqual(t, tm.Config().Persisted, true)
+
+	// Tasks built from a spec keep it, with their labels and encoded result
+	id, err := tm.Submit(WithLabels(ctx, map[string]string{"tenant": "acme"}), Spec{Name: "test.store", Params: map[string]any{"rows": 3}})
+	assertNoError(t, err)
+	_, err = tm.Await(ctx, id)
+	assertNoError(t, err)
+
+	task, err := store.Load(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, slices.Equal(store.statuses[id], []Status{StatusPending, StatusRunning, StatusCompleted}), true)
+	assertEqual(t, task.Spec.Name, "test.store")
+	assertEqual(t, maps.Equal(task.Labels, map[string]string{"tenant": "acme", RunnableLabel: "test.store"}), true)
+	assertEqual(t, task.Attempts, 1)
+	assertEqual(t, task.Codec, CodecJSON)
+	assertEqual(t, string(task.Result), `{"rows":3}`)
+	assertEqual(t, task.Finished.Before(task.Started), false)
+
+	// Retried attempts are counted, errors kept
+	attempts := 0
+	id = tm.Async(ctx, WithRetry(RunnableFunc(func(ctx context.Context) (any, error) {
+		attempts++
+		return nil, errors.New("upstream down")
+	}), 2, 0))
+	if _, err = tm.Await(ctx, id); err == nil {
+		t.Fatal("expected the task to fail")
+	}
+
+	task, err = store.Load(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, task.Status, StatusFailed)
+	assertEqual(t, task.Attempts, 3)
+	assertEqual(t, task.Spec, (*Spec)(nil))
+	assertEqual(t, task.Error, "after 2 retries: upstream down")
+
+	// Deferred tasks are saved when canceled before they run
+	id = tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) { return nil, nil }))
+	tm.Cancel(id)
+	assertEqual(t, slices.Equal(store.statuses[id], []Status{StatusDeferred, StatusCanceled}), true)
+
+	// Failing to save doesn't fail the task
+	store.failSave = true
+	future, err := tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) { return "ok", nil })))
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "ok")
+
+	for _, status := range []Status{StatusDeferred, StatusPending, StatusRunning, StatusCompleted, StatusFailed, StatusCanceled} {
+		assertEqual(t, ParseStatus(status.String()), status)
+	}
+	assertEqual(t, ParseStatus("lost"), StatusUnknown)
+}
+
+// Test finished tasks are recorded in the history, beyond Prune and across
+// managers sharing it
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	RegisterRunnable("test.history", func(params map[string]any) (Runnable, error) {
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			return nil, errors.New("upstream down")
+		}), nil
+	})
+
+	history := NewHistory(3)
+	tm := NewManager(WithHistory(history), WithRequestID("req-1"), WithLogger(slog.DiscardHandler))
+	assertEqual(t, tm.Config().History, 3)
+
+	failed, err := tm.Submit(WithLabels(ctx, map[string]string{"tenant": "acme"}), Spec{Name: "test.history"})
+	assertNoError(t, err)
+	_, err = tm.Await(ctx, failed)
+	assertError(t, err, ErrTaskFailed)
+	completed := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return "ok", nil
+	}))
+	_, err = tm.Await(ctx, completed)
+	assertNoError(t, err)
+	assertEqual(t, len(tm.History()), 2)
+
+	// Unfinished tasks aren't recorded, canceled ones are
+	deferred := tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	}))
+	assertEqual(t, len(tm.History()), 2)
+	tm.Cancel(deferred)
+
+	assertEqual(t, tm.Prune(0), 3)
+	entries := tm.History()
+	assertEqual(t, len(entries), 3)
+	assertEqual(t, entries[0].ID, deferred)
+	assertEqual(t, entries[0].Status, StatusCanceled)
+	assertEqual(t, entries[0].Started.IsZero(), true)
+	assertEqual(t, entries[1].ID, completed)
+	assertEqual(t, entries[1].Status, StatusCompleted)
+	assertEqual(t, entries[1].Attempts, 1)
+	assertEqual(t, entries[2].ID, failed)
+	assertEqual(t, entries[2].Name, "test.history")
+	assertEqual(t, entries[2].RequestID, "req-1")
+	assertEqual(t, entries[2].Labels["tenant"], "acme")
+	assertEqual(t, entries[2].Status, StatusFailed)
+	assertEqual(t, strings.Contains(entries[2].Error, "upstream down"), true)
+	assertEqual(t, entries[2].Finished.IsZero(), false)
+
+	// The oldest task is dropped once the history is full, whichever
+	// manager recorded it
+	other := NewManager(WithHistory(history))
+	_, err = other.Await(ctx, other.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	})))
+	assertNoError(t, err)
+	entries = tm.History()
+	assertEqual(t, len(entries), 3)
+	assertEqual(t, entries[0].RequestID, "")
+	assertEqual(t, entries[2].ID, completed)
+
+	assertEqual(t, len(NewManager().History()), 0)
+}
+
+// forwarderFunc adapts a function to Forwarder.
+type forwarderFunc func(spec Spec, labels map[string]string) Runnable
+
+func (f forwarderFunc) Route(spec Spec, labels map[string]string) Runnable {
+	return f(spec, labels)
+}
+
+// Test tasks built from a spec run elsewhere while the pool is full
+func TestForwarding(t *testing.T) {
+	ctx := context.Background()
+	RegisterRunnable("test.forward", func(params map[string]any) (Runnable, error) {
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			return "local", nil
+		}), nil
+	})
+
+	var routed atomic.Int32
+	tm := NewManager(WithWorkerLimit(1), WithForwarding(forwarderFunc(func(spec Spec, labels map[string]string) Runnable {
+		if labels["local"] != "" {
+			return nil
+		}
+		routed.Add(1)
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			return "remote:" + spec.Name, nil
+		})
+	})))
+	assertEqual(t, tm.Config().Forwarding, true)
+
+	// With a free slot, tasks run here
+	id, err := tm.Submit(ctx, Spec{Name: "test.forward"})
+	assertNoError(t, err)
+	future, err := tm.Await(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "local")
+	assertEqual(t, routed.Load(), int32(0))
+
+	release := make(chan struct{})
+	blocker := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}))
+
+	// With the pool full, they're forwarded and awaited as usual
+	id, err = tm.Submit(ctx, Spec{Name: "test.forward"})
+	assertNoError(t, err)
+	future, err = tm.Await(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "remote:test.forward")
+	assertEqual(t, tm.Stats().Forwarded, 1)
+
+	// Tasks the forwarder declines wait for the slot
+	declined := make(chan ID)
+	go func() {
+		id, _ := tm.Submit(WithLabels(ctx, map[string]string{"local": "1"}), Spec{Name: "test.forward"})
+		declined <- id
+	}()
+	for tm.Stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	future, err = tm.Await(ctx, <-declined)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "local")
+	_, err = tm.Await(ctx, blocker)
+	assertNoError(t, err)
+	assertEqual(t, tm.Stats().Forwarded, 1)
+}
+
+type (
+	userKey   struct{}
+	secretKey struct{}
+)
+
+// Test that task contexts only carry the allow-listed values
+func TestContextPropagation(t *testing.T) {
+	tm := NewManager(WithContextPropagation(userKey{}))
+	assertEqual(t, tm.Config().Propagated, 1)
+
+	ctx := context.WithValue(context.Background(), userKey{}, "u1")
+	ctx = context.WithValue(ctx, secretKey{}, "s3cret")
+	ctx = WithLabels(ctx, map[string]string{"name": "job"})
+
+	values := RunnableFunc(func(ctx context.Context) (any, error) {
+		return fmt.Sprintf("%v %v %v", ctx.Value(userKey{}), ctx.Value(secretKey{}), LabelsFromContext(ctx)["name"]), nil
+	})
+	future, err := tm.Await(ctx, tm.Async(ctx, values))
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "u1 <nil> job")
+
+	// Deferred tasks keep them until they're promoted
+	deferred := tm.Defer(ctx, values)
+	future, err = tm.Await(context.Background(), deferred)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "u1 <nil> job")
+
+	// Tasks are still canceled with the context they were submitted with
+	cancelCtx, cancel := context.WithCancel(ctx)
+	started := make(chan struct{})
+	id := tm.Async(cancelCtx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	<-started
+	cancel()
+	_, err = tm.Await(context.Background(), id)
+	if err == nil {
+		t.Fatal("expected the task to be canceled")
+	}
+
+	// Without the option, tasks carry every value
+	plain := NewManager()
+	future, err = plain.Await(ctx, plain.Async(ctx, values))
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "u1 s3cret job")
+}
+
+// Test that tasks with too little time left before their deadline fail
+// without running
+func TestInsufficientDeadline(t *testing.T) {
+	// Context deadlines are wall time, so the clock starts there
+	start := time.Now()
+	clock := NewFakeClock(start)
+	tm := NewManager(WithClock(clock), WithMinTaskDuration(2*time.Hour))
+	assertEqual(t, tm.Config().MinDuration, 2*time.Hour)
+
+	var ran atomic.Int32
+	runnable := RunnableFunc(func(ctx context.Context) (any, error) {
+		ran.Add(1)
+		return "ok", nil
+	})
+
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(time.Hour))
+	defer cancel()
+	_, err := tm.Await(context.Background(), tm.Async(ctx, runnable))
+	assertError(t, err, ErrInsufficientDeadline)
+
+	// A task declaring a shorter minimum, or none, fits
+	future, err := tm.Await(context.Background(), tm.Async(WithMinDuration(ctx, 30*time.Minute), runnable))
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "ok")
+	_, err = tm.Await(context.Background(), tm.Async(WithMinDuration(ctx, 0), runnable))
+	assertNoError(t, err)
+
+	// Deferred tasks are checked as they're promoted, against the deadline
+	// of the await
+	deferred := tm.Defer(WithMinDuration(context.Background(), 30*time.Minute), runnable)
+	clock.Advance(45 * time.Minute)
+	_, err = tm.Await(ctx, deferred)
+	assertError(t, err, ErrInsufficientDeadline)
+
+	// Contexts without a deadline always fit
+	_, err = tm.Await(context.Background(), tm.Async(context.Background(), runnable))
+	assertNoError(t, err)
+
+	assertEqual(t, ran.Load(), int32(3))
+	assertEqual(t, tm.Stats().Refused, 2)
+
+	// The timeout of the default policy is the default minimum
+	timed := NewManager(WithClock(clock), WithDefaultPolicy(Policy().Timeout(2*time.Hour)))
+	_, err = timed.Await(context.Background(), timed.Async(ctx, runnable))
+	assertError(t, err, ErrInsufficientDeadline)
+}
+
+// Test that tasks without heartbeats are reported as stalled, and started
+// over with restarts
+func TestStallDetection(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	var mu sync.Mutex
+	var stalls []ID
+	tm := NewManager(WithClock(clock), WithStallDetection(time.Second, 0), WithEventHandler(func(ev Event) {
+		if ev.Type == EventStalled {
+			mu.Lock()
+			stalls = append(stalls, ev.ID)
+			mu.Unlock()
+		}
+	}))
+	assertEqual(t, tm.Config().StallTimeout, time.Second)
+	ctx := context.Background()
+
+	awaitTimers := func(n int) {
+		for clock.Timers() < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	// Let the watch check once more, a quarter of the timeout later
+	tick := func(n int) {
+		for range n {
+			awaitTimers(1)
+			clock.Advance(250 * time.Millisecond)
+			awaitTimers(1)
+		}
+	}
+
+	started := make(chan struct{})
+	beats := make(chan chan struct{})
+	release := make(chan struct{})
+	id := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		for {
+			select {
+			case ack := <-beats:
+				Heartbeat(ctx)
+				close(ack)
+			case <-release:
+				return "done", nil
+			}
+		}
+	}))
+	beat := func() {
+		ack := make(chan struct{})
+		beats <- ack
+		<-ack
+	}
+	stalled := func() bool {
+		future, err := tm.Future(id)
+		assertNoError(t, err)
+		return future.Stalled
+	}
+	<-started
+
+	tick(3)
+	beat()
+	tick(3)
+	assertEqual(t, stalled(), false)
+	tick(1)
+	assertEqual(t, stalled(), true)
+	tick(2)
+	assertEqual(t, tm.Stats().Stalled, 1)
+
+	// A heartbeat clears it
+	beat()
+	assertEqual(t, stalled(), false)
+	close(release)
+	future, err := tm.Await(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "done")
+	mu.Lock()
+	assertEqual(t, len(stalls), 1)
+	assertEqual(t, stalls[0], id)
+	mu.Unlock()
+	_, err = tm.Shutdown(ctx)
+	assertNoError(t, err)
+
+	// With restarts, stalled runs are canceled and started over, until
+	// the restarts are used up
+	restarting := NewManager(WithClock(clock), WithStallDetection(time.Second, 1))
+	var runs atomic.Int32
+	wedged := make(chan struct{})
+	defer close(wedged)
+	runnable := RunnableFunc(func(ctx context.Context) (any, error) {
+		if runs.Add(1) == 2 {
+			return "ok", nil
+		}
+		<-wedged // ignores cancellation
+		return nil, nil
+	})
+	awaitRuns := func(n int32) {
+		for runs.Load() < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	id = restarting.Async(ctx, runnable)
+	awaitRuns(1)
+	tick(4)
+	future, err = restarting.Await(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "ok")
+
+	id = restarting.Async(ctx, runnable)
+	awaitRuns(3)
+	tick(4)
+	awaitRuns(4)
+	tick(4)
+	_, err = restarting.Await(ctx, id)
+	assertError(t, err, ErrTaskStalled)
+	assertEqual(t, restarting.Stats().Stalled, 3)
+}
+
+// Test that unbounded tasks run while the pool is full, and the tasks they
+// start take a slot again
+func TestUnbounded(t *testing.T) {
+	ctx := context.Background()
+	for _, opts := range [][]Option{
+		{WithWorkerLimit(1)},
+		{WithWorkerLimit(1), WithExecutor(PoolExecutor{Workers: 1})},
+	} {
+		tm := NewManager(opts...)
+		release := make(chan struct{})
+		started := make(chan struct{})
+		heavy := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			close(started)
+			<-release
+			return "heavy", nil
+		}))
+		<-started
+
+		future, err := tm.Await(ctx, tm.Async(WithUnbounded(ctx), RunnableFunc(func(ctx context.Context) (any, error) {
+			return unbounded(ctx), nil
+		})))
+		assertNoError(t, err)
+		assertEqual(t, future.Result, false)
+		assertEqual(t, tm.Stats().Workers, 1)
+
+		close(release)
+		future, err = tm.Await(ctx, heavy)
+		assertNoError(t, err)
+		assertEqual(t, future.Result, "heavy")
+		assertEqual(t, tm.Stats().Acquired, 1) // the heavy task only
+		_, err = tm.Shutdown(ctx)
+		assertNoError(t, err)
+	}
+}
+
+// Test that awaits with a timeout leave unfinished tasks running, telling
+// how far they got
+func TestAwaitTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	tm := NewManager(WithClock(clock))
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-release
+		return "slow", nil
+	}))
+	fast := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return "fast", nil
+	}))
+	<-started
+
+	// Runs await while the clock moves past its timeout
+	timeout := func(await func() error) error {
+		done := make(chan error, 1)
+		go func() { done <- await() }()
+		for clock.Timers() < 1 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Second)
+		return <-done
+	}
+
+	var future Future
+	err := timeout(func() (err error) {
+		future, err = tm.AwaitTimeout(ctx, slow, time.Second)
+		return err
+	})
+	assertError(t, err, ErrTaskTimeout)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a TimeoutError, got %v", err)
+	}
+	assertEqual(t, len(timeoutErr.Pending), 1)
+	assertEqual(t, timeoutErr.Pending[0].Status, StatusRunning)
+	assertEqual(t, timeoutErr.Pending[0].Elapsed, time.Second)
+	assertEqual(t, future.Status, StatusRunning.String())
+
+	var futures []Future
+	err = timeout(func() (err error) {
+		futures, err = tm.AwaitAllTimeout(ctx, []ID{fast, slow}, time.Second)
+		return err
+	})
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("expected a TimeoutError, got %v", err)
+	}
+	assertEqual(t, len(timeoutErr.Pending), 1)
+	assertEqual(t, timeoutErr.Pending[0].ID, slow)
+	assertEqual(t, futures[0].Result, "fast")
+	assertEqual(t, futures[1].Status, StatusRunning.String())
+
+	// The task kept running, so it can still be awaited
+	close(release)
+	futures, err = tm.AwaitAllTimeout(ctx, []ID{fast, slow}, time.Second)
+	assertNoError(t, err)
+	assertEqual(t, futures[1].Result, "slow")
+}
+
+// Test Stats reports moving task rates, failure rate and duration percentiles
+func TestStatsThroughput(t *testing.T) {
+	clock := NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	tm := NewManager(WithClock(clock))
+	ctx := context.Background()
+
+	near := func(name string, got, want float64) {
+		t.Helper()
+		if got < want*0.8 || got > want*1.2 {
+			t.Errorf("%s = %v, want about %v", name, got, want)
+		}
+	}
+	run := func(d time.Duration, err error) {
+		taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			clock.Advance(d)
+			return nil, err
+		}))
+		tm.Await(ctx, taskID)
+	}
+
+	for range 90 {
+		run(10*time.Millisecond, nil)
+	}
+	for range 9 {
+		run(100*time.Millisecond, nil)
+	}
+	run(time.Second, errors.New("boom"))
+
+	stats := tm.Stats()
+	near("p50", float64(stats.P50), float64(10*time.Millisecond))
+	near("p95", float64(stats.P95), float64(100*time.Millisecond))
+	near("p99", float64(stats.P99), float64(100*time.Millisecond))
+
+	// Rates take in the tasks once their interval is over
+	assertEqual(t, stats.Rate1m, 0.0)
+	clock.Advance(rateInterval)
+	stats = tm.Stats()
+	near("rate 1m", stats.Rate1m, 99/rateInterval.Seconds())
+	near("rate 5m", stats.Rate5m, 99/rateInterval.Seconds())
+	near("failure rate", stats.FailureRate, 0.01)
+
+	// Without tasks, the averages decay and the durations age out
+	clock.Advance(time.Minute)
+	stats = tm.Stats()
+	near("rate 1m", stats.Rate1m, 99/rateInterval.Seconds()/math.E)
+	near("rate 5m", stats.Rate5m, 99/rateInterval.Seconds()/math.Exp(0.2))
+	near("p50", float64(stats.P50), float64(10*time.Millisecond))
+
+	clock.Advance(time.Minute)
+	stats = tm.Stats()
+	assertEqual(t, stats.P50, time.Duration(0))
+	assertEqual(t, stats.P99, time.Duration(0))
+}
+
+// Test Each summarizes every task, stops when told to and lets yield call
+// the manager
+func TestEach(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	done := tm.Async(WithLabels(ctx, map[string]string{"kind": "done"}), RunnableFunc(func(ctx context.Context) (any, error) {
+		return "ok", nil
+	}))
+	_, err := tm.Await(ctx, done)
+	assertNoError(t, err)
+
+	started := make(chan struct{})
+	blocked := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	<-started
+	deferred := tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	}))
+
+	summaries := make(map[ID]TaskSummary)
+	for task := range tm.Each {
+		summaries[task.ID] = task
+	}
+	assertEqual(t, len(summaries), 3)
+	assertEqual(t, summaries[done].Status, StatusCompleted)
+	assertEqual(t, summaries[done].Labels["kind"], "done")
+	assertEqual(t, summaries[done].Attempts, 1)
+	assertEqual(t, summaries[done].Finished.IsZero(), false)
+	assertEqual(t, summaries[blocked].Status, StatusRunning)
+	assertEqual(t, summaries[blocked].Finished.IsZero(), true)
+	assertEqual(t, summaries[deferred].Status, StatusDeferred)
+	assertEqual(t, summaries[deferred].Started.IsZero(), true)
+
+	// Labels are copies
+	summaries[done].Labels["kind"] = "changed"
+	future, _ := tm.Future(done)
+	assertEqual(t, future.Labels["kind"], "done")
+
+	// Stopping early
+	seen := 0
+	for range tm.Each {
+		seen++
+		break
+	}
+	assertEqual(t, seen, 1)
+
+	// yield may cancel the tasks it's given
+	var canceled []CancelResult
+	for task := range tm.Each {
+		if task.Status == StatusRunning || task.Status == StatusDeferred {
+			result, err := tm.Cancel(task.ID)
+			assertNoError(t, err)
+			canceled = append(canceled, result)
+		}
+	}
+	slices.Sort(canceled)
+	assertEqual(t, len(canceled), 2)
+	assertEqual(t, canceled[0], CancelBeforeStart)
+	assertEqual(t, canceled[1], CancelWhileRunning)
+}
+
+// Test canceling deferred tasks before and after they're awaited
+func TestCancelDeferred(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	var ran atomic.Int32
+	deferred := tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		ran.Add(1)
+		return nil, nil
+	}))
+	result, err := tm.Cancel(deferred)
+	assertNoError(t, err)
+	assertEqual(t, result, CancelBeforeStart)
+	result, _ = tm.Cancel(deferred)
+	assertEqual(t, result, CancelAlreadyFinished)
+
+	// Canceled before it was awaited, it never runs
+	_, err = tm.Await(ctx, deferred)
+	assertError(t, err, ErrTaskNotFound)
+	assertEqual(t, ran.Load(), int32(0))
+	status, _ := tm.Status(deferred)
+	assertEqual(t, status, StatusCanceled)
+	future, _ := tm.Future(deferred)
+	assertError(t, future.Error, ErrTaskCanceled)
+
+	// Once awaited, the task it was promoted to is canceled
+	started := make(chan struct{})
+	deferred = tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	awaited := make(chan error, 1)
+	go func() {
+		_, err := tm.WaitFor(ctx, deferred)
+		awaited <- err
+	}()
+	<-started
+	result, err = tm.Cancel(deferred)
+	assertNoError(t, err)
+	assertEqual(t, result, CancelWhileRunning)
+	if err := <-awaited; err == nil {
+		t.Fatal("expected the await of the canceled task to fail")
+	}
+	status, _ = tm.Status(deferred)
+	assertEqual(t, status, StatusCanceled)
+}
+
+// Test deferred tasks run with the context of the await promoting them,
+// keeping the values of the one they were deferred with
+func TestDeferredPromotion(t *testing.T) {
+	tm := NewManager(WithWorkerLimit(1))
+
+	// The request deferring the task is gone by the time it's awaited
+	deferCtx, cancelDefer := context.WithCancel(WithLabels(context.Background(), map[string]string{"name": "job"}))
+	deferred := tm.Defer(deferCtx, RunnableFunc(func(ctx context.Context) (any, error) {
+		_, hasDeadline := ctx.Deadline()
+		return fmt.Sprintf("%v %v %v", LabelsFromContext(ctx)["name"], ctx.Err(), hasDeadline), nil
+	}))
+	cancelDefer()
+
+	awaitCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	future, err := tm.Await(awaitCtx, deferred)
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "job <nil> true")
+	future, _ = tm.Future(future.ID)
+	assertEqual(t, future.Labels["name"], "job")
+
+	// Awaits wait for the task rather than its worker slot, so they give
+	// up on a full pool as on a running task
+	release := make(chan struct{})
+	busy := tm.Async(context.Background(), RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}))
+	var ran atomic.Int32
+	deferred = tm.Defer(context.Background(), RunnableFunc(func(ctx context.Context) (any, error) {
+		ran.Add(1)
+		return nil, nil
+	}))
+	timeoutCtx, cancelTimeout := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelTimeout()
+	_, err = tm.Await(timeoutCtx, deferred)
+	assertError(t, err, ErrTaskTimeout)
+
+	close(release)
+	_, err = tm.Await(context.Background(), busy)
+	assertNoError(t, err)
+	assertEqual(t, tm.Wait(context.Background(), nil), nil)
+	assertEqual(t, ran.Load(), int32(0))
+}
+
+// Test tasks deferred together start together
+func TestDeferAll(t *testing.T) {
+	tm := NewManager()
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	var ran atomic.Int32
+	value := func(v string) Runnable {
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			ran.Add(1)
+			select {
+			case <-release:
+				return v, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+	}
+
+	ids := tm.DeferAll(ctx, value("a"), value("b"), value("c"))
+	assertEqual(t, len(ids), 3)
+	canceled := tm.DeferAll(ctx, value("x"), value("y"))
+	tm.Cancel(canceled[1])
+	for _, id := range ids {
+		status, _ := tm.Status(id)
+		assertEqual(t, status, StatusDeferred)
+	}
+
+	// Awaiting one promotes the group; the others keep running when the
+	// await gives up
+	awaitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err := tm.Await(awaitCtx, ids[1])
+	assertError(t, err, ErrTaskTimeout)
+	for _, id := range []ID{ids[0], ids[2]} {
+		status, _ := tm.Status(id)
+		if status != StatusPending && status != StatusRunning {
+			t.Fatalf("expected the group to be promoted, got %v", status)
+		}
+	}
+
+	close(release)
+	for i, v := range []string{"a", "c"} {
+		future, err := tm.Await(ctx, ids[i*2])
+		assertNoError(t, err)
+		assertEqual(t, future.Result, v)
+	}
+
+	// Promoting without awaiting, canceled members stay canceled
+	assertNoError(t, tm.PromoteAll([]ID{canceled[0]}))
+	future, err := tm.Await(ctx, canceled[0])
+	assertNoError(t, err)
+	assertEqual(t, future.Result, "x")
+	assertEqual(t, ran.Load(), int32(4))
+
+	err = tm.PromoteAll([]ID{canceled[1]})
+	assertError(t, err, ErrTaskNotFound)
+	assertEqual(t, ran.Load(), int32(4))
+}
+
+// Test sealing and opening with rotated keys
+func TestKeyring(t *testing.T) {
+	old, err := ParseKeyring([]string{"2024:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16))})
+	assertNoError(t, err)
+	sealedOld, err := old.Seal([]byte("Authorization: Bearer abc"))
+	assertNoError(t, err)
+	assertEqual(t, Sealed(sealedOld), true)
+	assertEqual(t, bytes.Contains(sealedOld, []byte("Bearer")), false)
+
+	// The new key seals, the old one still opens
+	rotated, err := ParseKeyring([]string{
+		"2025:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)),
+		"2024:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 16)),
+	})
+	assertNoError(t, err)
+	plain, err := rotated.Open(sealedOld)
+	assertNoError(t, err)
+	assertEqual(t, string(plain), "Authorization: Bearer abc")
+	sealedNew, err := rotated.Seal([]byte("pii"))
+	assertNoError(t, err)
+	plain, err = rotated.Open(sealedNew)
+	assertNoError(t, err)
+	assertEqual(t, string(plain), "pii")
+
+	// Unknown keys and tampering are detected, unsealed data passes
+	_, err = old.Open(sealedNew)
+	assertError(t, err, ErrUnsealable)
+	sealedNew[len(sealedNew)-1] ^= 1
+	_, err = rotated.Open(sealedNew)
+	assertError(t, err, ErrUnsealable)
+	_, err = rotated.Open(sealedNew[:6])
+	assertError(t, err, ErrUnsealable)
+	plain, err = rotated.Open([]byte(`{"rows":3}`))
+	assertNoError(t, err)
+	assertEqual(t, string(plain), `{"rows":3}`)
+
+	for _, specs := range [][]string{
+		nil,
+		{"nokey"},
+		{"k1:not base64"},
+		{"k1:" + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{":" + base64.StdEncoding.EncodeToString(make([]byte, 16))},
+		{"k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32))},
+	} {
+		_, err := ParseKeyring(specs)
+		assertError(t, err, ErrInvalidKey)
+	}
+}
+
+// Test invalid options are rejected and the effective configuration
+func TestNewManagerE(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"worker limit", WithWorkerLimit(0)},
+		{"pool limit", WithPool("io", 0)},
+		{"pool name", WithPool("", 4)},
+		{"autoscale bounds", WithAutoscale(4, 2, nil)},
+		{"fair label", WithFairScheduling("", nil)},
+		{"fair share", WithFairScheduling("request", map[string]int{"a": 0})},
+		{"log capacity", WithLogCapacity(-1)},
+		{"max tasks", WithMaxTasksPerRequest(-1)},
+		{"budget", WithRequestBudget(-time.Second)},
+		{"await budget", WithAwaitBudget(-time.Second)},
+		{"max task memory", WithMaxTaskMemory(-1)},
+		{"id generator", WithIDGenerator(nil)},
+		{"clock", WithClock(nil)},
+		{"slow task threshold", WithSlowTaskThreshold(-time.Second)},
+		{"codec", WithCodec(nil)},
+		{"executor", WithExecutor(nil)},
+		{"executor workers", WithExecutor(PoolExecutor{Workers: -1})},
+		{"namespace sharding", WithNamespaceSharding("")},
+		{"result store", WithResultOffload(nil, 0, 0)},
+		{"result offload threshold", WithResultOffload(newMapStore(), -1, 0)},
+		{"result offload ttl", WithResultOffload(newMapStore(), 0, -time.Second)},
+		{"task store", WithTaskStore(nil)},
+		{"history", WithHistory(nil)},
+		{"forwarder", WithForwarding(nil)},
+		{"context key", WithContextPropagation([]string{"user"})},
+		{"minimum task duration", WithMinTaskDuration(-time.Second)},
+		{"stall timeout", WithStallDetection(0, 0)},
+		{"stall restarts", WithStallDetection(time.Second, -1)},
+		{"shutdown policy", WithShutdownPolicy(ShutdownPolicy(7), 0)},
+		{"shutdown timeout", WithShutdownPolicy(ShutdownWait, -time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm, err := NewManagerE(tt.opt)
+			assertError(t, err, ErrInvalidOption)
+			if tm != nil {
+				t.Fatal("expected no manager")
+			}
+		})
+	}
+
+	// Conflicting pools
+	_, err := NewManagerE(WithPool("io", 4), WithPool("io", 8))
+	assertError(t, err, ErrInvalidOption)
+	_, err = NewManagerE(WithInlineExecution(), WithExecutor(PoolExecutor{}))
+	assertError(t, err, ErrInvalidOption)
+
+	tm, err := NewManagerE(
+		WithWorkerLimit(8),
+		WithPool("io", 4),
+		WithPool("io", 4),
+		WithMaxTasksPerRequest(100),
+		WithRequestID("req-1"),
+	)
+	assertNoError(t, err)
+	defer tm.Shutdown(context.Background())
+
+	cfg := tm.Config()
+	assertEqual(t, cfg.WorkerLimit, 8)
+	assertEqual(t, cfg.Pools["io"], 4)
+	assertEqual(t, cfg.MaxTasks, 100)
+	assertEqual(t, cfg.LogCapacity, DefaultLogCapacity)
+	assertEqual(t, cfg.RequestID, "req-1")
+	assertEqual(t, cfg.AutoscaleMax, 0)
+
+	// NewManager falls back to the defaults
+	tm = NewManager(WithWorkerLimit(0), WithAutoscale(0, 0, nil))
+	defer tm.Shutdown(context.Background())
+	cfg = tm.Config()
+	assertEqual(t, cfg.WorkerLimit, 1)
+	assertEqual(t, cfg.AutoscaleMin, 1)
+	assertEqual(t, cfg.AutoscaleMax, 1)
+}
+
+// Test ID generators and parsing
+func TestIDs(t *testing.T) {
+	ctx := context.Background()
+
+	for name, gen := range map[string]IDGenerator{
+		"xid":    NewXID,
+		"ulid":   NewULID,
+		"uuidv7": NewUUIDv7,
+		"seq":    SequenceIDs(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			tm := NewManager(WithIDGenerator(gen))
+			defer tm.Shutdown(ctx)
+
+			id := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+				return "ok", nil
+			}))
+			parsed, err := ParseID(id.String())
+			assertNoError(t, err)
+			assertEqual(t, parsed, id)
+
+			result, err := tm.Await(ctx, parsed)
+			assertNoError(t, err)
+			assertEqual(t, result.Result, "ok")
+		})
+	}
+
+	// Deterministic sequences
+	seq := SequenceIDs()
+	assertEqual(t, seq(), "0000000000000000000g")
+	assertEqual(t, seq(), "00000000000000000010")
+
+	// Parsing normalizes case
+	ulid := NewULID()
+	id, err := ParseID(strings.ToLower(ulid))
+	assertNoError(t, err)
+	assertEqual(t, id.String(), ulid)
+
+	for _, s := range []string{"", "invalid", "not-an-id", "ZZZZZZZZZZZZZZZZZZZZZZZZZZ", strings.Repeat("g", 36)} {
+		_, err := ParseID(s)
+		assertError(t, err, ErrInvalidID)
+	}
+
+	// JSON round trip
+	type task struct{ ID ID }
+	data, err := json.Marshal(task{id})
+	assertNoError(t, err)
+	assertEqual(t, string(data), `{"ID":"`+ulid+`"}`)
+	var decoded task
+	assertNoError(t, json.Unmarshal(data, &decoded))
+	assertEqual(t, decoded.ID, id)
+
+	assertNoError(t, json.Unmarshal([]byte(`{"ID":""}`), &decoded))
+	assertEqual(t, decoded.ID.IsZero(), true)
+	assertError(t, json.Unmarshal([]byte(`{"ID":"nope"}`), &decoded), ErrInvalidID)
+}
+
+// Test a fake clock drives task times, timeouts, backoff and prune TTLs
+func TestClock(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	tm := NewManager(WithClock(clock))
+	ctx := context.Background()
+
+	awaitTimers := func(n int) {
+		for clock.Timers() < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	timedOut := tm.Async(ctx, WithTimeout(RunnableFunc(func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}), time.Hour))
+	awaitTimers(1)
+	clock.Advance(time.Hour)
+
+	result, err := tm.Await(ctx, timedOut)
+	assertError(t, err, ErrTaskTimeout)
+	assertEqual(t, result.Submitted.Equal(start), true)
+	assertEqual(t, result.Duration, time.Hour)
+
+	var attempts atomic.Int32
+	retried := tm.Async(ctx, WithRetry(RunnableFunc(func(ctx context.Context) (any, error) {
+		attempts.Add(1)
+		return nil, errors.New("flaky")
+	}), 2, time.Minute))
+	awaitTimers(1)
+	clock.Advance(time.Minute)
+	awaitTimers(1)
+	clock.Advance(2 * time.Minute)
+
+	_, err = tm.Await(ctx, retried)
+	assertError(t, err, ErrTaskFailed)
+	assertEqual(t, attempts.Load(), int32(3))
+
+	assertEqual(t, tm.Prune(time.Hour), 0)
+	clock.Advance(59 * time.Minute)
+	assertEqual(t, tm.Prune(time.Hour), 1)
+}
+
+// Test profiling labels task goroutines and measures their CPU time
+func TestProfiling(t *testing.T) {
+	ctx := WithLabels(context.Background(), map[string]string{"script": "report.php"})
+	busy := RunnableFunc(func(ctx context.Context) (any, error) {
+		script, _ := pprof.Label(ctx, "script")
+		id, _ := pprof.Label(ctx, ProfileLabel)
+		for start := time.Now(); time.Since(start) < 20*time.Millisecond; {
+		}
+		return script + " " + id, nil
+	})
+
+	tm := NewManager(WithProfiling())
+	id := tm.Async(ctx, busy)
+	result, err := tm.Await(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, result.Result, "report.php "+id.String())
+	if runtime.GOOS == "linux" && (result.CPU <= 0 || result.CPU > result.Duration+10*time.Millisecond) {
+		t.Fatalf("expected CPU time up to the task's duration of %v, got %v", result.Duration, result.CPU)
+	}
+	assertEqual(t, tm.Stats().CPUTotal, result.CPU)
+	assertEqual(t, tm.Config().Profiling, true)
+
+	// Without profiling, only the task ID is a label
+	tm = NewManager()
+	id = tm.Async(ctx, busy)
+	result, err = tm.Await(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, result.Result, " "+id.String())
+	assertEqual(t, result.CPU, time.Duration(0))
+}
+
+// Test prewarmed managers run their tasks on long-lived goroutines
+func TestPrewarm(t *testing.T) {
+	ctx := context.Background()
+	tm := NewManager(WithPrewarm(4))
+	assertEqual(t, tm.Config().Prewarm, 4)
+
+	// Idle goroutines take the tasks, records come from the spare ones
+	ids := make([]ID, 8)
+	for i := range ids {
+		ids[i] = tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			return i, nil
+		}))
+	}
+	results, err := tm.AwaitAll(ctx, ids)
+	assertNoError(t, err)
+	for i, result := range results {
+		assertEqual(t, result.Result, i)
+	}
+	assertEqual(t, tm.tasks.len(), 8)
+	rec, _ := tm.tasks.load(ids[0])
+	assertEqual(t, rec, &tm.spare[0])
+	rec, _ = tm.tasks.load(ids[3])
+	assertEqual(t, rec, &tm.spare[3])
+
+	ran := make(chan struct{})
+	assertEqual(t, tm.warm.submit(func() { close(ran) }), true)
+	<-ran
+
+	// Shutdown lets them go
+	_, err = tm.Shutdown(ctx)
+	assertNoError(t, err)
+	deadline := time.Now().Add(time.Second)
+	for tm.warm.submit(func() {}) {
+		if time.Now().After(deadline) {
+			t.Fatal("prewarmed goroutines still running after shutdown")
+		}
+	}
+}
+
+// Test the pool executor queues tasks for its workers instead of blocking
+func TestPoolExecutor(t *testing.T) {
+	ctx := context.Background()
+	tm := NewManager(WithWorkerLimit(2), WithExecutor(PoolExecutor{}))
+	assertEqual(t, tm.Config().Executor, "pool")
+	assertEqual(t, tm.Config().Workers, 2)
+
+	// Submitting past the worker limit returns right away
+	release := make(chan struct{})
+	var running, peak atomic.Int32
+	ids := make([]ID, 100)
+	for i := range ids {
+		ids[i] = tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			<-release
+			running.Add(-1)
+			return i, nil
+		}))
+	}
+	for tm.Stats().Workers < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	assertEqual(t, tm.Stats().Queued, 98)
+
+	// Canceled while queued, it doesn't take a worker slot
+	_, err := tm.Cancel(ids[99])
+	assertNoError(t, err)
+
+	close(release)
+	results, errs, err := tm.AwaitAllSettled(ctx, ids)
+	assertNoError(t, err)
+	for i, result := range results[:99] {
+		assertNoError(t, errs[i])
+		assertEqual(t, result.Result, i)
+	}
+	assertError(t, errs[99], ErrTaskNotFound)
+	assertEqual(t, peak.Load(), int32(2))
+	assertEqual(t, tm.Stats().Queued, 0)
+	assertEqual(t, tm.Stats().Acquired, 99)
+
+	// Shutdown stops the workers, later tasks are canceled
+	before := runtime.NumGoroutine()
+	_, err = tm.Shutdown(ctx)
+	assertNoError(t, err)
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before-2 {
+		if time.Now().After(deadline) {
+			t.Fatal("pool executor workers still running after shutdown")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assertEqual(t, tm.queue.push("", func() {}), false)
+}
+
+// Test tasks sharded by namespace are found from their namespace and others
+func TestNamespaceSharding(t *testing.T) {
+	tm := NewManager(WithNamespaceSharding("tenant"))
+	assertEqual(t, tm.Config().ShardLabel, "tenant")
+
+	ctxA := WithLabels(context.Background(), map[string]string{"tenant": "a"})
+	ctxB := WithLabels(context.Background(), map[string]string{"tenant": "b"})
+	value := func(v any) Runnable {
+		return RunnableFunc(func(ctx context.Context) (any, error) { return v, nil })
+	}
+
+	idA := tm.Async(ctxA, value("a"))
+	idB := tm.Defer(ctxB, value("b"))
+	idNone := tm.Async(context.Background(), value("none"))
+
+	// Kept in the shards of their namespace
+	recA, _ := tm.tasks.load(idA)
+	assertEqual(t, recA.namespace, "a")
+	assertEqual(t, tm.tasks.shard(namespaceGroup("a"), idA).records[idA], recA)
+
+	// Awaited from any namespace, deferred tasks promoted within theirs
+	result, err := tm.Await(ctxB, idA)
+	assertNoError(t, err)
+	assertEqual(t, result.Result, "a")
+	results, err := tm.AwaitAll(ctxA, []ID{idB, idNone})
+	assertNoError(t, err)
+	assertEqual(t, results[0].Result, "b")
+	assertEqual(t, results[1].Result, "none")
+	recB, _ := tm.tasks.load(idB)
+	promoted, ok := tm.tasks.loadIn("b", recB.promotedID())
+	assertEqual(t, ok, true)
+	assertEqual(t, promoted.namespace, "b")
+
+	// Lookups by ID alone look everywhere
+	status, err := tm.Status(idA)
+	assertNoError(t, err)
+	assertEqual(t, status, StatusCompleted)
+	_, err = tm.Status(ID{s: "unknown"})
+	assertError(t, err, ErrTaskNotFound)
+
+	// Pruned from their shards, deferred tasks kept
+	assertEqual(t, tm.Prune(0), 3)
+	assertEqual(t, tm.tasks.len(), 1)
+}
+
+// Test a pool executor sharded by namespace takes turns between them
+func TestPoolExecutorNamespaces(t *testing.T) {
+	ctx := context.Background()
+	tm := NewManager(WithWorkerLimit(1), WithExecutor(PoolExecutor{}), WithNamespaceSharding("tenant"))
+	defer tm.Shutdown(ctx)
+
+	// "burst" and "quiet" hash to different groups
+	burst := WithLabels(ctx, map[string]string{"tenant": "burst"})
+	quiet := WithLabels(ctx, map[string]string{"tenant": "quiet"})
+
+	release := make(chan struct{})
+	var order []string
+	var mu sync.Mutex
+	record := func(name string) Runnable {
+		return RunnableFunc(func(ctx context.Context) (any, error) {
+			<-release
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil, nil
+		})
+	}
+
+	ids := make([]ID, 0, 11)
+	for range 10 {
+		ids = append(ids, tm.Async(burst, record("burst")))
+	}
+	ids = append(ids, tm.Async(quiet, record("quiet")))
+	for tm.Stats().Workers < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assertEqual(t, tm.Stats().Queued, 10)
+
+	close(release)
+	_, err := tm.AwaitAll(ctx, ids)
+	assertNoError(t, err)
+
+	// The quiet tenant's task doesn't wait for the burst to drain
+	assertEqual(t, slices.Index(order, "quiet") < 3, true)
+}
+
+// Test the trace export shows which worker ran each task and for how long
+func TestExportTrace(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	tm := NewManager(WithClock(clock), WithWorkerLimit(2), WithPool("io", 1))
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	blocking := RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	})
+	first, second := tm.Async(ctx, blocking), tm.Async(ctx, blocking)
+	for _, id := range []ID{first, second} {
+		for status, _ := tm.Status(id); status != StatusRunning; status, _ = tm.Status(id) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	clock.Advance(10 * time.Millisecond)
+	close(release)
+	_, err := tm.AwaitAll(ctx, []ID{first, second})
+	assertNoError(t, err)
+
+	third, err := tm.Await(ctx, tm.Async(WithLabels(ctx, map[string]string{PoolLabel: "io"}), blocking))
+	assertNoError(t, err)
+	tm.Defer(ctx, blocking)
+
+	var out bytes.Buffer
+	assertNoError(t, tm.ExportTrace(&out))
+
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	assertNoError(t, json.Unmarshal(out.Bytes(), &trace))
+
+	spans := map[string]traceEvent{}
+	waits, processes := 0, 0
+	for _, ev := range trace.TraceEvents {
+		switch ev.Phase {
+		case "X":
+			spans[ev.Name] = ev
+		case "b":
+			waits++
+		case "M":
+			if ev.Name == "process_name" {
+				processes++
+			}
+		}
+	}
+	assertEqual(t, len(spans), 3)
+	assertEqual(t, waits, 3)
+	assertEqual(t, processes, 2)
+
+	assertEqual(t, spans[first.String()].TID, 1)
+	assertEqual(t, spans[second.String()].TID, 2)
+	assertEqual(t, spans[first.String()].Duration, float64(10000))
+	assertEqual(t, spans[first.String()].Time, float64(1000*time.Second/time.Microsecond))
+	assertEqual(t, spans[third.ID.String()].PID, 2)
+	assertEqual(t, spans[third.ID.String()].TID, 1)
+	assertEqual(t, spans[third.ID.String()].Args["pool"], "io")
+}
+
+// Test tasks running longer than the slow task threshold are logged and counted
+func TestSlowTasks(t *testing.T) {
+	var out bytes.Buffer
+	clock := NewFakeClock(time.Now())
+	tm := NewManager(
+		WithClock(clock),
+		WithLogger(slog.NewJSONHandler(&out, nil)),
+		WithSlowTaskThreshold(time.Second),
+	)
+	ctx := WithLabels(context.Background(), map[string]string{"script": "report.php"})
+
+	_, err := tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	})))
+	assertNoError(t, err)
+	assertEqual(t, tm.Stats().Slow, 0)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	id := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}))
+	<-started
+	clock.Advance(2 * time.Second)
+	close(release)
+	_, err = tm.Await(ctx, id)
+	assertNoError(t, err)
+	assertEqual(t, tm.Stats().Slow, 1)
+	assertEqual(t, tm.Config().SlowTask, time.Second)
+
+	var record map[string]any
+	assertNoError(t, json.NewDecoder(&out).Decode(&record))
+	assertEqual(t, record["msg"], "Task Slow")
+	assertEqual(t, record["level"], "WARN")
+	assertEqual(t, record["id"], id.String())
+	assertEqual(t, record["script"], "report.php")
+	assertEqual(t, record["duration"], float64(2*time.Second))
+}
+
+// Test futures round trip through JSON with all their fields
+func TestFutureJSON(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	tm := NewManager(WithClock(clock), WithInlineExecution())
+	ctx := WithLabels(context.Background(), map[string]string{"job": "report"})
 
-	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
-		panic("test panic")
+	id := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		clock.Advance(2 * time.Second)
+		return map[string]any{"rows": 3}, nil
 	}))
+	future, err := tm.Future(id)
+	assertNoError(t, err)
 
-	result, err := tm.Await(ctx, taskID)
-	if err == nil {
-		t.Fatal("expected error from panicked task, got nil")
+	data, err := json.Marshal(future)
+	assertNoError(t, err)
+	assertEqual(t, string(data), `{"id":"`+id.String()+`","status":"completed","result":{"rows":3},"error":null,`+
+		`"submitted":"2025-01-01T00:00:00Z","started":"2025-01-01T00:00:00Z","finished":"2025-01-01T00:00:02Z",`+
+		`"duration":2000000000,"wait":0,"memory":12,"labels":{"job":"report"}}`)
+
+	var decoded Future
+	assertNoError(t, json.Unmarshal(data, &decoded))
+	assertEqual(t, decoded.ID, id)
+	assertEqual(t, decoded.Status, StatusCompleted.String())
+	assertEqual(t, decoded.Result.(map[string]any)["rows"], float64(3))
+	assertEqual(t, decoded.Error, nil)
+	assertEqual(t, decoded.Submitted.Equal(start), true)
+	assertEqual(t, decoded.Finished.Equal(start.Add(2*time.Second)), true)
+	assertEqual(t, decoded.Duration, 2*time.Second)
+	assertEqual(t, decoded.Labels["job"], "report")
+
+	// Errors keep their message and the sentinel they start with
+	future = Future{ID: id, Status: StatusCanceled.String(), Error: fmt.Errorf("%w: %v", ErrTaskCanceled, context.Canceled)}
+	data, err = json.Marshal(future)
+	assertNoError(t, err)
+	assertNoError(t, json.Unmarshal(data, &decoded))
+	assertEqual(t, decoded.Error.Error(), future.Error.Error())
+	assertError(t, decoded.Error, ErrTaskCanceled)
+	assertEqual(t, decoded.Result, nil)
+	assertEqual(t, decoded.Time.IsZero(), true)
+
+	// Other codecs carry their results encoded, next to their name
+	tm = NewManager(WithCodec(MsgpackCodec{}), WithInlineExecution())
+	id = tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return map[string]any{"rows": 3}, nil
+	}))
+	future, _ = tm.Future(id)
+	data, err = json.Marshal(future)
+	assertNoError(t, err)
+	if !strings.Contains(string(data), `"codec":"msgpack","result":"`) {
+		t.Fatalf("expected a msgpack result, got %s", data)
 	}
+	assertNoError(t, json.Unmarshal(data, &decoded))
+	assertEqual(t, decoded.Result.(map[string]any)["rows"], int8(3))
+	assertError(t, json.Unmarshal([]byte(`{"codec":"xml","result":"PGEvPg=="}`), &decoded), ErrUnknownCodec)
+}
 
-	if !errors.Is(err, ErrTaskFailed) {
-		t.Fatalf("expected ErrTaskFailed, got %v", err)
+// Test results round trip through each codec
+func TestCodecs(t *testing.T) {
+	type row struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
 	}
+	value := map[string]any{"list": []any{"a", int64(1)}, "ok": true, "ratio": 0.5}
 
-	if !errors.Is(result.Error, ErrTaskPanicked) {
-		t.Fatalf("expected ErrTaskPanicked in result, got %v", result.Error)
+	for _, name := range []string{CodecJSON, CodecMsgpack, CodecPHP, CodecGob} {
+		codec, err := LookupCodec(name)
+		assertNoError(t, err)
+		assertEqual(t, codec.Name(), name)
+
+		data, err := codec.Marshal(value)
+		assertNoError(t, err)
+		var decoded any
+		assertNoError(t, codec.Unmarshal(data, &decoded))
+		got := decoded.(map[string]any)
+		assertEqual(t, got["ok"], true)
+		assertEqual(t, got["ratio"], 0.5)
+		assertEqual(t, got["list"].([]any)[0], "a")
+
+		if name == CodecGob {
+			continue
+		}
+		data, err = codec.Marshal(row{Name: "x", Count: 2})
+		assertNoError(t, err)
+		var decodedRow row
+		assertNoError(t, codec.Unmarshal(data, &decodedRow))
+		assertEqual(t, decodedRow, row{Name: "x", Count: 2})
 	}
+
+	_, err := LookupCodec("xml")
+	assertError(t, err, ErrUnknownCodec)
+
+	// Custom codecs are found by name once registered
+	RegisterCodec(upperCodec{})
+	codec, err := LookupCodec("upper")
+	assertNoError(t, err)
+	assertEqual(t, codec.Name(), "upper")
 }
 
-// Test idempotent await
-func TestIdempotentAwait(t *testing.T) {
-	tm := NewManager()
-	ctx := context.Background()
+// Test the PHP codec writes what unserialize() reads, integers kept
+func TestPHPCodec(t *testing.T) {
+	codec := PHPCodec{}
+	data, err := codec.Marshal(map[string]any{"id": 7, "tags": []string{"a"}, "price": 1.5, "none": nil, "3": "three"})
+	assertNoError(t, err)
+	assertEqual(t, string(data), `a:5:{i:3;s:5:"three";s:2:"id";i:7;s:4:"none";N;s:5:"price";d:1.5;s:4:"tags";a:1:{i:0;s:1:"a";}}`)
 
-	expected := "test result"
-	taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
-		return expected, nil
-	}))
+	// Objects decode as their properties, protected and private ones too
+	var decoded any
+	assertNoError(t, codec.Unmarshal([]byte("O:4:\"User\":3:{s:2:\"id\";i:42;s:7:\"\x00*\x00name\";s:3:\"Ann\";s:9:\"\x00User\x00age\";i:30;}"), &decoded))
+	user := decoded.(map[string]any)
+	assertEqual(t, user["id"], int64(42))
+	assertEqual(t, user["name"], "Ann")
+	assertEqual(t, user["age"], int64(30))
 
-	// Await multiple times
-	result1, err1 := tm.Await(ctx, taskID)
-	assertNoError(t, err1)
+	assertNoError(t, codec.Unmarshal([]byte(`a:2:{i:0;b:1;i:5;s:0:"";}`), &decoded))
+	assertEqual(t, decoded.(map[string]any)["5"], "")
 
-	result2, err2 := tm.Await(ctx, taskID)
-	assertNoError(t, err2)
+	for _, bad := range []string{"", "i:1", `s:5:"abc";`, "a:1:{i:0;i:1;", "x:1;", "i:1;i:2;"} {
+		assertError(t, codec.Unmarshal([]byte(bad), &decoded), errPHPSyntax)
+	}
 
-	// Results should be identical
-	assertEqual(t, result1.Result, result2.Result)
-	assertEqual(t, result1.ID, result2.ID)
+	// Hostile lengths fail rather than panic or allocate for them
+	for _, bad := range []string{
+		`s:9223372036854775807:"x";`,
+		`s:99999999999999999999:"x";`,
+		`O:9223372036854775807:"x":0:{}`,
+		"a:100000000000:{",
+		"a:9223372036854775807:{i:0;N;}",
+		`a:2:{s:1:"k";N;s:1:"l";`,
+		"a:-1:{}",
+	} {
+		assertError(t, codec.Unmarshal([]byte(bad), &decoded), errPHPSyntax)
+	}
+
+	// Nesting is limited
+	deep := strings.Repeat("a:1:{i:0;", phpMaxDepth+1) + "N;" + strings.Repeat("}", phpMaxDepth+1)
+	assertError(t, codec.Unmarshal([]byte(deep), &decoded), errPHPSyntax)
+	deep = strings.Repeat("a:1:{i:0;", phpMaxDepth) + "N;" + strings.Repeat("}", phpMaxDepth)
+	assertNoError(t, codec.Unmarshal([]byte(deep), &decoded))
 }
 
-// Test concurrent task execution
-func TestConcurrentTasks(t *testing.T) {
-	tm := NewManager()
+// upperCodec is a custom codec writing strings in upper case.
+type upperCodec struct{ JSONCodec }
+
+func (upperCodec) Name() string { return "upper" }
+
+func (c upperCodec) Marshal(v any) ([]byte, error) {
+	return c.JSONCodec.Marshal(strings.ToUpper(fmt.Sprint(v)))
+}
+
+// Test a manager shuts down with its parent context
+func TestParentContext(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	tm := NewManager(WithParentContext(parent))
 	ctx := context.Background()
 
-	numTasks := 100
-	var taskIDs []ID
-	results := make([]int, numTasks)
+	started, canceled := make(chan struct{}), make(chan struct{})
+	tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	}))
+	<-started
 
-	for i := 0; i < numTasks; i++ {
-		idx := i // capture loop variable
-		taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
-			return idx, nil
-		}))
-		taskIDs = append(taskIDs, taskID)
+	cancel()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected task context to be canceled with the parent")
 	}
 
-	// Await all tasks concurrently
-	var wg sync.WaitGroup
-	for i, taskID := range taskIDs {
-		wg.Add(1)
-		go func(index int, id ID) {
-			defer wg.Done()
-			result, err := tm.Await(ctx, id)
-			if err != nil {
-				t.Errorf("task %d failed: %v", index, err)
-				return
-			}
-			results[index] = result.Result.(int)
-		}(i, taskID)
+	for tm.Stats().Total > 0 {
+		time.Sleep(time.Millisecond)
 	}
-
-	wg.Wait()
-
-	// Verify all results
-	for i := 0; i < numTasks; i++ {
-		if results[i] != i {
-			t.Errorf("expected result[%d] = %d, got %d", i, i, results[i])
+	_, err := tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	})))
+	assertError(t, err, ErrTaskCanceled)
+
+	// A parent done already shuts the manager down right away, refusing
+	// tasks before its shutdown starts
+	tm = NewManager(WithParentContext(parent))
+	_, err = tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		t.Error("task ran after its parent was done")
+		return nil, nil
+	})))
+	assertError(t, err, ErrTaskCanceled)
+	for {
+		tm.mu.Lock()
+		shuttingDown := tm.shuttingDown
+		tm.mu.Unlock()
+		if shuttingDown {
+			break
 		}
+		time.Sleep(time.Millisecond)
 	}
 }
 
-// Test Shutdown
-func TestShutdown(t *testing.T) {
+// Test tasks see the values and deadline of the parent context, the values
+// of the submitting context first
+func TestParentContext_Values(t *testing.T) {
+	type key string
+	deadline := time.Now().Add(time.Hour)
+	parent, cancel := context.WithDeadline(context.WithValue(context.WithValue(context.Background(),
+		key("tenant"), "acme"), key("locale"), "en"), deadline)
+	defer cancel()
+	tm := NewManager(WithParentContext(parent))
+	defer tm.Shutdown(context.Background())
+
+	ctx := context.WithValue(context.Background(), key("locale"), "nl")
+	result, err := tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		taskDeadline, _ := ctx.Deadline()
+		return []any{ctx.Value(key("tenant")), ctx.Value(key("locale")), taskDeadline.Equal(deadline)}, nil
+	})))
+	assertNoError(t, err)
+	assertEqual(t, fmt.Sprint(result.Result), "[acme nl true]")
+
+	// The earlier deadline of the submitting context wins
+	early := time.Now().Add(time.Minute)
+	ctx, cancelEarly := context.WithDeadline(ctx, early)
+	defer cancelEarly()
+	result, err = tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		taskDeadline, _ := ctx.Deadline()
+		return taskDeadline.Equal(early), nil
+	})))
+	assertNoError(t, err)
+	assertEqual(t, result.Result, true)
+
+	// Tasks are still canceled with the context they were submitted with
+	ctx, cancelSubmit := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	id := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	<-started
+	cancelSubmit()
+	_, err = tm.Await(context.Background(), id)
+	assertError(t, err, context.Canceled)
+}
+
+// Test draining lets running tasks finish and reports those that don't
+func TestDrain(t *testing.T) {
 	tm := NewManager()
 	ctx := context.Background()
 
-	// Start several long-running tasks
-	numTasks := 10
-	var taskIDs []ID
-	for i := 0; i < numTasks; i++ {
-		taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
-			select {
-			case <-time.After(1 * time.Second):
-				return "should not complete", nil
-			case <-ctx.Done():
-				return nil, ctx.Err()
-			}
-		}))
-		taskIDs = append(taskIDs, taskID)
-	}
-
-	// Give tasks a moment to start
-	time.Sleep(10 * time.Millisecond)
+	release := make(chan struct{})
+	finished := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return "done", nil
+	}))
+	_, err := tm.Await(ctx, finished)
+	assertNoError(t, err)
+	fast := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		time.Sleep(10 * time.Millisecond)
+		return "fast", nil
+	}))
+	stuck := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return "stuck", nil
+	}))
+	deferred := tm.Defer(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return "deferred", nil
+	}))
 
-	// Shutdown with timeout
-	shutdownCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	drainCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
 	defer cancel()
+	report, err := tm.Drain(drainCtx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	assertEqual(t, len(report.Completed), 1)
+	assertEqual(t, report.Completed[0], fast)
+	assertEqual(t, len(report.Abandoned), 1)
+	assertEqual(t, report.Abandoned[0], stuck)
+	assertEqual(t, len(report.Canceled), 0)
+
+	// New submissions are refused; drained tasks keep running
+	_, err = tm.Await(ctx, tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, nil
+	})))
+	assertError(t, err, ErrTaskCanceled)
+	status, _ := tm.Status(stuck)
+	assertEqual(t, status, StatusRunning)
 
-	tm.Shutdown(shutdownCtx)
+	close(release)
+	report, err = tm.Drain(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(report.Completed), 1)
+	assertEqual(t, report.Completed[0], stuck)
 
-	// Verify tasks are cleaned up
-	count := 0
-	tm.taskStatuses.Range(func(key, value interface{}) bool {
-		count++
-		return true
+	// Shutdown cancels what never ran
+	report, err = tm.Shutdown(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(report.Canceled), 1)
+	assertEqual(t, report.Canceled[0], deferred)
+}
+
+// Test Close waits for the tasks left as the shutdown policy says
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+	slow := RunnableFunc(func(ctx context.Context) (any, error) {
+		select {
+		case <-time.After(20 * time.Millisecond):
+			return "slow", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	stuck := RunnableFunc(func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
 	})
 
-	if count > 0 {
-		t.Errorf("expected all tasks to be cleaned up, but found %d remaining", count)
-	}
+	// Cancel doesn't wait
+	tm := NewManager()
+	id := tm.Async(ctx, slow)
+	report, err := tm.Close(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(report.Canceled), 1)
+	assertEqual(t, report.Canceled[0], id)
+
+	// Wait lets tasks finish within the timeout and cancels the others
+	tm = NewManager(WithShutdownPolicy(ShutdownWait, 200*time.Millisecond))
+	done := tm.Async(ctx, slow)
+	assertNoError(t, tm.SetShutdownPolicy(ShutdownWait, 50*time.Millisecond))
+	canceled := tm.Async(ctx, stuck)
+	report, err = tm.Close(ctx)
+	assertNoError(t, err)
+	assertEqual(t, len(report.Completed), 1)
+	assertEqual(t, report.Completed[0], done)
+	assertEqual(t, len(report.Canceled), 1)
+	assertEqual(t, report.Canceled[0], canceled)
 
-	// Verify we can't await the canceled tasks
-	for _, taskID := range taskIDs {
-		_, err := tm.Await(context.Background(), taskID)
-		if err == nil || !errors.Is(err, ErrTaskNotFound) {
-			t.Errorf("expected ErrTaskNotFound for shutdown task, got %v", err)
-		}
+	assertError(t, tm.SetShutdownPolicy(ShutdownDetach, -time.Second), ErrInvalidOption)
+	policy, err := ParseShutdownPolicy("detach")
+	assertNoError(t, err)
+	assertEqual(t, policy, ShutdownDetach)
+	_, err = ParseShutdownPolicy("linger")
+	if err == nil {
+		t.Fatal("expected an error for an unknown policy")
 	}
 }
 
-// TestStress_WorkerLimit verifies that the Manager respects a worker concurrency limit.
-func TestStress_WorkerLimit(t *testing.T) {
-	t.Log("Stress test: verify manager respects worker concurrency limit")
-	tm := NewManager(WithWorkerLimit(2))
-	ctx := context.Background()
-
-	running := int32(0)
-	maxConcurrent := int32(0)
+// Test Bind keeps tasks running for the grace after a disconnect, and
+// side-effect tasks until they finish.
+func TestBind(t *testing.T) {
+	stuck := RunnableFunc(func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	write := make(chan struct{})
+	var written atomic.Bool
+	sideEffect := RunnableFunc(func(ctx context.Context) (any, error) {
+		if LabelsFromContext(ctx)[SideEffectsLabel] != "true" {
+			return nil, errors.New("side-effect task without its label")
+		}
+		select {
+		case <-write:
+			written.Store(true)
+			return "written", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
 
-	var taskIDs []ID
-	for i := 0; i < 10; i++ {
-		taskID := tm.Async(ctx, RunnableFunc(func(ctx context.Context) (any, error) {
-			current := atomic.AddInt32(&running, 1)
+	reqCtx, disconnect := context.WithCancel(context.Background())
+	tm := NewManager()
+	closed := make(chan struct{})
+	ctx, closeManager := tm.Bind(reqCtx, 20*time.Millisecond, func() { close(closed) })
 
-			for {
-				max := atomic.LoadInt32(&maxConcurrent)
-				if current <= max || atomic.CompareAndSwapInt32(&maxConcurrent, max, current) {
-					break
-				}
-			}
+	canceled := tm.Async(ctx, stuck)
+	tm.Async(WithSideEffects(ctx), sideEffect)
 
-			time.Sleep(10 * time.Millisecond)
-			atomic.AddInt32(&running, -1)
-			return nil, nil
-		}))
-		taskIDs = append(taskIDs, taskID)
+	// The grace starts with the disconnect
+	disconnect()
+	select {
+	case <-ctx.Done():
+		t.Fatal("context canceled before the grace ran out")
+	case <-time.After(5 * time.Millisecond):
 	}
+	<-ctx.Done()
 
-	_, err := tm.AwaitAll(ctx, taskIDs)
-	assertNoError(t, err)
+	_, err := tm.Await(context.Background(), canceled)
+	assertError(t, err, context.Canceled)
 
-	if maxConcurrent > 2 {
-		t.Errorf("expected max concurrent tasks <= 2, got %d", maxConcurrent)
-	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(write)
+	}()
+	closeManager()
+	<-closed
+
+	assertEqual(t, written.Load(), true)
+
+	// Detached tasks keep running through a disconnect
+	reqCtx, disconnect = context.WithCancel(context.Background())
+	tm = NewManager(WithShutdownPolicy(ShutdownDetach, time.Second))
+	ctx, closeManager = tm.Bind(reqCtx, 0, nil)
+	disconnect()
+	time.Sleep(5 * time.Millisecond)
+	assertNoError(t, ctx.Err())
+	closeManager()
 }
 
 // TestStress_Concurrent stresses the Manager with many concurrent tasks.
@@ -708,8 +4093,7 @@ func TestStress_Concurrent(t *testing.T) {
 
 	tm := NewManager(
 		func(m *Manager) {
-			m.workerLimit = 4 // deliberately low to force contention
-			m.workerSemaphore = make(chan struct{}, m.workerLimit)
+			m.workers = newSemaphore(4) // deliberately low to force contention
 		},
 	)
 	ctx := context.Background()
@@ -930,3 +4314,267 @@ func TestStress_Shutdown(t *testing.T) {
 		t.Errorf("expected 0 total tasks after shutdown, got %d", stats.Total)
 	}
 }
+
+// benchmarkTasks is the number of tasks tracked by the manager while
+// benchmarking, to measure lookups in a table of realistic size.
+const benchmarkTasks = 100_000
+
+// newBenchmarkManager returns a manager tracking n finished tasks, and
+// their IDs.
+func newBenchmarkManager(b *testing.B, n int) (*Manager, []ID) {
+	b.Helper()
+
+	tm := NewManager(WithLogCapacity(0))
+	b.Cleanup(func() { tm.Shutdown(context.Background()) })
+
+	noop := RunnableFunc(func(ctx context.Context) (any, error) { return nil, nil })
+	ids := make([]ID, n)
+	for i := range ids {
+		ids[i] = tm.Async(context.Background(), noop)
+	}
+	if _, err := tm.AwaitAll(context.Background(), ids); err != nil {
+		b.Fatal(err)
+	}
+	return tm, ids
+}
+
+// Benchmark submitting and awaiting tasks next to 100k tracked ones
+func BenchmarkAsyncAwait(b *testing.B) {
+	tm, _ := newBenchmarkManager(b, benchmarkTasks)
+	noop := RunnableFunc(func(ctx context.Context) (any, error) { return nil, nil })
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := tm.Await(context.Background(), tm.Async(context.Background(), noop)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Benchmark looking up futures among 100k tracked tasks
+func BenchmarkFuture(b *testing.B) {
+	tm, ids := newBenchmarkManager(b, benchmarkTasks)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(len(ids))
+		for pb.Next() {
+			if _, err := tm.Future(ids[i%len(ids)]); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+// Benchmark canceling tasks, racing their completion, among 100k tracked
+// tasks
+func BenchmarkCancel(b *testing.B) {
+	tm, _ := newBenchmarkManager(b, benchmarkTasks)
+	noop := RunnableFunc(func(ctx context.Context) (any, error) { return nil, nil })
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tm.Cancel(tm.Async(context.Background(), noop))
+		}
+	})
+}
+
+// Benchmark AwaitAll over windows of 1000 of 100k tracked tasks, from
+// many awaiters at once
+func BenchmarkAwaitAll(b *testing.B) {
+	tm, ids := newBenchmarkManager(b, benchmarkTasks)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			start := rand.Intn(len(ids) - 1000)
+			if _, err := tm.AwaitAll(context.Background(), ids[start:start+1000]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Benchmark AwaitAny over windows of 1000 of 100k tracked tasks, from
+// many awaiters at once
+func BenchmarkAwaitAny(b *testing.B) {
+	tm, ids := newBenchmarkManager(b, benchmarkTasks)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			start := rand.Intn(len(ids) - 1000)
+			if _, err := tm.AwaitAny(context.Background(), ids[start:start+1000]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// Benchmark fanning out many tasks over a small pool, per executor
+func BenchmarkFanOut(b *testing.B) {
+	const tasks = 10000
+	noop := RunnableFunc(func(ctx context.Context) (any, error) { return nil, nil })
+
+	for _, executor := range []Executor{GoroutineExecutor{}, PoolExecutor{}} {
+		b.Run(executor.name(), func(b *testing.B) {
+			tm := NewManager(WithLogCapacity(0), WithWorkerLimit(8), WithExecutor(executor))
+			defer tm.Shutdown(context.Background())
+			ids := make([]ID, tasks)
+			b.ReportAllocs()
+			for range b.N {
+				for i := range ids {
+					ids[i] = tm.Async(context.Background(), noop)
+				}
+				if _, err := tm.AwaitAll(context.Background(), ids); err != nil {
+					b.Fatal(err)
+				}
+				tm.Prune(0)
+			}
+		})
+	}
+}
+
+// Benchmark awaiting finished tasks while another tenant submits a burst,
+// with the task table sharded by ID and by namespace
+func BenchmarkNamespaceSharding(b *testing.B) {
+	noop := RunnableFunc(func(ctx context.Context) (any, error) { return nil, nil })
+
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"id", nil},
+		{"namespace", []Option{WithNamespaceSharding("tenant")}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			tm := NewManager(append([]Option{WithLogCapacity(0)}, tt.opts...)...)
+			defer tm.Shutdown(context.Background())
+
+			quiet := WithLabels(context.Background(), map[string]string{"tenant": "quiet"})
+			ids := make([]ID, 1024)
+			for i := range ids {
+				ids[i] = tm.Async(quiet, noop)
+			}
+			if _, err := tm.AwaitAll(quiet, ids); err != nil {
+				b.Fatal(err)
+			}
+
+			// The burst keeps storing and pruning its records until the
+			// benchmark is done
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for range runtime.GOMAXPROCS(0) {
+				wg.Go(func() {
+					burst := WithLabels(context.Background(), map[string]string{"tenant": "burst"})
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						rec := tm.newRecord(burst, noop, StatusCompleted)
+						tm.tasks.store(rec)
+						tm.tasks.delete(rec)
+					}
+				})
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if _, err := tm.Await(quiet, ids[i%len(ids)]); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+// Benchmark the first tasks of a fresh manager, prewarmed or not
+func BenchmarkFirstTasks(b *testing.B) {
+	const tasks = 64
+	noop := RunnableFunc(func(ctx context.Context) (any, error) { return nil, nil })
+
+	for _, prewarm := range []int{0, tasks} {
+		b.Run(fmt.Sprintf("prewarm=%d", prewarm), func(b *testing.B) {
+			ids := make([]ID, tasks)
+			b.ReportAllocs()
+			for range b.N {
+				b.StopTimer()
+				tm := NewManager(WithLogCapacity(0), WithPrewarm(prewarm))
+				b.StartTimer()
+
+				for i := range ids {
+					ids[i] = tm.Async(context.Background(), noop)
+				}
+				if _, err := tm.AwaitAll(context.Background(), ids); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				tm.Shutdown(context.Background())
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// Benchmark encoding and decoding a large task result, 1000 records of
+// mixed values, with JSON and msgpack
+func BenchmarkCodec(b *testing.B) {
+	records := make([]any, 1000)
+	for i := range records {
+		records[i] = map[string]any{
+			"id":     i,
+			"name":   fmt.Sprintf("record %d", i),
+			"score":  float64(i) / 3,
+			"active": i%2 == 0,
+			"tags":   []any{"a", "b", "c"},
+			"body":   strings.Repeat("x", 200),
+		}
+	}
+	result := map[string]any{"total": len(records), "records": records}
+
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}} {
+		data, err := codec.Marshal(result)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(codec.Name()+"/encode", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := codec.Marshal(result); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(codec.Name()+"/decode", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for range b.N {
+				var v any
+				if err := codec.Unmarshal(data, &v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
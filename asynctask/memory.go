@@ -0,0 +1,139 @@
+package asynctask
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+)
+
+// maxSizeDepth bounds how deep resultSize follows pointers and nesting, so
+// cyclic results are counted once rather than forever.
+const maxSizeDepth = 32
+
+// ErrMemoryExceeded is the error of tasks whose result is larger than the
+// manager's task memory limit, see WithMaxTaskMemory.
+var ErrMemoryExceeded = errors.New("task memory exceeded")
+
+// Sizer is implemented by task results that know how many bytes they
+// hold, instead of having them estimated.
+type Sizer interface {
+	Size() int64
+}
+
+// WithMaxTaskMemory limits the approximate size of a task's result, such
+// as the body a PHP fragment returned. A task over the limit fails with
+// ErrMemoryExceeded and its result is dropped, so it can't pile up in the
+// manager while awaited; it's logged at warn level and counted in
+// Stats.OverMemory. Sizes are estimated from strings, byte slices and
+// the containers holding them, or reported by a Sizer. Defaults to 0, no
+// limit.
+func WithMaxTaskMemory(bytes int64) Option {
+	return func(m *Manager) {
+		if bytes < 0 {
+			m.invalidOption("max task memory %d, must not be negative", bytes)
+			return
+		}
+		m.maxMemory = bytes
+	}
+}
+
+// account sets the memory of a task's result and fails the task when it's
+// over the limit, before it's recorded.
+func (tm *Manager) account(rec *taskRecord, result *Future, status *Status) {
+	if result.Result == nil {
+		return
+	}
+	result.Memory = resultSize(result.Result)
+	if *status == StatusCompleted && tm.offload(rec, result) {
+		return
+	}
+	if tm.maxMemory <= 0 || result.Memory <= tm.maxMemory {
+		return
+	}
+
+	tm.overMemory.Add(1)
+	tm.logger.Warn("Task Over Memory",
+		slog.String("id", rec.id.String()),
+		slog.Int64("memory", result.Memory),
+		slog.Int64("limit", tm.maxMemory),
+	)
+	result.Error = fmt.Errorf("%w: result of %d bytes, limit %d", ErrMemoryExceeded, result.Memory, tm.maxMemory)
+	result.Result = nil
+	if *status == StatusCompleted {
+		*status = StatusFailed
+	}
+}
+
+// resultSize estimates the bytes held by a task result.
+func resultSize(result any) int64 {
+	return sizeOf(reflect.ValueOf(result), 0)
+}
+
+func sizeOf(v reflect.Value, depth int) int64 {
+	if !v.IsValid() || depth > maxSizeDepth {
+		return 0
+	}
+	if v.CanInterface() {
+		if s, ok := v.Interface().(Sizer); ok && (v.Kind() != reflect.Pointer || !v.IsNil()) {
+			return s.Size()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return sizeOf(v.Elem(), depth+1)
+	case reflect.Slice, reflect.Array:
+		// Elements without pointers take their size each, the rest are
+		// walked
+		if elem := v.Type().Elem(); !hasPointers(elem) {
+			return int64(v.Len()) * int64(elem.Size())
+		}
+		var n int64
+		for i := range v.Len() {
+			n += sizeOf(v.Index(i), depth+1)
+		}
+		return n
+	case reflect.Map:
+		var n int64
+		iter := v.MapRange()
+		for iter.Next() {
+			n += sizeOf(iter.Key(), depth+1) + sizeOf(iter.Value(), depth+1)
+		}
+		return n
+	case reflect.Struct:
+		var n int64
+		for i := range v.NumField() {
+			n += sizeOf(v.Field(i), depth+1)
+		}
+		return n
+	default:
+		return int64(v.Type().Size())
+	}
+}
+
+// hasPointers reports whether values of t refer to memory outside them.
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Array:
+		return hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := range t.NumField() {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,111 @@
+package asynctask
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Outcomes of an operation recorded by WithMetrics.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeError    = "error"
+	OutcomeTimeout  = "timeout"
+	OutcomeCanceled = "canceled"
+)
+
+// OperationBuckets are the upper bounds, in seconds, of the duration
+// histogram buckets of operations.
+var OperationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// OperationStats holds the durations and outcomes recorded for an operation
+// since startup.
+type OperationStats struct {
+	Name     string            `json:"name"`
+	Count    uint64            `json:"count"`
+	Sum      float64           `json:"sum"`     // seconds
+	Buckets  []uint64          `json:"buckets"` // cumulative, by OperationBuckets
+	Outcomes map[string]uint64 `json:"outcomes"`
+}
+
+// operations holds the stats of every operation name WithMetrics records,
+// across managers.
+var operations = struct {
+	mu    sync.Mutex
+	stats map[string]*OperationStats
+}{stats: make(map[string]*OperationStats)}
+
+// WithMetrics wraps a runnable to record its duration, timed by the clock
+// from ClockFromContext, and its outcome under the name of the logical
+// operation it performs, whichever task runs it. Wrapping WithRetry counts
+// one operation for all attempts, wrapping the runnable inside it one per
+// attempt. Operations returns the stats, which the admin metrics route
+// exports.
+func WithMetrics(runnable Runnable, name string) Runnable {
+	return RunnableFunc(func(ctx context.Context) (any, error) {
+		clock := ClockFromContext(ctx)
+		start := clock.Now()
+		result, err := runnable.Run(ctx)
+		recordOperation(name, clock.Now().Sub(start), outcome(err))
+		return result, err
+	})
+}
+
+// Operations returns the stats of the operations recorded by WithMetrics,
+// sorted by name.
+func Operations() []OperationStats {
+	operations.mu.Lock()
+	defer operations.mu.Unlock()
+
+	list := make([]OperationStats, 0, len(operations.stats))
+	for _, name := range slices.Sorted(maps.Keys(operations.stats)) {
+		op := *operations.stats[name]
+		op.Buckets = slices.Clone(op.Buckets)
+		op.Outcomes = maps.Clone(op.Outcomes)
+		list = append(list, op)
+	}
+	return list
+}
+
+// recordOperation adds a run of the operation name to its stats.
+func recordOperation(name string, d time.Duration, outcome string) {
+	operations.mu.Lock()
+	defer operations.mu.Unlock()
+
+	op, ok := operations.stats[name]
+	if !ok {
+		op = &OperationStats{
+			Name:     name,
+			Buckets:  make([]uint64, len(OperationBuckets)),
+			Outcomes: make(map[string]uint64),
+		}
+		operations.stats[name] = op
+	}
+
+	seconds := d.Seconds()
+	op.Count++
+	op.Sum += seconds
+	for i, le := range OperationBuckets {
+		if seconds <= le {
+			op.Buckets[i]++
+		}
+	}
+	op.Outcomes[outcome]++
+}
+
+// outcome classifies the error an operation returned.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, ErrTaskTimeout), errors.Is(err, context.DeadlineExceeded):
+		return OutcomeTimeout
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrTaskCanceled):
+		return OutcomeCanceled
+	default:
+		return OutcomeError
+	}
+}
@@ -0,0 +1,162 @@
+package asynctask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// offloadTimeout bounds deleting the offloaded results of pruned tasks and
+// managers shut down.
+const offloadTimeout = time.Minute
+
+// ErrResultNotFound is returned by a ResultStore for keys it doesn't hold,
+// or no longer does once they expired. Awaiting a task whose offloaded
+// result is gone fails with it.
+var ErrResultNotFound = errors.New("result not found")
+
+type (
+	// ResultStore keeps the results of tasks outside of the manager, see
+	// WithResultOffload. Stores must be safe for concurrent use.
+	ResultStore interface {
+		// Put stores data under key, to expire ttl later, or never for 0.
+		Put(ctx context.Context, key string, data []byte, ttl time.Duration) error
+
+		// Get returns the data stored under key, or an error wrapping
+		// ErrResultNotFound when there's none or it expired.
+		Get(ctx context.Context, key string) ([]byte, error)
+
+		// Delete removes key, if stored.
+		Delete(ctx context.Context, key string) error
+	}
+
+	// offloadedResult refers to a task result kept in a ResultStore.
+	offloadedResult struct {
+		store ResultStore
+		key   string
+		kind  resultKind
+		codec Codec
+	}
+
+	// resultKind is how an offloaded result was stored.
+	resultKind int
+)
+
+const (
+	resultEncoded resultKind = iota // by the manager's codec
+	resultBytes                     // a []byte as is
+	resultString                    // a string as is
+)
+
+// WithResultOffload moves the results of tasks completing with more than
+// threshold bytes, as estimated for WithMaxTaskMemory, to store, keeping
+// only a reference in memory. Await fetches them again each time, so
+// results the size of report artifacts don't pile up in the manager.
+// Byte slices and strings are stored as they are and come back as such;
+// other results are encoded by the manager's codec and come back as it
+// decodes them into an any. Stored results expire ttl later, or never for
+// 0, and are deleted when their task is pruned or the manager shut down.
+// A result that fails to store is kept in memory and logged at warn
+// level. The memory limit of WithMaxTaskMemory doesn't apply to offloaded
+// results.
+func WithResultOffload(store ResultStore, threshold int64, ttl time.Duration) Option {
+	return func(m *Manager) {
+		if store == nil {
+			m.invalidOption("nil result store")
+			return
+		}
+		if threshold < 0 {
+			m.invalidOption("result offload threshold %d, must not be negative", threshold)
+			return
+		}
+		if ttl < 0 {
+			m.invalidOption("result offload TTL %s, must not be negative", ttl)
+			return
+		}
+		m.results = store
+		m.offloadThreshold = threshold
+		m.offloadTTL = ttl
+	}
+}
+
+// offload moves the result of a completed task over the offload threshold
+// to the result store, reporting whether it did.
+func (tm *Manager) offload(rec *taskRecord, result *Future) bool {
+	if tm.results == nil || result.Memory <= tm.offloadThreshold || result.Error != nil {
+		return false
+	}
+
+	ref := &offloadedResult{store: tm.results, key: rec.id.String(), codec: tm.codec}
+	var data []byte
+	switch v := result.Result.(type) {
+	case []byte:
+		ref.kind, data = resultBytes, v
+	case string:
+		ref.kind, data = resultString, []byte(v)
+	default:
+		var err error
+		if data, err = tm.codec.Marshal(v); err != nil {
+			tm.logger.Warn("Result Offload Failed", slog.String("id", rec.id.String()), slog.Any("error", err))
+			return false
+		}
+	}
+
+	ctx := context.WithoutCancel(rec.ctx)
+	if err := tm.results.Put(ctx, ref.key, data, tm.offloadTTL); err != nil {
+		tm.logger.Warn("Result Offload Failed", slog.String("id", rec.id.String()), slog.Any("error", err))
+		return false
+	}
+
+	rec.mu.Lock()
+	rec.offloaded = ref
+	rec.mu.Unlock()
+	result.Result = nil
+	result.Offloaded = true
+	return true
+}
+
+// offloadedResult returns the reference to the record's offloaded result,
+// nil unless offloaded.
+func (r *taskRecord) offloadedResult() *offloadedResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.offloaded
+}
+
+// fetch returns the offloaded result.
+func (r *offloadedResult) fetch(ctx context.Context) (any, error) {
+	data, err := r.store.Get(ctx, r.key)
+	if err != nil {
+		return nil, err
+	}
+	switch r.kind {
+	case resultBytes:
+		return data, nil
+	case resultString:
+		return string(data), nil
+	}
+	var v any
+	if err := r.codec.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("decoding offloaded result: %w", err)
+	}
+	return v, nil
+}
+
+// deleteOffloaded deletes the offloaded results of records pruned or shut
+// down, in the background.
+func (tm *Manager) deleteOffloaded(refs []*offloadedResult) {
+	if len(refs) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), offloadTimeout)
+		defer cancel()
+		for _, ref := range refs {
+			if err := ref.store.Delete(ctx, ref.key); err != nil {
+				tm.logger.Warn("Offloaded Result Not Deleted", slog.String("id", ref.key), slog.Any("error", err))
+			}
+		}
+	}()
+}
@@ -0,0 +1,484 @@
+// Package pgstore persists tasks in PostgreSQL, and doubles as a queue
+// several nodes run tasks from. See asynctask.WithTaskStore for the
+// former and Store.Work for the latter. WithKeyring encrypts the specs
+// and results of tasks, which may hold personal data and credentials.
+package pgstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// ErrLeaseLost is returned when a node extends, completes or releases a
+// task whose lease it no longer holds: it expired and another node may
+// have claimed the task since.
+var ErrLeaseLost = errors.New("task lease lost")
+
+// purgeInterval is the least time between purges of tasks past the
+// retention.
+const purgeInterval = time.Minute
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS asynctask_tasks (
+		id          TEXT PRIMARY KEY,
+		spec        JSONB,
+		labels      JSONB       NOT NULL DEFAULT '{}',
+		status      TEXT        NOT NULL,
+		attempts    INTEGER     NOT NULL DEFAULT 0,
+		submitted   TIMESTAMPTZ NOT NULL,
+		started     TIMESTAMPTZ,
+		finished    TIMESTAMPTZ,
+		codec       TEXT,
+		result      BYTEA,
+		error       TEXT,
+		queued      BOOLEAN     NOT NULL DEFAULT FALSE,
+		lease_owner TEXT,
+		lease_until TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS asynctask_tasks_submitted ON asynctask_tasks (submitted)`,
+	`CREATE INDEX IF NOT EXISTS asynctask_tasks_labels ON asynctask_tasks USING GIN (labels)`,
+	`CREATE INDEX IF NOT EXISTS asynctask_tasks_queue ON asynctask_tasks (submitted) WHERE queued AND status IN ('pending', 'running')`,
+}
+
+const columns = `id, spec, labels, status, attempts, submitted, started, finished, codec, result, error`
+
+// Tasks of a manager are saved up to three times, as they're submitted,
+// start and finish. Their spec, labels and submission time don't change.
+const saveQuery = `INSERT INTO asynctask_tasks (` + columns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	ON CONFLICT (id) DO UPDATE SET
+		status = excluded.status, attempts = excluded.attempts, started = excluded.started,
+		finished = excluded.finished, codec = excluded.codec, result = excluded.result, error = excluded.error`
+
+// claimQuery leases the oldest queued task that's pending, or running
+// past its lease with attempts left. SKIP LOCKED lets nodes claiming at
+// the same time each take another task instead of waiting on one.
+const claimQuery = `WITH next AS (
+		SELECT id FROM asynctask_tasks
+		WHERE queued AND (status = 'pending' OR (status = 'running' AND lease_until < now())) AND attempts < $3
+		ORDER BY submitted
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE asynctask_tasks t SET
+		status = 'running', attempts = t.attempts + 1, started = now(),
+		lease_owner = $1, lease_until = now() + $2::float8 * interval '1 millisecond'
+	FROM next WHERE t.id = next.id
+	RETURNING t.id, t.spec, t.labels, t.status, t.attempts, t.submitted, t.started, t.finished, t.codec, t.result, t.error`
+
+// expireQuery fails the queued tasks whose last attempt ran past its
+// lease, as the nodes running them went away.
+const expireQuery = `UPDATE asynctask_tasks SET
+		status = 'failed', finished = now(), error = 'lease expired after ' || attempts || ' attempts',
+		lease_owner = NULL, lease_until = NULL
+	WHERE queued AND status = 'running' AND lease_until < now() AND attempts >= $1`
+
+type (
+	// DB runs the store's statements, such as a *pgxpool.Pool.
+	DB interface {
+		Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+		Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+		QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	}
+
+	// Store is an asynctask.TaskStore keeping tasks in the
+	// asynctask_tasks table, along with the tasks queued for any node.
+	Store struct {
+		db          DB
+		pool        *pgxpool.Pool // opened by Open, closed by Close
+		maxAttempts int
+		retention   time.Duration
+		keyring     *asynctask.Keyring
+		lastPurge   atomic.Int64 // Unix nanoseconds
+	}
+
+	Option func(*Store)
+)
+
+// WithMaxAttempts sets how often a queued task is claimed before it's
+// failed, 3 by default. Only attempts whose lease expired, as their node
+// went away, are claimed again; tasks failing on their own aren't.
+func WithMaxAttempts(n int) Option {
+	return func(s *Store) {
+		if n > 0 {
+			s.maxAttempts = n
+		}
+	}
+}
+
+// WithRetention deletes finished tasks submitted longer than d ago, checked
+// at most once a minute as tasks finish. Tasks are kept forever when 0,
+// the default.
+func WithRetention(d time.Duration) Option {
+	return func(s *Store) {
+		if d > 0 {
+			s.retention = d
+		}
+	}
+}
+
+// WithKeyring seals the specs and results of tasks with keyring before
+// storing them, opening them as they're loaded. Sealed specs are stored
+// as JSON strings of their base64. Tasks stored unsealed before still
+// load; labels and errors aren't sealed, as tasks are queried by them.
+func WithKeyring(keyring *asynctask.Keyring) Option {
+	return func(s *Store) {
+		s.keyring = keyring
+	}
+}
+
+// Open connects to the database at dsn and creates the table if needed.
+func Open(ctx context.Context, dsn string, opts ...Option) (*Store, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("pgstore: %w", err)
+	}
+	s, err := New(ctx, pool, opts...)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	s.pool = pool
+	return s, nil
+}
+
+// New returns a store using db, creating the table if needed. The caller
+// closes db.
+func New(ctx context.Context, db DB, opts ...Option) (*Store, error) {
+	s := &Store{db: db, maxAttempts: 3}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("pgstore: creating table: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Close closes the connections of a store opened with Open.
+func (s *Store) Close() error {
+	if s.pool != nil {
+		s.pool.Close()
+	}
+	return nil
+}
+
+// Save inserts or replaces task.
+func (s *Store) Save(ctx context.Context, task asynctask.StoredTask) error {
+	spec, labels, err := s.encode(task)
+	if err != nil {
+		return err
+	}
+	result, err := s.seal(task.Result)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(ctx, saveQuery,
+		task.ID.String(), spec, labels, task.Status.String(), task.Attempts,
+		task.Submitted, nullTime(task.Started), nullTime(task.Finished),
+		nullString(task.Codec), result, nullString(task.Error)); err != nil {
+		return fmt.Errorf("pgstore: saving %s: %w", task.ID, err)
+	}
+	if s.retention > 0 && !task.Finished.IsZero() {
+		s.purge(ctx)
+	}
+	return nil
+}
+
+// Load returns the task stored with id.
+func (s *Store) Load(ctx context.Context, id asynctask.ID) (asynctask.StoredTask, error) {
+	task, err := s.scanTask(s.db.QueryRow(ctx, `SELECT `+columns+` FROM asynctask_tasks WHERE id = $1`, id.String()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return asynctask.StoredTask{}, fmt.Errorf("pgstore: %w: %s", asynctask.ErrTaskNotFound, id)
+	}
+	return task, err
+}
+
+// List returns the tasks query matches, most recently submitted first.
+func (s *Store) List(ctx context.Context, query asynctask.TaskQuery) ([]asynctask.StoredTask, error) {
+	stmt := `SELECT ` + columns + ` FROM asynctask_tasks WHERE TRUE`
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if len(query.Statuses) > 0 {
+		statuses := make([]string, len(query.Statuses))
+		for i, status := range query.Statuses {
+			statuses[i] = status.String()
+		}
+		stmt += ` AND status = ANY(` + arg(statuses) + `)`
+	}
+	if len(query.Labels) > 0 {
+		labels, err := json.Marshal(query.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("pgstore: %w", err)
+		}
+		stmt += ` AND labels @> ` + arg(string(labels)) + `::jsonb`
+	}
+	if !query.Since.IsZero() {
+		stmt += ` AND submitted >= ` + arg(query.Since)
+	}
+	stmt += ` ORDER BY submitted DESC, id DESC`
+	if query.Limit > 0 {
+		stmt += ` LIMIT ` + arg(query.Limit)
+	}
+
+	rows, err := s.db.Query(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("pgstore: %w", err)
+	}
+	defer rows.Close()
+	var tasks []asynctask.StoredTask
+	for rows.Next() {
+		task, err := s.scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("pgstore: %w", err)
+	}
+	return tasks, nil
+}
+
+// Purge deletes the finished tasks submitted before t, returning how many.
+func (s *Store) Purge(ctx context.Context, before time.Time) (int, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM asynctask_tasks WHERE submitted < $1 AND status IN ('completed', 'failed', 'canceled')`, before)
+	if err != nil {
+		return 0, fmt.Errorf("pgstore: purging tasks: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// purge deletes the tasks past the retention, unless done less than a
+// purge interval ago. Failures are left to the next purge.
+func (s *Store) purge(ctx context.Context) {
+	now := time.Now()
+	last := s.lastPurge.Load()
+	if now.UnixNano()-last < int64(purgeInterval) || !s.lastPurge.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	_, _ = s.Purge(ctx, now.Add(-s.retention))
+}
+
+// Enqueue queues the task spec describes for any node working the queue,
+// returning its ID to Load its outcome with.
+func (s *Store) Enqueue(ctx context.Context, spec asynctask.Spec, labels map[string]string) (asynctask.ID, error) {
+	id, err := asynctask.ParseID(asynctask.NewXID())
+	if err != nil {
+		return asynctask.ID{}, err
+	}
+	encodedSpec, encodedLabels, err := s.encode(asynctask.StoredTask{Spec: &spec, Labels: labels})
+	if err != nil {
+		return asynctask.ID{}, err
+	}
+	if _, err := s.db.Exec(ctx, `INSERT INTO asynctask_tasks (id, spec, labels, status, submitted, queued)
+		VALUES ($1, $2, $3, 'pending', now(), TRUE)`, id.String(), encodedSpec, encodedLabels); err != nil {
+		return asynctask.ID{}, fmt.Errorf("pgstore: enqueuing %s: %w", spec.Name, err)
+	}
+	return id, nil
+}
+
+// Claim leases the oldest queued task to owner for lease, counting an
+// attempt, and returns it. Returns false when no task is waiting. Queued
+// tasks whose last attempt's lease expired are failed first.
+func (s *Store) Claim(ctx context.Context, owner string, lease time.Duration) (asynctask.StoredTask, bool, error) {
+	if _, err := s.db.Exec(ctx, expireQuery, s.maxAttempts); err != nil {
+		return asynctask.StoredTask{}, false, fmt.Errorf("pgstore: expiring leases: %w", err)
+	}
+	task, err := s.scanTask(s.db.QueryRow(ctx, claimQuery, owner, lease.Milliseconds(), s.maxAttempts))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return asynctask.StoredTask{}, false, nil
+	}
+	if err != nil {
+		return asynctask.StoredTask{}, false, err
+	}
+	return task, true, nil
+}
+
+// Extend renews the lease owner holds on the task id for lease.
+func (s *Store) Extend(ctx context.Context, id asynctask.ID, owner string, lease time.Duration) error {
+	return s.leased(ctx, `UPDATE asynctask_tasks SET lease_until = now() + $3::float8 * interval '1 millisecond'
+		WHERE id = $1 AND lease_owner = $2 AND status = 'running'`, id, owner, lease.Milliseconds())
+}
+
+// Complete records the outcome of the task id owner holds the lease on:
+// its status, and its result or error.
+func (s *Store) Complete(ctx context.Context, id asynctask.ID, owner string, outcome asynctask.StoredTask) error {
+	result, err := s.seal(outcome.Result)
+	if err != nil {
+		return err
+	}
+	return s.leased(ctx, `UPDATE asynctask_tasks SET
+			status = $3, finished = now(), codec = $4, result = $5, error = $6, lease_owner = NULL, lease_until = NULL
+		WHERE id = $1 AND lease_owner = $2 AND status = 'running'`,
+		id, owner, outcome.Status.String(), nullString(outcome.Codec), result, nullString(outcome.Error))
+}
+
+// Release gives up the lease owner holds on the task id without counting
+// the attempt, so another node claims it right away.
+func (s *Store) Release(ctx context.Context, id asynctask.ID, owner string) error {
+	return s.leased(ctx, `UPDATE asynctask_tasks SET
+			status = 'pending', attempts = attempts - 1, started = NULL, lease_owner = NULL, lease_until = NULL
+		WHERE id = $1 AND lease_owner = $2 AND status = 'running'`, id, owner)
+}
+
+// leased runs stmt on the task its first two args name, the ID and the
+// lease owner, returning ErrLeaseLost if it wasn't leased to the owner.
+func (s *Store) leased(ctx context.Context, stmt string, id asynctask.ID, owner string, args ...any) error {
+	tag, err := s.db.Exec(ctx, stmt, append([]any{id.String(), owner}, args...)...)
+	if err != nil {
+		return fmt.Errorf("pgstore: %s: %w", id, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("pgstore: %w: %s", ErrLeaseLost, id)
+	}
+	return nil
+}
+
+// encode returns the JSON of the spec, nil without one, and labels of
+// task, the spec sealed with the keyring, if any.
+func (s *Store) encode(task asynctask.StoredTask) (spec, labels any, err error) {
+	if task.Spec != nil {
+		data, err := json.Marshal(task.Spec)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pgstore: encoding spec: %w", err)
+		}
+		if s.keyring != nil {
+			sealed, err := s.seal(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			data, _ = json.Marshal(sealed)
+		}
+		spec = string(data)
+	}
+	labels = "{}"
+	if len(task.Labels) > 0 {
+		data, err := json.Marshal(task.Labels)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pgstore: encoding labels: %w", err)
+		}
+		labels = string(data)
+	}
+	return spec, labels, nil
+}
+
+func (s *Store) scanTask(row pgx.Row) (asynctask.StoredTask, error) {
+	var (
+		task              asynctask.StoredTask
+		id, status        string
+		spec, labels      []byte
+		started, finished *time.Time
+		codec, taskErr    *string
+	)
+	if err := row.Scan(&id, &spec, &labels, &status, &task.Attempts, &task.Submitted, &started, &finished, &codec, &task.Result, &taskErr); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return task, err
+		}
+		return task, fmt.Errorf("pgstore: %w", err)
+	}
+
+	var err error
+	if task.ID, err = asynctask.ParseID(id); err != nil {
+		return task, fmt.Errorf("pgstore: %w", err)
+	}
+	if spec != nil {
+		if spec, err = s.openSpec(spec); err != nil {
+			return task, fmt.Errorf("pgstore: opening spec of %s: %w", id, err)
+		}
+		task.Spec = new(asynctask.Spec)
+		if err := json.Unmarshal(spec, task.Spec); err != nil {
+			return task, fmt.Errorf("pgstore: decoding spec of %s: %w", id, err)
+		}
+	}
+	if err := json.Unmarshal(labels, &task.Labels); err != nil {
+		return task, fmt.Errorf("pgstore: decoding labels of %s: %w", id, err)
+	}
+	if len(task.Labels) == 0 {
+		task.Labels = nil
+	}
+	if task.Result, err = s.open(task.Result); err != nil {
+		return task, fmt.Errorf("pgstore: opening result of %s: %w", id, err)
+	}
+	task.Status = asynctask.ParseStatus(status)
+	if started != nil {
+		task.Started = *started
+	}
+	if finished != nil {
+		task.Finished = *finished
+	}
+	if codec != nil {
+		task.Codec = *codec
+	}
+	if taskErr != nil {
+		task.Error = *taskErr
+	}
+	return task, nil
+}
+
+// seal seals data with the keyring, if any.
+func (s *Store) seal(data []byte) ([]byte, error) {
+	if s.keyring == nil || data == nil {
+		return data, nil
+	}
+	sealed, err := s.keyring.Seal(data)
+	if err != nil {
+		return nil, fmt.Errorf("pgstore: sealing: %w", err)
+	}
+	return sealed, nil
+}
+
+// open opens data sealed with the keyring, if any.
+func (s *Store) open(data []byte) ([]byte, error) {
+	if s.keyring == nil || data == nil {
+		return data, nil
+	}
+	return s.keyring.Open(data)
+}
+
+// openSpec returns the JSON of a spec, opening it if it was stored sealed
+// as a JSON string rather than an object.
+func (s *Store) openSpec(spec []byte) ([]byte, error) {
+	if len(spec) == 0 || spec[0] != '"' {
+		return spec, nil
+	}
+	if s.keyring == nil {
+		return nil, fmt.Errorf("%w: no keyring", asynctask.ErrUnsealable)
+	}
+	var sealed []byte
+	if err := json.Unmarshal(spec, &sealed); err != nil {
+		return nil, err
+	}
+	return s.keyring.Open(sealed)
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nullTime stores zero times as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
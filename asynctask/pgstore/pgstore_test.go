@@ -0,0 +1,266 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// openTest opens a store in the database FRANKENASYNC_TEST_POSTGRES names,
+// skipping the test without one, and empties its table.
+func openTest(t *testing.T, opts ...Option) *Store {
+	t.Helper()
+	dsn := os.Getenv("FRANKENASYNC_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("FRANKENASYNC_TEST_POSTGRES not set")
+	}
+	ctx := context.Background()
+	s, err := Open(ctx, dsn, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if _, err := s.db.Exec(ctx, `TRUNCATE asynctask_tasks`); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newID(t *testing.T) asynctask.ID {
+	t.Helper()
+	id, err := asynctask.ParseID(asynctask.NewXID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// Test saving, loading and listing tasks
+func TestStore(t *testing.T) {
+	s := openTest(t)
+	ctx := context.Background()
+	submitted := time.Unix(1700000000, 0)
+
+	report := asynctask.StoredTask{
+		ID:        newID(t),
+		Spec:      &asynctask.Spec{Name: "report", Params: map[string]any{"month": "2024-01"}},
+		Labels:    map[string]string{"tenant": "acme"},
+		Status:    asynctask.StatusPending,
+		Submitted: submitted,
+	}
+	if err := s.Save(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+	report.Status, report.Attempts = asynctask.StatusCompleted, 1
+	report.Started, report.Finished = submitted.Add(time.Second), submitted.Add(3*time.Second)
+	report.Codec, report.Result = asynctask.CodecJSON, []byte(`{"rows":3}`)
+	if err := s.Save(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Load(ctx, report.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != asynctask.StatusCompleted || got.Spec.Name != "report" || got.Labels["tenant"] != "acme" ||
+		string(got.Result) != `{"rows":3}` || !got.Finished.Equal(report.Finished) {
+		t.Fatalf("unexpected task %+v", got)
+	}
+	if _, err := s.Load(ctx, newID(t)); !errors.Is(err, asynctask.ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+
+	running := asynctask.StoredTask{
+		ID:        newID(t),
+		Labels:    map[string]string{"tenant": "globex"},
+		Status:    asynctask.StatusRunning,
+		Submitted: submitted.Add(time.Minute),
+		Started:   submitted.Add(time.Minute),
+	}
+	if err := s.Save(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query asynctask.TaskQuery
+		want  []asynctask.ID
+	}{
+		{asynctask.TaskQuery{}, []asynctask.ID{running.ID, report.ID}},
+		{asynctask.TaskQuery{Limit: 1}, []asynctask.ID{running.ID}},
+		{asynctask.TaskQuery{Statuses: []asynctask.Status{asynctask.StatusRunning}}, []asynctask.ID{running.ID}},
+		{asynctask.TaskQuery{Labels: map[string]string{"tenant": "acme"}}, []asynctask.ID{report.ID}},
+		{asynctask.TaskQuery{Since: submitted.Add(time.Second)}, []asynctask.ID{running.ID}},
+	} {
+		tasks, err := s.List(ctx, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []asynctask.ID
+		for _, task := range tasks {
+			ids = append(ids, task.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("%+v: got %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+
+	if n, err := s.Purge(ctx, submitted.Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("purged %d, %v", n, err)
+	}
+}
+
+// Test nodes claim queued tasks one each, and expired leases are claimed
+// again until the attempts run out
+func TestStore_Claim(t *testing.T) {
+	s := openTest(t, WithMaxAttempts(2))
+	ctx := context.Background()
+
+	first, err := s.Enqueue(ctx, asynctask.Spec{Name: "report"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Enqueue(ctx, asynctask.Spec{Name: "report"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, ok, err := s.Claim(ctx, "node-a", time.Hour)
+	if err != nil || !ok || a.ID != first || a.Attempts != 1 {
+		t.Fatalf("node-a claimed %+v, %v, %v", a, ok, err)
+	}
+	b, ok, err := s.Claim(ctx, "node-b", 50*time.Millisecond)
+	if err != nil || !ok || b.ID != second {
+		t.Fatalf("node-b claimed %+v, %v, %v", b, ok, err)
+	}
+	if _, ok, err := s.Claim(ctx, "node-c", time.Hour); ok || err != nil {
+		t.Fatalf("expected an empty queue, got %v, %v", ok, err)
+	}
+
+	// Only the lease holder completes a task
+	if err := s.Complete(ctx, first, "node-b", asynctask.StoredTask{Status: asynctask.StatusCompleted}); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost, got %v", err)
+	}
+	if err := s.Complete(ctx, first, "node-a", asynctask.StoredTask{Status: asynctask.StatusCompleted, Codec: asynctask.CodecJSON, Result: []byte(`1`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// node-b went away: its task is claimed again, then failed
+	time.Sleep(100 * time.Millisecond)
+	c, ok, err := s.Claim(ctx, "node-c", 50*time.Millisecond)
+	if err != nil || !ok || c.ID != second || c.Attempts != 2 {
+		t.Fatalf("node-c claimed %+v, %v, %v", c, ok, err)
+	}
+	if err := s.Extend(ctx, second, "node-b", time.Hour); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok, err := s.Claim(ctx, "node-a", time.Hour); ok || err != nil {
+		t.Fatalf("expected no task left, got %v, %v", ok, err)
+	}
+	task, err := s.Load(ctx, second)
+	if err != nil || task.Status != asynctask.StatusFailed || task.Error != "lease expired after 2 attempts" {
+		t.Fatalf("unexpected task %+v, %v", task, err)
+	}
+}
+
+// Test workers run queued tasks on a manager and store their outcome
+func TestStore_Work(t *testing.T) {
+	s := openTest(t)
+	asynctask.RegisterRunnable("pgstore.double", func(params map[string]any) (asynctask.Runnable, error) {
+		n, _ := params["n"].(float64)
+		return asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			if n < 0 {
+				return nil, errors.New("negative")
+			}
+			return n * 2, nil
+		}), nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ok, err := s.Enqueue(ctx, asynctask.Spec{Name: "pgstore.double", Params: map[string]any{"n": 21}}, map[string]string{"tenant": "acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing, err := s.Enqueue(ctx, asynctask.Spec{Name: "pgstore.double", Params: map[string]any{"n": -1}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := asynctask.NewManager()
+	done := make(chan error)
+	go func() { done <- s.Work(ctx, tm, WorkerConfig{Concurrency: 2, Poll: 10 * time.Millisecond}) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		finished, err := s.List(ctx, asynctask.TaskQuery{Statuses: []asynctask.Status{asynctask.StatusCompleted, asynctask.StatusFailed}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(finished) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d queued tasks finished", len(finished))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	task, err := s.Load(context.Background(), ok)
+	if err != nil || task.Status != asynctask.StatusCompleted || string(task.Result) != "42" {
+		t.Fatalf("unexpected task %+v, %v", task, err)
+	}
+	task, err = s.Load(context.Background(), failing)
+	if err != nil || task.Status != asynctask.StatusFailed || task.Error == "" {
+		t.Fatalf("unexpected task %+v, %v", task, err)
+	}
+	labeled := 0
+	for _, future := range tm.List() {
+		if future.Labels["tenant"] == "acme" && future.Labels[QueuedLabel] == ok.String() {
+			labeled++
+		}
+	}
+	if labeled != 1 {
+		t.Fatalf("expected the task labeled on the manager, got %d", labeled)
+	}
+}
+
+// Test specs are sealed as JSON strings and opened again, while those
+// stored before sealing still decode
+func TestStore_KeyringSpec(t *testing.T) {
+	keyring, err := asynctask.NewKeyring([]string{"k1"}, [][]byte{make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Store{keyring: keyring}
+
+	spec, _, err := s.encode(asynctask.StoredTask{Spec: &asynctask.Spec{Name: "login", Params: map[string]any{"password": "hunter2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := spec.(string); stored[0] != '"' || strings.Contains(stored, "hunter2") {
+		t.Fatalf("expected a sealed spec, got %s", stored)
+	}
+	for _, stored := range []string{spec.(string), `{"name":"login","params":{"password":"hunter2"}}`} {
+		data, err := s.openSpec([]byte(stored))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "hunter2") {
+			t.Fatalf("unexpected spec %s", data)
+		}
+	}
+	if _, err := (&Store{}).openSpec([]byte(spec.(string))); !errors.Is(err, asynctask.ErrUnsealable) {
+		t.Fatalf("expected ErrUnsealable without a keyring, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package pgstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// QueuedLabel is the label holding the ID of the queued task a manager's
+// task runs.
+const QueuedLabel = "queued"
+
+// releaseTimeout bounds releasing the leases of tasks a stopping worker
+// was running.
+const releaseTimeout = 5 * time.Second
+
+// WorkerConfig configures Store.Work. Zero fields take their defaults.
+type WorkerConfig struct {
+	Owner       string        // names the node's leases, host name and process ID by default
+	Concurrency int           // tasks run at once, 1 by default
+	Lease       time.Duration // how long a claimed task is leased, renewed a third of it later; 30s by default
+	Poll        time.Duration // how long to wait for a task once the queue is empty, 1s by default
+	Logger      *slog.Logger  // slog.Default() by default
+}
+
+// Work claims tasks queued with Enqueue and runs them on tm until ctx is
+// done, Concurrency at once. Each task is built from its spec, submitted
+// labeled with QueuedLabel, and its lease renewed while it runs, so a
+// node going away leaves its tasks to others once their leases expire.
+// Its outcome is stored with the result encoded by tm's codec. A task
+// whose lease is lost meanwhile, or not renewed before it may expire, is
+// canceled, as another node may be running it. Once ctx is done, Work cancels the tasks it's running,
+// releases their leases for other nodes to claim right away and returns
+// ctx's error.
+func (s *Store) Work(ctx context.Context, tm *asynctask.Manager, cfg WorkerConfig) error {
+	cfg = cfg.withDefaults()
+	codec, err := asynctask.LookupCodec(tm.Config().Codec)
+	if err != nil {
+		return fmt.Errorf("pgstore: %w", err)
+	}
+
+	slots := make(chan struct{}, cfg.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		task, ok, err := s.Claim(ctx, cfg.Owner, cfg.Lease)
+		if !ok {
+			<-slots
+			if err != nil && ctx.Err() == nil {
+				cfg.Logger.Warn("Queue Claim Failed", slog.Any("error", err))
+			}
+			select {
+			case <-time.After(cfg.Poll):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.run(ctx, tm, codec, cfg, task)
+		}()
+	}
+}
+
+// run runs a claimed task on tm and stores its outcome.
+func (s *Store) run(ctx context.Context, tm *asynctask.Manager, codec asynctask.Codec, cfg WorkerConfig, task asynctask.StoredTask) {
+	logger := cfg.Logger.With(slog.String("id", task.ID.String()))
+	if task.Spec == nil {
+		s.complete(logger, task.ID, cfg.Owner, asynctask.StoredTask{Status: asynctask.StatusFailed, Error: "queued task has no spec"})
+		return
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	labels := map[string]string{QueuedLabel: task.ID.String()}
+	for key, value := range task.Labels {
+		labels[key] = value
+	}
+	id, err := tm.Submit(asynctask.WithLabels(runCtx, labels), *task.Spec)
+	if err != nil {
+		s.complete(logger, task.ID, cfg.Owner, asynctask.StoredTask{Status: asynctask.StatusFailed, Error: err.Error()})
+		return
+	}
+
+	// Renew the lease until the task finishes, canceling it once lost, or
+	// once it may have expired without a renewal, before another worker
+	// claims the task while it's still running here
+	var lost bool
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		valid := cfg.Lease - cfg.Lease/3
+		last := time.Now()
+		expire := time.NewTimer(valid)
+		defer expire.Stop()
+		ticker := time.NewTicker(cfg.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-expire.C:
+				logger.Warn("Queued Task Lease Expired", slog.Duration("since", time.Since(last)))
+				lost = true
+				cancel()
+				return
+			case <-ticker.C:
+				// The lease is extended from some time after the attempt starts
+				attempt := time.Now()
+				extendCtx, cancelExtend := context.WithTimeout(runCtx, valid-attempt.Sub(last))
+				err := s.Extend(extendCtx, task.ID, cfg.Owner, cfg.Lease)
+				cancelExtend()
+				switch {
+				case err == nil:
+					last = attempt
+					expire.Reset(valid - time.Since(last))
+				case errors.Is(err, ErrLeaseLost):
+					logger.Warn("Queued Task Lease Lost")
+					lost = true
+					cancel()
+					return
+				case runCtx.Err() == nil:
+					logger.Warn("Queued Task Lease Not Renewed", slog.Any("error", err))
+				}
+			}
+		}
+	}()
+
+	future, err := tm.Await(runCtx, id)
+	cancel()
+	<-renewed
+
+	switch {
+	case lost:
+		return
+	case err != nil && ctx.Err() != nil:
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+		defer cancel()
+		if err := s.Release(releaseCtx, task.ID, cfg.Owner); err != nil {
+			logger.Warn("Queued Task Not Released", slog.Any("error", err))
+		}
+		return
+	}
+
+	outcome := asynctask.StoredTask{Status: asynctask.StatusCompleted}
+	if status, _ := tm.Status(id); status == asynctask.StatusFailed || status == asynctask.StatusCanceled {
+		outcome.Status = status
+	}
+	switch {
+	case err != nil:
+		if outcome.Status == asynctask.StatusCompleted {
+			outcome.Status = asynctask.StatusFailed
+		}
+		outcome.Error = err.Error()
+	case future.Result != nil:
+		if outcome.Result, err = codec.Marshal(future.Result); err != nil {
+			outcome.Status, outcome.Error = asynctask.StatusFailed, fmt.Sprintf("encoding result: %v", err)
+		} else {
+			outcome.Codec = codec.Name()
+		}
+	}
+	s.complete(logger, task.ID, cfg.Owner, outcome)
+}
+
+// complete stores the outcome of a task. Failing to is only logged: the
+// lease expires and the task is claimed again.
+func (s *Store) complete(logger *slog.Logger, id asynctask.ID, owner string, outcome asynctask.StoredTask) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	if err := s.Complete(ctx, id, owner, outcome); err != nil {
+		logger.Warn("Queued Task Outcome Not Stored", slog.Any("error", err))
+	}
+}
+
+func (cfg WorkerConfig) withDefaults() WorkerConfig {
+	if cfg.Owner == "" {
+		host, _ := os.Hostname()
+		cfg.Owner = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = 30 * time.Second
+	}
+	if cfg.Poll <= 0 {
+		cfg.Poll = time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return cfg
+}
@@ -0,0 +1,395 @@
+package asynctask
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// errPHPSyntax is returned for malformed serialize() output.
+var errPHPSyntax = errors.New("malformed PHP serialized value")
+
+// phpMaxDepth limits how deep arrays and objects nest in unserialized
+// values, so hostile payloads can't exhaust the stack.
+const phpMaxDepth = 512
+
+// phpSerialize writes v to buf in PHP's serialize() format. Values other
+// than scalars, slices and maps with string or integer keys are written
+// through their JSON form.
+func phpSerialize(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("N;")
+	case bool:
+		if v {
+			buf.WriteString("b:1;")
+		} else {
+			buf.WriteString("b:0;")
+		}
+	case string:
+		phpString(buf, v)
+	case []byte:
+		phpString(buf, string(v))
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			fmt.Fprintf(buf, "i:%d;", n)
+		} else if f, err := v.Float64(); err == nil {
+			phpFloat(buf, f)
+		} else {
+			return err
+		}
+	case json.Marshaler:
+		return phpSerializeJSON(buf, v)
+	default:
+		return phpSerializeValue(buf, reflect.ValueOf(v))
+	}
+	return nil
+}
+
+func phpSerializeValue(buf *bytes.Buffer, rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprintf(buf, "i:%d;", rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := rv.Uint(); n <= math.MaxInt64 {
+			fmt.Fprintf(buf, "i:%d;", n)
+		} else {
+			phpFloat(buf, float64(n))
+		}
+	case reflect.Float32, reflect.Float64:
+		phpFloat(buf, rv.Float())
+	case reflect.Bool:
+		return phpSerialize(buf, rv.Bool())
+	case reflect.String:
+		phpString(buf, rv.String())
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			buf.WriteString("N;")
+			return nil
+		}
+		return phpSerialize(buf, rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			buf.WriteString("N;")
+			return nil
+		}
+		fmt.Fprintf(buf, "a:%d:{", rv.Len())
+		for i := range rv.Len() {
+			fmt.Fprintf(buf, "i:%d;", i)
+			if err := phpSerialize(buf, rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case reflect.Map:
+		if rv.IsNil() {
+			buf.WriteString("N;")
+			return nil
+		}
+		keys := rv.MapKeys()
+		switch rv.Type().Key().Kind() {
+		case reflect.String:
+			slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			slices.SortFunc(keys, func(a, b reflect.Value) int { return cmp.Compare(a.Int(), b.Int()) })
+		default:
+			return phpSerializeJSON(buf, rv.Interface())
+		}
+		fmt.Fprintf(buf, "a:%d:{", len(keys))
+		for _, key := range keys {
+			if key.Kind() == reflect.String {
+				phpKey(buf, key.String())
+			} else {
+				fmt.Fprintf(buf, "i:%d;", key.Int())
+			}
+			if err := phpSerialize(buf, rv.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return phpSerializeJSON(buf, rv.Interface())
+	}
+	return nil
+}
+
+// phpSerializeJSON writes v as serialize() would write its JSON form
+// decoded in PHP, keeping integers.
+func phpSerializeJSON(buf *bytes.Buffer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var decoded any
+	if err := dec.Decode(&decoded); err != nil {
+		return err
+	}
+	return phpSerialize(buf, decoded)
+}
+
+func phpString(buf *bytes.Buffer, s string) {
+	fmt.Fprintf(buf, `s:%d:"`, len(s))
+	buf.WriteString(s)
+	buf.WriteString(`";`)
+}
+
+// phpKey writes an array key. Keys that are decimal integers are written
+// as integers, as PHP arrays store them.
+func phpKey(buf *bytes.Buffer, key string) {
+	if n, err := strconv.ParseInt(key, 10, 64); err == nil && strconv.FormatInt(n, 10) == key {
+		fmt.Fprintf(buf, "i:%d;", n)
+		return
+	}
+	phpString(buf, key)
+}
+
+func phpFloat(buf *bytes.Buffer, f float64) {
+	switch {
+	case math.IsNaN(f):
+		buf.WriteString("d:NAN;")
+	case math.IsInf(f, 1):
+		buf.WriteString("d:INF;")
+	case math.IsInf(f, -1):
+		buf.WriteString("d:-INF;")
+	default:
+		buf.WriteString("d:" + strconv.FormatFloat(f, 'g', -1, 64) + ";")
+	}
+}
+
+// phpUnserialize decodes a value written by PHP's serialize(). Integers
+// become int64, arrays with the keys 0 to n-1 in order slices and other
+// arrays maps; objects become maps of their properties.
+func phpUnserialize(data []byte) (any, error) {
+	p := phpParser{data: data}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.data) {
+		return nil, p.fail("trailing data")
+	}
+	return v, nil
+}
+
+type phpParser struct {
+	data  []byte
+	pos   int
+	depth int // arrays and objects being read
+}
+
+func (p *phpParser) fail(what string) error {
+	return fmt.Errorf("%w: %s at offset %d", errPHPSyntax, what, p.pos)
+}
+
+// until returns the text up to the next delim and moves past it.
+func (p *phpParser) until(delim byte) (string, error) {
+	i := bytes.IndexByte(p.data[p.pos:], delim)
+	if i < 0 {
+		return "", p.fail(fmt.Sprintf("missing %q", delim))
+	}
+	s := string(p.data[p.pos : p.pos+i])
+	p.pos += i + 1
+	return s, nil
+}
+
+func (p *phpParser) expect(s string) error {
+	if !bytes.HasPrefix(p.data[p.pos:], []byte(s)) {
+		return p.fail(fmt.Sprintf("expected %q", s))
+	}
+	p.pos += len(s)
+	return nil
+}
+
+func (p *phpParser) length(delim byte) (int, error) {
+	s, err := p.until(delim)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, p.fail("invalid length")
+	}
+	return n, nil
+}
+
+// text reads a quoted string of n bytes.
+func (p *phpParser) text(n int) (string, error) {
+	if err := p.expect(`"`); err != nil {
+		return "", err
+	}
+	if n > len(p.data)-p.pos {
+		return "", p.fail("string past the end")
+	}
+	s := string(p.data[p.pos : p.pos+n])
+	p.pos += n
+	return s, p.expect(`"`)
+}
+
+func (p *phpParser) value() (any, error) {
+	if p.pos+1 >= len(p.data) {
+		return nil, p.fail("unexpected end")
+	}
+	kind := p.data[p.pos]
+	if kind == 'N' {
+		p.pos++
+		return nil, p.expect(";")
+	}
+	p.pos++
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case 'b':
+		s, err := p.until(';')
+		if err != nil {
+			return nil, err
+		}
+		return s == "1", nil
+	case 'i':
+		s, err := p.until(';')
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, p.fail("invalid integer")
+		}
+		return n, nil
+	case 'd':
+		s, err := p.until(';')
+		if err != nil {
+			return nil, err
+		}
+		switch s {
+		case "NAN":
+			return math.NaN(), nil
+		case "INF":
+			return math.Inf(1), nil
+		case "-INF":
+			return math.Inf(-1), nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, p.fail("invalid float")
+		}
+		return f, nil
+	case 's':
+		n, err := p.length(':')
+		if err != nil {
+			return nil, err
+		}
+		s, err := p.text(n)
+		if err != nil {
+			return nil, err
+		}
+		return s, p.expect(";")
+	case 'a':
+		return p.array()
+	case 'O':
+		n, err := p.length(':')
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.text(n); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		props, err := p.array()
+		if err != nil {
+			return nil, err
+		}
+		return phpProperties(props), nil
+	}
+	return nil, p.fail(fmt.Sprintf("unsupported type %q", kind))
+}
+
+// array reads the element count and elements of an array or object.
+func (p *phpParser) array() (any, error) {
+	n, err := p.length(':')
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if p.depth++; p.depth > phpMaxDepth {
+		return nil, p.fail("nested too deep")
+	}
+	defer func() { p.depth-- }()
+
+	// Every element takes a few bytes, so counts beyond what's left are
+	// caught as the input runs out, without allocating for them first
+	capacity := min(n, len(p.data)-p.pos)
+	list := make([]any, 0, capacity)
+	var assoc map[string]any
+	for i := range n {
+		key, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+
+		// Stay a list while the keys count up from zero
+		if assoc == nil {
+			if index, ok := key.(int64); ok && index == int64(i) {
+				list = append(list, value)
+				continue
+			}
+			assoc = make(map[string]any, capacity)
+			for j, v := range list {
+				assoc[strconv.Itoa(j)] = v
+			}
+		}
+		switch key := key.(type) {
+		case int64:
+			assoc[strconv.FormatInt(key, 10)] = value
+		case string:
+			assoc[key] = value
+		default:
+			return nil, p.fail("invalid array key")
+		}
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+
+	if assoc != nil {
+		return assoc, nil
+	}
+	return list, nil
+}
+
+// phpProperties returns the properties of an object by name, without the
+// markers serialize() puts before protected and private ones.
+func phpProperties(props any) map[string]any {
+	out := map[string]any{}
+	switch props := props.(type) {
+	case []any:
+		for i, v := range props {
+			out[strconv.Itoa(i)] = v
+		}
+	case map[string]any:
+		for name, v := range props {
+			if i := strings.LastIndexByte(name, 0); i >= 0 {
+				name = name[i+1:]
+			}
+			out[name] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,204 @@
+package asynctask
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// RunnablePolicy stacks the wrappers of a runnable in a fixed order,
+	// whatever order they're set in, so they compose the same way every
+	// time. From the outside in: WithLogging, WithMetrics, WithTimeout
+	// over all attempts, WithRetry, WithCircuitBreaker and WithRateLimit
+	// per attempt, and WithRecover closest to the runnable. Its methods
+	// return a copy, so a policy can be shared and extended. The zero
+	// policy applies nothing.
+	RunnablePolicy struct {
+		logging    bool
+		logger     *slog.Logger
+		attrs      []any
+		metrics    string
+		timeout    time.Duration
+		retries    int
+		backoff    time.Duration
+		breaker    *CircuitBreaker
+		limiter    RateLimiter
+		recovering func(recovered any) error
+	}
+
+	// RateLimiter hands out the attempts WithRateLimit lets through, such
+	// as a *rate.Limiter of golang.org/x/time/rate. Wait blocks until an
+	// attempt may start, failing when ctx is done first or can't wait long
+	// enough.
+	RateLimiter interface {
+		Wait(ctx context.Context) error
+	}
+
+	// CircuitBreaker fails attempts fast with ErrCircuitOpen after a run
+	// of consecutive failures, until a cooldown has passed. Then a single
+	// attempt probes whether the dependency recovered: its success closes
+	// the circuit, its failure opens it for another cooldown. Canceled
+	// attempts don't count. It's safe for concurrent use.
+	CircuitBreaker struct {
+		threshold int
+		cooldown  time.Duration
+
+		mu       sync.Mutex
+		failures int
+		openedAt time.Time // zero while closed
+		probing  bool
+	}
+)
+
+// Policy returns an empty policy to build a wrapper stack with.
+func Policy() RunnablePolicy {
+	return RunnablePolicy{}
+}
+
+// Logging logs attempts with WithLogging.
+func (p RunnablePolicy) Logging(logger *slog.Logger, attrs ...any) RunnablePolicy {
+	p.logging, p.logger, p.attrs = true, logger, slices.Clone(attrs)
+	return p
+}
+
+// Metrics records the operation as name with WithMetrics.
+func (p RunnablePolicy) Metrics(name string) RunnablePolicy {
+	p.metrics = name
+	return p
+}
+
+// Timeout bounds the runnable, retries included, with WithTimeout.
+func (p RunnablePolicy) Timeout(timeout time.Duration) RunnablePolicy {
+	p.timeout = timeout
+	return p
+}
+
+// Retry retries failed attempts with WithRetry.
+func (p RunnablePolicy) Retry(retries int, backoff time.Duration) RunnablePolicy {
+	p.retries, p.backoff = retries, backoff
+	return p
+}
+
+// CircuitBreak guards every attempt with cb.
+func (p RunnablePolicy) CircuitBreak(cb *CircuitBreaker) RunnablePolicy {
+	p.breaker = cb
+	return p
+}
+
+// RateLimit lets every attempt wait for l.
+func (p RunnablePolicy) RateLimit(l RateLimiter) RunnablePolicy {
+	p.limiter = l
+	return p
+}
+
+// Recover maps the runnable's panics to errors with WithRecover.
+func (p RunnablePolicy) Recover(mapping func(recovered any) error) RunnablePolicy {
+	p.recovering = mapping
+	return p
+}
+
+// Apply wraps runnable in the wrappers of the policy.
+func (p RunnablePolicy) Apply(runnable Runnable) Runnable {
+	if p.recovering != nil {
+		runnable = WithRecover(runnable, p.recovering)
+	}
+	if p.limiter != nil {
+		runnable = WithRateLimit(runnable, p.limiter)
+	}
+	if p.breaker != nil {
+		runnable = WithCircuitBreaker(runnable, p.breaker)
+	}
+	if p.retries > 0 {
+		runnable = WithRetry(runnable, p.retries, p.backoff)
+	}
+	if p.timeout > 0 {
+		runnable = WithTimeout(runnable, p.timeout)
+	}
+	if p.metrics != "" {
+		runnable = WithMetrics(runnable, p.metrics)
+	}
+	if p.logging {
+		runnable = WithLogging(runnable, p.logger, p.attrs...)
+	}
+	return runnable
+}
+
+// WithRateLimit wraps a runnable to wait for l before it runs. Fails with
+// the error of l when it can't wait.
+func WithRateLimit(runnable Runnable, l RateLimiter) Runnable {
+	return RunnableFunc(func(ctx context.Context) (any, error) {
+		if err := l.Wait(ctx); err != nil {
+			return nil, err
+		}
+		return runnable.Run(ctx)
+	})
+}
+
+// NewCircuitBreaker returns a circuit breaker opening after threshold
+// consecutive failures, for cooldown. A threshold below 1 is taken as 1.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: max(threshold, 1), cooldown: cooldown}
+}
+
+// WithCircuitBreaker wraps a runnable with cb, failing it with
+// ErrCircuitOpen without running it while the circuit is open. The
+// cooldown is timed by the clock from ClockFromContext.
+func WithCircuitBreaker(runnable Runnable, cb *CircuitBreaker) Runnable {
+	return RunnableFunc(func(ctx context.Context) (any, error) {
+		clock := ClockFromContext(ctx)
+		if !cb.allow(clock.Now()) {
+			return nil, ErrCircuitOpen
+		}
+		result, err := runnable.Run(ctx)
+		cb.record(clock.Now(), err)
+		return result, err
+	})
+}
+
+// Open reports whether the circuit is open, failing attempts fast.
+func (cb *CircuitBreaker) Open() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return !cb.openedAt.IsZero()
+}
+
+// allow reports whether an attempt may run at now, letting one probe
+// through once the cooldown has passed.
+func (cb *CircuitBreaker) allow(now time.Time) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.openedAt.IsZero() {
+		return true
+	}
+	if cb.probing || now.Sub(cb.openedAt) < cb.cooldown {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+// record counts the outcome of an attempt finished at now.
+func (cb *CircuitBreaker) record(now time.Time, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	probe := cb.probing
+	cb.probing = false
+
+	switch outcome(err) {
+	case OutcomeSuccess:
+		cb.failures = 0
+		cb.openedAt = time.Time{}
+	case OutcomeCanceled:
+		// Tells nothing about the dependency
+	default:
+		cb.failures++
+		if probe || cb.failures >= cb.threshold {
+			cb.openedAt = now
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package asynctask
+
+import (
+	"context"
+	"fmt"
+)
+
+// PoolLabel is the label naming the worker pool a task runs in. Tasks
+// without it run in the default pool sized by WithWorkerLimit.
+const PoolLabel = "pool"
+
+// PoolStats holds the utilization of a named worker pool.
+type PoolStats struct {
+	Workers     int `json:"workers"`
+	WorkerLimit int `json:"worker_limit"`
+	Waiting     int `json:"waiting"`
+}
+
+// pool returns the worker pool named by the PoolLabel of ctx. Returns
+// ErrPoolNotFound for names not configured with WithPool.
+func (tm *Manager) pool(ctx context.Context) (*semaphore, error) {
+	name := LabelsFromContext(ctx)[PoolLabel]
+	if name == "" {
+		return tm.workers, nil
+	}
+	if pool, ok := tm.pools[name]; ok {
+		return pool, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrPoolNotFound, name)
+}
+
+// busy returns the worker slots in use across all pools.
+func (tm *Manager) busy() int {
+	_, used, _, _ := tm.workers.state()
+	for _, pool := range tm.pools {
+		_, n, _, _ := pool.state()
+		used += n
+	}
+	return used
+}
@@ -0,0 +1,80 @@
+package asynctask
+
+import "sync"
+
+// warmPool keeps goroutines that run tasks one after another, so a task
+// submitted while one of them is idle doesn't start a goroutine of its own.
+type warmPool struct {
+	work chan func()
+	stop chan struct{}
+	once sync.Once
+}
+
+// WithPrewarm readies the manager for its first n tasks up front: their
+// records are allocated in one go, the task table is sized for them, and n
+// long-lived goroutines wait to run tasks until Shutdown. A task submitted
+// while none of them is idle gets a goroutine of its own as usual, and the
+// worker limit applies either way. Meant for managers that live long and
+// start many tasks, as records allocated together are only freed together.
+// Zero, the default, prewarms nothing.
+func WithPrewarm(n int) Option {
+	return func(m *Manager) {
+		if n < 0 {
+			m.invalidOption("prewarm %d, must not be negative", n)
+			return
+		}
+		m.prewarm = n
+	}
+}
+
+// prewarmTable allocates the records of the first n tasks and sizes the
+// shards of the task table for them.
+func (tm *Manager) prewarmTable(n int) {
+	tm.spare = make([]taskRecord, n)
+	for i := range tm.tasks.shards {
+		tm.tasks.shards[i].records = make(map[ID]*taskRecord, n/taskShards+1)
+	}
+}
+
+// allocRecord returns a preallocated record while any is left, else a
+// new one.
+func (tm *Manager) allocRecord() *taskRecord {
+	if i := int(tm.spareNext.Add(1)) - 1; i < len(tm.spare) {
+		return &tm.spare[i]
+	}
+	return new(taskRecord)
+}
+
+func newWarmPool(n int) *warmPool {
+	p := &warmPool{work: make(chan func()), stop: make(chan struct{})}
+	for range n {
+		go p.run()
+	}
+	return p
+}
+
+func (p *warmPool) run() {
+	for {
+		select {
+		case fn := <-p.work:
+			fn()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// submit hands fn to an idle goroutine, reporting false when none is.
+func (p *warmPool) submit(fn func()) bool {
+	select {
+	case p.work <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+// close lets the goroutines exit once they've finished their task.
+func (p *warmPool) close() {
+	p.once.Do(func() { close(p.stop) })
+}
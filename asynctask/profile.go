@@ -0,0 +1,48 @@
+package asynctask
+
+import (
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// WithProfiling turns on task profiling. Besides ProfileLabel, task
+// goroutines and those they start carry every label of their task as a
+// pprof label, such as script or runnable, so CPU profiles can be sliced
+// by them (go tool pprof -tagfocus script=report.php). And each task runs
+// locked to its thread, whose CPU time is recorded in Future.CPU and
+// summed in Stats.CPUTotal, on Linux only. CPU time of goroutines the task
+// starts, or of the PHP threads running its scripts, isn't counted.
+func WithProfiling() Option {
+	return func(m *Manager) {
+		m.profiling = true
+	}
+}
+
+// profileLabels returns the pprof labels of the goroutine of rec.
+func (tm *Manager) profileLabels(rec *taskRecord) pprof.LabelSet {
+	labels := []string{ProfileLabel, rec.id.String()}
+	if tm.profiling {
+		for key, value := range rec.labels {
+			if key != ProfileLabel {
+				labels = append(labels, key, value)
+			}
+		}
+	}
+	return pprof.Labels(labels...)
+}
+
+// measureCPU runs fn locked to its thread, returning the CPU time the
+// thread used, or 0 where it can't be read.
+func measureCPU(fn func()) time.Duration {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	start, ok := threadCPU()
+	fn()
+	end, _ := threadCPU()
+	if !ok {
+		return 0
+	}
+	return max(end-start, 0)
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package asynctask
+
+import (
+	"syscall"
+	"time"
+)
+
+// rusageThread is RUSAGE_THREAD, missing from package syscall.
+const rusageThread = 1
+
+// threadCPU returns the user and system CPU time of the calling thread.
+func threadCPU() (time.Duration, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(rusageThread, &ru); err != nil {
+		return 0, false
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package asynctask
+
+import "time"
+
+// threadCPU can't read the CPU time of a thread here.
+func threadCPU() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,92 @@
+package asynctask
+
+import (
+	"context"
+	"reflect"
+	"slices"
+)
+
+// propagatedContext is the context of a task of a manager with
+// WithContextPropagation. It's canceled with the context the task was
+// submitted with, but only carries its values of the allow-listed keys and
+// the manager's own.
+type propagatedContext struct {
+	context.Context
+	keys []any
+}
+
+// WithContextPropagation limits the values task contexts carry from the
+// context they were submitted with to those of keys, such as the keys of a
+// user ID, locale or trace, besides the manager's own: the manager, labels,
+// logger, clock and request binding. Tasks outliving their request, bound
+// with Bind or detached by the shutdown policy, then don't hold on to what
+// else the request put in its context, and tasks get the same values
+// however they run: right away, queued for a pool executor, promoted from
+// Defer or retried. Task contexts are still canceled with the context they
+// were submitted with. Keys must be comparable, as context keys are.
+func WithContextPropagation(keys ...any) Option {
+	return func(m *Manager) {
+		for _, key := range keys {
+			if key == nil || !reflect.TypeOf(key).Comparable() {
+				m.invalidOption("context key %T, must be comparable", key)
+				return
+			}
+		}
+		m.propagate = append(make([]any, 0, len(keys)), keys...)
+	}
+}
+
+// propagated returns the context a task submitted with ctx runs in: ctx,
+// unless the manager propagates only some of its values.
+func (tm *Manager) propagated(ctx context.Context) context.Context {
+	if tm.propagate == nil {
+		return ctx
+	}
+	if p, ok := ctx.(propagatedContext); ok && slices.Equal(p.keys, tm.propagate) {
+		return ctx
+	}
+	return propagatedContext{Context: ctx, keys: tm.propagate}
+}
+
+// Value returns the value of key in the submitting context if it's
+// allow-listed or the manager's, nil otherwise.
+func (c propagatedContext) Value(key any) any {
+	switch key.(type) {
+	case ctxKey, labelsKey, loggerKey, clockKey, taskIDKey, requestKey, attemptsKey, minDurationKey, unboundedKey:
+		return c.Context.Value(key)
+	}
+	if slices.Contains(c.keys, key) {
+		return c.Context.Value(key)
+	}
+	return nil
+}
+
+// AfterFunc lets contexts derived from c be canceled with it without a
+// goroutine each, see context.AfterFunc.
+func (c propagatedContext) AfterFunc(f func()) func() bool {
+	return context.AfterFunc(c.Context, f)
+}
+
+// parentedContext is the context of a task of a manager with
+// WithParentContext, before it's canceled with the parent too. It carries
+// the values of the context the task was submitted with, and those of the
+// parent for the keys it has none of.
+type parentedContext struct {
+	context.Context
+	parent context.Context
+}
+
+// Value returns the value of key in the submitting context, or the parent
+// if it has none.
+func (c parentedContext) Value(key any) any {
+	if value := c.Context.Value(key); value != nil {
+		return value
+	}
+	return c.parent.Value(key)
+}
+
+// AfterFunc lets contexts derived from c be canceled with it without a
+// goroutine each, see context.AfterFunc.
+func (c parentedContext) AfterFunc(f func()) func() bool {
+	return context.AfterFunc(c.Context, f)
+}
@@ -0,0 +1,144 @@
+package asynctask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// RunnableLabel is the label holding the name of the registered runnable a
+// task was submitted as.
+const RunnableLabel = "runnable"
+
+var ErrRunnableNotRegistered = errors.New("runnable not registered")
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+type (
+	// Factory builds the runnable of a task from its params.
+	Factory func(params map[string]any) (Runnable, error)
+
+	// Spec describes a task by the name of a registered runnable and its
+	// params. Unlike a closure it can be serialized, to persist, replay or
+	// submit tasks from elsewhere.
+	Spec struct {
+		Name   string         `json:"name"`
+		Params map[string]any `json:"params,omitempty"`
+	}
+
+	// specRunnable is a runnable built from a spec, kept so the spec of a
+	// task can be looked up.
+	specRunnable struct {
+		Runnable
+		spec Spec
+	}
+)
+
+// RegisterRunnable makes factory available under name, typically from an
+// init function. It panics if name is empty or already registered, or if
+// factory is nil.
+func RegisterRunnable(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if name == "" {
+		panic("asynctask: RegisterRunnable with empty name")
+	}
+	if factory == nil {
+		panic("asynctask: RegisterRunnable factory is nil for " + name)
+	}
+	if _, dup := factories[name]; dup {
+		panic("asynctask: RegisterRunnable called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Runnables returns the sorted names of the registered runnables.
+func Runnables() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	return slices.Sorted(maps.Keys(factories))
+}
+
+// Runnable builds the runnable described by s. Returns
+// ErrRunnableNotRegistered for unknown names, and the factory's error for
+// invalid params.
+func (s Spec) Runnable() (Runnable, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[s.Name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrRunnableNotRegistered, s.Name)
+	}
+
+	runnable, err := factory(s.Params)
+	if err != nil {
+		return nil, fmt.Errorf("runnable %q: %w", s.Name, err)
+	}
+	return &specRunnable{Runnable: runnable, spec: s}, nil
+}
+
+// Submit starts the task described by spec, like Async, labeled with the
+// runnable's name.
+func (tm *Manager) Submit(ctx context.Context, spec Spec) (ID, error) {
+	runnable, err := spec.Runnable()
+	if err != nil {
+		return ID{}, err
+	}
+	ctx = WithLabels(ctx, map[string]string{RunnableLabel: spec.Name})
+	return tm.Async(ctx, runnable), nil
+}
+
+// Replay starts a new task from the spec of the finished task taskID, with
+// its original context and labels, and returns the new task's ID. Unlike
+// Retry, the runnable is built again from the spec and completed tasks can
+// be replayed too. Returns ErrTaskNotReplayable if the task hasn't finished
+// or wasn't built from a spec.
+func (tm *Manager) Replay(taskID ID) (ID, error) {
+	status, err := tm.Status(taskID)
+	if err != nil {
+		return ID{}, err
+	}
+	if status != StatusCompleted && status != StatusFailed {
+		return ID{}, fmt.Errorf("%w: task is %s", ErrTaskNotReplayable, status)
+	}
+
+	rec, ok := tm.tasks.load(taskID)
+	if !ok {
+		return ID{}, ErrTaskNotFound
+	}
+	sr, ok := rec.runnable.(*specRunnable)
+	if !ok {
+		return ID{}, fmt.Errorf("%w: task has no spec", ErrTaskNotReplayable)
+	}
+
+	runnable, err := sr.spec.Runnable()
+	if err != nil {
+		return ID{}, err
+	}
+
+	newID := tm.Async(rec.ctx, runnable)
+	tm.logger.Debug("Future Replayed", slog.String("id", taskID.String()), slog.String("replay", newID.String()))
+
+	return newID, nil
+}
+
+// Spec returns the spec a task was submitted with. Returns
+// ErrTaskNotFound if the task is unknown or wasn't built from a spec.
+func (tm *Manager) Spec(taskID ID) (Spec, error) {
+	rec, ok := tm.tasks.load(taskID)
+	if !ok {
+		return Spec{}, ErrTaskNotFound
+	}
+	if sr, ok := rec.runnable.(*specRunnable); ok {
+		return sr.spec, nil
+	}
+	return Spec{}, ErrTaskNotFound
+}
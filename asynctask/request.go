@@ -0,0 +1,90 @@
+package asynctask
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// SideEffectsLabel marks tasks started with WithSideEffects.
+const SideEffectsLabel = "side_effects"
+
+// WithSideEffects returns a derived context for tasks with side effects,
+// such as writes or sending email, which must not stop halfway when the
+// client goes away. Their tasks keep running when ctx is canceled, and
+// the close function of Bind waits for them after a disconnect. Shutting
+// the manager down still cancels them.
+func WithSideEffects(ctx context.Context) context.Context {
+	return WithLabels(context.WithoutCancel(ctx), map[string]string{SideEffectsLabel: "true"})
+}
+
+// errDisconnected cancels the tasks of a bound manager once its client
+// disconnected, see Bind.
+var errDisconnected = fmt.Errorf("%w: client disconnected", ErrTaskCanceled)
+
+// Bind ties the manager to a request whose context is ctx, returning the
+// context to start the request's tasks with and the function closing the
+// manager once the request is done.
+//
+// Unlike ctx, the returned context isn't canceled when the request
+// returns, so tasks can outlive it as the shutdown policy allows. When the
+// client disconnects first, it is canceled grace later, right away for 0,
+// unless the policy is ShutdownDetach. Either way it's canceled with a
+// cause matching ErrTaskCanceled, which bound runnables such as those of
+// WithRequestDeadline fail with.
+//
+// The close function calls Close, in the background with ShutdownDetach.
+// After a disconnect it first waits for the tasks started with
+// WithSideEffects, up to the timeout of the shutdown policy. Once the
+// manager is closed, it calls onClose unless nil.
+func (tm *Manager) Bind(ctx context.Context, grace time.Duration, onClose func()) (context.Context, func()) {
+	base, cancelCause := context.WithCancelCause(withRequest(context.WithoutCancel(ctx), ctx))
+	cancel := func() { cancelCause(errDisconnected) }
+
+	var (
+		disconnected atomic.Bool
+		timer        atomic.Pointer[Timer]
+	)
+	stop := context.AfterFunc(ctx, func() {
+		if policy, _ := tm.ShutdownPolicy(); policy == ShutdownDetach {
+			return
+		}
+		disconnected.Store(true)
+		if grace <= 0 {
+			cancel()
+			return
+		}
+		t := tm.clock.AfterFunc(grace, cancel)
+		timer.Store(&t)
+	})
+
+	finish := func() {
+		if disconnected.Load() {
+			waitCtx := context.WithoutCancel(ctx)
+			if _, timeout := tm.ShutdownPolicy(); timeout > 0 {
+				var cancelWait context.CancelFunc
+				waitCtx, cancelWait = withTimeout(waitCtx, tm.clock, timeout)
+				defer cancelWait()
+			}
+			tm.Wait(waitCtx, map[string]string{SideEffectsLabel: "true"})
+		}
+		tm.Close(context.WithoutCancel(ctx))
+		if onClose != nil {
+			onClose()
+		}
+		if t := timer.Load(); t != nil {
+			(*t).Stop()
+		}
+		cancelCause(errRequestDone)
+	}
+
+	return base, func() {
+		stop()
+		if policy, _ := tm.ShutdownPolicy(); policy == ShutdownDetach {
+			go finish()
+			return
+		}
+		finish()
+	}
+}
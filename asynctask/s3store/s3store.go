@@ -0,0 +1,297 @@
+// Package s3store keeps offloaded task results in S3-compatible object
+// storage, such as AWS S3, MinIO or Cloudflare R2. See
+// asynctask.WithResultOffload.
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+const (
+	// expiresHeader holds the time an object expires, in RFC 3339.
+	expiresHeader = "X-Amz-Meta-Expires-At"
+
+	// emptyHash is the SHA-256 of an empty payload.
+	emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// unsignedPayload stands in for the hash of uploads, so results
+	// of gigabytes aren't hashed before they're sent.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	timeFormat = "20060102T150405Z"
+)
+
+type (
+	// Config locates the bucket results are stored in and the credentials
+	// to access it with.
+	Config struct {
+		Endpoint     string // e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000
+		Region       string // us-east-1 when empty
+		Bucket       string
+		Prefix       string // prepended to the keys of objects, e.g. "results/"
+		AccessKey    string
+		SecretKey    string
+		SessionToken string // of temporary credentials, if any
+
+		// Address the bucket in the path (endpoint/bucket/key) rather
+		// than the host (bucket.endpoint/key), as MinIO needs
+		PathStyle bool
+
+		// Seal results before uploading them and open them as they're
+		// downloaded, as they may hold personal data and credentials
+		Keyring *asynctask.Keyring
+
+		Client *http.Client // http.DefaultClient when nil
+	}
+
+	// Store is an asynctask.ResultStore keeping results as objects of a
+	// bucket. Objects carry the time they expire, after which Get reports
+	// them missing and deletes them. Those never read again are left to
+	// the bucket: give it a lifecycle rule expiring objects under Prefix
+	// a day after the longest TTL.
+	Store struct {
+		cfg      Config
+		endpoint *url.URL
+		now      func() time.Time
+	}
+)
+
+// New returns a store for the bucket cfg names.
+func New(cfg Config) (*Store, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3store: endpoint must be an http:// or https:// URL, got %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3store: bucket must not be empty")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &Store{cfg: cfg, endpoint: endpoint, now: time.Now}, nil
+}
+
+// Put uploads data as the object of key, expiring ttl later.
+func (s *Store) Put(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	if s.cfg.Keyring != nil {
+		var err error
+		if data, err = s.cfg.Keyring.Seal(data); err != nil {
+			return fmt.Errorf("s3store: sealing %s: %w", key, err)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	if ttl > 0 {
+		expires := s.now().Add(ttl).UTC()
+		header.Set(expiresHeader, expires.Format(time.RFC3339))
+		header.Set("Expires", expires.Format(http.TimeFormat))
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, header, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, key)
+	}
+	return nil
+}
+
+// Get downloads the object of key. Expired objects are deleted and
+// reported missing.
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, key)
+	}
+
+	if v := resp.Header.Get(expiresHeader); v != "" {
+		if expires, err := time.Parse(time.RFC3339, v); err == nil && !s.now().Before(expires) {
+			_ = s.Delete(ctx, key)
+			return nil, fmt.Errorf("s3store: %w: %s expired", asynctask.ErrResultNotFound, key)
+		}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil || s.cfg.Keyring == nil {
+		return data, err
+	}
+	if data, err = s.cfg.Keyring.Open(data); err != nil {
+		return nil, fmt.Errorf("s3store: opening %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// Delete removes the object of key. Missing objects aren't an error.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError(resp, key)
+	}
+	return nil
+}
+
+// do sends a signed request for the object of key.
+func (s *Store) do(ctx context.Context, method, key string, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	objectPath := "/" + s.cfg.Prefix + key
+	if s.cfg.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + objectPath
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	}
+	u.RawPath = uriEncode(u.Path, false)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("s3store: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+
+	payloadHash := emptyHash
+	if method == http.MethodPut {
+		payloadHash = unsignedPayload
+	}
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+	s.sign(req, payloadHash, s.now())
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3store: %w", err)
+	}
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 of req, signing its host, range
+// and x-amz-* headers.
+func (s *Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	date := now.Format(timeFormat)
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := now.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		date,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query sorted by key, URI-encoded.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes s as SigV4 requires: every byte but the unreserved
+// characters, and slashes unless encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// responseError returns the error of an unexpected response for key,
+// wrapping asynctask.ErrResultNotFound for missing objects.
+func responseError(resp *http.Response, key string) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("s3store: %w: %s", asynctask.ErrResultNotFound, key)
+	}
+	return fmt.Errorf("s3store: %s %s: %s", resp.Request.Method, key, strings.TrimSpace(resp.Status+" "+string(body)))
+}
@@ -0,0 +1,185 @@
+package asynctask
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// semaphore is a counting semaphore whose size can change while slots
+	// are held. Shrinking it below the slots in use takes effect as they
+	// are released.
+	//
+	// Submissions queue by key. Free slots go to the keys in turn, each
+	// receiving as many slots per turn as its share (1 by default), and
+	// within a key first come, first served. With a single key that's
+	// plain FIFO.
+	semaphore struct {
+		mu      sync.Mutex
+		size    int
+		used    int
+		waiting int
+		queues  map[string][]*waiter
+		order   []string // keys with queued submissions, in turn order
+		next    int      // index in order of the key whose turn it is
+		served  int      // slots granted to that key this turn
+		shares  map[string]int
+		clock   Clock  // times how long submissions wait
+		workers []bool // worker numbers in use, see take
+	}
+
+	waiter struct {
+		key   string
+		ready chan struct{} // closed once the slot is granted
+		since time.Time
+	}
+)
+
+func newSemaphore(size int) *semaphore {
+	return &semaphore{size: size, queues: make(map[string][]*waiter), clock: systemClock{}}
+}
+
+// tryAcquire takes a slot if one is free and nobody is queued for it.
+func (s *semaphore) tryAcquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used < s.size && s.waiting == 0 {
+		s.used++
+		return true
+	}
+	return false
+}
+
+// enqueue queues for a slot under key, to be waited for with wait.
+func (s *semaphore) enqueue(key string) *waiter {
+	w := &waiter{key: key, ready: make(chan struct{}), since: s.clock.Now()}
+
+	s.mu.Lock()
+	if len(s.queues[key]) == 0 {
+		s.order = append(s.order, key)
+	}
+	s.queues[key] = append(s.queues[key], w)
+	s.waiting++
+	s.grant()
+	s.mu.Unlock()
+
+	return w
+}
+
+func (s *semaphore) wait(ctx context.Context, w *waiter) error {
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queues[w.key]
+	if i := slices.Index(queue, w); i >= 0 {
+		s.waiting--
+		if len(queue) == 1 {
+			s.dropKey(w.key)
+		} else {
+			s.queues[w.key] = slices.Delete(queue, i, i+1)
+		}
+		return ctx.Err()
+	}
+
+	// Granted while ctx was done: hand the slot on
+	s.used--
+	s.grant()
+	return ctx.Err()
+}
+
+// take numbers the worker of an acquired slot, the lowest number not in
+// use, so traces show which tasks shared a worker.
+func (s *semaphore) take() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, busy := range s.workers {
+		if !busy {
+			s.workers[i] = true
+			return i
+		}
+	}
+	s.workers = append(s.workers, true)
+	return len(s.workers) - 1
+}
+
+// release frees the slot of worker.
+func (s *semaphore) release(worker int) {
+	s.mu.Lock()
+	s.workers[worker] = false
+	s.used--
+	s.grant()
+	s.mu.Unlock()
+}
+
+func (s *semaphore) resize(size int) {
+	s.mu.Lock()
+	s.size = size
+	s.grant()
+	s.mu.Unlock()
+}
+
+// setShares sets the slots per turn of keys. Keys without a share get 1.
+func (s *semaphore) setShares(shares map[string]int) {
+	s.mu.Lock()
+	s.shares = shares
+	s.mu.Unlock()
+}
+
+// grant hands free slots to queued submissions. Must be called with mu
+// held.
+func (s *semaphore) grant() {
+	for s.used < s.size && s.waiting > 0 {
+		if s.next >= len(s.order) {
+			s.next = 0
+		}
+		key := s.order[s.next]
+		queue := s.queues[key]
+
+		close(queue[0].ready)
+		s.used++
+		s.waiting--
+		s.served++
+
+		if len(queue) == 1 {
+			s.dropKey(key)
+			continue
+		}
+		s.queues[key] = queue[1:]
+		if s.served >= max(s.shares[key], 1) {
+			s.next++
+			s.served = 0
+		}
+	}
+}
+
+// dropKey removes key, whose queue is empty, from the turn order. Must be
+// called with mu held.
+func (s *semaphore) dropKey(key string) {
+	delete(s.queues, key)
+	i := slices.Index(s.order, key)
+	s.order = slices.Delete(s.order, i, i+1)
+	switch {
+	case i < s.next:
+		s.next--
+	case i == s.next:
+		s.served = 0
+	}
+}
+
+// state returns the size, the slots in use, the number of queued
+// submissions and how long the oldest has waited.
+func (s *semaphore) state() (size, used, waiting int, oldest time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, queue := range s.queues {
+		oldest = max(oldest, s.clock.Now().Sub(queue[0].since))
+	}
+	return s.size, s.used, s.waiting, oldest
+}
@@ -0,0 +1,115 @@
+package asynctask
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ShutdownPolicy is what Close does with the tasks still pending or
+// running, such as those a request started but never awaited.
+type ShutdownPolicy int
+
+const (
+	// ShutdownCancel cancels them right away, as Shutdown does.
+	ShutdownCancel ShutdownPolicy = iota
+	// ShutdownWait waits for them up to the policy's timeout, then
+	// cancels those still running.
+	ShutdownWait
+	// ShutdownDetach leaves them running up to the policy's timeout. Close
+	// waits like ShutdownWait; owners of the manager call it in the
+	// background, so they don't have to wait themselves.
+	ShutdownDetach
+)
+
+// ShutdownPolicies lists the shutdown policies by name.
+var ShutdownPolicies = []string{"cancel", "wait", "detach"}
+
+// ParseShutdownPolicy returns the policy named s.
+func ParseShutdownPolicy(s string) (ShutdownPolicy, error) {
+	for i, name := range ShutdownPolicies {
+		if name == s {
+			return ShutdownPolicy(i), nil
+		}
+	}
+	return ShutdownCancel, fmt.Errorf("unknown shutdown policy %q", s)
+}
+
+// String returns the name of the policy.
+func (p ShutdownPolicy) String() string {
+	if p >= 0 && int(p) < len(ShutdownPolicies) {
+		return ShutdownPolicies[p]
+	}
+	return fmt.Sprintf("ShutdownPolicy(%d)", int(p))
+}
+
+// WithShutdownPolicy sets what Close does with the tasks left, waiting up
+// to timeout for them with ShutdownWait and ShutdownDetach. A zero timeout
+// waits until the context given to Close is done. Defaults to
+// ShutdownCancel.
+func WithShutdownPolicy(policy ShutdownPolicy, timeout time.Duration) Option {
+	return func(m *Manager) {
+		if err := validShutdownPolicy(policy, timeout); err != nil {
+			m.invalidOption("%v", err)
+			return
+		}
+		m.shutdownPolicy = policy
+		m.shutdownTimeout = timeout
+	}
+}
+
+// SetShutdownPolicy changes the shutdown policy until Close is called, as
+// WithShutdownPolicy sets it. Returns ErrInvalidOption for unknown policies
+// and negative timeouts.
+func (tm *Manager) SetShutdownPolicy(policy ShutdownPolicy, timeout time.Duration) error {
+	if err := validShutdownPolicy(policy, timeout); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOption, err)
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.shutdownPolicy = policy
+	tm.shutdownTimeout = timeout
+	return nil
+}
+
+// ShutdownPolicy returns the shutdown policy and its timeout.
+func (tm *Manager) ShutdownPolicy() (ShutdownPolicy, time.Duration) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return tm.shutdownPolicy, tm.shutdownTimeout
+}
+
+// Close ends the manager once its owner, such as the request it served, is
+// done with it. The tasks still pending or running are canceled, or first
+// waited for up to the timeout of the shutdown policy. It then shuts the
+// manager down as Shutdown does, with the report covering both steps.
+func (tm *Manager) Close(ctx context.Context) (DrainReport, error) {
+	policy, timeout := tm.ShutdownPolicy()
+	if policy == ShutdownCancel {
+		return tm.Shutdown(ctx)
+	}
+
+	drainCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		drainCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// Tasks still running are canceled by Shutdown below
+	drained, _ := tm.Drain(drainCtx)
+
+	report, err := tm.Shutdown(ctx)
+	report.Completed = append(drained.Completed, report.Completed...)
+	report.Canceled = append(drained.Canceled, report.Canceled...)
+	return report, err
+}
+
+func validShutdownPolicy(policy ShutdownPolicy, timeout time.Duration) error {
+	if policy < ShutdownCancel || policy > ShutdownDetach {
+		return fmt.Errorf("unknown shutdown policy %d", int(policy))
+	}
+	if timeout < 0 {
+		return fmt.Errorf("shutdown timeout %v, must not be negative", timeout)
+	}
+	return nil
+}
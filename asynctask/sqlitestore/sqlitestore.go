@@ -0,0 +1,417 @@
+// Package sqlitestore persists tasks in an embedded SQLite database, so a
+// single node keeps their specs, statuses, attempts and results across
+// restarts without running a database server. See asynctask.WithTaskStore.
+//
+// It uses database/sql, leaving the driver to the program: import one
+// registering itself as "sqlite", such as modernc.org/sqlite, or pass
+// another name to WithDriver. WithKeyring encrypts the specs and results
+// of tasks, which may hold personal data and credentials.
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// errNoDriver is returned by Open when no driver is registered under the
+// configured name.
+var errNoDriver = errors.New("no SQLite driver registered")
+
+const (
+	defaultDriver = "sqlite"
+
+	// purgeInterval is the least time between purges of tasks past the
+	// retention.
+	purgeInterval = time.Minute
+)
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS asynctask_tasks (
+		id        TEXT PRIMARY KEY,
+		spec      TEXT,
+		status    TEXT    NOT NULL,
+		attempts  INTEGER NOT NULL DEFAULT 0,
+		submitted INTEGER NOT NULL,
+		started   INTEGER,
+		finished  INTEGER,
+		codec     TEXT,
+		result    BLOB,
+		error     TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS asynctask_tasks_submitted ON asynctask_tasks (submitted)`,
+	`CREATE INDEX IF NOT EXISTS asynctask_tasks_status ON asynctask_tasks (status, submitted)`,
+	`CREATE TABLE IF NOT EXISTS asynctask_task_labels (
+		task_id TEXT NOT NULL,
+		key     TEXT NOT NULL,
+		value   TEXT NOT NULL,
+		PRIMARY KEY (task_id, key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS asynctask_task_labels_value ON asynctask_task_labels (key, value)`,
+}
+
+// Tasks are saved up to three times, as they're submitted, start and
+// finish. Their spec and submission time don't change.
+const saveQuery = `INSERT INTO asynctask_tasks (id, spec, status, attempts, submitted, started, finished, codec, result, error)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET
+		status = excluded.status, attempts = excluded.attempts, started = excluded.started,
+		finished = excluded.finished, codec = excluded.codec, result = excluded.result, error = excluded.error`
+
+const selectQuery = `SELECT id, spec, status, attempts, submitted, started, finished, codec, result, error FROM asynctask_tasks`
+
+type (
+	// Store is an asynctask.TaskStore keeping tasks in SQLite tables
+	// prefixed asynctask_, in WAL mode when opened with Open.
+	Store struct {
+		db        *sql.DB
+		owned     bool // opened by Open, closed by Close
+		driver    string
+		retention time.Duration
+		keyring   *asynctask.Keyring
+		now       func() time.Time
+		lastPurge atomic.Int64 // Unix nanoseconds
+	}
+
+	Option func(*Store)
+)
+
+// WithDriver sets the name of the database/sql driver Open uses,
+// "sqlite" by default.
+func WithDriver(name string) Option {
+	return func(s *Store) {
+		if name != "" {
+			s.driver = name
+		}
+	}
+}
+
+// WithRetention deletes finished tasks submitted longer than d ago, checked
+// at most once a minute as tasks finish. Tasks are kept forever when 0,
+// the default.
+func WithRetention(d time.Duration) Option {
+	return func(s *Store) {
+		if d > 0 {
+			s.retention = d
+		}
+	}
+}
+
+// WithKeyring seals the specs and results of tasks with keyring before
+// storing them, opening them as they're loaded. Tasks stored unsealed
+// before still load; labels and errors aren't sealed, as tasks are queried
+// by them.
+func WithKeyring(keyring *asynctask.Keyring) Option {
+	return func(s *Store) {
+		s.keyring = keyring
+	}
+}
+
+// Open opens or creates the database at path, in WAL mode so tasks are
+// read while others are written.
+func Open(path string, opts ...Option) (*Store, error) {
+	s := newStore(nil, opts)
+	if !slices.Contains(sql.Drivers(), s.driver) {
+		return nil, fmt.Errorf("sqlitestore: %w as %q, import one such as modernc.org/sqlite", errNoDriver, s.driver)
+	}
+	db, err := sql.Open(s.driver, path)
+	if err != nil {
+		return nil, fmt.Errorf("sqlitestore: %w", err)
+	}
+
+	// One connection, so the pragmas apply to every statement and writers
+	// of this process never get SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	for _, pragma := range []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		"PRAGMA busy_timeout = 5000",
+	} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("sqlitestore: %s: %w", pragma, err)
+		}
+	}
+
+	s.db, s.owned = db, true
+	if err := s.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New returns a store using db, creating its tables if needed. The caller
+// configures and closes db.
+func New(ctx context.Context, db *sql.DB, opts ...Option) (*Store, error) {
+	s := newStore(db, opts)
+	if err := s.migrate(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newStore(db *sql.DB, opts []Option) *Store {
+	s := &Store{db: db, driver: defaultDriver, now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Store) migrate(ctx context.Context) error {
+	for _, stmt := range schema {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("sqlitestore: creating tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the database if the store opened it.
+func (s *Store) Close() error {
+	if !s.owned {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// Save inserts or replaces task, adding its labels the first time.
+func (s *Store) Save(ctx context.Context, task asynctask.StoredTask) error {
+	// Specs are stored as JSON text, or as blobs once sealed
+	var spec any
+	if task.Spec != nil {
+		data, err := json.Marshal(task.Spec)
+		if err != nil {
+			return fmt.Errorf("sqlitestore: encoding spec: %w", err)
+		}
+		spec = string(data)
+		if s.keyring != nil {
+			if spec, err = s.keyring.Seal(data); err != nil {
+				return fmt.Errorf("sqlitestore: sealing spec: %w", err)
+			}
+		}
+	}
+	result := task.Result
+	if s.keyring != nil && result != nil {
+		var err error
+		if result, err = s.keyring.Seal(result); err != nil {
+			return fmt.Errorf("sqlitestore: sealing result: %w", err)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlitestore: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, saveQuery,
+		task.ID.String(), spec, task.Status.String(), task.Attempts,
+		task.Submitted.UnixNano(), nullTime(task.Started), nullTime(task.Finished),
+		nullString(task.Codec), result, nullString(task.Error))
+	if err != nil {
+		return fmt.Errorf("sqlitestore: saving %s: %w", task.ID, err)
+	}
+	for key, value := range task.Labels {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO asynctask_task_labels (task_id, key, value) VALUES (?, ?, ?)`,
+			task.ID.String(), key, value); err != nil {
+			return fmt.Errorf("sqlitestore: saving labels of %s: %w", task.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlitestore: %w", err)
+	}
+
+	if s.retention > 0 && !task.Finished.IsZero() {
+		s.purge(ctx)
+	}
+	return nil
+}
+
+// Load returns the task stored with id.
+func (s *Store) Load(ctx context.Context, id asynctask.ID) (asynctask.StoredTask, error) {
+	tasks, err := s.query(ctx, selectQuery+` WHERE id = ?`, id.String())
+	if err != nil {
+		return asynctask.StoredTask{}, err
+	}
+	if len(tasks) == 0 {
+		return asynctask.StoredTask{}, fmt.Errorf("sqlitestore: %w: %s", asynctask.ErrTaskNotFound, id)
+	}
+	return tasks[0], nil
+}
+
+// List returns the tasks query matches, most recently submitted first.
+func (s *Store) List(ctx context.Context, query asynctask.TaskQuery) ([]asynctask.StoredTask, error) {
+	var where []string
+	var args []any
+	if len(query.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(query.Statuses)-1)+")")
+		for _, status := range query.Statuses {
+			args = append(args, status.String())
+		}
+	}
+	for key, value := range query.Labels {
+		where = append(where, "EXISTS (SELECT 1 FROM asynctask_task_labels l WHERE l.task_id = asynctask_tasks.id AND l.key = ? AND l.value = ?)")
+		args = append(args, key, value)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "submitted >= ?")
+		args = append(args, query.Since.UnixNano())
+	}
+
+	stmt := selectQuery
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY submitted DESC, id DESC"
+	if query.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	return s.query(ctx, stmt, args...)
+}
+
+// Purge deletes the finished tasks submitted before t, returning how many.
+func (s *Store) Purge(ctx context.Context, before time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("sqlitestore: %w", err)
+	}
+	defer tx.Rollback()
+
+	const finished = `SELECT id FROM asynctask_tasks WHERE submitted < ? AND status IN ('completed', 'failed', 'canceled')`
+	if _, err := tx.ExecContext(ctx, `DELETE FROM asynctask_task_labels WHERE task_id IN (`+finished+`)`, before.UnixNano()); err != nil {
+		return 0, fmt.Errorf("sqlitestore: purging labels: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM asynctask_tasks WHERE id IN (`+finished+`)`, before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("sqlitestore: purging tasks: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("sqlitestore: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// purge deletes the tasks past the retention, unless done less than a
+// purge interval ago. Failures are left to the next purge.
+func (s *Store) purge(ctx context.Context) {
+	now := s.now()
+	last := s.lastPurge.Load()
+	if now.UnixNano()-last < int64(purgeInterval) || !s.lastPurge.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	_, _ = s.Purge(ctx, now.Add(-s.retention))
+}
+
+func (s *Store) query(ctx context.Context, stmt string, args ...any) ([]asynctask.StoredTask, error) {
+	rows, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("sqlitestore: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []asynctask.StoredTask
+	for rows.Next() {
+		var (
+			task              asynctask.StoredTask
+			id, status        string
+			spec              []byte
+			codec, taskErr    sql.NullString
+			submitted         int64
+			started, finished sql.NullInt64
+		)
+		if err := rows.Scan(&id, &spec, &status, &task.Attempts, &submitted, &started, &finished, &codec, &task.Result, &taskErr); err != nil {
+			return nil, fmt.Errorf("sqlitestore: %w", err)
+		}
+		if task.ID, err = asynctask.ParseID(id); err != nil {
+			return nil, fmt.Errorf("sqlitestore: %w", err)
+		}
+		if spec != nil {
+			if spec, err = s.open(spec); err != nil {
+				return nil, fmt.Errorf("sqlitestore: opening spec of %s: %w", id, err)
+			}
+			task.Spec = new(asynctask.Spec)
+			if err := json.Unmarshal(spec, task.Spec); err != nil {
+				return nil, fmt.Errorf("sqlitestore: decoding spec of %s: %w", id, err)
+			}
+		}
+		if task.Result, err = s.open(task.Result); err != nil {
+			return nil, fmt.Errorf("sqlitestore: opening result of %s: %w", id, err)
+		}
+		task.Status = asynctask.ParseStatus(status)
+		task.Submitted = time.Unix(0, submitted)
+		if started.Valid {
+			task.Started = time.Unix(0, started.Int64)
+		}
+		if finished.Valid {
+			task.Finished = time.Unix(0, finished.Int64)
+		}
+		task.Codec, task.Error = codec.String, taskErr.String
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlitestore: %w", err)
+	}
+	return tasks, s.loadLabels(ctx, tasks)
+}
+
+// loadLabels fills in the labels of tasks.
+func (s *Store) loadLabels(ctx context.Context, tasks []asynctask.StoredTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(tasks))
+	args := make([]any, len(tasks))
+	for i, task := range tasks {
+		index[task.ID.String()] = i
+		args[i] = task.ID.String()
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT task_id, key, value FROM asynctask_task_labels WHERE task_id IN (?`+
+		strings.Repeat(", ?", len(tasks)-1)+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("sqlitestore: loading labels: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, key, value string
+		if err := rows.Scan(&id, &key, &value); err != nil {
+			return fmt.Errorf("sqlitestore: loading labels: %w", err)
+		}
+		task := &tasks[index[id]]
+		if task.Labels == nil {
+			task.Labels = make(map[string]string)
+		}
+		task.Labels[key] = value
+	}
+	return rows.Err()
+}
+
+// open opens data sealed with the keyring, if any.
+func (s *Store) open(data []byte) ([]byte, error) {
+	if s.keyring == nil || data == nil {
+		return data, nil
+	}
+	return s.keyring.Open(data)
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime stores times as Unix nanoseconds, zero ones as NULL.
+func nullTime(t time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: !t.IsZero()}
+}
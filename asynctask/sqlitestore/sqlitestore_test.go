@@ -0,0 +1,183 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// openTest opens a store in a temporary directory, skipping the test when
+// the test binary has no SQLite driver linked in.
+func openTest(t *testing.T, opts ...Option) *Store {
+	t.Helper()
+	if !slices.Contains(sql.Drivers(), defaultDriver) {
+		t.Skip("no SQLite driver registered as " + defaultDriver)
+	}
+	s, err := Open(filepath.Join(t.TempDir(), "tasks.db"), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Test saving, loading and listing tasks
+func TestStore(t *testing.T) {
+	s := openTest(t)
+	ctx := context.Background()
+	submitted := time.Unix(1700000000, 0)
+
+	report := asynctask.StoredTask{
+		ID:        mustParseID(t, asynctask.NewXID()),
+		Spec:      &asynctask.Spec{Name: "report", Params: map[string]any{"month": "2024-01"}},
+		Labels:    map[string]string{"tenant": "acme"},
+		Status:    asynctask.StatusPending,
+		Submitted: submitted,
+	}
+	if err := s.Save(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+	report.Status, report.Attempts = asynctask.StatusCompleted, 2
+	report.Started, report.Finished = submitted.Add(time.Second), submitted.Add(3*time.Second)
+	report.Codec, report.Result = asynctask.CodecJSON, []byte(`{"rows":3}`)
+	if err := s.Save(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Load(ctx, report.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != asynctask.StatusCompleted || got.Attempts != 2 || got.Spec.Name != "report" ||
+		got.Labels["tenant"] != "acme" || string(got.Result) != `{"rows":3}` || !got.Finished.Equal(report.Finished) {
+		t.Fatalf("unexpected task %+v", got)
+	}
+	if _, err := s.Load(ctx, mustParseID(t, asynctask.NewXID())); !errors.Is(err, asynctask.ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound, got %v", err)
+	}
+
+	running := asynctask.StoredTask{
+		ID:        mustParseID(t, asynctask.NewXID()),
+		Labels:    map[string]string{"tenant": "globex"},
+		Status:    asynctask.StatusRunning,
+		Submitted: submitted.Add(time.Minute),
+		Started:   submitted.Add(time.Minute),
+	}
+	if err := s.Save(ctx, running); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		query asynctask.TaskQuery
+		want  []asynctask.ID
+	}{
+		{asynctask.TaskQuery{}, []asynctask.ID{running.ID, report.ID}},
+		{asynctask.TaskQuery{Limit: 1}, []asynctask.ID{running.ID}},
+		{asynctask.TaskQuery{Statuses: []asynctask.Status{asynctask.StatusPending, asynctask.StatusRunning}}, []asynctask.ID{running.ID}},
+		{asynctask.TaskQuery{Labels: map[string]string{"tenant": "acme"}}, []asynctask.ID{report.ID}},
+		{asynctask.TaskQuery{Since: submitted.Add(time.Second)}, []asynctask.ID{running.ID}},
+	} {
+		tasks, err := s.List(ctx, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []asynctask.ID
+		for _, task := range tasks {
+			ids = append(ids, task.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("%+v: got %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+
+	// Purging keeps unfinished tasks
+	n, err := s.Purge(ctx, submitted.Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("purged %d, %v", n, err)
+	}
+	if tasks, _ := s.List(ctx, asynctask.TaskQuery{}); len(tasks) != 1 || tasks[0].ID != running.ID {
+		t.Fatalf("unexpected tasks left %+v", tasks)
+	}
+}
+
+// Test a manager's tasks are persisted
+func TestStore_Manager(t *testing.T) {
+	s := openTest(t)
+	ctx := context.Background()
+
+	tm := asynctask.NewManager(asynctask.WithTaskStore(s))
+	id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return "done", nil
+	}))
+	if _, err := tm.Await(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := s.Load(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != asynctask.StatusCompleted || task.Attempts != 1 || string(task.Result) != `"done"` {
+		t.Fatalf("unexpected task %+v", task)
+	}
+}
+
+// Test specs and results are sealed at rest and opened as loaded
+func TestStore_Keyring(t *testing.T) {
+	keyring, err := asynctask.NewKeyring([]string{"k1"}, [][]byte{make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := openTest(t, WithKeyring(keyring))
+	ctx := context.Background()
+
+	task := asynctask.StoredTask{
+		ID:        mustParseID(t, asynctask.NewXID()),
+		Spec:      &asynctask.Spec{Name: "login", Params: map[string]any{"password": "hunter2"}},
+		Status:    asynctask.StatusCompleted,
+		Submitted: time.Now(),
+		Codec:     asynctask.CodecJSON,
+		Result:    []byte(`"token"`),
+	}
+	if err := s.Save(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+
+	var spec, result []byte
+	if err := s.db.QueryRow(`SELECT spec, result FROM asynctask_tasks WHERE id = ?`, task.ID.String()).Scan(&spec, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !asynctask.Sealed(spec) || !asynctask.Sealed(result) {
+		t.Fatalf("expected sealed spec and result, got %q and %q", spec, result)
+	}
+
+	got, err := s.Load(ctx, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.Params["password"] != "hunter2" || string(got.Result) != `"token"` {
+		t.Fatalf("unexpected task %+v", got)
+	}
+}
+
+// Test Open fails clearly without a driver
+func TestOpen_NoDriver(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "tasks.db"), WithDriver("sqlite-missing")); !errors.Is(err, errNoDriver) {
+		t.Fatalf("expected errNoDriver, got %v", err)
+	}
+}
+
+func mustParseID(t *testing.T, s string) asynctask.ID {
+	t.Helper()
+	id, err := asynctask.ParseID(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -0,0 +1,71 @@
+package asynctask
+
+import "time"
+
+// transitions lists the statuses each status may move to. Finished
+// statuses move nowhere, so a completed task can't be canceled and a
+// canceled one can't complete. Deferred tasks keep their status once
+// promoted; the task they're promoted to runs through its own.
+var transitions = [...][]Status{
+	StatusDeferred:  {StatusCanceled},
+	StatusPending:   {StatusRunning, StatusFailed, StatusCanceled},
+	StatusRunning:   {StatusCompleted, StatusFailed, StatusCanceled},
+	StatusCompleted: nil,
+	StatusFailed:    nil,
+	StatusCanceled:  nil,
+	StatusUnknown:   nil,
+}
+
+// canTransition reports whether a task may move from s to status to.
+func (s Status) canTransition(to Status) bool {
+	if s < 0 || int(s) >= len(transitions) {
+		return false
+	}
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// finished reports whether s is a final status.
+func (s Status) finished() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCanceled
+}
+
+// transition moves the record to status to, if that's a valid transition
+// from its current status, and stamps the time it started or finished.
+// Returns the status it moved from, and false if the transition was
+// rejected.
+func (r *taskRecord) transition(to Status) (Status, bool) {
+	for {
+		from := r.loadStatus()
+		if !from.canTransition(to) {
+			return from, false
+		}
+		if r.status.CompareAndSwap(int32(from), int32(to)) {
+			now := r.clock.Now().UnixNano()
+			switch {
+			case to == StatusRunning:
+				r.started.Store(now)
+			case to.finished():
+				r.finished.Store(now)
+			}
+			return from, true
+		}
+	}
+}
+
+// times returns when the record was submitted, started and finished. The
+// latter two are zero until the task gets there.
+func (r *taskRecord) times() (submitted, started, finished time.Time) {
+	return unixTime(r.submitted.Load()), unixTime(r.started.Load()), unixTime(r.finished.Load())
+}
+
+func unixTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,135 @@
+package asynctask
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// TaskStore persists the tasks of a manager as they move through their
+	// statuses, see WithTaskStore. Stores must be safe for concurrent use.
+	TaskStore interface {
+		// Save inserts task, or replaces the task stored with its ID.
+		Save(ctx context.Context, task StoredTask) error
+
+		// Load returns the task stored with id, or an error wrapping
+		// ErrTaskNotFound.
+		Load(ctx context.Context, id ID) (StoredTask, error)
+
+		// List returns the stored tasks query matches, most recently
+		// submitted first.
+		List(ctx context.Context, query TaskQuery) ([]StoredTask, error)
+	}
+
+	// StoredTask is a task as a TaskStore keeps it. Spec is only set for
+	// tasks built from one (see Submit), so they can be submitted again.
+	// Result is encoded by the codec named by Codec; it's nil for results
+	// offloaded or failing to encode.
+	StoredTask struct {
+		ID        ID
+		Spec      *Spec
+		Labels    map[string]string
+		Status    Status
+		Attempts  int // runs of the runnable, counting those of WithRetry
+		Submitted time.Time
+		Started   time.Time
+		Finished  time.Time
+		Codec     string
+		Result    []byte
+		Error     string
+	}
+
+	// TaskQuery selects stored tasks. Tasks match when they have one of
+	// Statuses, all of Labels and were submitted at or after Since; zero
+	// fields match any task. Limit caps the tasks returned, 0 for none.
+	TaskQuery struct {
+		Statuses []Status
+		Labels   map[string]string
+		Since    time.Time
+		Limit    int
+	}
+
+	// attemptsKey holds the attempt counter of a task's record.
+	attemptsKey struct{}
+)
+
+// WithTaskStore persists every task to store when it's submitted, starts
+// and finishes, with its spec, labels, status, attempts and encoded
+// result, so tasks outlive the process for inspection after a crash and
+// history queries. Tasks left pending or running in the store were
+// interrupted. Saves happen on the task's path and failures are logged at
+// warn level without failing the task.
+func WithTaskStore(store TaskStore) Option {
+	return func(m *Manager) {
+		if store == nil {
+			m.invalidOption("nil task store")
+			return
+		}
+		m.store = store
+	}
+}
+
+// persist saves rec to the task store, if any.
+func (tm *Manager) persist(rec *taskRecord) {
+	if tm.store == nil {
+		return
+	}
+
+	task := StoredTask{
+		ID:       rec.id,
+		Labels:   maps.Clone(rec.labels),
+		Status:   rec.loadStatus(),
+		Attempts: int(rec.attempts.Load()),
+	}
+	task.Submitted, task.Started, task.Finished = rec.times()
+	if sr, ok := rec.runnable.(*specRunnable); ok {
+		spec := sr.spec
+		task.Spec = &spec
+	}
+	if task.Status.finished() {
+		rec.mu.Lock()
+		result := rec.result
+		rec.mu.Unlock()
+		if result.Error != nil {
+			task.Error = result.Error.Error()
+		} else if result.Result != nil {
+			data, err := tm.codec.Marshal(result.Result)
+			if err == nil {
+				task.Codec, task.Result = tm.codec.Name(), data
+			} else {
+				tm.logger.Warn("Task Result Not Persisted", slog.String("id", rec.id.String()), slog.Any("error", err))
+			}
+		}
+	}
+
+	if err := tm.store.Save(context.WithoutCancel(rec.ctx), task); err != nil {
+		tm.logger.Warn("Task Not Persisted", slog.String("id", rec.id.String()), slog.String("status", task.Status.String()), slog.Any("error", err))
+	}
+}
+
+// withAttempts returns a derived context carrying the attempt counter of
+// a task's record.
+func withAttempts(ctx context.Context, attempts *atomic.Int32) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, attempts)
+}
+
+// countAttempt counts another attempt of the task running with ctx.
+func countAttempt(ctx context.Context) {
+	if attempts, ok := ctx.Value(attemptsKey{}).(*atomic.Int32); ok {
+		attempts.Add(1)
+	}
+}
+
+// ParseStatus returns the status named s, as Status.String returns it, or
+// StatusUnknown.
+func ParseStatus(s string) Status {
+	for status := StatusDeferred; status < StatusUnknown; status++ {
+		if status.String() == s {
+			return status
+		}
+	}
+	return StatusUnknown
+}
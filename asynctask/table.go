@@ -0,0 +1,350 @@
+package asynctask
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// taskShards is the number of shards of a task table, a power of two.
+	taskShards = 64
+
+	// namespaceShards is the number of shards a namespace's tasks are
+	// spread over when the table is sharded by namespace, a power of two.
+	namespaceShards = 4
+
+	// namespaceGroups is the number of groups of shards namespaces are
+	// hashed to.
+	namespaceGroups = taskShards / namespaceShards
+)
+
+type (
+	// taskTable maps task IDs to their records. It's sharded by ID, so
+	// tasks submitted and finishing concurrently rarely contend on a lock.
+	// Sharded by namespace, a namespace's tasks stay within a group of
+	// shards of their own, so a burst of tasks in one namespace doesn't
+	// slow lookups in the others.
+	taskTable struct {
+		shards      [taskShards]taskShard
+		byNamespace bool
+	}
+
+	taskShard struct {
+		mu      sync.RWMutex
+		records map[ID]*taskRecord
+	}
+
+	// taskRecord holds all state of one task. Its status moves through
+	// the state machine in state.go, so a task canceled while it finishes
+	// ends up either canceled or finished, never a mix of both.
+	taskRecord struct {
+		id        ID
+		namespace string // value of the manager's shard label
+		status    atomic.Int32
+
+		// Transition times, in Unix nanoseconds
+		submitted atomic.Int64
+		started   atomic.Int64
+		finished  atomic.Int64
+
+		worker   atomic.Int32 // number of the worker running it, plus one
+		attempts atomic.Int32 // runs of its runnable, see StoredTask
+
+		heartbeat atomic.Pointer[heartbeat] // liveness, with stall detection
+		unbounded bool                      // runs without a worker slot, see WithUnbounded
+
+		labels   map[string]string
+		deferred bool
+		group    []*taskRecord // deferred along with it by DeferAll, itself included
+		done     chan struct{} // closed when the task finishes
+
+		// Kept so the task can be retried, replayed or promoted
+		runnable Runnable
+		ctx      context.Context
+		cancel   context.CancelFunc // nil for deferred tasks
+		clock    Clock              // stamps transition times
+		codec    Codec              // encodes the result in JSON
+
+		once sync.Once // promotes a deferred task
+
+		mu        sync.Mutex
+		result    Future
+		logs      *logBuffer
+		promoted  ID   // task a deferred task was promoted to
+		detached  bool // canceled, no longer awaitable
+		offloaded *offloadedResult
+		watchers  []watcher
+	}
+
+	// watcher is told the index of a task among those awaited together
+	// once it finishes
+	watcher struct {
+		finished chan<- int
+		index    int
+	}
+)
+
+// WithNamespaceSharding shards the manager's task table and the queue of
+// its PoolExecutor by the value of label, such as the request or tenant of
+// a manager shared by many. Each value's tasks are kept in a few shards of
+// their own, so a burst of tasks from one of them contends with the others
+// only when their values hash alike. Awaits find tasks in the namespace of
+// the labels their context carries first; lookups by ID alone, such as
+// Cancel and Status, look in every namespace. Tasks without the label
+// share one namespace.
+func WithNamespaceSharding(label string) Option {
+	return func(m *Manager) {
+		if label == "" {
+			m.invalidOption("namespace sharding without a label")
+			return
+		}
+		m.shardLabel = label
+		m.tasks.byNamespace = true
+	}
+}
+
+// namespace returns the namespace of the tasks awaited with ctx, empty
+// without namespace sharding.
+func (tm *Manager) namespace(ctx context.Context) string {
+	if tm.shardLabel == "" {
+		return ""
+	}
+	return LabelsFromContext(ctx)[tm.shardLabel]
+}
+
+// fnv32 returns the FNV-1a hash of s.
+func fnv32(s string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(s); i++ {
+		h = (h ^ uint32(s[i])) * 16777619
+	}
+	return h
+}
+
+// namespaceGroup returns the group of shards holding the tasks of
+// namespace.
+func namespaceGroup(namespace string) int {
+	return int(fnv32(namespace) & (namespaceGroups - 1))
+}
+
+// shard returns the shard holding id in the given group, picked by a hash
+// of id. Without namespace sharding there's a single group of all shards.
+func (t *taskTable) shard(group int, id ID) *taskShard {
+	h := fnv32(id.s)
+	if !t.byNamespace {
+		return &t.shards[h&(taskShards-1)]
+	}
+	return &t.shards[group*namespaceShards+int(h&(namespaceShards-1))]
+}
+
+// load returns the record of id, looking in every namespace.
+func (t *taskTable) load(id ID) (*taskRecord, bool) {
+	if !t.byNamespace {
+		return t.shard(0, id).load(id)
+	}
+	for group := range namespaceGroups {
+		if rec, ok := t.shard(group, id).load(id); ok {
+			return rec, true
+		}
+	}
+	return nil, false
+}
+
+// loadIn returns the record of id, looking in namespace before the others.
+func (t *taskTable) loadIn(namespace string, id ID) (*taskRecord, bool) {
+	if t.byNamespace {
+		if rec, ok := t.shard(namespaceGroup(namespace), id).load(id); ok {
+			return rec, true
+		}
+	}
+	return t.load(id)
+}
+
+func (t *taskTable) store(rec *taskRecord) {
+	s := t.shard(namespaceGroup(rec.namespace), rec.id)
+	s.mu.Lock()
+	if s.records == nil {
+		s.records = make(map[ID]*taskRecord)
+	}
+	s.records[rec.id] = rec
+	s.mu.Unlock()
+}
+
+func (t *taskTable) delete(rec *taskRecord) {
+	s := t.shard(namespaceGroup(rec.namespace), rec.id)
+	s.mu.Lock()
+	delete(s.records, rec.id)
+	s.mu.Unlock()
+}
+
+func (s *taskShard) load(id ID) (*taskRecord, bool) {
+	s.mu.RLock()
+	rec, ok := s.records[id]
+	s.mu.RUnlock()
+	return rec, ok
+}
+
+// snapshot returns all records, in no particular order.
+func (t *taskTable) snapshot() []*taskRecord {
+	var records []*taskRecord
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.RLock()
+		for _, rec := range s.records {
+			records = append(records, rec)
+		}
+		s.mu.RUnlock()
+	}
+	return records
+}
+
+// each calls fn with every record until it returns false, a shard at a
+// time, holding no lock while fn runs.
+func (t *taskTable) each(fn func(*taskRecord) bool) {
+	var records []*taskRecord
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.RLock()
+		records = records[:0]
+		for _, rec := range s.records {
+			records = append(records, rec)
+		}
+		s.mu.RUnlock()
+
+		for _, rec := range records {
+			if !fn(rec) {
+				return
+			}
+		}
+	}
+}
+
+// len returns the number of records.
+func (t *taskTable) len() int {
+	n := 0
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.RLock()
+		n += len(s.records)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+// clear removes all records.
+func (t *taskTable) clear() {
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.Lock()
+		clear(s.records)
+		s.mu.Unlock()
+	}
+}
+
+func (r *taskRecord) loadStatus() Status {
+	return Status(r.status.Load())
+}
+
+// future returns the record's result, or a partial Future while it runs,
+// with its status and labels.
+func (r *taskRecord) future() Future {
+	r.mu.Lock()
+	future := r.result
+	r.mu.Unlock()
+
+	future.ID = r.id
+	future.Status = r.loadStatus().String()
+	future.Labels = r.labels
+	future.Submitted, future.Time, future.Finished = r.times()
+	if hb := r.heartbeat.Load(); hb != nil {
+		future.Stalled = hb.stalled.Load()
+	}
+	future.codec = r.codec
+	return future
+}
+
+// summary returns the state of the record for Each, with a copy of its
+// labels.
+func (r *taskRecord) summary() TaskSummary {
+	summary := TaskSummary{
+		ID:       r.id,
+		Status:   r.loadStatus(),
+		Labels:   maps.Clone(r.labels),
+		Attempts: int(r.attempts.Load()),
+	}
+	summary.Submitted, summary.Started, summary.Finished = r.times()
+	if hb := r.heartbeat.Load(); hb != nil {
+		summary.Stalled = hb.stalled.Load()
+	}
+	return summary
+}
+
+// promotedID returns the task a deferred record was promoted to, the zero
+// ID until it's awaited.
+func (r *taskRecord) promotedID() ID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.promoted
+}
+
+// outcome returns the result of a finished record as Await does, fetching
+// an offloaded one with ctx.
+func (r *taskRecord) outcome(ctx context.Context) (Future, error) {
+	r.mu.Lock()
+	result := r.result
+	offloaded := r.offloaded
+	r.mu.Unlock()
+
+	result.codec = r.codec
+	if result.Error != nil {
+		return result, fmt.Errorf("task %s: %w: %w", r.id.String(), ErrTaskFailed, result.Error)
+	}
+	if offloaded != nil {
+		value, err := offloaded.fetch(ctx)
+		if err != nil {
+			return result, fmt.Errorf("task %s: %w", r.id.String(), err)
+		}
+		result.Result = value
+	}
+	return result, nil
+}
+
+// watch sends index on finished once the record finishes, right away if
+// it already has. finished must have room, as the send doesn't block.
+func (r *taskRecord) watch(finished chan<- int, index int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		finished <- index
+	default:
+		r.watchers = append(r.watchers, watcher{finished: finished, index: index})
+	}
+}
+
+// unwatch stops sending on finished.
+func (r *taskRecord) unwatch(finished chan<- int) {
+	r.mu.Lock()
+	r.watchers = slices.DeleteFunc(r.watchers, func(w watcher) bool {
+		return w.finished == finished
+	})
+	r.mu.Unlock()
+}
+
+// close marks the record finished, waking its waiters and watchers.
+func (r *taskRecord) close() {
+	r.mu.Lock()
+	close(r.done)
+	watchers := r.watchers
+	r.watchers = nil
+	r.mu.Unlock()
+
+	for _, w := range watchers {
+		w.finished <- w.index
+	}
+}
@@ -0,0 +1,161 @@
+package asynctask
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// rateInterval is how often the moving averages of Stats take in the
+	// tasks finished meanwhile.
+	rateInterval = 5 * time.Second
+
+	// durationWindow is how long the durations of finished tasks count for
+	// the percentiles of Stats, for one to two windows.
+	durationWindow = time.Minute
+
+	// The duration histogram has durationSteps buckets per doubling, from
+	// 1ns up to 2^40ns, about 18 minutes, longer tasks falling in the last.
+	durationSteps   = 4
+	durationBuckets = 40 * durationSteps
+)
+
+var (
+	alpha1m = 1 - math.Exp(-rateInterval.Seconds()/time.Minute.Seconds())
+	alpha5m = 1 - math.Exp(-rateInterval.Seconds()/(5*time.Minute).Seconds())
+)
+
+type (
+	// throughput keeps the moving averages and duration percentiles of the
+	// tasks finished by a manager, updated as they finish so Stats doesn't
+	// go through the tasks for them.
+	throughput struct {
+		mu sync.Mutex
+
+		// Exponentially weighted tasks per second, updated each interval
+		tick              time.Time // start of the current interval
+		completed, failed int       // in the current interval
+		rate1m, rate5m    float64   // completed tasks
+		failed5m          float64
+		primed            bool // set by the first interval
+
+		// Durations of the tasks finished in the current and previous window
+		window              time.Time
+		durations, previous durationHistogram
+	}
+
+	durationHistogram [durationBuckets]uint32
+)
+
+// record counts a finished task that ran for d, at now.
+func (t *throughput) record(now time.Time, d time.Duration, status Status) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.advance(now)
+	switch status {
+	case StatusCompleted:
+		t.completed++
+	case StatusFailed:
+		t.failed++
+	default:
+		return // canceled tasks may not even have run
+	}
+	t.durations[durationBucket(d)]++
+}
+
+// advance brings the averages and duration windows up to now.
+func (t *throughput) advance(now time.Time) {
+	if t.tick.IsZero() {
+		t.tick, t.window = now, now
+		return
+	}
+
+	if intervals := int(now.Sub(t.tick) / rateInterval); intervals > 0 {
+		completed := float64(t.completed) / rateInterval.Seconds()
+		failed := float64(t.failed) / rateInterval.Seconds()
+		if !t.primed {
+			t.rate1m, t.rate5m, t.failed5m, t.primed = completed, completed, failed, true
+		} else {
+			t.rate1m += alpha1m * (completed - t.rate1m)
+			t.rate5m += alpha5m * (completed - t.rate5m)
+			t.failed5m += alpha5m * (failed - t.failed5m)
+		}
+		// Intervals without tasks since then
+		idle := float64(intervals - 1)
+		t.rate1m *= math.Pow(1-alpha1m, idle)
+		t.rate5m *= math.Pow(1-alpha5m, idle)
+		t.failed5m *= math.Pow(1-alpha5m, idle)
+
+		t.completed, t.failed = 0, 0
+		t.tick = t.tick.Add(time.Duration(intervals) * rateInterval)
+	}
+
+	if windows := now.Sub(t.window) / durationWindow; windows > 0 {
+		t.previous = t.durations
+		if windows > 1 {
+			t.previous = durationHistogram{}
+		}
+		t.durations = durationHistogram{}
+		t.window = t.window.Add(windows * durationWindow)
+	}
+}
+
+// stats fills in the throughput figures of s as of now.
+func (t *throughput) stats(now time.Time, s *Stats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.advance(now)
+	s.Rate1m = t.rate1m
+	s.Rate5m = t.rate5m
+	if finished := t.rate5m + t.failed5m; finished > 0 {
+		s.FailureRate = t.failed5m / finished
+	}
+
+	var durations durationHistogram
+	for i := range durations {
+		durations[i] = t.durations[i] + t.previous[i]
+	}
+	s.P50 = durations.quantile(.50)
+	s.P95 = durations.quantile(.95)
+	s.P99 = durations.quantile(.99)
+}
+
+// durationBucket returns the histogram bucket of d, the one from
+// 2^(i/durationSteps) up to 2^((i+1)/durationSteps) nanoseconds.
+func durationBucket(d time.Duration) int {
+	if d <= 1 {
+		return 0
+	}
+	return min(int(math.Log2(float64(d))*durationSteps), durationBuckets-1)
+}
+
+// quantile estimates the duration below which fraction q of the durations
+// in h fall, interpolating within its bucket. It's 0 for an empty h.
+func (h *durationHistogram) quantile(q float64) time.Duration {
+	var total uint64
+	for _, n := range h {
+		total += uint64(n)
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var seen uint64
+	for i, n := range h {
+		if n == 0 || float64(seen+uint64(n)) < rank {
+			seen += uint64(n)
+			continue
+		}
+		lower := math.Exp2(float64(i) / durationSteps)
+		upper := math.Exp2(float64(i+1) / durationSteps)
+		if i == 0 {
+			lower = 0
+		}
+		return time.Duration(lower + (upper-lower)*(rank-float64(seen))/float64(n))
+	}
+	return time.Duration(math.Exp2(durationBuckets / durationSteps))
+}
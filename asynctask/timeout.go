@@ -0,0 +1,114 @@
+package asynctask
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type (
+	// TimeoutError is the error of AwaitTimeout and AwaitAllTimeout once
+	// their timeout runs out before the tasks finish. Unlike the timeout of
+	// Await, it leaves the tasks running and tells how far they got, so the
+	// caller can wait some more, cancel them or leave them be. It matches
+	// ErrTaskTimeout.
+	TimeoutError struct {
+		Timeout time.Duration
+		Pending []PendingTask // the unfinished tasks, in the order awaited
+	}
+
+	// PendingTask is the state of a task an await gave up on.
+	PendingTask struct {
+		ID      ID
+		Status  Status        // deferred, pending or running
+		Elapsed time.Duration // since it was submitted
+	}
+)
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%v: %d unfinished after %v", ErrTaskTimeout, len(e.Pending), e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return ErrTaskTimeout
+}
+
+// AwaitTimeout is Await giving up after d without canceling the task. When
+// the task doesn't finish in time, it returns the task's Future as far as
+// it got, with its status and times, and a *TimeoutError. Deferred tasks
+// are promoted, and the time adds to the await budget as for Await.
+func (tm *Manager) AwaitTimeout(ctx context.Context, taskID ID, d time.Duration) (Future, error) {
+	timeoutCtx, cancel := withTimeout(ctx, tm.clock, d)
+	defer cancel()
+
+	future, err := tm.await(timeoutCtx, taskID, false)
+	if err != nil && timeoutCtx.Err() != nil && ctx.Err() == nil {
+		if futures, timeoutErr := tm.pending(ctx, []ID{taskID}, d); timeoutErr != nil {
+			return futures[0], timeoutErr
+		}
+		// It finished as the timeout ran out
+		future, err = tm.await(ctx, taskID, false)
+	}
+	return future, err
+}
+
+// AwaitAllTimeout is AwaitAll giving up after d without canceling the
+// tasks. When they don't all finish in time, it returns the futures of all
+// of them as far as they got, in the order of taskIDs, finished ones with
+// their outcome, and a *TimeoutError listing the others. When ctx is done
+// first, it returns ctx's error, also leaving the tasks running.
+func (tm *Manager) AwaitAllTimeout(ctx context.Context, taskIDs []ID, d time.Duration) ([]Future, error) {
+	if len(taskIDs) == 0 {
+		return nil, nil
+	}
+
+	timeoutCtx, cancel := withTimeout(ctx, tm.clock, d)
+	defer cancel()
+
+	var tasks []Future
+	var errs []error
+	err := tm.budgeted(timeoutCtx, func(ctx context.Context) (err error) {
+		tasks, errs, err = tm.awaitAll(ctx, taskIDs, false)
+		return err
+	})
+	if err != nil && timeoutCtx.Err() != nil && ctx.Err() == nil {
+		if futures, timeoutErr := tm.pending(ctx, taskIDs, d); timeoutErr != nil {
+			return futures, timeoutErr
+		}
+		// They finished as the timeout ran out
+		tasks, errs, err = tm.awaitAll(ctx, taskIDs, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tasks, nil
+}
+
+// pending returns the futures of taskIDs as far as they got, and the
+// *TimeoutError of those unfinished, nil when all finished.
+func (tm *Manager) pending(ctx context.Context, taskIDs []ID, d time.Duration) ([]Future, error) {
+	now := tm.clock.Now()
+	namespace := tm.namespace(ctx)
+	futures := make([]Future, len(taskIDs))
+	var unfinished []PendingTask
+	for i, taskID := range taskIDs {
+		rec, err := tm.resolve(ctx, namespace, taskID, false)
+		if err != nil {
+			futures[i] = Future{ID: taskID, Status: StatusUnknown.String()}
+			continue
+		}
+		futures[i] = rec.future()
+		if status := rec.loadStatus(); !status.finished() {
+			unfinished = append(unfinished, PendingTask{ID: taskID, Status: status, Elapsed: now.Sub(futures[i].Submitted)})
+		}
+	}
+	if len(unfinished) == 0 {
+		return futures, nil
+	}
+	return futures, &TimeoutError{Timeout: d, Pending: unfinished}
+}
@@ -0,0 +1,115 @@
+package asynctask
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+)
+
+type (
+	// traceEvent is an event of the Chrome trace event format, which
+	// chrome://tracing, Perfetto and speedscope open.
+	traceEvent struct {
+		Name     string         `json:"name"`
+		Category string         `json:"cat,omitempty"`
+		Phase    string         `json:"ph"`
+		Time     float64        `json:"ts"` // microseconds
+		Duration float64        `json:"dur,omitempty"`
+		PID      int            `json:"pid"`
+		TID      int            `json:"tid"`
+		ID       string         `json:"id,omitempty"`
+		Args     map[string]any `json:"args,omitempty"`
+	}
+
+	traceFile struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}
+)
+
+// ExportTrace writes the timeline of the tracked tasks to w as Chrome
+// trace event JSON. Each pool is a process and each of its workers a
+// thread, showing when tasks ran on which worker; the time tasks waited
+// for a slot shows as an async span next to them. Tasks still running
+// end at the time of the export.
+func (tm *Manager) ExportTrace(w io.Writer) error {
+	now := tm.clock.Now()
+
+	records := tm.tasks.snapshot()
+	slices.SortFunc(records, func(a, b *taskRecord) int {
+		return cmp.Or(cmp.Compare(a.submitted.Load(), b.submitted.Load()), cmp.Compare(a.id.s, b.id.s))
+	})
+
+	// Processes are numbered by pool, the default pool first
+	names := append([]string{""}, slices.Sorted(maps.Keys(tm.pools))...)
+	pools := make(map[string]int, len(names))
+	events := make([]traceEvent, 0, len(records)*3+len(names))
+	for i, name := range names {
+		pools[name] = i + 1
+		if name == "" {
+			name = "default"
+		}
+		events = append(events, traceEvent{Name: "process_name", Phase: "M", PID: i + 1, Args: map[string]any{"name": "pool " + name}})
+	}
+
+	type thread struct{ pid, tid int }
+	threads := map[thread]bool{}
+	for _, rec := range records {
+		// Deferred tasks run as the task they're promoted to
+		if rec.deferred {
+			continue
+		}
+
+		submitted, started, finished := rec.times()
+		end := finished
+		if end.IsZero() {
+			end = now
+		}
+		pid := pools[rec.labels[PoolLabel]]
+		status := rec.loadStatus().String()
+
+		// Waiting for a slot, until the end for tasks that never started
+		waited := started
+		if waited.IsZero() {
+			waited = end
+		}
+		events = append(events,
+			traceEvent{Name: "wait", Category: "wait", Phase: "b", Time: traceTime(submitted), PID: pid, ID: rec.id.s},
+			traceEvent{Name: "wait", Category: "wait", Phase: "e", Time: traceTime(waited), PID: pid, ID: rec.id.s},
+		)
+
+		worker := int(rec.worker.Load())
+		if started.IsZero() || worker == 0 {
+			continue
+		}
+		if !threads[thread{pid, worker}] {
+			threads[thread{pid, worker}] = true
+			events = append(events, traceEvent{Name: "thread_name", Phase: "M", PID: pid, TID: worker, Args: map[string]any{"name": fmt.Sprintf("worker %d", worker)}})
+		}
+		args := map[string]any{"status": status, "wait": started.Sub(submitted).String()}
+		for key, value := range rec.labels {
+			args[key] = value
+		}
+		events = append(events, traceEvent{
+			Name:     rec.id.s,
+			Category: status,
+			Phase:    "X",
+			Time:     traceTime(started),
+			Duration: float64(end.Sub(started)) / float64(time.Microsecond),
+			PID:      pid,
+			TID:      worker,
+			Args:     args,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(traceFile{TraceEvents: events, DisplayTimeUnit: "ms"})
+}
+
+// traceTime returns t in microseconds since the Unix epoch.
+func traceTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Microsecond)
+}
@@ -0,0 +1,22 @@
+package asynctask
+
+import "context"
+
+type unboundedKey struct{}
+
+// WithUnbounded returns a derived context for tiny tasks, such as timers
+// and cheap transforms coordinating other tasks, which start right away
+// without taking a worker slot: neither a full pool nor the queue of a pool
+// executor holds them back, so coordinators can't end up waiting behind
+// the work they coordinate. Tasks they start with their own context take a
+// slot again, unless started with WithUnbounded too. Nothing limits how
+// many such tasks run at once, so heavy work doesn't belong in them.
+func WithUnbounded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unboundedKey{}, true)
+}
+
+// unbounded reports whether tasks submitted with ctx skip the worker limit.
+func unbounded(ctx context.Context) bool {
+	u, _ := ctx.Value(unboundedKey{}).(bool)
+	return u
+}
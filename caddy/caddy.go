@@ -0,0 +1,284 @@
+// Package caddy provides the frankenasync Caddy HTTP handler. It registers
+// the PHP extension with FrankenPHP and gives every request an
+// asynctask.Manager, so php_server and php handlers placed after it can
+// run Script::async() and friends:
+//
+//	{
+//		frankenphp
+//	}
+//
+//	example.com {
+//		root public/
+//		frankenasync {
+//			root public/
+//			workers 32
+//			max_depth 8
+//			log_capacity 50
+//			slow_task 5s
+//			disconnect_grace 10s
+//		}
+//		php_server
+//	}
+//
+// Build Caddy with both modules:
+//
+//	xcaddy build \
+//		--with github.com/dunglas/frankenphp/caddy \
+//		--with github.com/johanjanssens/frankenasync/caddy
+package caddy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/kvstore"
+	"github.com/johanjanssens/frankenasync/phpext"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dunglas/frankenphp"
+	"github.com/rs/xid"
+)
+
+func init() {
+	// Extensions must be registered before FrankenPHP starts
+	phpext.Register()
+
+	caddy.RegisterModule(Handler{})
+	httpcaddyfile.RegisterHandlerDirective("frankenasync", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("frankenasync", httpcaddyfile.Before, "encode")
+}
+
+// Handler gives each request its own task manager for the PHP handlers
+// after it. Root and MaxDepth are process-wide, as is the PHP extension,
+// so every frankenasync handler of a config should agree on them.
+type Handler struct {
+	// Document root of subrequests started with Script::async(). Should
+	// match the root of php_server. Defaults to the working directory.
+	Root string `json:"root,omitempty"`
+
+	// Concurrent subrequests per request. Defaults to the PHP thread pool
+	// size minus two, so subrequests can't starve their parents.
+	Workers int `json:"workers,omitempty"`
+
+	// Subrequest nesting limit. 0 disables the limit.
+	MaxDepth *int `json:"max_depth,omitempty"`
+
+	// Log records kept per task for Future::getLogs(). 0 disables capture.
+	LogCapacity *int `json:"log_capacity,omitempty"`
+
+	// Tasks running longer are logged at warn level. 0 disables it.
+	SlowTask caddy.Duration `json:"slow_task,omitempty"`
+
+	// How long tasks keep running after the client disconnects. 0 cancels
+	// them right away.
+	DisconnectGrace caddy.Duration `json:"disconnect_grace,omitempty"`
+
+	logger      *slog.Logger
+	logCapacity int
+	workers     func() int
+}
+
+// CaddyModule returns the Caddy module information.
+func (Handler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.frankenasync",
+		New: func() caddy.Module { return new(Handler) },
+	}
+}
+
+// Provision sets up the handler.
+func (h *Handler) Provision(ctx caddy.Context) error {
+	h.logger = ctx.Slogger()
+
+	root, err := filepath.Abs(h.Root)
+	if err != nil {
+		return fmt.Errorf("resolve root: %w", err)
+	}
+	phpext.DocumentRoot = root
+
+	if h.MaxDepth != nil {
+		phpext.MaxDepth = *h.MaxDepth
+	}
+
+	h.logCapacity = 50
+	if h.LogCapacity != nil {
+		h.logCapacity = *h.LogCapacity
+	}
+
+	// FrankenPHP starts after provisioning, so the thread pool is sized on
+	// the first request
+	h.workers = func() int { return h.Workers }
+	if h.Workers == 0 {
+		h.workers = sync.OnceValue(func() int {
+			state := frankenphp.DebugState()
+			return max(len(state.ThreadDebugStates)+state.ReservedThreadCount-2, 1)
+		})
+	}
+
+	// Reclaim expired keys in the server-global store until the config is
+	// unloaded
+	go phpext.SharedStore.PruneEvery(ctx, time.Minute)
+
+	return nil
+}
+
+// Validate ensures the handler's configuration is valid.
+func (h *Handler) Validate() error {
+	if h.Workers < 0 {
+		return errors.New("workers must not be negative")
+	}
+	if h.MaxDepth != nil && *h.MaxDepth < 0 {
+		return errors.New("max_depth must not be negative")
+	}
+	if h.LogCapacity != nil && *h.LogCapacity < 0 {
+		return errors.New("log_capacity must not be negative")
+	}
+	if h.SlowTask < 0 {
+		return errors.New("slow_task must not be negative")
+	}
+	if h.DisconnectGrace < 0 {
+		return errors.New("disconnect_grace must not be negative")
+	}
+	return nil
+}
+
+// ServeHTTP runs the rest of the route with a task manager in the request
+// context, and closes the manager as its shutdown policy says once it has
+// responded.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	// Generate the request's ID, and send it back so clients can correlate
+	// their logs with ours. A valid ID of the caller's is only kept as a
+	// label of its own, so callers can't pose as another request.
+	requestID := xid.New().String()
+	w.Header().Set("X-Request-ID", requestID)
+	labels := map[string]string{admin.RequestLabel: requestID}
+	if clientID := admin.ClientRequestID(r); clientID != "" {
+		labels[admin.ClientRequestLabel] = clientID
+	}
+
+	taskManager := asynctask.NewManager(
+		asynctask.WithWorkerLimit(h.workers()),
+		asynctask.WithLogger(h.logger.Handler()),
+		asynctask.WithLogCapacity(h.logCapacity),
+		asynctask.WithSlowTaskThreshold(time.Duration(h.SlowTask)),
+		asynctask.WithRequestID(requestID),
+	)
+
+	baseCtx, closeManager := taskManager.Bind(r.Context(), time.Duration(h.DisconnectGrace), nil)
+	defer closeManager()
+
+	// Store manager and request-scoped key-value store in request context,
+	// labelling every task with the request that started it
+	reqCtx := asynctask.WithContext(baseCtx, taskManager)
+	reqCtx = asynctask.WithLabels(reqCtx, labels)
+	reqCtx = kvstore.WithContext(reqCtx, kvstore.New())
+
+	return next.ServeHTTP(w, r.WithContext(reqCtx))
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens:
+//
+//	frankenasync {
+//		root <path>
+//		workers <n>
+//		max_depth <n>
+//		log_capacity <n>
+//		slow_task <duration>
+//		disconnect_grace <duration>
+//	}
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "root":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			h.Root = d.Val()
+		case "workers":
+			n, err := intArg(d)
+			if err != nil {
+				return err
+			}
+			h.Workers = n
+		case "max_depth":
+			n, err := intArg(d)
+			if err != nil {
+				return err
+			}
+			h.MaxDepth = &n
+		case "log_capacity":
+			n, err := intArg(d)
+			if err != nil {
+				return err
+			}
+			h.LogCapacity = &n
+		case "slow_task":
+			var value string
+			if !d.AllArgs(&value) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(value)
+			if err != nil {
+				return d.Errf("slow_task: %v", err)
+			}
+			h.SlowTask = caddy.Duration(dur)
+		case "disconnect_grace":
+			var value string
+			if !d.AllArgs(&value) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(value)
+			if err != nil {
+				return d.Errf("disconnect_grace: %v", err)
+			}
+			h.DisconnectGrace = caddy.Duration(dur)
+		default:
+			return d.Errf("unrecognized subdirective %q", d.Val())
+		}
+	}
+	return nil
+}
+
+// intArg reads the single numeric argument of a subdirective.
+func intArg(d *caddyfile.Dispenser) (int, error) {
+	name := d.Val()
+	var value string
+	if !d.AllArgs(&value) {
+		return 0, d.ArgErr()
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, d.Errf("%s: %q is not a number", name, value)
+	}
+	return n, nil
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	var handler Handler
+	err := handler.UnmarshalCaddyfile(h.Dispenser)
+	return &handler, err
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Handler)(nil)
+	_ caddy.Validator             = (*Handler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
+	_ caddyfile.Unmarshaler       = (*Handler)(nil)
+)
@@ -0,0 +1,15 @@
+module github.com/johanjanssens/frankenasync/caddy
+
+go 1.26.0
+
+require (
+	github.com/caddyserver/caddy/v2 v2.10.2
+	github.com/dunglas/frankenphp v1.11.3
+	github.com/johanjanssens/frankenasync v0.0.0-00010101000000-000000000000
+	github.com/rs/xid v1.6.0
+)
+
+replace (
+	github.com/dunglas/frankenphp v1.11.3 => ../../frankenphp
+	github.com/johanjanssens/frankenasync => ../
+)
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/config"
+	"github.com/johanjanssens/frankenasync/server"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+const usage = `Usage: frankenasync <command> [flags]
+
+Commands:
+  serve          Run the server (default)
+  check          Validate the configuration and boot PHP
+  tasks list     List the tasks of in-flight requests
+  tasks cancel   Cancel a task
+  version        Print version information
+
+Run 'frankenasync <command> -h' for the flags of a command.
+`
+
+// run dispatches the command line and returns the exit code.
+func run(args []string) int {
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		fs := newFlagSet("serve")
+		configPath := configFlag(fs)
+		fs.Parse(args)
+		serve(*configPath)
+		return 0
+	case "check":
+		return check(args)
+	case "tasks":
+		return tasks(args)
+	case "version":
+		printVersion(os.Stdout)
+		return 0
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		return 2
+	}
+}
+
+// newFlagSet returns flags for a command. Parse errors exit with status 2.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("frankenasync "+name, flag.ExitOnError)
+}
+
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", os.Getenv("FRANKENASYNC_CONFIG"), "configuration file (default "+config.DefaultPath+" if present)")
+}
+
+// check validates the configuration and boots PHP once, running the startup
+// script if any, so a broken deployment fails before it takes traffic.
+func check(args []string) int {
+	fs := newFlagSet("check")
+	configPath := configFlag(fs)
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration: %v\n", err)
+		return 1
+	}
+	if info, err := os.Stat(cfg.DocumentRoot); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "document_root: %s is not a directory\n", cfg.DocumentRoot)
+		return 1
+	}
+	fmt.Println("configuration ok")
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	srv, err := server.New(cfg, server.WithLogger(logger))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "php: %v\n", err)
+		return 1
+	}
+	threads := srv.Threads().Total
+	srv.Shutdown(context.Background())
+	fmt.Printf("php ok (%d threads)\n", threads)
+
+	return 0
+}
+
+// tasks lists or cancels tasks through the admin API of a running server.
+func tasks(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, "usage: frankenasync tasks list|cancel [flags]\n")
+		return 2
+	}
+	sub, args := args[0], args[1:]
+
+	fs := newFlagSet("tasks " + sub)
+	configPath := configFlag(fs)
+	addr := fs.String("addr", "", "admin API address (default admin.addr)")
+	token := fs.String("token", "", "admin token (default admin.token)")
+	status := fs.String("status", "", "comma-separated statuses to list, e.g. running,failed")
+	limit := fs.Int("limit", 0, "maximum number of tasks to list")
+	fs.Parse(args)
+
+	// Defaults come from the same configuration as the server's
+	if *addr == "" || *token == "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration: %v\n", err)
+			return 1
+		}
+		if *addr == "" {
+			*addr = cfg.Admin.Addr
+		}
+		if *token == "" {
+			*token = cfg.Admin.Token
+		}
+	}
+	if *addr == "" {
+		fmt.Fprint(os.Stderr, "admin API address not configured, set admin.addr or -addr\n")
+		return 1
+	}
+
+	client := admin.NewClient(adminURL(*addr), *token)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	switch sub {
+	case "list":
+		var statuses []string
+		if *status != "" {
+			statuses = strings.Split(*status, ",")
+		}
+		list, err := client.Tasks(ctx, statuses, *limit, 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		printTasks(os.Stdout, list.Tasks)
+		if list.Total > len(list.Tasks) {
+			fmt.Printf("(%d of %d tasks)\n", len(list.Tasks), list.Total)
+		}
+	case "cancel":
+		if fs.NArg() != 1 {
+			fmt.Fprint(os.Stderr, "usage: frankenasync tasks cancel [flags] <id>\n")
+			return 2
+		}
+		task, err := client.Cancel(ctx, fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		printTasks(os.Stdout, []admin.Task{task})
+	default:
+		fmt.Fprintf(os.Stderr, "unknown tasks command %q\n", sub)
+		return 2
+	}
+
+	return 0
+}
+
+// adminURL turns a listen address such as ":8082" into a URL to dial.
+func adminURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+func printTasks(w io.Writer, tasks []admin.Task) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tDURATION\tREQUEST\tERROR")
+	for _, task := range tasks {
+		duration := time.Duration(task.Duration * float64(time.Millisecond)).Round(time.Millisecond)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", task.ID, task.Status, duration, task.Request, task.Error)
+	}
+	tw.Flush()
+}
+
+func printVersion(w io.Writer) {
+	revision := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	fmt.Fprintf(w, "frankenasync %s (%s, %s %s/%s)\n", version, revision, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,354 @@
+// Package cluster lets the task managers of several nodes share their
+// work. Nodes gossip how many tasks they have room for, and a manager whose
+// pool is full forwards the tasks built from a spec to the peer with the
+// most room, through the peer's gRPC Tasks service, awaiting them there
+// and returning their results to the original awaiter. See
+// asynctask.WithForwarding.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/grpcapi/taskspb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// GossipPath is the route nodes exchange their capacity on.
+	GossipPath = "/_frankenasync/cluster/gossip"
+
+	// ForwardedLabel labels the tasks a node runs for another with the
+	// other's name. They're never forwarded again.
+	ForwardedLabel = "forwarded_from"
+
+	// cancelTimeout bounds canceling a forwarded task on its peer once
+	// its awaiter gave up.
+	cancelTimeout = 5 * time.Second
+)
+
+type (
+	// Peer is another node of the cluster.
+	Peer struct {
+		Name string
+		URL  string // base URL serving GossipPath, e.g. http://10.0.0.2:8082
+		Addr string // address of its gRPC Tasks service, e.g. 10.0.0.2:9090
+	}
+
+	// Config configures a Node. Zero fields take their defaults.
+	Config struct {
+		Node  string // name of this node, as its peers know it
+		Peers []Peer
+
+		// Bearer token of gossip and of the peers' gRPC services, shared
+		// by every node. It's sent in plaintext unless the peers' URLs are
+		// https and DialOptions carry TLS credentials, so plaintext
+		// clusters belong on a private network.
+		Token string
+
+		// Capacity returns how many more tasks this node takes from its
+		// peers, such as the free slots of the manager running them
+		Capacity func() int
+
+		Interval    time.Duration     // between gossip rounds, 1s by default
+		Client      *http.Client      // of gossip, http.DefaultClient by default
+		DialOptions []grpc.DialOption // of the peers' gRPC services, plaintext by default
+		Logger      *slog.Logger      // slog.Default() by default
+	}
+
+	// Report is the capacity a node gossips.
+	Report struct {
+		Node string    `json:"node"`
+		Free int       `json:"free"`
+		Time time.Time `json:"time"`
+	}
+
+	// Node is this node's view of the cluster. It's an asynctask.Forwarder,
+	// routing tasks to the peer that last reported the most room, as long
+	// as its report is fresh.
+	Node struct {
+		cfg   Config
+		mu    sync.Mutex
+		peers map[string]*peer
+	}
+
+	peer struct {
+		Peer
+		free     int       // reported, less the tasks forwarded since
+		reported time.Time // of the last report, by the peer's clock
+		seen     time.Time // when the last report arrived, by this node's clock
+		conn     *grpc.ClientConn
+		client   taskspb.TasksClient
+	}
+)
+
+// New returns the node cfg describes, without gossiping yet: see Run.
+func New(cfg Config) (*Node, error) {
+	if cfg.Node == "" {
+		return nil, errors.New("cluster: node name must not be empty")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("cluster: token must not be empty")
+	}
+	if cfg.Capacity == nil {
+		cfg.Capacity = func() int { return 0 }
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.DialOptions == nil {
+		cfg.DialOptions = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+		cfg.Logger.Warn("Cluster Token Sent In Plaintext", slog.String("reason", "gRPC without TLS"))
+	}
+
+	n := &Node{cfg: cfg, peers: make(map[string]*peer, len(cfg.Peers))}
+	for _, p := range cfg.Peers {
+		if p.Name == "" || p.Name == cfg.Node || n.peers[p.Name] != nil {
+			n.Close()
+			return nil, fmt.Errorf("cluster: peer name %q must be set and unique", p.Name)
+		}
+		if strings.HasPrefix(p.URL, "http://") {
+			cfg.Logger.Warn("Cluster Token Sent In Plaintext", slog.String("reason", "gossip over http"), slog.String("peer", p.Name))
+		}
+		conn, err := grpc.NewClient(p.Addr, cfg.DialOptions...)
+		if err != nil {
+			n.Close()
+			return nil, fmt.Errorf("cluster: peer %s: %w", p.Name, err)
+		}
+		n.peers[p.Name] = &peer{Peer: p, conn: conn, client: taskspb.NewTasksClient(conn)}
+	}
+	return n, nil
+}
+
+// Close closes the connections to the peers.
+func (n *Node) Close() error {
+	for _, p := range n.peers {
+		p.conn.Close()
+	}
+	return nil
+}
+
+// Run gossips with every peer each interval until ctx is done: it sends
+// this node's report and records the one the peer answers with.
+func (n *Node) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, p := range n.peers {
+			wg.Go(func() {
+				if err := n.gossip(ctx, p); err != nil && ctx.Err() == nil {
+					n.cfg.Logger.Debug("Cluster Gossip Failed", slog.String("peer", p.Name), slog.Any("error", err))
+				}
+			})
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// gossip exchanges reports with p.
+func (n *Node) gossip(ctx context.Context, p *peer) error {
+	ctx, cancel := context.WithTimeout(ctx, n.cfg.Interval)
+	defer cancel()
+
+	body, err := json.Marshal(n.report())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.URL, "/")+GossipPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.cfg.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	var report Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return err
+	}
+	n.record(report)
+	return nil
+}
+
+// Handler serves GossipPath, recording the report of the peer calling and
+// answering with this node's.
+func (n *Node) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(n.cfg.Token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var report Report
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			http.Error(w, "invalid report", http.StatusBadRequest)
+			return
+		}
+		n.record(report)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(n.report())
+	})
+}
+
+// Reports returns the last report of every peer heard from.
+func (n *Node) Reports() []Report {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var reports []Report
+	for _, p := range n.peers {
+		if !p.seen.IsZero() {
+			reports = append(reports, Report{Node: p.Name, Free: p.free, Time: p.seen})
+		}
+	}
+	return reports
+}
+
+func (n *Node) report() Report {
+	return Report{Node: n.cfg.Node, Free: max(n.cfg.Capacity(), 0), Time: time.Now()}
+}
+
+// record keeps report, if it's from a peer and newer than the last one.
+// Its freshness counts from when it arrived: the peer's clock only orders
+// its reports, so a peer whose clock runs ahead isn't trusted for longer.
+func (n *Node) record(report Report) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if p := n.peers[report.Node]; p != nil && report.Time.After(p.reported) {
+		p.free, p.reported, p.seen = report.Free, report.Time, time.Now()
+	}
+}
+
+// Route returns a runnable running the task spec describes on the peer
+// with the most room, counting it against the peer's room until it
+// reports again. Returns nil when no peer reported room within the last
+// three intervals, or for tasks forwarded to this node.
+func (n *Node) Route(spec asynctask.Spec, labels map[string]string) asynctask.Runnable {
+	if labels[ForwardedLabel] != "" {
+		return nil
+	}
+
+	n.mu.Lock()
+	var best *peer
+	stale := time.Now().Add(-3 * n.cfg.Interval)
+	for _, p := range n.peers {
+		if p.free > 0 && p.seen.After(stale) && (best == nil || p.free > best.free) {
+			best = p
+		}
+	}
+	if best == nil {
+		n.mu.Unlock()
+		return nil
+	}
+	best.free--
+	n.mu.Unlock()
+
+	return asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return n.forward(ctx, best, spec, labels)
+	})
+}
+
+// forward runs the task spec describes on p and awaits it. A task p can't
+// be given runs here after all, past the pool's limit, as it was only
+// forwarded to spare it waiting. Canceling ctx cancels the task on p.
+func (n *Node) forward(ctx context.Context, p *peer, spec asynctask.Spec, labels map[string]string) (any, error) {
+	args, err := structpb.NewStruct(spec.Params)
+	if err != nil {
+		return n.runHere(ctx, p, spec, err)
+	}
+	labels = maps.Clone(labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[ForwardedLabel] = n.cfg.Node
+
+	callCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+n.cfg.Token)
+	submitted, err := p.client.SubmitTask(callCtx, &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Runnable{Runnable: spec.Name},
+		Args:   args,
+		Labels: labels,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		n.mu.Lock()
+		p.free = 0 // until it reports again
+		n.mu.Unlock()
+		return n.runHere(ctx, p, spec, err)
+	}
+
+	task, err := p.client.AwaitTask(callCtx, &taskspb.AwaitTaskRequest{Id: submitted.GetId()})
+	if err != nil {
+		if ctx.Err() != nil {
+			cancelCtx, cancel := context.WithTimeout(context.WithoutCancel(callCtx), cancelTimeout)
+			defer cancel()
+			_, _ = p.client.CancelTask(cancelCtx, &taskspb.CancelTaskRequest{Id: submitted.GetId()})
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("cluster: awaiting task %s on %s: %w", submitted.GetId(), p.Name, err)
+	}
+
+	switch task.GetStatus() {
+	case asynctask.StatusCompleted.String():
+		if task.GetResult() == nil {
+			return nil, nil
+		}
+		return task.GetResult().AsInterface(), nil
+	case asynctask.StatusCanceled.String():
+		return nil, fmt.Errorf("%w on %s: %s", asynctask.ErrTaskCanceled, p.Name, task.GetError())
+	default:
+		return nil, fmt.Errorf("on %s: %s", p.Name, task.GetError())
+	}
+}
+
+// runHere runs the task spec describes on this node, as p couldn't be
+// given it.
+func (n *Node) runHere(ctx context.Context, p *peer, spec asynctask.Spec, cause error) (any, error) {
+	n.cfg.Logger.Warn("Task Not Forwarded", slog.String("peer", p.Name), slog.String("runnable", spec.Name), slog.Any("error", cause))
+	runnable, err := spec.Runnable()
+	if err != nil {
+		return nil, err
+	}
+	return runnable.Run(ctx)
+}
@@ -0,0 +1,201 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/grpcapi"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func init() {
+	asynctask.RegisterRunnable("cluster.sum", func(params map[string]any) (asynctask.Runnable, error) {
+		a, ok1 := params["a"].(float64)
+		b, ok2 := params["b"].(float64)
+		if !ok1 || !ok2 {
+			return nil, errors.New("a and b must be numbers")
+		}
+		return asynctask.RunnableFunc(func(context.Context) (any, error) {
+			return map[string]any{"sum": a + b}, nil
+		}), nil
+	})
+}
+
+// serve serves the Tasks service of tm in memory, returning the dial
+// options reaching it.
+func serve(t *testing.T, tm *asynctask.Manager, token string) []grpc.DialOption {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpcapi.NewService(context.Background(), tm, grpcapi.WithToken(token)).Server()
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}
+}
+
+func newNode(t *testing.T, cfg Config) *Node {
+	t.Helper()
+	n, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// Test that nodes learn each other's capacity, with the shared token only
+func TestNode_Gossip(t *testing.T) {
+	b := newNode(t, Config{Node: "b", Token: "secret", Peers: []Peer{{Name: "a", Addr: "passthrough:///a"}}, Capacity: func() int { return 3 }})
+	server := httptest.NewServer(b.Handler())
+	t.Cleanup(server.Close)
+
+	a := newNode(t, Config{Node: "a", Token: "secret", Peers: []Peer{{Name: "b", URL: server.URL, Addr: "passthrough:///b"}}, Capacity: func() int { return 5 }})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+	for len(a.Reports()) == 0 || len(b.Reports()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := a.Reports()[0]; got.Node != "b" || got.Free != 3 {
+		t.Fatalf("got report %+v, want b with 3 free", got)
+	}
+	if got := b.Reports()[0]; got.Node != "a" || got.Free != 5 {
+		t.Fatalf("got report %+v, want a with 5 free", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, GossipPath, strings.NewReader(`{"node":"a","free":1}`))
+	req.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	b.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+// Test that a saturated manager runs tasks on the peer with room
+func TestNode_Forward(t *testing.T) {
+	ctx := context.Background()
+
+	remote := asynctask.NewManager()
+	t.Cleanup(func() { remote.Shutdown(ctx) })
+
+	a := newNode(t, Config{Node: "a", Token: "secret", Peers: []Peer{{Name: "b", Addr: "passthrough:///b"}}, DialOptions: serve(t, remote, "secret")})
+	a.record(Report{Node: "b", Free: 1, Time: time.Now()})
+
+	tm := asynctask.NewManager(asynctask.WithWorkerLimit(1), asynctask.WithForwarding(a))
+	t.Cleanup(func() { tm.Shutdown(ctx) })
+
+	release := make(chan struct{})
+	blocker := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}))
+
+	id, err := tm.Submit(ctx, asynctask.Spec{Name: "cluster.sum", Params: map[string]any{"a": 1.0, "b": 2.0}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	future, err := tm.Await(ctx, id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := future.Result.(map[string]any)["sum"]; got != float64(3) {
+		t.Fatalf("got sum %v, want 3", got)
+	}
+	if got := tm.Stats().Forwarded; got != 1 {
+		t.Fatalf("got %d forwarded, want 1", got)
+	}
+	if got := remote.Stats().Completed; got != 1 {
+		t.Fatalf("got %d completed remotely, want 1", got)
+	}
+
+	// The peer's room is used up until it reports again, and forwarded
+	// tasks stay where they are
+	if a.Route(asynctask.Spec{Name: "cluster.sum"}, nil) != nil {
+		t.Fatal("routed to a peer without room")
+	}
+	a.record(Report{Node: "b", Free: 1, Time: time.Now()})
+	if a.Route(asynctask.Spec{Name: "cluster.sum"}, map[string]string{ForwardedLabel: "c"}) != nil {
+		t.Fatal("routed a forwarded task")
+	}
+
+	close(release)
+	if _, err := tm.Await(ctx, blocker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Test that tasks a peer can't be given run here
+func TestNode_ForwardFallback(t *testing.T) {
+	a := newNode(t, Config{Node: "a", Token: "secret", Peers: []Peer{{Name: "b", Addr: "passthrough:///b"}}, DialOptions: []grpc.DialOption{
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return nil, errors.New("unreachable")
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}})
+	a.record(Report{Node: "b", Free: 2, Time: time.Now()})
+
+	runnable := a.Route(asynctask.Spec{Name: "cluster.sum", Params: map[string]any{"a": 2.0, "b": 2.0}}, nil)
+	if runnable == nil {
+		t.Fatal("not routed")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := runnable.Run(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := result.(map[string]any)["sum"]; got != float64(4) {
+		t.Fatalf("got sum %v, want 4", got)
+	}
+	if a.Route(asynctask.Spec{Name: "cluster.sum"}, nil) != nil {
+		t.Fatal("routed to an unreachable peer")
+	}
+}
+
+// Test that reports are fresh from when they arrive, whatever the clock of
+// the peer sending them
+func TestNode_ClockSkew(t *testing.T) {
+	a := newNode(t, Config{Node: "a", Token: "secret", Peers: []Peer{{Name: "b", Addr: "passthrough:///b"}}, Interval: 50 * time.Millisecond})
+
+	// A peer running behind is routed to
+	a.record(Report{Node: "b", Free: 1, Time: time.Now().Add(-time.Hour)})
+	if a.Route(asynctask.Spec{Name: "cluster.sum"}, nil) == nil {
+		t.Fatal("fresh report of a peer running behind ignored")
+	}
+
+	// A peer running ahead isn't once it stopped reporting
+	a.record(Report{Node: "b", Free: 1, Time: time.Now().Add(time.Hour)})
+	time.Sleep(200 * time.Millisecond)
+	if a.Route(asynctask.Spec{Name: "cluster.sum"}, nil) != nil {
+		t.Fatal("stale report of a peer running ahead routed to")
+	}
+
+	// Older reports than the last one are dropped
+	a.record(Report{Node: "b", Free: 1, Time: time.Now()})
+	if a.Route(asynctask.Spec{Name: "cluster.sum"}, nil) != nil {
+		t.Fatal("out of order report recorded")
+	}
+}
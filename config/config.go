@@ -0,0 +1,896 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/logging"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is the configuration file loaded when no path is given and
+// the file exists.
+const DefaultPath = "frankenasync.yaml"
+
+type (
+	// Config holds the server settings. Zero values for Threads and Workers
+	// mean they're derived from the number of CPUs at startup.
+	Config struct {
+		Addr         string            `yaml:"addr"`
+		DocumentRoot string            `yaml:"document_root"`
+		Threads      int               `yaml:"threads"`
+		Workers      int               `yaml:"workers"`
+		Pools        map[string]int    `yaml:"pools"` // named worker pools and their sizes
+		Encoding     string            `yaml:"encoding"`
+		LogLevel     string            `yaml:"log_level"`
+		LogLevels    map[string]string `yaml:"log_levels"`   // levels of components overriding log_level
+		LogSampling  int               `yaml:"log_sampling"` // log 1 in n task debug records, 0 for all
+		AccessLog    bool              `yaml:"access_log"`   // log a line per request, with its tasks
+		PHPIni       map[string]string `yaml:"php_ini"`
+		Startup      Startup           `yaml:"startup"`
+		TLS          TLS               `yaml:"tls"`
+		Static       Static            `yaml:"static"`
+		RateLimit    RateLimit         `yaml:"rate_limit"`
+		MockAPI      MockAPI           `yaml:"mock_api"`
+		Locks        Locks             `yaml:"locks"`
+		Exec         Exec              `yaml:"exec"`
+		Admin        Admin             `yaml:"admin"`
+		Listeners    []Listener        `yaml:"listeners"`
+		GRPC         GRPC              `yaml:"grpc"`
+		Cluster      Cluster           `yaml:"cluster"`
+		Tasks        Tasks             `yaml:"tasks"`
+	}
+
+	// TLS configures TLS termination on addr, from a certificate and key or
+	// from ACME (Let's Encrypt) certificates for Domains. HTTP/2 is enabled
+	// with TLS, HTTP/3 additionally listens on UDP.
+	TLS struct {
+		Cert     string   `yaml:"cert"`
+		Key      string   `yaml:"key"`
+		Domains  []string `yaml:"domains"`
+		CacheDir string   `yaml:"cache_dir"` // ACME certificate cache
+		HTTP3    bool     `yaml:"http3"`
+	}
+
+	// Startup configures what runs once PHP booted, before the server takes
+	// traffic: the script it refuses to start without, and the warmup
+	// requests filling opcache and the application's caches.
+	Startup struct {
+		Script  string        `yaml:"script"`  // path below document_root, none when empty
+		Timeout time.Duration `yaml:"timeout"` // of the script, and of the warmup, 0 for no limit
+		Preload string        `yaml:"preload"` // opcache.preload script, relative to document_root
+		Warmup  []string      `yaml:"warmup"`  // paths requested concurrently, e.g. "/" or "/search.php?q=a"
+	}
+
+	// Static configures the files served from the document root without PHP.
+	Static struct {
+		MaxAge time.Duration `yaml:"max_age"` // 0 makes clients revalidate every request
+	}
+
+	// RateLimit limits the requests per second reaching PHP, over all
+	// clients and per client IP, allowing bursts above those rates. A rate
+	// of 0 disables its limit, a burst of 0 equals its rate.
+	RateLimit struct {
+		Rate       int `yaml:"rate"`
+		Burst      int `yaml:"burst"`
+		PerIP      int `yaml:"per_ip"`
+		PerIPBurst int `yaml:"per_ip_burst"`
+	}
+
+	// MockAPI configures the simulated remote API used by demos and load
+	// tests.
+	MockAPI struct {
+		Enabled     bool        `yaml:"enabled"`
+		Latency     MockLatency `yaml:"latency"`
+		ErrorRate   float64     `yaml:"error_rate"` // share of requests failed, 0 to 1
+		ErrorStatus int         `yaml:"error_status"`
+		Routes      []MockRoute `yaml:"routes"` // JSONPlaceholder comments when empty
+	}
+
+	// MockLatency is the distribution mock API delays are drawn from: fixed,
+	// uniform, normal or exponential.
+	MockLatency struct {
+		Distribution string        `yaml:"distribution"`
+		Min          time.Duration `yaml:"min"`
+		Max          time.Duration `yaml:"max"`
+		Mean         time.Duration `yaml:"mean"`
+		StdDev       time.Duration `yaml:"stddev"`
+	}
+
+	// MockRoute renders Template, a Go text/template, for requests to Path.
+	MockRoute struct {
+		Path        string `yaml:"path"`
+		Status      int    `yaml:"status"`
+		ContentType string `yaml:"content_type"`
+		Template    string `yaml:"template"`
+	}
+
+	// Locks configures the lock backend.
+	Locks struct {
+		Redis string `yaml:"redis"` // in-process when empty
+	}
+
+	// Exec configures the external commands PHP may run as tasks.
+	Exec struct {
+		Allow     []string      `yaml:"allow"`      // command names or absolute paths, none when empty
+		EnvAllow  []string      `yaml:"env_allow"`  // environment variables PHP may set, none when empty
+		Dirs      []string      `yaml:"dirs"`       // directories commands may run in, and below
+		CPU       time.Duration `yaml:"cpu"`        // CPU time of a command, 0 for no limit
+		Memory    int           `yaml:"memory"`     // bytes of address space of a command, 0 for no limit
+		OpenFiles int           `yaml:"open_files"` // open files of a command, 0 for no limit
+		FileSize  int           `yaml:"file_size"`  // bytes of the files a command writes, 0 for no limit
+	}
+
+	// Admin configures the admin API listener.
+	Admin struct {
+		Addr    string `yaml:"addr"` // disabled when empty
+		Token   string `yaml:"token"`
+		Debug   bool   `yaml:"debug"`
+		AuthAll bool   `yaml:"auth_all"` // require auth to read state too
+
+		// TLS of the admin listener. With a client CA, clients must present
+		// a certificate it signed, which stands in for the token.
+		Cert     string `yaml:"cert"`
+		Key      string `yaml:"key"`
+		ClientCA string `yaml:"client_ca"`
+
+		// Origins of pages allowed to call the admin API, "*" for any
+		CORSOrigins []string `yaml:"cors_origins"`
+	}
+
+	// Listener is a further listener serving one role: app (the site, as on
+	// addr), admin (the admin API, configured by admin) or metrics
+	// (Prometheus metrics and health, and profiling with admin.debug).
+	Listener struct {
+		Addr      string `yaml:"addr"`
+		Role      string `yaml:"role"`
+		AccessLog bool   `yaml:"access_log"` // log its requests, app listeners also when access_log is on
+		Auth      bool   `yaml:"auth"`       // require admin.token, or a client certificate, on every route
+
+		// TLS, and with a client CA, certificates clients must present
+		Cert     string `yaml:"cert"`
+		Key      string `yaml:"key"`
+		ClientCA string `yaml:"client_ca"`
+	}
+
+	// GRPC configures the gRPC task API listener.
+	GRPC struct {
+		Addr    string `yaml:"addr"` // disabled when empty
+		Token   string `yaml:"token"`
+		Workers int    `yaml:"workers"` // concurrent tasks, derived from threads when 0
+	}
+
+	// Cluster configures forwarding the tasks a saturated server can't
+	// start to peer servers with room. Peers gossip their room on the admin
+	// listener and run the tasks through their gRPC listener, both
+	// authenticated with grpc.token, shared by every node.
+	Cluster struct {
+		Node     string        `yaml:"node"`     // name of this server, as its peers know it
+		Peers    []ClusterPeer `yaml:"peers"`    // disabled when empty
+		Interval time.Duration `yaml:"interval"` // between gossip rounds
+	}
+
+	// ClusterPeer is another server of the cluster.
+	ClusterPeer struct {
+		Name string `yaml:"name"`
+		URL  string `yaml:"url"`  // of its admin listener, e.g. http://10.0.0.2:8082
+		Addr string `yaml:"addr"` // of its gRPC listener, e.g. 10.0.0.2:9090
+	}
+
+	// Tasks holds the defaults applied to every request's tasks.
+	Tasks struct {
+		MaxDepth    int           `yaml:"max_depth"`
+		LogCapacity int           `yaml:"log_capacity"`
+		History     int           `yaml:"history"`      // finished tasks the admin API keeps across requests, 0 for none
+		PruneTTL    time.Duration `yaml:"prune_ttl"`    // 0 keeps finished tasks until the request ends
+		MaxTasks    int           `yaml:"max_tasks"`    // tasks one request may start, 0 for no limit
+		Budget      time.Duration `yaml:"budget"`       // time one request's tasks may run together, 0 for no limit
+		AwaitBudget time.Duration `yaml:"await_budget"` // time one request may spend awaiting, 0 for no limit
+		MaxMemory   int           `yaml:"max_memory"`   // bytes one task's result may hold, 0 for no limit
+		SlowTask    time.Duration `yaml:"slow_task"`    // log tasks running longer, 0 to disable
+		Profiling   bool          `yaml:"profiling"`    // label task goroutines by task labels and record their CPU time
+
+		// Tasks that don't call heartbeat for stall_timeout are stalled,
+		// and start over up to stall_restarts times. 0 disables detection.
+		StallTimeout  time.Duration `yaml:"stall_timeout"`
+		StallRestarts int           `yaml:"stall_restarts"`
+
+		// What happens to the tasks a request leaves running when it ends:
+		// cancel, wait or detach. PHP can change it per request.
+		Shutdown        string        `yaml:"shutdown"`
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // how long wait and detach wait, 0 for no limit
+		DisconnectGrace time.Duration `yaml:"disconnect_grace"` // how long tasks run once the client is gone
+
+		// Summarize each request's tasks in X-FrankenAsync-* response headers
+		DiagnosticHeaders bool `yaml:"diagnostic_headers"`
+
+		Offload Offload   `yaml:"offload"`
+		Store   TaskStore `yaml:"store"`
+
+		// AES keys sealing the specs and results the store and offload
+		// keep, as id:base64 of 16, 24 or 32 bytes. The first seals, the
+		// others only open what older keys sealed, so keys are rotated by
+		// prepending a new one. Unsealed when empty.
+		EncryptionKeys []string `yaml:"encryption_keys"`
+	}
+
+	// TaskStore persists every task of the server's managers, in SQLite
+	// or PostgreSQL. Servers sharing a Postgres database also run the
+	// tasks queued in it.
+	TaskStore struct {
+		SQLite       string        `yaml:"sqlite"`        // path of the database
+		Postgres     string        `yaml:"postgres"`      // connection string of the database
+		Retention    time.Duration `yaml:"retention"`     // how long finished tasks are kept, 0 for ever
+		QueueWorkers int           `yaml:"queue_workers"` // queued tasks run at once, 0 to run none
+	}
+
+	// Offload moves task results over a size to S3-compatible storage,
+	// fetching them again when awaited.
+	Offload struct {
+		Threshold int           `yaml:"threshold"` // bytes of a result before it's offloaded, disabled when 0
+		TTL       time.Duration `yaml:"ttl"`       // how long offloaded results are kept, 0 until their task is pruned
+		S3        S3            `yaml:"s3"`
+	}
+
+	// S3 locates a bucket of S3-compatible storage.
+	S3 struct {
+		Endpoint  string `yaml:"endpoint"` // e.g. https://s3.eu-west-1.amazonaws.com
+		Region    string `yaml:"region"`   // us-east-1 when empty
+		Bucket    string `yaml:"bucket"`
+		Prefix    string `yaml:"prefix"`
+		AccessKey string `yaml:"access_key"`
+		SecretKey string `yaml:"secret_key"`
+		PathStyle bool   `yaml:"path_style"` // bucket in the path rather than the host, for MinIO
+	}
+)
+
+// Default returns the configuration used when neither a file nor
+// environment variables override it.
+func Default() *Config {
+	return &Config{
+		Addr:         ":8081",
+		DocumentRoot: "examples",
+		Encoding:     "json",
+		LogLevel:     "debug",
+		Startup: Startup{
+			Timeout: 30 * time.Second,
+		},
+		TLS: TLS{
+			CacheDir: "certs",
+		},
+		MockAPI: MockAPI{
+			Latency: MockLatency{
+				Distribution: "uniform",
+				Min:          50 * time.Millisecond,
+				Max:          150 * time.Millisecond,
+			},
+			ErrorStatus: 503,
+		},
+		Cluster: Cluster{
+			Interval: time.Second,
+		},
+		Tasks: Tasks{
+			MaxDepth:        8,
+			LogCapacity:     50,
+			History:         1000,
+			Shutdown:        "cancel",
+			ShutdownTimeout: 30 * time.Second,
+		},
+	}
+}
+
+// Load reads the YAML file at path over the defaults, applies environment
+// variable overrides and validates the result. An empty path loads
+// DefaultPath if it exists.
+func Load(path string) (*Config, error) {
+	c := Default()
+
+	if path == "" {
+		if _, err := os.Stat(DefaultPath); err == nil {
+			path = DefaultPath
+		}
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.decode(data, os.LookupEnv); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := c.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// decode merges a YAML document into c, after interpolating the variables
+// returned by lookup, rejecting unknown keys so typos don't go unnoticed.
+func (c *Config) decode(data []byte, lookup func(string) (string, bool)) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	replaced, err := interpolate(&doc, lookup)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		if data, err = yaml.Marshal(&doc); err != nil {
+			return err
+		}
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	// An empty file leaves the defaults untouched
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// ApplyEnv overrides settings with the FRANKENASYNC_* environment variables
+// returned by lookup. Each can be read from the file named by the variable
+// with a _FILE suffix instead, e.g. FRANKENASYNC_ADMIN_TOKEN_FILE.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	lookup = withSecrets(lookup, &errs)
+
+	str := func(name string, dst *string) {
+		if v, ok := lookup(name); ok && v != "" {
+			*dst = v
+		}
+	}
+	num := func(name string, dst *int) {
+		if v, ok := lookup(name); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a number", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	duration := func(name string, dst *time.Duration) {
+		if v, ok := lookup(name); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a duration", name, v))
+				return
+			}
+			*dst = d
+		}
+	}
+	flag := func(name string, dst *bool) {
+		if v, ok := lookup(name); ok && v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a boolean", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	if v, ok := lookup("FRANKENASYNC_PORT"); ok && v != "" {
+		c.Addr = ":" + v
+	}
+	str("FRANKENASYNC_DOCUMENT_ROOT", &c.DocumentRoot)
+	num("FRANKENASYNC_THREADS", &c.Threads)
+	num("FRANKENASYNC_WORKERS", &c.Workers)
+	if v, ok := lookup("FRANKENASYNC_POOLS"); ok && v != "" {
+		c.Pools = make(map[string]int)
+		for _, pool := range strings.Split(v, ",") {
+			name, size, _ := strings.Cut(pool, "=")
+			n, err := strconv.Atoi(size)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("FRANKENASYNC_POOLS: %q is not name=size", pool))
+				continue
+			}
+			c.Pools[strings.TrimSpace(name)] = n
+		}
+	}
+	num("FRANKENASYNC_MAX_DEPTH", &c.Tasks.MaxDepth)
+	num("FRANKENASYNC_LOG_CAPACITY", &c.Tasks.LogCapacity)
+	num("FRANKENASYNC_TASK_HISTORY", &c.Tasks.History)
+	duration("FRANKENASYNC_PRUNE_TTL", &c.Tasks.PruneTTL)
+	num("FRANKENASYNC_MAX_TASKS", &c.Tasks.MaxTasks)
+	duration("FRANKENASYNC_TASK_BUDGET", &c.Tasks.Budget)
+	duration("FRANKENASYNC_AWAIT_BUDGET", &c.Tasks.AwaitBudget)
+	num("FRANKENASYNC_MAX_TASK_MEMORY", &c.Tasks.MaxMemory)
+	duration("FRANKENASYNC_SLOW_TASK", &c.Tasks.SlowTask)
+	duration("FRANKENASYNC_STALL_TIMEOUT", &c.Tasks.StallTimeout)
+	num("FRANKENASYNC_STALL_RESTARTS", &c.Tasks.StallRestarts)
+	flag("FRANKENASYNC_TASK_PROFILING", &c.Tasks.Profiling)
+	str("FRANKENASYNC_SHUTDOWN", &c.Tasks.Shutdown)
+	duration("FRANKENASYNC_SHUTDOWN_TIMEOUT", &c.Tasks.ShutdownTimeout)
+	duration("FRANKENASYNC_DISCONNECT_GRACE", &c.Tasks.DisconnectGrace)
+	flag("FRANKENASYNC_DIAGNOSTIC_HEADERS", &c.Tasks.DiagnosticHeaders)
+	num("FRANKENASYNC_OFFLOAD_THRESHOLD", &c.Tasks.Offload.Threshold)
+	duration("FRANKENASYNC_OFFLOAD_TTL", &c.Tasks.Offload.TTL)
+	str("FRANKENASYNC_S3_ENDPOINT", &c.Tasks.Offload.S3.Endpoint)
+	str("FRANKENASYNC_S3_REGION", &c.Tasks.Offload.S3.Region)
+	str("FRANKENASYNC_S3_BUCKET", &c.Tasks.Offload.S3.Bucket)
+	str("FRANKENASYNC_S3_PREFIX", &c.Tasks.Offload.S3.Prefix)
+	str("FRANKENASYNC_S3_ACCESS_KEY", &c.Tasks.Offload.S3.AccessKey)
+	str("FRANKENASYNC_S3_SECRET_KEY", &c.Tasks.Offload.S3.SecretKey)
+	flag("FRANKENASYNC_S3_PATH_STYLE", &c.Tasks.Offload.S3.PathStyle)
+	str("FRANKENASYNC_STORE_SQLITE", &c.Tasks.Store.SQLite)
+	str("FRANKENASYNC_STORE_POSTGRES", &c.Tasks.Store.Postgres)
+	duration("FRANKENASYNC_STORE_RETENTION", &c.Tasks.Store.Retention)
+	num("FRANKENASYNC_QUEUE_WORKERS", &c.Tasks.Store.QueueWorkers)
+	if v, ok := lookup("FRANKENASYNC_ENCRYPTION_KEYS"); ok && v != "" {
+		c.Tasks.EncryptionKeys = strings.Split(v, ",")
+	}
+	str("FRANKENASYNC_ENCODING", &c.Encoding)
+	str("FRANKENASYNC_LOG_LEVEL", &c.LogLevel)
+	if v, ok := lookup("FRANKENASYNC_LOG_LEVELS"); ok && v != "" {
+		c.LogLevels = make(map[string]string)
+		for _, level := range strings.Split(v, ",") {
+			component, name, ok := strings.Cut(level, "=")
+			if !ok {
+				errs = append(errs, fmt.Errorf("FRANKENASYNC_LOG_LEVELS: %q is not component=level", level))
+				continue
+			}
+			c.LogLevels[strings.TrimSpace(component)] = strings.TrimSpace(name)
+		}
+	}
+	num("FRANKENASYNC_LOG_SAMPLING", &c.LogSampling)
+	flag("FRANKENASYNC_ACCESS_LOG", &c.AccessLog)
+	str("FRANKENASYNC_STARTUP_SCRIPT", &c.Startup.Script)
+	duration("FRANKENASYNC_STARTUP_TIMEOUT", &c.Startup.Timeout)
+	str("FRANKENASYNC_PRELOAD", &c.Startup.Preload)
+	if v, ok := lookup("FRANKENASYNC_WARMUP"); ok && v != "" {
+		c.Startup.Warmup = strings.Split(v, ",")
+	}
+	str("FRANKENASYNC_TLS_CERT", &c.TLS.Cert)
+	str("FRANKENASYNC_TLS_KEY", &c.TLS.Key)
+	if v, ok := lookup("FRANKENASYNC_TLS_DOMAINS"); ok && v != "" {
+		c.TLS.Domains = strings.Split(v, ",")
+	}
+	flag("FRANKENASYNC_HTTP3", &c.TLS.HTTP3)
+	duration("FRANKENASYNC_STATIC_MAX_AGE", &c.Static.MaxAge)
+	num("FRANKENASYNC_RATE_LIMIT", &c.RateLimit.Rate)
+	num("FRANKENASYNC_RATE_LIMIT_BURST", &c.RateLimit.Burst)
+	num("FRANKENASYNC_RATE_LIMIT_PER_IP", &c.RateLimit.PerIP)
+	num("FRANKENASYNC_RATE_LIMIT_PER_IP_BURST", &c.RateLimit.PerIPBurst)
+	flag("FRANKENASYNC_MOCK_API", &c.MockAPI.Enabled)
+	str("FRANKENASYNC_LOCK_REDIS", &c.Locks.Redis)
+	if v, ok := lookup("FRANKENASYNC_EXEC_ALLOW"); ok && v != "" {
+		c.Exec.Allow = strings.Split(v, ",")
+	}
+	if v, ok := lookup("FRANKENASYNC_EXEC_ENV_ALLOW"); ok && v != "" {
+		c.Exec.EnvAllow = strings.Split(v, ",")
+	}
+	if v, ok := lookup("FRANKENASYNC_EXEC_DIRS"); ok && v != "" {
+		c.Exec.Dirs = strings.Split(v, ",")
+	}
+	duration("FRANKENASYNC_EXEC_CPU", &c.Exec.CPU)
+	num("FRANKENASYNC_EXEC_MEMORY", &c.Exec.Memory)
+	num("FRANKENASYNC_EXEC_OPEN_FILES", &c.Exec.OpenFiles)
+	num("FRANKENASYNC_EXEC_FILE_SIZE", &c.Exec.FileSize)
+	str("FRANKENASYNC_ADMIN_ADDR", &c.Admin.Addr)
+	str("FRANKENASYNC_ADMIN_TOKEN", &c.Admin.Token)
+	flag("FRANKENASYNC_ADMIN_DEBUG", &c.Admin.Debug)
+	flag("FRANKENASYNC_ADMIN_AUTH_ALL", &c.Admin.AuthAll)
+	str("FRANKENASYNC_ADMIN_CERT", &c.Admin.Cert)
+	str("FRANKENASYNC_ADMIN_KEY", &c.Admin.Key)
+	str("FRANKENASYNC_ADMIN_CLIENT_CA", &c.Admin.ClientCA)
+	if v, ok := lookup("FRANKENASYNC_ADMIN_CORS_ORIGINS"); ok && v != "" {
+		c.Admin.CORSOrigins = strings.Split(v, ",")
+	}
+	if v, ok := lookup("FRANKENASYNC_LISTENERS"); ok && v != "" {
+		c.Listeners = nil
+		for _, listener := range strings.Split(v, ",") {
+			role, addr, ok := strings.Cut(listener, "=")
+			if !ok {
+				errs = append(errs, fmt.Errorf("FRANKENASYNC_LISTENERS: %q is not role=addr", listener))
+				continue
+			}
+			c.Listeners = append(c.Listeners, Listener{Role: strings.TrimSpace(role), Addr: strings.TrimSpace(addr)})
+		}
+	}
+	str("FRANKENASYNC_GRPC_ADDR", &c.GRPC.Addr)
+	str("FRANKENASYNC_GRPC_TOKEN", &c.GRPC.Token)
+	num("FRANKENASYNC_GRPC_WORKERS", &c.GRPC.Workers)
+	str("FRANKENASYNC_CLUSTER_NODE", &c.Cluster.Node)
+	if v, ok := lookup("FRANKENASYNC_CLUSTER_PEERS"); ok && v != "" {
+		c.Cluster.Peers = nil
+		for _, peer := range strings.Split(v, ",") {
+			name, addrs, ok1 := strings.Cut(peer, "=")
+			base, addr, ok2 := strings.Cut(addrs, "|")
+			if !ok1 || !ok2 {
+				errs = append(errs, fmt.Errorf("FRANKENASYNC_CLUSTER_PEERS: %q is not name=url|addr", peer))
+				continue
+			}
+			c.Cluster.Peers = append(c.Cluster.Peers, ClusterPeer{Name: strings.TrimSpace(name), URL: strings.TrimSpace(base), Addr: strings.TrimSpace(addr)})
+		}
+	}
+	duration("FRANKENASYNC_CLUSTER_INTERVAL", &c.Cluster.Interval)
+
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once, each prefixed with its
+// YAML key.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		fail("addr", "invalid listen address %q", c.Addr)
+	}
+	if c.DocumentRoot == "" {
+		fail("document_root", "must not be empty")
+	}
+	if c.Threads < 0 {
+		fail("threads", "must not be negative")
+	}
+	if c.Workers < 0 {
+		fail("workers", "must not be negative")
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Pools)) {
+		if name == "" {
+			fail("pools", "names must not be empty")
+		} else if c.Pools[name] < 1 {
+			fail("pools."+name, "must be at least 1")
+		}
+	}
+	if c.Encoding != "json" && c.Encoding != "msgpack" && c.Encoding != "php" {
+		fail("encoding", "must be json, msgpack or php, got %q", c.Encoding)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		fail("log_level", "must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	for _, component := range slices.Sorted(maps.Keys(c.LogLevels)) {
+		if !slices.Contains(logging.Components, component) {
+			fail("log_levels."+component, "unknown component, must be one of %s", strings.Join(logging.Components, ", "))
+		} else if err := level.UnmarshalText([]byte(c.LogLevels[component])); err != nil {
+			fail("log_levels."+component, "must be debug, info, warn or error, got %q", c.LogLevels[component])
+		}
+	}
+	if c.LogSampling < 0 {
+		fail("log_sampling", "must not be negative")
+	}
+	if c.Startup.Script != "" && !strings.HasSuffix(c.Startup.Script, ".php") {
+		fail("startup.script", "must be a .php file, got %q", c.Startup.Script)
+	}
+	if c.Startup.Timeout < 0 {
+		fail("startup.timeout", "must not be negative")
+	}
+	if c.Startup.Preload != "" && !strings.HasSuffix(c.Startup.Preload, ".php") {
+		fail("startup.preload", "must be a .php file, got %q", c.Startup.Preload)
+	}
+	for i, target := range c.Startup.Warmup {
+		if u, err := url.Parse(target); err != nil || (u.Scheme == "" && !strings.HasPrefix(target, "/")) {
+			fail(fmt.Sprintf("startup.warmup[%d]", i), "must be a path starting with / or a URL, got %q", target)
+		}
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		fail("tls", "cert and key must be set together")
+	}
+	if c.TLS.Cert != "" && len(c.TLS.Domains) > 0 {
+		fail("tls", "set either cert and key or domains, not both")
+	}
+	if c.TLS.HTTP3 && !c.TLS.Enabled() {
+		fail("tls.http3", "requires cert and key or domains")
+	}
+	if c.Static.MaxAge < 0 {
+		fail("static.max_age", "must not be negative")
+	}
+	if r := c.RateLimit; r.Rate < 0 || r.Burst < 0 || r.PerIP < 0 || r.PerIPBurst < 0 {
+		fail("rate_limit", "must not be negative")
+	}
+	switch l := c.MockAPI.Latency; {
+	case !slices.Contains([]string{"fixed", "uniform", "normal", "exponential"}, l.Distribution):
+		fail("mock_api.latency.distribution", "must be fixed, uniform, normal or exponential, got %q", l.Distribution)
+	case l.Min < 0 || l.Max < 0 || l.Mean < 0 || l.StdDev < 0:
+		fail("mock_api.latency", "must not be negative")
+	case l.Max > 0 && l.Min > l.Max:
+		fail("mock_api.latency", "min must not exceed max")
+	}
+	if c.MockAPI.ErrorRate < 0 || c.MockAPI.ErrorRate > 1 {
+		fail("mock_api.error_rate", "must be between 0 and 1")
+	}
+	if c.MockAPI.ErrorStatus < 100 || c.MockAPI.ErrorStatus > 599 {
+		fail("mock_api.error_status", "must be an HTTP status code")
+	}
+	for i, route := range c.MockAPI.Routes {
+		if route.Path == "" {
+			fail(fmt.Sprintf("mock_api.routes[%d].path", i), "must not be empty")
+		}
+	}
+	if c.Locks.Redis != "" {
+		if u, err := url.Parse(c.Locks.Redis); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			fail("locks.redis", "must be a redis:// or rediss:// URL")
+		}
+	}
+	for i, command := range c.Exec.Allow {
+		if command == "" || (strings.ContainsRune(command, '/') && !strings.HasPrefix(command, "/")) {
+			fail(fmt.Sprintf("exec.allow[%d]", i), "must be a command name or an absolute path, got %q", command)
+		}
+	}
+	for i, key := range c.Exec.EnvAllow {
+		switch {
+		case key == "" || strings.ContainsRune(key, '='):
+			fail(fmt.Sprintf("exec.env_allow[%d]", i), "must be a variable name, got %q", key)
+		case key == "PATH" || strings.HasPrefix(key, "LD_") || strings.HasPrefix(key, "DYLD_"):
+			fail(fmt.Sprintf("exec.env_allow[%d]", i), "%s can't be set by PHP", key)
+		}
+	}
+	for i, dir := range c.Exec.Dirs {
+		if !strings.HasPrefix(dir, "/") {
+			fail(fmt.Sprintf("exec.dirs[%d]", i), "must be an absolute path, got %q", dir)
+		}
+	}
+	if c.Exec.CPU < 0 || c.Exec.Memory < 0 || c.Exec.OpenFiles < 0 || c.Exec.FileSize < 0 {
+		fail("exec", "cpu, memory, open_files and file_size must not be negative")
+	}
+	if c.Admin.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.Admin.Addr); err != nil {
+			fail("admin.addr", "invalid listen address %q", c.Admin.Addr)
+		} else if c.Admin.Addr == c.Addr {
+			fail("admin.addr", "must differ from addr")
+		}
+	}
+	if (c.Admin.Cert == "") != (c.Admin.Key == "") {
+		fail("admin", "cert and key must be set together")
+	}
+	if c.Admin.ClientCA != "" && c.Admin.Cert == "" {
+		fail("admin.client_ca", "requires admin.cert and admin.key")
+	}
+	addrs := map[string]string{c.Addr: "addr", c.Admin.Addr: "admin.addr", c.GRPC.Addr: "grpc.addr"}
+	for i, l := range c.Listeners {
+		key := fmt.Sprintf("listeners[%d]", i)
+		if !slices.Contains([]string{"app", "admin", "metrics"}, l.Role) {
+			fail(key+".role", "must be app, admin or metrics, got %q", l.Role)
+		}
+		if _, _, err := net.SplitHostPort(l.Addr); err != nil {
+			fail(key+".addr", "invalid listen address %q", l.Addr)
+		} else if other, ok := addrs[l.Addr]; ok {
+			fail(key+".addr", "already used by %s", other)
+		} else {
+			addrs[l.Addr] = key + ".addr"
+		}
+		if (l.Cert == "") != (l.Key == "") {
+			fail(key, "cert and key must be set together")
+		}
+		if l.ClientCA != "" && l.Cert == "" {
+			fail(key+".client_ca", "requires cert and key")
+		}
+		if l.Auth && l.Role == "app" {
+			fail(key+".auth", "only applies to admin and metrics listeners")
+		}
+	}
+	if c.GRPC.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.GRPC.Addr); err != nil {
+			fail("grpc.addr", "invalid listen address %q", c.GRPC.Addr)
+		} else if c.GRPC.Addr == c.Addr || c.GRPC.Addr == c.Admin.Addr {
+			fail("grpc.addr", "must differ from addr and admin.addr")
+		}
+		if c.GRPC.Token == "" {
+			fail("grpc.token", "must be set when grpc.addr is")
+		}
+	}
+	if c.GRPC.Workers < 0 {
+		fail("grpc.workers", "must not be negative")
+	}
+	if len(c.Cluster.Peers) > 0 {
+		if c.Cluster.Node == "" {
+			fail("cluster.node", "must be set when cluster.peers are")
+		}
+		if c.GRPC.Addr == "" || c.Admin.Addr == "" {
+			fail("cluster.peers", "require grpc.addr and admin.addr")
+		}
+	}
+	names := map[string]bool{c.Cluster.Node: true}
+	for i, p := range c.Cluster.Peers {
+		key := fmt.Sprintf("cluster.peers[%d]", i)
+		if p.Name == "" || names[p.Name] {
+			fail(key+".name", "must be set and unique, got %q", p.Name)
+		}
+		names[p.Name] = true
+		if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(key+".url", "invalid URL %q", p.URL)
+		}
+		if _, _, err := net.SplitHostPort(p.Addr); err != nil {
+			fail(key+".addr", "invalid address %q", p.Addr)
+		}
+	}
+	if c.Cluster.Interval < 0 {
+		fail("cluster.interval", "must not be negative")
+	}
+	if c.Tasks.MaxDepth < 0 {
+		fail("tasks.max_depth", "must not be negative")
+	}
+	if c.Tasks.LogCapacity < 0 {
+		fail("tasks.log_capacity", "must not be negative")
+	}
+	if c.Tasks.History < 0 {
+		fail("tasks.history", "must not be negative")
+	}
+	if c.Tasks.PruneTTL < 0 {
+		fail("tasks.prune_ttl", "must not be negative")
+	}
+	if c.Tasks.MaxTasks < 0 {
+		fail("tasks.max_tasks", "must not be negative")
+	}
+	if c.Tasks.Budget < 0 {
+		fail("tasks.budget", "must not be negative")
+	}
+	if c.Tasks.AwaitBudget < 0 {
+		fail("tasks.await_budget", "must not be negative")
+	}
+	if c.Tasks.MaxMemory < 0 {
+		fail("tasks.max_memory", "must not be negative")
+	}
+	if c.Tasks.SlowTask < 0 {
+		fail("tasks.slow_task", "must not be negative")
+	}
+	if c.Tasks.StallTimeout < 0 {
+		fail("tasks.stall_timeout", "must not be negative")
+	}
+	if c.Tasks.StallRestarts < 0 {
+		fail("tasks.stall_restarts", "must not be negative")
+	}
+	if c.Tasks.Shutdown != "cancel" && c.Tasks.Shutdown != "wait" && c.Tasks.Shutdown != "detach" {
+		fail("tasks.shutdown", "must be cancel, wait or detach, got %q", c.Tasks.Shutdown)
+	}
+	if c.Tasks.ShutdownTimeout < 0 {
+		fail("tasks.shutdown_timeout", "must not be negative")
+	}
+	if c.Tasks.DisconnectGrace < 0 {
+		fail("tasks.disconnect_grace", "must not be negative")
+	}
+	if o := c.Tasks.Offload; o.Threshold < 0 || o.TTL < 0 {
+		fail("tasks.offload", "must not be negative")
+	} else if o.Threshold > 0 {
+		if u, err := url.Parse(o.S3.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("tasks.offload.s3.endpoint", "must be an http:// or https:// URL")
+		}
+		if o.S3.Bucket == "" {
+			fail("tasks.offload.s3.bucket", "must be set when tasks.offload.threshold is")
+		}
+	}
+	if st := c.Tasks.Store; st.SQLite != "" && st.Postgres != "" {
+		fail("tasks.store", "sqlite and postgres are mutually exclusive")
+	}
+	if c.Tasks.Store.Retention < 0 {
+		fail("tasks.store.retention", "must not be negative")
+	}
+	if st := c.Tasks.Store; st.QueueWorkers < 0 {
+		fail("tasks.store.queue_workers", "must not be negative")
+	} else if st.QueueWorkers > 0 && st.Postgres == "" {
+		fail("tasks.store.queue_workers", "needs tasks.store.postgres")
+	}
+	ids := make(map[string]bool, len(c.Tasks.EncryptionKeys))
+	for _, key := range c.Tasks.EncryptionKeys {
+		id, encoded, _ := strings.Cut(key, ":")
+		if secret, err := base64.StdEncoding.DecodeString(encoded); id == "" || err != nil {
+			fail("tasks.encryption_keys", "key %q must be id:base64", id)
+		} else if n := len(secret); n != 16 && n != 24 && n != 32 {
+			fail("tasks.encryption_keys", "key %q must be 16, 24 or 32 bytes, got %d", id, n)
+		} else if ids[id] {
+			fail("tasks.encryption_keys", "key %q is listed twice", id)
+		}
+		ids[id] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+// Level returns the parsed log level. It assumes c is valid.
+func (c *Config) Level() slog.Level {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(c.LogLevel))
+	return level
+}
+
+// ComponentLevels returns the parsed levels of components that override
+// the log level. It assumes c is valid.
+func (c *Config) ComponentLevels() map[string]slog.Level {
+	levels := make(map[string]slog.Level, len(c.LogLevels))
+	for component, name := range c.LogLevels {
+		var level slog.Level
+		_ = level.UnmarshalText([]byte(name))
+		levels[component] = level
+	}
+	return levels
+}
+
+// RestartRequired returns the keys that differ between c and next but only
+// take effect after a restart. Workers, pools, the logging settings and the
+// tasks settings other than max_depth, history, offload, store and
+// encryption_keys apply to requests started after a reload.
+func (c *Config) RestartRequired(next *Config) []string {
+	var keys []string
+	check := func(key string, changed bool) {
+		if changed {
+			keys = append(keys, key)
+		}
+	}
+
+	check("addr", c.Addr != next.Addr)
+	check("document_root", c.DocumentRoot != next.DocumentRoot)
+	check("threads", c.Threads != next.Threads)
+	check("encoding", c.Encoding != next.Encoding)
+	check("php_ini", !maps.Equal(c.PHPIni, next.PHPIni))
+	check("startup", !c.Startup.equal(next.Startup))
+	check("tls", !c.TLS.equal(next.TLS))
+	check("static", c.Static != next.Static)
+	check("mock_api", !c.MockAPI.equal(next.MockAPI))
+	check("locks", c.Locks != next.Locks)
+	check("exec", !c.Exec.equal(next.Exec))
+	check("admin", !c.Admin.equal(next.Admin))
+	check("listeners", !slices.Equal(c.Listeners, next.Listeners))
+	check("grpc", c.GRPC != next.GRPC)
+	check("cluster", !c.Cluster.equal(next.Cluster))
+	check("tasks.max_depth", c.Tasks.MaxDepth != next.Tasks.MaxDepth)
+	check("tasks.history", c.Tasks.History != next.Tasks.History)
+	check("tasks.offload", c.Tasks.Offload != next.Tasks.Offload)
+	check("tasks.store", c.Tasks.Store != next.Tasks.Store)
+	check("tasks.encryption_keys", !slices.Equal(c.Tasks.EncryptionKeys, next.Tasks.EncryptionKeys))
+
+	return keys
+}
+
+// Enabled reports whether TLS is configured.
+func (t TLS) Enabled() bool {
+	return t.Cert != "" || len(t.Domains) > 0
+}
+
+func (t TLS) equal(other TLS) bool {
+	return t.Cert == other.Cert && t.Key == other.Key && t.CacheDir == other.CacheDir &&
+		t.HTTP3 == other.HTTP3 && slices.Equal(t.Domains, other.Domains)
+}
+
+func (s Startup) equal(other Startup) bool {
+	return s.Script == other.Script && s.Timeout == other.Timeout && s.Preload == other.Preload &&
+		slices.Equal(s.Warmup, other.Warmup)
+}
+
+func (e Exec) equal(other Exec) bool {
+	return slices.Equal(e.Allow, other.Allow) && slices.Equal(e.EnvAllow, other.EnvAllow) &&
+		slices.Equal(e.Dirs, other.Dirs) && e.CPU == other.CPU && e.Memory == other.Memory &&
+		e.OpenFiles == other.OpenFiles && e.FileSize == other.FileSize
+}
+
+func (a Admin) equal(other Admin) bool {
+	return a.Addr == other.Addr && a.Token == other.Token && a.Debug == other.Debug && a.AuthAll == other.AuthAll &&
+		a.Cert == other.Cert && a.Key == other.Key && a.ClientCA == other.ClientCA &&
+		slices.Equal(a.CORSOrigins, other.CORSOrigins)
+}
+
+func (c Cluster) equal(other Cluster) bool {
+	return c.Node == other.Node && c.Interval == other.Interval && slices.Equal(c.Peers, other.Peers)
+}
+
+func (m MockAPI) equal(other MockAPI) bool {
+	return m.Enabled == other.Enabled && m.Latency == other.Latency && m.ErrorRate == other.ErrorRate &&
+		m.ErrorStatus == other.ErrorStatus && slices.Equal(m.Routes, other.Routes)
+}
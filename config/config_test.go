@@ -0,0 +1,497 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "frankenasync.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Test loading a file over the defaults
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+threads: 16
+encoding: msgpack
+php_ini:
+  memory_limit: 256M
+admin:
+  addr: 127.0.0.1:8082
+  token: secret
+tasks:
+  log_capacity: 10
+  prune_ttl: 5m
+  shutdown: wait
+mock_api:
+  enabled: true
+  latency:
+    distribution: normal
+    mean: 80ms
+    stddev: 20ms
+  routes:
+    - path: /api/users/{id}
+      template: '{"id":{{.Int "id"}}}'
+`)
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.Addr, ":8081")
+	assertEqual(t, c.Threads, 16)
+	assertEqual(t, c.Encoding, "msgpack")
+	assertEqual(t, c.PHPIni["memory_limit"], "256M")
+	assertEqual(t, c.Admin.Addr, "127.0.0.1:8082")
+	assertEqual(t, c.Admin.Token, "secret")
+	assertEqual(t, c.Tasks.MaxDepth, 8)
+	assertEqual(t, c.Tasks.LogCapacity, 10)
+	assertEqual(t, c.Tasks.PruneTTL, 5*time.Minute)
+	assertEqual(t, c.Tasks.Shutdown, "wait")
+	assertEqual(t, c.Tasks.ShutdownTimeout, 30*time.Second)
+	assertEqual(t, c.MockAPI.Enabled, true)
+	assertEqual(t, c.MockAPI.Latency.Distribution, "normal")
+	assertEqual(t, c.MockAPI.Latency.StdDev, 20*time.Millisecond)
+	assertEqual(t, c.MockAPI.ErrorStatus, 503)
+	assertEqual(t, c.MockAPI.Routes[0].Path, "/api/users/{id}")
+
+	// An empty file keeps the defaults
+	c, err = Load(writeConfig(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.Encoding, "json")
+
+	// An explicit path must exist
+	_, err = Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assertEqual(t, os.IsNotExist(err), true)
+
+	// Unknown keys are rejected
+	_, err = Load(writeConfig(t, "thread: 4\n"))
+	if err == nil || !strings.Contains(err.Error(), "field thread not found") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+// Test variables and secret files interpolated into the file
+func TestLoad_Interpolate(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "redis_url")
+	if err := os.WriteFile(secret, []byte("redis://:s3cret@redis:6379/0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_THREADS", "6")
+	t.Setenv("TEST_ADMIN_TOKEN", "tok: en")
+	t.Setenv("TEST_REDIS_URL_FILE", secret)
+
+	path := writeConfig(t, `
+threads: ${TEST_THREADS}
+locks:
+  redis: ${TEST_REDIS_URL}
+admin:
+  addr: ${TEST_ADMIN_ADDR:-127.0.0.1:8082}
+  token: "${TEST_ADMIN_TOKEN}"
+php_ini:
+  sendmail_path: "/usr/sbin/sendmail -f $$USER" # ${NOT_IN_COMMENTS}
+`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.Threads, 6)
+	assertEqual(t, c.Locks.Redis, "redis://:s3cret@redis:6379/0")
+	assertEqual(t, c.Admin.Addr, "127.0.0.1:8082")
+	assertEqual(t, c.Admin.Token, "tok: en")
+	assertEqual(t, c.PHPIni["sendmail_path"], "/usr/sbin/sendmail -f $USER")
+
+	// Quoted placeholders stay strings whatever their values read as, plain
+	// ones take the type of theirs
+	for _, value := range []string{"null", "~", "yes", "0x10", "true", "1e3"} {
+		t.Setenv("TEST_ADMIN_TOKEN", value)
+		c, err = Load(writeConfig(t, "admin:\n  token: '${TEST_ADMIN_TOKEN}'\nphp_ini:\n  secret: |\n    ${TEST_ADMIN_TOKEN}\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, c.Admin.Token, value)
+		assertEqual(t, c.PHPIni["secret"], value+"\n")
+	}
+	t.Setenv("TEST_ADMIN_TOKEN", "null")
+	c, err = Load(writeConfig(t, "admin:\n  token: ${TEST_ADMIN_TOKEN}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.Admin.Token, "")
+
+	// Mapping keys aren't interpolated
+	c, err = Load(writeConfig(t, "php_ini:\n  ${TEST_THREADS}: ${TEST_THREADS}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.PHPIni["${TEST_THREADS}"], "6")
+
+	// A $ outside a placeholder is left alone, $$ stays a string
+	c, err = Load(writeConfig(t, "admin:\n  token: pa$word\nphp_ini:\n  price: $$\n  total: 1$$\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.Admin.Token, "pa$word")
+	assertEqual(t, c.PHPIni["price"], "$")
+	assertEqual(t, c.PHPIni["total"], "1$")
+
+	// Variables without a value or default are an error
+	_, err = Load(writeConfig(t, "admin:\n  token: ${TEST_UNSET_TOKEN}\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2: ${TEST_UNSET_TOKEN} is not set") {
+		t.Fatalf("expected unset variable error, got %v", err)
+	}
+}
+
+// Test that only substituted placeholders count as replaced and retype
+// their scalars
+func TestInterpolate_Dollar(t *testing.T) {
+	lookup := func(name string) (string, bool) { return "", false }
+
+	for value, want := range map[string]bool{"pa$word": false, "a$1": false, "$": false, "$$": true, "${NONE:-1}": true} {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte("token: "+value+"\n"), &doc); err != nil {
+			t.Fatal(err)
+		}
+		replaced, err := interpolate(&doc, lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scalar := doc.Content[0].Content[1]
+		if replaced != want {
+			t.Fatalf("%s: got replaced %v, want %v", value, replaced, want)
+		}
+		if substituted := strings.HasPrefix(value, "${"); (scalar.Tag == "") != substituted {
+			t.Fatalf("%s: got tag %q", value, scalar.Tag)
+		}
+	}
+}
+
+// Test environment variables overriding the file
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"FRANKENASYNC_PORT":               "9000",
+		"FRANKENASYNC_WORKERS":            "12",
+		"FRANKENASYNC_POOLS":              "io=64, cpu=4",
+		"FRANKENASYNC_ADMIN_DEBUG":        "true",
+		"FRANKENASYNC_ENCODING":           "",
+		"FRANKENASYNC_TLS_DOMAINS":        "example.com,www.example.com",
+		"FRANKENASYNC_LOG_LEVELS":         "manager=warn, phpext=debug",
+		"FRANKENASYNC_LOG_SAMPLING":       "100",
+		"FRANKENASYNC_DIAGNOSTIC_HEADERS": "1",
+		"FRANKENASYNC_ACCESS_LOG":         "true",
+		"FRANKENASYNC_ADMIN_CORS_ORIGINS": "https://ops.example.com,https://grafana.example.com",
+		"FRANKENASYNC_RATE_LIMIT_PER_IP":  "20",
+		"FRANKENASYNC_STARTUP_SCRIPT":     "healthcheck.php",
+		"FRANKENASYNC_WARMUP":             "/,/search.php?q=a",
+		"FRANKENASYNC_LISTENERS":          "metrics=127.0.0.1:9100, app=:8443",
+		"FRANKENASYNC_EXEC_ALLOW":         "convert,/usr/bin/pdftotext",
+		"FRANKENASYNC_EXEC_ENV_ALLOW":     "LANG,TZ",
+		"FRANKENASYNC_EXEC_CPU":           "30s",
+		"FRANKENASYNC_EXEC_MEMORY":        "536870912",
+		"FRANKENASYNC_MAX_TASK_MEMORY":    "67108864",
+		"FRANKENASYNC_TASK_PROFILING":     "true",
+		"FRANKENASYNC_OFFLOAD_THRESHOLD":  "1048576",
+		"FRANKENASYNC_S3_BUCKET":          "results",
+		"FRANKENASYNC_S3_PATH_STYLE":      "true",
+		"FRANKENASYNC_STORE_SQLITE":       "/var/lib/frankenasync/tasks.db",
+		"FRANKENASYNC_STORE_RETENTION":    "168h",
+		"FRANKENASYNC_QUEUE_WORKERS":      "4",
+		"FRANKENASYNC_TASK_HISTORY":       "500",
+		"FRANKENASYNC_ENCRYPTION_KEYS":    "2025:AAAAAAAAAAAAAAAAAAAAAA==,2024:AQEBAQEBAQEBAQEBAQEBAQ==",
+		"FRANKENASYNC_CLUSTER_NODE":       "a",
+		"FRANKENASYNC_CLUSTER_PEERS":      "b=http://10.0.0.2:8082|10.0.0.2:9090, c=http://10.0.0.3:8082|10.0.0.3:9090",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	c := Default()
+	if err := c.ApplyEnv(lookup); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.Addr, ":9000")
+	assertEqual(t, c.Workers, 12)
+	assertEqual(t, c.Pools["io"], 64)
+	assertEqual(t, c.Pools["cpu"], 4)
+	assertEqual(t, c.Admin.Debug, true)
+	assertEqual(t, c.Encoding, "json")
+	assertEqual(t, len(c.TLS.Domains), 2)
+	assertEqual(t, c.TLS.Enabled(), true)
+	assertEqual(t, c.LogSampling, 100)
+	assertEqual(t, c.Tasks.DiagnosticHeaders, true)
+	assertEqual(t, c.Tasks.MaxMemory, 64<<20)
+	assertEqual(t, c.Tasks.Profiling, true)
+	assertEqual(t, c.Tasks.Offload.Threshold, 1<<20)
+	assertEqual(t, c.Tasks.Offload.S3.Bucket, "results")
+	assertEqual(t, c.Tasks.Offload.S3.PathStyle, true)
+	assertEqual(t, c.Tasks.Store.SQLite, "/var/lib/frankenasync/tasks.db")
+	assertEqual(t, c.Tasks.Store.Retention, 168*time.Hour)
+	assertEqual(t, c.Tasks.Store.QueueWorkers, 4)
+	assertEqual(t, c.Tasks.History, 500)
+	assertEqual(t, len(c.Tasks.EncryptionKeys), 2)
+	assertEqual(t, c.Tasks.EncryptionKeys[1], "2024:AQEBAQEBAQEBAQEBAQEBAQ==")
+	assertEqual(t, c.AccessLog, true)
+	assertEqual(t, len(c.Admin.CORSOrigins), 2)
+	assertEqual(t, c.RateLimit.PerIP, 20)
+	assertEqual(t, c.Startup.Script, "healthcheck.php")
+	assertEqual(t, c.Startup.Timeout, 30*time.Second)
+	assertEqual(t, len(c.Startup.Warmup), 2)
+	assertEqual(t, len(c.Listeners), 2)
+	assertEqual(t, c.Listeners[0].Role, "metrics")
+	assertEqual(t, c.Listeners[1].Addr, ":8443")
+	assertEqual(t, c.Exec.Allow[1], "/usr/bin/pdftotext")
+	assertEqual(t, c.Exec.EnvAllow[1], "TZ")
+	assertEqual(t, c.Exec.CPU, 30*time.Second)
+	assertEqual(t, c.Exec.Memory, 512<<20)
+	assertEqual(t, c.Cluster.Node, "a")
+	assertEqual(t, len(c.Cluster.Peers), 2)
+	assertEqual(t, c.Cluster.Peers[1], ClusterPeer{Name: "c", URL: "http://10.0.0.3:8082", Addr: "10.0.0.3:9090"})
+	assertEqual(t, c.ComponentLevels()["manager"], slog.LevelWarn)
+	assertEqual(t, c.ComponentLevels()["phpext"], slog.LevelDebug)
+	assertEqual(t, len(c.ComponentLevels()), 2)
+
+	// Secrets are read from the files named by _FILE variables
+	secret := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secret, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c = Default()
+	if err := c.ApplyEnv(func(name string) (string, bool) {
+		return secret, name == "FRANKENASYNC_GRPC_TOKEN_FILE"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, c.GRPC.Token, "from-file")
+
+	env["FRANKENASYNC_THREADS"] = "many"
+	env["FRANKENASYNC_ADMIN_DEBUG"] = "maybe"
+	err := Default().ApplyEnv(lookup)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	assertEqual(t, strings.Count(err.Error(), "\n"), 1)
+	assertEqual(t, strings.Contains(err.Error(), `FRANKENASYNC_THREADS: "many" is not a number`), true)
+}
+
+// Test detecting changes that need a restart
+func TestRestartRequired(t *testing.T) {
+	c := Default()
+	next := Default()
+	next.Workers = 12
+	next.LogLevel = "warn"
+	next.Tasks.PruneTTL = time.Minute
+	next.Pools = map[string]int{"io": 64}
+	next.Tasks.Budget = time.Minute
+	next.Admin.CORSOrigins = []string{}
+	next.RateLimit.Rate = 500
+	assertEqual(t, len(c.RestartRequired(next)), 0)
+	assertEqual(t, next.Level(), slog.LevelWarn)
+
+	next.PHPIni = map[string]string{"memory_limit": "1G"}
+	next.Admin.Token = "rotated"
+	next.TLS.Domains = []string{"example.com"}
+	next.MockAPI.ErrorRate = 0.1
+	next.Exec.Allow = []string{"convert"}
+	next.Tasks.Offload.TTL = time.Hour
+	next.Tasks.Store.SQLite = "tasks.db"
+	next.Tasks.EncryptionKeys = []string{"2025:AAAAAAAAAAAAAAAAAAAAAA=="}
+	next.Cluster.Peers = []ClusterPeer{{Name: "b"}}
+	assertEqual(t, strings.Join(c.RestartRequired(next), ","), "php_ini,tls,mock_api,exec,admin,cluster,tasks.offload,tasks.store,tasks.encryption_keys")
+}
+
+// Test that every invalid setting is reported
+func TestValidate(t *testing.T) {
+	assertEqual(t, Default().Validate(), nil)
+
+	c := Default()
+	c.Addr = "8081"
+	c.Threads = -1
+	c.Encoding = "xml"
+	c.LogLevel = "verbose"
+	c.Locks.Redis = "http://localhost"
+	c.Tasks.LogCapacity = -1
+	c.Tasks.History = -1
+	c.Tasks.MaxTasks = -1
+	c.Tasks.AwaitBudget = -time.Second
+	c.Tasks.MaxMemory = -1
+	c.Tasks.SlowTask = -time.Second
+	c.Tasks.StallRestarts = -1
+	c.Tasks.Shutdown = "linger"
+	c.Tasks.DisconnectGrace = -time.Second
+	c.RateLimit.PerIPBurst = -1
+	c.Startup.Script = "healthcheck.sh"
+	c.Startup.Timeout = -time.Second
+	c.Startup.Warmup = []string{"/", "index.php"}
+	c.LogLevels = map[string]string{"worker": "debug", "manager": "loud"}
+	c.LogSampling = -1
+	c.Pools = map[string]int{"io": 0}
+	c.MockAPI.Latency.Distribution = "poisson"
+	c.MockAPI.ErrorRate = 2
+	c.MockAPI.Routes = []MockRoute{{Template: "{}"}}
+	c.Exec.Allow = []string{"convert", "bin/convert"}
+	c.Exec.EnvAllow = []string{"LANG", "LD_PRELOAD"}
+	c.Exec.Dirs = []string{"tmp"}
+	c.Exec.OpenFiles = -1
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, key := range []string{"addr:", "threads:", "encoding:", "log_level:", "locks.redis:", "tasks.log_capacity:", "tasks.history:", "pools.io:", "tasks.max_tasks:", "tasks.await_budget:", "tasks.max_memory:", "tasks.slow_task:", "tasks.stall_restarts:", "tasks.shutdown:", "tasks.disconnect_grace:", "rate_limit:", "startup.script:", "startup.timeout:", "startup.warmup[1]:", "log_levels.worker:", "log_levels.manager:", "log_sampling:",
+		"exec.allow[1]:", "exec.env_allow[1]:", "exec.dirs[0]:", "exec:", "mock_api.latency.distribution:", "mock_api.error_rate:", "mock_api.routes[0].path:"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected %s in %v", key, err)
+		}
+	}
+
+	// Offloading needs a bucket
+	c = Default()
+	c.Tasks.Offload.Threshold = 64 << 20
+	c.Tasks.Offload.S3.Endpoint = "minio:9000"
+	err = c.Validate()
+	for _, key := range []string{"tasks.offload.s3.endpoint:", "tasks.offload.s3.bucket:"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("expected %s in %v", key, err)
+		}
+	}
+	c.Tasks.Offload.S3.Endpoint = "http://minio:9000"
+	c.Tasks.Offload.S3.Bucket = "results"
+	assertEqual(t, c.Validate(), nil)
+
+	c = Default()
+	c.Tasks.Store = TaskStore{SQLite: "/var/lib/frankenasync/tasks.db", Retention: -time.Hour}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "tasks.store.retention:") {
+		t.Errorf("expected tasks.store.retention in %v", err)
+	}
+	c.Tasks.Store = TaskStore{SQLite: "tasks.db", Postgres: "postgres://db/tasks"}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "tasks.store:") {
+		t.Errorf("expected tasks.store in %v", err)
+	}
+	c.Tasks.Store = TaskStore{SQLite: "tasks.db", QueueWorkers: 4}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "tasks.store.queue_workers:") {
+		t.Errorf("expected tasks.store.queue_workers in %v", err)
+	}
+	c.Tasks.Store = TaskStore{Postgres: "postgres://db/tasks", QueueWorkers: 4}
+	assertEqual(t, c.Validate(), nil)
+
+	// Encryption keys are id:base64 of an AES key size, by unique IDs
+	for _, keys := range [][]string{
+		{"2025"},
+		{"2025:not base64"},
+		{":AAAAAAAAAAAAAAAAAAAAAA=="},
+		{"2025:AAAAAAAA"},
+		{"2025:AAAAAAAAAAAAAAAAAAAAAA==", "2025:AQEBAQEBAQEBAQEBAQEBAQ=="},
+	} {
+		c.Tasks.EncryptionKeys = keys
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "tasks.encryption_keys:") {
+			t.Errorf("%v: expected tasks.encryption_keys in %v", keys, err)
+		}
+	}
+	c.Tasks.EncryptionKeys = []string{"2025:AAAAAAAAAAAAAAAAAAAAAA==", "2024:AQEBAQEBAQEBAQEBAQEBAQ=="}
+	assertEqual(t, c.Validate(), nil)
+
+	// Peers need a node name, the listeners they talk to and unique names
+	c = Default()
+	c.Cluster.Peers = []ClusterPeer{{Name: "b", URL: "10.0.0.2:8082", Addr: "10.0.0.2"}, {Name: "b", URL: "http://10.0.0.3:8082", Addr: "10.0.0.3:9090"}}
+	err = c.Validate()
+	for _, key := range []string{"cluster.node:", "cluster.peers:", "cluster.peers[0].url:", "cluster.peers[0].addr:", "cluster.peers[1].name:"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("expected %s in %v", key, err)
+		}
+	}
+	c.Cluster.Node = "a"
+	c.Admin.Addr = ":8082"
+	c.GRPC = GRPC{Addr: ":9090", Token: "secret"}
+	c.Cluster.Peers = []ClusterPeer{{Name: "b", URL: "http://10.0.0.2:8082", Addr: "10.0.0.2:9090"}}
+	assertEqual(t, c.Validate(), nil)
+
+	// TLS needs a certificate source
+	c = Default()
+	c.TLS.Cert = "server.crt"
+	c.TLS.HTTP3 = true
+	err = c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "tls: cert and key must be set together") {
+		t.Fatalf("expected tls error, got %v", err)
+	}
+	c.TLS.Key = "server.key"
+	assertEqual(t, c.Validate(), nil)
+	c.TLS.Domains = []string{"example.com"}
+	if c.Validate() == nil {
+		t.Fatal("expected error for cert with domains")
+	}
+	c = Default()
+	c.TLS.HTTP3 = true
+	if c.Validate() == nil {
+		t.Fatal("expected error for http3 without TLS")
+	}
+
+	// The admin API needs its own listener
+	c = Default()
+	c.Admin.Addr = c.Addr
+	err = c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "admin.addr: must differ from addr") {
+		t.Fatalf("expected admin.addr error, got %v", err)
+	}
+	c = Default()
+	c.Admin.ClientCA = "clients.pem"
+	err = c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "admin.client_ca: requires admin.cert and admin.key") {
+		t.Fatalf("expected admin.client_ca error, got %v", err)
+	}
+	c.Admin.Cert = "admin.crt"
+	err = c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "admin: cert and key must be set together") {
+		t.Fatalf("expected admin error, got %v", err)
+	}
+	c.Admin.Key = "admin.key"
+	assertEqual(t, c.Validate(), nil)
+
+	// Further listeners need a role and an address of their own
+	c = Default()
+	c.Listeners = []Listener{
+		{Role: "metrics", Addr: "127.0.0.1:9100", Auth: true},
+		{Role: "public", Addr: c.Addr},
+		{Role: "app", Addr: "127.0.0.1:9100", ClientCA: "clients.pem", Auth: true},
+	}
+	err = c.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, key := range []string{"listeners[1].role:", "listeners[1].addr: already used by addr", "listeners[2].addr: already used by listeners[0].addr", "listeners[2].client_ca:", "listeners[2].auth:"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("expected %s in %v", key, err)
+		}
+	}
+	assertEqual(t, strings.Contains(err.Error(), "listeners[0].auth:"), false)
+
+	// The gRPC API refuses to listen without a token
+	c = Default()
+	c.GRPC.Addr = "127.0.0.1:9090"
+	err = c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "grpc.token: must be set") {
+		t.Fatalf("expected grpc.token error, got %v", err)
+	}
+	c.GRPC.Token = "secret"
+	assertEqual(t, c.Validate(), nil)
+}
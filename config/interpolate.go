@@ -0,0 +1,94 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// placeholder matches ${NAME} and ${NAME:-default}, and $$ escaping a $.
+var placeholder = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// withSecrets returns a lookup that, for a variable lookup doesn't set,
+// reads the value from the file named by the variable with a _FILE
+// suffix, as mounted Docker and Kubernetes secrets are. A trailing newline
+// is dropped. Files that can't be read are added to errs.
+func withSecrets(lookup func(string) (string, bool), errs *[]error) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if v, ok := lookup(name); ok && v != "" {
+			return v, true
+		}
+		path, ok := lookup(name + "_FILE")
+		if !ok || path == "" {
+			return "", false
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s_FILE: %w", name, err))
+			return "", false
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), true
+	}
+}
+
+// interpolate replaces the placeholders in the scalars of doc with the
+// values of environment variables, or of their _FILE secrets: ${NAME} with
+// that of NAME, ${NAME:-default} with default when NAME isn't set. $$
+// stands for a literal $. Mapping keys are left as they are. It reports
+// whether it changed any scalar, and fails on variables without a value or
+// default.
+func interpolate(doc *yaml.Node, lookup func(string) (string, bool)) (bool, error) {
+	var errs []error
+	lookup = withSecrets(lookup, &errs)
+
+	replaced := false
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		for i, child := range n.Content {
+			if n.Kind == yaml.MappingNode && i%2 == 0 {
+				continue
+			}
+			walk(child)
+		}
+		if n.Kind != yaml.ScalarNode || !strings.Contains(n.Value, "$") {
+			return
+		}
+
+		substituted := false
+		value := placeholder.ReplaceAllStringFunc(n.Value, func(m string) string {
+			if m == "$$" {
+				return "$"
+			}
+			sub := placeholder.FindStringSubmatch(m)
+			if v, ok := lookup(sub[1]); ok {
+				substituted = true
+				return v
+			}
+			if sub[2] != "" {
+				substituted = true
+				return sub[3]
+			}
+			errs = append(errs, fmt.Errorf("line %d: ${%s} is not set", n.Line, sub[1]))
+			return m
+		})
+		if value == n.Value && !substituted {
+			return
+		}
+		n.Value = value
+		replaced = true
+
+		// Let the value of a plain scalar decide its type, so ${THREADS} can
+		// set a number. Quoted ones stay strings, whatever the value reads as,
+		// as do scalars with only a $$ or a $ of their own.
+		if n.Style == 0 && substituted {
+			n.Tag = ""
+		}
+	}
+	walk(doc)
+
+	return replaced, errors.Join(errs...)
+}
@@ -0,0 +1,52 @@
+package grpcapi
+
+import (
+	"context"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryCall is a unary method of a generated gRPC client, such as
+// taskspb.TasksClient.AwaitTask.
+type UnaryCall[Req, Resp any] func(ctx context.Context, req Req, opts ...grpc.CallOption) (Resp, error)
+
+// Call returns a runnable making a unary call with req, its result the
+// response, so Go services embedding a manager fan out RPCs with Async and
+// cancel or await them like any task. The call runs with the task's
+// context: the deadline of asynctask.WithTimeout, WithDeadline or
+// WithRequestDeadline goes with it, and canceling the task cancels the
+// call. Failures with a code RetryableCode doesn't accept are marked
+// asynctask.Permanent, so WithRetry only retries transient ones.
+func Call[Req, Resp any](call UnaryCall[Req, Resp], req Req, opts ...grpc.CallOption) asynctask.Runnable {
+	return asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		resp, err := call(ctx, req, opts...)
+		if err == nil {
+			return resp, nil
+		}
+
+		// The call was cut short by the task, not failed by the server
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !RetryableCode(status.Code(err)) {
+			return nil, asynctask.Permanent(err)
+		}
+		return nil, err
+	})
+}
+
+// RetryableCode reports whether a call failing with code may succeed when
+// made again: the server was unavailable, out of resources, aborted the
+// call or ran out of time.
+func RetryableCode(code codes.Code) bool {
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,317 @@
+// Package grpcapi serves the frankenasync.v1.Tasks gRPC service, which lets
+// other services submit PHP scripts and the Go runnables registered with
+// asynctask.RegisterRunnable as tasks, await their results and follow their
+// events.
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/grpcapi/taskspb"
+	"github.com/johanjanssens/frankenasync/pubsub"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// eventBuffer is the number of events a slow stream may fall behind before
+// events are dropped.
+const eventBuffer = 256
+
+type (
+	// ScriptFunc runs a PHP script, like phpext.RunScript.
+	ScriptFunc func(ctx context.Context, name string, args map[string]any) (any, error)
+
+	// Option configures the service.
+	Option func(*Service)
+
+	// Service implements the Tasks service on top of a task manager owned
+	// by the caller.
+	Service struct {
+		taskspb.UnimplementedTasksServer
+
+		manager *asynctask.Manager
+		ctx     context.Context // tasks outlive the call that submitted them
+		token   string
+		events  *pubsub.Broker
+		script  ScriptFunc
+	}
+)
+
+// WithToken sets the bearer token every call must present. Without a token
+// all calls are refused.
+func WithToken(token string) Option {
+	return func(s *Service) {
+		s.token = token
+	}
+}
+
+// WithEvents enables StreamEvents, fed by tasks whose manager publishes to b
+// through admin.Publisher.
+func WithEvents(b *pubsub.Broker) Option {
+	return func(s *Service) {
+		s.events = b
+	}
+}
+
+// WithScripts runs the scripts named in SubmitTask with fn. Without it,
+// script tasks are refused.
+func WithScripts(fn ScriptFunc) Option {
+	return func(s *Service) {
+		s.script = fn
+	}
+}
+
+// NewService returns a service running tasks in manager. ctx is the parent
+// of every task's context.
+func NewService(ctx context.Context, manager *asynctask.Manager, opts ...Option) *Service {
+	s := &Service{
+		manager: manager,
+		ctx:     ctx,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Server returns a gRPC server with the service registered and token
+// authentication installed.
+func (s *Service) Server(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := s.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorize(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+
+	server := grpc.NewServer(opts...)
+	taskspb.RegisterTasksServer(server, s)
+	return server
+}
+
+func (s *Service) authorize(ctx context.Context) error {
+	if s.token == "" {
+		return status.Error(codes.PermissionDenied, "no token configured")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid token")
+}
+
+// SubmitTask starts a script or runnable task.
+func (s *Service) SubmitTask(_ context.Context, req *taskspb.SubmitTaskRequest) (*taskspb.SubmitTaskResponse, error) {
+	var runnable asynctask.Runnable
+	args := req.GetArgs().AsMap()
+	labels := maps.Clone(req.GetLabels())
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	switch target := req.GetTarget().(type) {
+	case *taskspb.SubmitTaskRequest_Script:
+		if s.script == nil {
+			return nil, status.Error(codes.FailedPrecondition, "script tasks are disabled")
+		}
+		if target.Script == "" {
+			return nil, status.Error(codes.InvalidArgument, "script must not be empty")
+		}
+		runnable = asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			return s.script(ctx, target.Script, args)
+		})
+		labels["script"] = target.Script
+	case *taskspb.SubmitTaskRequest_Runnable:
+		var err error
+		runnable, err = asynctask.Spec{Name: target.Runnable, Params: args}.Runnable()
+		if errors.Is(err, asynctask.ErrRunnableNotRegistered) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		} else if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		labels[asynctask.RunnableLabel] = target.Runnable
+	default:
+		return nil, status.Error(codes.InvalidArgument, "script or runnable required")
+	}
+
+	if timeout := req.GetTimeout(); timeout != nil {
+		if err := timeout.CheckValid(); err != nil || timeout.AsDuration() <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid timeout")
+		}
+		runnable = asynctask.WithTimeout(runnable, timeout.AsDuration())
+	}
+
+	id := s.manager.Async(asynctask.WithLabels(s.ctx, labels), runnable)
+	return &taskspb.SubmitTaskResponse{Id: id.String()}, nil
+}
+
+// AwaitTask waits for a task without canceling it when the call ends first.
+func (s *Service) AwaitTask(ctx context.Context, req *taskspb.AwaitTaskRequest) (*taskspb.Task, error) {
+	id, err := parseID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout := req.GetTimeout(); timeout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout.AsDuration())
+		defer cancel()
+	}
+
+	// Failures are reported in the task, as are cancellations, whose tasks
+	// the manager no longer waits for
+	_, err = s.manager.WaitFor(ctx, id)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, status.Error(codes.DeadlineExceeded, "task still running")
+	case errors.Is(err, context.Canceled):
+		return nil, status.FromContextError(err).Err()
+	}
+
+	return s.task(id)
+}
+
+// CancelTask cancels a task.
+func (s *Service) CancelTask(_ context.Context, req *taskspb.CancelTaskRequest) (*taskspb.Task, error) {
+	id, err := parseID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.manager.Cancel(id)
+	switch {
+	case err != nil:
+		return nil, status.Error(codes.NotFound, "task not found")
+	case result == asynctask.CancelAlreadyFinished:
+		return nil, status.Error(codes.FailedPrecondition, "task already finished")
+	}
+	return s.task(id)
+}
+
+// StreamEvents sends the task events matching the request until the call
+// ends.
+func (s *Service) StreamEvents(req *taskspb.StreamEventsRequest, stream grpc.ServerStreamingServer[taskspb.Event]) error {
+	if s.events == nil {
+		return status.Error(codes.Unimplemented, "events are disabled")
+	}
+
+	sub := s.events.Subscribe(admin.EventsTopic, eventBuffer)
+	defer sub.Close()
+
+	for {
+		msg, err := sub.Receive(stream.Context())
+		if err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		var ev admin.Event
+		if err := json.Unmarshal(msg, &ev); err != nil || !match(req, ev) {
+			continue
+		}
+
+		event := &taskspb.Event{
+			Type:   ev.Type,
+			Id:     ev.ID,
+			Labels: ev.Labels,
+			Time:   timestamppb.New(ev.Time),
+			Error:  ev.Error,
+		}
+		if ev.Duration > 0 {
+			event.Duration = durationpb.New(time.Duration(ev.Duration * float64(time.Millisecond)))
+		}
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+}
+
+// task returns the state of a task.
+func (s *Service) task(id asynctask.ID) (*taskspb.Task, error) {
+	future, err := s.manager.Future(id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "task not found")
+	}
+
+	task := &taskspb.Task{
+		Id:       id.String(),
+		Status:   future.Status,
+		Labels:   future.Labels,
+		Duration: durationpb.New(future.Duration),
+	}
+	if future.Error != nil {
+		task.Error = future.Error.Error()
+	}
+	if future.Result != nil {
+		if task.Result, err = toValue(future.Result); err != nil {
+			return nil, status.Errorf(codes.Internal, "encode result: %v", err)
+		}
+	}
+	return task, nil
+}
+
+func match(req *taskspb.StreamEventsRequest, ev admin.Event) bool {
+	if types := req.GetTypes(); len(types) > 0 && !slices.Contains(types, ev.Type) {
+		return false
+	}
+	for key, value := range req.GetLabels() {
+		if ev.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func parseID(s string) (asynctask.ID, error) {
+	id, err := asynctask.ParseID(s)
+	if err != nil {
+		return asynctask.ID{}, status.Error(codes.InvalidArgument, "invalid task ID")
+	}
+	return id, nil
+}
+
+// toValue converts a task result through its JSON representation. Results
+// that already are JSON text, as script results are by default, are
+// decoded first.
+func toValue(result any) (*structpb.Value, error) {
+	data, ok := result.(string)
+	if !ok || !json.Valid([]byte(data)) {
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		data = string(encoded)
+	}
+
+	value := &structpb.Value{}
+	if err := protojson.Unmarshal([]byte(data), value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
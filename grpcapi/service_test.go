@@ -0,0 +1,342 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/grpcapi/taskspb"
+	"github.com/johanjanssens/frankenasync/pubsub"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// blockStarted receives a value once the block runnable runs.
+var blockStarted = make(chan struct{}, 1)
+
+func init() {
+	asynctask.RegisterRunnable("sum", func(params map[string]any) (asynctask.Runnable, error) {
+		a, ok1 := params["a"].(float64)
+		b, ok2 := params["b"].(float64)
+		if !ok1 || !ok2 {
+			return nil, errors.New("a and b must be numbers")
+		}
+		return asynctask.RunnableFunc(func(context.Context) (any, error) {
+			return map[string]any{"sum": a + b}, nil
+		}), nil
+	})
+	asynctask.RegisterRunnable("fail", func(map[string]any) (asynctask.Runnable, error) {
+		return asynctask.RunnableFunc(func(context.Context) (any, error) {
+			return nil, errors.New("boom")
+		}), nil
+	})
+	asynctask.RegisterRunnable("block", func(map[string]any) (asynctask.Runnable, error) {
+		return asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			blockStarted <- struct{}{}
+			<-ctx.Done()
+			return nil, nil
+		}), nil
+	})
+	asynctask.RegisterRunnable("noop", func(map[string]any) (asynctask.Runnable, error) {
+		return asynctask.RunnableFunc(func(context.Context) (any, error) {
+			return "ok", nil
+		}), nil
+	})
+}
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func assertCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("got %v (%v), want %v", got, err, want)
+	}
+}
+
+// dial serves s in memory and returns a client sending token.
+func dial(t *testing.T, s *Service, token string) taskspb.TasksClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	server := s.Server()
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token), method, req, reply, cc, opts...)
+		}),
+		grpc.WithStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token), desc, cc, method, opts...)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return taskspb.NewTasksClient(conn)
+}
+
+func newManager(t *testing.T, opts ...asynctask.Option) *asynctask.Manager {
+	t.Helper()
+	tm := asynctask.NewManager(opts...)
+	t.Cleanup(func() { tm.Shutdown(context.Background()) })
+	return tm
+}
+
+// Test that calls need the configured token
+func TestService_Auth(t *testing.T) {
+	tm := newManager(t)
+	req := &taskspb.AwaitTaskRequest{Id: "invalid"}
+
+	_, err := dial(t, NewService(context.Background(), tm, WithToken("secret")), "wrong").AwaitTask(context.Background(), req)
+	assertCode(t, err, codes.Unauthenticated)
+
+	_, err = dial(t, NewService(context.Background(), tm), "").AwaitTask(context.Background(), req)
+	assertCode(t, err, codes.PermissionDenied)
+
+	_, err = dial(t, NewService(context.Background(), tm, WithToken("secret")), "secret").AwaitTask(context.Background(), req)
+	assertCode(t, err, codes.InvalidArgument)
+}
+
+// Test submitting a runnable and awaiting its result
+func TestService_SubmitAwait(t *testing.T) {
+	client := dial(t, NewService(context.Background(), newManager(t), WithToken("secret")), "secret")
+	ctx := context.Background()
+
+	args, _ := structpb.NewStruct(map[string]any{"a": 1, "b": 2})
+	submitted, err := client.SubmitTask(ctx, &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Runnable{Runnable: "sum"},
+		Args:   args,
+		Labels: map[string]string{"tenant": "acme"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	task, err := client.AwaitTask(ctx, &taskspb.AwaitTaskRequest{Id: submitted.Id})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, task.Status, "completed")
+	assertEqual(t, task.Result.GetStructValue().Fields["sum"].GetNumberValue(), 3.0)
+	assertEqual(t, task.Labels["tenant"], "acme")
+	assertEqual(t, task.Labels["runnable"], "sum")
+
+	// Failures are reported in the task, not as call errors
+	submitted, err = client.SubmitTask(ctx, &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Runnable{Runnable: "fail"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	task, err = client.AwaitTask(ctx, &taskspb.AwaitTaskRequest{Id: submitted.Id})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, task.Status, "failed")
+	assertEqual(t, task.Error, "boom")
+
+	_, err = client.SubmitTask(ctx, &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Runnable{Runnable: "missing"},
+	})
+	assertCode(t, err, codes.NotFound)
+
+	// Params are checked when the task is submitted
+	_, err = client.SubmitTask(ctx, &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Runnable{Runnable: "sum"},
+	})
+	assertCode(t, err, codes.InvalidArgument)
+
+	_, err = client.SubmitTask(ctx, &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Script{Script: "task.php"},
+	})
+	assertCode(t, err, codes.FailedPrecondition)
+}
+
+// Test running scripts through the script function
+func TestService_Script(t *testing.T) {
+	var gotName string
+	s := NewService(context.Background(), newManager(t),
+		WithToken("secret"),
+		WithScripts(func(_ context.Context, name string, args map[string]any) (any, error) {
+			gotName = name
+			return `{"status":200,"body":"hello"}`, nil
+		}),
+	)
+	client := dial(t, s, "secret")
+
+	submitted, err := client.SubmitTask(context.Background(), &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Script{Script: "include/task.php"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	task, err := client.AwaitTask(context.Background(), &taskspb.AwaitTaskRequest{Id: submitted.Id})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, gotName, "include/task.php")
+	assertEqual(t, task.Labels["script"], "include/task.php")
+
+	// JSON results are decoded, not passed on as a string
+	assertEqual(t, task.Result.GetStructValue().Fields["body"].GetStringValue(), "hello")
+}
+
+// Test that an await timing out leaves the task running, and canceling it
+func TestService_AwaitTimeout(t *testing.T) {
+	client := dial(t, NewService(context.Background(), newManager(t), WithToken("secret")), "secret")
+	ctx := context.Background()
+
+	submitted, err := client.SubmitTask(ctx, &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Runnable{Runnable: "block"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-blockStarted
+
+	_, err = client.AwaitTask(ctx, &taskspb.AwaitTaskRequest{Id: submitted.Id, Timeout: durationpb.New(20 * time.Millisecond)})
+	assertCode(t, err, codes.DeadlineExceeded)
+
+	task, err := client.CancelTask(ctx, &taskspb.CancelTaskRequest{Id: submitted.Id})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, task.Id, submitted.Id)
+
+	task, err = client.AwaitTask(ctx, &taskspb.AwaitTaskRequest{Id: submitted.Id})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, task.Status, "canceled")
+
+	_, err = client.CancelTask(ctx, &taskspb.CancelTaskRequest{Id: submitted.Id})
+	assertCode(t, err, codes.FailedPrecondition)
+
+	_, err = client.CancelTask(ctx, &taskspb.CancelTaskRequest{Id: "d0q4kg7n9ccvr4mpkmvg"})
+	assertCode(t, err, codes.NotFound)
+}
+
+// Test streaming filtered task events
+func TestService_StreamEvents(t *testing.T) {
+	b := pubsub.NewBroker()
+	tm := newManager(t, asynctask.WithEventHandler(admin.Publisher(b)))
+	client := dial(t, NewService(context.Background(), tm, WithToken("secret"), WithEvents(b)), "secret")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamEvents(ctx, &taskspb.StreamEventsRequest{
+		Types:  []string{"completed"},
+		Labels: map[string]string{"tenant": "acme"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Wait for the stream to subscribe before submitting
+	deadline := time.Now().Add(time.Second)
+	for b.Subscribers(admin.EventsTopic) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, tenant := range []string{"other", "acme"} {
+		if _, err := client.SubmitTask(ctx, &taskspb.SubmitTaskRequest{
+			Target: &taskspb.SubmitTaskRequest_Runnable{Runnable: "noop"},
+			Labels: map[string]string{"tenant": tenant},
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, event.Type, "completed")
+	assertEqual(t, event.Labels["tenant"], "acme")
+}
+
+// Test unary calls as runnables
+func TestCall(t *testing.T) {
+	client := dial(t, NewService(context.Background(), newManager(t), WithToken("secret")), "secret")
+	tm := newManager(t)
+	ctx := context.Background()
+
+	submitted, err := client.SubmitTask(ctx, &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Runnable{Runnable: "noop"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := tm.Await(ctx, tm.Async(ctx, Call(client.AwaitTask, &taskspb.AwaitTaskRequest{Id: submitted.Id})))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Result.(*taskspb.Task).Status, "completed")
+
+	// Rejected calls aren't retried, transient failures are
+	calls := 0
+	counted := func(ctx context.Context, req *taskspb.AwaitTaskRequest, opts ...grpc.CallOption) (*taskspb.Task, error) {
+		calls++
+		return client.AwaitTask(ctx, req, opts...)
+	}
+	_, err = tm.Await(ctx, tm.Async(ctx, asynctask.WithRetry(Call(counted, &taskspb.AwaitTaskRequest{Id: "invalid"}), 2, time.Millisecond)))
+	assertCode(t, err, codes.InvalidArgument)
+	assertEqual(t, calls, 1)
+
+	calls = 0
+	flaky := func(context.Context, string, ...grpc.CallOption) (string, error) {
+		if calls++; calls < 3 {
+			return "", status.Error(codes.Unavailable, "try again")
+		}
+		return "ok", nil
+	}
+	result, err = tm.Await(ctx, tm.Async(ctx, asynctask.WithRetry(Call(flaky, ""), 2, time.Millisecond)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Result, "ok")
+
+	// The task's deadline goes with the call
+	submitted, err = client.SubmitTask(ctx, &taskspb.SubmitTaskRequest{
+		Target: &taskspb.SubmitTaskRequest_Runnable{Runnable: "block"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-blockStarted
+
+	var deadline bool
+	awaiting := func(ctx context.Context, req *taskspb.AwaitTaskRequest, opts ...grpc.CallOption) (*taskspb.Task, error) {
+		_, deadline = ctx.Deadline()
+		return client.AwaitTask(ctx, req, opts...)
+	}
+	_, err = tm.Await(ctx, tm.Async(ctx, asynctask.WithTimeout(Call(awaiting, &taskspb.AwaitTaskRequest{Id: submitted.Id}), 20*time.Millisecond)))
+	if !errors.Is(err, asynctask.ErrTaskTimeout) {
+		t.Fatalf("expected ErrTaskTimeout, got %v", err)
+	}
+	assertEqual(t, deadline, true)
+}
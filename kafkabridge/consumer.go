@@ -0,0 +1,100 @@
+package kafkabridge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// RecordLabel labels the tasks a Consumer submits with the record they
+// came from, as topic/partition/offset.
+const RecordLabel = "kafka_record"
+
+type (
+	// Consumer submits the tasks described by the records of a topic to a
+	// manager, see Run.
+	Consumer struct {
+		reader  Reader
+		manager *asynctask.Manager
+		logger  *slog.Logger
+	}
+
+	// ConsumerOption configures a Consumer.
+	ConsumerOption func(*Consumer)
+
+	// Submission is the JSON value of the records a Consumer reads: the
+	// spec of a registered runnable, and the labels of its task.
+	//
+	//	{"name": "report.build", "params": {"id": 42}, "labels": {"tenant": "acme"}}
+	Submission struct {
+		asynctask.Spec
+		Labels map[string]string `json:"labels,omitempty"`
+	}
+)
+
+// WithConsumerLogger sets the logger of skipped records. Defaults to
+// slog.Default().
+func WithConsumerLogger(logger *slog.Logger) ConsumerOption {
+	return func(c *Consumer) {
+		c.logger = logger
+	}
+}
+
+// NewConsumer returns a consumer submitting the tasks r reads to tm.
+func NewConsumer(r Reader, tm *asynctask.Manager, opts ...ConsumerOption) *Consumer {
+	c := &Consumer{reader: r, manager: tm, logger: slog.Default()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run submits a task per record until ctx is done or the reader fails,
+// returning why it stopped. A record is committed once its task is
+// submitted, so it's submitted at least once; submitting blocks while the
+// manager's pool is full, holding back the topic. Records that aren't a
+// valid submission are logged and committed, so they don't hold it back
+// forever. Tasks outlive Run, until the manager shuts down.
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("kafkabridge: fetch: %w", err)
+		}
+
+		if id, err := c.submit(ctx, msg); err != nil {
+			c.logger.Warn("Kafka Record Skipped", slog.String("topic", msg.Topic), slog.Int("partition", msg.Partition), slog.Int64("offset", msg.Offset), slog.Any("error", err))
+		} else if c.logger.Enabled(ctx, slog.LevelDebug) {
+			c.logger.Debug("Kafka Record Submitted", slog.String("id", id.String()), slog.String("topic", msg.Topic), slog.Int64("offset", msg.Offset))
+		}
+
+		if err := c.reader.CommitMessages(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("kafkabridge: commit: %w", err)
+		}
+	}
+}
+
+// submit starts the task msg describes.
+func (c *Consumer) submit(ctx context.Context, msg Message) (asynctask.ID, error) {
+	var sub Submission
+	if err := json.Unmarshal(msg.Value, &sub); err != nil {
+		return asynctask.ID{}, fmt.Errorf("invalid submission: %w", err)
+	}
+
+	labels := make(map[string]string, len(sub.Labels)+1)
+	maps.Copy(labels, sub.Labels)
+	labels[RecordLabel] = fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+
+	ctx = asynctask.WithLabels(context.WithoutCancel(ctx), labels)
+	return c.manager.Submit(ctx, sub.Spec)
+}
@@ -0,0 +1,89 @@
+package kafkabridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+func init() {
+	asynctask.RegisterRunnable("kafkabridge.echo", func(params map[string]any) (asynctask.Runnable, error) {
+		return asynctask.RunnableFunc(func(context.Context) (any, error) {
+			return params["msg"], nil
+		}), nil
+	})
+}
+
+// memReader reads the records it was given, then blocks.
+type memReader struct {
+	mu        sync.Mutex
+	messages  []Message
+	committed []int64
+}
+
+func (r *memReader) FetchMessage(ctx context.Context) (Message, error) {
+	r.mu.Lock()
+	if len(r.messages) > 0 {
+		msg := r.messages[0]
+		r.messages = r.messages[1:]
+		r.mu.Unlock()
+		return msg, nil
+	}
+	r.mu.Unlock()
+	<-ctx.Done()
+	return Message{}, ctx.Err()
+}
+
+func (r *memReader) CommitMessages(_ context.Context, msgs ...Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range msgs {
+		r.committed = append(r.committed, msg.Offset)
+	}
+	return nil
+}
+
+// Test that records are submitted as tasks and committed, invalid ones too
+func TestConsumer(t *testing.T) {
+	r := &memReader{messages: []Message{
+		{Topic: "tasks", Offset: 1, Value: []byte(`{"name": "kafkabridge.echo", "params": {"msg": "hi"}, "labels": {"tenant": "acme"}}`)},
+		{Topic: "tasks", Offset: 2, Value: []byte(`not json`)},
+		{Topic: "tasks", Offset: 3, Value: []byte(`{"name": "kafkabridge.unknown"}`)},
+	}}
+	tm := asynctask.NewManager()
+	defer tm.Shutdown(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- NewConsumer(r, tm).Run(ctx) }()
+
+	for {
+		r.mu.Lock()
+		n := len(r.committed)
+		r.mu.Unlock()
+		if n == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	futures := tm.List()
+	if len(futures) != 1 {
+		t.Fatalf("got %d tasks, want 1", len(futures))
+	}
+	future, err := tm.Await(context.Background(), futures[0].ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if future.Result != "hi" || futures[0].Labels["tenant"] != "acme" || futures[0].Labels[RecordLabel] != "tasks/0/1" {
+		t.Fatalf("unexpected task %+v", future)
+	}
+}
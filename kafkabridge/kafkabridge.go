@@ -0,0 +1,44 @@
+// Package kafkabridge connects task managers to Kafka: a Publisher writes
+// the terminal events of tasks, optionally with their results, to a topic,
+// and a Consumer submits the tasks described by the records of another.
+//
+// The bridge works on Writer and Reader, shaped after the writer and reader
+// of github.com/segmentio/kafka-go, so the client is left to the binary: a
+// kafka-go Writer or Reader fits behind a few lines converting Message,
+// and other clients behind an adapter of their own.
+package kafkabridge
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// Message is a Kafka record.
+	Message struct {
+		Topic     string
+		Partition int
+		Offset    int64
+		Key       []byte
+		Value     []byte
+		Headers   map[string]string
+		Time      time.Time
+	}
+
+	// Writer writes records to Kafka.
+	Writer interface {
+		// WriteMessages writes msgs, returning once they're acknowledged.
+		WriteMessages(ctx context.Context, msgs ...Message) error
+	}
+
+	// Reader reads the records of a topic as a member of a consumer group,
+	// which only moves past records once they're committed.
+	Reader interface {
+		// FetchMessage returns the next record, blocking until there's one
+		// or ctx is done.
+		FetchMessage(ctx context.Context) (Message, error)
+
+		// CommitMessages commits the offsets of msgs.
+		CommitMessages(ctx context.Context, msgs ...Message) error
+	}
+)
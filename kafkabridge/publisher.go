@@ -0,0 +1,211 @@
+package kafkabridge
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+const (
+	defaultBuffer    = 1024
+	defaultBatchSize = 100
+	retryDelay       = time.Second
+)
+
+type (
+	// Publisher writes the terminal events of tasks (completed, failed and
+	// canceled) to a topic, keyed by task ID. Managers hand it events
+	// through Handler, which never blocks: events are buffered and written
+	// in batches by Run, and dropped while the buffer is full.
+	Publisher struct {
+		writer    Writer
+		topic     string
+		results   bool
+		batchSize int
+		logger    *slog.Logger
+
+		events  chan Event
+		dropped atomic.Int64
+	}
+
+	// PublisherOption configures a Publisher.
+	PublisherOption func(*Publisher)
+
+	// Event is the JSON value of the records a Publisher writes.
+	Event struct {
+		Type     string            `json:"type"`
+		ID       string            `json:"id"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Time     time.Time         `json:"time"`
+		Duration float64           `json:"duration_ms"`
+		Error    string            `json:"error,omitempty"`
+		Result   json.RawMessage   `json:"result,omitempty"` // with WithResults
+	}
+)
+
+// WithResults adds the results of completed tasks to their events, as
+// JSON. Results that don't encode, or were offloaded, are left out.
+func WithResults() PublisherOption {
+	return func(p *Publisher) {
+		p.results = true
+	}
+}
+
+// WithBuffer sets how many events wait to be written before new ones are
+// dropped, 1024 by default.
+func WithBuffer(size int) PublisherOption {
+	return func(p *Publisher) {
+		if size > 0 {
+			p.events = make(chan Event, size)
+		}
+	}
+}
+
+// WithBatchSize sets how many events are written at once at most, 100 by
+// default.
+func WithBatchSize(size int) PublisherOption {
+	return func(p *Publisher) {
+		if size > 0 {
+			p.batchSize = size
+		}
+	}
+}
+
+// WithPublisherLogger sets the logger of write failures. Defaults to
+// slog.Default().
+func WithPublisherLogger(logger *slog.Logger) PublisherOption {
+	return func(p *Publisher) {
+		p.logger = logger
+	}
+}
+
+// NewPublisher returns a publisher writing to topic with w. Call Run to
+// start writing.
+func NewPublisher(w Writer, topic string, opts ...PublisherOption) *Publisher {
+	p := &Publisher{
+		writer:    w,
+		topic:     topic,
+		batchSize: defaultBatchSize,
+		logger:    slog.Default(),
+		events:    make(chan Event, defaultBuffer),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Handler returns an event handler buffering the terminal events of a
+// manager's tasks, for use with asynctask.WithEventHandler.
+func (p *Publisher) Handler() func(asynctask.Event) {
+	return func(ev asynctask.Event) {
+		switch ev.Type {
+		case asynctask.EventCompleted, asynctask.EventFailed, asynctask.EventCanceled:
+		default:
+			return
+		}
+
+		event := Event{
+			Type:     string(ev.Type),
+			ID:       ev.ID.String(),
+			Labels:   ev.Labels,
+			Time:     ev.Time,
+			Duration: float64(ev.Duration) / float64(time.Millisecond),
+		}
+		if ev.Error != nil {
+			event.Error = ev.Error.Error()
+		}
+		if p.results && ev.Result != nil {
+			if data, err := json.Marshal(ev.Result); err == nil {
+				event.Result = data
+			}
+		}
+
+		select {
+		case p.events <- event:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped returns how many events were dropped as the buffer was full.
+func (p *Publisher) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// Run writes the buffered events until ctx is done, retrying failed
+// batches. Events still buffered then are written once more, with what's
+// left of the time the writer gives them.
+func (p *Publisher) Run(ctx context.Context) {
+	batch := make([]Message, 0, p.batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			p.flush(context.WithoutCancel(ctx), batch)
+			return
+		case event := <-p.events:
+			batch = p.collect(append(batch[:0], p.message(event)))
+		}
+
+		for {
+			err := p.writer.WriteMessages(ctx, batch...)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				p.flush(context.WithoutCancel(ctx), batch)
+				return
+			}
+			p.logger.Warn("Kafka Write Failed", slog.String("topic", p.topic), slog.Int("events", len(batch)), slog.Any("error", err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryDelay):
+			}
+		}
+		batch = batch[:0]
+	}
+}
+
+// collect adds the events already buffered to batch, up to the batch size.
+func (p *Publisher) collect(batch []Message) []Message {
+	for len(batch) < p.batchSize {
+		select {
+		case event := <-p.events:
+			batch = append(batch, p.message(event))
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// flush writes batch and the events still buffered once, on shutdown.
+func (p *Publisher) flush(ctx context.Context, batch []Message) {
+	for {
+		batch = p.collect(batch)
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.writer.WriteMessages(ctx, batch...); err != nil {
+			p.logger.Warn("Kafka Write Failed", slog.String("topic", p.topic), slog.Int("events", len(batch)+len(p.events)), slog.Any("error", err))
+			return
+		}
+		batch = batch[:0]
+	}
+}
+
+func (p *Publisher) message(event Event) Message {
+	value, _ := json.Marshal(event) // results are already JSON
+	return Message{
+		Topic:   p.topic,
+		Key:     []byte(event.ID),
+		Value:   value,
+		Headers: map[string]string{"type": event.Type},
+		Time:    event.Time,
+	}
+}
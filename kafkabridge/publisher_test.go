@@ -0,0 +1,121 @@
+package kafkabridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// memWriter keeps the records written to it, failing the first fail writes.
+type memWriter struct {
+	mu       sync.Mutex
+	fail     int
+	messages []Message
+}
+
+func (w *memWriter) WriteMessages(_ context.Context, msgs ...Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fail > 0 {
+		w.fail--
+		return errors.New("broker unavailable")
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *memWriter) written() []Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Message(nil), w.messages...)
+}
+
+// Test that terminal events are written with their results
+func TestPublisher(t *testing.T) {
+	w := &memWriter{}
+	p := NewPublisher(w, "task-events", WithResults())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	tm := asynctask.NewManager(asynctask.WithEventHandler(p.Handler()))
+	defer tm.Shutdown(context.Background())
+	taskCtx := asynctask.WithLabels(context.Background(), map[string]string{"tenant": "acme"})
+
+	ok := tm.Async(taskCtx, asynctask.RunnableFunc(func(context.Context) (any, error) {
+		return map[string]any{"rows": 3}, nil
+	}))
+	failed := tm.Async(taskCtx, asynctask.RunnableFunc(func(context.Context) (any, error) {
+		return nil, errors.New("boom")
+	}))
+	_, _ = tm.AwaitAll(context.Background(), []asynctask.ID{ok, failed})
+
+	deadline := time.Now().Add(time.Second)
+	for len(w.written()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d records, want 2", len(w.written()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	events := make(map[string]Event)
+	for _, msg := range w.written() {
+		if msg.Topic != "task-events" || msg.Headers["type"] == "" {
+			t.Fatalf("unexpected record %+v", msg)
+		}
+		var event Event
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg.Key) != event.ID || event.Labels["tenant"] != "acme" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		events[event.ID] = event
+	}
+	if got := events[ok.String()]; got.Type != "completed" || string(got.Result) != `{"rows":3}` {
+		t.Fatalf("got %+v, want completed with its result", got)
+	}
+	if got := events[failed.String()]; got.Type != "failed" || got.Error == "" || got.Result != nil {
+		t.Fatalf("got %+v, want failed with its error", got)
+	}
+}
+
+// Test that failed writes are retried and a full buffer drops events
+func TestPublisher_Backpressure(t *testing.T) {
+	w := &memWriter{fail: 1}
+	p := NewPublisher(w, "task-events", WithBuffer(2))
+	handler := p.Handler()
+	for range 3 {
+		handler(asynctask.Event{Type: asynctask.EventCompleted})
+	}
+	handler(asynctask.Event{Type: asynctask.EventStarted})
+	if got := p.Dropped(); got != 1 {
+		t.Fatalf("got %d dropped, want 1", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(3 * time.Second)
+	for len(w.written()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d records, want 2", len(w.written()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
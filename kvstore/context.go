@@ -0,0 +1,20 @@
+package kvstore
+
+import "context"
+
+type ctxKey struct{}
+
+// WithContext stores a Store in the context and returns a new derived
+// context containing it.
+func WithContext(ctx context.Context, store *Store) context.Context {
+	return context.WithValue(ctx, ctxKey{}, store)
+}
+
+// FromContext retrieves the Store from the provided context. If no Store is
+// found in the context, it creates and returns a new empty Store.
+func FromContext(ctx context.Context) *Store {
+	if store, ok := ctx.Value(ctxKey{}).(*Store); ok {
+		return store
+	}
+	return New()
+}
@@ -0,0 +1,152 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// Store is a concurrent in-memory key-value store with per-key TTLs.
+	// Values are opaque byte slices. All operations are thread-safe.
+	Store struct {
+		mu    sync.Mutex
+		items map[string]item
+	}
+
+	item struct {
+		value   []byte
+		expires time.Time // zero means no expiry
+	}
+)
+
+// New creates an empty Store.
+func New() *Store {
+	return &Store{items: make(map[string]item)}
+}
+
+func (i item) expired(now time.Time) bool {
+	return !i.expires.IsZero() && !now.Before(i.expires)
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// lookup returns the live item for key, evicting it if expired. Caller holds mu.
+func (s *Store) lookup(key string) (item, bool) {
+	it, ok := s.items[key]
+	if !ok {
+		return item{}, false
+	}
+	if it.expired(time.Now()) {
+		delete(s.items, key)
+		return item{}, false
+	}
+	return it, true
+}
+
+// Get returns the value stored under key and whether it exists.
+func (s *Store) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it, ok := s.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return it.value, true
+}
+
+// Set stores value under key. A ttl of zero or less keeps the key until it
+// is deleted.
+func (s *Store) Set(key string, value []byte, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[key] = item{value: bytes.Clone(value), expires: expiry(ttl)}
+}
+
+// Delete removes key. Returns false if the key did not exist.
+func (s *Store) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lookup(key); !ok {
+		return false
+	}
+	delete(s.items, key)
+	return true
+}
+
+// CompareAndSwap replaces the value of key with new if its current value
+// equals old. A nil old swaps only if the key does not exist. Returns whether
+// the swap happened.
+func (s *Store) CompareAndSwap(key string, old, new []byte, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it, ok := s.lookup(key)
+	if old == nil {
+		if ok {
+			return false
+		}
+	} else if !ok || !bytes.Equal(it.value, old) {
+		return false
+	}
+
+	s.items[key] = item{value: bytes.Clone(new), expires: expiry(ttl)}
+	return true
+}
+
+// Len returns the number of live keys.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for _, it := range s.items {
+		if !it.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// Prune removes expired keys. Returns count pruned.
+func (s *Store) Prune() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	pruned := 0
+	for key, it := range s.items {
+		if it.expired(now) {
+			delete(s.items, key)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// PruneEvery prunes expired keys at the given interval until ctx is done.
+// Expired keys are never returned either way; pruning only reclaims memory
+// for keys that are not read again.
+func (s *Store) PruneEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Prune()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package kvstore
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// Test basic get, set and delete
+func TestStore(t *testing.T) {
+	s := New()
+
+	_, ok := s.Get("missing")
+	assertEqual(t, ok, false)
+
+	s.Set("key", []byte("value"), 0)
+	value, ok := s.Get("key")
+	assertEqual(t, ok, true)
+	assertEqual(t, string(value), "value")
+
+	assertEqual(t, s.Delete("key"), true)
+	assertEqual(t, s.Delete("key"), false)
+
+	_, ok = s.Get("key")
+	assertEqual(t, ok, false)
+}
+
+// Test that Set copies the value
+func TestStore_SetCopiesValue(t *testing.T) {
+	s := New()
+
+	buf := []byte("value")
+	s.Set("key", buf, 0)
+	buf[0] = 'X'
+
+	value, _ := s.Get("key")
+	assertEqual(t, string(value), "value")
+}
+
+// Test TTL expiry and pruning
+func TestStore_TTL(t *testing.T) {
+	s := New()
+
+	s.Set("short", []byte("a"), 20*time.Millisecond)
+	s.Set("forever", []byte("b"), 0)
+	assertEqual(t, s.Len(), 2)
+
+	time.Sleep(40 * time.Millisecond)
+
+	_, ok := s.Get("short")
+	assertEqual(t, ok, false)
+	assertEqual(t, s.Len(), 1)
+
+	s.Set("short", []byte("a"), 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	assertEqual(t, s.Prune(), 1)
+
+	_, ok = s.Get("forever")
+	assertEqual(t, ok, true)
+}
+
+// Test compare-and-swap semantics
+func TestStore_CompareAndSwap(t *testing.T) {
+	s := New()
+
+	// nil old only swaps when the key is absent
+	assertEqual(t, s.CompareAndSwap("key", nil, []byte("1"), 0), true)
+	assertEqual(t, s.CompareAndSwap("key", nil, []byte("2"), 0), false)
+
+	assertEqual(t, s.CompareAndSwap("key", []byte("0"), []byte("2"), 0), false)
+	assertEqual(t, s.CompareAndSwap("key", []byte("1"), []byte("2"), 0), true)
+
+	value, _ := s.Get("key")
+	assertEqual(t, string(value), "2")
+
+	// Expired keys count as absent
+	s.Set("expiring", []byte("x"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assertEqual(t, s.CompareAndSwap("expiring", []byte("x"), []byte("y"), 0), false)
+	assertEqual(t, s.CompareAndSwap("expiring", nil, []byte("y"), 0), true)
+}
+
+// Test concurrent counter increments through a CAS loop
+func TestStore_ConcurrentCAS(t *testing.T) {
+	s := New()
+	s.Set("counter", []byte("0"), 0)
+
+	const workers, increments = 10, 100
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				for {
+					old, _ := s.Get("counter")
+					var n int
+					fmt.Sscan(string(old), &n)
+					if s.CompareAndSwap("counter", old, []byte(fmt.Sprint(n+1)), 0) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, _ := s.Get("counter")
+	assertEqual(t, string(value), fmt.Sprint(workers*increments))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/config"
+	"github.com/johanjanssens/frankenasync/server"
+)
+
+// adminOptions returns the admin API options configured by cfg.
+func adminOptions(cfg config.Admin, reload func() error) []admin.Option {
+	opts := []admin.Option{
+		admin.WithToken(cfg.Token),
+		admin.WithReload(reload),
+	}
+
+	// Profiling and goroutine dumps
+	if cfg.Debug {
+		opts = append(opts, admin.WithDebug())
+	}
+	if cfg.AuthAll {
+		opts = append(opts, admin.WithAuthAll())
+	}
+	if cfg.ClientCA != "" {
+		opts = append(opts, admin.WithClientCerts())
+	}
+	if len(cfg.CORSOrigins) > 0 {
+		opts = append(opts, admin.WithCORS(cfg.CORSOrigins...))
+	}
+	return opts
+}
+
+// listenerServer returns the server of a further listener, with the
+// handler of its role wrapped in its middleware.
+func listenerServer(srv *server.Server, cfg *config.Config, l config.Listener, accessLogger *slog.Logger, reload func() error) (*http.Server, error) {
+	tlsCfg, err := listenerTLSConfig(l.Cert, l.Key, l.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []admin.Option
+	if l.Auth {
+		opts = append(opts, admin.WithAuthAll())
+	}
+	if l.ClientCA != "" {
+		opts = append(opts, admin.WithClientCerts())
+	}
+
+	logged := func() bool { return l.AccessLog }
+
+	var handler http.Handler
+	switch l.Role {
+	case "app":
+		handler = srv
+		logged = func() bool { return l.AccessLog || srv.Config().AccessLog }
+	case "admin":
+		handler = srv.AdminHandler(append(adminOptions(cfg.Admin, reload), opts...)...)
+	case "metrics":
+		opts = append(opts, admin.WithToken(cfg.Admin.Token))
+		if cfg.Admin.Debug {
+			opts = append(opts, admin.WithDebug())
+		}
+		handler = srv.MetricsHandler(opts...)
+	}
+
+	s := &http.Server{
+		Addr:        l.Addr,
+		Handler:     accessLog(accessLogger, logged, handler),
+		TLSConfig:   tlsCfg,
+		ReadTimeout: 10 * time.Second,
+		IdleTimeout: 60 * time.Second,
+	}
+	if l.Role == "app" {
+		s.ReadTimeout = 30 * time.Second
+		s.WriteTimeout = 120 * time.Second
+	}
+	return s, nil
+}
+
+// listen serves s over TLS when it has a TLS configuration.
+func listen(s *http.Server) error {
+	if s.TLSConfig != nil {
+		return s.ListenAndServeTLS("", "")
+	}
+	return s.ListenAndServe()
+}
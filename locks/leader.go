@@ -0,0 +1,138 @@
+package locks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseTTL is the lease of leaders created without one. A leader
+// that died is replaced within it.
+const DefaultLeaseTTL = 15 * time.Second
+
+// releaseTimeout bounds releasing a lease once its leader stops.
+const releaseTimeout = 5 * time.Second
+
+// Leader elects one of the processes campaigning for the same name, such
+// as the servers all running the same scheduled jobs, which then only fire
+// on the leader (see Do). The leader holds a lease of the backend, a lock
+// it renews a few times per TTL. Once it dies, or can't renew its lease
+// before the lease expires, another process takes over.
+type Leader struct {
+	backend LeaseBackend
+	name    string
+	ttl     time.Duration
+
+	mu   sync.Mutex
+	lead context.Context // done once leadership is lost, nil before it's won
+}
+
+// NewLeader returns a leader campaigning for name on backend, with leases
+// of ttl or DefaultLeaseTTL when ttl isn't positive. Processes share
+// leadership through a backend they share, such as a redislock.Backend.
+func NewLeader(backend LeaseBackend, name string, ttl time.Duration) *Leader {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	return &Leader{backend: backend, name: name, ttl: ttl}
+}
+
+// Run campaigns until ctx is done. Each time it wins, it calls fn, if not
+// nil, with a context done once leadership is lost, and leads until then,
+// whether fn returned or not. Leadership is released when ctx is done, so
+// another process takes over without waiting for the lease to expire.
+func (l *Leader) Run(ctx context.Context, fn func(ctx context.Context)) {
+	for ctx.Err() == nil {
+		start := time.Now()
+		token, err := l.backend.Acquire(ctx, l.name, 1, l.ttl)
+		if err != nil {
+			// The backend failed, try again once it may be back
+			select {
+			case <-ctx.Done():
+			case <-time.After(l.interval()):
+			}
+			continue
+		}
+		l.hold(ctx, token, start, fn)
+	}
+}
+
+// hold leads with the lease of token, acquired at start, until it's lost
+// or ctx is done, then releases it.
+func (l *Leader) hold(ctx context.Context, token string, start time.Time, fn func(ctx context.Context)) {
+	lead, cancel := context.WithCancel(ctx)
+	l.mu.Lock()
+	l.lead = lead
+	l.mu.Unlock()
+
+	var wg sync.WaitGroup
+	if fn != nil {
+		wg.Go(func() { fn(lead) })
+	}
+
+	// Step down before the lease may have expired, so two leaders never
+	// overlap, even while a renewal hangs
+	valid := l.ttl - l.interval()
+	expire := time.AfterFunc(valid-time.Since(start), cancel)
+	defer expire.Stop()
+
+	ticker := time.NewTicker(l.interval())
+	defer ticker.Stop()
+	renewed := start
+	for lead.Err() == nil {
+		select {
+		case <-lead.Done():
+		case <-ticker.C:
+			// The lease is extended from some time after the renewal starts
+			attempt := time.Now()
+			renewCtx, cancelRenew := context.WithTimeout(lead, valid-attempt.Sub(renewed))
+			err := l.backend.Renew(renewCtx, l.name, token, l.ttl)
+			cancelRenew()
+			if err == nil {
+				renewed = attempt
+				expire.Reset(valid - time.Since(renewed))
+				continue
+			}
+			if errors.Is(err, ErrNotHeld) {
+				cancel()
+			}
+		}
+	}
+	cancel()
+	wg.Wait()
+
+	releaseCtx, cancelRelease := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancelRelease()
+	_ = l.backend.Release(releaseCtx, l.name, token)
+}
+
+// interval returns how often the lease is renewed.
+func (l *Leader) interval() time.Duration {
+	return l.ttl / 3
+}
+
+// IsLeader reports whether this process leads.
+func (l *Leader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lead != nil && l.lead.Err() == nil
+}
+
+// Do calls fn if this process leads, with a context also done once
+// leadership is lost, and reports whether it did. Every process runs a
+// scheduled job on the same schedule through Do, so it fires once.
+func (l *Leader) Do(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	l.mu.Lock()
+	lead := l.lead
+	l.mu.Unlock()
+	if lead == nil || lead.Err() != nil {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(lead, cancel)
+	defer stop()
+	return true, fn(ctx)
+}
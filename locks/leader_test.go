@@ -0,0 +1,159 @@
+package locks
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second passed.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Test that one of the campaigning processes leads, and another takes over
+// once it stops
+func TestLeader_Failover(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	a := NewLeader(m, "scheduler", time.Minute)
+	b := NewLeader(m, "scheduler", time.Minute)
+
+	var leading atomic.Int32
+	run := func(l *Leader) context.CancelFunc {
+		ctx, cancel := context.WithCancel(ctx)
+		go l.Run(ctx, func(ctx context.Context) {
+			leading.Add(1)
+			<-ctx.Done()
+			leading.Add(-1)
+		})
+		return cancel
+	}
+	stopA := run(a)
+	waitFor(t, a.IsLeader)
+	stopB := run(b)
+	defer stopB()
+
+	time.Sleep(10 * time.Millisecond)
+	if b.IsLeader() || leading.Load() != 1 {
+		t.Fatalf("got %d leaders, want 1", leading.Load())
+	}
+
+	// Only the leader runs scheduled jobs
+	var fired int
+	for _, l := range []*Leader{a, b} {
+		ran, err := l.Do(ctx, func(context.Context) error {
+			fired++
+			return nil
+		})
+		assertNoError(t, err)
+		if ran != (l == a) {
+			t.Fatalf("leader ran %v", ran)
+		}
+	}
+	if fired != 1 {
+		t.Fatalf("job fired %d times, want 1", fired)
+	}
+
+	stopA()
+	waitFor(t, b.IsLeader)
+	if a.IsLeader() {
+		t.Fatal("stopped leader still leads")
+	}
+}
+
+// Test that a leader that died is replaced once its lease expires, and
+// one losing its lease steps down
+func TestLeader_LeaseExpiry(t *testing.T) {
+	m := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A leader that died without releasing its lease
+	token, err := m.Acquire(ctx, "scheduler", 1, 50*time.Millisecond)
+	assertNoError(t, err)
+
+	l := NewLeader(m, "scheduler", 30*time.Millisecond)
+	lost := make(chan struct{}, 1)
+	start := time.Now()
+	go l.Run(ctx, func(ctx context.Context) {
+		<-ctx.Done()
+		select {
+		case lost <- struct{}{}:
+		default:
+		}
+	})
+	waitFor(t, l.IsLeader)
+	if time.Since(start) < 40*time.Millisecond {
+		t.Fatal("took over before the lease expired")
+	}
+	assertError(t, m.Renew(ctx, "scheduler", token, time.Minute), ErrNotHeld)
+
+	// Someone else taking the lease over ends leadership at the next renewal
+	m.mu.Lock()
+	clear(m.semaphores["scheduler"].holders)
+	m.mu.Unlock()
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("leader kept leading without its lease")
+	}
+}
+
+// hangingBackend is a Memory whose renewals hang until their context ends,
+// as they do against an unreachable server.
+type hangingBackend struct {
+	*Memory
+	renewals atomic.Int32
+}
+
+func (b *hangingBackend) Renew(ctx context.Context, name, token string, ttl time.Duration) error {
+	b.renewals.Add(1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// Test that a leader steps down before its lease expires while a renewal
+// hangs
+func TestLeader_HangingRenew(t *testing.T) {
+	b := &hangingBackend{Memory: NewMemory()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const ttl = 90 * time.Millisecond
+	l := NewLeader(b, "scheduler", ttl)
+	won := make(chan time.Time, 1)
+	lost := make(chan time.Time, 1)
+	go l.Run(ctx, func(ctx context.Context) {
+		won <- time.Now()
+		<-ctx.Done()
+		lost <- time.Now()
+	})
+
+	var start time.Time
+	select {
+	case start = <-won:
+	case <-time.After(time.Second):
+		t.Fatal("never led")
+	}
+	select {
+	case end := <-lost:
+		if end.Sub(start) >= ttl {
+			t.Fatalf("stepped down after %v, lease of %v", end.Sub(start), ttl)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("leader kept leading while its renewal hung")
+	}
+	if b.renewals.Load() == 0 {
+		t.Fatal("lease never renewed")
+	}
+}
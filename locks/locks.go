@@ -0,0 +1,42 @@
+package locks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var ErrNotHeld = errors.New("lock not held")
+
+// Backend grants named locks. A lock with a limit above one is a counting
+// semaphore: up to limit holders may hold it at once. Each holder receives a
+// token that must be presented to release it. Permits expire after their TTL
+// so a crashed holder cannot block others forever.
+type Backend interface {
+	// Acquire blocks until one of limit permits on name is granted or ctx is
+	// done. A ttl of zero or less never expires.
+	Acquire(ctx context.Context, name string, limit int, ttl time.Duration) (token string, err error)
+
+	// Release returns the permit identified by token. Returns ErrNotHeld if
+	// the token does not hold a permit, for example because it expired.
+	Release(ctx context.Context, name, token string) error
+}
+
+// LeaseBackend is a Backend whose permits can be extended before they
+// expire, as leaders keep theirs, see Leader.
+type LeaseBackend interface {
+	Backend
+
+	// Renew pushes the expiry of the permit identified by token to ttl from
+	// now. Returns ErrNotHeld if the token does not hold a permit anymore.
+	Renew(ctx context.Context, name, token string, ttl time.Duration) error
+}
+
+// NewToken returns a random permit token.
+func NewToken() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,150 @@
+package locks
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// Memory is an in-process Backend. Locks are only shared by requests
+	// served by the same process.
+	Memory struct {
+		mu         sync.Mutex
+		semaphores map[string]*semaphore
+	}
+
+	semaphore struct {
+		holders map[string]time.Time // token -> expiry, zero means none
+		wake    chan struct{}        // closed and replaced whenever a permit is returned
+	}
+)
+
+// NewMemory creates an empty in-process Backend.
+func NewMemory() *Memory {
+	return &Memory{semaphores: make(map[string]*semaphore)}
+}
+
+// semaphore returns the state for name, dropping expired holders. Caller holds mu.
+func (m *Memory) semaphore(name string, now time.Time) *semaphore {
+	s, ok := m.semaphores[name]
+	if !ok {
+		s = &semaphore{holders: make(map[string]time.Time), wake: make(chan struct{})}
+		m.semaphores[name] = s
+	}
+
+	for token, expires := range s.holders {
+		if !expires.IsZero() && !now.Before(expires) {
+			delete(s.holders, token)
+		}
+	}
+
+	return s
+}
+
+// nextExpiry returns when the earliest permit expires, if any do. Caller holds mu.
+func (s *semaphore) nextExpiry() (time.Time, bool) {
+	var next time.Time
+	for _, expires := range s.holders {
+		if !expires.IsZero() && (next.IsZero() || expires.Before(next)) {
+			next = expires
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Acquire implements Backend.
+func (m *Memory) Acquire(ctx context.Context, name string, limit int, ttl time.Duration) (token string, err error) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	for {
+		now := time.Now()
+
+		m.mu.Lock()
+		s := m.semaphore(name, now)
+		if len(s.holders) < limit {
+			token = NewToken()
+			var expires time.Time
+			if ttl > 0 {
+				expires = now.Add(ttl)
+			}
+			s.holders[token] = expires
+			m.mu.Unlock()
+			return token, nil
+		}
+
+		wake := s.wake
+		next, expiring := s.nextExpiry()
+		m.mu.Unlock()
+
+		// Wait for a release, or for the earliest permit to expire
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if expiring {
+			timer = time.NewTimer(next.Sub(now))
+			expired = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-wake:
+		case <-expired:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// Release implements Backend.
+func (m *Memory) Release(_ context.Context, name, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.semaphore(name, time.Now())
+	if _, ok := s.holders[token]; !ok {
+		if len(s.holders) == 0 {
+			delete(m.semaphores, name)
+		}
+		return ErrNotHeld
+	}
+
+	delete(s.holders, token)
+	close(s.wake)
+	s.wake = make(chan struct{})
+
+	if len(s.holders) == 0 {
+		delete(m.semaphores, name)
+	}
+
+	return nil
+}
+
+// Renew implements LeaseBackend.
+func (m *Memory) Renew(_ context.Context, name, token string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	s := m.semaphore(name, now)
+	if _, ok := s.holders[token]; !ok {
+		if len(s.holders) == 0 {
+			delete(m.semaphores, name)
+		}
+		return ErrNotHeld
+	}
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = now.Add(ttl)
+	}
+	s.holders[token] = expires
+	return nil
+}
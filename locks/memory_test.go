@@ -0,0 +1,130 @@
+package locks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func assertError(t *testing.T, err error, expected error) {
+	t.Helper()
+	if !errors.Is(err, expected) {
+		t.Fatalf("expected error %v, got %v", expected, err)
+	}
+}
+
+// Test mutual exclusion and release
+func TestMemory_Lock(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	token, err := m.Acquire(ctx, "rebuild", 1, 0)
+	assertNoError(t, err)
+
+	// A second holder times out while the lock is held
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = m.Acquire(timeoutCtx, "rebuild", 1, 0)
+	assertError(t, err, context.DeadlineExceeded)
+
+	assertNoError(t, m.Release(ctx, "rebuild", token))
+	assertError(t, m.Release(ctx, "rebuild", token), ErrNotHeld)
+
+	token, err = m.Acquire(ctx, "rebuild", 1, 0)
+	assertNoError(t, err)
+	assertNoError(t, m.Release(ctx, "rebuild", token))
+}
+
+// Test that a waiter is woken by a release
+func TestMemory_WaitForRelease(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	token, err := m.Acquire(ctx, "lock", 1, 0)
+	assertNoError(t, err)
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := m.Acquire(ctx, "lock", 1, 0)
+		acquired <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	assertNoError(t, m.Release(ctx, "lock", token))
+
+	select {
+	case err := <-acquired:
+		assertNoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not woken by release")
+	}
+}
+
+// Test that expired permits are reclaimed
+func TestMemory_TTL(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	token, err := m.Acquire(ctx, "lock", 1, 20*time.Millisecond)
+	assertNoError(t, err)
+
+	start := time.Now()
+	_, err = m.Acquire(ctx, "lock", 1, 0)
+	assertNoError(t, err)
+	if time.Since(start) < 15*time.Millisecond {
+		t.Fatal("lock acquired before the previous permit expired")
+	}
+
+	assertError(t, m.Release(ctx, "lock", token), ErrNotHeld)
+}
+
+// Test that a semaphore admits at most limit holders
+func TestMemory_Semaphore(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	const limit = 3
+
+	var current, peak int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := m.Acquire(ctx, "pool", limit, 0)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+
+			n := atomic.AddInt32(&current, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&current, -1)
+
+			if err := m.Release(ctx, "pool", token); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > limit {
+		t.Fatalf("peak holders %d exceeds limit %d", peak, limit)
+	}
+}
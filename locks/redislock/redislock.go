@@ -0,0 +1,172 @@
+// Package redislock implements locks.Backend on Redis, so locks and
+// semaphores are shared by every server using the same Redis instance.
+package redislock
+
+import (
+	"context"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/locks"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultPrefix       = "frankenasync:lock:"
+	defaultPollInterval = 25 * time.Millisecond
+	maxPollInterval     = 250 * time.Millisecond
+)
+
+// Each lock is a sorted set of permit tokens scored by their expiry in
+// milliseconds of the Redis clock, so servers whose clocks drift apart agree
+// on expiry. Expired permits are dropped before counting holders. The key
+// expires with its last permit, unless a permit without TTL persists it.
+var acquireScript = redis.NewScript(`
+local key, token = KEYS[1], ARGV[1]
+local limit, ttl = tonumber(ARGV[2]), tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+if redis.call('ZCARD', key) >= limit then
+	return 0
+end
+
+local pttl = redis.call('PTTL', key)
+if ttl > 0 then
+	redis.call('ZADD', key, now + ttl, token)
+	if pttl == -2 or (pttl >= 0 and pttl < ttl) then
+		redis.call('PEXPIRE', key, ttl)
+	end
+else
+	redis.call('ZADD', key, '+inf', token)
+	redis.call('PERSIST', key)
+end
+return 1
+`)
+
+// Renewing drops expired permits too, so an expired permit can't be
+// brought back once another holder may have taken its place.
+var renewScript = redis.NewScript(`
+local key, token = KEYS[1], ARGV[1]
+local ttl = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+if not redis.call('ZSCORE', key, token) then
+	return 0
+end
+
+local pttl = redis.call('PTTL', key)
+if ttl > 0 then
+	redis.call('ZADD', key, 'XX', now + ttl, token)
+	if pttl >= 0 and pttl < ttl then
+		redis.call('PEXPIRE', key, ttl)
+	end
+else
+	redis.call('ZADD', key, 'XX', '+inf', token)
+	redis.call('PERSIST', key)
+end
+return 1
+`)
+
+type (
+	// Backend is a Redis-backed locks.Backend. Waiting holders poll with
+	// backoff, so acquisition latency after a release is bounded by the
+	// poll interval rather than immediate.
+	Backend struct {
+		client       redis.UniversalClient
+		prefix       string
+		pollInterval time.Duration
+	}
+
+	Option func(*Backend)
+)
+
+// WithPrefix sets the key prefix for lock keys.
+func WithPrefix(prefix string) Option {
+	return func(b *Backend) {
+		b.prefix = prefix
+	}
+}
+
+// WithPollInterval sets the initial interval between acquisition attempts.
+func WithPollInterval(interval time.Duration) Option {
+	return func(b *Backend) {
+		if interval > 0 {
+			b.pollInterval = interval
+		}
+	}
+}
+
+// New creates a Backend using client.
+func New(client redis.UniversalClient, opts ...Option) *Backend {
+	b := &Backend{
+		client:       client,
+		prefix:       defaultPrefix,
+		pollInterval: defaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Acquire implements locks.Backend.
+func (b *Backend) Acquire(ctx context.Context, name string, limit int, ttl time.Duration) (string, error) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	token := locks.NewToken()
+	interval := b.pollInterval
+
+	for {
+		granted, err := acquireScript.Run(ctx, b.client, []string{b.prefix + name},
+			token, limit, ttl.Milliseconds()).Int()
+		if err != nil {
+			return "", err
+		}
+		if granted == 1 {
+			return token, nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+
+		interval = min(interval*2, maxPollInterval)
+	}
+}
+
+// Release implements locks.Backend.
+func (b *Backend) Release(ctx context.Context, name, token string) error {
+	removed, err := b.client.ZRem(ctx, b.prefix+name, token).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return locks.ErrNotHeld
+	}
+	return nil
+}
+
+// Renew implements locks.LeaseBackend.
+func (b *Backend) Renew(ctx context.Context, name, token string, ttl time.Duration) error {
+	renewed, err := renewScript.Run(ctx, b.client, []string{b.prefix + name},
+		token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if renewed == 0 {
+		return locks.ErrNotHeld
+	}
+	return nil
+}
+
+var _ locks.LeaseBackend = (*Backend)(nil)
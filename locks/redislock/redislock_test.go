@@ -0,0 +1,135 @@
+package redislock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/locks"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newBackend(t *testing.T) (*Backend, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return New(client, WithPollInterval(5*time.Millisecond)), server
+}
+
+// advance moves the clock of server, the one permits expire by, and the
+// TTLs of its keys forward by d.
+func advance(server *miniredis.Miniredis, start *time.Time, d time.Duration) {
+	*start = start.Add(d)
+	server.SetTime(*start)
+	server.FastForward(d)
+}
+
+// Test mutual exclusion and release
+func TestBackend_Lock(t *testing.T) {
+	b, _ := newBackend(t)
+	ctx := context.Background()
+
+	token, err := b.Acquire(ctx, "rebuild", 1, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer cancel()
+	if _, err := b.Acquire(timeoutCtx, "rebuild", 1, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	if err := b.Release(ctx, "rebuild", token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Release(ctx, "rebuild", token); !errors.Is(err, locks.ErrNotHeld) {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+}
+
+// Test that a semaphore admits limit holders and expired permits are reclaimed
+func TestBackend_Semaphore(t *testing.T) {
+	b, server := newBackend(t)
+	ctx := context.Background()
+	now := time.Now()
+	server.SetTime(now)
+
+	for range 2 {
+		if _, err := b.Acquire(ctx, "pool", 2, 30*time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.Acquire(timeoutCtx, "pool", 2, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("third holder admitted before a permit expired: %v", err)
+	}
+
+	advance(server, &now, 30*time.Second)
+	if _, err := b.Acquire(ctx, "pool", 2, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Test that a permit without TTL keeps its lock from expiring
+func TestBackend_Persist(t *testing.T) {
+	b, server := newBackend(t)
+	ctx := context.Background()
+	now := time.Now()
+	server.SetTime(now)
+
+	if _, err := b.Acquire(ctx, "pool", 2, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := b.Acquire(ctx, "pool", 2, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ttl := server.TTL(b.prefix + "pool"); ttl != 0 {
+		t.Fatalf("persisted lock expires in %v", ttl)
+	}
+
+	// The expired permit is reclaimed, the one without TTL is not
+	advance(server, &now, time.Hour)
+	if _, err := b.Acquire(ctx, "pool", 2, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.Acquire(timeoutCtx, "pool", 2, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("permit without TTL expired: %v", err)
+	}
+}
+
+// Test that renewing extends a permit, and fails once it expired
+func TestBackend_Renew(t *testing.T) {
+	b, server := newBackend(t)
+	ctx := context.Background()
+	now := time.Now()
+	server.SetTime(now)
+
+	token, err := b.Acquire(ctx, "leader", 1, 40*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	advance(server, &now, 20*time.Second)
+	if err := b.Renew(ctx, "leader", token, 40*time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	advance(server, &now, 30*time.Second)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	if _, err := b.Acquire(timeoutCtx, "leader", 1, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquired a renewed permit: %v", err)
+	}
+
+	advance(server, &now, 20*time.Second)
+	if err := b.Renew(ctx, "leader", token, time.Minute); !errors.Is(err, locks.ErrNotHeld) {
+		t.Fatalf("expected ErrNotHeld, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+// Package logging filters the server's logs by component and thins out
+// high-frequency debug records. Loggers name their component with a
+// "component" attribute, so one subsystem can log at debug level while the
+// rest stays at info.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// ComponentKey is the attribute naming the component a logger belongs to.
+const ComponentKey = "component"
+
+// Components of the server with their own log level.
+const (
+	Manager = "manager" // task managers
+	PHPExt  = "phpext"  // FrankenPHP and the PHP extension
+	Server  = "server"  // the HTTP server
+	Access  = "access"  // the access log
+)
+
+// Components lists the components with their own log level.
+var Components = []string{Manager, PHPExt, Server, Access}
+
+type (
+	// Levels holds the log level of each component, falling back to a
+	// default level for the others. It can be changed while in use.
+	Levels struct {
+		mu     sync.RWMutex
+		def    slog.Level
+		levels map[string]slog.Level
+	}
+
+	// Sampler passes 1 in n debug records of each message, so events
+	// logged for every task don't flood production logs. Records at info
+	// level and above always pass.
+	Sampler struct {
+		every  atomic.Int64
+		mu     sync.Mutex
+		counts map[string]int64
+	}
+
+	levelHandler struct {
+		next      slog.Handler
+		levels    *Levels
+		component string
+	}
+
+	sampleHandler struct {
+		next    slog.Handler
+		sampler *Sampler
+	}
+)
+
+// NewLevels returns the levels of components, def for the others.
+func NewLevels(def slog.Level, components map[string]slog.Level) *Levels {
+	l := &Levels{}
+	l.Set(def, components)
+	return l
+}
+
+// Set replaces the default level and those of components.
+func (l *Levels) Set(def slog.Level, components map[string]slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.def = def
+	l.levels = components
+}
+
+// Level returns the level of component.
+func (l *Levels) Level(component string) slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if level, ok := l.levels[component]; ok {
+		return level
+	}
+	return l.def
+}
+
+// Handler returns a handler passing records to next when they're at or
+// above the level of their component. next should let every level
+// through. The component attribute is moved to the end of each record, so
+// a logger derived from another names only its own component.
+func (l *Levels) Handler(next slog.Handler) slog.Handler {
+	return &levelHandler{next: next, levels: l}
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.levels.Level(h.component) && h.next.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.component != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String(ComponentKey, h.component))
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	rest := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		if attr.Key == ComponentKey {
+			clone.component = attr.Value.String()
+			continue
+		}
+		rest = append(rest, attr)
+	}
+	if len(rest) > 0 {
+		clone.next = h.next.WithAttrs(rest)
+	}
+	return &clone
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.next = h.next.WithGroup(name)
+	return &clone
+}
+
+// NewSampler returns a sampler passing 1 in n debug records. n below 2
+// passes them all.
+func NewSampler(n int) *Sampler {
+	s := &Sampler{counts: make(map[string]int64)}
+	s.SetRate(n)
+	return s
+}
+
+// SetRate changes n.
+func (s *Sampler) SetRate(n int) {
+	s.every.Store(int64(max(n, 1)))
+}
+
+// Handler returns a handler passing the sampled records to next. Handlers
+// of one sampler share its counts.
+func (s *Sampler) Handler(next slog.Handler) slog.Handler {
+	return &sampleHandler{next: next, sampler: s}
+}
+
+// keep reports whether to pass on a debug record with message msg: the
+// first and every nth after it.
+func (s *Sampler) keep(msg string) bool {
+	every := s.every.Load()
+	if every == 1 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.counts[msg]
+	s.counts[msg] = n + 1
+	return n%every == 0
+}
+
+func (h *sampleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *sampleHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level <= slog.LevelDebug && !h.sampler.keep(r.Message) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *sampleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sampleHandler{next: h.next.WithAttrs(attrs), sampler: h.sampler}
+}
+
+func (h *sampleHandler) WithGroup(name string) slog.Handler {
+	return &sampleHandler{next: h.next.WithGroup(name), sampler: h.sampler}
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func newBuffer() (*bytes.Buffer, slog.Handler) {
+	var out bytes.Buffer
+	return &out, slog.NewTextHandler(&out, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+}
+
+// Test components log at their own level
+func TestLevels(t *testing.T) {
+	out, base := newBuffer()
+	levels := NewLevels(slog.LevelInfo, map[string]slog.Level{Manager: slog.LevelDebug})
+	logger := slog.New(levels.Handler(base))
+
+	logger.Debug("dropped")
+	logger.With(ComponentKey, Server).Debug("dropped too")
+	logger.With(ComponentKey, Server).With(ComponentKey, Manager).Debug("kept", "id", 1)
+	assertEqual(t, out.String(), "level=DEBUG msg=kept id=1 component=manager\n")
+
+	// Levels change while in use
+	out.Reset()
+	levels.Set(slog.LevelDebug, map[string]slog.Level{Manager: slog.LevelWarn})
+	logger.Debug("kept")
+	logger.With(ComponentKey, Manager).Info("dropped")
+	assertEqual(t, out.String(), "level=DEBUG msg=kept\n")
+}
+
+// Test debug records are sampled per message
+func TestSampler(t *testing.T) {
+	out, base := newBuffer()
+	sampler := NewSampler(3)
+
+	// Loggers of one sampler share its counts
+	for i := range 6 {
+		logger := slog.New(sampler.Handler(base))
+		logger.Debug("submitted", "i", i)
+		logger.Info("always", "i", i)
+	}
+	assertEqual(t, strings.Count(out.String(), "msg=submitted"), 2)
+	assertEqual(t, strings.Count(out.String(), "msg=always"), 6)
+	if !strings.Contains(out.String(), "msg=submitted i=3") {
+		t.Fatalf("expected every third record, got %s", out)
+	}
+
+	out.Reset()
+	sampler.SetRate(0)
+	logger := slog.New(sampler.Handler(base))
+	logger.Debug("submitted")
+	logger.Debug("submitted")
+	assertEqual(t, strings.Count(out.String(), "msg=submitted"), 2)
+}
@@ -2,203 +2,257 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"log/slog"
-	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"runtime"
-	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
-	"github.com/johanjanssens/frankenasync/asynctask"
-	"github.com/johanjanssens/frankenasync/phpext"
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/config"
+	"github.com/johanjanssens/frankenasync/logging"
+	"github.com/johanjanssens/frankenasync/server"
 
-	"github.com/dunglas/frankenphp"
 	"github.com/joho/godotenv"
 	"github.com/lmittmann/tint"
+	"github.com/quic-go/quic-go/http3"
+	"google.golang.org/grpc"
 )
 
 func main() {
 	// Load .env if present
 	_ = godotenv.Load()
 
-	// Set up logger
-	logger := slog.New(tint.NewHandler(os.Stdout, &tint.Options{
+	os.Exit(run(os.Args[1:]))
+}
+
+// serve runs the HTTP server until SIGINT or SIGTERM.
+func serve(configPath string) {
+	// Set up logger, at levels per component that can change on reload
+	logLevels := logging.NewLevels(slog.LevelInfo, nil)
+	logger := slog.New(logLevels.Handler(tint.NewHandler(os.Stdout, &tint.Options{
 		Level:      slog.LevelDebug,
 		TimeFormat: time.Kitchen,
-	}))
+	})))
 	slog.SetDefault(logger)
 
+	// Load frankenasync.yaml (or FRANKENASYNC_CONFIG), with env vars as overrides
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		logger.Error("Invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	logLevels.Set(cfg.Level(), cfg.ComponentLevels())
+
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	// Resolve document root
-	docRoot, err := filepath.Abs("examples")
+	srv, err := server.New(cfg, server.WithLogger(logger))
 	if err != nil {
-		logger.Error("Failed to resolve document root", "error", err)
+		logger.Error("Failed to start FrankenAsync", "error", err)
 		os.Exit(1)
 	}
+	defer srv.Shutdown(context.Background())
 
-	// Register PHP extension
-	phpext.Register()
-	phpext.DocumentRoot = docRoot
-
-	// Thread pool: starts with numThreads, autoscales up to maxThreads under load.
-	// The worker semaphore is capped at maxThreads-2 so subrequests flow to
-	// FrankenPHP and trigger autoscaling when threads are saturated.
-	numCPU := runtime.NumCPU()
-	numThreads := numCPU * 4
-	if v := os.Getenv("FRANKENASYNC_THREADS"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 {
-			numThreads = n
+	reloadConfig := func() error {
+		next, err := config.Load(configPath)
+		if err != nil {
+			logger.Error("Rejected configuration reload", "error", err)
+			return err
 		}
-	}
-
-	maxThreads := numThreads * 4
-	workerLimit := maxThreads - 2
-	if v := os.Getenv("FRANKENASYNC_WORKERS"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil && n > 0 {
-			workerLimit = n
+		if keys := srv.Config().RestartRequired(next); len(keys) > 0 {
+			logger.Warn("Configuration changes take effect after a restart", "keys", keys)
 		}
-	}
-	if workerLimit > maxThreads-2 {
-		logger.Warn("Capping worker limit to max thread pool size", "requested", workerLimit, "capped", maxThreads-2)
-		workerLimit = maxThreads - 2
+		srv.Reload(next)
+		logLevels.Set(next.Level(), next.ComponentLevels())
+		logger.Info("Configuration reloaded", "workers", srv.Workers(), "log_level", next.LogLevel)
+		return nil
 	}
 
-	phpIni := map[string]string{
-		"opcache.enable":               "1",
-		"opcache.enable_file_override": "1",
-		"opcache.validate_timestamps":  "0",
-		"include_path":                 docRoot,
-		"swow.enable":                  "0",
-	}
+	// Access log, switched on and off by reloads
+	accessLogger := logger.With(logging.ComponentKey, logging.Access)
+	handler := accessLog(accessLogger, func() bool {
+		return srv.Config().AccessLog
+	}, srv)
 
-	// Init FrankenPHP
-	initOptions := []frankenphp.Option{
-		frankenphp.WithNumThreads(numThreads),
-		frankenphp.WithMaxThreads(maxThreads),
-		frankenphp.WithLogger(logger),
-		frankenphp.WithPhpIni(phpIni),
+	// Set up HTTP server
+	addr := cfg.Addr
+	httpServer := &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 120 * time.Second,
+		IdleTimeout:  60 * time.Second,
 	}
 
-	if err := frankenphp.Init(initOptions...); err != nil {
-		logger.Error("Failed to initialize FrankenPHP", "error", err)
+	// TLS termination. net/http negotiates HTTP/2 over TLS by itself, and
+	// HTTP/3 listens on the same port over UDP.
+	httpServer.TLSConfig, err = tlsConfig(cfg.TLS)
+	if err != nil {
+		logger.Error("Failed to configure TLS", "error", err)
 		os.Exit(1)
 	}
-	defer frankenphp.Shutdown()
 
-	// Set up HTTP handler
-	addr := ":8081"
-	if port := os.Getenv("FRANKENASYNC_PORT"); port != "" {
-		addr = ":" + port
+	var h3Server *http3.Server
+	if httpServer.TLSConfig != nil && cfg.TLS.HTTP3 {
+		h3Server = &http3.Server{
+			Addr:        addr,
+			Handler:     handler,
+			TLSConfig:   http3.ConfigureTLSConfig(httpServer.TLSConfig),
+			IdleTimeout: 60 * time.Second,
+		}
+		httpServer.Handler = advertiseHTTP3(h3Server, handler)
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Local API endpoint — simulates JSONPlaceholder with realistic latency
-		if strings.HasPrefix(r.URL.Path, "/api/comments/") {
-			idStr := strings.TrimPrefix(r.URL.Path, "/api/comments/")
-			id, err := strconv.Atoi(idStr)
-			if err != nil || id < 1 {
-				http.Error(w, "Invalid comment ID", http.StatusBadRequest)
-				return
-			}
-
-			delay := time.Duration(50+rand.Intn(100)) * time.Millisecond
-			time.Sleep(delay)
-
-			names := []string{
-				"id labore ex et quam laborum",
-				"quo vero reiciendis velit similique earum",
-				"odio adipisci rerum aut animi",
-				"alias odio sit",
-				"vero eaque aliquid doloribus et culpa",
-				"et fugit eligendi deleniti quidem qui sint nihil autem",
-				"repellat consequatur praesentium vel minus",
-				"et omnis dolorem",
-				"provident id voluptas",
-				"eaque et deleniti atque tenetur ut quo ut",
-			}
+	// Admin API on its own listener, never exposed on the public port
+	var adminServer *http.Server
+	if adminAddr := cfg.Admin.Addr; adminAddr != "" {
+		adminTLS, err := listenerTLSConfig(cfg.Admin.Cert, cfg.Admin.Key, cfg.Admin.ClientCA)
+		if err != nil {
+			logger.Error("Failed to configure admin TLS", "error", err)
+			os.Exit(1)
+		}
 
-			comment := map[string]any{
-				"postId": ((id - 1) / 5) + 1,
-				"id":     id,
-				"name":   names[(id-1)%len(names)],
-				"email":  fmt.Sprintf("user%d@example.com", id),
-				"body":   fmt.Sprintf("Comment body for comment %d", id),
-			}
+		adminHandler := srv.AdminHandler(adminOptions(cfg.Admin, reloadConfig)...)
 
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(comment)
-			return
+		adminServer = &http.Server{
+			Addr:        adminAddr,
+			Handler:     adminHandler,
+			TLSConfig:   adminTLS,
+			ReadTimeout: 10 * time.Second,
+			IdleTimeout: 60 * time.Second,
 		}
 
-		// Rewrite directory requests to index.php
-		if r.URL.Path == "/" || strings.HasSuffix(r.URL.Path, "/") {
-			r.URL.Path = r.URL.Path + "index.php"
-		}
+		go func() {
+			logger.Info("Starting admin API", "addr", adminAddr, "tls", adminTLS != nil)
+			if err := listen(adminServer); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Admin server error", "error", err)
+			}
+		}()
+	}
 
-		// Create async task manager for this request
-		taskManager := asynctask.NewManager(
-			asynctask.WithWorkerLimit(workerLimit),
-			asynctask.WithLogger(logger.Handler()),
-		)
-
-		// Store manager in request context
-		reqCtx := asynctask.WithContext(r.Context(), taskManager)
-		r = r.WithContext(reqCtx)
-
-		// Create FrankenPHP request
-		req, err := frankenphp.NewRequestWithContext(r,
-			frankenphp.WithRequestResolvedDocumentRoot(docRoot),
-			frankenphp.WithRequestLogger(logger),
-		)
+	// Further listeners, each serving the site, the admin API or metrics
+	// with its own TLS and middleware
+	var listenerServers []*http.Server
+	for _, l := range cfg.Listeners {
+		ls, err := listenerServer(srv, cfg, l, accessLogger, reloadConfig)
 		if err != nil {
-			logger.Error("Failed to create FrankenPHP request", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+			logger.Error("Failed to configure listener", "addr", l.Addr, "role", l.Role, "error", err)
+			os.Exit(1)
 		}
+		listenerServers = append(listenerServers, ls)
 
-		if err := frankenphp.ServeHTTP(w, req); err != nil {
-			logger.Error("Failed to serve PHP", "error", err)
+		go func() {
+			logger.Info("Starting listener", "addr", l.Addr, "role", l.Role, "tls", ls.TLSConfig != nil)
+			if err := listen(ls); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Listener error", "addr", l.Addr, "role", l.Role, "error", err)
+			}
+		}()
+	}
+
+	// gRPC task API for other services, on its own listener
+	var grpcServer *grpc.Server
+	if grpcAddr := cfg.GRPC.Addr; grpcAddr != "" {
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			logger.Error("Failed to listen for gRPC", "error", err)
+			os.Exit(1)
 		}
 
-		// Shutdown task manager after request completes
-		taskManager.Shutdown(r.Context())
-	})
+		grpcServer = srv.TaskService().Server()
 
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      mux,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 120 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		go func() {
+			logger.Info("Starting gRPC task API", "addr", grpcAddr)
+			if err := grpcServer.Serve(lis); err != nil {
+				logger.Error("gRPC server error", "error", err)
+			}
+		}()
 	}
 
+	// SIGUSR1 logs the state of every request's task manager, SIGQUIT dumps
+	// goroutine stacks without exiting, SIGHUP reloads the configuration
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGQUIT, syscall.SIGHUP)
+		for sig := range sigs {
+			switch sig {
+			case syscall.SIGHUP:
+				_ = reloadConfig() // logged, the running configuration stays in place
+			case syscall.SIGUSR1:
+				srv.LogState(ctx)
+			case syscall.SIGQUIT:
+				logger.Info("Dumping goroutines to stderr")
+				if err := admin.WriteGoroutines(os.Stderr); err != nil {
+					logger.Error("Failed to dump goroutines", "error", err)
+				}
+			}
+		}
+	}()
+
 	// Start server in goroutine
 	go func() {
-		logger.Info("Starting FrankenAsync server", "addr", addr, "threads", numThreads, "workers", workerLimit, "cpus", numCPU)
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Info("Starting FrankenAsync server", "addr", addr, "tls", httpServer.TLSConfig != nil, "threads", srv.Threads().Total, "workers", srv.Workers(), "cpus", runtime.NumCPU())
+
+		var err error
+		if httpServer.TLSConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("Server error", "error", err)
 			cancel()
 		}
 	}()
 
+	if h3Server != nil {
+		go func() {
+			logger.Info("Starting HTTP/3 listener", "addr", addr)
+			if err := h3Server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("HTTP/3 server error", "error", err)
+			}
+		}()
+	}
+
 	// Wait for shutdown signal
 	<-ctx.Done()
 	logger.Info("Shutting down...")
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
-	if err := server.Shutdown(shutdownCtx); err != nil {
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		logger.Error("Failed to shutdown server", "error", err)
 	}
+	if h3Server != nil {
+		if err := h3Server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Failed to shutdown HTTP/3 server", "error", err)
+		}
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Failed to shutdown admin server", "error", err)
+		}
+	}
+	for _, ls := range listenerServers {
+		if err := ls.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Failed to shutdown listener", "addr", ls.Addr, "error", err)
+		}
+	}
+	if grpcServer != nil {
+		// Event streams only end with their callers, don't wait for them
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			grpcServer.Stop()
+		}
+	}
 }
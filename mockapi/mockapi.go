@@ -0,0 +1,294 @@
+// Package mockapi serves canned responses with simulated latency and
+// failures, standing in for a remote API in demos and load tests.
+package mockapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+type (
+	// Option configures the mock API handler.
+	Option func(*handler)
+
+	// Route answers requests matching Path with Template rendered as the
+	// response body. Path is an http.ServeMux pattern such as
+	// "/api/comments/{id}", optionally prefixed with a method.
+	Route struct {
+		Path        string
+		Status      int    // 200 when zero
+		ContentType string // application/json when empty
+		Template    string
+	}
+
+	// Latency is the distribution response delays are drawn from.
+	//
+	//   - fixed: always Mean
+	//   - uniform: between Min and Max
+	//   - normal: around Mean with StdDev
+	//   - exponential: averaging Mean, with a long tail
+	//
+	// Delays of every distribution are clamped to Min and, when set, Max.
+	Latency struct {
+		Distribution string
+		Min          time.Duration
+		Max          time.Duration
+		Mean         time.Duration
+		StdDev       time.Duration
+	}
+
+	handler struct {
+		routes      []Route
+		latency     Latency
+		errorRate   float64
+		errorStatus int
+		mux         *http.ServeMux
+		next        http.Handler
+	}
+
+	// request is the data a route template renders.
+	request struct {
+		r *http.Request
+	}
+
+	// paramError reports a path parameter that isn't a positive integer.
+	paramError struct {
+		name, value string
+	}
+)
+
+// Comments mimics the JSONPlaceholder comments endpoint. It's served when
+// no routes are configured.
+var Comments = Route{
+	Path: "GET /api/comments/{id}",
+	Template: `{{- $id := .Int "id" -}}
+{"postId":{{add (div (sub $id 1) 5) 1}},"id":{{$id}},` +
+		`"name":{{json (pick (sub $id 1) "id labore ex et quam laborum" "quo vero reiciendis velit similique earum" ` +
+		`"odio adipisci rerum aut animi" "alias odio sit" "vero eaque aliquid doloribus et culpa" ` +
+		`"et fugit eligendi deleniti quidem qui sint nihil autem" "repellat consequatur praesentium vel minus" ` +
+		`"et omnis dolorem" "provident id voluptas" "eaque et deleniti atque tenetur ut quo ut")}},` +
+		`"email":"user{{$id}}@example.com","body":"Comment body for comment {{$id}}"}
+`,
+}
+
+// funcs are available to route templates.
+var funcs = template.FuncMap{
+	"add": func(a, b int) int { return a + b },
+	"sub": func(a, b int) int { return a - b },
+	"mul": func(a, b int) int { return a * b },
+	"div": func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	},
+	"mod": func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a % b, nil
+	},
+	// pick returns the i-th item, wrapping around
+	"pick": func(i int, items ...string) string {
+		if len(items) == 0 {
+			return ""
+		}
+		return items[((i%len(items))+len(items))%len(items)]
+	},
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// WithRoutes replaces the default Comments route.
+func WithRoutes(routes ...Route) Option {
+	return func(h *handler) {
+		h.routes = routes
+	}
+}
+
+// WithLatency delays every response by a duration drawn from latency.
+func WithLatency(latency Latency) Option {
+	return func(h *handler) {
+		h.latency = latency
+	}
+}
+
+// WithErrors fails a share of requests, between 0 and 1, with status (503
+// when zero) after the usual delay.
+func WithErrors(rate float64, status int) Option {
+	return func(h *handler) {
+		h.errorRate = rate
+		h.errorStatus = status
+	}
+}
+
+// Handler serves the routes and passes requests matching none of them to
+// next. It fails on an invalid template, pattern or latency distribution.
+func Handler(next http.Handler, opts ...Option) (http.Handler, error) {
+	h := &handler{
+		routes:      []Route{Comments},
+		errorStatus: http.StatusServiceUnavailable,
+		mux:         http.NewServeMux(),
+		next:        next,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.errorStatus == 0 {
+		h.errorStatus = http.StatusServiceUnavailable
+	}
+
+	if err := h.latency.validate(); err != nil {
+		return nil, err
+	}
+	if h.errorRate < 0 || h.errorRate > 1 {
+		return nil, fmt.Errorf("error rate %v is not between 0 and 1", h.errorRate)
+	}
+
+	for _, route := range h.routes {
+		if err := h.register(route); err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.Path, err)
+		}
+	}
+	return h, nil
+}
+
+// register adds a route to the mux. ServeMux panics on invalid or
+// conflicting patterns, which is reported as an error instead.
+func (h *handler) register(route Route) (err error) {
+	tmpl, err := template.New(route.Path).Funcs(funcs).Parse(route.Template)
+	if err != nil {
+		return err
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType := route.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	h.mux.Handle(route.Path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, request{r}); err != nil {
+			var perr *paramError
+			if errors.As(err, &perr) {
+				http.Error(w, perr.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		w.Write(body.Bytes())
+	}))
+	return nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := h.mux.Handler(r); pattern == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	// Wait as a remote API would, unless the client goes away first
+	timer := time.NewTimer(h.latency.sample())
+	defer timer.Stop()
+	select {
+	case <-r.Context().Done():
+		return
+	case <-timer.C:
+	}
+
+	if h.errorRate > 0 && rand.Float64() < h.errorRate {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(h.errorStatus)
+		w.Write([]byte(`{"error":"injected failure"}` + "\n"))
+		return
+	}
+
+	// The mux sets the path values the templates read
+	h.mux.ServeHTTP(w, r)
+}
+
+// sample draws a delay from the distribution.
+func (l Latency) sample() time.Duration {
+	var d time.Duration
+	switch l.Distribution {
+	case "fixed":
+		d = l.Mean
+	case "uniform":
+		if l.Max > l.Min {
+			d = l.Min + rand.N(l.Max-l.Min)
+		}
+	case "normal":
+		d = l.Mean + time.Duration(rand.NormFloat64()*float64(l.StdDev))
+	case "exponential":
+		d = time.Duration(rand.ExpFloat64() * float64(l.Mean))
+	}
+
+	d = max(d, l.Min, 0)
+	if l.Max > 0 {
+		d = min(d, l.Max)
+	}
+	return d
+}
+
+func (l Latency) validate() error {
+	switch l.Distribution {
+	case "", "fixed", "uniform", "normal", "exponential":
+	default:
+		return fmt.Errorf("unknown latency distribution %q", l.Distribution)
+	}
+	if l.Min < 0 || l.Max < 0 || l.Mean < 0 || l.StdDev < 0 {
+		return errors.New("latency durations must not be negative")
+	}
+	if l.Max > 0 && l.Min > l.Max {
+		return errors.New("latency min exceeds max")
+	}
+	return nil
+}
+
+// Param returns a path parameter.
+func (req request) Param(name string) string {
+	return req.r.PathValue(name)
+}
+
+// Int returns a path parameter as a positive integer. Anything else fails
+// the request with 400 Bad Request.
+func (req request) Int(name string) (int, error) {
+	value := req.r.PathValue(name)
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > math.MaxInt32 {
+		return 0, &paramError{name, value}
+	}
+	return n, nil
+}
+
+// Query returns a query string parameter.
+func (req request) Query(name string) string {
+	return req.r.URL.Query().Get(name)
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid %s %q", e.name, e.value)
+}
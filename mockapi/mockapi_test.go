@@ -0,0 +1,161 @@
+package mockapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func newHandler(t *testing.T, opts ...Option) http.Handler {
+	t.Helper()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("next"))
+	})
+	h, err := Handler(next, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func get(h http.Handler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// Test the default comments route
+func TestHandler_Comments(t *testing.T) {
+	h := newHandler(t)
+
+	rec := get(h, "/api/comments/7")
+	assertEqual(t, rec.Code, http.StatusOK)
+	assertEqual(t, rec.Header().Get("Content-Type"), "application/json")
+
+	var comment struct {
+		PostID int    `json:"postId"`
+		ID     int    `json:"id"`
+		Name   string `json:"name"`
+		Email  string `json:"email"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &comment); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+	}
+	assertEqual(t, comment.PostID, 2)
+	assertEqual(t, comment.ID, 7)
+	assertEqual(t, comment.Name, "repellat consequatur praesentium vel minus")
+	assertEqual(t, comment.Email, "user7@example.com")
+
+	// Names wrap around
+	rec = get(h, "/api/comments/11")
+	json.Unmarshal(rec.Body.Bytes(), &comment)
+	assertEqual(t, comment.Name, "id labore ex et quam laborum")
+
+	assertEqual(t, get(h, "/api/comments/0").Code, http.StatusBadRequest)
+	assertEqual(t, get(h, "/api/comments/abc").Code, http.StatusBadRequest)
+
+	// Everything else goes to the next handler
+	assertEqual(t, get(h, "/api/posts/1").Body.String(), "next")
+	assertEqual(t, get(h, "/index.php").Body.String(), "next")
+}
+
+// Test configured routes and template data
+func TestHandler_Routes(t *testing.T) {
+	h := newHandler(t, WithRoutes(
+		Route{
+			Path:        "/users/{name}",
+			ContentType: "text/plain",
+			Template:    `hello {{.Param "name"}}{{with .Query "suffix"}}{{.}}{{end}}`,
+		},
+		Route{
+			Path:     "POST /orders",
+			Status:   http.StatusCreated,
+			Template: `{"total":{{mul 3 (mod 14 5)}}}`,
+		},
+	))
+
+	rec := get(h, "/users/ann?suffix=!")
+	assertEqual(t, rec.Code, http.StatusOK)
+	assertEqual(t, rec.Header().Get("Content-Type"), "text/plain")
+	assertEqual(t, rec.Body.String(), "hello ann!")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders", nil))
+	assertEqual(t, rec.Code, http.StatusCreated)
+	assertEqual(t, rec.Body.String(), `{"total":12}`)
+
+	// Routes replace the comments default
+	assertEqual(t, get(h, "/api/comments/1").Body.String(), "next")
+}
+
+// Test that a full error rate fails every request
+func TestHandler_Errors(t *testing.T) {
+	h := newHandler(t, WithErrors(1, http.StatusBadGateway))
+
+	rec := get(h, "/api/comments/1")
+	assertEqual(t, rec.Code, http.StatusBadGateway)
+	if !strings.Contains(rec.Body.String(), "injected failure") {
+		t.Fatalf("unexpected body %q", rec.Body.String())
+	}
+
+	// Unmatched requests are never failed
+	assertEqual(t, get(h, "/other").Code, http.StatusOK)
+}
+
+// Test that responses are delayed
+func TestHandler_Latency(t *testing.T) {
+	h := newHandler(t, WithLatency(Latency{Distribution: "fixed", Mean: 20 * time.Millisecond}))
+
+	start := time.Now()
+	assertEqual(t, get(h, "/api/comments/1").Code, http.StatusOK)
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("response after %v, want at least 20ms", elapsed)
+	}
+}
+
+// Test that invalid settings are rejected
+func TestHandler_Invalid(t *testing.T) {
+	tests := map[string][]Option{
+		"template":     {WithRoutes(Route{Path: "/a", Template: "{{"})},
+		"pattern":      {WithRoutes(Route{Path: "a b c", Template: ""})},
+		"conflict":     {WithRoutes(Route{Path: "/a/{x}"}, Route{Path: "/a/{y}"})},
+		"distribution": {WithLatency(Latency{Distribution: "poisson"})},
+		"range":        {WithLatency(Latency{Min: time.Second, Max: time.Millisecond})},
+		"error rate":   {WithErrors(1.5, 0)},
+	}
+	for name, opts := range tests {
+		if _, err := Handler(http.NotFoundHandler(), opts...); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// Test that sampled delays stay within bounds
+func TestLatency_Sample(t *testing.T) {
+	distributions := []Latency{
+		{Distribution: "uniform", Min: 10 * time.Millisecond, Max: 20 * time.Millisecond},
+		{Distribution: "normal", Mean: 15 * time.Millisecond, StdDev: 50 * time.Millisecond, Min: 10 * time.Millisecond, Max: 20 * time.Millisecond},
+		{Distribution: "exponential", Mean: 15 * time.Millisecond, Min: 10 * time.Millisecond, Max: 20 * time.Millisecond},
+	}
+	for _, l := range distributions {
+		for range 1000 {
+			d := l.sample()
+			if d < l.Min || d > l.Max {
+				t.Fatalf("%s: sampled %v outside [%v, %v]", l.Distribution, d, l.Min, l.Max)
+			}
+		}
+	}
+
+	assertEqual(t, Latency{}.sample(), time.Duration(0))
+	assertEqual(t, Latency{Distribution: "fixed", Mean: time.Second}.sample(), time.Second)
+}
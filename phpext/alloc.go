@@ -0,0 +1,32 @@
+package phpext
+
+// #include <stdlib.h>
+import "C"
+import "unsafe"
+
+// Every buffer handed to the C side is allocated through cString or cBytes
+// and must be released with go_free_result. Routing frees back through Go
+// lets debug builds (-tags frankenasync_debug) track outstanding allocations.
+
+// cString copies s onto the C heap.
+func cString(s string) *C.char {
+	ptr := C.CString(s)
+	trackAlloc(unsafe.Pointer(ptr), len(s)+1)
+	return ptr
+}
+
+// cBytes copies data onto the C heap. The result is not NUL-terminated.
+func cBytes(data []byte) *C.char {
+	ptr := C.CBytes(data)
+	trackAlloc(ptr, len(data))
+	return (*C.char)(ptr)
+}
+
+//export go_free_result
+func go_free_result(ptr *C.char) {
+	if ptr == nil {
+		return
+	}
+	untrackAlloc(unsafe.Pointer(ptr))
+	C.free(unsafe.Pointer(ptr))
+}
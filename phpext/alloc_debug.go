@@ -0,0 +1,52 @@
+//go:build frankenasync_debug
+
+package phpext
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+type allocation struct {
+	size   int
+	caller string
+	at     time.Time
+}
+
+var allocations sync.Map // uintptr -> allocation
+
+func trackAlloc(ptr unsafe.Pointer, size int) {
+	caller := "unknown"
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = fmt.Sprintf("%s:%d", file, line)
+	}
+	allocations.Store(uintptr(ptr), allocation{size: size, caller: caller, at: time.Now()})
+}
+
+func untrackAlloc(ptr unsafe.Pointer) {
+	if _, ok := allocations.LoadAndDelete(uintptr(ptr)); !ok {
+		slog.Error("Freeing untracked C allocation", "ptr", fmt.Sprintf("%#x", uintptr(ptr)))
+	}
+}
+
+// ReportLeaks logs every C allocation that was handed to PHP but never
+// released, and returns how many there are.
+func ReportLeaks(logger *slog.Logger) int {
+	leaked := 0
+	allocations.Range(func(key, value any) bool {
+		alloc := value.(allocation)
+		logger.Warn("Leaked C allocation",
+			"ptr", fmt.Sprintf("%#x", key.(uintptr)),
+			"size", alloc.size,
+			"caller", alloc.caller,
+			"age", time.Since(alloc.at),
+		)
+		leaked++
+		return true
+	})
+	return leaked
+}
@@ -0,0 +1,43 @@
+//go:build frankenasync_debug
+
+package phpext
+
+import (
+	"log/slog"
+	"testing"
+)
+
+// Test that every buffer handed to PHP is released, on success and on
+// failure alike
+func TestAllocations(t *testing.T) {
+	writeScript(t, "echo.php", `<?php echo $_SERVER['APP_VALUE'] ?? '';`)
+	writeScript(t, "allocations.php", `<?php
+use Frankenphp\Script;
+use Frankenphp\Async\Future;
+
+(new Script('echo.php'))->execute(['value' => 'sync']);
+$tasks = ['a' => (new Script('echo.php'))->async(), 'b' => (new Script('echo.php'))->async()];
+Future::awaitAll($tasks, '5s');
+$tasks['a']->getInfo();
+Future::list();
+Future::getStats();
+
+$deferred = (new Script('echo.php'))->defer();
+$deferred->cancel();
+$deferred->getErrorInfo();
+try {
+    $deferred->await('1s');
+} catch (Future\Exception $e) {
+}
+echo 'done';
+`)
+
+	result, err := runScript(t, "allocations.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, "done")
+	if leaked := ReportLeaks(slog.Default()); leaked != 0 {
+		t.Fatalf("%d C allocations leaked", leaked)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !frankenasync_debug
+
+package phpext
+
+import (
+	"log/slog"
+	"unsafe"
+)
+
+func trackAlloc(unsafe.Pointer, int) {}
+
+func untrackAlloc(unsafe.Pointer) {}
+
+// ReportLeaks is a no-op unless built with -tags frankenasync_debug.
+func ReportLeaks(*slog.Logger) int { return 0 }
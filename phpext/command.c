@@ -0,0 +1,130 @@
+/**
+ * FrankenAsync Commands
+ *
+ * Registers Frankenphp\Async\Command, which runs the external commands the
+ * server allows (exec.allow) as tasks, returning a Future of their exit
+ * code and output.
+ */
+
+#include <php.h>
+
+#include <ext/spl/spl_exceptions.h>
+
+#include <Zend/zend_exceptions.h>
+#include <Zend/zend_smart_str.h>
+
+#include "phpext.h"
+#include "encoding.h"
+#include "util.h"
+#include "phpext_cgo.h"
+
+#include "frankenphp.h"
+
+static zend_class_entry *command_ce = NULL;
+
+static const zend_function_entry command_methods[];
+
+int frankenasync_command_minit(void)
+{
+    zend_class_entry ce;
+
+    INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async", "Command", command_methods);
+
+    command_ce = zend_register_internal_class(&ce);
+    if (!command_ce) {
+        return FAILURE;
+    }
+
+    command_ce->ce_flags |= ZEND_ACC_FINAL;
+
+    return SUCCESS;
+}
+
+PHP_METHOD(Async_Command, __construct)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+}
+
+PHP_METHOD(Async_Command, async)
+{
+    zend_string *command;
+    HashTable *args = NULL;
+    HashTable *options = NULL;
+    smart_str payload = {0};
+
+    ZEND_PARSE_PARAMETERS_START(1, 3)
+        Z_PARAM_STR(command)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ARRAY_HT(args)
+        Z_PARAM_ARRAY_HT(options)
+    ZEND_PARSE_PARAMETERS_END();
+
+    if (args && !zend_array_is_list(args)) {
+        zend_throw_exception_ex(spl_ce_InvalidArgumentException, 0,
+            "The 'args' parameter must be a list of strings");
+        RETURN_THROWS();
+    }
+
+    if (options && zend_hash_num_elements(options) > 0 && !frankenasync_is_associative(options)) {
+        zend_throw_exception_ex(spl_ce_InvalidArgumentException, 0,
+            "The 'options' parameter must be an associative array with string keys");
+        RETURN_THROWS();
+    }
+
+    zval payload_array;
+    array_init(&payload_array);
+
+    /* Options are merged as top-level fields; reserved keys cannot be overridden */
+    if (options) {
+        zend_string *key;
+        zval *val;
+
+        ZEND_HASH_FOREACH_STR_KEY_VAL(options, key, val) {
+            if (!key || zend_string_equals_literal(key, "command") || zend_string_equals_literal(key, "args")) {
+                continue;
+            }
+            Z_TRY_ADDREF_P(val);
+            zend_hash_update(Z_ARRVAL(payload_array), key, val);
+        } ZEND_HASH_FOREACH_END();
+    }
+
+    add_assoc_str(&payload_array, "command", zend_string_copy(command));
+
+    if (args && zend_hash_num_elements(args) > 0) {
+        zval args_zval;
+        ZVAL_ARR(&args_zval, args);
+        Z_ADDREF(args_zval);
+        add_assoc_zval(&payload_array, "args", &args_zval);
+    }
+
+    if (frankenasync_encode_payload(&payload, &payload_array) != SUCCESS) {
+        zval_ptr_dtor(&payload_array);
+        smart_str_free(&payload);
+        frankenasync_throw_exception("Failed to encode payload");
+        RETURN_THROWS();
+    }
+    zval_ptr_dtor(&payload_array);
+
+    struct go_exec_async_return result = go_exec_async(
+        frankenphp_thread_index(),
+        ZSTR_VAL(payload.s),
+        ZSTR_LEN(payload.s)
+    );
+
+    smart_str_free(&payload);
+
+    if (UNEXPECTED(!result.r1)) {
+        frankenasync_throw_bridge_error(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+
+    frankenasync_create_asyncfuture_object(return_value, result.r0);
+    go_free_result(result.r0);
+}
+
+static const zend_function_entry command_methods[] = {
+    PHP_ME(Async_Command, __construct, arginfo_command___construct, ZEND_ACC_PRIVATE)
+    PHP_ME(Async_Command, async, arginfo_command_async, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_FE_END
+};
@@ -0,0 +1,539 @@
+/**
+ * FrankenAsync Bridge Encoding
+ *
+ * Serializes payloads crossing the CGO boundary. JSON is the default; msgpack
+ * or PHP's serialize() format is negotiated at module init when enabled on
+ * the Go side. Msgpack avoids text encoding costs for large script results,
+ * serialize() keeps integers, floats and arrays as PHP has them.
+ */
+
+#include <php.h>
+#include <ext/json/php_json.h>
+#include <ext/standard/php_var.h>
+#include <Zend/zend_smart_str.h>
+
+#include "phpext.h"
+#include "encoding.h"
+
+int frankenasync_encoding = FRANKENASYNC_ENCODING_JSON;
+
+/* ============================================================================
+ * MSGPACK ENCODER
+ * ============================================================================ */
+
+static inline void mp_write_u8(smart_str *buf, uint8_t v) {
+    smart_str_appendc(buf, (char) v);
+}
+
+static inline void mp_write_be16(smart_str *buf, uint16_t v) {
+    char b[2] = { (char) (v >> 8), (char) v };
+    smart_str_appendl(buf, b, 2);
+}
+
+static inline void mp_write_be32(smart_str *buf, uint32_t v) {
+    char b[4] = { (char) (v >> 24), (char) (v >> 16), (char) (v >> 8), (char) v };
+    smart_str_appendl(buf, b, 4);
+}
+
+static inline void mp_write_be64(smart_str *buf, uint64_t v) {
+    mp_write_be32(buf, (uint32_t) (v >> 32));
+    mp_write_be32(buf, (uint32_t) v);
+}
+
+static void mp_write_str(smart_str *buf, const char *str, size_t len) {
+    if (len < 32) {
+        mp_write_u8(buf, 0xa0 | (uint8_t) len);
+    } else if (len < 256) {
+        mp_write_u8(buf, 0xd9);
+        mp_write_u8(buf, (uint8_t) len);
+    } else if (len < 65536) {
+        mp_write_u8(buf, 0xda);
+        mp_write_be16(buf, (uint16_t) len);
+    } else {
+        mp_write_u8(buf, 0xdb);
+        mp_write_be32(buf, (uint32_t) len);
+    }
+    smart_str_appendl(buf, str, len);
+}
+
+static void mp_write_long(smart_str *buf, zend_long v) {
+    if (v >= 0 && v < 128) {
+        mp_write_u8(buf, (uint8_t) v);
+    } else if (v < 0 && v >= -32) {
+        mp_write_u8(buf, (uint8_t) (int8_t) v);
+    } else {
+        mp_write_u8(buf, 0xd3);
+        mp_write_be64(buf, (uint64_t) (int64_t) v);
+    }
+}
+
+static void mp_write_double(smart_str *buf, double v) {
+    uint64_t bits;
+    memcpy(&bits, &v, sizeof(bits));
+    mp_write_u8(buf, 0xcb);
+    mp_write_be64(buf, bits);
+}
+
+static void mp_write_container(smart_str *buf, uint8_t fix, uint8_t tag16, uint32_t count) {
+    if (count < 16) {
+        mp_write_u8(buf, fix | (uint8_t) count);
+    } else if (count < 65536) {
+        mp_write_u8(buf, tag16);
+        mp_write_be16(buf, (uint16_t) count);
+    } else {
+        mp_write_u8(buf, tag16 + 1);
+        mp_write_be32(buf, count);
+    }
+}
+
+static int mp_encode_zval(smart_str *buf, zval *value, int depth) {
+    if (UNEXPECTED(depth > FRANKENASYNC_JSON_DEPTH)) {
+        return FAILURE;
+    }
+
+    ZVAL_DEREF(value);
+
+    switch (Z_TYPE_P(value)) {
+        case IS_NULL:
+            mp_write_u8(buf, 0xc0);
+            return SUCCESS;
+        case IS_FALSE:
+            mp_write_u8(buf, 0xc2);
+            return SUCCESS;
+        case IS_TRUE:
+            mp_write_u8(buf, 0xc3);
+            return SUCCESS;
+        case IS_LONG:
+            mp_write_long(buf, Z_LVAL_P(value));
+            return SUCCESS;
+        case IS_DOUBLE:
+            mp_write_double(buf, Z_DVAL_P(value));
+            return SUCCESS;
+        case IS_STRING:
+            mp_write_str(buf, Z_STRVAL_P(value), Z_STRLEN_P(value));
+            return SUCCESS;
+        case IS_ARRAY: {
+            HashTable *ht = Z_ARRVAL_P(value);
+            zend_string *key;
+            zend_ulong index;
+            zval *item;
+
+            if (zend_array_is_list(ht)) {
+                mp_write_container(buf, 0x90, 0xdc, zend_hash_num_elements(ht));
+                ZEND_HASH_FOREACH_VAL(ht, item) {
+                    if (UNEXPECTED(mp_encode_zval(buf, item, depth + 1) != SUCCESS)) {
+                        return FAILURE;
+                    }
+                } ZEND_HASH_FOREACH_END();
+                return SUCCESS;
+            }
+
+            /* Map keys are always strings, matching JSON object semantics */
+            mp_write_container(buf, 0x80, 0xde, zend_hash_num_elements(ht));
+            ZEND_HASH_FOREACH_KEY_VAL(ht, index, key, item) {
+                if (key) {
+                    mp_write_str(buf, ZSTR_VAL(key), ZSTR_LEN(key));
+                } else {
+                    char index_buf[MAX_LENGTH_OF_LONG + 1];
+                    int index_len = snprintf(index_buf, sizeof(index_buf), ZEND_LONG_FMT, (zend_long) index);
+                    mp_write_str(buf, index_buf, (size_t) index_len);
+                }
+                if (UNEXPECTED(mp_encode_zval(buf, item, depth + 1) != SUCCESS)) {
+                    return FAILURE;
+                }
+            } ZEND_HASH_FOREACH_END();
+            return SUCCESS;
+        }
+        default:
+            /* Objects and resources have no portable representation */
+            return FAILURE;
+    }
+}
+
+int frankenasync_msgpack_encode(smart_str *buf, zval *value) {
+    return mp_encode_zval(buf, value, 0);
+}
+
+/* ============================================================================
+ * MSGPACK DECODER
+ * ============================================================================ */
+
+typedef struct {
+    const unsigned char *pos;
+    const unsigned char *end;
+} mp_reader;
+
+static inline zend_bool mp_need(mp_reader *r, size_t n) {
+    return (size_t) (r->end - r->pos) >= n;
+}
+
+static inline uint16_t mp_be16(const unsigned char *p) {
+    return (uint16_t) ((p[0] << 8) | p[1]);
+}
+
+static inline uint32_t mp_be32(const unsigned char *p) {
+    return ((uint32_t) p[0] << 24) | ((uint32_t) p[1] << 16) | ((uint32_t) p[2] << 8) | (uint32_t) p[3];
+}
+
+static inline uint64_t mp_be64(const unsigned char *p) {
+    return ((uint64_t) mp_be32(p) << 32) | (uint64_t) mp_be32(p + 4);
+}
+
+/* Read a big-endian length of n bytes (1, 2 or 4) */
+static int mp_read_length(mp_reader *r, size_t n, uint32_t *out) {
+    if (UNEXPECTED(!mp_need(r, n))) {
+        return FAILURE;
+    }
+    switch (n) {
+        case 1: *out = r->pos[0]; break;
+        case 2: *out = mp_be16(r->pos); break;
+        default: *out = mp_be32(r->pos); break;
+    }
+    r->pos += n;
+    return SUCCESS;
+}
+
+static int mp_decode_zval(mp_reader *r, zval *out, int depth);
+
+static int mp_decode_str(mp_reader *r, zval *out, uint32_t len) {
+    if (UNEXPECTED(!mp_need(r, len))) {
+        return FAILURE;
+    }
+    ZVAL_STRINGL(out, (const char *) r->pos, len);
+    r->pos += len;
+    return SUCCESS;
+}
+
+static int mp_decode_array(mp_reader *r, zval *out, uint32_t count, int depth) {
+    /* Every element takes at least one byte; reject bogus counts early */
+    if (UNEXPECTED(!mp_need(r, count))) {
+        return FAILURE;
+    }
+
+    array_init_size(out, count);
+    for (uint32_t i = 0; i < count; i++) {
+        zval item;
+        if (UNEXPECTED(mp_decode_zval(r, &item, depth + 1) != SUCCESS)) {
+            zval_ptr_dtor(out);
+            return FAILURE;
+        }
+        zend_hash_next_index_insert(Z_ARRVAL_P(out), &item);
+    }
+    return SUCCESS;
+}
+
+static int mp_decode_map(mp_reader *r, zval *out, uint32_t count, int depth) {
+    if (UNEXPECTED(!mp_need(r, (size_t) count * 2))) {
+        return FAILURE;
+    }
+
+    array_init_size(out, count);
+    for (uint32_t i = 0; i < count; i++) {
+        zval key, item;
+        if (UNEXPECTED(mp_decode_zval(r, &key, depth + 1) != SUCCESS)) {
+            zval_ptr_dtor(out);
+            return FAILURE;
+        }
+        if (UNEXPECTED(mp_decode_zval(r, &item, depth + 1) != SUCCESS)) {
+            zval_ptr_dtor(&key);
+            zval_ptr_dtor(out);
+            return FAILURE;
+        }
+
+        if (Z_TYPE(key) == IS_STRING) {
+            zend_symtable_update(Z_ARRVAL_P(out), Z_STR(key), &item);
+        } else if (Z_TYPE(key) == IS_LONG) {
+            zend_hash_index_update(Z_ARRVAL_P(out), Z_LVAL(key), &item);
+        } else {
+            zval_ptr_dtor(&key);
+            zval_ptr_dtor(&item);
+            zval_ptr_dtor(out);
+            return FAILURE;
+        }
+        zval_ptr_dtor(&key);
+    }
+    return SUCCESS;
+}
+
+static int mp_decode_zval(mp_reader *r, zval *out, int depth) {
+    uint32_t len;
+
+    if (UNEXPECTED(depth > FRANKENASYNC_JSON_DEPTH || !mp_need(r, 1))) {
+        return FAILURE;
+    }
+
+    uint8_t tag = *r->pos++;
+
+    if (tag <= 0x7f) {
+        ZVAL_LONG(out, tag);
+        return SUCCESS;
+    }
+    if (tag >= 0xe0) {
+        ZVAL_LONG(out, (int8_t) tag);
+        return SUCCESS;
+    }
+    if ((tag & 0xf0) == 0x80) {
+        return mp_decode_map(r, out, tag & 0x0f, depth);
+    }
+    if ((tag & 0xf0) == 0x90) {
+        return mp_decode_array(r, out, tag & 0x0f, depth);
+    }
+    if ((tag & 0xe0) == 0xa0) {
+        return mp_decode_str(r, out, tag & 0x1f);
+    }
+
+    switch (tag) {
+        case 0xc0:
+            ZVAL_NULL(out);
+            return SUCCESS;
+        case 0xc2:
+            ZVAL_FALSE(out);
+            return SUCCESS;
+        case 0xc3:
+            ZVAL_TRUE(out);
+            return SUCCESS;
+
+        /* bin and str both become PHP strings */
+        case 0xc4: case 0xd9:
+            return mp_read_length(r, 1, &len) == SUCCESS ? mp_decode_str(r, out, len) : FAILURE;
+        case 0xc5: case 0xda:
+            return mp_read_length(r, 2, &len) == SUCCESS ? mp_decode_str(r, out, len) : FAILURE;
+        case 0xc6: case 0xdb:
+            return mp_read_length(r, 4, &len) == SUCCESS ? mp_decode_str(r, out, len) : FAILURE;
+
+        case 0xca: {
+            if (UNEXPECTED(!mp_need(r, 4))) {
+                return FAILURE;
+            }
+            uint32_t bits = mp_be32(r->pos);
+            float f;
+            memcpy(&f, &bits, sizeof(f));
+            r->pos += 4;
+            ZVAL_DOUBLE(out, (double) f);
+            return SUCCESS;
+        }
+        case 0xcb: {
+            if (UNEXPECTED(!mp_need(r, 8))) {
+                return FAILURE;
+            }
+            uint64_t bits = mp_be64(r->pos);
+            double d;
+            memcpy(&d, &bits, sizeof(d));
+            r->pos += 8;
+            ZVAL_DOUBLE(out, d);
+            return SUCCESS;
+        }
+
+        case 0xcc:
+            if (UNEXPECTED(mp_read_length(r, 1, &len) != SUCCESS)) {
+                return FAILURE;
+            }
+            ZVAL_LONG(out, len);
+            return SUCCESS;
+        case 0xcd:
+            if (UNEXPECTED(mp_read_length(r, 2, &len) != SUCCESS)) {
+                return FAILURE;
+            }
+            ZVAL_LONG(out, len);
+            return SUCCESS;
+        case 0xce:
+            if (UNEXPECTED(mp_read_length(r, 4, &len) != SUCCESS)) {
+                return FAILURE;
+            }
+            ZVAL_LONG(out, (zend_long) len);
+            return SUCCESS;
+        case 0xcf: {
+            if (UNEXPECTED(!mp_need(r, 8))) {
+                return FAILURE;
+            }
+            uint64_t v = mp_be64(r->pos);
+            r->pos += 8;
+            if (v > (uint64_t) ZEND_LONG_MAX) {
+                ZVAL_DOUBLE(out, (double) v);
+            } else {
+                ZVAL_LONG(out, (zend_long) v);
+            }
+            return SUCCESS;
+        }
+
+        case 0xd0:
+            if (UNEXPECTED(!mp_need(r, 1))) {
+                return FAILURE;
+            }
+            ZVAL_LONG(out, (int8_t) r->pos[0]);
+            r->pos += 1;
+            return SUCCESS;
+        case 0xd1:
+            if (UNEXPECTED(!mp_need(r, 2))) {
+                return FAILURE;
+            }
+            ZVAL_LONG(out, (int16_t) mp_be16(r->pos));
+            r->pos += 2;
+            return SUCCESS;
+        case 0xd2:
+            if (UNEXPECTED(!mp_need(r, 4))) {
+                return FAILURE;
+            }
+            ZVAL_LONG(out, (int32_t) mp_be32(r->pos));
+            r->pos += 4;
+            return SUCCESS;
+        case 0xd3:
+            if (UNEXPECTED(!mp_need(r, 8))) {
+                return FAILURE;
+            }
+            ZVAL_LONG(out, (zend_long) (int64_t) mp_be64(r->pos));
+            r->pos += 8;
+            return SUCCESS;
+
+        case 0xdc:
+            return mp_read_length(r, 2, &len) == SUCCESS ? mp_decode_array(r, out, len, depth) : FAILURE;
+        case 0xdd:
+            return mp_read_length(r, 4, &len) == SUCCESS ? mp_decode_array(r, out, len, depth) : FAILURE;
+        case 0xde:
+            return mp_read_length(r, 2, &len) == SUCCESS ? mp_decode_map(r, out, len, depth) : FAILURE;
+        case 0xdf:
+            return mp_read_length(r, 4, &len) == SUCCESS ? mp_decode_map(r, out, len, depth) : FAILURE;
+
+        default:
+            /* Extension types (e.g. timestamps) are not supported */
+            return FAILURE;
+    }
+}
+
+int frankenasync_msgpack_decode(zval *return_value, const char *data, size_t len) {
+    mp_reader r = {
+        .pos = (const unsigned char *) data,
+        .end = (const unsigned char *) data + len,
+    };
+
+    if (UNEXPECTED(mp_decode_zval(&r, return_value, 0) != SUCCESS)) {
+        ZVAL_UNDEF(return_value);
+        return FAILURE;
+    }
+
+    if (UNEXPECTED(r.pos != r.end)) {
+        zval_ptr_dtor(return_value);
+        ZVAL_UNDEF(return_value);
+        return FAILURE;
+    }
+
+    return SUCCESS;
+}
+
+/* ============================================================================
+ * PHP SERIALIZE
+ * ============================================================================ */
+
+int frankenasync_php_encode(smart_str *buf, zval *value) {
+    php_serialize_data_t var_hash;
+
+    PHP_VAR_SERIALIZE_INIT(var_hash);
+    php_var_serialize(buf, value, &var_hash);
+    PHP_VAR_SERIALIZE_DESTROY(var_hash);
+
+    return UNEXPECTED(EG(exception)) ? FAILURE : SUCCESS;
+}
+
+int frankenasync_php_decode(zval *return_value, const char *data, size_t len) {
+    const unsigned char *p = (const unsigned char *) data;
+    const unsigned char *end = p + len;
+    php_unserialize_data_t var_hash;
+    int result = FAILURE;
+
+    PHP_VAR_UNSERIALIZE_INIT(var_hash);
+    zval *decoded = var_tmp_var(&var_hash);
+    if (EXPECTED(php_var_unserialize(decoded, &p, end, &var_hash)) && EXPECTED(p == end)) {
+        ZVAL_COPY(return_value, decoded);
+        result = SUCCESS;
+    } else {
+        ZVAL_UNDEF(return_value);
+    }
+    PHP_VAR_UNSERIALIZE_DESTROY(var_hash);
+
+    return result;
+}
+
+/* ============================================================================
+ * NEGOTIATED ENCODING
+ * ============================================================================ */
+
+int frankenasync_encode_payload(smart_str *buf, zval *value) {
+    if (frankenasync_encoding == FRANKENASYNC_ENCODING_MSGPACK) {
+        if (frankenasync_msgpack_encode(buf, value) != SUCCESS) {
+            return FAILURE;
+        }
+    } else if (frankenasync_encoding == FRANKENASYNC_ENCODING_PHP) {
+        if (frankenasync_php_encode(buf, value) != SUCCESS) {
+            return FAILURE;
+        }
+    } else if (php_json_encode(buf, value, 0) != SUCCESS) {
+        return FAILURE;
+    }
+
+    smart_str_0(buf);
+    return SUCCESS;
+}
+
+int frankenasync_decode_payload(zval *return_value, const char *data, size_t len) {
+    if (frankenasync_encoding == FRANKENASYNC_ENCODING_MSGPACK) {
+        return frankenasync_msgpack_decode(return_value, data, len);
+    }
+    if (frankenasync_encoding == FRANKENASYNC_ENCODING_PHP) {
+        return frankenasync_php_decode(return_value, data, len);
+    }
+
+    return php_json_decode_ex(return_value, data, len, PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH);
+}
+
+void frankenasync_decode_result(zval *return_value, const char *data, size_t len) {
+    zval decoded;
+    ZVAL_UNDEF(&decoded);
+
+    if (frankenasync_encoding == FRANKENASYNC_ENCODING_MSGPACK) {
+        if (EXPECTED(frankenasync_msgpack_decode(&decoded, data, len) == SUCCESS)) {
+            ZVAL_COPY_VALUE(return_value, &decoded);
+            return;
+        }
+    } else if (frankenasync_encoding == FRANKENASYNC_ENCODING_PHP) {
+        if (EXPECTED(frankenasync_php_decode(&decoded, data, len) == SUCCESS)) {
+            ZVAL_COPY_VALUE(return_value, &decoded);
+            return;
+        }
+    } else if (EXPECTED(php_json_decode_ex(&decoded, data, len, PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) == SUCCESS)) {
+        if (EXPECTED(Z_TYPE(decoded) == IS_ARRAY)) {
+            ZVAL_COPY_VALUE(return_value, &decoded);
+            return;
+        }
+        zval_ptr_dtor(&decoded);
+    }
+
+    RETVAL_STRINGL(data, len);
+}
+
+void frankenasync_decode_result_str(zval *return_value, zend_string *data) {
+    zval decoded;
+    ZVAL_UNDEF(&decoded);
+
+    if (frankenasync_encoding == FRANKENASYNC_ENCODING_MSGPACK) {
+        if (EXPECTED(frankenasync_msgpack_decode(&decoded, ZSTR_VAL(data), ZSTR_LEN(data)) == SUCCESS)) {
+            zend_string_release(data);
+            ZVAL_COPY_VALUE(return_value, &decoded);
+            return;
+        }
+    } else if (frankenasync_encoding == FRANKENASYNC_ENCODING_PHP) {
+        if (EXPECTED(frankenasync_php_decode(&decoded, ZSTR_VAL(data), ZSTR_LEN(data)) == SUCCESS)) {
+            zend_string_release(data);
+            ZVAL_COPY_VALUE(return_value, &decoded);
+            return;
+        }
+    } else if (EXPECTED(php_json_decode_ex(&decoded, ZSTR_VAL(data), ZSTR_LEN(data), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) == SUCCESS)) {
+        if (EXPECTED(Z_TYPE(decoded) == IS_ARRAY)) {
+            zend_string_release(data);
+            ZVAL_COPY_VALUE(return_value, &decoded);
+            return;
+        }
+        zval_ptr_dtor(&decoded);
+    }
+
+    RETVAL_STR(data);
+}
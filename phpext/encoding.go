@@ -0,0 +1,62 @@
+package phpext
+
+import (
+	"encoding/json"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Payload encodings supported across the CGO boundary, named after the
+// asynctask codecs implementing them.
+const (
+	EncodingJSON    = asynctask.CodecJSON
+	EncodingMsgpack = asynctask.CodecMsgpack
+	EncodingPHP     = asynctask.CodecPHP
+)
+
+// Encoding selects how script payloads and task results are serialized
+// between Go and the C extension. It is negotiated once when the PHP module
+// initializes, so it must be set before FrankenPHP starts.
+var Encoding = EncodingJSON
+
+// bridgeEncodings are the codecs the C extension can decode, by the
+// number it knows them by.
+var bridgeEncodings = []asynctask.Codec{asynctask.JSONCodec{}, asynctask.MsgpackCodec{}, asynctask.PHPCodec{}}
+
+// currentEncoding returns the negotiated bridge encoding.
+func currentEncoding() asynctask.Codec {
+	for _, enc := range bridgeEncodings {
+		if enc.Name() == Encoding {
+			return enc
+		}
+	}
+	return asynctask.JSONCodec{}
+}
+
+// encodeResult serializes a task result for PHP. With JSON, string results
+// pass through untouched so the C side can return non-JSON output verbatim.
+func encodeResult(enc asynctask.Codec, v any) ([]byte, error) {
+	if enc.Name() == EncodingJSON {
+		switch v := v.(type) {
+		case string:
+			return []byte(v), nil
+		case []byte:
+			return v, nil
+		}
+	}
+	return enc.Marshal(v)
+}
+
+// taskResult is the value script and command runnables store as their task
+// result. JSON keeps the pre-encoded string the C side has always received;
+// the other encodings store the struct and encode it once, on await.
+func taskResult(result any) (any, error) {
+	if currentEncoding().Name() == EncodingJSON {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+#ifndef FRANKENASYNC_ENCODING_H
+#define FRANKENASYNC_ENCODING_H
+
+#include <php.h>
+#include <Zend/zend_smart_str.h>
+
+#define FRANKENASYNC_ENCODING_JSON    0
+#define FRANKENASYNC_ENCODING_MSGPACK 1
+#define FRANKENASYNC_ENCODING_PHP     2
+
+/**
+ * Payload encoding negotiated with Go at module init
+ */
+extern int frankenasync_encoding;
+
+/**
+ * Encode a zval with the negotiated encoding into a smart_str
+ */
+int frankenasync_encode_payload(smart_str *buf, zval *value);
+
+/**
+ * Decode data with the negotiated encoding. Fails on malformed input.
+ */
+int frankenasync_decode_payload(zval *return_value, const char *data, size_t len);
+
+/**
+ * Decode a task result. With JSON, results that are not a JSON array or
+ * object are returned verbatim as a string.
+ */
+void frankenasync_decode_result(zval *return_value, const char *data, size_t len);
+
+/**
+ * Decode a task result held in a zend_string, taking ownership of it. The
+ * string itself is returned when it is not decoded, avoiding a copy.
+ */
+void frankenasync_decode_result_str(zval *return_value, zend_string *data);
+
+/**
+ * Msgpack primitives
+ */
+int frankenasync_msgpack_encode(smart_str *buf, zval *value);
+int frankenasync_msgpack_decode(zval *return_value, const char *data, size_t len);
+
+/**
+ * PHP serialize() primitives
+ */
+int frankenasync_php_encode(smart_str *buf, zval *value);
+int frankenasync_php_decode(zval *return_value, const char *data, size_t len);
+
+#endif /* FRANKENASYNC_ENCODING_H */
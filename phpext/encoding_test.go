@@ -0,0 +1,214 @@
+package phpext
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// storeScript passes the global store value under the "in" argument
+// through PHP, decoding it with the C decoder and storing it again under
+// "out" with the C encoder, or echoes the decoding error.
+const storeScript = `<?php
+use Frankenphp\Async\Store;
+
+$store = new Store(Store::SCOPE_GLOBAL);
+try {
+    $store->set($_SERVER['APP_OUT'], $store->get($_SERVER['APP_IN']));
+    echo 'ok';
+} catch (\Throwable $e) {
+    echo $e->getMessage();
+}
+`
+
+// Test values encoded by the asynctask msgpack codec decode in PHP, and
+// come back unchanged once PHP encodes them again
+func TestMsgpackRoundTrip(t *testing.T) {
+	writeScript(t, "roundtrip.php", storeScript)
+	codec := asynctask.MsgpackCodec{}
+
+	for i, value := range []any{
+		nil,
+		true,
+		false,
+		0,
+		-1,
+		-33, // int8
+		127,
+		255,   // uint8
+		-1000, // int16
+		65535, // uint16
+		1 << 20,
+		-1 << 40,
+		math.MaxInt64,
+		math.MinInt64,
+		1.5,
+		-0.25,
+		"",
+		"short",
+		strings.Repeat("a", 31),  // fixstr
+		strings.Repeat("b", 200), // str8
+		strings.Repeat("c", 300), // str16
+		strings.Repeat("d", 70000),
+		"ünïcødé",
+		[]any{},
+		[]any{1, "two", 3.5, nil, []any{true}},
+		make([]any, 20), // array16
+		map[string]any{"name": "task", "tags": []any{"a", "b"}, "nested": map[string]any{"depth": 2}},
+	} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			in, out := fmt.Sprintf("roundtrip.in.%d", i), fmt.Sprintf("roundtrip.out.%d", i)
+			data, err := codec.Marshal(value)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			SharedStore.Set(in, data, 0)
+			defer SharedStore.Delete(in)
+			defer SharedStore.Delete(out)
+
+			result, err := runScript(t, "roundtrip.php", map[string]any{"in": in, "out": out})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertEqual(t, result.Body, "ok")
+
+			encoded, ok := SharedStore.Get(out)
+			if !ok {
+				t.Fatal("value not stored again")
+			}
+			var got any
+			if err := codec.Unmarshal(encoded, &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Integers come back in the smallest width that holds them
+			want, _ := json.Marshal(value)
+			have, _ := json.Marshal(got)
+			if string(have) != string(want) {
+				t.Fatalf("got %.100s, want %.100s", have, want)
+			}
+		})
+	}
+}
+
+// Test that the C decoder rejects truncated and malformed input, including
+// lengths and counts claiming more than the input holds
+func TestMsgpackDecode_Malformed(t *testing.T) {
+	writeScript(t, "malformed.php", storeScript)
+
+	for name, data := range map[string][]byte{
+		"empty":            {},
+		"unused tag":       {0xc1},
+		"extension":        {0xd4, 0x01, 0x00},
+		"trailing bytes":   {0xc0, 0xc0},
+		"fixstr":           {0xa5, 'a', 'b'},
+		"str8":             {0xd9, 0x05, 'a', 'b'},
+		"str8 length":      {0xd9},
+		"str16":            {0xda, 0xff, 0xff, 'a'},
+		"str16 length":     {0xda, 0x01},
+		"str32":            {0xdb, 0xff, 0xff, 0xff, 0xff, 'a'},
+		"str32 length":     {0xdb, 0x00, 0x00},
+		"bin8":             {0xc4, 0x03, 0x00},
+		"bin16":            {0xc5, 0x01, 0x00},
+		"bin32":            {0xc6, 0x7f, 0xff, 0xff, 0xff},
+		"fixarray":         {0x93, 0x01},
+		"array16":          {0xdc, 0xff, 0xff, 0xc0},
+		"array16 length":   {0xdc, 0x00},
+		"array32":          {0xdd, 0xff, 0xff, 0xff, 0xff, 0xc0},
+		"array32 length":   {0xdd, 0x00, 0x00, 0x00},
+		"fixmap":           {0x81, 0xa1, 'k'},
+		"map16":            {0xde, 0xff, 0xff, 0xa1, 'k', 0xc0},
+		"map32":            {0xdf, 0xff, 0xff, 0xff, 0xff, 0xa1, 'k', 0xc0},
+		"map32 length":     {0xdf, 0xff},
+		"map key":          {0x81, 0x90, 0xc0},
+		"nested truncated": {0x91, 0x92, 0xa3, 'a'},
+		"float32":          {0xca, 0x00, 0x00},
+		"float64":          {0xcb, 0x00, 0x00, 0x00, 0x00},
+		"uint64":           {0xcf, 0x00},
+		"int32":            {0xd2, 0x00, 0x00, 0x00},
+		"nested too deep":  append(bytes.Repeat([]byte{0x91}, 1000), 0xc0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			in, out := "malformed.in."+name, "malformed.out."+name
+			SharedStore.Set(in, data, 0)
+			defer SharedStore.Delete(in)
+
+			result, err := runScript(t, "malformed.php", map[string]any{"in": in, "out": out})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(result.Body, "Failed to decode stored value") {
+				t.Fatalf("decoded malformed input: %q", result.Body)
+			}
+			if _, ok := SharedStore.Get(out); ok {
+				t.Fatal("malformed input stored again")
+			}
+		})
+	}
+}
+
+// Benchmark encoding and decoding a large script payload and result, as
+// they cross the bridge, with JSON and msgpack
+func BenchmarkEncoding(b *testing.B) {
+	app := make(map[string]any)
+	for i := range 500 {
+		app[fmt.Sprintf("item%d", i)] = map[string]any{"id": i, "title": strings.Repeat("t", 50), "price": float64(i) / 4}
+	}
+	headers := make(map[string]string)
+	for i := range 20 {
+		headers[fmt.Sprintf("X-Header-%d", i)] = strings.Repeat("h", 40)
+	}
+	forward := true
+
+	for _, payload := range []struct {
+		name  string
+		value any
+		new   func() any
+	}{
+		{"request", &scriptRequest{
+			Name:           "reports/build.php",
+			Env:            &scriptEnv{App: app, CGI: map[string]string{"HTTP_ACCEPT": "text/html"}},
+			ForwardCookies: &forward,
+			HeaderAllow:    []string{"Accept", "Accept-Language"},
+			Pool:           "io",
+		}, func() any { return new(scriptRequest) }},
+		{"result", &scriptResult{
+			Name:     "reports/build.php",
+			Body:     strings.Repeat(`{"row":"0123456789abcdef"},`, 4000),
+			Headers:  headers,
+			Status:   200,
+			Duration: 12.5,
+		}, func() any { return new(scriptResult) }},
+	} {
+		for _, codec := range []asynctask.Codec{asynctask.JSONCodec{}, asynctask.MsgpackCodec{}} {
+			data, err := codec.Marshal(payload.value)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.Run(payload.name+"/"+codec.Name()+"/encode", func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for range b.N {
+					if _, err := codec.Marshal(payload.value); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run(payload.name+"/"+codec.Name()+"/decode", func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for range b.N {
+					if err := codec.Unmarshal(data, payload.new()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
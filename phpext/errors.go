@@ -0,0 +1,206 @@
+package phpext
+
+// #include <stdlib.h>
+import "C"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/pubsub"
+)
+
+// Error codes sent to the C extension, which maps them onto typed PHP exceptions.
+const (
+	codeInternal          = "INTERNAL"
+	codeInvalidArgument   = "INVALID_ARGUMENT"
+	codeThreadUnavailable = "THREAD_UNAVAILABLE"
+	codeTaskNotFound      = "TASK_NOT_FOUND"
+	codeTimeout           = "TIMEOUT"
+	codeCanceled          = "CANCELED"
+	codePanicked          = "PANICKED"
+	codeFailed            = "FAILED"
+	codeDepthExceeded     = "DEPTH_EXCEEDED"
+	codeSubrequestLoop    = "SUBREQUEST_LOOP"
+	codeClosed            = "CLOSED"
+	codeQuotaExceeded     = "QUOTA_EXCEEDED"
+	codeBudgetExceeded    = "BUDGET_EXCEEDED"
+	codeMemoryExceeded    = "MEMORY_EXCEEDED"
+	codeDeadline          = "INSUFFICIENT_DEADLINE"
+)
+
+var (
+	errThreadUnavailable = errors.New("thread not available")
+	errInvalidArgument   = errors.New("invalid argument")
+)
+
+type (
+	// bridgeError is the JSON envelope returned alongside false from go_* exports.
+	bridgeError struct {
+		Code    string         `json:"code"`
+		Message string         `json:"message"`
+		Details map[string]any `json:"details,omitempty"`
+	}
+
+	// taskError describes why a task failed, for PHP code branching on
+	// the cause rather than parsing the message.
+	taskError struct {
+		Code      string      `json:"code"`
+		Message   string      `json:"message"`
+		Timeout   bool        `json:"timeout"`
+		Canceled  bool        `json:"canceled"`
+		Panicked  bool        `json:"panicked"`
+		Retryable bool        `json:"retryable"` // Retry accepts the task
+		Chain     []errorLink `json:"chain"`
+	}
+
+	// errorLink is an error of a chain, outermost first.
+	errorLink struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+)
+
+// errorCode classifies err. More specific causes are checked first, since a
+// failed task wraps both ErrTaskFailed and the underlying cause.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, errThreadUnavailable):
+		return codeThreadUnavailable
+	case errors.Is(err, errInvalidArgument):
+		return codeInvalidArgument
+	case errors.Is(err, asynctask.ErrTaskNotFound):
+		return codeTaskNotFound
+	case errors.Is(err, asynctask.ErrTaskTimeout):
+		return codeTimeout
+	case errors.Is(err, asynctask.ErrTaskPanicked):
+		return codePanicked
+	case errors.Is(err, asynctask.ErrTaskCanceled):
+		return codeCanceled
+	case errors.Is(err, ErrMaxDepthExceeded):
+		return codeDepthExceeded
+	case errors.Is(err, ErrSubrequestLoop):
+		return codeSubrequestLoop
+	case errors.Is(err, pubsub.ErrClosed):
+		return codeClosed
+	case errors.Is(err, asynctask.ErrQuotaExceeded):
+		return codeQuotaExceeded
+	case errors.Is(err, asynctask.ErrBudgetExceeded):
+		return codeBudgetExceeded
+	case errors.Is(err, asynctask.ErrMemoryExceeded):
+		return codeMemoryExceeded
+	case errors.Is(err, asynctask.ErrInsufficientDeadline):
+		return codeDeadline
+	case errors.Is(err, asynctask.ErrTaskFailed):
+		return codeFailed
+	default:
+		return codeInternal
+	}
+}
+
+// invalidArgument wraps a decoding or parsing failure of export input.
+func invalidArgument(err error) error {
+	return fmt.Errorf("%w: %v", errInvalidArgument, err)
+}
+
+// newBridgeError returns the envelope for err. taskID is added to the
+// details when the failure concerns a single known task.
+func newBridgeError(err error, taskID string) bridgeError {
+	envelope := bridgeError{
+		Code:    errorCode(err),
+		Message: err.Error(),
+		Details: map[string]any{},
+	}
+	if taskID != "" {
+		envelope.Details["task_id"] = taskID
+	}
+
+	// Failed commands report how they exited
+	var exit *asynctask.ExitError
+	if errors.As(err, &exit) {
+		envelope.Details["exit_code"] = exit.Code
+		envelope.Details["stderr"] = exit.Stderr
+	}
+
+	// Failed tasks report the last records they logged
+	var logged *asynctask.LoggedError
+	if errors.As(err, &logged) {
+		envelope.Details["logs"] = logged.Logs
+	}
+
+	return envelope
+}
+
+// encodeError builds the envelope for err, see newBridgeError.
+func encodeError(err error, taskID string) []byte {
+	return marshalBridgeError(newBridgeError(err, taskID))
+}
+
+func marshalBridgeError(envelope bridgeError) []byte {
+	data, mErr := json.Marshal(envelope)
+	if mErr != nil {
+		data = []byte(`{"code":"` + codeInternal + `","message":"failed to encode error"}`)
+	}
+
+	return data
+}
+
+// describeTaskError returns the structured form of the error of a task
+// that finished with status.
+func describeTaskError(err error, status asynctask.Status) taskError {
+	code := errorCode(err)
+	if code == codeInternal {
+		code = codeFailed
+	}
+	return taskError{
+		Code:      code,
+		Message:   err.Error(),
+		Timeout:   errors.Is(err, asynctask.ErrTaskTimeout) || errors.Is(err, context.DeadlineExceeded),
+		Canceled:  errors.Is(err, asynctask.ErrTaskCanceled) || errors.Is(err, context.Canceled),
+		Panicked:  errors.Is(err, asynctask.ErrTaskPanicked),
+		Retryable: status == asynctask.StatusFailed,
+		Chain:     errorChain(err, nil),
+	}
+}
+
+// errorChain appends err and the errors it wraps to chain, depth first.
+func errorChain(err error, chain []errorLink) []errorLink {
+	if err == nil {
+		return chain
+	}
+	chain = append(chain, errorLink{Type: fmt.Sprintf("%T", err), Message: err.Error()})
+	switch err := err.(type) {
+	case interface{ Unwrap() error }:
+		return errorChain(err.Unwrap(), chain)
+	case interface{ Unwrap() []error }:
+		for _, wrapped := range err.Unwrap() {
+			chain = errorChain(wrapped, chain)
+		}
+	}
+	return chain
+}
+
+// errorResult returns err as an envelope to the C side.
+func errorResult(err error, taskID string) (*C.char, C.bool) {
+	return cString(string(encodeError(err, taskID))), C.bool(false)
+}
+
+// errorData is errorResult for exports that return length-prefixed data.
+func errorData(err error, taskID string) (*C.char, C.size_t, C.bool) {
+	data := encodeError(err, taskID)
+	return cString(string(data)), C.size_t(len(data)), C.bool(false)
+}
+
+// dataError is errorData for an envelope built by newBridgeError.
+func dataError(envelope bridgeError) (*C.char, C.size_t, C.bool) {
+	data := marshalBridgeError(envelope)
+	return cString(string(data)), C.size_t(len(data)), C.bool(false)
+}
+
+// dataResult hands encoded data to the C side. The buffer may contain NUL
+// bytes (msgpack), so the length travels alongside it.
+func dataResult(data []byte) (*C.char, C.size_t, C.bool) {
+	return cBytes(data), C.size_t(len(data)), C.bool(true)
+}
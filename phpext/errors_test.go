@@ -0,0 +1,89 @@
+package phpext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/pubsub"
+)
+
+// Test that errors map onto the codes of their most specific cause
+func TestErrorCode(t *testing.T) {
+	failed := func(err error) error { return fmt.Errorf("%w: %w", asynctask.ErrTaskFailed, err) }
+
+	for err, want := range map[error]string{
+		errThreadUnavailable:                         codeThreadUnavailable,
+		invalidArgument(errors.New("bad json")):      codeInvalidArgument,
+		asynctask.ErrTaskNotFound:                    codeTaskNotFound,
+		errResultNotBuffered:                         codeTaskNotFound,
+		failed(asynctask.ErrTaskTimeout):             codeTimeout,
+		failed(asynctask.ErrTaskPanicked):            codePanicked,
+		failed(asynctask.ErrTaskCanceled):            codeCanceled,
+		fmt.Errorf("%w: a.php", ErrMaxDepthExceeded): codeDepthExceeded,
+		fmt.Errorf("%w: a.php", ErrSubrequestLoop):   codeSubrequestLoop,
+		pubsub.ErrClosed:                             codeClosed,
+		asynctask.ErrQuotaExceeded:                   codeQuotaExceeded,
+		asynctask.ErrBudgetExceeded:                  codeBudgetExceeded,
+		failed(asynctask.ErrMemoryExceeded):          codeMemoryExceeded,
+		asynctask.ErrInsufficientDeadline:            codeDeadline,
+		failed(errors.New("exit status 1")):          codeFailed,
+		errors.New("unexpected"):                     codeInternal,
+	} {
+		if got := errorCode(err); got != want {
+			t.Fatalf("got %s for %v, want %s", got, err, want)
+		}
+	}
+}
+
+// Test the envelope of an error, with the details PHP exceptions expose
+func TestNewBridgeError(t *testing.T) {
+	err := fmt.Errorf("%w: %w", asynctask.ErrTaskFailed, &asynctask.LoggedError{
+		Err:  &asynctask.ExitError{Code: 2, Stderr: "no such file", Err: errors.New("convert")},
+		Logs: []asynctask.LogRecord{{Level: "ERROR", Message: "Conversion Failed"}},
+	})
+
+	envelope := newBridgeError(err, "task")
+	assertEqual(t, envelope.Code, codeFailed)
+	assertEqual(t, envelope.Message, err.Error())
+	assertEqual(t, envelope.Details["task_id"], "task")
+	assertEqual(t, envelope.Details["exit_code"], 2)
+	assertEqual(t, envelope.Details["stderr"], "no such file")
+	assertEqual(t, len(envelope.Details["logs"].([]asynctask.LogRecord)), 1)
+
+	var decoded bridgeError
+	if err := json.Unmarshal(encodeError(asynctask.ErrTaskNotFound, ""), &decoded); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, decoded.Code, codeTaskNotFound)
+	assertEqual(t, decoded.Message, asynctask.ErrTaskNotFound.Error())
+	assertEqual(t, len(decoded.Details), 0)
+}
+
+// Test the structured form of task errors and their chains
+func TestDescribeTaskError(t *testing.T) {
+	err := fmt.Errorf("%w: %w", asynctask.ErrTaskTimeout, context.DeadlineExceeded)
+	info := describeTaskError(err, asynctask.StatusFailed)
+	assertEqual(t, info.Code, codeTimeout)
+	assertEqual(t, info.Timeout, true)
+	assertEqual(t, info.Canceled, false)
+	assertEqual(t, info.Retryable, true)
+	assertEqual(t, len(info.Chain), 3)
+	assertEqual(t, info.Chain[0].Message, err.Error())
+	assertEqual(t, info.Chain[1].Message, asynctask.ErrTaskTimeout.Error())
+	assertEqual(t, info.Chain[2].Message, context.DeadlineExceeded.Error())
+
+	info = describeTaskError(fmt.Errorf("canceling: %w", context.Canceled), asynctask.StatusCanceled)
+	assertEqual(t, info.Code, codeFailed)
+	assertEqual(t, info.Canceled, true)
+	assertEqual(t, info.Retryable, false)
+	assertEqual(t, info.Chain[0].Type, "*fmt.wrapError")
+	assertEqual(t, info.Chain[1].Type, "*errors.errorString")
+
+	info = describeTaskError(fmt.Errorf("%w: boom", asynctask.ErrTaskPanicked), asynctask.StatusFailed)
+	assertEqual(t, info.Code, codePanicked)
+	assertEqual(t, info.Panicked, true)
+}
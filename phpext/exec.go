@@ -0,0 +1,199 @@
+package phpext
+
+// #include <stdlib.h>
+// #include <stdint.h>
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+
+	"github.com/dunglas/frankenphp"
+)
+
+// ExecAllow lists the commands Frankenphp\Async\Command may run, as the
+// names or absolute paths PHP passes. Empty disables it.
+var ExecAllow []string
+
+// ExecEnvAllow lists the environment variables PHP may give commands.
+// PATH and the LD_* and DYLD_* variables of the dynamic linker are never
+// allowed.
+var ExecEnvAllow []string
+
+// ExecDirs lists the directories commands may run in, with those below
+// them. Empty leaves them running in the server's working directory.
+var ExecDirs []string
+
+// ExecLimits are the resource limits of every command.
+var ExecLimits asynctask.CommandLimits
+
+// ExecRunnable is the name command tasks are registered under with
+// asynctask.RegisterRunnable.
+const ExecRunnable = "frankenasync.exec"
+
+// ErrCommandNotAllowed is the error of commands missing from ExecAllow,
+// and of environment variables and directories PHP may not give them.
+var ErrCommandNotAllowed = errors.New("command not allowed")
+
+func init() {
+	asynctask.RegisterRunnable(ExecRunnable, newExecRunnable)
+}
+
+// execRequest is the payload from PHP for a command task.
+type execRequest struct {
+	Command   string            `json:"command"`
+	Args      []string          `json:"args,omitempty"`
+	Dir       string            `json:"dir,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Stdin     string            `json:"stdin,omitempty"`
+	Timeout   int64             `json:"timeout,omitempty"` // milliseconds, 0 for none
+	MaxOutput int               `json:"max_output,omitempty"`
+
+	// Pool and SideEffects as for scripts
+	Pool        string `json:"pool,omitempty"`
+	SideEffects bool   `json:"side_effects,omitempty"`
+}
+
+// newExecRunnable is the factory of ExecRunnable. The params are the JSON
+// fields of an execRequest. Commands not in ExecAllow are refused, as are
+// environment variables not in ExecEnvAllow and directories outside
+// ExecDirs.
+func newExecRunnable(params map[string]any) (asynctask.Runnable, error) {
+	var er execRequest
+	if err := convert(params, &er); err != nil {
+		return nil, err
+	}
+	if er.Command == "" {
+		return nil, errors.New("command required")
+	}
+	if !slices.Contains(ExecAllow, er.Command) {
+		return nil, fmt.Errorf("%w: %s", ErrCommandNotAllowed, er.Command)
+	}
+	for key := range er.Env {
+		if !execEnvAllowed(key) {
+			return nil, fmt.Errorf("%w: %s: environment variable %s", ErrCommandNotAllowed, er.Command, key)
+		}
+	}
+	dir, err := execDir(er.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrCommandNotAllowed, er.Command, err)
+	}
+
+	// Commands don't inherit the server's environment, which may hold
+	// secrets, only what they need to find other programs
+	cmd := asynctask.Command(er.Command, er.Args...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	for key, value := range er.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.Limits = ExecLimits
+	if er.Stdin != "" {
+		cmd.Stdin = []byte(er.Stdin)
+	}
+	cmd.MaxOutput = er.MaxOutput
+
+	var runnable asynctask.Runnable = cmd
+	if er.Timeout > 0 {
+		runnable = asynctask.WithTimeout(cmd, time.Duration(er.Timeout)*time.Millisecond)
+	}
+
+	return asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		result, err := runnable.Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return taskResult(result)
+	}), nil
+}
+
+// execEnvAllowed reports whether PHP may give commands the environment
+// variable key.
+func execEnvAllowed(key string) bool {
+	if key == "PATH" || strings.HasPrefix(key, "LD_") || strings.HasPrefix(key, "DYLD_") {
+		return false
+	}
+	return slices.Contains(ExecEnvAllow, key)
+}
+
+// execDir returns dir with its symbolic links resolved, failing unless
+// it's in or below one of ExecDirs. An empty dir stays empty.
+func execDir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("directory %s is not absolute", dir)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, allowed := range ExecDirs {
+		root, err := filepath.EvalSymlinks(allowed)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && filepath.IsLocal(rel) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("directory %s is not allowed", dir)
+}
+
+// execContext returns the context to start a command task with.
+func execContext(ctx context.Context, er *execRequest) context.Context {
+	if er.SideEffects {
+		ctx = asynctask.WithSideEffects(ctx)
+	}
+	labels := map[string]string{"command": er.Command}
+	if er.Pool != "" {
+		labels[asynctask.PoolLabel] = er.Pool
+	}
+	return asynctask.WithLabels(ctx, labels)
+}
+
+// go_exec_async starts a command task for Command::async, refusing
+// commands not in ExecAllow.
+//
+//export go_exec_async
+func go_exec_async(threadIndex C.uintptr_t, payload *C.char, payload_len C.size_t) (*C.char, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorResult(errThreadUnavailable, "")
+	}
+
+	ctx := thread.Request.Context()
+
+	var er execRequest
+	if err := currentEncoding().Unmarshal(C.GoBytes(unsafe.Pointer(payload), C.int(payload_len)), &er); err != nil {
+		return errorResult(invalidArgument(err), "")
+	}
+
+	var params map[string]any
+	if err := convert(&er, &params); err != nil {
+		return errorResult(invalidArgument(err), "")
+	}
+	runnable, err := asynctask.Spec{Name: ExecRunnable, Params: params}.Runnable()
+	if err != nil {
+		return errorResult(invalidArgument(err), "")
+	}
+
+	tasks := asynctask.FromContext(ctx)
+	taskID := tasks.Async(execContext(ctx, &er), runnable)
+
+	// Refuse tasks over the request's quotas up front rather than on await
+	if future, _ := tasks.Future(taskID); errors.Is(future.Error, asynctask.ErrQuotaExceeded) {
+		return errorResult(future.Error, taskID.String())
+	}
+
+	return cString(taskID.String()), C.bool(true)
+}
@@ -0,0 +1,54 @@
+package phpext
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test the environment variables and directories PHP may give commands
+func TestNewExecRunnable_Checks(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defer func(allow, env, dirs []string) { ExecAllow, ExecEnvAllow, ExecDirs = allow, env, dirs }(ExecAllow, ExecEnvAllow, ExecDirs)
+	ExecAllow = []string{"true"}
+	ExecEnvAllow = []string{"LANG", "PATH", "LD_PRELOAD"}
+	ExecDirs = []string{root}
+
+	for name, test := range map[string]struct {
+		params  map[string]any
+		allowed bool
+	}{
+		"no env or dir":   {map[string]any{}, true},
+		"allowed env":     {map[string]any{"env": map[string]any{"LANG": "C.UTF-8"}}, true},
+		"unlisted env":    {map[string]any{"env": map[string]any{"HOME": "/"}}, false},
+		"PATH":            {map[string]any{"env": map[string]any{"PATH": "/tmp"}}, false},
+		"LD_PRELOAD":      {map[string]any{"env": map[string]any{"LD_PRELOAD": "/tmp/x.so"}}, false},
+		"DYLD_":           {map[string]any{"env": map[string]any{"DYLD_INSERT_LIBRARIES": "/tmp/x"}}, false},
+		"allowed dir":     {map[string]any{"dir": root}, true},
+		"dir below":       {map[string]any{"dir": filepath.Join(root, "sub")}, true},
+		"relative dir":    {map[string]any{"dir": "sub"}, false},
+		"dir outside":     {map[string]any{"dir": outside}, false},
+		"dir via ..":      {map[string]any{"dir": filepath.Join(root, "..")}, false},
+		"dir via symlink": {map[string]any{"dir": filepath.Join(root, "escape")}, false},
+		"missing dir":     {map[string]any{"dir": filepath.Join(root, "missing")}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			test.params["command"] = "true"
+			_, err := newExecRunnable(test.params)
+			if test.allowed && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.allowed && !errors.Is(err, ErrCommandNotAllowed) {
+				t.Fatalf("expected ErrCommandNotAllowed, got %v", err)
+			}
+		})
+	}
+}
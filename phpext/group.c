@@ -0,0 +1,264 @@
+/**
+ * FrankenAsync Task Groups
+ *
+ * Registers Frankenphp\Async\TaskGroup, a keyed set of futures awaited,
+ * canceled and inspected together. Results and statuses come back under
+ * the keys the futures were added with.
+ */
+
+#include <php.h>
+
+#include <Zend/zend_interfaces.h>
+#include <Zend/zend_exceptions.h>
+
+#include "phpext.h"
+#include "util.h"
+
+static zend_class_entry *taskgroup_ce = NULL;
+static zend_object_handlers taskgroup_object_handlers;
+
+static const zend_function_entry taskgroup_methods[];
+
+static inline taskgroup_object *taskgroup_from_obj(zend_object *obj) {
+    return (taskgroup_object *)((char *)(obj) - XtOffsetOf(taskgroup_object, std));
+}
+
+static zend_object *taskgroup_create_object(zend_class_entry *ce)
+{
+    taskgroup_object *intern = ecalloc(1, sizeof(taskgroup_object) + zend_object_properties_size(ce));
+
+    zend_object_std_init(&intern->std, ce);
+    object_properties_init(&intern->std, ce);
+
+    array_init(&intern->futures);
+    intern->std.handlers = &taskgroup_object_handlers;
+
+    return &intern->std;
+}
+
+static void taskgroup_free_object(zend_object *object)
+{
+    taskgroup_object *intern = taskgroup_from_obj(object);
+
+    zval_ptr_dtor(&intern->futures);
+
+    zend_object_std_dtor(&intern->std);
+}
+
+static HashTable *taskgroup_get_gc(zend_object *object, zval **table, int *n)
+{
+    taskgroup_object *intern = taskgroup_from_obj(object);
+
+    *table = &intern->futures;
+    *n = 1;
+
+    return zend_std_get_properties(object);
+}
+
+int frankenasync_taskgroup_minit(void)
+{
+    zend_class_entry ce;
+
+    INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async", "TaskGroup", taskgroup_methods);
+
+    taskgroup_ce = zend_register_internal_class(&ce);
+    if (!taskgroup_ce) {
+        return FAILURE;
+    }
+
+    taskgroup_ce->ce_flags |= ZEND_ACC_FINAL;
+    taskgroup_ce->create_object = taskgroup_create_object;
+    zend_class_implements(taskgroup_ce, 1, zend_ce_countable);
+
+    memcpy(&taskgroup_object_handlers, zend_get_std_object_handlers(), sizeof(zend_object_handlers));
+    taskgroup_object_handlers.offset = XtOffsetOf(taskgroup_object, std);
+    taskgroup_object_handlers.free_obj = taskgroup_free_object;
+    taskgroup_object_handlers.get_gc = taskgroup_get_gc;
+    taskgroup_object_handlers.clone_obj = NULL;
+
+    return SUCCESS;
+}
+
+/* Calls a static Future method with the group's futures, which hands back
+ * results under their keys */
+static void taskgroup_call_future(taskgroup_object *intern, const char *method, size_t method_len, zval *timeout_param, zval *retval)
+{
+    zval timeout;
+
+    if (timeout_param) {
+        ZVAL_COPY_VALUE(&timeout, timeout_param);
+    } else {
+        ZVAL_LONG(&timeout, 0);
+    }
+
+    zend_call_method(NULL, frankenasync_asyncfuture_class_entry(), NULL, method, method_len, retval, 2, &intern->futures, &timeout);
+}
+
+PHP_METHOD(Async_TaskGroup, __construct)
+{
+    HashTable *futures = NULL;
+
+    ZEND_PARSE_PARAMETERS_START(0, 1)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ARRAY_HT(futures)
+    ZEND_PARSE_PARAMETERS_END();
+
+    if (!futures) {
+        return;
+    }
+
+    zval *future;
+    ZEND_HASH_FOREACH_VAL(futures, future) {
+        if (UNEXPECTED(Z_TYPE_P(future) != IS_OBJECT ||
+            !instanceof_function(Z_OBJCE_P(future), frankenasync_asyncfuture_class_entry()))) {
+            zend_argument_type_error(1, "must contain only Frankenphp\\Async\\Future objects");
+            RETURN_THROWS();
+        }
+    } ZEND_HASH_FOREACH_END();
+
+    taskgroup_object *intern = taskgroup_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    zval_ptr_dtor(&intern->futures);
+    ZVAL_ARR(&intern->futures, zend_array_dup(futures));
+}
+
+PHP_METHOD(Async_TaskGroup, add)
+{
+    zval *future;
+    zend_string *str_key = NULL;
+    zend_long long_key = 0;
+    bool key_is_null = 1;
+
+    ZEND_PARSE_PARAMETERS_START(1, 2)
+        Z_PARAM_OBJECT_OF_CLASS(future, frankenasync_asyncfuture_class_entry())
+        Z_PARAM_OPTIONAL
+        Z_PARAM_STR_OR_LONG_OR_NULL(str_key, long_key, key_is_null)
+    ZEND_PARSE_PARAMETERS_END();
+
+    taskgroup_object *intern = taskgroup_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    Z_ADDREF_P(future);
+    if (str_key) {
+        zend_symtable_update(Z_ARRVAL(intern->futures), str_key, future);
+    } else if (!key_is_null) {
+        zend_hash_index_update(Z_ARRVAL(intern->futures), long_key, future);
+    } else if (UNEXPECTED(!zend_hash_next_index_insert(Z_ARRVAL(intern->futures), future))) {
+        Z_DELREF_P(future);
+        frankenasync_throw_error("Cannot add future to the group: no next key");
+        RETURN_THROWS();
+    }
+
+    RETURN_OBJ_COPY(Z_OBJ_P(ZEND_THIS));
+}
+
+PHP_METHOD(Async_TaskGroup, getFutures)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    taskgroup_object *intern = taskgroup_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    RETURN_COPY(&intern->futures);
+}
+
+PHP_METHOD(Async_TaskGroup, count)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    taskgroup_object *intern = taskgroup_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    RETURN_LONG(zend_hash_num_elements(Z_ARRVAL(intern->futures)));
+}
+
+PHP_METHOD(Async_TaskGroup, awaitAll)
+{
+    zval *timeout_param = NULL;
+
+    ZEND_PARSE_PARAMETERS_START(0, 1)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ZVAL(timeout_param)
+    ZEND_PARSE_PARAMETERS_END();
+
+    taskgroup_call_future(taskgroup_from_obj(Z_OBJ_P(ZEND_THIS)), "awaitall", sizeof("awaitall") - 1, timeout_param, return_value);
+}
+
+PHP_METHOD(Async_TaskGroup, awaitAny)
+{
+    zval *timeout_param = NULL;
+
+    ZEND_PARSE_PARAMETERS_START(0, 1)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ZVAL(timeout_param)
+    ZEND_PARSE_PARAMETERS_END();
+
+    taskgroup_call_future(taskgroup_from_obj(Z_OBJ_P(ZEND_THIS)), "awaitanyindex", sizeof("awaitanyindex") - 1, timeout_param, return_value);
+}
+
+PHP_METHOD(Async_TaskGroup, cancelAll)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    taskgroup_object *intern = taskgroup_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    zend_long canceled = 0;
+    zval *future;
+    ZEND_HASH_FOREACH_VAL(Z_ARRVAL(intern->futures), future) {
+        zval retval;
+        ZVAL_UNDEF(&retval);
+
+        zend_call_method(Z_OBJ_P(future), Z_OBJCE_P(future), NULL, "cancel", sizeof("cancel") - 1, &retval, 0, NULL, NULL);
+
+        if (UNEXPECTED(EG(exception))) {
+            zval_ptr_dtor(&retval);
+            RETURN_THROWS();
+        }
+
+        if (Z_TYPE(retval) == IS_TRUE) {
+            canceled++;
+        }
+    } ZEND_HASH_FOREACH_END();
+
+    RETURN_LONG(canceled);
+}
+
+PHP_METHOD(Async_TaskGroup, getStatuses)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    taskgroup_object *intern = taskgroup_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    array_init_size(return_value, zend_hash_num_elements(Z_ARRVAL(intern->futures)));
+
+    zend_string *key;
+    zend_ulong index;
+    zval *future;
+    ZEND_HASH_FOREACH_KEY_VAL(Z_ARRVAL(intern->futures), index, key, future) {
+        zval status;
+        ZVAL_UNDEF(&status);
+
+        zend_call_method(Z_OBJ_P(future), Z_OBJCE_P(future), NULL, "getstatus", sizeof("getstatus") - 1, &status, 0, NULL, NULL);
+
+        if (UNEXPECTED(EG(exception))) {
+            zval_ptr_dtor(&status);
+            zval_ptr_dtor(return_value);
+            RETURN_THROWS();
+        }
+
+        if (key) {
+            zend_hash_update(Z_ARRVAL_P(return_value), key, &status);
+        } else {
+            zend_hash_index_update(Z_ARRVAL_P(return_value), index, &status);
+        }
+    } ZEND_HASH_FOREACH_END();
+}
+
+static const zend_function_entry taskgroup_methods[] = {
+    PHP_ME(Async_TaskGroup, __construct, arginfo_taskgroup___construct, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_TaskGroup, add, arginfo_taskgroup_add, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_TaskGroup, getFutures, arginfo_taskgroup_getFutures, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_TaskGroup, count, arginfo_taskgroup_count, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_TaskGroup, awaitAll, arginfo_taskgroup_awaitAll, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_TaskGroup, awaitAny, arginfo_taskgroup_awaitAny, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_TaskGroup, cancelAll, arginfo_taskgroup_cancelAll, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_TaskGroup, getStatuses, arginfo_taskgroup_getStatuses, ZEND_ACC_PUBLIC)
+    PHP_FE_END
+};
@@ -0,0 +1,104 @@
+package phpext
+
+// #include <stdlib.h>
+// #include <stdint.h>
+import "C"
+import (
+	"errors"
+	"time"
+	"unsafe"
+
+	"github.com/johanjanssens/frankenasync/kvstore"
+
+	"github.com/dunglas/frankenphp"
+)
+
+// Store scopes, matching Frankenphp\Async\Store::SCOPE_* on the PHP side.
+const (
+	kvScopeRequest = 0
+	kvScopeGlobal  = 1
+)
+
+// SharedStore backs the server-global Store scope. Request-scoped stores are
+// attached to each request context by the application with kvstore.WithContext.
+var SharedStore = kvstore.New()
+
+// kvStore resolves the store for scope from the calling thread's request.
+func kvStore(threadIndex C.uintptr_t, scope C.int) (*kvstore.Store, error) {
+	switch scope {
+	case kvScopeGlobal:
+		return SharedStore, nil
+	case kvScopeRequest:
+		thread, ok := frankenphp.Thread(int(threadIndex))
+		if !ok || thread.IsRequestDone() {
+			return nil, errThreadUnavailable
+		}
+		return kvstore.FromContext(thread.Request.Context()), nil
+	default:
+		return nil, invalidArgument(errors.New("unknown store scope"))
+	}
+}
+
+//export go_kv_get
+func go_kv_get(threadIndex C.uintptr_t, scope C.int, key *C.char) (*C.char, C.size_t, C.bool) {
+	store, err := kvStore(threadIndex, scope)
+	if err != nil {
+		return errorData(err, "")
+	}
+
+	value, ok := store.Get(C.GoString(key))
+	if !ok {
+		return nil, 0, C.bool(true)
+	}
+
+	return dataResult(value)
+}
+
+//export go_kv_set
+func go_kv_set(threadIndex C.uintptr_t, scope C.int, key *C.char, value *C.char, value_len C.size_t, ttl_ms C.longlong) (*C.char, C.bool) {
+	store, err := kvStore(threadIndex, scope)
+	if err != nil {
+		return errorResult(err, "")
+	}
+
+	store.Set(C.GoString(key), C.GoBytes(unsafe.Pointer(value), C.int(value_len)), time.Duration(ttl_ms)*time.Millisecond)
+
+	return nil, C.bool(true)
+}
+
+//export go_kv_delete
+func go_kv_delete(threadIndex C.uintptr_t, scope C.int, key *C.char) (C.bool, *C.char, C.bool) {
+	store, err := kvStore(threadIndex, scope)
+	if err != nil {
+		errData, ok := errorResult(err, "")
+		return false, errData, ok
+	}
+
+	return C.bool(store.Delete(C.GoString(key))), nil, C.bool(true)
+}
+
+// go_kv_cas swaps key to value if it currently holds expected. A NULL
+// expected swaps only if the key does not exist.
+//
+//export go_kv_cas
+func go_kv_cas(threadIndex C.uintptr_t, scope C.int, key *C.char, expected *C.char, expected_len C.size_t, value *C.char, value_len C.size_t, ttl_ms C.longlong) (C.bool, *C.char, C.bool) {
+	store, err := kvStore(threadIndex, scope)
+	if err != nil {
+		errData, ok := errorResult(err, "")
+		return false, errData, ok
+	}
+
+	var old []byte
+	if expected != nil {
+		old = C.GoBytes(unsafe.Pointer(expected), C.int(expected_len))
+	}
+
+	swapped := store.CompareAndSwap(
+		C.GoString(key),
+		old,
+		C.GoBytes(unsafe.Pointer(value), C.int(value_len)),
+		time.Duration(ttl_ms)*time.Millisecond,
+	)
+
+	return C.bool(swapped), nil, C.bool(true)
+}
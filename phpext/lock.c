@@ -0,0 +1,202 @@
+/**
+ * FrankenAsync Locks
+ *
+ * Registers Frankenphp\Async\Lock, named locks and counting semaphores
+ * granted by the Go lock backend, so concurrent tasks can serialize access
+ * to shared resources.
+ */
+
+#include <php.h>
+
+#include <ext/spl/spl_exceptions.h>
+
+#include <Zend/zend_exceptions.h>
+
+#include "phpext.h"
+#include "util.h"
+#include "phpext_cgo.h"
+
+#include "frankenphp.h"
+
+#define LOCK_DEFAULT_TTL_MS 30000
+
+static zend_class_entry *lock_ce = NULL;
+static zend_object_handlers lock_object_handlers;
+
+static const zend_function_entry lock_methods[];
+
+static inline lock_object *lock_from_obj(zend_object *obj) {
+    return (lock_object *)((char *)(obj) - XtOffsetOf(lock_object, std));
+}
+
+static zend_object *lock_create_object(zend_class_entry *ce)
+{
+    lock_object *intern = ecalloc(1, sizeof(lock_object) + zend_object_properties_size(ce));
+
+    zend_object_std_init(&intern->std, ce);
+    object_properties_init(&intern->std, ce);
+
+    intern->name = NULL;
+    intern->token = NULL;
+    intern->std.handlers = &lock_object_handlers;
+
+    return &intern->std;
+}
+
+/* Release the permit if still held. Returns whether it was released. */
+static zend_bool lock_release(lock_object *intern, zend_bool throw_on_error)
+{
+    if (!intern->token) {
+        return 0;
+    }
+
+    struct go_lock_release_return result = go_lock_release(
+        frankenphp_thread_index(),
+        ZSTR_VAL(intern->name),
+        ZSTR_VAL(intern->token)
+    );
+
+    zend_string_release(intern->token);
+    intern->token = NULL;
+
+    if (UNEXPECTED(!result.r2)) {
+        if (throw_on_error) {
+            frankenasync_throw_bridge_error(result.r1);
+        }
+        go_free_result(result.r1);
+        return 0;
+    }
+
+    return result.r0;
+}
+
+static void lock_free_object(zend_object *object)
+{
+    lock_object *intern = lock_from_obj(object);
+
+    lock_release(intern, 0);
+
+    if (intern->name) {
+        zend_string_release(intern->name);
+    }
+
+    zend_object_std_dtor(&intern->std);
+}
+
+int frankenasync_lock_minit(void)
+{
+    zend_class_entry ce;
+
+    INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async", "Lock", lock_methods);
+
+    lock_ce = zend_register_internal_class(&ce);
+    if (!lock_ce) {
+        return FAILURE;
+    }
+
+    lock_ce->ce_flags |= ZEND_ACC_FINAL;
+    lock_ce->create_object = lock_create_object;
+
+    memcpy(&lock_object_handlers, zend_get_std_object_handlers(), sizeof(zend_object_handlers));
+    lock_object_handlers.offset = XtOffsetOf(lock_object, std);
+    lock_object_handlers.free_obj = lock_free_object;
+    lock_object_handlers.clone_obj = NULL;
+
+    return SUCCESS;
+}
+
+PHP_METHOD(Async_Lock, __construct)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+}
+
+PHP_METHOD(Async_Lock, acquire)
+{
+    zend_string *name;
+    zval *ttl_param = NULL;
+    zval *timeout_param = NULL;
+    zend_long permits = 1;
+
+    ZEND_PARSE_PARAMETERS_START(1, 4)
+        Z_PARAM_STR(name)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ZVAL(ttl_param)
+        Z_PARAM_ZVAL(timeout_param)
+        Z_PARAM_LONG(permits)
+    ZEND_PARSE_PARAMETERS_END();
+
+    if (permits < 1) {
+        zend_throw_exception_ex(spl_ce_InvalidArgumentException, 0,
+            "The 'permits' parameter must be at least 1");
+        RETURN_THROWS();
+    }
+
+    zend_long ttl_ms = LOCK_DEFAULT_TTL_MS;
+    if (ttl_param) {
+        PARSE_TIMEOUT_PARAM(ttl_param)
+        ttl_ms = timeout_ms;
+    }
+
+    PARSE_TIMEOUT_PARAM(timeout_param)
+
+    struct go_lock_acquire_return result = go_lock_acquire(
+        frankenphp_thread_index(),
+        ZSTR_VAL(name),
+        (int) permits,
+        ttl_ms,
+        timeout_ms
+    );
+
+    if (UNEXPECTED(!result.r1)) {
+        frankenasync_throw_bridge_error(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+
+    /* Timed out waiting for a permit */
+    if (result.r0 == NULL) {
+        RETURN_NULL();
+    }
+
+    object_init_ex(return_value, lock_ce);
+
+    lock_object *intern = lock_from_obj(Z_OBJ_P(return_value));
+    intern->name = zend_string_copy(name);
+    intern->token = zend_string_init(result.r0, strlen(result.r0), 0);
+
+    go_free_result(result.r0);
+}
+
+PHP_METHOD(Async_Lock, release)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    zend_bool released = lock_release(lock_from_obj(Z_OBJ_P(ZEND_THIS)), 1);
+    if (EG(exception)) {
+        RETURN_THROWS();
+    }
+
+    RETURN_BOOL(released);
+}
+
+PHP_METHOD(Async_Lock, getName)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    lock_object *intern = lock_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    if (UNEXPECTED(!intern->name)) {
+        frankenasync_throw_error("Lock not properly initialized");
+        RETURN_THROWS();
+    }
+
+    RETURN_STR_COPY(intern->name);
+}
+
+static const zend_function_entry lock_methods[] = {
+    PHP_ME(Async_Lock, __construct, arginfo_lock___construct, ZEND_ACC_PRIVATE)
+    PHP_ME(Async_Lock, acquire, arginfo_lock_acquire, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_ME(Async_Lock, release, arginfo_lock_release, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Lock, getName, arginfo_lock_getName, ZEND_ACC_PUBLIC)
+    PHP_FE_END
+};
@@ -0,0 +1,69 @@
+package phpext
+
+// #include <stdlib.h>
+// #include <stdint.h>
+import "C"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/locks"
+
+	"github.com/dunglas/frankenphp"
+)
+
+// Locks grants the named locks and semaphores used by Frankenphp\Async\Lock.
+// It defaults to an in-process backend; set it to a shared backend such as
+// redislock before serving requests to lock across servers.
+var Locks locks.Backend = locks.NewMemory()
+
+// go_lock_acquire returns a permit token, or NULL when timeout elapses first.
+// Permits still held when the acquiring request ends are released.
+//
+//export go_lock_acquire
+func go_lock_acquire(threadIndex C.uintptr_t, name *C.char, limit C.int, ttl_ms C.longlong, timeout C.int) (*C.char, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorResult(errThreadUnavailable, "")
+	}
+
+	reqCtx := thread.Request.Context()
+	ctx := reqCtx
+
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		durTimeout := time.Duration(timeout) * time.Millisecond
+		ctx, cancel = context.WithTimeout(ctx, durTimeout)
+		defer cancel()
+	}
+
+	lockName := C.GoString(name)
+	token, err := Locks.Acquire(ctx, lockName, int(limit), time.Duration(ttl_ms)*time.Millisecond)
+	if err != nil {
+		if timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+			return nil, C.bool(true)
+		}
+		return errorResult(err, "")
+	}
+
+	context.AfterFunc(reqCtx, func() {
+		_ = Locks.Release(context.Background(), lockName, token)
+	})
+
+	return cString(token), C.bool(true)
+}
+
+//export go_lock_release
+func go_lock_release(threadIndex C.uintptr_t, name *C.char, token *C.char) (C.bool, *C.char, C.bool) {
+	err := Locks.Release(context.Background(), C.GoString(name), C.GoString(token))
+	if errors.Is(err, locks.ErrNotHeld) {
+		return false, nil, C.bool(true)
+	}
+	if err != nil {
+		errData, ok := errorResult(err, "")
+		return false, errData, ok
+	}
+
+	return true, nil, C.bool(true)
+}
@@ -21,6 +21,7 @@
 #include <Zend/zend_interfaces.h>
 
 #include "phpext.h"
+#include "encoding.h"
 #include "util.h"
 #include "phpext_cgo.h"
 
@@ -42,6 +43,7 @@ static zend_object_handlers asyncfuture_object_handlers;
 /* AsyncFuture object structure */
 typedef struct _frankenasync_asyncfuture_object {
     zend_string *task_id;
+    zval callbacks; /* onComplete() callbacks, UNDEF when there are none */
     zend_object std;
 } frankenasync_asyncfuture_object;
 
@@ -61,16 +63,21 @@ static zend_class_entry *asyncfuture_panic_ce;
 static zend_object *script_create_object(zend_class_entry *ce);
 static void script_free_object(zend_object *object);
 static inline script_object *script_from_obj(zend_object *obj);
-static int build_script_payload(smart_str *json_payload, const char *script_name, HashTable *ini, HashTable *app, HashTable *server);
+static int build_script_payload(smart_str *payload, const char *script_name, HashTable *ini, HashTable *options, HashTable *app, HashTable *server);
 static const zend_function_entry script_methods[];
 
 /* AsyncFuture */
 static zend_object *asyncfuture_create_object(zend_class_entry *ce);
 static void asyncfuture_free_object(zend_object *object);
+static HashTable *asyncfuture_get_gc(zend_object *object, zval **table, int *n);
 static inline frankenasync_asyncfuture_object *frankenasync_asyncfuture_from_obj(zend_object *obj);
 static inline void asyncfuture_throw_exception(const char *error_msg);
+static void asyncfuture_complete(zval *future, zval *result, zend_object *error);
+static zval *asyncfuture_keyed_find(HashTable *ht, zend_string *key, zend_ulong index);
+static void asyncfuture_complete_with_exception(zval *future);
 static const zend_function_entry asyncfuture_methods[];
 static const zend_function_entry asyncfuture_status_methods[];
+static const zend_function_entry asyncfuture_exception_methods[];
 
 /* ============================================================================
  * MODULE LIFECYCLE
@@ -96,6 +103,9 @@ void frankenasync_register() {
 }
 
 int frankenasync_minit(int type, int module_number) {
+    /* Negotiate payload encoding with Go */
+    frankenasync_encoding = go_bridge_encoding();
+
     /* Register Script class */
     if (frankenasync_script_minit() != SUCCESS) {
         php_error(E_WARNING, "Failed to register Frankenphp\\Script class.");
@@ -108,6 +118,36 @@ int frankenasync_minit(int type, int module_number) {
         return FAILURE;
     }
 
+    /* Register Store class */
+    if (frankenasync_store_minit() != SUCCESS) {
+        php_error(E_WARNING, "Failed to register Frankenphp\\Async\\Store class.");
+        return FAILURE;
+    }
+
+    /* Register PubSub and Subscription classes */
+    if (frankenasync_pubsub_minit() != SUCCESS) {
+        php_error(E_WARNING, "Failed to register Frankenphp\\Async\\PubSub classes.");
+        return FAILURE;
+    }
+
+    /* Register Lock class */
+    if (frankenasync_lock_minit() != SUCCESS) {
+        php_error(E_WARNING, "Failed to register Frankenphp\\Async\\Lock class.");
+        return FAILURE;
+    }
+
+    /* Register TaskGroup class */
+    if (frankenasync_taskgroup_minit() != SUCCESS) {
+        php_error(E_WARNING, "Failed to register Frankenphp\\Async\\TaskGroup class.");
+        return FAILURE;
+    }
+
+    /* Register Command class */
+    if (frankenasync_command_minit() != SUCCESS) {
+        php_error(E_WARNING, "Failed to register Frankenphp\\Async\\Command class.");
+        return FAILURE;
+    }
+
     return SUCCESS;
 }
 
@@ -158,6 +198,7 @@ static zend_object *script_create_object(zend_class_entry *ce)
 
     intern->name = NULL;
     intern->ini = NULL;
+    intern->options = NULL;
     intern->std.handlers = &script_object_handlers;
 
     return &intern->std;
@@ -175,6 +216,10 @@ static void script_free_object(zend_object *object)
         zend_array_release(intern->ini);
     }
 
+    if (intern->options) {
+        zend_array_release(intern->options);
+    }
+
     zend_object_std_dtor(&intern->std);
 }
 
@@ -182,11 +227,13 @@ PHP_METHOD(Script, __construct)
 {
     zend_string *script_name;
     HashTable *ini = NULL;
+    HashTable *options = NULL;
 
-    ZEND_PARSE_PARAMETERS_START(1, 2)
+    ZEND_PARSE_PARAMETERS_START(1, 3)
         Z_PARAM_STR(script_name)
         Z_PARAM_OPTIONAL
         Z_PARAM_ARRAY_HT_OR_NULL(ini)
+        Z_PARAM_ARRAY_HT_OR_NULL(options)
     ZEND_PARSE_PARAMETERS_END();
 
     /* Validate INI array: must be associative with string values */
@@ -196,6 +243,13 @@ PHP_METHOD(Script, __construct)
         return;
     }
 
+    /* Validate options array: forward_cookies, forward_session, header_allow, header_deny */
+    if (options && zend_hash_num_elements(options) > 0 && !frankenasync_is_associative(options)) {
+        zend_throw_exception_ex(spl_ce_InvalidArgumentException, 0,
+            "The 'options' parameter must be an associative array with string keys");
+        return;
+    }
+
     script_object *intern = script_from_obj(Z_OBJ_P(ZEND_THIS));
 
     /* Resolve script name using include path if relative */
@@ -218,6 +272,13 @@ PHP_METHOD(Script, __construct)
         zend_hash_init(intern->ini, zend_hash_num_elements(ini), NULL, ZVAL_PTR_DTOR, 0);
         zend_hash_copy(intern->ini, ini, (copy_ctor_func_t) zval_add_ref);
     }
+
+    /* Store forwarding options (copy if provided) */
+    if (options && zend_hash_num_elements(options) > 0) {
+        ALLOC_HASHTABLE(intern->options);
+        zend_hash_init(intern->options, zend_hash_num_elements(options), NULL, ZVAL_PTR_DTOR, 0);
+        zend_hash_copy(intern->options, options, (copy_ctor_func_t) zval_add_ref);
+    }
 }
 
 PHP_METHOD(Script, getName)
@@ -237,7 +298,7 @@ PHP_METHOD(Script, execute)
 {
     HashTable *app = NULL;
     HashTable *server = NULL;
-    smart_str json_payload = {0};
+    smart_str payload = {0};
 
     ZEND_PARSE_PARAMETERS_START(0, 2)
         Z_PARAM_OPTIONAL
@@ -264,23 +325,24 @@ PHP_METHOD(Script, execute)
         return;
     }
 
-    if (UNEXPECTED(build_script_payload(&json_payload, ZSTR_VAL(intern->name), intern->ini, app, server) == FAILURE)) {
-        smart_str_free(&json_payload);
+    if (UNEXPECTED(build_script_payload(&payload, ZSTR_VAL(intern->name), intern->ini, intern->options, app, server) == FAILURE)) {
+        smart_str_free(&payload);
         frankenasync_throw_exception("Failed to encode payload");
         RETURN_THROWS();
     }
 
     struct go_execute_script_return result = go_execute_script(
         frankenphp_thread_index(),
-        ZSTR_VAL(json_payload.s)
+        ZSTR_VAL(payload.s),
+        ZSTR_LEN(payload.s)
     );
 
-    smart_str_free(&json_payload);
+    smart_str_free(&payload);
 
-    if (UNEXPECTED(!result.r1)) {
+    if (UNEXPECTED(!result.r2)) {
         if (result.r0) {
-            frankenasync_throw_exception("%s", result.r0);
-            free(result.r0);
+            asyncfuture_throw_exception(result.r0);
+            go_free_result(result.r0);
         } else {
             frankenasync_throw_error("Unknown internal error in runtime");
         }
@@ -295,13 +357,13 @@ PHP_METHOD(Script, execute)
     zval decoded_result;
     ZVAL_UNDEF(&decoded_result);
 
-    if (UNEXPECTED(php_json_decode_ex(&decoded_result, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) != SUCCESS)) {
+    if (UNEXPECTED(frankenasync_decode_payload(&decoded_result, result.r0, result.r1) != SUCCESS)) {
         frankenasync_throw_error("Failed to decode data");
-        free(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
     }
 
-    free(result.r0);
+    go_free_result(result.r0);
 
     /* Remove internal fields from result */
     if (Z_TYPE(decoded_result) == IS_ARRAY) {
@@ -316,7 +378,7 @@ PHP_METHOD(Script, async)
 {
     HashTable *app = NULL;
     HashTable *server = NULL;
-    smart_str json_payload = {0};
+    smart_str payload = {0};
 
     ZEND_PARSE_PARAMETERS_START(0, 2)
         Z_PARAM_OPTIONAL
@@ -343,23 +405,24 @@ PHP_METHOD(Script, async)
         return;
     }
 
-    if (UNEXPECTED(build_script_payload(&json_payload, ZSTR_VAL(intern->name), intern->ini, app, server) == FAILURE)) {
-        smart_str_free(&json_payload);
+    if (UNEXPECTED(build_script_payload(&payload, ZSTR_VAL(intern->name), intern->ini, intern->options, app, server) == FAILURE)) {
+        smart_str_free(&payload);
         frankenasync_throw_exception("Failed to encode payload");
         RETURN_THROWS();
     }
 
     struct go_execute_script_async_return result = go_execute_script_async(
         frankenphp_thread_index(),
-        ZSTR_VAL(json_payload.s)
+        ZSTR_VAL(payload.s),
+        ZSTR_LEN(payload.s)
     );
 
-    smart_str_free(&json_payload);
+    smart_str_free(&payload);
 
     if (UNEXPECTED(!result.r1)) {
         if (result.r0) {
-            frankenasync_throw_exception("%s", result.r0);
-            free(result.r0);
+            asyncfuture_throw_exception(result.r0);
+            go_free_result(result.r0);
         } else {
             frankenasync_throw_error("Unknown internal error in runtime");
         }
@@ -372,14 +435,14 @@ PHP_METHOD(Script, async)
     }
 
     frankenasync_create_asyncfuture_object(return_value, result.r0);
-    free(result.r0);
+    go_free_result(result.r0);
 }
 
 PHP_METHOD(Script, defer)
 {
     HashTable *app = NULL;
     HashTable *server = NULL;
-    smart_str json_payload = {0};
+    smart_str payload = {0};
 
     ZEND_PARSE_PARAMETERS_START(0, 2)
         Z_PARAM_OPTIONAL
@@ -406,23 +469,24 @@ PHP_METHOD(Script, defer)
         return;
     }
 
-    if (UNEXPECTED(build_script_payload(&json_payload, ZSTR_VAL(intern->name), intern->ini, app, server) == FAILURE)) {
-        smart_str_free(&json_payload);
+    if (UNEXPECTED(build_script_payload(&payload, ZSTR_VAL(intern->name), intern->ini, intern->options, app, server) == FAILURE)) {
+        smart_str_free(&payload);
         frankenasync_throw_exception("Failed to encode payload");
         RETURN_THROWS();
     }
 
     struct go_execute_script_defer_return result = go_execute_script_defer(
         frankenphp_thread_index(),
-        ZSTR_VAL(json_payload.s)
+        ZSTR_VAL(payload.s),
+        ZSTR_LEN(payload.s)
     );
 
-    smart_str_free(&json_payload);
+    smart_str_free(&payload);
 
     if (UNEXPECTED(!result.r1)) {
         if (result.r0) {
-            frankenasync_throw_exception("%s", result.r0);
-            free(result.r0);
+            asyncfuture_throw_exception(result.r0);
+            go_free_result(result.r0);
         } else {
             frankenasync_throw_error("Unknown internal error in runtime");
         }
@@ -435,7 +499,7 @@ PHP_METHOD(Script, defer)
     }
 
     frankenasync_create_asyncfuture_object(return_value, result.r0);
-    free(result.r0);
+    go_free_result(result.r0);
 }
 
 PHP_METHOD(Script, __invoke)
@@ -457,13 +521,28 @@ static inline script_object *script_from_obj(zend_object *obj) {
     return (script_object *)((char *)(obj) - XtOffsetOf(script_object, std));
 }
 
-static int build_script_payload(smart_str *json_payload, const char *script_name, HashTable *ini, HashTable *app, HashTable *server)
+static int build_script_payload(smart_str *payload, const char *script_name, HashTable *ini, HashTable *options, HashTable *app, HashTable *server)
 {
     zval payload_array;
     array_init(&payload_array);
 
     add_assoc_string(&payload_array, "name", script_name);
 
+    /* Options are merged as top-level fields; reserved keys cannot be overridden */
+    if (options && zend_hash_num_elements(options) > 0) {
+        zend_string *key;
+        zval *val;
+
+        ZEND_HASH_FOREACH_STR_KEY_VAL(options, key, val) {
+            if (!key || zend_string_equals_literal(key, "name") ||
+                zend_string_equals_literal(key, "ini") || zend_string_equals_literal(key, "env")) {
+                continue;
+            }
+            Z_TRY_ADDREF_P(val);
+            zend_hash_update(Z_ARRVAL(payload_array), key, val);
+        } ZEND_HASH_FOREACH_END();
+    }
+
     if (ini && zend_hash_num_elements(ini) > 0) {
         zval ini_zval;
         ZVAL_ARR(&ini_zval, ini);
@@ -494,12 +573,11 @@ static int build_script_payload(smart_str *json_payload, const char *script_name
         zval_ptr_dtor(&env_array);
     }
 
-    if (php_json_encode(json_payload, &payload_array, 0) != SUCCESS) {
+    if (frankenasync_encode_payload(payload, &payload_array) != SUCCESS) {
         zval_ptr_dtor(&payload_array);
         return FAILURE;
     }
 
-    smart_str_0(json_payload);
     zval_ptr_dtor(&payload_array);
 
     return SUCCESS;
@@ -562,10 +640,12 @@ int frankenasync_asyncfuture_minit(void)
     zend_class_entry ce;
 
     /* Register exception hierarchy */
-    INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async\\Future", "Exception", NULL);
+    INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async\\Future", "Exception", asyncfuture_exception_methods);
     asyncfuture_exception_ce = zend_register_internal_class_ex(&ce, zend_ce_exception);
 
     zend_declare_property_null(asyncfuture_exception_ce, "taskId", sizeof("taskId")-1, ZEND_ACC_PROTECTED);
+    zend_declare_property_null(asyncfuture_exception_ce, "errorCode", sizeof("errorCode")-1, ZEND_ACC_PROTECTED);
+    zend_declare_property_null(asyncfuture_exception_ce, "details", sizeof("details")-1, ZEND_ACC_PROTECTED);
 
     INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async\\Future", "FutureTimeoutException", NULL);
     asyncfuture_timeout_ce = zend_register_internal_class_ex(&ce, asyncfuture_exception_ce);
@@ -582,6 +662,11 @@ int frankenasync_asyncfuture_minit(void)
     INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async\\Future", "FuturePanicException", NULL);
     asyncfuture_panic_ce = zend_register_internal_class_ex(&ce, asyncfuture_exception_ce);
 
+    /* Aliases under the names the exceptions were first specified with */
+    zend_register_class_alias("Frankenphp\\Async\\Future\\AsyncTaskNotFoundException", asyncfuture_notfound_ce);
+    zend_register_class_alias("Frankenphp\\Async\\Future\\AsyncTimeoutException", asyncfuture_timeout_ce);
+    zend_register_class_alias("Frankenphp\\Async\\Future\\AsyncCanceledException", asyncfuture_canceled_ce);
+
     /* Register Future class */
     INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async", "Future", asyncfuture_methods);
     asyncfuture_ce = zend_register_internal_class(&ce);
@@ -595,6 +680,7 @@ int frankenasync_asyncfuture_minit(void)
     memcpy(&asyncfuture_object_handlers, zend_get_std_object_handlers(), sizeof(zend_object_handlers));
     asyncfuture_object_handlers.offset = XtOffsetOf(frankenasync_asyncfuture_object, std);
     asyncfuture_object_handlers.free_obj = asyncfuture_free_object;
+    asyncfuture_object_handlers.get_gc = asyncfuture_get_gc;
     asyncfuture_object_handlers.clone_obj = NULL;
 
     return SUCCESS;
@@ -608,6 +694,7 @@ static zend_object *asyncfuture_create_object(zend_class_entry *ce)
     object_properties_init(&intern->std, ce);
 
     intern->task_id = NULL;
+    ZVAL_UNDEF(&intern->callbacks);
     intern->std.handlers = &asyncfuture_object_handlers;
 
     return &intern->std;
@@ -621,9 +708,28 @@ static void asyncfuture_free_object(zend_object *object)
         zend_string_release(intern->task_id);
     }
 
+    zval_ptr_dtor(&intern->callbacks);
+
     zend_object_std_dtor(&intern->std);
 }
 
+/* Exposes the onComplete() callbacks to the cycle collector, since they
+ * often close over the future itself */
+static HashTable *asyncfuture_get_gc(zend_object *object, zval **table, int *n)
+{
+    frankenasync_asyncfuture_object *intern = frankenasync_asyncfuture_from_obj(object);
+
+    if (Z_TYPE(intern->callbacks) == IS_ARRAY) {
+        *table = &intern->callbacks;
+        *n = 1;
+    } else {
+        *table = NULL;
+        *n = 0;
+    }
+
+    return zend_std_get_properties(object);
+}
+
 PHP_METHOD(Async_Future, __construct)
 {
     zend_string *task_id;
@@ -668,42 +774,58 @@ PHP_METHOD(Async_Future, await)
         RETURN_THROWS();
     }
 
-    struct go_asynctask_await_return result = go_asynctask_await(
+    struct go_asynctask_result_length_return length = go_asynctask_result_length(
         frankenphp_thread_index(),
         ZSTR_VAL(intern->task_id),
         timeout_ms
     );
 
-    if (UNEXPECTED(!result.r1)) {
-        asyncfuture_throw_exception(result.r0);
-        free(result.r0);
+    if (UNEXPECTED(!length.r2)) {
+        asyncfuture_throw_exception(length.r1);
+        go_free_result(length.r1);
+        asyncfuture_complete_with_exception(ZEND_THIS);
         RETURN_THROWS();
     }
 
-    if (UNEXPECTED(result.r0 == NULL)) {
-        RETURN_NULL();
-    }
+    /* Copy the result in chunks straight into a PHP string, so very large
+     * results never need a second full-size allocation on the C heap */
+    zend_string *data = zend_string_alloc((size_t) length.r0, 0);
+    size_t offset = 0;
 
-    zval decoded_result;
-    ZVAL_UNDEF(&decoded_result);
+    while (offset < (size_t) length.r0) {
+        size_t chunk = MIN((size_t) length.r0 - offset, FRANKENASYNC_RESULT_CHUNK_SIZE);
 
-    zend_try {
-        php_json_decode_ex(&decoded_result, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH);
+        struct go_asynctask_result_read_return read = go_asynctask_result_read(
+            frankenphp_thread_index(),
+            ZSTR_VAL(intern->task_id),
+            (long long) offset,
+            ZSTR_VAL(data) + offset,
+            chunk
+        );
 
-        if (EXPECTED(Z_TYPE(decoded_result) == IS_ARRAY)) {
-            free(result.r0);
-            RETURN_ZVAL(&decoded_result, 1, 1);
+        if (UNEXPECTED(!read.r2 || read.r0 == 0)) {
+            zend_string_efree(data);
+            if (read.r1) {
+                asyncfuture_throw_exception(read.r1);
+                go_free_result(read.r1);
+            } else {
+                frankenasync_throw_error("Short read of task result");
+            }
+            RETURN_THROWS();
         }
 
-        RETVAL_STRING(result.r0);
-        free(result.r0);
-        zval_ptr_dtor(&decoded_result);
+        offset += read.r0;
+    }
 
-    } zend_catch {
-        free(result.r0);
-        zend_bailout();
+    ZSTR_VAL(data)[offset] = '\0';
+
+    frankenasync_decode_result_str(return_value, data);
+
+    asyncfuture_complete(ZEND_THIS, return_value, NULL);
+    if (UNEXPECTED(EG(exception))) {
+        zval_ptr_dtor(return_value);
         RETURN_THROWS();
-    } zend_end_try();
+    }
 }
 
 PHP_METHOD(Async_Future, awaitAll)
@@ -727,11 +849,14 @@ PHP_METHOD(Async_Future, awaitAll)
         return;
     }
 
+    /* Task IDs go to Go under the caller's keys, and results come back under them */
     zval task_ids_array;
     array_init(&task_ids_array);
 
+    zend_string *key;
+    zend_ulong index;
     zval *task_obj;
-    ZEND_HASH_FOREACH_VAL(tasks_ht, task_obj) {
+    ZEND_HASH_FOREACH_KEY_VAL(tasks_ht, index, key, task_obj) {
         if (UNEXPECTED(Z_TYPE_P(task_obj) != IS_OBJECT ||
             !instanceof_function(Z_OBJCE_P(task_obj), asyncfuture_ce))) {
             zval_ptr_dtor(&task_ids_array);
@@ -746,11 +871,17 @@ PHP_METHOD(Async_Future, awaitAll)
             RETURN_THROWS();
         }
 
-        add_next_index_str(&task_ids_array, zend_string_copy(intern->task_id));
+        zval task_id;
+        ZVAL_STR_COPY(&task_id, intern->task_id);
+        if (key) {
+            zend_hash_update(Z_ARRVAL(task_ids_array), key, &task_id);
+        } else {
+            zend_hash_index_update(Z_ARRVAL(task_ids_array), index, &task_id);
+        }
     } ZEND_HASH_FOREACH_END();
 
     smart_str json_task_ids = {0};
-    php_json_encode(&json_task_ids, &task_ids_array, PHP_JSON_THROW_ON_ERROR);
+    php_json_encode(&json_task_ids, &task_ids_array, PHP_JSON_FORCE_OBJECT | PHP_JSON_THROW_ON_ERROR);
     smart_str_0(&json_task_ids);
 
     zval_ptr_dtor(&task_ids_array);
@@ -763,9 +894,9 @@ PHP_METHOD(Async_Future, awaitAll)
 
     smart_str_free(&json_task_ids);
 
-    if (UNEXPECTED(!result.r1)) {
+    if (UNEXPECTED(!result.r2)) {
         asyncfuture_throw_exception(result.r0);
-        free(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
     }
 
@@ -773,29 +904,58 @@ PHP_METHOD(Async_Future, awaitAll)
         RETURN_NULL();
     }
 
-    zval decoded_result;
-    ZVAL_UNDEF(&decoded_result);
+    zval decoded;
+    ZVAL_UNDEF(&decoded);
 
     zend_try {
-        php_json_decode_ex(&decoded_result, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH);
-
-        if (EXPECTED(Z_TYPE(decoded_result) == IS_ARRAY)) {
-            free(result.r0);
-            RETURN_ZVAL(&decoded_result, 1, 1);
-        }
-
-        RETVAL_STRING(result.r0);
-        free(result.r0);
-        zval_ptr_dtor(&decoded_result);
-
+        frankenasync_decode_result(&decoded, result.r0, result.r1);
+        go_free_result(result.r0);
     } zend_catch {
-        free(result.r0);
+        go_free_result(result.r0);
         zend_bailout();
         RETURN_THROWS();
     } zend_end_try();
+
+    if (UNEXPECTED(Z_TYPE(decoded) != IS_ARRAY)) {
+        zval_ptr_dtor(&decoded);
+        frankenasync_throw_error("Failed to decode task results");
+        RETURN_THROWS();
+    }
+
+    /* Put the results in the order of the futures */
+    array_init_size(return_value, task_count);
+
+    ZEND_HASH_FOREACH_KEY_VAL(tasks_ht, index, key, task_obj) {
+        zval *task_result = asyncfuture_keyed_find(Z_ARRVAL(decoded), key, index);
+        zval value;
+
+        if (task_result) {
+            ZVAL_COPY(&value, task_result);
+        } else {
+            ZVAL_NULL(&value);
+        }
+
+        if (key) {
+            zend_hash_update(Z_ARRVAL_P(return_value), key, &value);
+        } else {
+            zend_hash_index_update(Z_ARRVAL_P(return_value), index, &value);
+        }
+    } ZEND_HASH_FOREACH_END();
+
+    zval_ptr_dtor(&decoded);
+
+    ZEND_HASH_FOREACH_KEY_VAL(tasks_ht, index, key, task_obj) {
+        asyncfuture_complete(task_obj, asyncfuture_keyed_find(Z_ARRVAL_P(return_value), key, index), NULL);
+        if (UNEXPECTED(EG(exception))) {
+            zval_ptr_dtor(return_value);
+            RETURN_THROWS();
+        }
+    } ZEND_HASH_FOREACH_END();
 }
 
-PHP_METHOD(Async_Future, awaitAny)
+/* Shared implementation of awaitAny() and awaitAnyIndex(). With with_key set,
+ * the result is wrapped as ['key' => ..., 'id' => ..., 'result' => ...]. */
+static void asyncfuture_await_any(INTERNAL_FUNCTION_PARAMETERS, zend_bool with_key)
 {
     zval *tasks_array;
     zval *timeout_param = NULL;
@@ -851,9 +1011,9 @@ PHP_METHOD(Async_Future, awaitAny)
 
     smart_str_free(&json_task_ids);
 
-    if (UNEXPECTED(!result.r1)) {
+    if (UNEXPECTED(!result.r3)) {
         asyncfuture_throw_exception(result.r0);
-        free(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
     }
 
@@ -861,26 +1021,241 @@ PHP_METHOD(Async_Future, awaitAny)
         RETURN_NULL();
     }
 
-    zval decoded_result;
-    ZVAL_UNDEF(&decoded_result);
+    zval decoded;
+    ZVAL_UNDEF(&decoded);
 
     zend_try {
-        php_json_decode_ex(&decoded_result, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH);
+        frankenasync_decode_result(&decoded, result.r0, result.r1);
+        go_free_result(result.r0);
+    } zend_catch {
+        go_free_result(result.r0);
+        zend_bailout();
+        RETURN_THROWS();
+    } zend_end_try();
+
+    if (!with_key) {
+        RETURN_COPY_VALUE(&decoded);
+    }
+
+    /* Map the winner's position back to the caller's array key */
+    zend_long position = 0;
+    zend_string *key;
+    zend_ulong index;
 
-        if (EXPECTED(Z_TYPE(decoded_result) == IS_ARRAY)) {
-            free(result.r0);
-            RETURN_ZVAL(&decoded_result, 1, 1);
+    array_init(return_value);
+
+    ZEND_HASH_FOREACH_KEY_VAL(tasks_ht, index, key, task_obj) {
+        if (position++ != (zend_long) result.r2) {
+            continue;
         }
 
-        RETVAL_STRING(result.r0);
-        free(result.r0);
-        zval_ptr_dtor(&decoded_result);
+        if (key) {
+            add_assoc_str(return_value, "key", zend_string_copy(key));
+        } else {
+            add_assoc_long(return_value, "key", (zend_long) index);
+        }
 
-    } zend_catch {
-        free(result.r0);
-        zend_bailout();
+        frankenasync_asyncfuture_object *intern = Z_FRANKENASYNC_ASYNCFUTURE_OBJ_P(task_obj);
+        add_assoc_str(return_value, "id", zend_string_copy(intern->task_id));
+        break;
+    } ZEND_HASH_FOREACH_END();
+
+    add_assoc_zval(return_value, "result", &decoded);
+}
+
+PHP_METHOD(Async_Future, awaitAny)
+{
+    asyncfuture_await_any(INTERNAL_FUNCTION_PARAM_PASSTHRU, 0);
+}
+
+PHP_METHOD(Async_Future, awaitAnyIndex)
+{
+    asyncfuture_await_any(INTERNAL_FUNCTION_PARAM_PASSTHRU, 1);
+}
+
+PHP_METHOD(Async_Future, list)
+{
+    HashTable *statuses = NULL;
+    HashTable *labels = NULL;
+
+    ZEND_PARSE_PARAMETERS_START(0, 2)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ARRAY_HT(statuses)
+        Z_PARAM_ARRAY_HT(labels)
+    ZEND_PARSE_PARAMETERS_END();
+
+    /* Build the filter, statuses given as strings or Status cases */
+    zval filter, filter_statuses, filter_labels;
+    array_init(&filter);
+    array_init(&filter_statuses);
+    array_init(&filter_labels);
+
+    if (statuses) {
+        zval *status;
+        ZEND_HASH_FOREACH_VAL(statuses, status) {
+            if (Z_TYPE_P(status) == IS_OBJECT && instanceof_function(Z_OBJCE_P(status), asyncfuture_status_ce)) {
+                status = zend_enum_fetch_case_value(Z_OBJ_P(status));
+            }
+            if (UNEXPECTED(Z_TYPE_P(status) != IS_STRING)) {
+                zval_ptr_dtor(&filter);
+                zval_ptr_dtor(&filter_statuses);
+                zval_ptr_dtor(&filter_labels);
+                zend_argument_type_error(1, "must contain only strings or Frankenphp\\Async\\Future\\Status cases");
+                RETURN_THROWS();
+            }
+            add_next_index_str(&filter_statuses, zend_string_copy(Z_STR_P(status)));
+        } ZEND_HASH_FOREACH_END();
+    }
+
+    if (labels) {
+        zend_string *key;
+        zval *value;
+        ZEND_HASH_FOREACH_STR_KEY_VAL(labels, key, value) {
+            if (UNEXPECTED(!key || Z_TYPE_P(value) != IS_STRING)) {
+                zval_ptr_dtor(&filter);
+                zval_ptr_dtor(&filter_statuses);
+                zval_ptr_dtor(&filter_labels);
+                zend_argument_type_error(2, "must map label names to strings");
+                RETURN_THROWS();
+            }
+            Z_ADDREF_P(value);
+            zend_hash_update(Z_ARRVAL(filter_labels), key, value);
+        } ZEND_HASH_FOREACH_END();
+    }
+
+    /* An empty array would encode as a list, so labels are left out then */
+    add_assoc_zval(&filter, "statuses", &filter_statuses);
+    if (zend_hash_num_elements(Z_ARRVAL(filter_labels)) > 0) {
+        add_assoc_zval(&filter, "labels", &filter_labels);
+    } else {
+        zval_ptr_dtor(&filter_labels);
+    }
+
+    smart_str json_filter = {0};
+    php_json_encode(&json_filter, &filter, 0);
+    smart_str_0(&json_filter);
+
+    zval_ptr_dtor(&filter);
+
+    struct go_asynctask_list_return result = go_asynctask_list(
+        frankenphp_thread_index(),
+        ZSTR_VAL(json_filter.s)
+    );
+
+    smart_str_free(&json_filter);
+
+    if (UNEXPECTED(!result.r1)) {
+        asyncfuture_throw_exception(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
-    } zend_end_try();
+    }
+
+    if (UNEXPECTED(php_json_decode_ex(return_value, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) != SUCCESS)) {
+        go_free_result(result.r0);
+        frankenasync_throw_error("Failed to decode task list");
+        RETURN_THROWS();
+    }
+
+    go_free_result(result.r0);
+
+    /* Statuses as Status cases, as getStatus() returns them */
+    zval *task;
+    ZEND_HASH_FOREACH_VAL(Z_ARRVAL_P(return_value), task) {
+        zval *status = zend_hash_str_find(Z_ARRVAL_P(task), "status", sizeof("status") - 1);
+        if (UNEXPECTED(!status)) {
+            continue;
+        }
+
+        zval status_case;
+        ZVAL_UNDEF(&status_case);
+        zend_call_method(NULL, asyncfuture_status_ce, NULL, "from", sizeof("from") - 1, &status_case, 1, status, NULL);
+        if (UNEXPECTED(EG(exception))) {
+            zval_ptr_dtor(return_value);
+            RETURN_THROWS();
+        }
+
+        zval_ptr_dtor(status);
+        ZVAL_COPY_VALUE(status, &status_case);
+    } ZEND_HASH_FOREACH_END();
+}
+
+PHP_METHOD(Async_Future, getStats)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    struct go_asynctask_stats_return result = go_asynctask_stats(frankenphp_thread_index());
+
+    if (UNEXPECTED(!result.r1)) {
+        asyncfuture_throw_exception(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+
+    if (UNEXPECTED(php_json_decode_ex(return_value, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) != SUCCESS)) {
+        go_free_result(result.r0);
+        frankenasync_throw_error("Failed to decode task manager stats");
+        RETURN_THROWS();
+    }
+
+    go_free_result(result.r0);
+}
+
+PHP_METHOD(Async_Future, prune)
+{
+    zval *ttl_param = NULL;
+
+    ZEND_PARSE_PARAMETERS_START(0, 1)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ZVAL(ttl_param)
+    ZEND_PARSE_PARAMETERS_END();
+
+    PARSE_TIMEOUT_PARAM(ttl_param)
+
+    struct go_asynctask_prune_return result = go_asynctask_prune(
+        frankenphp_thread_index(),
+        timeout_ms
+    );
+
+    if (UNEXPECTED(!result.r2)) {
+        asyncfuture_throw_exception(result.r1);
+        go_free_result(result.r1);
+        RETURN_THROWS();
+    }
+
+    RETURN_LONG((zend_long) result.r0);
+}
+
+PHP_METHOD(Async_Future, setShutdownPolicy)
+{
+    zend_string *policy;
+    zval *timeout_param = NULL;
+
+    ZEND_PARSE_PARAMETERS_START(1, 2)
+        Z_PARAM_STR(policy)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ZVAL(timeout_param)
+    ZEND_PARSE_PARAMETERS_END();
+
+    PARSE_TIMEOUT_PARAM(timeout_param)
+
+    struct go_asynctask_set_shutdown_policy_return result = go_asynctask_set_shutdown_policy(
+        frankenphp_thread_index(),
+        ZSTR_VAL(policy),
+        timeout_ms
+    );
+
+    if (UNEXPECTED(!result.r1)) {
+        asyncfuture_throw_exception(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+}
+
+PHP_METHOD(Async_Future, heartbeat)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    go_asynctask_heartbeat(frankenphp_thread_index());
 }
 
 PHP_METHOD(Async_Future, cancel)
@@ -901,15 +1276,17 @@ PHP_METHOD(Async_Future, cancel)
 
     if (UNEXPECTED(!result.r1)) {
         asyncfuture_throw_exception(result.r0);
-        free(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
     }
 
-    if (EXPECTED(result.r0 != NULL)) {
-        free(result.r0);
+    /* A result means the task had already finished, keeping its result */
+    if (UNEXPECTED(result.r0 != NULL)) {
+        go_free_result(result.r0);
+        RETURN_FALSE;
     }
 
-    RETURN_BOOL(1);
+    RETURN_TRUE;
 }
 
 PHP_METHOD(Async_Future, getStatus)
@@ -930,7 +1307,7 @@ PHP_METHOD(Async_Future, getStatus)
 
     if (UNEXPECTED(!result.r1)) {
         asyncfuture_throw_exception(result.r0);
-        free(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
     }
 
@@ -954,7 +1331,7 @@ PHP_METHOD(Async_Future, getStatus)
             }
             zval_ptr_dtor(&decoded_result);
         }
-        free(result.r0);
+        go_free_result(result.r0);
     }
 
     /* Call Status::from($status_str) to get enum object */
@@ -1005,7 +1382,7 @@ PHP_METHOD(Async_Future, getDuration)
 
     if (UNEXPECTED(!result.r1)) {
         asyncfuture_throw_exception(result.r0);
-        free(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
     }
 
@@ -1018,11 +1395,11 @@ PHP_METHOD(Async_Future, getDuration)
 
     if (UNEXPECTED(php_json_decode_ex(&decoded_result, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) != SUCCESS)) {
         frankenasync_throw_error("Failed to decode task info");
-        free(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
     }
 
-    free(result.r0);
+    go_free_result(result.r0);
 
     if (EXPECTED(Z_TYPE(decoded_result) == IS_ARRAY)) {
         zval *duration_val = zend_hash_str_find(Z_ARRVAL(decoded_result), "duration", sizeof("duration") - 1);
@@ -1061,7 +1438,7 @@ PHP_METHOD(Async_Future, getError)
 
     if (UNEXPECTED(!result.r1)) {
         asyncfuture_throw_exception(result.r0);
-        free(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
     }
 
@@ -1074,11 +1451,11 @@ PHP_METHOD(Async_Future, getError)
 
     if (UNEXPECTED(php_json_decode_ex(&decoded_result, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) != SUCCESS)) {
         frankenasync_throw_error("Failed to decode task info");
-        free(result.r0);
+        go_free_result(result.r0);
         RETURN_THROWS();
     }
 
-    free(result.r0);
+    go_free_result(result.r0);
 
     if (EXPECTED(Z_TYPE(decoded_result) == IS_ARRAY)) {
         zval *error_val = zend_hash_str_find(Z_ARRVAL(decoded_result), "error", sizeof("error") - 1);
@@ -1093,6 +1470,128 @@ PHP_METHOD(Async_Future, getError)
     RETURN_NULL();
 }
 
+PHP_METHOD(Async_Future, getErrorInfo)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    frankenasync_asyncfuture_object *intern = Z_FRANKENASYNC_ASYNCFUTURE_OBJ_P(ZEND_THIS);
+
+    if (UNEXPECTED(!intern->task_id)) {
+        frankenasync_throw_error("Task ID not set");
+        RETURN_THROWS();
+    }
+
+    struct go_asynctask_error_return result = go_asynctask_error(
+        frankenphp_thread_index(),
+        ZSTR_VAL(intern->task_id)
+    );
+
+    if (UNEXPECTED(!result.r1)) {
+        asyncfuture_throw_exception(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+
+    if (result.r0 == NULL) {
+        RETURN_NULL();
+    }
+
+    if (UNEXPECTED(php_json_decode_ex(return_value, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) != SUCCESS)) {
+        go_free_result(result.r0);
+        frankenasync_throw_error("Failed to decode task error");
+        RETURN_THROWS();
+    }
+
+    go_free_result(result.r0);
+}
+
+PHP_METHOD(Async_Future, getInfo)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    frankenasync_asyncfuture_object *intern = Z_FRANKENASYNC_ASYNCFUTURE_OBJ_P(ZEND_THIS);
+
+    if (UNEXPECTED(!intern->task_id)) {
+        frankenasync_throw_error("Task ID not set");
+        RETURN_THROWS();
+    }
+
+    struct go_asynctask_info_return result = go_asynctask_info(
+        frankenphp_thread_index(),
+        ZSTR_VAL(intern->task_id)
+    );
+
+    if (UNEXPECTED(!result.r1)) {
+        asyncfuture_throw_exception(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+
+    if (result.r0 == NULL) {
+        RETURN_NULL();
+    }
+
+    if (UNEXPECTED(php_json_decode_ex(return_value, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) != SUCCESS)) {
+        go_free_result(result.r0);
+        frankenasync_throw_error("Failed to decode task info");
+        RETURN_THROWS();
+    }
+
+    go_free_result(result.r0);
+}
+
+PHP_METHOD(Async_Future, onComplete)
+{
+    zend_fcall_info fci;
+    zend_fcall_info_cache fcc;
+
+    ZEND_PARSE_PARAMETERS_START(1, 1)
+        Z_PARAM_FUNC(fci, fcc)
+    ZEND_PARSE_PARAMETERS_END();
+
+    frankenasync_asyncfuture_object *intern = Z_FRANKENASYNC_ASYNCFUTURE_OBJ_P(ZEND_THIS);
+
+    if (Z_TYPE(intern->callbacks) != IS_ARRAY) {
+        array_init(&intern->callbacks);
+    }
+
+    Z_TRY_ADDREF(fci.function_name);
+    add_next_index_zval(&intern->callbacks, &fci.function_name);
+
+    RETURN_OBJ_COPY(Z_OBJ_P(ZEND_THIS));
+}
+
+PHP_METHOD(Async_Future, getLogs)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    frankenasync_asyncfuture_object *intern = Z_FRANKENASYNC_ASYNCFUTURE_OBJ_P(ZEND_THIS);
+
+    if (UNEXPECTED(!intern->task_id)) {
+        frankenasync_throw_error("Task ID not set");
+        RETURN_THROWS();
+    }
+
+    struct go_asynctask_logs_return result = go_asynctask_logs(
+        frankenphp_thread_index(),
+        ZSTR_VAL(intern->task_id)
+    );
+
+    if (UNEXPECTED(!result.r1)) {
+        asyncfuture_throw_exception(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+
+    if (UNEXPECTED(php_json_decode_ex(return_value, result.r0, strlen(result.r0), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) != SUCCESS)) {
+        go_free_result(result.r0);
+        frankenasync_throw_error("Failed to decode task logs");
+        RETURN_THROWS();
+    }
+
+    go_free_result(result.r0);
+}
+
 PHP_METHOD(Async_Future_Status, __toString)
 {
     ZEND_PARSE_PARAMETERS_NONE();
@@ -1125,10 +1624,63 @@ static const zend_function_entry asyncfuture_methods[] = {
     PHP_ME(Async_Future, await, arginfo_asyncfuture_await, ZEND_ACC_PUBLIC)
     PHP_ME(Async_Future, awaitAll, arginfo_asyncfuture_awaitAll, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
     PHP_ME(Async_Future, awaitAny, arginfo_asyncfuture_awaitAny, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_ME(Async_Future, awaitAnyIndex, arginfo_asyncfuture_awaitAnyIndex, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_ME(Async_Future, list, arginfo_asyncfuture_list, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_ME(Async_Future, getStats, arginfo_asyncfuture_getStats, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_ME(Async_Future, prune, arginfo_asyncfuture_prune, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_ME(Async_Future, setShutdownPolicy, arginfo_asyncfuture_setShutdownPolicy, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_ME(Async_Future, heartbeat, arginfo_asyncfuture_heartbeat, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
     PHP_ME(Async_Future, cancel, arginfo_asyncfuture_cancel, ZEND_ACC_PUBLIC)
     PHP_ME(Async_Future, getStatus, arginfo_asyncfuture_getStatus, ZEND_ACC_PUBLIC)
     PHP_ME(Async_Future, getDuration, arginfo_asyncfuture_getDuration, ZEND_ACC_PUBLIC)
     PHP_ME(Async_Future, getError, arginfo_asyncfuture_getError, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Future, getErrorInfo, arginfo_asyncfuture_getErrorInfo, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Future, getInfo, arginfo_asyncfuture_getInfo, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Future, onComplete, arginfo_asyncfuture_onComplete, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Future, getLogs, arginfo_asyncfuture_getLogs, ZEND_ACC_PUBLIC)
+    PHP_FE_END
+};
+
+PHP_METHOD(Async_Future_Exception, getTaskId)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    zval *value = zend_read_property(asyncfuture_exception_ce, Z_OBJ_P(ZEND_THIS), "taskId", sizeof("taskId") - 1, 1, NULL);
+    if (value && Z_TYPE_P(value) == IS_STRING) {
+        RETURN_STR_COPY(Z_STR_P(value));
+    }
+
+    RETURN_NULL();
+}
+
+PHP_METHOD(Async_Future_Exception, getErrorCode)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    zval *value = zend_read_property(asyncfuture_exception_ce, Z_OBJ_P(ZEND_THIS), "errorCode", sizeof("errorCode") - 1, 1, NULL);
+    if (value && Z_TYPE_P(value) == IS_STRING) {
+        RETURN_STR_COPY(Z_STR_P(value));
+    }
+
+    RETURN_NULL();
+}
+
+PHP_METHOD(Async_Future_Exception, getDetails)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    zval *value = zend_read_property(asyncfuture_exception_ce, Z_OBJ_P(ZEND_THIS), "details", sizeof("details") - 1, 1, NULL);
+    if (value && Z_TYPE_P(value) == IS_ARRAY) {
+        RETURN_COPY(value);
+    }
+
+    array_init(return_value);
+}
+
+static const zend_function_entry asyncfuture_exception_methods[] = {
+    PHP_ME(Async_Future_Exception, getTaskId, arginfo_asyncfuture_exception_getTaskId, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Future_Exception, getErrorCode, arginfo_asyncfuture_exception_getErrorCode, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Future_Exception, getDetails, arginfo_asyncfuture_exception_getDetails, ZEND_ACC_PUBLIC)
     PHP_FE_END
 };
 
@@ -1152,41 +1704,171 @@ void frankenasync_create_asyncfuture_object(zval *return_value, const char *task
     intern->task_id = zend_string_init(task_id, strlen(task_id), 0);
 }
 
+zend_class_entry *frankenasync_asyncfuture_class_entry(void)
+{
+    return asyncfuture_ce;
+}
+
+/* Finds the element of ht under a key of the caller's array. Decoded
+ * objects may hold integer keys as strings. */
+static zval *asyncfuture_keyed_find(HashTable *ht, zend_string *key, zend_ulong index)
+{
+    if (key) {
+        return zend_symtable_find(ht, key);
+    }
+
+    zval *found = zend_hash_index_find(ht, index);
+    if (!found) {
+        char buf[MAX_LENGTH_OF_LONG + 1];
+        int len = snprintf(buf, sizeof(buf), ZEND_ULONG_FMT, index);
+        found = zend_hash_str_find(ht, buf, len);
+    }
+
+    return found;
+}
+
+/* Runs the onComplete() callbacks of a future, once, with its result or
+ * the exception its task ended with. Callbacks stop at the first that
+ * throws. */
+static void asyncfuture_complete(zval *future, zval *result, zend_object *error)
+{
+    frankenasync_asyncfuture_object *intern = Z_FRANKENASYNC_ASYNCFUTURE_OBJ_P(future);
+
+    if (EXPECTED(Z_TYPE(intern->callbacks) != IS_ARRAY)) {
+        return;
+    }
+
+    zval callbacks;
+    ZVAL_COPY_VALUE(&callbacks, &intern->callbacks);
+    ZVAL_UNDEF(&intern->callbacks);
+
+    zval args[3];
+    ZVAL_COPY_VALUE(&args[0], future);
+    if (result) {
+        ZVAL_COPY_VALUE(&args[1], result);
+    } else {
+        ZVAL_NULL(&args[1]);
+    }
+    if (error) {
+        ZVAL_OBJ(&args[2], error);
+    } else {
+        ZVAL_NULL(&args[2]);
+    }
+
+    zval *callback;
+    ZEND_HASH_FOREACH_VAL(Z_ARRVAL(callbacks), callback) {
+        zval retval;
+        ZVAL_UNDEF(&retval);
+
+        call_user_function(NULL, NULL, callback, &retval, 3, args);
+        zval_ptr_dtor(&retval);
+
+        if (UNEXPECTED(EG(exception))) {
+            break;
+        }
+    } ZEND_HASH_FOREACH_END();
+
+    zval_ptr_dtor(&callbacks);
+}
+
+/* Runs the onComplete() callbacks of a future with the exception just
+ * thrown for its task, then throws it again. Timeouts and other failures
+ * that don't end the task leave the callbacks waiting. An exception thrown
+ * by a callback replaces the task's. */
+static void asyncfuture_complete_with_exception(zval *future)
+{
+    zend_object *error = EG(exception);
+
+    if (!error ||
+        !instanceof_function(error->ce, asyncfuture_exception_ce) ||
+        instanceof_function(error->ce, asyncfuture_timeout_ce) ||
+        instanceof_function(error->ce, asyncfuture_notfound_ce) ||
+        Z_TYPE(Z_FRANKENASYNC_ASYNCFUTURE_OBJ_P(future)->callbacks) != IS_ARRAY) {
+        return;
+    }
+
+    GC_ADDREF(error);
+    zend_clear_exception();
+
+    asyncfuture_complete(future, NULL, error);
+
+    if (UNEXPECTED(EG(exception))) {
+        OBJ_RELEASE(error);
+        return;
+    }
+
+    zval rethrow;
+    ZVAL_OBJ(&rethrow, error);
+    zend_throw_exception_object(&rethrow);
+}
+
+static zend_class_entry *asyncfuture_exception_ce_for_code(const char *code) {
+    if (strcmp(code, "TIMEOUT") == 0) {
+        return asyncfuture_timeout_ce;
+    } else if (strcmp(code, "TASK_NOT_FOUND") == 0) {
+        return asyncfuture_notfound_ce;
+    } else if (strcmp(code, "CANCELED") == 0) {
+        return asyncfuture_canceled_ce;
+    } else if (strcmp(code, "PANICKED") == 0) {
+        return asyncfuture_panic_ce;
+    } else if (strcmp(code, "FAILED") == 0) {
+        return asyncfuture_failed_ce;
+    }
+
+    return asyncfuture_exception_ce;
+}
+
+/**
+ * Throws the typed exception described by a Go error envelope:
+ * {"code": "...", "message": "...", "details": {"task_id": "..."}}
+ */
 static inline void asyncfuture_throw_exception(const char *error_msg) {
     zend_class_entry *exception_ce = asyncfuture_exception_ce;
-    const char *task_id_str = NULL;
-
-    /* Extract task ID if present */
-    if (EXPECTED(strncmp(error_msg, "task ", 5) == 0 && strlen(error_msg) > 26 && error_msg[25] == ':')) {
-        task_id_str = error_msg + 5;
-    }
-
-    /* Determine exception type */
-    if (strstr(error_msg, "task timed out")) {
-        exception_ce = asyncfuture_timeout_ce;
-    } else if (strstr(error_msg, "task not found")) {
-        exception_ce = asyncfuture_notfound_ce;
-    } else if (strstr(error_msg, "task canceled")) {
-        exception_ce = asyncfuture_canceled_ce;
-    } else if (strstr(error_msg, "task panicked")) {
-        exception_ce = asyncfuture_panic_ce;
-    } else if (strstr(error_msg, "task failed")) {
-        exception_ce = asyncfuture_failed_ce;
-    }
-
-    zend_throw_exception(exception_ce, error_msg, 0);
-
-    /* Set taskId property if extracted */
-    if (EXPECTED(EG(exception) && task_id_str != NULL)) {
-        zval task_id_zval;
-        ZVAL_STRINGL(&task_id_zval, task_id_str, 20);
-        zend_update_property(
-            exception_ce,
-            EG(exception),
-            "taskId",
-            sizeof("taskId")-1,
-            &task_id_zval
-        );
-        zval_ptr_dtor(&task_id_zval);
+
+    if (UNEXPECTED(error_msg == NULL)) {
+        frankenasync_throw_error("Unknown internal error in runtime");
+        return;
+    }
+
+    zval envelope;
+    ZVAL_UNDEF(&envelope);
+
+    if (UNEXPECTED(php_json_decode_ex(&envelope, error_msg, strlen(error_msg), PHP_JSON_OBJECT_AS_ARRAY, FRANKENASYNC_JSON_DEPTH) != SUCCESS ||
+        Z_TYPE(envelope) != IS_ARRAY)) {
+        zval_ptr_dtor(&envelope);
+        zend_throw_exception(exception_ce, error_msg, 0);
+        return;
+    }
+
+    zval *code = zend_hash_str_find(Z_ARRVAL(envelope), "code", sizeof("code") - 1);
+    zval *message = zend_hash_str_find(Z_ARRVAL(envelope), "message", sizeof("message") - 1);
+    zval *details = zend_hash_str_find(Z_ARRVAL(envelope), "details", sizeof("details") - 1);
+
+    if (EXPECTED(code && Z_TYPE_P(code) == IS_STRING)) {
+        exception_ce = asyncfuture_exception_ce_for_code(Z_STRVAL_P(code));
+    }
+
+    zend_object *exception = zend_throw_exception(exception_ce,
+        (message && Z_TYPE_P(message) == IS_STRING) ? Z_STRVAL_P(message) : error_msg, 0);
+
+    if (EXPECTED(exception != NULL)) {
+        if (EXPECTED(code && Z_TYPE_P(code) == IS_STRING)) {
+            zend_update_property(exception_ce, exception, "errorCode", sizeof("errorCode") - 1, code);
+        }
+
+        if (details && Z_TYPE_P(details) == IS_ARRAY) {
+            zend_update_property(exception_ce, exception, "details", sizeof("details") - 1, details);
+
+            zval *task_id = zend_hash_str_find(Z_ARRVAL_P(details), "task_id", sizeof("task_id") - 1);
+            if (task_id && Z_TYPE_P(task_id) == IS_STRING) {
+                zend_update_property(exception_ce, exception, "taskId", sizeof("taskId") - 1, task_id);
+            }
+        }
     }
+
+    zval_ptr_dtor(&envelope);
+}
+
+void frankenasync_throw_bridge_error(const char *payload) {
+    asyncfuture_throw_exception(payload);
 }
@@ -14,19 +14,43 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unsafe"
 
 	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/kvstore"
 
 	"github.com/dunglas/frankenphp"
-
-	"github.com/rs/xid"
 )
 
 // DocumentRoot is set by the application to pass to subrequests.
 var DocumentRoot string
 
+// MaxDepth limits how deeply subrequests may nest (parent → child → grandchild).
+// Zero disables the limit.
+var MaxDepth = 8
+
+// SessionCookieName is the cookie stripped from subrequests that opt out of
+// session forwarding. It should match PHP's session.name.
+var SessionCookieName = "PHPSESSID"
+
+// ScriptRunnable is the name script tasks are registered under with
+// asynctask.RegisterRunnable.
+const ScriptRunnable = "frankenasync.script"
+
+func init() {
+	asynctask.RegisterRunnable(ScriptRunnable, newScriptRunnable)
+}
+
+var (
+	ErrMaxDepthExceeded = errors.New("subrequest depth limit exceeded")
+	ErrSubrequestLoop   = errors.New("subrequest loop detected")
+)
+
 // Register hooks our PHP module into FrankenPHP's extension loading.
 func Register() {
 	C.frankenasync_register()
@@ -36,6 +60,22 @@ func Register() {
 type scriptRequest struct {
 	Name string     `json:"name"`
 	Env  *scriptEnv `json:"env,omitempty"`
+
+	// Parent request state forwarded to the subrequest. Cookies and the
+	// session are forwarded unless explicitly disabled; HeaderAllow, when
+	// set, restricts the cloned headers to the listed names.
+	ForwardCookies *bool    `json:"forward_cookies,omitempty"`
+	ForwardSession *bool    `json:"forward_session,omitempty"`
+	HeaderAllow    []string `json:"header_allow,omitempty"`
+	HeaderDeny     []string `json:"header_deny,omitempty"`
+
+	// Pool names the worker pool the script runs in, from the pools
+	// setting. Empty uses the default pool.
+	Pool string `json:"pool,omitempty"`
+
+	// SideEffects keeps the script running when the client disconnects,
+	// see asynctask.WithSideEffects.
+	SideEffects bool `json:"side_effects,omitempty"`
 }
 
 type scriptEnv struct {
@@ -84,15 +124,47 @@ func (r *responseRecorder) WriteHeader(code int) {
 
 // executeScript runs a PHP script as a subrequest via FrankenPHP.
 func executeScript(ctx context.Context, sr *scriptRequest) (*scriptResult, error) {
-	start := time.Now()
-
 	thread, ok := frankenphp.Thread(threadIndexFromContext(ctx))
 	if !ok || thread.IsRequestDone() {
-		return nil, fmt.Errorf("thread not available")
+		return nil, errThreadUnavailable
+	}
+
+	return runSubrequest(ctx, thread.Request, sr)
+}
+
+// RunScript runs a PHP script below DocumentRoot outside of any PHP request,
+// for callers such as the gRPC API. args are passed as APP_* server
+// variables. The script gets its own request-scoped store and the task
+// manager of ctx, if any, for the tasks it starts.
+//
+// The result is the script response as task results store it: JSON text, or
+// a struct with the other encodings.
+func RunScript(ctx context.Context, name string, args map[string]any) (any, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return nil, fmt.Errorf("script '%s' is outside the document root", name)
 	}
 
+	// An empty chain makes the script itself the top-level request
+	ctx = withSubrequestChain(ctx, []subrequestCall{})
+	ctx = kvstore.WithContext(ctx, kvstore.New())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := runSubrequest(ctx, req, &scriptRequest{Name: name, Env: &scriptEnv{App: args}})
+	if err != nil {
+		return nil, err
+	}
+	return taskResult(result)
+}
+
+// runSubrequest runs a PHP script as a subrequest of origReq.
+func runSubrequest(ctx context.Context, origReq *http.Request, sr *scriptRequest) (*scriptResult, error) {
+	start := time.Now()
+
 	// Clone the original request and update the URL path
-	origReq := thread.Request
 	clonedReq := origReq.Clone(ctx)
 
 	// PHP's php_resolve_path may return an absolute path; strip the document root
@@ -102,6 +174,37 @@ func executeScript(ctx context.Context, sr *scriptRequest) (*scriptResult, error
 	}
 	clonedReq.URL.Path = "/" + strings.TrimPrefix(scriptPath, "/")
 
+	// Guard against runaway nesting and scripts that (indirectly) dispatch
+	// themselves with the same params. Recursing with other params, as
+	// walking a tree does, is only bounded by MaxDepth.
+	call := subrequestCall{path: clonedReq.URL.Path}
+	if sr.Env != nil && (len(sr.Env.App) > 0 || len(sr.Env.CGI) > 0) {
+		params, err := json.Marshal(sr.Env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode params of '%s': %w", sr.Name, err)
+		}
+		call.params = string(params)
+	}
+	chain := subrequestChainFromContext(ctx)
+	if chain == nil {
+		chain = []subrequestCall{{path: origReq.URL.Path}}
+	}
+	chain = append(chain[:len(chain):len(chain)], call)
+	if slices.Contains(chain[:len(chain)-1], call) {
+		paths := make([]string, len(chain))
+		for i, c := range chain {
+			paths[i] = c.path
+		}
+		return nil, fmt.Errorf("%w: %s", ErrSubrequestLoop, strings.Join(paths, " -> "))
+	}
+	depth := len(chain) - 1
+	if MaxDepth > 0 && depth > MaxDepth {
+		return nil, fmt.Errorf("%w: '%s' at depth %d (max %d)", ErrMaxDepthExceeded, sr.Name, depth, MaxDepth)
+	}
+	clonedReq = clonedReq.WithContext(withSubrequestChain(clonedReq.Context(), chain))
+
+	applyForwardPolicy(clonedReq, sr)
+
 	// Prepare CGI environment variables
 	envCGI := make(map[string]string)
 	if sr.Env != nil {
@@ -113,11 +216,18 @@ func executeScript(ctx context.Context, sr *scriptRequest) (*scriptResult, error
 			envCGI["APP_"+strings.ToUpper(strings.ReplaceAll(fmt.Sprint(key), "-", "_"))] = fmt.Sprint(value)
 		}
 	}
+	envCGI["FRANKENASYNC_DEPTH"] = strconv.Itoa(depth)
+	if requestID := asynctask.FromContext(origReq.Context()).RequestID(); requestID != "" {
+		envCGI["FRANKENASYNC_REQUEST_ID"] = requestID
+	}
 
 	// Create FrankenPHP request for the subrequest
+	// Inside a task, the subrequest logs through the task's logger so its
+	// output can be retrieved with Future::getLogs()
 	reqOpts := []frankenphp.RequestOption{
 		frankenphp.WithRequestEnv(envCGI),
 		frankenphp.WithOriginalRequest(origReq),
+		frankenphp.WithRequestLogger(asynctask.LoggerFromContext(ctx)),
 	}
 	if DocumentRoot != "" {
 		reqOpts = append(reqOpts, frankenphp.WithRequestResolvedDocumentRoot(DocumentRoot))
@@ -150,6 +260,101 @@ func executeScript(ctx context.Context, sr *scriptRequest) (*scriptResult, error
 	}, nil
 }
 
+// scriptLabels returns the labels of a script task: its script name and
+// the worker pool it asked for.
+func scriptLabels(sr *scriptRequest) map[string]string {
+	labels := map[string]string{"script": sr.Name}
+	if sr.Pool != "" {
+		labels[asynctask.PoolLabel] = sr.Pool
+	}
+	return labels
+}
+
+// scriptContext returns the context to start a script task with.
+func scriptContext(ctx context.Context, sr *scriptRequest) context.Context {
+	if sr.SideEffects {
+		ctx = asynctask.WithSideEffects(ctx)
+	}
+	return asynctask.WithLabels(ctx, scriptLabels(sr))
+}
+
+// scriptTask returns the runnable of a script task, built from a spec so
+// the task can be replayed.
+func scriptTask(sr *scriptRequest) (asynctask.Runnable, error) {
+	var params map[string]any
+	if err := convert(sr, &params); err != nil {
+		return nil, err
+	}
+	return asynctask.Spec{Name: ScriptRunnable, Params: params}.Runnable()
+}
+
+// newScriptRunnable is the factory of ScriptRunnable. The params are the
+// JSON fields of a scriptRequest.
+func newScriptRunnable(params map[string]any) (asynctask.Runnable, error) {
+	var sr scriptRequest
+	if err := convert(params, &sr); err != nil {
+		return nil, err
+	}
+	if sr.Name == "" {
+		return nil, errors.New("name required")
+	}
+
+	return asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		result, err := executeScript(ctx, &sr)
+		if err != nil {
+			return nil, err
+		}
+		return taskResult(result)
+	}), nil
+}
+
+// convert copies src into dst through JSON, keeping numbers as written so
+// APP_* variables don't change format.
+func convert(src, dst any) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(dst)
+}
+
+// applyForwardPolicy strips parent request state the script request did not
+// ask to inherit from the cloned subrequest.
+func applyForwardPolicy(req *http.Request, sr *scriptRequest) {
+	if len(sr.HeaderAllow) > 0 {
+		allowed := make(map[string]bool, len(sr.HeaderAllow))
+		for _, name := range sr.HeaderAllow {
+			allowed[http.CanonicalHeaderKey(name)] = true
+		}
+		for name := range req.Header {
+			if !allowed[name] {
+				req.Header.Del(name)
+			}
+		}
+	}
+
+	for _, name := range sr.HeaderDeny {
+		req.Header.Del(name)
+	}
+
+	if sr.ForwardCookies != nil && !*sr.ForwardCookies {
+		req.Header.Del("Cookie")
+		return
+	}
+
+	if sr.ForwardSession != nil && !*sr.ForwardSession {
+		cookies := req.Cookies()
+		req.Header.Del("Cookie")
+		for _, cookie := range cookies {
+			if cookie.Name != SessionCookieName {
+				req.AddCookie(cookie)
+			}
+		}
+	}
+}
+
 // threadIndexKey is used to pass the thread index through context.
 type threadIndexKey struct{}
 
@@ -164,168 +369,218 @@ func threadIndexFromContext(ctx context.Context) int {
 	return -1
 }
 
+// subrequestCall is a script of the subrequest chain, and the encoded
+// params it was dispatched with.
+type subrequestCall struct {
+	path   string
+	params string
+}
+
+// subrequestChainKey carries the script calls from the top-level request down
+// to the current subrequest. Subrequest contexts derive from their parent's,
+// so the chain grows naturally as scripts dispatch further scripts.
+type subrequestChainKey struct{}
+
+func withSubrequestChain(ctx context.Context, chain []subrequestCall) context.Context {
+	return context.WithValue(ctx, subrequestChainKey{}, chain)
+}
+
+func subrequestChainFromContext(ctx context.Context) []subrequestCall {
+	if chain, ok := ctx.Value(subrequestChainKey{}).([]subrequestCall); ok {
+		return chain
+	}
+	return nil
+}
+
 //export go_execute_script
-func go_execute_script(threadIndex C.uintptr_t, script_json *C.char) (*C.char, C.bool) {
+func go_execute_script(threadIndex C.uintptr_t, payload *C.char, payload_len C.size_t) (*C.char, C.size_t, C.bool) {
 	thread, ok := frankenphp.Thread(int(threadIndex))
 	if !ok || thread.IsRequestDone() {
-		return C.CString("Thread not available"), C.bool(false)
+		return errorData(errThreadUnavailable, "")
 	}
 
 	ctx := thread.Request.Context()
 	ctx = withThreadIndex(ctx, int(threadIndex))
 
-	strScript := C.GoString(script_json)
+	enc := currentEncoding()
 
 	var sr scriptRequest
-	if err := json.Unmarshal([]byte(strScript), &sr); err != nil {
-		return C.CString(err.Error()), C.bool(false)
+	if err := enc.Unmarshal(C.GoBytes(unsafe.Pointer(payload), C.int(payload_len)), &sr); err != nil {
+		return errorData(invalidArgument(err), "")
 	}
 
 	result, err := executeScript(ctx, &sr)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		return errorData(err, "")
 	}
 
-	resultJSON, err := json.Marshal(result)
+	data, err := enc.Marshal(result)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		return errorData(err, "")
 	}
 
-	return C.CString(string(resultJSON)), C.bool(true)
+	return dataResult(data)
 }
 
 //export go_execute_script_async
-func go_execute_script_async(threadIndex C.uintptr_t, script_json *C.char) (*C.char, C.bool) {
+func go_execute_script_async(threadIndex C.uintptr_t, payload *C.char, payload_len C.size_t) (*C.char, C.bool) {
 	thread, ok := frankenphp.Thread(int(threadIndex))
 	if !ok || thread.IsRequestDone() {
-		return C.CString("Thread not available"), C.bool(false)
+		return errorResult(errThreadUnavailable, "")
 	}
 
 	ctx := thread.Request.Context()
 	ctx = withThreadIndex(ctx, int(threadIndex))
 
-	strScript := C.GoString(script_json)
-
 	var sr scriptRequest
-	if err := json.Unmarshal([]byte(strScript), &sr); err != nil {
-		return C.CString(err.Error()), C.bool(false)
+	if err := currentEncoding().Unmarshal(C.GoBytes(unsafe.Pointer(payload), C.int(payload_len)), &sr); err != nil {
+		return errorResult(invalidArgument(err), "")
+	}
+
+	runnable, err := scriptTask(&sr)
+	if err != nil {
+		return errorResult(invalidArgument(err), "")
 	}
 
 	tasks := asynctask.FromContext(ctx)
-	taskID := tasks.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
-		result, err := executeScript(ctx, &sr)
-		if err != nil {
-			return nil, err
-		}
-		resultJSON, err := json.Marshal(result)
-		if err != nil {
-			return nil, err
-		}
-		return string(resultJSON), nil
-	}))
+	labeled := scriptContext(ctx, &sr)
+	taskID := tasks.Async(labeled, runnable)
+
+	// Refuse tasks over the request's quotas up front rather than on await
+	if future, _ := tasks.Future(taskID); errors.Is(future.Error, asynctask.ErrQuotaExceeded) {
+		return errorResult(future.Error, taskID.String())
+	}
 
-	return C.CString(taskID.String()), C.bool(true)
+	return cString(taskID.String()), C.bool(true)
 }
 
 //export go_execute_script_defer
-func go_execute_script_defer(threadIndex C.uintptr_t, script_json *C.char) (*C.char, C.bool) {
+func go_execute_script_defer(threadIndex C.uintptr_t, payload *C.char, payload_len C.size_t) (*C.char, C.bool) {
 	thread, ok := frankenphp.Thread(int(threadIndex))
 	if !ok || thread.IsRequestDone() {
-		return C.CString("Thread not available"), C.bool(false)
+		return errorResult(errThreadUnavailable, "")
 	}
 
 	ctx := thread.Request.Context()
 	ctx = withThreadIndex(ctx, int(threadIndex))
 
-	strScript := C.GoString(script_json)
-
 	var sr scriptRequest
-	if err := json.Unmarshal([]byte(strScript), &sr); err != nil {
-		return C.CString(err.Error()), C.bool(false)
+	if err := currentEncoding().Unmarshal(C.GoBytes(unsafe.Pointer(payload), C.int(payload_len)), &sr); err != nil {
+		return errorResult(invalidArgument(err), "")
+	}
+
+	runnable, err := scriptTask(&sr)
+	if err != nil {
+		return errorResult(invalidArgument(err), "")
 	}
 
 	tasks := asynctask.FromContext(ctx)
-	taskID := tasks.Defer(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
-		result, err := executeScript(ctx, &sr)
-		if err != nil {
-			return nil, err
-		}
-		resultJSON, err := json.Marshal(result)
-		if err != nil {
-			return nil, err
-		}
-		return string(resultJSON), nil
-	}))
+	labeled := scriptContext(ctx, &sr)
+	taskID := tasks.Defer(labeled, runnable)
 
-	return C.CString(taskID.String()), C.bool(true)
+	// Refuse tasks over the request's quotas up front rather than on await
+	if future, _ := tasks.Future(taskID); errors.Is(future.Error, asynctask.ErrQuotaExceeded) {
+		return errorResult(future.Error, taskID.String())
+	}
+
+	return cString(taskID.String()), C.bool(true)
 }
 
-//export go_asynctask_await
-func go_asynctask_await(threadIndex C.uintptr_t, task_id *C.char, timeout C.int) (*C.char, C.bool) {
+//export go_asynctask_result_length
+func go_asynctask_result_length(threadIndex C.uintptr_t, task_id *C.char, timeout C.int) (C.longlong, *C.char, C.bool) {
 	thread, ok := frankenphp.Thread(int(threadIndex))
 	if !ok || thread.IsRequestDone() {
-		return C.CString("Thread not available"), C.bool(false)
+		errData, ok := errorResult(errThreadUnavailable, "")
+		return -1, errData, ok
 	}
 
 	strTaskID := C.GoString(task_id)
-	xidTaskID, err := xid.FromString(strTaskID)
+	taskID, err := asynctask.ParseID(strTaskID)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		errData, ok := errorResult(invalidArgument(err), strTaskID)
+		return -1, errData, ok
 	}
 
 	ctx := thread.Request.Context()
 	tasks := asynctask.FromContext(ctx)
 
-	var cancel context.CancelFunc
+	awaitCtx := ctx
 	if timeout > 0 {
-		durTimeout := time.Duration(timeout) * time.Millisecond
-		ctx, cancel = context.WithTimeout(thread.Request.Context(), durTimeout)
+		var cancel context.CancelFunc
+		awaitCtx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
 		defer cancel()
 	}
 
-	result, err := tasks.Await(ctx, asynctask.ID(xidTaskID))
+	result, err := tasks.Await(awaitCtx, taskID)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		errData, ok := errorResult(err, strTaskID)
+		return -1, errData, ok
 	}
 
-	var resultStr string
-	switch v := result.Result.(type) {
-	case string:
-		resultStr = v
-	case []byte:
-		resultStr = string(v)
-	default:
-		taskJSON, err := json.Marshal(result.Result)
-		if err != nil {
-			return C.CString(err.Error()), C.bool(false)
-		}
-		resultStr = string(taskJSON)
+	data, err := encodeResult(currentEncoding(), result.Result)
+	if err != nil {
+		errData, ok := errorResult(err, strTaskID)
+		return -1, errData, ok
 	}
 
-	return C.CString(resultStr), C.bool(true)
+	// The buffer is tied to the request, not the await timeout
+	if len(data) > 0 {
+		bufferResult(ctx, taskID, data)
+	}
+
+	return C.longlong(len(data)), nil, C.bool(true)
 }
 
-//export go_asynctask_await_all
-func go_asynctask_await_all(threadIndex C.uintptr_t, task_id_json *C.char, timeout C.int) (*C.char, C.bool) {
+//export go_asynctask_result_read
+func go_asynctask_result_read(threadIndex C.uintptr_t, task_id *C.char, offset C.longlong, buf *C.char, length C.size_t) (C.size_t, *C.char, C.bool) {
 	thread, ok := frankenphp.Thread(int(threadIndex))
 	if !ok || thread.IsRequestDone() {
-		return C.CString("Thread not available"), C.bool(false)
+		errData, ok := errorResult(errThreadUnavailable, "")
+		return 0, errData, ok
 	}
 
-	strTaskIDs := C.GoString(task_id_json)
+	strTaskID := C.GoString(task_id)
+	taskID, err := asynctask.ParseID(strTaskID)
+	if err != nil {
+		errData, ok := errorResult(invalidArgument(err), strTaskID)
+		return 0, errData, ok
+	}
 
-	var arrTaskIDs []string
-	if err := json.Unmarshal([]byte(strTaskIDs), &arrTaskIDs); err != nil {
-		return C.CString(err.Error()), C.bool(false)
+	dst := unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(length))
+	// Only the request that awaited the result can read it
+	n, err := readResult(thread.Request.Context(), taskID, int(offset), dst)
+	if err != nil {
+		errData, ok := errorResult(err, strTaskID)
+		return 0, errData, ok
 	}
 
-	taskIDs := make([]asynctask.ID, 0, len(arrTaskIDs))
-	for _, idStr := range arrTaskIDs {
-		xidID, err := xid.FromString(idStr)
+	return C.size_t(n), nil, C.bool(true)
+}
+
+// go_asynctask_await_all takes a JSON object of task IDs by key and returns
+// their results under the same keys. When tasks fail, the error is that of
+// the first failed task, with the errors of all of them by key in its
+// details.
+//
+//export go_asynctask_await_all
+func go_asynctask_await_all(threadIndex C.uintptr_t, task_id_json *C.char, timeout C.int) (*C.char, C.size_t, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorData(errThreadUnavailable, "")
+	}
+
+	keys, strTaskIDs, err := decodeKeyedIDs(C.GoString(task_id_json))
+	if err != nil {
+		return errorData(invalidArgument(err), "")
+	}
+
+	taskIDs := make([]asynctask.ID, 0, len(strTaskIDs))
+	for _, idStr := range strTaskIDs {
+		id, err := asynctask.ParseID(idStr)
 		if err != nil {
-			return C.CString(fmt.Sprintf("invalid task ID: %s", idStr)), C.bool(false)
+			return errorData(invalidArgument(fmt.Errorf("invalid task ID: %s", idStr)), idStr)
 		}
-		taskIDs = append(taskIDs, asynctask.ID(xidID))
+		taskIDs = append(taskIDs, id)
 	}
 
 	ctx := thread.Request.Context()
@@ -338,52 +593,104 @@ func go_asynctask_await_all(threadIndex C.uintptr_t, task_id_json *C.char, timeo
 		defer cancel()
 	}
 
-	results, err := tasks.AwaitAll(ctx, taskIDs)
+	results, errs, err := tasks.AwaitAllSettled(ctx, taskIDs)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		return errorData(err, "")
 	}
 
-	data := make([]any, 0, len(results))
-	for _, res := range results {
+	failed := -1
+	taskErrors := map[string]taskError{}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if failed < 0 {
+			failed = i
+		}
+		status, _ := tasks.Status(taskIDs[i])
+		taskErrors[keys[i]] = describeTaskError(err, status)
+	}
+	if failed >= 0 {
+		envelope := newBridgeError(errs[failed], strTaskIDs[failed])
+		envelope.Details["key"] = keys[failed]
+		envelope.Details["errors"] = taskErrors
+		return dataError(envelope)
+	}
+
+	data := make(map[string]any, len(results))
+	for i, res := range results {
 		switch v := res.Result.(type) {
-		case string:
-			data = append(data, v)
 		case []byte:
-			data = append(data, string(v))
+			data[keys[i]] = string(v)
 		default:
-			data = append(data, v)
+			data[keys[i]] = v
 		}
 	}
 
-	tasksJSON, err := json.Marshal(data)
+	encoded, err := currentEncoding().Marshal(data)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		return errorData(err, "")
 	}
 
-	return C.CString(string(tasksJSON)), C.bool(true)
+	return dataResult(encoded)
 }
 
+// decodeKeyedIDs decodes a JSON object of task IDs, returning its keys and
+// IDs in the order they appear.
+func decodeKeyedIDs(s string) (keys, ids []string, err error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	if tok, err := dec.Token(); err != nil {
+		return nil, nil, err
+	} else if tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("expected an object of task IDs, got %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var id string
+		if err := dec.Decode(&id); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, tok.(string))
+		ids = append(ids, id)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return keys, ids, nil
+}
+
+// go_asynctask_await_any also returns the position of the winning task in
+// the task ID list, or -1 on error.
+//
 //export go_asynctask_await_any
-func go_asynctask_await_any(threadIndex C.uintptr_t, task_id_json *C.char, timeout C.int) (*C.char, C.bool) {
+func go_asynctask_await_any(threadIndex C.uintptr_t, task_id_json *C.char, timeout C.int) (*C.char, C.size_t, C.longlong, C.bool) {
+	fail := func(err error, taskID string) (*C.char, C.size_t, C.longlong, C.bool) {
+		errData, errLen, ok := errorData(err, taskID)
+		return errData, errLen, -1, ok
+	}
+
 	thread, ok := frankenphp.Thread(int(threadIndex))
 	if !ok || thread.IsRequestDone() {
-		return C.CString("Thread not available"), C.bool(false)
+		return fail(errThreadUnavailable, "")
 	}
 
 	strTaskIDs := C.GoString(task_id_json)
 
 	var arrTaskIDs []string
 	if err := json.Unmarshal([]byte(strTaskIDs), &arrTaskIDs); err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		return fail(invalidArgument(err), "")
 	}
 
 	taskIDs := make([]asynctask.ID, 0, len(arrTaskIDs))
 	for _, idStr := range arrTaskIDs {
-		xidID, err := xid.FromString(idStr)
+		id, err := asynctask.ParseID(idStr)
 		if err != nil {
-			return C.CString(fmt.Sprintf("invalid task ID: %s", idStr)), C.bool(false)
+			return fail(invalidArgument(fmt.Errorf("invalid task ID: %s", idStr)), idStr)
 		}
-		taskIDs = append(taskIDs, asynctask.ID(xidID))
+		taskIDs = append(taskIDs, id)
 	}
 
 	ctx := thread.Request.Context()
@@ -396,50 +703,42 @@ func go_asynctask_await_any(threadIndex C.uintptr_t, task_id_json *C.char, timeo
 		defer cancel()
 	}
 
-	result, err := tasks.AwaitAny(ctx, taskIDs)
+	index, result, err := tasks.AwaitAnyIndex(ctx, taskIDs)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		return fail(err, "")
 	}
 
-	var resultStr string
-	switch v := result.Result.(type) {
-	case string:
-		resultStr = v
-	case []byte:
-		resultStr = string(v)
-	default:
-		taskJSON, err := json.Marshal(result.Result)
-		if err != nil {
-			return C.CString(err.Error()), C.bool(false)
-		}
-		resultStr = string(taskJSON)
+	data, err := encodeResult(currentEncoding(), result.Result)
+	if err != nil {
+		return fail(err, arrTaskIDs[index])
 	}
 
-	return C.CString(resultStr), C.bool(true)
+	resData, resLen, ok := dataResult(data)
+	return resData, resLen, C.longlong(index), ok
 }
 
 //export go_asynctask_info
 func go_asynctask_info(threadIndex C.uintptr_t, task_id *C.char) (*C.char, C.bool) {
 	thread, ok := frankenphp.Thread(int(threadIndex))
 	if !ok || thread.IsRequestDone() {
-		return C.CString("Thread not available"), C.bool(false)
+		return errorResult(errThreadUnavailable, "")
 	}
 
 	strTaskID := C.GoString(task_id)
-	xidTaskID, err := xid.FromString(strTaskID)
+	taskID, err := asynctask.ParseID(strTaskID)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		return errorResult(invalidArgument(err), strTaskID)
 	}
 
 	ctx := thread.Request.Context()
 	tasks := asynctask.FromContext(ctx)
 
-	taskData, err := tasks.Future(asynctask.ID(xidTaskID))
+	taskData, err := tasks.Future(taskID)
 	if err != nil {
 		if errors.Is(err, asynctask.ErrTaskNotFound) {
 			return nil, C.bool(true)
 		}
-		return C.CString(err.Error()), C.bool(false)
+		return errorResult(err, strTaskID)
 	}
 
 	// Build a JSON-serializable response with duration in milliseconds
@@ -447,11 +746,13 @@ func go_asynctask_info(threadIndex C.uintptr_t, task_id *C.char) (*C.char, C.boo
 		Status   string  `json:"status"`
 		Duration float64 `json:"duration"`
 		Error    string  `json:"error,omitempty"`
+		Stalled  bool    `json:"stalled,omitempty"`
 	}
 
 	info := taskInfo{
 		Status:   taskData.Status,
 		Duration: float64(taskData.Duration.Microseconds()) / 1000.0,
+		Stalled:  taskData.Stalled,
 	}
 	if taskData.Error != nil {
 		info.Error = taskData.Error.Error()
@@ -459,30 +760,270 @@ func go_asynctask_info(threadIndex C.uintptr_t, task_id *C.char) (*C.char, C.boo
 
 	byteResult, err := json.Marshal(info)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		return errorResult(err, "")
+	}
+
+	return cString(string(byteResult)), C.bool(true)
+}
+
+// go_asynctask_error returns the error of a finished task as a taskError,
+// or NULL when it has none.
+//
+//export go_asynctask_error
+func go_asynctask_error(threadIndex C.uintptr_t, task_id *C.char) (*C.char, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorResult(errThreadUnavailable, "")
+	}
+
+	strTaskID := C.GoString(task_id)
+	taskID, err := asynctask.ParseID(strTaskID)
+	if err != nil {
+		return errorResult(invalidArgument(err), strTaskID)
 	}
 
-	return C.CString(string(byteResult)), C.bool(true)
+	tasks := asynctask.FromContext(thread.Request.Context())
+
+	future, err := tasks.Future(taskID)
+	if err != nil {
+		return errorResult(err, strTaskID)
+	}
+	if future.Error == nil {
+		return nil, C.bool(true)
+	}
+	status, _ := tasks.Status(taskID)
+
+	data, err := json.Marshal(describeTaskError(future.Error, status))
+	if err != nil {
+		return errorResult(err, strTaskID)
+	}
+
+	return cString(string(data)), C.bool(true)
+}
+
+//export go_asynctask_logs
+func go_asynctask_logs(threadIndex C.uintptr_t, task_id *C.char) (*C.char, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorResult(errThreadUnavailable, "")
+	}
+
+	strTaskID := C.GoString(task_id)
+	taskID, err := asynctask.ParseID(strTaskID)
+	if err != nil {
+		return errorResult(invalidArgument(err), strTaskID)
+	}
+
+	tasks := asynctask.FromContext(thread.Request.Context())
+
+	logs, err := tasks.Logs(taskID)
+	if err != nil {
+		return errorResult(err, strTaskID)
+	}
+	if logs == nil {
+		logs = []asynctask.LogRecord{}
+	}
+
+	data, err := json.Marshal(logs)
+	if err != nil {
+		return errorResult(err, strTaskID)
+	}
+
+	return cString(string(data)), C.bool(true)
 }
 
 //export go_asynctask_cancel
 func go_asynctask_cancel(threadIndex C.uintptr_t, task_id *C.char) (*C.char, C.bool) {
 	thread, ok := frankenphp.Thread(int(threadIndex))
 	if !ok || thread.IsRequestDone() {
-		return C.CString("Thread not available"), C.bool(false)
+		return errorResult(errThreadUnavailable, "")
 	}
 
 	strTaskID := C.GoString(task_id)
-	xidTaskID, err := xid.FromString(strTaskID)
+	taskID, err := asynctask.ParseID(strTaskID)
 	if err != nil {
-		return C.CString(err.Error()), C.bool(false)
+		return errorResult(invalidArgument(err), strTaskID)
 	}
 
 	ctx := thread.Request.Context()
 	tasks := asynctask.FromContext(ctx)
-	result := tasks.Cancel(asynctask.ID(xidTaskID))
+	result, err := tasks.Cancel(taskID)
+	if err != nil {
+		return errorResult(err, strTaskID)
+	}
+
+	// A task that finished first keeps its result, cancel() returns false
+	if result == asynctask.CancelAlreadyFinished {
+		return cString(result.String()), C.bool(true)
+	}
+	return nil, C.bool(true)
+}
+
+type (
+	// taskFilter selects the tasks go_asynctask_list returns: those with
+	// one of Statuses, all when empty, carrying all of Labels.
+	taskFilter struct {
+		Statuses []string          `json:"statuses"`
+		Labels   map[string]string `json:"labels"`
+	}
 
-	return nil, C.bool(result)
+	// taskSummary describes a task listed by go_asynctask_list, with its
+	// durations in milliseconds.
+	taskSummary struct {
+		ID       string            `json:"id"`
+		Status   string            `json:"status"`
+		Labels   map[string]string `json:"labels"`
+		Duration float64           `json:"duration"`
+		Wait     float64           `json:"wait"`
+	}
+)
+
+// go_asynctask_list returns the tasks of the request's task manager
+// matching the JSON taskFilter filter_json, oldest first.
+//
+//export go_asynctask_list
+func go_asynctask_list(threadIndex C.uintptr_t, filter_json *C.char) (*C.char, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorResult(errThreadUnavailable, "")
+	}
+
+	var filter taskFilter
+	if err := json.Unmarshal([]byte(C.GoString(filter_json)), &filter); err != nil {
+		return errorResult(invalidArgument(err), "")
+	}
+
+	tasks := asynctask.FromContext(thread.Request.Context())
+
+	list := []taskSummary{}
+	for _, future := range tasks.List() {
+		if len(filter.Statuses) > 0 && !slices.Contains(filter.Statuses, future.Status) {
+			continue
+		}
+		if !matchLabels(future.Labels, filter.Labels) {
+			continue
+		}
+		labels := future.Labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		list = append(list, taskSummary{
+			ID:       future.ID.String(),
+			Status:   future.Status,
+			Labels:   labels,
+			Duration: float64(future.Duration.Microseconds()) / 1000.0,
+			Wait:     float64(future.Wait.Microseconds()) / 1000.0,
+		})
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return errorResult(err, "")
+	}
+
+	return cString(string(data)), C.bool(true)
+}
+
+// matchLabels reports whether labels holds all of want.
+func matchLabels(labels, want map[string]string) bool {
+	for key, value := range want {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// go_asynctask_stats returns the Stats of the request's task manager as
+// JSON.
+//
+//export go_asynctask_stats
+func go_asynctask_stats(threadIndex C.uintptr_t) (*C.char, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorResult(errThreadUnavailable, "")
+	}
+
+	tasks := asynctask.FromContext(thread.Request.Context())
+
+	data, err := json.Marshal(tasks.Stats())
+	if err != nil {
+		return errorResult(err, "")
+	}
+
+	return cString(string(data)), C.bool(true)
+}
+
+// go_asynctask_prune removes the finished tasks of the request's task
+// manager that finished at least ttl_ms ago, all of them for 0, and returns
+// how many it removed.
+//
+//export go_asynctask_prune
+func go_asynctask_prune(threadIndex C.uintptr_t, ttl_ms C.longlong) (C.longlong, *C.char, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		errData, ok := errorResult(errThreadUnavailable, "")
+		return 0, errData, ok
+	}
+	if ttl_ms < 0 {
+		errData, ok := errorResult(invalidArgument(errors.New("ttl must not be negative")), "")
+		return 0, errData, ok
+	}
+
+	tasks := asynctask.FromContext(thread.Request.Context())
+	pruned := tasks.Prune(time.Duration(ttl_ms) * time.Millisecond)
+
+	return C.longlong(pruned), nil, C.bool(true)
+}
+
+// go_asynctask_heartbeat tells the manager of the task the request runs
+// for, as a script subrequest, that it's still making progress. A no-op
+// outside of tasks with stall detection, see asynctask.Heartbeat.
+//
+//export go_asynctask_heartbeat
+func go_asynctask_heartbeat(threadIndex C.uintptr_t) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return
+	}
+	asynctask.Heartbeat(thread.Request.Context())
+}
+
+// go_asynctask_set_shutdown_policy sets what happens to the tasks the
+// request leaves running when it ends, waiting up to timeout_ms for them
+// with wait and detach, for as long as they take with 0.
+//
+//export go_asynctask_set_shutdown_policy
+func go_asynctask_set_shutdown_policy(threadIndex C.uintptr_t, policy *C.char, timeout_ms C.longlong) (*C.char, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorResult(errThreadUnavailable, "")
+	}
+	parsed, err := asynctask.ParseShutdownPolicy(C.GoString(policy))
+	if err != nil {
+		return errorResult(invalidArgument(err), "")
+	}
+	if timeout_ms < 0 {
+		return errorResult(invalidArgument(errors.New("timeout must not be negative")), "")
+	}
+
+	tasks := asynctask.FromContext(thread.Request.Context())
+	if err := tasks.SetShutdownPolicy(parsed, time.Duration(timeout_ms)*time.Millisecond); err != nil {
+		return errorResult(invalidArgument(err), "")
+	}
+
+	return nil, C.bool(true)
+}
+
+//export go_bridge_encoding
+func go_bridge_encoding() C.int {
+	name := currentEncoding().Name()
+	for i, enc := range bridgeEncodings {
+		if enc.Name() == name {
+			return C.int(i)
+		}
+	}
+	return 0
 }
 
 //export go_parse_duration_ms
@@ -10,6 +10,7 @@
 
 #define FRANKENASYNC_VERSION "0.1.0"
 #define FRANKENASYNC_JSON_DEPTH 512
+#define FRANKENASYNC_RESULT_CHUNK_SIZE (8 * 1024 * 1024)
 
 /* ============================================================================
  * SCRIPT CLASS
@@ -19,6 +20,7 @@
 typedef struct _script_object {
     zend_string *name;
     HashTable *ini;
+    HashTable *options;
     zend_object std;
 } script_object;
 
@@ -36,6 +38,7 @@ PHP_METHOD(Script, defer);
 ZEND_BEGIN_ARG_INFO_EX(arginfo_frankenasync_script_construct, 0, 0, 1)
     ZEND_ARG_TYPE_INFO(0, name, IS_STRING, 0)
     ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, ini, IS_ARRAY, 1, "[]")
+    ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, options, IS_ARRAY, 1, "[]")
 ZEND_END_ARG_INFO()
 
 ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_frankenasync_script_get_name, 0, 0, IS_STRING, 1)
@@ -72,14 +75,27 @@ PHP_METHOD(Async_Future, getId);
 PHP_METHOD(Async_Future, await);
 PHP_METHOD(Async_Future, awaitAll);
 PHP_METHOD(Async_Future, awaitAny);
+PHP_METHOD(Async_Future, awaitAnyIndex);
+PHP_METHOD(Async_Future, list);
+PHP_METHOD(Async_Future, getStats);
+PHP_METHOD(Async_Future, prune);
+PHP_METHOD(Async_Future, setShutdownPolicy);
+PHP_METHOD(Async_Future, heartbeat);
 PHP_METHOD(Async_Future, cancel);
 PHP_METHOD(Async_Future, getStatus);
 PHP_METHOD(Async_Future, getDuration);
 PHP_METHOD(Async_Future, getError);
+PHP_METHOD(Async_Future, getErrorInfo);
+PHP_METHOD(Async_Future, getInfo);
+PHP_METHOD(Async_Future, onComplete);
+PHP_METHOD(Async_Future, getLogs);
 
 /* Helper to create Future object from C */
 void frankenasync_create_asyncfuture_object(zval *return_value, const char *task_id);
 
+/* Future class entry, for the classes built on it */
+zend_class_entry *frankenasync_asyncfuture_class_entry(void);
+
 /* Future class argument info */
 ZEND_BEGIN_ARG_INFO_EX(arginfo_asyncfuture___construct, 0, 0, 1)
     ZEND_ARG_TYPE_INFO(0, taskId, IS_STRING, 0)
@@ -102,6 +118,31 @@ ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_awaitAny, 0, 1, IS_A
     ZEND_ARG_TYPE_MASK(0, timeout, MAY_BE_LONG | MAY_BE_STRING, "0")
 ZEND_END_ARG_INFO()
 
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_awaitAnyIndex, 0, 1, IS_ARRAY, 1)
+    ZEND_ARG_TYPE_INFO(0, tasks, IS_ARRAY, 0)
+    ZEND_ARG_TYPE_MASK(0, timeout, MAY_BE_LONG | MAY_BE_STRING, "0")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_list, 0, 0, IS_ARRAY, 0)
+    ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, statuses, IS_ARRAY, 0, "[]")
+    ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, labels, IS_ARRAY, 0, "[]")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_getStats, 0, 0, IS_ARRAY, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_prune, 0, 0, IS_LONG, 0)
+    ZEND_ARG_TYPE_MASK(0, ttl, MAY_BE_LONG | MAY_BE_STRING, "0")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_setShutdownPolicy, 0, 1, IS_VOID, 0)
+    ZEND_ARG_TYPE_INFO(0, policy, IS_STRING, 0)
+    ZEND_ARG_TYPE_MASK(0, timeout, MAY_BE_LONG | MAY_BE_STRING, "0")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_heartbeat, 0, 0, IS_VOID, 0)
+ZEND_END_ARG_INFO()
+
 ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_cancel, 0, 0, _IS_BOOL, 0)
 ZEND_END_ARG_INFO()
 
@@ -114,9 +155,246 @@ ZEND_END_ARG_INFO()
 ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_getError, 0, 0, IS_STRING, 1)
 ZEND_END_ARG_INFO()
 
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_getErrorInfo, 0, 0, IS_ARRAY, 1)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_getInfo, 0, 0, IS_ARRAY, 1)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_onComplete, 0, 1, IS_STATIC, 0)
+    ZEND_ARG_TYPE_INFO(0, callback, IS_CALLABLE, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_getLogs, 0, 0, IS_ARRAY, 0)
+ZEND_END_ARG_INFO()
+
 ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_status___toString, 0, 0, IS_STRING, 0)
 ZEND_END_ARG_INFO()
 
+/* Future exception methods */
+PHP_METHOD(Async_Future_Exception, getTaskId);
+PHP_METHOD(Async_Future_Exception, getErrorCode);
+PHP_METHOD(Async_Future_Exception, getDetails);
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_exception_getTaskId, 0, 0, IS_STRING, 1)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_exception_getErrorCode, 0, 0, IS_STRING, 1)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_asyncfuture_exception_getDetails, 0, 0, IS_ARRAY, 0)
+ZEND_END_ARG_INFO()
+
+/* ============================================================================
+ * STORE CLASS
+ * ============================================================================ */
+
+/* Store object structure */
+typedef struct _store_object {
+    int scope;
+    zend_object std;
+} store_object;
+
+/* Store initialization */
+int frankenasync_store_minit(void);
+
+/* Store PHP methods */
+PHP_METHOD(Async_Store, __construct);
+PHP_METHOD(Async_Store, get);
+PHP_METHOD(Async_Store, set);
+PHP_METHOD(Async_Store, delete);
+PHP_METHOD(Async_Store, cas);
+
+/* Store argument info */
+ZEND_BEGIN_ARG_INFO_EX(arginfo_store___construct, 0, 0, 0)
+    ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, scope, IS_STRING, 0, "Frankenphp\\Async\\Store::SCOPE_REQUEST")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_store_get, 0, 1, IS_MIXED, 0)
+    ZEND_ARG_TYPE_INFO(0, key, IS_STRING, 0)
+    ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, default, IS_MIXED, 0, "null")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_store_set, 0, 2, IS_VOID, 0)
+    ZEND_ARG_TYPE_INFO(0, key, IS_STRING, 0)
+    ZEND_ARG_TYPE_INFO(0, value, IS_MIXED, 0)
+    ZEND_ARG_TYPE_MASK(0, ttl, MAY_BE_LONG | MAY_BE_STRING, "0")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_store_delete, 0, 1, _IS_BOOL, 0)
+    ZEND_ARG_TYPE_INFO(0, key, IS_STRING, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_store_cas, 0, 3, _IS_BOOL, 0)
+    ZEND_ARG_TYPE_INFO(0, key, IS_STRING, 0)
+    ZEND_ARG_TYPE_INFO(0, expected, IS_MIXED, 0)
+    ZEND_ARG_TYPE_INFO(0, value, IS_MIXED, 0)
+    ZEND_ARG_TYPE_MASK(0, ttl, MAY_BE_LONG | MAY_BE_STRING, "0")
+ZEND_END_ARG_INFO()
+
+/* ============================================================================
+ * PUBSUB CLASSES
+ * ============================================================================ */
+
+/* Subscription object structure */
+typedef struct _subscription_object {
+    zend_string *handle;
+    zend_string *topic;
+    zend_object std;
+} subscription_object;
+
+/* PubSub initialization */
+int frankenasync_pubsub_minit(void);
+
+/* PubSub PHP methods */
+PHP_METHOD(Async_PubSub, publish);
+PHP_METHOD(Async_PubSub, subscribe);
+PHP_METHOD(Async_Subscription, __construct);
+PHP_METHOD(Async_Subscription, getTopic);
+PHP_METHOD(Async_Subscription, receive);
+PHP_METHOD(Async_Subscription, close);
+
+/* PubSub argument info */
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_pubsub_publish, 0, 2, IS_LONG, 0)
+    ZEND_ARG_TYPE_INFO(0, topic, IS_STRING, 0)
+    ZEND_ARG_TYPE_INFO(0, payload, IS_STRING, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_OBJ_INFO_EX(arginfo_pubsub_subscribe, 0, 1, Frankenphp\\Async\\Subscription, 0)
+    ZEND_ARG_TYPE_INFO(0, topic, IS_STRING, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_INFO_EX(arginfo_subscription___construct, 0, 0, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_subscription_getTopic, 0, 0, IS_STRING, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_subscription_receive, 0, 0, IS_STRING, 1)
+    ZEND_ARG_TYPE_MASK(0, timeout, MAY_BE_LONG | MAY_BE_STRING, "0")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_subscription_close, 0, 0, IS_VOID, 0)
+ZEND_END_ARG_INFO()
+
+/* ============================================================================
+ * LOCK CLASS
+ * ============================================================================ */
+
+/* Lock object structure */
+typedef struct _lock_object {
+    zend_string *name;
+    zend_string *token;
+    zend_object std;
+} lock_object;
+
+/* Lock initialization */
+int frankenasync_lock_minit(void);
+
+/* Lock PHP methods */
+PHP_METHOD(Async_Lock, __construct);
+PHP_METHOD(Async_Lock, acquire);
+PHP_METHOD(Async_Lock, release);
+PHP_METHOD(Async_Lock, getName);
+
+/* Lock argument info */
+ZEND_BEGIN_ARG_INFO_EX(arginfo_lock___construct, 0, 0, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_OBJ_INFO_EX(arginfo_lock_acquire, 0, 1, Frankenphp\\Async\\Lock, 1)
+    ZEND_ARG_TYPE_INFO(0, name, IS_STRING, 0)
+    ZEND_ARG_TYPE_MASK(0, ttl, MAY_BE_LONG | MAY_BE_STRING, "\"30s\"")
+    ZEND_ARG_TYPE_MASK(0, timeout, MAY_BE_LONG | MAY_BE_STRING, "0")
+    ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, permits, IS_LONG, 0, "1")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_lock_release, 0, 0, _IS_BOOL, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_lock_getName, 0, 0, IS_STRING, 0)
+ZEND_END_ARG_INFO()
+
+/* ============================================================================
+ * TASK GROUP CLASS
+ * ============================================================================ */
+
+/* TaskGroup object structure */
+typedef struct _taskgroup_object {
+    zval futures; /* array of Future objects by key */
+    zend_object std;
+} taskgroup_object;
+
+/* TaskGroup initialization */
+int frankenasync_taskgroup_minit(void);
+
+/* TaskGroup PHP methods */
+PHP_METHOD(Async_TaskGroup, __construct);
+PHP_METHOD(Async_TaskGroup, add);
+PHP_METHOD(Async_TaskGroup, getFutures);
+PHP_METHOD(Async_TaskGroup, count);
+PHP_METHOD(Async_TaskGroup, awaitAll);
+PHP_METHOD(Async_TaskGroup, awaitAny);
+PHP_METHOD(Async_TaskGroup, cancelAll);
+PHP_METHOD(Async_TaskGroup, getStatuses);
+
+/* TaskGroup argument info */
+ZEND_BEGIN_ARG_INFO_EX(arginfo_taskgroup___construct, 0, 0, 0)
+    ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, futures, IS_ARRAY, 0, "[]")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_taskgroup_add, 0, 1, IS_STATIC, 0)
+    ZEND_ARG_OBJ_INFO(0, future, Frankenphp\\Async\\Future, 0)
+    ZEND_ARG_TYPE_MASK(0, key, MAY_BE_STRING | MAY_BE_LONG | MAY_BE_NULL, "null")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_taskgroup_getFutures, 0, 0, IS_ARRAY, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_taskgroup_count, 0, 0, IS_LONG, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_taskgroup_awaitAll, 0, 0, IS_ARRAY, 1)
+    ZEND_ARG_TYPE_MASK(0, timeout, MAY_BE_LONG | MAY_BE_STRING, "0")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_taskgroup_awaitAny, 0, 0, IS_ARRAY, 1)
+    ZEND_ARG_TYPE_MASK(0, timeout, MAY_BE_LONG | MAY_BE_STRING, "0")
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_taskgroup_cancelAll, 0, 0, IS_LONG, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_TYPE_INFO_EX(arginfo_taskgroup_getStatuses, 0, 0, IS_ARRAY, 0)
+ZEND_END_ARG_INFO()
+
+/* ============================================================================
+ * COMMAND CLASS
+ * ============================================================================ */
+
+/* Command initialization */
+int frankenasync_command_minit(void);
+
+/* Command PHP methods */
+PHP_METHOD(Async_Command, __construct);
+PHP_METHOD(Async_Command, async);
+
+/* Command argument info */
+ZEND_BEGIN_ARG_INFO_EX(arginfo_command___construct, 0, 0, 0)
+ZEND_END_ARG_INFO()
+
+ZEND_BEGIN_ARG_WITH_RETURN_OBJ_INFO_EX(arginfo_command_async, 0, 1, Frankenphp\\Async\\Future, 0)
+    ZEND_ARG_TYPE_INFO(0, command, IS_STRING, 0)
+    ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, args, IS_ARRAY, 0, "[]")
+    ZEND_ARG_TYPE_INFO_WITH_DEFAULT_VALUE(0, options, IS_ARRAY, 0, "[]")
+ZEND_END_ARG_INFO()
+
+/* ============================================================================
+ * BRIDGE ERRORS
+ * ============================================================================ */
+
+/* Throw the typed exception for an error envelope returned by a Go export */
+void frankenasync_throw_bridge_error(const char *payload);
+
 /* ============================================================================
  * MODULE LIFECYCLE
  * ============================================================================ */
@@ -0,0 +1,436 @@
+package phpext
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/kvstore"
+
+	"github.com/dunglas/frankenphp"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// TestMain boots FrankenPHP with the extension once for every test, with
+// the msgpack bridge encoding, since the encoding is negotiated when the
+// module starts.
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "phpext")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	Register()
+	DocumentRoot = dir
+	Encoding = EncodingMsgpack
+	if err := frankenphp.Init(
+		frankenphp.WithNumThreads(8),
+		frankenphp.WithPhpIni(map[string]string{"include_path": dir}),
+	); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer frankenphp.Shutdown()
+
+	return m.Run()
+}
+
+// writeScript writes the PHP code of script name under the document root.
+func writeScript(t *testing.T, name, code string) {
+	t.Helper()
+	path := filepath.Join(DocumentRoot, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(code), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// runScript runs script name with a task manager of its own, as the
+// server runs a request, and returns its response.
+func runScript(t *testing.T, name string, args map[string]any) (*scriptResult, error) {
+	t.Helper()
+	tm := asynctask.NewManager(asynctask.WithCodec(asynctask.MsgpackCodec{}))
+	t.Cleanup(func() { tm.Shutdown(context.Background()) })
+
+	result, err := RunScript(asynctask.WithContext(context.Background(), tm), name, args)
+	if err != nil {
+		return nil, err
+	}
+	return result.(*scriptResult), nil
+}
+
+// runRequest runs the script of sr as a subrequest of req, which may carry
+// headers and cookies for it to inherit.
+func runRequest(t *testing.T, req *http.Request, sr *scriptRequest) (*scriptResult, error) {
+	t.Helper()
+	tm := asynctask.NewManager(asynctask.WithCodec(asynctask.MsgpackCodec{}))
+	t.Cleanup(func() { tm.Shutdown(context.Background()) })
+
+	ctx := asynctask.WithContext(req.Context(), tm)
+	ctx = kvstore.WithContext(ctx, kvstore.New())
+	ctx = withSubrequestChain(ctx, []subrequestCall{})
+	return runSubrequest(ctx, req.WithContext(ctx), sr)
+}
+
+// Test task IDs keyed by the caller's array keys, in their order
+func TestDecodeKeyedIDs(t *testing.T) {
+	keys, ids, err := decodeKeyedIDs(`{"user":"a","0":"b","orders":"c"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(keys, []string{"user", "0", "orders"}) || !slices.Equal(ids, []string{"a", "b", "c"}) {
+		t.Fatalf("got keys %v and IDs %v", keys, ids)
+	}
+
+	keys, ids, err = decodeKeyedIDs(`{}`)
+	if err != nil || len(keys) != 0 || len(ids) != 0 {
+		t.Fatalf("got keys %v, IDs %v and %v for no tasks", keys, ids, err)
+	}
+
+	for _, input := range []string{``, `["a","b"]`, `{"user":1}`, `{"user":"a"`, `{"user":"a",}`, `"a"`} {
+		if _, _, err := decodeKeyedIDs(input); err == nil {
+			t.Fatalf("expected an error for %q", input)
+		}
+	}
+}
+
+// Test the cookies, session and headers forwarded to subrequests
+func TestApplyForwardPolicy(t *testing.T) {
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Accept-Language", "nl")
+		req.Header.Set("X-Trace", "1")
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "session"})
+		req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+		return req
+	}
+	off := false
+
+	// Everything is forwarded by default
+	req := newRequest()
+	applyForwardPolicy(req, &scriptRequest{})
+	assertEqual(t, req.Header.Get("Authorization"), "Bearer secret")
+	assertEqual(t, len(req.Cookies()), 2)
+
+	req = newRequest()
+	applyForwardPolicy(req, &scriptRequest{HeaderAllow: []string{"accept-language", "cookie"}})
+	assertEqual(t, req.Header.Get("Authorization"), "")
+	assertEqual(t, req.Header.Get("X-Trace"), "")
+	assertEqual(t, req.Header.Get("Accept-Language"), "nl")
+	assertEqual(t, len(req.Cookies()), 2)
+
+	req = newRequest()
+	applyForwardPolicy(req, &scriptRequest{HeaderDeny: []string{"authorization"}})
+	assertEqual(t, req.Header.Get("Authorization"), "")
+	assertEqual(t, req.Header.Get("X-Trace"), "1")
+
+	req = newRequest()
+	applyForwardPolicy(req, &scriptRequest{ForwardCookies: &off})
+	assertEqual(t, req.Header.Get("Cookie"), "")
+
+	req = newRequest()
+	applyForwardPolicy(req, &scriptRequest{ForwardSession: &off})
+	cookies := req.Cookies()
+	assertEqual(t, len(cookies), 1)
+	assertEqual(t, cookies[0].Name, "theme")
+}
+
+// Test that scripts may recurse with other params up to MaxDepth, and
+// that dispatching themselves with the same params is a loop
+func TestRunSubrequest_Recursion(t *testing.T) {
+	writeScript(t, "tree.php", `<?php
+use Frankenphp\Script;
+use Frankenphp\Async\Future;
+
+$n = (int) ($_SERVER['APP_N'] ?? 0);
+if ($n === 3) {
+    echo 'depth ', $_SERVER['FRANKENASYNC_DEPTH'];
+    return;
+}
+try {
+    echo (new Script('tree.php'))->execute(['n' => $n + 1])['body'];
+} catch (Future\Exception $e) {
+    echo $e->getErrorCode();
+}
+`)
+	writeScript(t, "self.php", `<?php
+use Frankenphp\Script;
+use Frankenphp\Async\Future;
+
+try {
+    echo (new Script('self.php'))->execute(['page' => $_SERVER['APP_PAGE']])['body'];
+} catch (Future\Exception $e) {
+    echo $e->getErrorCode();
+}
+`)
+
+	result, err := runScript(t, "tree.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, "depth 3")
+
+	result, err = runScript(t, "self.php", map[string]any{"page": "1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, codeSubrequestLoop)
+
+	defer func(depth int) { MaxDepth = depth }(MaxDepth)
+	MaxDepth = 2
+	result, err = runScript(t, "tree.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, codeDepthExceeded)
+}
+
+// Test the options of Script deciding the headers and cookies a subrequest
+// inherits
+func TestRunSubrequest_Forwarding(t *testing.T) {
+	writeScript(t, "headers.php", `<?php
+$cookies = array_keys($_COOKIE);
+sort($cookies);
+echo $_SERVER['HTTP_AUTHORIZATION'] ?? '-', '|', $_SERVER['HTTP_ACCEPT_LANGUAGE'] ?? '-', '|', implode(',', $cookies);
+`)
+	writeScript(t, "forward.php", `<?php
+use Frankenphp\Script;
+
+$options = json_decode($_SERVER['APP_OPTIONS'], true);
+echo (new Script('headers.php', null, $options))->execute()['body'];
+`)
+
+	for options, want := range map[string]string{
+		`{}`:                                   "Bearer secret|nl|PHPSESSID,theme",
+		`{"header_deny":["Authorization"]}`:    "-|nl|PHPSESSID,theme",
+		`{"header_allow":["Accept-Language"]}`: "-|nl|",
+		`{"forward_cookies":false}`:            "Bearer secret|nl|",
+		`{"forward_session":false}`:            "Bearer secret|nl|theme",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Accept-Language", "nl")
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "session"})
+		req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+
+		result, err := runRequest(t, req, &scriptRequest{Name: "forward.php", Env: &scriptEnv{App: map[string]any{"options": options}}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Body != want {
+			t.Fatalf("got %q with %s, want %q", result.Body, options, want)
+		}
+	}
+}
+
+// Test failures surfacing as typed exceptions with their code and task
+func TestFuture_Exceptions(t *testing.T) {
+	writeScript(t, "sleep.php", `<?php usleep(200000); echo 'slept';`)
+	writeScript(t, "exceptions.php", `<?php
+use Frankenphp\Script;
+use Frankenphp\Async\Future;
+
+$slow = (new Script('sleep.php'))->async();
+try {
+    $slow->await('10ms');
+} catch (Future\FutureTimeoutException $e) {
+    echo $e->getErrorCode(), ' ', $e->getTaskId() === $slow->getId() ? 'task' : 'other', "\n";
+}
+
+$deferred = (new Script('sleep.php'))->defer();
+$deferred->cancel();
+try {
+    $deferred->await('1s');
+} catch (Future\AsyncCanceledException $e) {
+    echo $e->getErrorCode(), ' ', get_class($e), "\n";
+}
+`)
+
+	result, err := runScript(t, "exceptions.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, "TIMEOUT task\nCANCELED Frankenphp\\Async\\Future\\FutureCanceledException\n")
+}
+
+// Test the error of a failed task described as structured data
+func TestFuture_GetErrorInfo(t *testing.T) {
+	writeScript(t, "sleep.php", `<?php usleep(200000); echo 'slept';`)
+	writeScript(t, "echo.php", `<?php echo $_SERVER['APP_VALUE'] ?? '';`)
+	writeScript(t, "errorinfo.php", `<?php
+use Frankenphp\Script;
+
+$deferred = (new Script('sleep.php'))->defer();
+$deferred->cancel();
+$info = $deferred->getErrorInfo();
+echo $info['code'], ' ', var_export($info['canceled'], true), ' ', var_export($info['timeout'], true), ' ', count($info['chain']) > 0 ? 'chain' : 'none', "\n";
+
+$done = (new Script('echo.php'))->async();
+$done->await('5s');
+var_export($done->getErrorInfo());
+`)
+
+	result, err := runScript(t, "errorinfo.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, "CANCELED true false chain\nNULL")
+}
+
+// Test a result too large for a single buffer reaching PHP whole
+func TestFuture_LargeResult(t *testing.T) {
+	writeScript(t, "big.php", `<?php echo str_repeat('x', 3 << 20);`)
+	writeScript(t, "awaitbig.php", `<?php
+use Frankenphp\Script;
+
+$result = (new Script('big.php'))->async()->await('10s');
+echo strlen($result['body']), ' ', $result['body'] === str_repeat('x', 3 << 20) ? 'same' : 'different';
+`)
+
+	result, err := runScript(t, "awaitbig.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, "3145728 same")
+}
+
+// Test a task group handing results and statuses back under its keys
+func TestTaskGroup(t *testing.T) {
+	writeScript(t, "sleep.php", `<?php usleep(200000); echo 'slept';`)
+	writeScript(t, "echo.php", `<?php echo $_SERVER['APP_VALUE'] ?? '';`)
+	writeScript(t, "group.php", `<?php
+use Frankenphp\Script;
+use Frankenphp\Async\TaskGroup;
+
+$group = new TaskGroup([
+    'a' => (new Script('echo.php'))->async(['value' => 'one']),
+    'b' => (new Script('echo.php'))->async(['value' => 'two']),
+]);
+$group->add((new Script('echo.php'))->async(['value' => 'three']), 'c');
+
+$results = $group->awaitAll('5s');
+echo count($group), ' ', implode(',', array_keys($results)), ' ', implode(',', array_map(fn($r) => $r['body'], $results)), "\n";
+echo implode(',', array_map(fn($s) => $s->value, $group->getStatuses())), "\n";
+
+$pending = new TaskGroup(['slow' => (new Script('sleep.php'))->defer()]);
+echo $pending->cancelAll();
+`)
+
+	result, err := runScript(t, "group.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, "3 a,b,c one,two,three\ncompleted,completed,completed\n1")
+}
+
+// Test the stats of the request's task manager, and pruning its finished
+// tasks
+func TestFuture_StatsAndPrune(t *testing.T) {
+	writeScript(t, "echo.php", `<?php echo $_SERVER['APP_VALUE'] ?? '';`)
+	writeScript(t, "stats.php", `<?php
+use Frankenphp\Script;
+use Frankenphp\Async\Future;
+
+Future::awaitAll([(new Script('echo.php'))->async(), (new Script('echo.php'))->async()], '5s');
+$stats = Future::getStats();
+echo $stats['completed'], ' ', $stats['total'], "\n";
+echo Future::prune('1h'), ' ', Future::prune(), ' ', Future::getStats()['total'];
+`)
+
+	result, err := runScript(t, "stats.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, "2 2\n0 2 0")
+}
+
+// Test listing the request's tasks by status and labels
+func TestFuture_List(t *testing.T) {
+	writeScript(t, "sleep.php", `<?php usleep(200000); echo 'slept';`)
+	writeScript(t, "echo.php", `<?php echo $_SERVER['APP_VALUE'] ?? '';`)
+	writeScript(t, "list.php", `<?php
+use Frankenphp\Script;
+use Frankenphp\Async\Future;
+use Frankenphp\Async\Future\Status;
+
+$done = (new Script('echo.php'))->async();
+$done->await('5s');
+$deferred = (new Script('sleep.php'))->defer();
+
+$names = fn(array $tasks) => implode(',', array_map(
+    fn($task) => match ($task['id']) {
+        $done->getId() => 'done',
+        $deferred->getId() => 'deferred',
+        default => 'other',
+    },
+    $tasks,
+));
+echo $names(Future::list()), "\n";
+echo $names(Future::list([Status::Deferred])), "\n";
+echo $names(Future::list(['completed', 'failed'])), "\n";
+echo $names(Future::list([], ['script' => (new Script('sleep.php'))->getName()])), "\n";
+echo $names(Future::list([], ['script' => 'missing.php'])), "\n";
+
+$deferred->cancel();
+`)
+
+	result, err := runScript(t, "list.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, "done,deferred\ndeferred\ndone\ndeferred\n\n")
+}
+
+// Test label filters of task lists
+func TestMatchLabels(t *testing.T) {
+	labels := map[string]string{"script": "a.php", "pool": "io"}
+	assertEqual(t, matchLabels(labels, nil), true)
+	assertEqual(t, matchLabels(labels, map[string]string{"pool": "io"}), true)
+	assertEqual(t, matchLabels(labels, map[string]string{"pool": "io", "script": "a.php"}), true)
+	assertEqual(t, matchLabels(labels, map[string]string{"pool": "cpu"}), false)
+	assertEqual(t, matchLabels(labels, map[string]string{"request": ""}), false)
+	assertEqual(t, matchLabels(nil, map[string]string{"pool": "io"}), false)
+}
+
+// Test the exceptions of tasks under their alias names
+func TestFuture_ExceptionAliases(t *testing.T) {
+	writeScript(t, "aliases.php", `<?php
+use Frankenphp\Async\Future;
+
+foreach (['AsyncTaskNotFoundException', 'AsyncTimeoutException', 'AsyncCanceledException'] as $alias) {
+    $class = new ReflectionClass('Frankenphp\Async\Future\\' . $alias);
+    echo $class->getShortName(), ' ', $class->isSubclassOf(Future\Exception::class) ? 'typed' : 'untyped', "\n";
+}
+`)
+
+	result, err := runScript(t, "aliases.php", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, result.Body, "FutureNotFoundException typed\nFutureTimeoutException typed\nFutureCanceledException typed\n")
+}
@@ -0,0 +1,219 @@
+/**
+ * FrankenAsync Pub/Sub
+ *
+ * Registers Frankenphp\Async\PubSub and Frankenphp\Async\Subscription on top
+ * of the Go broker, so tasks can broadcast progress to the request that
+ * spawned them or to SSE clients.
+ */
+
+#include <php.h>
+
+#include <Zend/zend_exceptions.h>
+
+#include "phpext.h"
+#include "util.h"
+#include "phpext_cgo.h"
+
+#include "frankenphp.h"
+
+static zend_class_entry *pubsub_ce = NULL;
+static zend_class_entry *subscription_ce = NULL;
+static zend_object_handlers subscription_object_handlers;
+
+static const zend_function_entry pubsub_methods[];
+static const zend_function_entry subscription_methods[];
+
+static inline subscription_object *subscription_from_obj(zend_object *obj) {
+    return (subscription_object *)((char *)(obj) - XtOffsetOf(subscription_object, std));
+}
+
+static zend_object *subscription_create_object(zend_class_entry *ce)
+{
+    subscription_object *intern = ecalloc(1, sizeof(subscription_object) + zend_object_properties_size(ce));
+
+    zend_object_std_init(&intern->std, ce);
+    object_properties_init(&intern->std, ce);
+
+    intern->handle = NULL;
+    intern->topic = NULL;
+    intern->std.handlers = &subscription_object_handlers;
+
+    return &intern->std;
+}
+
+static void subscription_close(subscription_object *intern)
+{
+    if (intern->handle) {
+        go_unsubscribe(frankenphp_thread_index(), ZSTR_VAL(intern->handle));
+        zend_string_release(intern->handle);
+        intern->handle = NULL;
+    }
+}
+
+static void subscription_free_object(zend_object *object)
+{
+    subscription_object *intern = subscription_from_obj(object);
+
+    subscription_close(intern);
+
+    if (intern->topic) {
+        zend_string_release(intern->topic);
+    }
+
+    zend_object_std_dtor(&intern->std);
+}
+
+int frankenasync_pubsub_minit(void)
+{
+    zend_class_entry ce;
+
+    INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async", "PubSub", pubsub_methods);
+    pubsub_ce = zend_register_internal_class(&ce);
+    if (!pubsub_ce) {
+        return FAILURE;
+    }
+    pubsub_ce->ce_flags |= ZEND_ACC_FINAL;
+
+    INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async", "Subscription", subscription_methods);
+    subscription_ce = zend_register_internal_class(&ce);
+    if (!subscription_ce) {
+        return FAILURE;
+    }
+
+    subscription_ce->ce_flags |= ZEND_ACC_FINAL;
+    subscription_ce->create_object = subscription_create_object;
+
+    memcpy(&subscription_object_handlers, zend_get_std_object_handlers(), sizeof(zend_object_handlers));
+    subscription_object_handlers.offset = XtOffsetOf(subscription_object, std);
+    subscription_object_handlers.free_obj = subscription_free_object;
+    subscription_object_handlers.clone_obj = NULL;
+
+    return SUCCESS;
+}
+
+PHP_METHOD(Async_PubSub, publish)
+{
+    zend_string *topic;
+    zend_string *payload;
+
+    ZEND_PARSE_PARAMETERS_START(2, 2)
+        Z_PARAM_STR(topic)
+        Z_PARAM_STR(payload)
+    ZEND_PARSE_PARAMETERS_END();
+
+    long long delivered = go_publish(
+        frankenphp_thread_index(),
+        ZSTR_VAL(topic),
+        ZSTR_VAL(payload),
+        ZSTR_LEN(payload)
+    );
+
+    RETURN_LONG((zend_long) delivered);
+}
+
+PHP_METHOD(Async_PubSub, subscribe)
+{
+    zend_string *topic;
+
+    ZEND_PARSE_PARAMETERS_START(1, 1)
+        Z_PARAM_STR(topic)
+    ZEND_PARSE_PARAMETERS_END();
+
+    struct go_subscribe_return result = go_subscribe(
+        frankenphp_thread_index(),
+        ZSTR_VAL(topic)
+    );
+
+    if (UNEXPECTED(!result.r1)) {
+        frankenasync_throw_bridge_error(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+
+    object_init_ex(return_value, subscription_ce);
+
+    subscription_object *intern = subscription_from_obj(Z_OBJ_P(return_value));
+    intern->handle = zend_string_init(result.r0, strlen(result.r0), 0);
+    intern->topic = zend_string_copy(topic);
+
+    go_free_result(result.r0);
+}
+
+PHP_METHOD(Async_Subscription, __construct)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+}
+
+PHP_METHOD(Async_Subscription, getTopic)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    subscription_object *intern = subscription_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    if (UNEXPECTED(!intern->topic)) {
+        frankenasync_throw_error("Subscription not properly initialized");
+        RETURN_THROWS();
+    }
+
+    RETURN_STR_COPY(intern->topic);
+}
+
+PHP_METHOD(Async_Subscription, receive)
+{
+    zval *timeout_param = NULL;
+
+    ZEND_PARSE_PARAMETERS_START(0, 1)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ZVAL(timeout_param)
+    ZEND_PARSE_PARAMETERS_END();
+
+    PARSE_TIMEOUT_PARAM(timeout_param)
+
+    subscription_object *intern = subscription_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    if (UNEXPECTED(!intern->handle)) {
+        frankenasync_throw_exception("Subscription is closed");
+        RETURN_THROWS();
+    }
+
+    struct go_subscription_receive_return result = go_subscription_receive(
+        frankenphp_thread_index(),
+        ZSTR_VAL(intern->handle),
+        timeout_ms
+    );
+
+    if (UNEXPECTED(!result.r2)) {
+        frankenasync_throw_bridge_error(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+
+    /* Timed out without a message */
+    if (result.r0 == NULL) {
+        RETURN_NULL();
+    }
+
+    RETVAL_STRINGL(result.r0, result.r1);
+    go_free_result(result.r0);
+}
+
+PHP_METHOD(Async_Subscription, close)
+{
+    ZEND_PARSE_PARAMETERS_NONE();
+
+    subscription_close(subscription_from_obj(Z_OBJ_P(ZEND_THIS)));
+}
+
+static const zend_function_entry pubsub_methods[] = {
+    PHP_ME(Async_PubSub, publish, arginfo_pubsub_publish, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_ME(Async_PubSub, subscribe, arginfo_pubsub_subscribe, ZEND_ACC_PUBLIC | ZEND_ACC_STATIC)
+    PHP_FE_END
+};
+
+static const zend_function_entry subscription_methods[] = {
+    PHP_ME(Async_Subscription, __construct, arginfo_subscription___construct, ZEND_ACC_PRIVATE)
+    PHP_ME(Async_Subscription, getTopic, arginfo_subscription_getTopic, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Subscription, receive, arginfo_subscription_receive, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Subscription, close, arginfo_subscription_close, ZEND_ACC_PUBLIC)
+    PHP_FE_END
+};
@@ -0,0 +1,95 @@
+package phpext
+
+// #include <stdlib.h>
+// #include <stdint.h>
+import "C"
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/johanjanssens/frankenasync/pubsub"
+
+	"github.com/dunglas/frankenphp"
+
+	"github.com/rs/xid"
+)
+
+// Broker carries messages published from PHP. It is server-global, so a
+// background task can report to the request that spawned it as well as to
+// SSE handlers serving other clients.
+var Broker = pubsub.NewBroker()
+
+// subscriptions maps handles given to PHP onto their subscription. A
+// subscription is closed when PHP closes it or its request ends.
+var subscriptions sync.Map // handle string -> *pubsub.Subscription
+
+//export go_publish
+func go_publish(threadIndex C.uintptr_t, topic *C.char, payload *C.char, payload_len C.size_t) C.longlong {
+	delivered := Broker.Publish(C.GoString(topic), C.GoBytes(unsafe.Pointer(payload), C.int(payload_len)))
+
+	return C.longlong(delivered)
+}
+
+//export go_subscribe
+func go_subscribe(threadIndex C.uintptr_t, topic *C.char) (*C.char, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorResult(errThreadUnavailable, "")
+	}
+
+	sub := Broker.Subscribe(C.GoString(topic), 0)
+	handle := xid.New().String()
+	subscriptions.Store(handle, sub)
+
+	context.AfterFunc(thread.Request.Context(), func() {
+		subscriptions.Delete(handle)
+		sub.Close()
+	})
+
+	return cString(handle), C.bool(true)
+}
+
+// go_subscription_receive returns the next message, or NULL when timeout
+// elapses first.
+//
+//export go_subscription_receive
+func go_subscription_receive(threadIndex C.uintptr_t, handle *C.char, timeout C.int) (*C.char, C.size_t, C.bool) {
+	thread, ok := frankenphp.Thread(int(threadIndex))
+	if !ok || thread.IsRequestDone() {
+		return errorData(errThreadUnavailable, "")
+	}
+
+	value, ok := subscriptions.Load(C.GoString(handle))
+	if !ok {
+		return errorData(pubsub.ErrClosed, "")
+	}
+
+	ctx := thread.Request.Context()
+
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		durTimeout := time.Duration(timeout) * time.Millisecond
+		ctx, cancel = context.WithTimeout(ctx, durTimeout)
+		defer cancel()
+	}
+
+	msg, err := value.(*pubsub.Subscription).Receive(ctx)
+	if err != nil {
+		if timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+			return nil, 0, C.bool(true)
+		}
+		return errorData(err, "")
+	}
+
+	return dataResult(msg)
+}
+
+//export go_unsubscribe
+func go_unsubscribe(threadIndex C.uintptr_t, handle *C.char) {
+	if value, ok := subscriptions.LoadAndDelete(C.GoString(handle)); ok {
+		value.(*pubsub.Subscription).Close()
+	}
+}
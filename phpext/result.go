@@ -0,0 +1,65 @@
+package phpext
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+var errResultNotBuffered = fmt.Errorf("%w: no buffered result", asynctask.ErrTaskNotFound)
+
+// resultBuffers holds encoded task results between go_asynctask_result_length
+// and the go_asynctask_result_read calls that drain them, so large results can
+// be copied into PHP in chunks instead of through one C.CString allocation.
+var resultBuffers sync.Map // resultKey -> *resultBuffer
+
+type (
+	// resultKey identifies a buffered result by the request that awaited it
+	// as well as its task, so other requests can't read it, even knowing or
+	// guessing the task ID.
+	resultKey struct {
+		request context.Context
+		taskID  asynctask.ID
+	}
+
+	resultBuffer struct {
+		data []byte
+	}
+)
+
+// bufferResult stores data for taskID, awaited by the request of ctx, until
+// it is fully read or the request context ends, whichever happens first.
+func bufferResult(ctx context.Context, taskID asynctask.ID, data []byte) {
+	key := resultKey{request: ctx, taskID: taskID}
+	buf := &resultBuffer{data: data}
+	resultBuffers.Store(key, buf)
+	context.AfterFunc(ctx, func() {
+		resultBuffers.CompareAndDelete(key, buf)
+	})
+}
+
+// readResult copies the bytes of taskID buffered for the request of ctx,
+// starting at offset, into dst and returns the number of bytes copied. The
+// buffer is released once the last byte has been read.
+func readResult(ctx context.Context, taskID asynctask.ID, offset int, dst []byte) (int, error) {
+	key := resultKey{request: ctx, taskID: taskID}
+	value, ok := resultBuffers.Load(key)
+	if !ok {
+		return 0, errResultNotBuffered
+	}
+
+	data := value.(*resultBuffer).data
+	if offset < 0 || offset > len(data) {
+		return 0, invalidArgument(errors.New("offset out of range"))
+	}
+
+	n := copy(dst, data[offset:])
+	if offset+n == len(data) {
+		resultBuffers.CompareAndDelete(key, value)
+	}
+
+	return n, nil
+}
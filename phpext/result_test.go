@@ -0,0 +1,63 @@
+package phpext
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+
+	"github.com/rs/xid"
+)
+
+// Test reading a buffered result in chunks, and its release once read
+func TestReadResult(t *testing.T) {
+	ctx := context.Background()
+	id, _ := asynctask.ParseID(xid.New().String())
+	bufferResult(ctx, id, []byte("0123456789"))
+
+	dst := make([]byte, 4)
+	var got []byte
+	for offset := 0; offset < 10; {
+		n, err := readResult(ctx, id, offset, dst)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, dst[:n]...)
+		offset += n
+	}
+	assertEqual(t, string(got), "0123456789")
+
+	if _, err := readResult(ctx, id, 0, dst); !errors.Is(err, asynctask.ErrTaskNotFound) {
+		t.Fatalf("expected ErrTaskNotFound once read, got %v", err)
+	}
+}
+
+// Test that a buffered result is only readable by the request that awaited
+// it, and dropped once that request ends
+func TestReadResult_Request(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	id, _ := asynctask.ParseID(xid.New().String())
+	bufferResult(ctx, id, []byte("secret"))
+
+	dst := make([]byte, 16)
+	if _, err := readResult(context.Background(), id, 0, dst); !errors.Is(err, asynctask.ErrTaskNotFound) {
+		t.Fatalf("read the result of another request: %v", err)
+	}
+	if _, err := readResult(ctx, id, 7, dst); !errors.Is(err, errInvalidArgument) {
+		t.Fatalf("expected an invalid argument, got %v", err)
+	}
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := resultBuffers.Load(resultKey{request: ctx, taskID: id}); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("result kept after its request ended")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,273 @@
+/**
+ * FrankenAsync Shared Store
+ *
+ * Registers Frankenphp\Async\Store, a key-value store held in Go and shared
+ * between the request and the scripts it dispatches (request scope) or across
+ * all requests (global scope).
+ */
+
+#include <php.h>
+
+#include <ext/spl/spl_exceptions.h>
+
+#include <Zend/zend_exceptions.h>
+#include <Zend/zend_smart_str.h>
+
+#include "phpext.h"
+#include "encoding.h"
+#include "util.h"
+#include "phpext_cgo.h"
+
+#include "frankenphp.h"
+
+#define STORE_SCOPE_REQUEST 0
+#define STORE_SCOPE_GLOBAL  1
+
+static zend_class_entry *store_ce = NULL;
+static zend_object_handlers store_object_handlers;
+
+static const zend_function_entry store_methods[];
+
+static inline store_object *store_from_obj(zend_object *obj) {
+    return (store_object *)((char *)(obj) - XtOffsetOf(store_object, std));
+}
+
+static zend_object *store_create_object(zend_class_entry *ce)
+{
+    store_object *intern = ecalloc(1, sizeof(store_object) + zend_object_properties_size(ce));
+
+    zend_object_std_init(&intern->std, ce);
+    object_properties_init(&intern->std, ce);
+
+    intern->scope = STORE_SCOPE_REQUEST;
+    intern->std.handlers = &store_object_handlers;
+
+    return &intern->std;
+}
+
+int frankenasync_store_minit(void)
+{
+    zend_class_entry ce;
+
+    INIT_NS_CLASS_ENTRY(ce, "Frankenphp\\Async", "Store", store_methods);
+
+    store_ce = zend_register_internal_class(&ce);
+    if (!store_ce) {
+        return FAILURE;
+    }
+
+    store_ce->ce_flags |= ZEND_ACC_FINAL;
+    store_ce->create_object = store_create_object;
+
+    zend_declare_class_constant_string(store_ce, "SCOPE_REQUEST", sizeof("SCOPE_REQUEST") - 1, "request");
+    zend_declare_class_constant_string(store_ce, "SCOPE_GLOBAL", sizeof("SCOPE_GLOBAL") - 1, "global");
+
+    memcpy(&store_object_handlers, zend_get_std_object_handlers(), sizeof(zend_object_handlers));
+    store_object_handlers.offset = XtOffsetOf(store_object, std);
+    store_object_handlers.clone_obj = NULL;
+
+    return SUCCESS;
+}
+
+/* Encode a value for the store, throwing on failure */
+static int store_encode_value(smart_str *buf, zval *value)
+{
+    if (UNEXPECTED(frankenasync_encode_payload(buf, value) != SUCCESS)) {
+        smart_str_free(buf);
+        zend_throw_exception_ex(spl_ce_InvalidArgumentException, 0,
+            "Value cannot be stored: only scalars and arrays are supported");
+        return FAILURE;
+    }
+
+    return SUCCESS;
+}
+
+PHP_METHOD(Async_Store, __construct)
+{
+    zend_string *scope = NULL;
+
+    ZEND_PARSE_PARAMETERS_START(0, 1)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_STR(scope)
+    ZEND_PARSE_PARAMETERS_END();
+
+    store_object *intern = store_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    if (!scope || zend_string_equals_literal(scope, "request")) {
+        intern->scope = STORE_SCOPE_REQUEST;
+    } else if (zend_string_equals_literal(scope, "global")) {
+        intern->scope = STORE_SCOPE_GLOBAL;
+    } else {
+        zend_throw_exception_ex(spl_ce_InvalidArgumentException, 0,
+            "Unknown store scope '%s', expected 'request' or 'global'", ZSTR_VAL(scope));
+    }
+}
+
+PHP_METHOD(Async_Store, get)
+{
+    zend_string *key;
+    zval *default_value = NULL;
+
+    ZEND_PARSE_PARAMETERS_START(1, 2)
+        Z_PARAM_STR(key)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ZVAL(default_value)
+    ZEND_PARSE_PARAMETERS_END();
+
+    store_object *intern = store_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    struct go_kv_get_return result = go_kv_get(
+        frankenphp_thread_index(),
+        intern->scope,
+        ZSTR_VAL(key)
+    );
+
+    if (UNEXPECTED(!result.r2)) {
+        frankenasync_throw_bridge_error(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+
+    if (result.r0 == NULL) {
+        if (default_value) {
+            RETURN_COPY(default_value);
+        }
+        RETURN_NULL();
+    }
+
+    if (UNEXPECTED(frankenasync_decode_payload(return_value, result.r0, result.r1) != SUCCESS)) {
+        go_free_result(result.r0);
+        frankenasync_throw_error("Failed to decode stored value for '%s'", ZSTR_VAL(key));
+        RETURN_THROWS();
+    }
+
+    go_free_result(result.r0);
+}
+
+PHP_METHOD(Async_Store, set)
+{
+    zend_string *key;
+    zval *value;
+    zval *ttl_param = NULL;
+    smart_str buf = {0};
+
+    ZEND_PARSE_PARAMETERS_START(2, 3)
+        Z_PARAM_STR(key)
+        Z_PARAM_ZVAL(value)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ZVAL(ttl_param)
+    ZEND_PARSE_PARAMETERS_END();
+
+    PARSE_TIMEOUT_PARAM(ttl_param)
+
+    store_object *intern = store_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    if (store_encode_value(&buf, value) != SUCCESS) {
+        RETURN_THROWS();
+    }
+
+    struct go_kv_set_return result = go_kv_set(
+        frankenphp_thread_index(),
+        intern->scope,
+        ZSTR_VAL(key),
+        ZSTR_VAL(buf.s),
+        ZSTR_LEN(buf.s),
+        timeout_ms
+    );
+
+    smart_str_free(&buf);
+
+    if (UNEXPECTED(!result.r1)) {
+        frankenasync_throw_bridge_error(result.r0);
+        go_free_result(result.r0);
+        RETURN_THROWS();
+    }
+}
+
+PHP_METHOD(Async_Store, delete)
+{
+    zend_string *key;
+
+    ZEND_PARSE_PARAMETERS_START(1, 1)
+        Z_PARAM_STR(key)
+    ZEND_PARSE_PARAMETERS_END();
+
+    store_object *intern = store_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    struct go_kv_delete_return result = go_kv_delete(
+        frankenphp_thread_index(),
+        intern->scope,
+        ZSTR_VAL(key)
+    );
+
+    if (UNEXPECTED(!result.r2)) {
+        frankenasync_throw_bridge_error(result.r1);
+        go_free_result(result.r1);
+        RETURN_THROWS();
+    }
+
+    RETURN_BOOL(result.r0);
+}
+
+PHP_METHOD(Async_Store, cas)
+{
+    zend_string *key;
+    zval *expected;
+    zval *value;
+    zval *ttl_param = NULL;
+    smart_str expected_buf = {0};
+    smart_str value_buf = {0};
+
+    ZEND_PARSE_PARAMETERS_START(3, 4)
+        Z_PARAM_STR(key)
+        Z_PARAM_ZVAL(expected)
+        Z_PARAM_ZVAL(value)
+        Z_PARAM_OPTIONAL
+        Z_PARAM_ZVAL(ttl_param)
+    ZEND_PARSE_PARAMETERS_END();
+
+    PARSE_TIMEOUT_PARAM(ttl_param)
+
+    store_object *intern = store_from_obj(Z_OBJ_P(ZEND_THIS));
+
+    /* A null expected value means the key must not exist yet */
+    if (Z_TYPE_P(expected) != IS_NULL && store_encode_value(&expected_buf, expected) != SUCCESS) {
+        RETURN_THROWS();
+    }
+
+    if (store_encode_value(&value_buf, value) != SUCCESS) {
+        smart_str_free(&expected_buf);
+        RETURN_THROWS();
+    }
+
+    struct go_kv_cas_return result = go_kv_cas(
+        frankenphp_thread_index(),
+        intern->scope,
+        ZSTR_VAL(key),
+        expected_buf.s ? ZSTR_VAL(expected_buf.s) : NULL,
+        expected_buf.s ? ZSTR_LEN(expected_buf.s) : 0,
+        ZSTR_VAL(value_buf.s),
+        ZSTR_LEN(value_buf.s),
+        timeout_ms
+    );
+
+    smart_str_free(&expected_buf);
+    smart_str_free(&value_buf);
+
+    if (UNEXPECTED(!result.r2)) {
+        frankenasync_throw_bridge_error(result.r1);
+        go_free_result(result.r1);
+        RETURN_THROWS();
+    }
+
+    RETURN_BOOL(result.r0);
+}
+
+static const zend_function_entry store_methods[] = {
+    PHP_ME(Async_Store, __construct, arginfo_store___construct, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Store, get, arginfo_store_get, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Store, set, arginfo_store_set, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Store, delete, arginfo_store_delete, ZEND_ACC_PUBLIC)
+    PHP_ME(Async_Store, cas, arginfo_store_cas, ZEND_ACC_PUBLIC)
+    PHP_FE_END
+};
@@ -0,0 +1,141 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrClosed = errors.New("subscription closed")
+
+// DefaultBuffer is the number of undelivered messages a subscription holds
+// before further messages for it are dropped.
+const DefaultBuffer = 64
+
+type (
+	// Broker fans out messages published on a topic to every subscription on
+	// that topic. Publishing never blocks: slow subscribers drop messages
+	// once their buffer is full. All operations are thread-safe.
+	Broker struct {
+		mu     sync.RWMutex
+		topics map[string]map[*Subscription]struct{}
+	}
+
+	// Subscription receives messages for a single topic until it is closed.
+	Subscription struct {
+		topic    string
+		broker   *Broker
+		messages chan []byte
+		done     chan struct{}
+		once     sync.Once
+		dropped  atomic.Int64
+	}
+)
+
+// NewBroker creates a Broker without subscriptions.
+func NewBroker() *Broker {
+	return &Broker{topics: make(map[string]map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscription on topic. A buffer of zero or less uses
+// DefaultBuffer.
+func (b *Broker) Subscribe(topic string, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+
+	sub := &Subscription{
+		topic:    topic,
+		broker:   b,
+		messages: make(chan []byte, buffer),
+		done:     make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs, ok := b.topics[topic]
+	if !ok {
+		subs = make(map[*Subscription]struct{})
+		b.topics[topic] = subs
+	}
+	subs[sub] = struct{}{}
+
+	return sub
+}
+
+// Publish delivers payload to every subscription on topic and returns how
+// many subscriptions accepted it.
+func (b *Broker) Publish(topic string, payload []byte) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	delivered := 0
+	for sub := range b.topics[topic] {
+		select {
+		case sub.messages <- payload:
+			delivered++
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+	return delivered
+}
+
+// Subscribers returns the number of subscriptions on topic.
+func (b *Broker) Subscribers(topic string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.topics[topic])
+}
+
+func (b *Broker) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs := b.topics[sub.topic]
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.topics, sub.topic)
+	}
+}
+
+// Topic returns the topic the subscription listens on.
+func (s *Subscription) Topic() string {
+	return s.topic
+}
+
+// Dropped returns how many messages were dropped because the buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Receive blocks until a message arrives, the subscription is closed
+// (ErrClosed) or ctx is done. Messages already buffered when the subscription
+// is closed are discarded.
+func (s *Subscription) Receive(ctx context.Context) ([]byte, error) {
+	select {
+	case <-s.done:
+		return nil, ErrClosed
+	default:
+	}
+
+	select {
+	case msg := <-s.messages:
+		return msg, nil
+	case <-s.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close unsubscribes. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.broker.remove(s)
+		close(s.done)
+	})
+}
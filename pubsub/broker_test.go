@@ -0,0 +1,121 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// Test fan-out to every subscriber of a topic
+func TestBroker_Publish(t *testing.T) {
+	b := NewBroker()
+	ctx := context.Background()
+
+	a := b.Subscribe("progress", 0)
+	c := b.Subscribe("progress", 0)
+	other := b.Subscribe("other", 0)
+	defer other.Close()
+
+	assertEqual(t, b.Publish("progress", []byte("50%")), 2)
+
+	for _, sub := range []*Subscription{a, c} {
+		msg, err := sub.Receive(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertEqual(t, string(msg), "50%")
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := other.Receive(timeoutCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	a.Close()
+	c.Close()
+	assertEqual(t, b.Subscribers("progress"), 0)
+	assertEqual(t, b.Publish("progress", []byte("done")), 0)
+}
+
+// Test that a full buffer drops messages instead of blocking the publisher
+func TestBroker_SlowSubscriber(t *testing.T) {
+	b := NewBroker()
+
+	sub := b.Subscribe("topic", 2)
+	defer sub.Close()
+
+	assertEqual(t, b.Publish("topic", []byte("1")), 1)
+	assertEqual(t, b.Publish("topic", []byte("2")), 1)
+	assertEqual(t, b.Publish("topic", []byte("3")), 0)
+	assertEqual(t, sub.Dropped(), int64(1))
+}
+
+// Test that Receive unblocks when the subscription is closed
+func TestSubscription_Close(t *testing.T) {
+	b := NewBroker()
+	sub := b.Subscribe("topic", 0)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := sub.Receive(context.Background())
+		errCh <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	sub.Close()
+	sub.Close()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Receive did not return after Close")
+	}
+}
+
+// Test streaming a topic as Server-Sent Events
+func TestSSEHandler(t *testing.T) {
+	b := NewBroker()
+	server := httptest.NewServer(SSEHandler(b))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?topic=news")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	assertEqual(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	// Wait for the handler to subscribe before publishing
+	deadline := time.Now().Add(time.Second)
+	for b.Subscribers("news") == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	b.Publish("news", []byte("line1\nline2"))
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		lines = append(lines, strings.TrimRight(line, "\n"))
+	}
+	assertEqual(t, strings.Join(lines, "|"), "data: line1|data: line2|")
+}
@@ -0,0 +1,52 @@
+package pubsub
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SSEHandler streams the topic named by the "topic" query parameter as
+// Server-Sent Events until the client disconnects.
+func SSEHandler(b *Broker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		topic := r.URL.Query().Get("topic")
+		if topic == "" {
+			http.Error(w, "Missing topic", http.StatusBadRequest)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		// Streams outlive the server's write timeout
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		sub := b.Subscribe(topic, 0)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			msg, err := sub.Receive(r.Context())
+			if err != nil {
+				return
+			}
+
+			// Multi-line payloads become multiple data fields of one event
+			for _, line := range strings.Split(string(msg), "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+			flusher.Flush()
+		}
+	})
+}
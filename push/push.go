@@ -0,0 +1,184 @@
+// Package push notifies browsers over WebSocket when a task finishes, so a
+// page can kick off work and update itself without polling.
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/pubsub"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+const (
+	// writeTimeout bounds sending the notification to a slow client.
+	writeTimeout = 5 * time.Second
+
+	// DefaultPollInterval is how often the task is looked up again, in case
+	// its finish event was dropped by the broker.
+	DefaultPollInterval = 5 * time.Second
+
+	// DefaultMaxLifetime is how long a socket stays open waiting for its
+	// task, after which the client is asked to reconnect.
+	DefaultMaxLifetime = time.Hour
+)
+
+type (
+	// Option configures TaskHandler.
+	Option func(*config)
+
+	config struct {
+		pollInterval time.Duration
+		maxLifetime  time.Duration
+	}
+)
+
+// WithPollInterval sets how often the task is looked up again while waiting
+// for its finish event, DefaultPollInterval by default.
+func WithPollInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.pollInterval = d
+	}
+}
+
+// WithMaxLifetime sets how long a socket may wait for its task before it's
+// closed with StatusTryAgainLater, DefaultMaxLifetime by default.
+func WithMaxLifetime(d time.Duration) Option {
+	return func(c *config) {
+		c.maxLifetime = d
+	}
+}
+
+// Message is sent once, when the task finishes or when it can't be found,
+// after which the connection is closed. Results and errors are not sent;
+// the page fetches them through its own endpoints.
+type Message struct {
+	ID       string  `json:"id"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration_ms,omitempty"`
+}
+
+// TaskHandler serves a WebSocket for the task named by the "id" path value.
+// Tasks are looked up in reg, and finishes are observed through the task
+// events published on events by admin.Publisher. Events are shared by all
+// tasks and dropped for slow subscribers, so the task is also looked up
+// again whenever events were dropped and every poll interval. Only
+// same-origin pages may connect.
+func TaskHandler(reg *admin.Registry, events *pubsub.Broker, opts ...Option) http.Handler {
+	cfg := config{
+		pollInterval: DefaultPollInterval,
+		maxLifetime:  DefaultMaxLifetime,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := asynctask.ParseID(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid task ID", http.StatusBadRequest)
+			return
+		}
+
+		// The socket outlives the server's read and write timeouts
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+
+		// Subscribe before looking the task up so a finish in between is
+		// not missed
+		sub := events.Subscribe(admin.EventsTopic, 0)
+		defer sub.Close()
+
+		ctx := conn.CloseRead(r.Context())
+		if lookup(ctx, conn, reg, id) {
+			return
+		}
+
+		lifetime, cancel := context.WithTimeout(ctx, cfg.maxLifetime)
+		defer cancel()
+
+		var dropped int64
+		for {
+			poll, cancel := context.WithTimeout(lifetime, cfg.pollInterval)
+			msg, err := sub.Receive(poll)
+			cancel()
+
+			switch {
+			case lifetime.Err() != nil:
+				if ctx.Err() == nil {
+					conn.Close(websocket.StatusTryAgainLater, "lifetime exceeded")
+				}
+				return
+			case err != nil && poll.Err() == nil:
+				return
+			}
+
+			// Missed events may have included the finish
+			if n := sub.Dropped(); err != nil || n != dropped {
+				dropped = n
+				if lookup(ctx, conn, reg, id) {
+					return
+				}
+				continue
+			}
+
+			var ev admin.Event
+			if err := json.Unmarshal(msg, &ev); err != nil || ev.ID != id.String() || !finished(ev.Type) {
+				continue
+			}
+
+			notify(ctx, conn, Message{ID: ev.ID, Status: ev.Type, Duration: ev.Duration})
+			return
+		}
+	})
+}
+
+// lookup notifies the client when the task of id is unknown or finished and
+// reports whether it did.
+func lookup(ctx context.Context, conn *websocket.Conn, reg *admin.Registry, id asynctask.ID) bool {
+	future, err := reg.Future(id)
+	if err != nil {
+		notify(ctx, conn, Message{ID: id.String(), Status: asynctask.StatusUnknown.String()})
+		return true
+	}
+	if finished(future.Status) {
+		notify(ctx, conn, Message{ID: id.String(), Status: future.Status, Duration: ms(future.Duration)})
+		return true
+	}
+	return false
+}
+
+func notify(ctx context.Context, conn *websocket.Conn, msg Message) {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	if err := wsjson.Write(ctx, conn, msg); err != nil {
+		return
+	}
+	conn.Close(websocket.StatusNormalClosure, msg.Status)
+}
+
+func finished(status string) bool {
+	switch status {
+	case asynctask.StatusCompleted.String(), asynctask.StatusFailed.String(), asynctask.StatusCanceled.String():
+		return true
+	}
+	return false
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
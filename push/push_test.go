@@ -0,0 +1,170 @@
+package push
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/pubsub"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func newServer(t *testing.T) (*httptest.Server, *asynctask.Manager) {
+	t.Helper()
+
+	reg := admin.NewRegistry()
+	events := pubsub.NewBroker()
+
+	tm := asynctask.NewManager(asynctask.WithEventHandler(admin.Publisher(events)))
+	t.Cleanup(reg.Track(tm, http.MethodGet, "/"))
+	t.Cleanup(func() { tm.Shutdown(context.Background()) })
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws/tasks/{id}", TaskHandler(reg, events))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server, tm
+}
+
+func receive(t *testing.T, server *httptest.Server, id string) Message {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/tasks/" + id
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	var msg Message
+	if err := wsjson.Read(ctx, conn, &msg); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return msg
+}
+
+// Test notification once a running task finishes
+func TestTaskHandler_Running(t *testing.T) {
+	server, tm := newServer(t)
+
+	release := make(chan struct{})
+	id := tm.Async(context.Background(), asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return "done", nil
+	}))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+
+	msg := receive(t, server, id.String())
+	assertEqual(t, msg.ID, id.String())
+	assertEqual(t, msg.Status, "completed")
+}
+
+// Test immediate notification for finished and unknown tasks
+func TestTaskHandler_Finished(t *testing.T) {
+	server, tm := newServer(t)
+	ctx := context.Background()
+
+	id := tm.Async(ctx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		return nil, context.DeadlineExceeded
+	}))
+	_, _ = tm.Await(ctx, id)
+
+	assertEqual(t, receive(t, server, id.String()).Status, "failed")
+	assertEqual(t, receive(t, server, "d0q4kg7n9ccvr4mpkmvg").Status, "unknown")
+
+	resp, err := http.Get(server.URL + "/ws/tasks/invalid")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	assertEqual(t, resp.StatusCode, http.StatusBadRequest)
+}
+
+// Test that a finish missing from the events is noticed by polling
+func TestTaskHandler_Poll(t *testing.T) {
+	reg := admin.NewRegistry()
+
+	// The manager doesn't publish to the broker the handler subscribes to
+	tm := asynctask.NewManager()
+	t.Cleanup(reg.Track(tm, http.MethodGet, "/"))
+	t.Cleanup(func() { tm.Shutdown(context.Background()) })
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws/tasks/{id}", TaskHandler(reg, pubsub.NewBroker(), WithPollInterval(20*time.Millisecond)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	release := make(chan struct{})
+	id := tm.Async(context.Background(), asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return "done", nil
+	}))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+
+	msg := receive(t, server, id.String())
+	assertEqual(t, msg.ID, id.String())
+	assertEqual(t, msg.Status, "completed")
+}
+
+// Test that a socket waiting past its lifetime is closed
+func TestTaskHandler_MaxLifetime(t *testing.T) {
+	reg := admin.NewRegistry()
+	events := pubsub.NewBroker()
+
+	tm := asynctask.NewManager(asynctask.WithEventHandler(admin.Publisher(events)))
+	t.Cleanup(reg.Track(tm, http.MethodGet, "/"))
+	t.Cleanup(func() { tm.Shutdown(context.Background()) })
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws/tasks/{id}", TaskHandler(reg, events, WithMaxLifetime(50*time.Millisecond)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	release := make(chan struct{})
+	defer close(release)
+	id := tm.Async(context.Background(), asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+		<-release
+		return nil, nil
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/tasks/" + id.String()
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.CloseNow()
+
+	var msg Message
+	err = wsjson.Read(ctx, conn, &msg)
+	assertEqual(t, websocket.CloseStatus(err), websocket.StatusTryAgainLater)
+}
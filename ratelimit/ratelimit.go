@@ -0,0 +1,160 @@
+// Package ratelimit limits the rate of requests reaching a handler, over
+// all clients and per client IP, with token buckets. A spike of requests is
+// turned away with 429 Too Many Requests before it occupies PHP threads.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// sweepInterval is how often idle clients are dropped.
+const sweepInterval = time.Minute
+
+type (
+	// Limits are the requests per second a Limiter allows over all clients
+	// and per client IP, and the bursts it allows above those rates. A rate
+	// of 0 disables its limit; a burst of 0 defaults to its rate.
+	Limits struct {
+		Rate       int
+		Burst      int
+		PerIP      int
+		PerIPBurst int
+	}
+
+	// Limiter hands out the tokens of the buckets. It's safe for
+	// concurrent use.
+	Limiter struct {
+		mu      sync.Mutex
+		limits  Limits
+		global  *rate.Limiter
+		clients map[string]*client
+		swept   time.Time
+		now     func() time.Time
+	}
+
+	// client is the bucket of a client IP.
+	client struct {
+		limiter *rate.Limiter
+		seen    time.Time
+	}
+)
+
+// New returns a limiter enforcing limits.
+func New(limits Limits) *Limiter {
+	l := &Limiter{now: time.Now}
+	l.SetLimits(limits)
+	return l
+}
+
+// SetLimits replaces the limits, starting every bucket out full.
+func (l *Limiter) SetLimits(limits Limits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits.Burst = burst(limits.Rate, limits.Burst)
+	limits.PerIPBurst = burst(limits.PerIP, limits.PerIPBurst)
+	l.limits = limits
+
+	l.global = rate.NewLimiter(rate.Limit(limits.Rate), limits.Burst)
+	l.clients = make(map[string]*client)
+	l.swept = l.now()
+}
+
+// Limits returns the limits in effect, with bursts defaulted.
+func (l *Limiter) Limits() Limits {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limits
+}
+
+// Allow takes a token for a request of ip. When a bucket is empty it
+// returns false, and the time until it holds a token again.
+func (l *Limiter) Allow(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	var perIP *rate.Reservation
+	if l.limits.PerIP > 0 {
+		c, ok := l.clients[ip]
+		if !ok {
+			c = &client{limiter: rate.NewLimiter(rate.Limit(l.limits.PerIP), l.limits.PerIPBurst)}
+			l.clients[ip] = c
+		}
+		c.seen = now
+
+		perIP = c.limiter.ReserveN(now, 1)
+		if delay := perIP.DelayFrom(now); delay > 0 {
+			perIP.CancelAt(now)
+			return false, delay
+		}
+	}
+
+	if l.limits.Rate > 0 {
+		r := l.global.ReserveN(now, 1)
+		if delay := r.DelayFrom(now); delay > 0 {
+			// The request isn't served, so it doesn't count against its client
+			r.CancelAt(now)
+			if perIP != nil {
+				perIP.CancelAt(now)
+			}
+			return false, delay
+		}
+	}
+	return true, 0
+}
+
+// sweep drops the clients idle long enough for their bucket to be full
+// again, so one-off clients don't pile up.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval || l.limits.PerIP == 0 {
+		return
+	}
+	l.swept = now
+
+	refill := time.Duration(float64(l.limits.PerIPBurst) / float64(l.limits.PerIP) * float64(time.Second))
+	for ip, c := range l.clients {
+		if now.Sub(c.seen) >= max(refill, sweepInterval) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// Handler passes the requests the limiter allows to next, and responds to
+// the others with 429 Too Many Requests and a Retry-After header. Clients
+// are told apart by the IP address of the connection, so behind a proxy
+// the per-IP limit applies to the proxy.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, delay := l.Allow(clientIP(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func burst(rate, burst int) int {
+	if burst == 0 {
+		return rate
+	}
+	return burst
+}
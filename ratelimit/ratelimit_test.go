@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// newLimiter returns a limiter on a clock advanced by the returned func.
+func newLimiter(limits Limits) (*Limiter, func(time.Duration)) {
+	now := time.Unix(0, 0)
+	l := &Limiter{now: func() time.Time { return now }}
+	l.SetLimits(limits)
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+// Test the overall limit with its burst
+func TestLimiter_Rate(t *testing.T) {
+	l, advance := newLimiter(Limits{Rate: 2, Burst: 3})
+
+	for range 3 {
+		ok, _ := l.Allow("10.0.0.1")
+		assertEqual(t, ok, true)
+	}
+	ok, delay := l.Allow("10.0.0.2")
+	assertEqual(t, ok, false)
+	assertEqual(t, delay, 500*time.Millisecond)
+
+	advance(500 * time.Millisecond)
+	ok, _ = l.Allow("10.0.0.2")
+	assertEqual(t, ok, true)
+}
+
+// Test each client IP having its own bucket
+func TestLimiter_PerIP(t *testing.T) {
+	l, advance := newLimiter(Limits{PerIP: 1})
+	assertEqual(t, l.Limits().PerIPBurst, 1)
+
+	ok, _ := l.Allow("10.0.0.1")
+	assertEqual(t, ok, true)
+	ok, _ = l.Allow("10.0.0.1")
+	assertEqual(t, ok, false)
+	ok, _ = l.Allow("10.0.0.2")
+	assertEqual(t, ok, true)
+
+	// Requests turned away by the overall limit don't use up the client's tokens
+	l, _ = newLimiter(Limits{Rate: 1, PerIP: 1, PerIPBurst: 2})
+	ok, _ = l.Allow("10.0.0.1")
+	assertEqual(t, ok, true)
+	ok, _ = l.Allow("10.0.0.1")
+	assertEqual(t, ok, false)
+	assertEqual(t, l.clients["10.0.0.1"].limiter.TokensAt(l.now()), 1.0)
+
+	// Idle clients are dropped
+	l, advance = newLimiter(Limits{PerIP: 1})
+	l.Allow("10.0.0.1")
+	advance(sweepInterval)
+	l.Allow("10.0.0.2")
+	assertEqual(t, len(l.clients), 1)
+}
+
+// Test that reloaded limits apply right away
+func TestLimiter_SetLimits(t *testing.T) {
+	l, _ := newLimiter(Limits{})
+	for range 10 {
+		ok, _ := l.Allow("10.0.0.1")
+		assertEqual(t, ok, true)
+	}
+
+	l.SetLimits(Limits{Rate: 1})
+	ok, _ := l.Allow("10.0.0.1")
+	assertEqual(t, ok, true)
+	ok, _ = l.Allow("10.0.0.1")
+	assertEqual(t, ok, false)
+
+	l.SetLimits(Limits{})
+	ok, _ = l.Allow("10.0.0.1")
+	assertEqual(t, ok, true)
+}
+
+// Test requests over the limit getting 429 with Retry-After
+func TestLimiter_Handler(t *testing.T) {
+	l, _ := newLimiter(Limits{PerIP: 1})
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("next"))
+	}))
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("10.0.0.1:50000")
+	assertEqual(t, rec.Code, http.StatusOK)
+	assertEqual(t, rec.Body.String(), "next")
+
+	// Other ports of the same IP share its bucket
+	rec = serve("10.0.0.1:50001")
+	assertEqual(t, rec.Code, http.StatusTooManyRequests)
+	assertEqual(t, rec.Header().Get("Retry-After"), "1")
+
+	rec = serve("10.0.0.2:50000")
+	assertEqual(t, rec.Code, http.StatusOK)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+)
+
+// Headers summarizing a request's tasks, see diagnosticsWriter.
+const (
+	headerTasks = "X-FrankenAsync-Tasks"
+	headerWall  = "X-FrankenAsync-Wall"
+	headerSaved = "X-FrankenAsync-Saved"
+)
+
+// diagnosticsWriter adds headers summarizing the tasks of a request to its
+// response, as they stand when the headers are sent: how many tasks the
+// request started, its wall time so far, and the time its finished tasks
+// ran beyond the time spent awaiting them, which running them one after
+// another would have added. Times are in milliseconds.
+type diagnosticsWriter struct {
+	http.ResponseWriter
+	tasks *asynctask.Manager
+	start time.Time
+	sent  bool
+}
+
+func newDiagnosticsWriter(w http.ResponseWriter, tasks *asynctask.Manager) *diagnosticsWriter {
+	return &diagnosticsWriter{ResponseWriter: w, tasks: tasks, start: time.Now()}
+}
+
+func (w *diagnosticsWriter) WriteHeader(code int) {
+	w.addHeaders()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *diagnosticsWriter) Write(b []byte) (int, error) {
+	w.addHeaders()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *diagnosticsWriter) Flush() {
+	w.addHeaders()
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *diagnosticsWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *diagnosticsWriter) addHeaders() {
+	if w.sent {
+		return
+	}
+	w.sent = true
+
+	stats := w.tasks.Stats()
+	header := w.Header()
+	header.Set(headerTasks, strconv.Itoa(stats.Total))
+	header.Set(headerWall, formatMillis(time.Since(w.start)))
+	header.Set(headerSaved, formatMillis(max(stats.RunTotal-stats.AwaitTotal, 0)))
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64)
+}
+
+type taskSummaryKey struct{}
+
+// TaskSummary sums up the tasks of a PHP request, such as for an access
+// log line. Slowest names the script, or else the ID, of the task that
+// ran longest.
+type TaskSummary struct {
+	Tasks           int
+	Failed          int
+	Slowest         string
+	SlowestDuration time.Duration
+}
+
+// WithTaskSummary returns a derived context for a request whose task
+// summary the server fills in before it returns from serving the request.
+func WithTaskSummary(ctx context.Context, summary *TaskSummary) context.Context {
+	return context.WithValue(ctx, taskSummaryKey{}, summary)
+}
+
+// summarizeTasks fills in the task summary asked for by ctx, if any.
+func summarizeTasks(ctx context.Context, tasks *asynctask.Manager) {
+	summary, ok := ctx.Value(taskSummaryKey{}).(*TaskSummary)
+	if !ok {
+		return
+	}
+	for _, future := range tasks.List() {
+		summary.Tasks++
+		if future.Status == asynctask.StatusFailed.String() {
+			summary.Failed++
+		}
+		if future.Duration > summary.SlowestDuration {
+			summary.SlowestDuration = future.Duration
+			summary.Slowest = future.Labels["script"]
+			if summary.Slowest == "" {
+				summary.Slowest = future.ID.String()
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/grpcapi"
+	"github.com/johanjanssens/frankenasync/phpext"
+)
+
+// jobRetention is how long finished gRPC and queued tasks stay in their
+// managers, available to AwaitTask, when tasks.prune_ttl is unset.
+const jobRetention = 10 * time.Minute
+
+// TaskService returns the gRPC task API, running scripts and the runnables
+// registered with asynctask.RegisterRunnable in a task manager of its own.
+// Its tasks show up in the admin API and its events stream. Call it at most
+// once; Shutdown cancels the tasks still running.
+func (s *Server) TaskService(opts ...grpcapi.Option) *grpcapi.Service {
+	cfg := s.Config()
+
+	workers := s.Workers()
+	if cfg.GRPC.Workers > 0 {
+		workers = min(cfg.GRPC.Workers, s.maxThreads-2)
+	}
+
+	s.jobs = asynctask.NewManager(append([]asynctask.Option{
+		asynctask.WithWorkerLimit(workers),
+		asynctask.WithLogger(s.taskLogger()),
+		asynctask.WithLogCapacity(cfg.Tasks.LogCapacity),
+		asynctask.WithSlowTaskThreshold(cfg.Tasks.SlowTask),
+		asynctask.WithCodec(codec()),
+		asynctask.WithEventHandler(admin.Publisher(s.taskEvents)),
+	}, s.taskOptions()...)...)
+	s.untrackJobs = s.registry.Track(s.jobs, "GRPC", "/frankenasync.v1.Tasks")
+	s.clusterRun.Store(s.jobs)
+
+	go s.pruneJobs(s.ctx, s.jobs)
+
+	// Scripts started by a task can start tasks of their own
+	ctx := asynctask.WithContext(s.ctx, s.jobs)
+
+	opts = append([]grpcapi.Option{
+		grpcapi.WithToken(cfg.GRPC.Token),
+		grpcapi.WithEvents(s.taskEvents),
+		grpcapi.WithScripts(phpext.RunScript),
+	}, opts...)
+	return grpcapi.NewService(ctx, s.jobs, opts...)
+}
+
+// pruneJobs prunes the finished tasks of a server-wide manager, such as
+// that of the gRPC API, once they're jobRetention old.
+func (s *Server) pruneJobs(ctx context.Context, jobs *asynctask.Manager) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// tasks.prune_ttl, when set, is applied by pruneTasks
+			if s.Config().Tasks.PruneTTL == 0 {
+				jobs.Prune(jobRetention)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/config"
+	"github.com/johanjanssens/frankenasync/locks/redislock"
+	"github.com/johanjanssens/frankenasync/phpext"
+
+	"github.com/dunglas/frankenphp"
+	"github.com/redis/go-redis/v9"
+)
+
+// registerOnce hooks the extension into FrankenPHP, which keeps it across
+// Init and Shutdown.
+var registerOnce sync.Once
+
+// initPHP registers the extension and boots FrankenPHP as configured by cfg.
+// Callers must call frankenphp.Shutdown once done.
+func initPHP(cfg *config.Config, logger *slog.Logger) (numThreads, maxThreads int, err error) {
+	// Resolve document root
+	docRoot, err := filepath.Abs(cfg.DocumentRoot)
+	if err != nil {
+		return 0, 0, fmt.Errorf("resolve document root: %w", err)
+	}
+
+	// Register PHP extension
+	registerOnce.Do(phpext.Register)
+	phpext.DocumentRoot = docRoot
+
+	// Thread pool: starts with numThreads, autoscales up to maxThreads under load.
+	// The worker semaphore is capped at maxThreads-2 so subrequests flow to
+	// FrankenPHP and trigger autoscaling when threads are saturated.
+	numThreads = runtime.NumCPU() * 4
+	if cfg.Threads > 0 {
+		numThreads = cfg.Threads
+	}
+	maxThreads = numThreads * 4
+
+	// Subrequest nesting limit (0 disables)
+	phpext.MaxDepth = cfg.Tasks.MaxDepth
+
+	// Commands PHP may run with Frankenphp\Async\Command, the environment
+	// and directories it may give them, and their resource limits
+	phpext.ExecAllow = cfg.Exec.Allow
+	phpext.ExecEnvAllow = cfg.Exec.EnvAllow
+	phpext.ExecDirs = cfg.Exec.Dirs
+	phpext.ExecLimits = asynctask.CommandLimits{
+		CPU:       cfg.Exec.CPU,
+		Memory:    int64(cfg.Exec.Memory),
+		OpenFiles: cfg.Exec.OpenFiles,
+		FileSize:  int64(cfg.Exec.FileSize),
+	}
+
+	// Payload encoding across the CGO boundary (json, msgpack or php)
+	phpext.Encoding = cfg.Encoding
+
+	// Share locks across servers through Redis (redis://host:port/db)
+	if cfg.Locks.Redis != "" {
+		opts, err := redis.ParseURL(cfg.Locks.Redis)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid lock Redis URL: %w", err)
+		}
+		phpext.Locks = redislock.New(redis.NewClient(opts))
+	}
+
+	phpIni := map[string]string{
+		"opcache.enable":               "1",
+		"opcache.enable_file_override": "1",
+		"opcache.validate_timestamps":  "0",
+		"include_path":                 docRoot,
+		"swow.enable":                  "0",
+	}
+	// Scripts compiled into opcache once, shared by every request
+	if preload := cfg.Startup.Preload; preload != "" {
+		if !filepath.IsAbs(preload) {
+			preload = filepath.Join(docRoot, preload)
+		}
+		phpIni["opcache.preload"] = preload
+	}
+	maps.Copy(phpIni, cfg.PHPIni)
+
+	// Init FrankenPHP
+	initOptions := []frankenphp.Option{
+		frankenphp.WithNumThreads(numThreads),
+		frankenphp.WithMaxThreads(maxThreads),
+		frankenphp.WithLogger(logger),
+		frankenphp.WithPhpIni(phpIni),
+	}
+
+	if err := frankenphp.Init(initOptions...); err != nil {
+		return 0, 0, err
+	}
+
+	return numThreads, maxThreads, nil
+}
+
+// Threads summarizes the FrankenPHP thread pool.
+func (s *Server) Threads() admin.Threads {
+	state := frankenphp.DebugState()
+
+	threads := admin.Threads{
+		Total:    len(state.ThreadDebugStates),
+		Reserved: state.ReservedThreadCount,
+	}
+	for _, thread := range state.ThreadDebugStates {
+		if thread.IsBusy {
+			threads.Busy++
+		}
+	}
+	return threads
+}
+
+// codec returns the codec of the bridge encoding, which task managers
+// encode their results with too.
+func codec() asynctask.Codec {
+	codec, err := asynctask.LookupCodec(phpext.Encoding)
+	if err != nil {
+		return asynctask.JSONCodec{}
+	}
+	return codec
+}
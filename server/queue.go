@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/asynctask/pgstore"
+	"github.com/johanjanssens/frankenasync/logging"
+)
+
+// workQueue runs the tasks queued in the Postgres task store, workers at
+// once, in a task manager of its own until the server shuts down. Its
+// tasks show up in the admin API and its events stream. They aren't saved
+// to the store as tasks of their own: the queued task records their
+// outcome.
+func (s *Server) workQueue(pg *pgstore.Store, workers int) {
+	cfg := s.Config()
+	queue := asynctask.NewManager(append([]asynctask.Option{
+		asynctask.WithWorkerLimit(min(workers, s.maxThreads-2)),
+		asynctask.WithLogger(s.taskLogger()),
+		asynctask.WithLogCapacity(cfg.Tasks.LogCapacity),
+		asynctask.WithSlowTaskThreshold(cfg.Tasks.SlowTask),
+		asynctask.WithCodec(codec()),
+		asynctask.WithEventHandler(admin.Publisher(s.taskEvents)),
+	}, s.runOptions()...)...)
+	untrack := s.registry.Track(queue, "QUEUE", "/"+pgstore.QueuedLabel)
+
+	// Scripts started by a task can start tasks of their own
+	ctx := asynctask.WithContext(s.ctx, queue)
+
+	go s.pruneJobs(s.ctx, queue)
+
+	s.queueDone = make(chan struct{})
+	go func() {
+		defer close(s.queueDone)
+		defer untrack()
+		_ = pg.Work(ctx, queue, pgstore.WorkerConfig{
+			Concurrency: workers,
+			Logger:      s.component(logging.Manager),
+		})
+		queue.Shutdown(context.Background())
+	}()
+}
@@ -0,0 +1,570 @@
+// Package server is the FrankenAsync HTTP handler: PHP served by
+// FrankenPHP, with an asynctask.Manager per request, static files, the
+// health probes, pub/sub events and task notifications. The standalone
+// binary wraps it with listeners, signals and the admin API; other Go
+// programs can mount it on their own server:
+//
+//	srv, err := server.New(config.Default())
+//	if err != nil {
+//		return err
+//	}
+//	defer srv.Shutdown(context.Background())
+//	http.ListenAndServe(":8080", srv)
+//
+// FrankenPHP and the PHP extension are process-wide, so a process runs at
+// most one Server at a time.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/admin"
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/asynctask/pgstore"
+	"github.com/johanjanssens/frankenasync/asynctask/s3store"
+	"github.com/johanjanssens/frankenasync/asynctask/sqlitestore"
+	"github.com/johanjanssens/frankenasync/cluster"
+	"github.com/johanjanssens/frankenasync/config"
+	"github.com/johanjanssens/frankenasync/kvstore"
+	"github.com/johanjanssens/frankenasync/logging"
+	"github.com/johanjanssens/frankenasync/mockapi"
+	"github.com/johanjanssens/frankenasync/phpext"
+	"github.com/johanjanssens/frankenasync/pubsub"
+	"github.com/johanjanssens/frankenasync/push"
+	"github.com/johanjanssens/frankenasync/ratelimit"
+	"github.com/johanjanssens/frankenasync/static"
+
+	"github.com/dunglas/frankenphp"
+	"github.com/rs/xid"
+)
+
+type (
+	// Option configures a Server.
+	Option func(*Server)
+
+	// Server serves a FrankenAsync site. It's an http.Handler.
+	Server struct {
+		base       *slog.Logger // logger given with WithLogger
+		logger     *slog.Logger // the server component's
+		sampler    *logging.Sampler
+		handler    http.Handler
+		maxThreads int
+
+		// Tracks in-flight request task managers and their lifecycle events
+		// for the admin API. Events get their own broker so they never reach
+		// the public /events stream.
+		registry   *admin.Registry
+		taskEvents *pubsub.Broker
+
+		// Records the tasks of every manager as they finish, with tasks.history
+		history *asynctask.History
+
+		// Settings a reload can change. They apply to requests started after
+		// it; in-flight requests keep theirs.
+		current     atomic.Pointer[config.Config]
+		workerLimit atomic.Int64
+		limiter     *ratelimit.Limiter
+
+		// Runs the tasks of the gRPC API, once TaskService was called
+		jobs        *asynctask.Manager
+		untrackJobs func()
+
+		// Keeps the task results over tasks.offload.threshold, if set
+		results asynctask.ResultStore
+
+		// Persists every task with tasks.store.sqlite or postgres
+		store interface {
+			asynctask.TaskStore
+			Close() error
+		}
+		queueDone chan struct{} // closed once the queue workers stopped, if any
+
+		// Forwards tasks to peers with room once a pool is full, with
+		// cluster.peers. It offers the room of jobs, once the gRPC API runs.
+		cluster    *cluster.Node
+		clusterRun atomic.Pointer[asynctask.Manager]
+
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+)
+
+// WithLogger sets the logger of the server, PHP and tasks. Defaults to
+// slog.Default(). Each logs with a component attribute, so a handler from
+// logging.Levels can give them their own level.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// New boots FrankenPHP as configured by cfg, runs the startup script and
+// the warmup requests if any, and returns the server. Callers must call Shutdown once done, after
+// in-flight requests finished.
+func New(cfg *config.Config, opts ...Option) (*Server, error) {
+	s := &Server{
+		logger:     slog.Default(),
+		registry:   admin.NewRegistry(),
+		taskEvents: pubsub.NewBroker(),
+		limiter:    ratelimit.New(ratelimit.Limits{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.base = s.logger
+	s.logger = s.component(logging.Server)
+	s.sampler = logging.NewSampler(cfg.LogSampling)
+	if cfg.Tasks.History > 0 {
+		s.history = asynctask.NewHistory(cfg.Tasks.History)
+	}
+
+	// Seal what the store and offload keep, as scripts' tasks may carry
+	// personal data and auth headers
+	var keyring *asynctask.Keyring
+	var err error
+	if len(cfg.Tasks.EncryptionKeys) > 0 {
+		if keyring, err = asynctask.ParseKeyring(cfg.Tasks.EncryptionKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	if o := cfg.Tasks.Offload; o.Threshold > 0 {
+		s.results, err = s3store.New(s3store.Config{
+			Endpoint:  o.S3.Endpoint,
+			Region:    o.S3.Region,
+			Bucket:    o.S3.Bucket,
+			Prefix:    o.S3.Prefix,
+			AccessKey: o.S3.AccessKey,
+			SecretKey: o.S3.SecretKey,
+			PathStyle: o.S3.PathStyle,
+			Keyring:   keyring,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var pg *pgstore.Store
+	switch st := cfg.Tasks.Store; {
+	case st.SQLite != "":
+		// The binary must link a SQLite driver, see sqlitestore
+		if s.store, err = sqlitestore.Open(st.SQLite, sqlitestore.WithRetention(st.Retention), sqlitestore.WithKeyring(keyring)); err != nil {
+			return nil, err
+		}
+	case st.Postgres != "":
+		if pg, err = pgstore.Open(context.Background(), st.Postgres, pgstore.WithRetention(st.Retention), pgstore.WithKeyring(keyring)); err != nil {
+			return nil, err
+		}
+		s.store = pg
+	}
+
+	_, s.maxThreads, err = initPHP(cfg, s.component(logging.PHPExt))
+	if err != nil {
+		s.closeStore()
+		return nil, err
+	}
+	s.Reload(cfg)
+
+	s.handler, err = s.routes(cfg)
+	if err != nil {
+		frankenphp.Shutdown()
+		s.closeStore()
+		return nil, err
+	}
+
+	// Catch a broken deployment before it takes traffic
+	if cfg.Startup.Script != "" {
+		if err := s.runStartup(cfg.Startup); err != nil {
+			// A script past its timeout still holds a PHP thread, which
+			// Shutdown would wait for
+			if !errors.Is(err, context.DeadlineExceeded) {
+				frankenphp.Shutdown()
+				s.closeStore()
+			}
+			return nil, err
+		}
+	}
+
+	// Compile and cache the scripts of the warmup targets
+	if len(cfg.Startup.Warmup) > 0 {
+		s.warmup(cfg.Startup)
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	// Reclaim expired keys in the server-global store
+	go phpext.SharedStore.PruneEvery(s.ctx, time.Minute)
+
+	// Drop tasks of long-running requests once they finished tasks.prune_ttl ago
+	go s.pruneTasks(s.ctx)
+
+	if c := cfg.Cluster; len(c.Peers) > 0 {
+		peers := make([]cluster.Peer, 0, len(c.Peers))
+		for _, p := range c.Peers {
+			peers = append(peers, cluster.Peer{Name: p.Name, URL: p.URL, Addr: p.Addr})
+		}
+		s.cluster, err = cluster.New(cluster.Config{
+			Node:     c.Node,
+			Peers:    peers,
+			Token:    cfg.GRPC.Token,
+			Capacity: s.clusterCapacity,
+			Interval: c.Interval,
+			Logger:   s.logger,
+		})
+		if err != nil {
+			s.cancel()
+			frankenphp.Shutdown()
+			s.closeStore()
+			return nil, err
+		}
+		go s.cluster.Run(s.ctx)
+	}
+
+	if workers := cfg.Tasks.Store.QueueWorkers; pg != nil && workers > 0 {
+		s.workQueue(pg, workers)
+	}
+
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Shutdown stops FrankenPHP and the background jobs of the server, after
+// canceling the tasks of the gRPC API and releasing the queued tasks this
+// server was running.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
+	if s.jobs != nil {
+		s.untrackJobs()
+		if report, err := s.jobs.Shutdown(ctx); err != nil {
+			s.logger.Warn("Abandoning running gRPC tasks", "tasks", len(report.Abandoned), "error", err)
+		}
+	}
+	if s.queueDone != nil {
+		select {
+		case <-s.queueDone:
+		case <-ctx.Done():
+			s.logger.Warn("Abandoning running queued tasks", "error", ctx.Err())
+		}
+	}
+	if s.cluster != nil {
+		s.cluster.Close()
+	}
+	frankenphp.Shutdown()
+	s.closeStore()
+	phpext.ReportLeaks(s.component(logging.PHPExt)) // no-op unless built with -tags frankenasync_debug
+	return ctx.Err()
+}
+
+// closeStore closes the task store, once no task saves to it anymore.
+func (s *Server) closeStore() {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Close(); err != nil {
+		s.logger.Warn("Closing task store failed", "error", err)
+	}
+}
+
+// Reload applies the settings of cfg that don't need a restart: workers,
+// log sampling, rate limits and the tasks settings other than max_depth,
+// history, offload, store, encryption_keys and cluster. Config().RestartRequired(cfg)
+// names the changes it ignores.
+func (s *Server) Reload(cfg *config.Config) {
+	limit := s.maxThreads - 2
+	if cfg.Workers > 0 {
+		limit = cfg.Workers
+	}
+	if limit > s.maxThreads-2 {
+		s.logger.Warn("Capping worker limit to max thread pool size", "requested", limit, "capped", s.maxThreads-2)
+		limit = s.maxThreads - 2
+	}
+	s.workerLimit.Store(int64(limit))
+	s.sampler.SetRate(cfg.LogSampling)
+	if prev := s.current.Load(); prev == nil || prev.RateLimit != cfg.RateLimit {
+		s.limiter.SetLimits(ratelimit.Limits(cfg.RateLimit))
+	}
+	s.current.Store(cfg)
+}
+
+// component returns the logger of a component of the server.
+func (s *Server) component(name string) *slog.Logger {
+	return s.base.With(logging.ComponentKey, name)
+}
+
+// taskLogger returns the log handler of task managers, sampling their
+// debug records.
+func (s *Server) taskLogger() slog.Handler {
+	return s.sampler.Handler(s.component(logging.Manager).Handler())
+}
+
+// Config returns the configuration the server runs with.
+func (s *Server) Config() *config.Config {
+	return s.current.Load()
+}
+
+// Workers returns the concurrent subrequest limit of new requests.
+func (s *Server) Workers() int {
+	return int(s.workerLimit.Load())
+}
+
+// taskOptions returns the options of new managers the config turns on:
+// runOptions and the task store.
+func (s *Server) taskOptions() []asynctask.Option {
+	opts := s.runOptions()
+	if s.store != nil {
+		opts = append(opts, asynctask.WithTaskStore(s.store))
+	}
+	return opts
+}
+
+// runOptions returns the options of how managers run their tasks: the
+// named worker pools, each capped like the worker limit, task profiling,
+// stall detection, result offloading, the history and forwarding to the
+// cluster.
+func (s *Server) runOptions() []asynctask.Option {
+	var opts []asynctask.Option
+	if s.history != nil {
+		opts = append(opts, asynctask.WithHistory(s.history))
+	}
+	if s.cluster != nil {
+		opts = append(opts, asynctask.WithForwarding(s.cluster))
+	}
+	for name, size := range s.Config().Pools {
+		opts = append(opts, asynctask.WithPool(name, min(size, s.maxThreads-2)))
+	}
+	if s.Config().Tasks.Profiling {
+		opts = append(opts, asynctask.WithProfiling())
+	}
+	if stall := s.Config().Tasks.StallTimeout; stall > 0 {
+		opts = append(opts, asynctask.WithStallDetection(stall, s.Config().Tasks.StallRestarts))
+	}
+	if s.results != nil {
+		offload := s.Config().Tasks.Offload
+		opts = append(opts, asynctask.WithResultOffload(s.results, int64(offload.Threshold), offload.TTL))
+	}
+	return opts
+}
+
+// AdminHandler returns the admin API for the tasks of this server's
+// requests. It isn't mounted by ServeHTTP; serve it on its own listener.
+func (s *Server) AdminHandler(opts ...admin.Option) http.Handler {
+	opts = append([]admin.Option{
+		admin.WithThreads(s.Threads),
+		admin.WithEvents(s.taskEvents),
+	}, opts...)
+	if s.history != nil {
+		opts = append(opts, admin.WithHistory(s.history))
+	}
+	handler := admin.Handler(s.registry, opts...)
+	if s.cluster == nil {
+		return handler
+	}
+
+	// Peers gossip on the admin listener, with the shared gRPC token
+	mux := http.NewServeMux()
+	mux.Handle(cluster.GossipPath, s.cluster.Handler())
+	mux.Handle("/", handler)
+	return mux
+}
+
+// clusterCapacity returns the tasks peers may forward to this server: the
+// free slots of the gRPC API's manager, none before it runs.
+func (s *Server) clusterCapacity() int {
+	jobs := s.clusterRun.Load()
+	if jobs == nil {
+		return 0
+	}
+	stats := jobs.Stats()
+	return stats.WorkerLimit - stats.Workers - stats.Waiting - stats.Queued
+}
+
+// MetricsHandler returns the Prometheus metrics of this server's tasks and
+// PHP threads, see admin.MetricsHandler. Like AdminHandler, it's served on
+// its own listener.
+func (s *Server) MetricsHandler(opts ...admin.Option) http.Handler {
+	opts = append([]admin.Option{admin.WithThreads(s.Threads)}, opts...)
+	return admin.MetricsHandler(s.registry, opts...)
+}
+
+// LogState logs the state of every request's task manager.
+func (s *Server) LogState(ctx context.Context) {
+	s.registry.LogState(ctx, s.logger)
+}
+
+// routes builds the handler tree.
+func (s *Server) routes(cfg *config.Config) (http.Handler, error) {
+	mux := http.NewServeMux()
+
+	// Load balancer probes: process up, and PHP threads available
+	mux.Handle("GET /healthz", admin.HealthHandler())
+	mux.Handle("GET /readyz", admin.ReadyHandler(func() error {
+		threads := s.Threads()
+		if threads.Total == 0 {
+			return errors.New("FrankenPHP not initialized")
+		}
+		if threads.Busy >= threads.Total && threads.Reserved == 0 {
+			return errors.New("thread pool saturated")
+		}
+		return nil
+	}))
+
+	// Server-Sent Events for topics published from PHP via PubSub::publish()
+	mux.Handle("/events", pubsub.SSEHandler(phpext.Broker))
+
+	// WebSocket notifying the browser when a task started by its page finishes
+	mux.Handle("GET /ws/tasks/{id}", push.TaskHandler(s.registry, s.taskEvents))
+
+	// Rate limits keep a spike of requests from taking every PHP thread,
+	// leaving none for the subrequests of those already running
+	appHandler := s.limiter.Handler(http.HandlerFunc(s.servePHP))
+
+	// Simulated remote API for demos and load tests, e.g. the HTTP mode of
+	// examples/include/task.php
+	if cfg.MockAPI.Enabled {
+		var err error
+		appHandler, err = mockapi.Handler(appHandler, mockAPIOptions(cfg.MockAPI)...)
+		if err != nil {
+			return nil, fmt.Errorf("mock_api: %w", err)
+		}
+	}
+
+	// Assets under the document root are served from Go, everything else by PHP
+	siteHandler, err := static.Handler(phpext.DocumentRoot, appHandler, static.WithMaxAge(cfg.Static.MaxAge))
+	if err != nil {
+		return nil, fmt.Errorf("open document root: %w", err)
+	}
+	mux.Handle("/", siteHandler)
+
+	return mux, nil
+}
+
+// servePHP runs a PHP script with its own task manager.
+func (s *Server) servePHP(w http.ResponseWriter, r *http.Request) {
+	// Rewrite directory requests to index.php
+	if r.URL.Path == "/" || strings.HasSuffix(r.URL.Path, "/") {
+		r.URL.Path = r.URL.Path + "index.php"
+	}
+
+	// Generate the request's ID, and send it back so clients can correlate
+	// their logs with ours. A valid ID of the caller's is only kept as a
+	// label of its own, so callers can't pose as another request.
+	requestID := xid.New().String()
+	w.Header().Set("X-Request-ID", requestID)
+	labels := map[string]string{admin.RequestLabel: requestID}
+	reqLogger := s.logger.With("request_id", requestID)
+	if clientID := admin.ClientRequestID(r); clientID != "" {
+		labels[admin.ClientRequestLabel] = clientID
+		reqLogger = reqLogger.With("client_request_id", clientID)
+	}
+
+	// Validated with the config, so only an unset policy fails to parse
+	shutdownPolicy, _ := asynctask.ParseShutdownPolicy(s.Config().Tasks.Shutdown)
+
+	// Create async task manager for this request
+	taskManager := asynctask.NewManager(append([]asynctask.Option{
+		asynctask.WithWorkerLimit(s.Workers()),
+		asynctask.WithLogger(s.taskLogger()),
+		asynctask.WithLogCapacity(s.Config().Tasks.LogCapacity),
+		asynctask.WithMaxTasksPerRequest(s.Config().Tasks.MaxTasks),
+		asynctask.WithRequestBudget(s.Config().Tasks.Budget),
+		asynctask.WithAwaitBudget(s.Config().Tasks.AwaitBudget),
+		asynctask.WithMaxTaskMemory(int64(s.Config().Tasks.MaxMemory)),
+		asynctask.WithSlowTaskThreshold(s.Config().Tasks.SlowTask),
+		asynctask.WithShutdownPolicy(shutdownPolicy, s.Config().Tasks.ShutdownTimeout),
+		asynctask.WithCodec(codec()),
+		asynctask.WithRequestID(requestID),
+		asynctask.WithEventHandler(admin.Publisher(s.taskEvents)),
+	}, s.taskOptions()...)...)
+
+	// Tasks may outlive the request as the shutdown policy allows, and
+	// run for the disconnect grace once the client goes away. The manager
+	// is untracked once its finished tasks have been counted.
+	var untrack func()
+	baseCtx, closeManager := taskManager.Bind(r.Context(), s.Config().Tasks.DisconnectGrace, func() {
+		if untrack != nil {
+			untrack()
+		}
+	})
+
+	// Store manager and request-scoped key-value store in request context,
+	// labelling every task with the request that started it
+	reqCtx := asynctask.WithContext(baseCtx, taskManager)
+	reqCtx = asynctask.WithLabels(reqCtx, labels)
+	reqCtx = kvstore.WithContext(reqCtx, kvstore.New())
+	r = r.WithContext(reqCtx)
+
+	// Create FrankenPHP request
+	req, err := frankenphp.NewRequestWithContext(r,
+		frankenphp.WithRequestResolvedDocumentRoot(phpext.DocumentRoot),
+		frankenphp.WithRequestEnv(map[string]string{"FRANKENASYNC_REQUEST_ID": requestID}),
+		frankenphp.WithRequestLogger(reqLogger),
+	)
+	if err != nil {
+		reqLogger.Error("Failed to create FrankenPHP request", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		closeManager()
+		return
+	}
+
+	untrack = s.registry.Track(taskManager, r.Method, r.URL.Path)
+
+	if s.Config().Tasks.DiagnosticHeaders {
+		w = newDiagnosticsWriter(w, taskManager)
+	}
+
+	if err := frankenphp.ServeHTTP(w, req); err != nil {
+		reqLogger.Error("Failed to serve PHP", "error", err)
+	}
+
+	summarizeTasks(r.Context(), taskManager)
+
+	// Pages that fan out more than they can wait for show up in the logs
+	if awaited, over := taskManager.AwaitTime(); over {
+		reqLogger.Warn("Request ran over its await budget", "path", r.URL.Path, "awaited", awaited)
+	}
+
+	// Close the task manager after the request completes, as its shutdown
+	// policy says
+	closeManager()
+}
+
+func (s *Server) pruneTasks(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ttl := s.Config().Tasks.PruneTTL; ttl > 0 {
+				s.registry.Prune(ttl)
+			}
+		}
+	}
+}
+
+// mockAPIOptions maps the mock_api settings to handler options.
+func mockAPIOptions(cfg config.MockAPI) []mockapi.Option {
+	opts := []mockapi.Option{
+		mockapi.WithLatency(mockapi.Latency(cfg.Latency)),
+		mockapi.WithErrors(cfg.ErrorRate, cfg.ErrorStatus),
+	}
+	if len(cfg.Routes) > 0 {
+		routes := make([]mockapi.Route, len(cfg.Routes))
+		for i, route := range cfg.Routes {
+			routes[i] = mockapi.Route(route)
+		}
+		opts = append(opts, mockapi.WithRoutes(routes...))
+	}
+	return opts
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/johanjanssens/frankenasync/config"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// Test the server embedded as an http.Handler, serving PHP that starts
+// tasks, and the probes
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	for name, code := range map[string]string{
+		"index.php": `<?php
+use Frankenphp\Script;
+
+echo (new Script('fragment.php'))->async(['name' => 'world'])->await('5s')['body'], ' ', $_SERVER['FRANKENASYNC_REQUEST_ID'];
+`,
+		"fragment.php": `<?php echo 'hello ', $_SERVER['APP_NAME'];`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(code), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config.Default()
+	cfg.DocumentRoot = dir
+	cfg.Threads = 4
+	srv, err := New(cfg, WithLogger(slog.New(slog.DiscardHandler)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown(context.Background())
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/index.php", nil)
+	req.Header.Set("X-Request-ID", "client-1")
+	srv.ServeHTTP(rec, req)
+	assertEqual(t, rec.Code, http.StatusOK)
+
+	// The request gets an ID of the server's, whatever the client sent
+	requestID := rec.Header().Get("X-Request-ID")
+	if requestID == "" || requestID == "client-1" {
+		t.Fatalf("got request ID %q", requestID)
+	}
+	assertEqual(t, rec.Body.String(), "hello world "+requestID)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		rec = httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assertEqual(t, rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/johanjanssens/frankenasync/asynctask"
+	"github.com/johanjanssens/frankenasync/config"
+)
+
+// maxStartupOutput caps the output of a failing startup script quoted in
+// its error.
+const maxStartupOutput = 512
+
+// warmupLabel holds the target of warmup tasks.
+const warmupLabel = "warmup"
+
+// runStartup runs the startup script like a GET request for it, with its
+// own task manager, and fails when it doesn't respond with a 2xx or 3xx
+// status. PHP errors and uncaught exceptions respond with 500. A script
+// still running at the timeout is left behind.
+func (s *Server) runStartup(cfg config.Startup) error {
+	ctx, cancel := startupContext(cfg)
+	defer cancel()
+
+	start := time.Now()
+	rec, err := s.serveLocal(ctx, cfg.Script)
+	if err != nil {
+		return fmt.Errorf("startup script %s: %w", cfg.Script, err)
+	}
+	if rec.status >= http.StatusBadRequest {
+		output := strings.TrimSpace(rec.body.String())
+		if len(output) > maxStartupOutput {
+			output = output[:maxStartupOutput] + "..."
+		}
+		return fmt.Errorf("startup script %s: status %d: %s", cfg.Script, rec.status, output)
+	}
+
+	s.logger.Info("Startup script passed", "script", cfg.Script, "status", rec.status, "duration", time.Since(start))
+	return nil
+}
+
+// warmup requests the warmup targets as tasks of one manager, as many at a
+// time as the worker limit allows, so their scripts are compiled and their
+// caches filled before the server takes traffic. Failed requests are
+// logged, they don't stop the server.
+func (s *Server) warmup(cfg config.Startup) {
+	ctx, cancel := startupContext(cfg)
+	defer cancel()
+
+	tm := asynctask.NewManager(
+		asynctask.WithWorkerLimit(s.Workers()),
+		asynctask.WithLogger(s.taskLogger()),
+	)
+	defer tm.Shutdown(context.Background())
+
+	start := time.Now()
+	ids := make([]asynctask.ID, len(cfg.Warmup))
+	for i, target := range cfg.Warmup {
+		taskCtx := asynctask.WithLabels(ctx, map[string]string{warmupLabel: target})
+		ids[i] = tm.Async(taskCtx, asynctask.RunnableFunc(func(ctx context.Context) (any, error) {
+			rec, err := s.serveLocal(ctx, target)
+			if err != nil {
+				return nil, err
+			}
+			if rec.status >= http.StatusBadRequest {
+				return nil, fmt.Errorf("status %d", rec.status)
+			}
+			return rec.status, nil
+		}))
+	}
+
+	_, errs, err := tm.AwaitAllSettled(ctx, ids)
+	if err != nil {
+		s.logger.Warn("Warmup incomplete", "requests", len(ids), "duration", time.Since(start), "error", err)
+		return
+	}
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			s.logger.Warn("Warmup request failed", "target", cfg.Warmup[i], "error", err)
+			failed++
+		}
+	}
+	s.logger.Info("Warmup finished", "requests", len(ids), "failed", failed, "duration", time.Since(start))
+}
+
+// startupContext returns the context bounding the startup script, or the
+// warmup as a whole, by the startup timeout.
+func startupContext(cfg config.Startup) (context.Context, context.CancelFunc) {
+	if cfg.Timeout > 0 {
+		return context.WithTimeout(context.Background(), cfg.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// serveLocal serves a GET request for target, a path below the document
+// root with an optional query or a URL whose host is ignored, through PHP
+// without a client. It gives up when ctx is done, leaving the script
+// running.
+func (s *Server) serveLocal(ctx context.Context, target string) (*localResponse, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + strings.TrimPrefix(u.Path, "/")
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+u.RequestURI(), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.RemoteAddr = "127.0.0.1:0"
+
+	rec := &localResponse{header: make(http.Header), status: http.StatusOK}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.servePHP(rec, r)
+	}()
+
+	select {
+	case <-done:
+		return rec, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no response in time: %w", ctx.Err())
+	}
+}
+
+// localResponse records the response of a request served by serveLocal.
+type localResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (w *localResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *localResponse) WriteHeader(code int) {
+	if !w.wrote {
+		w.status = code
+		w.wrote = true
+	}
+}
+
+func (w *localResponse) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.body.Write(b)
+}
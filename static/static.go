@@ -0,0 +1,204 @@
+// Package static serves the non-PHP files of the document root directly from
+// Go, so assets don't occupy a PHP thread.
+package static
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Option configures the static file handler.
+	Option func(*handler)
+
+	handler struct {
+		root   *os.Root
+		maxAge time.Duration
+		next   http.Handler
+	}
+
+	// encoding is a precompressed variant looked up next to a file.
+	encoding struct {
+		name string // Content-Encoding token
+		ext  string
+	}
+)
+
+// encodings in order of preference.
+var encodings = []encoding{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// scriptExts are never served as files, so PHP source can't leak.
+var scriptExts = []string{".php", ".phtml", ".phar"}
+
+// WithMaxAge sets the max-age of the Cache-Control header. Without it,
+// clients revalidate every request using ETag and Last-Modified.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(h *handler) {
+		h.maxAge = maxAge
+	}
+}
+
+// Handler serves GET and HEAD requests for files below dir, and passes
+// every other request (PHP scripts, directories, missing files) to next.
+// Dotfiles and PHP scripts are never served. Range and conditional requests
+// are supported, and a file.br or file.gz next to a file is served in its
+// place when the client accepts that encoding.
+func Handler(dir string, next http.Handler, opts ...Option) (http.Handler, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &handler{root: root, next: next}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	name, ok := fileName(r.URL.Path)
+	if !ok {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	f, info, err := h.open(name)
+	if err != nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+
+	header := w.Header()
+	header.Set("Cache-Control", h.cacheControl())
+
+	// Content type follows the original file, not the compressed variant
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+
+	tag := etag(info, "")
+	if enc, cf, cinfo, ok := h.precompressed(r, name); ok {
+		defer cf.Close()
+		f, info = cf, cinfo
+		tag = etag(cinfo, enc.name)
+		header.Set("Content-Encoding", enc.name)
+
+		// Don't let ServeContent sniff the compressed bytes
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+	}
+	if ctype != "" {
+		header.Set("Content-Type", ctype)
+	}
+	header.Add("Vary", "Accept-Encoding")
+	header.Set("ETag", tag)
+
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func (h *handler) cacheControl() string {
+	if h.maxAge <= 0 {
+		return "no-cache"
+	}
+	return "public, max-age=" + strconv.Itoa(int(h.maxAge.Seconds()))
+}
+
+// open opens a regular file below the root.
+func (h *handler) open(name string) (*os.File, fs.FileInfo, error) {
+	f, err := h.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, errors.New("not a regular file")
+	}
+	return f, info, nil
+}
+
+// precompressed opens the preferred precompressed variant of name that the
+// client accepts.
+func (h *handler) precompressed(r *http.Request, name string) (encoding, *os.File, fs.FileInfo, bool) {
+	accepted := r.Header.Get("Accept-Encoding")
+	if accepted == "" {
+		return encoding{}, nil, nil, false
+	}
+
+	for _, enc := range encodings {
+		if !accepts(accepted, enc.name) {
+			continue
+		}
+		if f, info, err := h.open(name + enc.ext); err == nil {
+			return enc, f, info, true
+		}
+	}
+	return encoding{}, nil, nil, false
+}
+
+// fileName maps a URL path to a file name below the root, rejecting PHP
+// scripts, dotfiles and directories.
+func fileName(urlPath string) (string, bool) {
+	if urlPath == "" || strings.HasSuffix(urlPath, "/") {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+
+	if slices.Contains(scriptExts, strings.ToLower(path.Ext(name))) {
+		return "", false
+	}
+	return name, true
+}
+
+// accepts reports whether an Accept-Encoding header allows coding.
+func accepts(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		token, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(token), coding) {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+
+// etag derives a strong validator from the size and modification time,
+// distinct per content encoding.
+func etag(info fs.FileInfo, encoding string) string {
+	tag := fmt.Sprintf("%x-%x", info.Size(), info.ModTime().UnixNano())
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return `"` + tag + `"`
+}
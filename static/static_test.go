@@ -0,0 +1,123 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func newHandler(t *testing.T, opts ...Option) http.Handler {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.js":          "console.log('plain')",
+		"app.js.br":       "brotli",
+		"app.js.gz":       "gzip",
+		"css/site.css":    "body{}",
+		"index.php":       "<?php echo 'php';",
+		".env":            "SECRET=1",
+		".git/config":     "[core]",
+		"include/lib.php": "<?php",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("next"))
+	})
+	h, err := Handler(dir, next, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func serve(h http.Handler, method, target string, header map[string]string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// Test which requests are served as files
+func TestHandler(t *testing.T) {
+	h := newHandler(t)
+
+	rec := serve(h, http.MethodGet, "/css/site.css", nil)
+	assertEqual(t, rec.Code, http.StatusOK)
+	assertEqual(t, rec.Body.String(), "body{}")
+	assertEqual(t, rec.Header().Get("Content-Type"), "text/css; charset=utf-8")
+	assertEqual(t, rec.Header().Get("Cache-Control"), "no-cache")
+
+	// Everything else falls through to PHP
+	for _, target := range []string{"/", "/css/", "/css", "/index.php", "/INDEX.PHP", "/include/lib.php", "/.env", "/.git/config", "/missing.js", "/css/../.env"} {
+		assertEqual(t, serve(h, http.MethodGet, target, nil).Body.String(), "next")
+	}
+	assertEqual(t, serve(h, http.MethodPost, "/css/site.css", nil).Body.String(), "next")
+}
+
+// Test serving precompressed variants
+func TestHandler_Precompressed(t *testing.T) {
+	h := newHandler(t)
+
+	rec := serve(h, http.MethodGet, "/app.js", map[string]string{"Accept-Encoding": "gzip, br"})
+	assertEqual(t, rec.Body.String(), "brotli")
+	assertEqual(t, rec.Header().Get("Content-Encoding"), "br")
+	assertEqual(t, rec.Header().Get("Content-Type"), "text/javascript; charset=utf-8")
+	assertEqual(t, rec.Header().Get("Vary"), "Accept-Encoding")
+
+	rec = serve(h, http.MethodGet, "/app.js", map[string]string{"Accept-Encoding": "gzip, br;q=0"})
+	assertEqual(t, rec.Body.String(), "gzip")
+	assertEqual(t, rec.Header().Get("Content-Encoding"), "gzip")
+
+	rec = serve(h, http.MethodGet, "/app.js", nil)
+	assertEqual(t, rec.Body.String(), "console.log('plain')")
+	assertEqual(t, rec.Header().Get("Content-Encoding"), "")
+}
+
+// Test caching headers, conditional and range requests
+func TestHandler_Caching(t *testing.T) {
+	h := newHandler(t, WithMaxAge(time.Hour))
+
+	rec := serve(h, http.MethodGet, "/css/site.css", nil)
+	assertEqual(t, rec.Header().Get("Cache-Control"), "public, max-age=3600")
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Last-Modified") == "" {
+		t.Fatal("expected ETag and Last-Modified")
+	}
+
+	rec = serve(h, http.MethodGet, "/css/site.css", map[string]string{"If-None-Match": etag})
+	assertEqual(t, rec.Code, http.StatusNotModified)
+
+	// Variants have their own ETag
+	rec = serve(h, http.MethodGet, "/app.js", map[string]string{"Accept-Encoding": "br"})
+	brTag := rec.Header().Get("ETag")
+	rec = serve(h, http.MethodGet, "/app.js", nil)
+	if rec.Header().Get("ETag") == brTag {
+		t.Fatal("expected distinct ETags per encoding")
+	}
+
+	rec = serve(h, http.MethodGet, "/css/site.css", map[string]string{"Range": "bytes=0-3"})
+	assertEqual(t, rec.Code, http.StatusPartialContent)
+	assertEqual(t, rec.Body.String(), "body")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/johanjanssens/frankenasync/config"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsConfig returns the TLS configuration of the main listener, or nil when
+// TLS is disabled. ACME certificates are obtained through TLS-ALPN-01
+// challenges, so the listener must be reachable on port 443.
+func tlsConfig(cfg config.TLS) (*tls.Config, error) {
+	switch {
+	case cfg.Cert != "":
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	case len(cfg.Domains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+			Cache:      autocert.DirCache(cfg.CacheDir),
+		}
+		return manager.TLSConfig(), nil
+	}
+	return nil, nil
+}
+
+// listenerTLSConfig returns the TLS configuration of the admin listener or
+// a further listener, or nil when it serves plain HTTP. With a client CA,
+// clients must present a certificate signed by it.
+func listenerTLSConfig(certFile, keyFile, clientCA string) (*tls.Config, error) {
+	if certFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA %s: no certificates found", clientCA)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg, nil
+}
+
+// advertiseHTTP3 adds the Alt-Svc header to HTTP/1.1 and HTTP/2 responses,
+// so clients switch to h3 for subsequent requests.
+func advertiseHTTP3(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			_ = h3.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, r)
+	})
+}